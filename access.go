@@ -0,0 +1,48 @@
+package pvaccess
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+// AddAccessController adds an AccessController that limits the rights of clients to every channel,
+// in addition to any AccessController implemented by the channel itself.
+func (srv *Server) AddAccessController(ac AccessController) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.accessControllers = append(srv.accessControllers, ac)
+}
+
+// accessRights returns the rights of the client in ctx to channel, granted by every AccessController.
+// Without any AccessControllers, clients can read and write every channel.
+func (srv *Server) accessRights(ctx context.Context, channel Channel) AccessRights {
+	srv.mu.RLock()
+	controllers := append([]AccessController{}, srv.accessControllers...)
+	srv.mu.RUnlock()
+	if ac, ok := channel.(AccessController); ok {
+		controllers = append(controllers, ac)
+	}
+	rights := AccessReadWrite
+	for _, ac := range controllers {
+		rights &= ac.AccessRights(ctx, channel.Name())
+	}
+	return rights
+}
+
+// checkRead rejects Gets, Monitors, and RPCs of channel by a client with rights if they don't include reading.
+func checkRead(rights AccessRights, channel Channel) error {
+	if rights&AccessRead == 0 {
+		return pvdata.Error(fmt.Sprintf("no read access to channel %q", channel.Name()))
+	}
+	return nil
+}
+
+// checkWrite rejects puts to channel by a client with rights if they don't include writing.
+func checkWrite(rights AccessRights, channel Channel) error {
+	if rights&AccessWrite == 0 {
+		return pvdata.Error(fmt.Sprintf("no write access to channel %q", channel.Name()))
+	}
+	return nil
+}
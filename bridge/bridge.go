@@ -0,0 +1,356 @@
+// Package bridge publishes the monitor updates of pvAccess channels to message brokers, such as MQTT and Kafka,
+// for data pipelines.
+//
+// A Bridge subscribes to its PVs with a client.Client and publishes each update, encoded as JSON by default,
+// to the PV's topic. Updates are published in batches, and kept while the broker is unreachable until they
+// can be delivered; subscriptions to PVs that can't be found are retried in the background.
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/client"
+	"github.com/Lexcelon/go-pvaccess/clock"
+	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+// Message is a message for a broker.
+type Message struct {
+	Topic string
+	// Key is the name of the PV, which brokers that partition topics use to keep each PV's updates in order.
+	Key   []byte
+	Value []byte
+}
+
+// Publisher publishes messages to a broker. Publish is called by one goroutine at a time;
+// if it fails, the same messages are published again later, so brokers may receive them more than once.
+type Publisher interface {
+	Publish(ctx context.Context, msgs []Message) error
+}
+
+// Update is an event of a PV's monitor.
+type Update struct {
+	PV string `json:"pv"`
+	// Time is when the update was received.
+	Time time.Time `json:"time"`
+	// Connected is false when the monitor's connection was lost, in which case no Value is sent.
+	Connected bool `json:"connected"`
+	// Value is the PV's whole value, in the form of pvdata.ToMap.
+	Value map[string]interface{} `json:"value,omitempty"`
+	// Changed lists the fields of Value that changed since the last update, as returned by pvdata.ChangedFields.
+	// It is empty when the whole value was sent, as in the first update.
+	Changed []string `json:"changed,omitempty"`
+}
+
+// Encoder encodes an update as the value of a message. The update's Value is reused after the Encoder returns.
+// Other formats, such as Avro with a site's schema, are supported by setting Bridge.Encode.
+type Encoder func(u *Update) ([]byte, error)
+
+// EncodeJSON encodes updates as JSON objects.
+func EncodeJSON(u *Update) ([]byte, error) {
+	return json.Marshal(u)
+}
+
+// PV configures a PV to publish.
+type PV struct {
+	Name string
+	// Topic is the topic that the PV's updates are published to. It defaults to Name.
+	Topic string
+	// Request is the pvRequest of the PV's monitor; see client.Channel.Monitor.
+	Request interface{}
+}
+
+// Bridge publishes the monitor updates of PVs with a Publisher.
+type Bridge struct {
+	// Client subscribes to the PVs.
+	Client *client.Client
+	// Publisher publishes the updates.
+	Publisher Publisher
+	PVs       []PV
+	// Encode encodes updates. Nil selects EncodeJSON.
+	Encode Encoder
+	// BatchSize is the most updates that are published together. Zero selects a default of 100.
+	BatchSize int
+	// BatchDelay is how long updates wait for others to be published with. Zero selects a default of
+	// 100 milliseconds, and a negative delay publishes each update as soon as the Publisher is free.
+	BatchDelay time.Duration
+	// RetryDelay is the wait after the first failure to publish or subscribe, which doubles after each
+	// further failure up to MaxRetryDelay. Zero values select defaults of one second and 30 seconds.
+	RetryDelay, MaxRetryDelay time.Duration
+	// MaxPending is the most updates kept while they can't be published; the oldest are dropped to make room.
+	// Zero selects a default of 10000.
+	MaxPending int
+	// Clock measures the delays. Nil selects the system clock.
+	Clock clock.Clock
+
+	mu      sync.Mutex
+	pending []Message
+	// removed counts the messages ever removed from the front of pending, whether published or dropped.
+	removed  uint64
+	dropping bool
+	// wake is signalled when a message is queued.
+	wake chan struct{}
+}
+
+const (
+	defaultBatchSize     = 100
+	defaultBatchDelay    = 100 * time.Millisecond
+	defaultRetryDelay    = time.Second
+	defaultMaxRetryDelay = 30 * time.Second
+	defaultMaxPending    = 10000
+)
+
+// Run publishes updates until ctx is done, and then returns ctx's error. Updates that haven't been published
+// by then are discarded.
+func (b *Bridge) Run(ctx context.Context) error {
+	switch {
+	case b.Client == nil:
+		return errors.New("bridge has no client")
+	case b.Publisher == nil:
+		return errors.New("bridge has no publisher")
+	}
+	topics := make(map[string]bool)
+	for _, pv := range b.PVs {
+		if pv.Name == "" {
+			return errors.New("bridge PV has no name")
+		}
+		if topics[pv.Name] {
+			return fmt.Errorf("duplicate bridge PV %q", pv.Name)
+		}
+		topics[pv.Name] = true
+	}
+	b.mu.Lock()
+	b.wake = make(chan struct{}, 1)
+	b.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+	for _, pv := range b.PVs {
+		if pv.Topic == "" {
+			pv.Topic = pv.Name
+		}
+		wg.Add(1)
+		go func(pv PV) {
+			defer wg.Done()
+			b.subscribe(ctx, pv)
+		}(pv)
+	}
+	b.publish(ctx)
+	return ctx.Err()
+}
+
+// subscribe monitors pv until ctx is done, subscribing again after failures.
+func (b *Bridge) subscribe(ctx context.Context, pv PV) {
+	var failures int
+	for {
+		err := b.monitor(ctx, pv)
+		if ctx.Err() != nil {
+			return
+		}
+		ctxlog.L(ctx).Warnf("bridge: monitoring %q: %v", pv.Name, err)
+		failures++
+		if clock.Sleep(ctx, clock.Or(b.Clock), b.retryDelay(failures)) != nil {
+			return
+		}
+	}
+}
+
+// monitor queues the updates of a monitor on pv until it fails or ctx is done.
+func (b *Bridge) monitor(ctx context.Context, pv PV) error {
+	ch, err := b.Client.Channel(ctx, pv.Name)
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+	m, err := ch.Monitor(ctx, pv.Request)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	var value map[string]interface{}
+	for {
+		var e client.Event
+		var ok bool
+		select {
+		case e, ok = <-m.Events():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if !ok {
+			if err := m.Err(); err != nil {
+				return err
+			}
+			return errors.New("monitor closed")
+		}
+		u := Update{PV: pv.Name, Time: clock.Or(b.Clock).Now()}
+		switch e.Kind {
+		case client.Disconnected:
+			value = nil
+		case client.Update:
+			u.Connected = true
+			if e.Full || value == nil {
+				value = e.Value.ToMap()
+			} else {
+				u.Changed = merge(value, e.Value, e.Changed)
+			}
+			u.Value = value
+		default:
+			continue
+		}
+		if err := b.queue(ctx, pv, &u); err != nil {
+			return err
+		}
+	}
+}
+
+// merge copies the fields of v marked in changed into value, and returns their names.
+func merge(value map[string]interface{}, v pvdata.PVStructure, changed pvdata.PVBitSet) []string {
+	fd, err := v.FieldDesc()
+	if err != nil {
+		return nil
+	}
+	fields, _ := pvdata.ChangedFields(fd, changed)
+	src := v.ToMap()
+	for _, name := range fields {
+		path := strings.Split(name, ".")
+		s, d := src, value
+		for _, elem := range path[:len(path)-1] {
+			s, _ = s[elem].(map[string]interface{})
+			next, ok := d[elem].(map[string]interface{})
+			if !ok {
+				next = make(map[string]interface{})
+				d[elem] = next
+			}
+			d = next
+		}
+		d[path[len(path)-1]] = s[path[len(path)-1]]
+	}
+	return fields
+}
+
+// queue encodes u and queues it for publishing.
+func (b *Bridge) queue(ctx context.Context, pv PV, u *Update) error {
+	encode := b.Encode
+	if encode == nil {
+		encode = EncodeJSON
+	}
+	data, err := encode(u)
+	if err != nil {
+		return fmt.Errorf("encoding update: %w", err)
+	}
+	max := b.MaxPending
+	if max <= 0 {
+		max = defaultMaxPending
+	}
+	b.mu.Lock()
+	if len(b.pending) >= max {
+		b.removeLocked(1)
+		if !b.dropping {
+			ctxlog.L(ctx).Warnf("bridge: more than %d updates are waiting to be published; dropping the oldest", max)
+			b.dropping = true
+		}
+	}
+	b.pending = append(b.pending, Message{Topic: pv.Topic, Key: []byte(pv.Name), Value: data})
+	b.mu.Unlock()
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// publish publishes queued messages until ctx is done.
+func (b *Bridge) publish(ctx context.Context) {
+	size := b.BatchSize
+	if size <= 0 {
+		size = defaultBatchSize
+	}
+	delay := b.BatchDelay
+	if delay == 0 {
+		delay = defaultBatchDelay
+	}
+	clk := clock.Or(b.Clock)
+	var failures int
+	for {
+		b.mu.Lock()
+		n := len(b.pending)
+		b.mu.Unlock()
+		if n == 0 {
+			select {
+			case <-b.wake:
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		if n < size && delay > 0 && failures == 0 {
+			// Wait for more updates to fill the batch.
+			if clock.Sleep(ctx, clk, delay) != nil {
+				return
+			}
+		}
+		b.mu.Lock()
+		batch := b.pending
+		if len(batch) > size {
+			batch = batch[:size]
+		}
+		batch = append([]Message(nil), batch...)
+		start := b.removed
+		b.mu.Unlock()
+		if err := b.Publisher.Publish(ctx, batch); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			failures++
+			ctxlog.L(ctx).Warnf("bridge: publishing %d updates: %v", len(batch), err)
+			if clock.Sleep(ctx, clk, b.retryDelay(failures)) != nil {
+				return
+			}
+			continue
+		}
+		failures = 0
+		b.mu.Lock()
+		// Some of the batch may have been dropped while it was being published.
+		if end := start + uint64(len(batch)); end > b.removed {
+			b.removeLocked(int(end - b.removed))
+		}
+		b.dropping = false
+		b.mu.Unlock()
+	}
+}
+
+// removeLocked removes the first n pending messages. b.mu must be held.
+func (b *Bridge) removeLocked(n int) {
+	for i := 0; i < n; i++ {
+		b.pending[i] = Message{}
+	}
+	b.pending = b.pending[n:]
+	b.removed += uint64(n)
+}
+
+// retryDelay returns the wait after the given number of consecutive failures.
+func (b *Bridge) retryDelay(failures int) time.Duration {
+	delay, max := b.RetryDelay, b.MaxRetryDelay
+	if delay <= 0 {
+		delay = defaultRetryDelay
+	}
+	if max <= 0 {
+		max = defaultMaxRetryDelay
+	}
+	for i := 1; i < failures && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
@@ -0,0 +1,227 @@
+package bridge
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+	"github.com/Lexcelon/go-pvaccess/client"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/google/go-cmp/cmp"
+)
+
+// serve runs srv on a random port until the test ends.
+func serve(t *testing.T, srv *pvaccess.Server) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.Serve(ctx, ln)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return ln.Addr().String()
+}
+
+// publisher records published messages, failing while fail is set.
+type publisher struct {
+	mu   sync.Mutex
+	fail int
+	msgs chan Message
+}
+
+func (p *publisher) Publish(ctx context.Context, msgs []Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.fail > 0 {
+		p.fail--
+		return errors.New("broker unavailable")
+	}
+	for _, m := range msgs {
+		p.msgs <- m
+	}
+	return nil
+}
+
+func TestBridge(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ch := pvaccess.NewSimpleChannel("dev:x")
+	x := pvdata.PVInt(1)
+	ch.Set(&x)
+	srv := &pvaccess.Server{DisableSearch: true}
+	srv.AddChannelProvider(ch)
+	c := client.New(serve(t, srv))
+	defer c.Close()
+
+	p := &publisher{fail: 2, msgs: make(chan Message, 10)}
+	b := &Bridge{
+		Client:     c,
+		Publisher:  p,
+		PVs:        []PV{{Name: "dev:x", Topic: "pv/dev:x"}, {Name: "dev:missing"}},
+		BatchDelay: -1,
+		RetryDelay: 10 * time.Millisecond,
+	}
+	done := make(chan error)
+	go func() { done <- b.Run(ctx) }()
+
+	next := func() Update {
+		t.Helper()
+		select {
+		case m := <-p.msgs:
+			if m.Topic != "pv/dev:x" || string(m.Key) != "dev:x" {
+				t.Errorf("message has topic %q and key %q, want pv/dev:x and dev:x", m.Topic, m.Key)
+			}
+			var u Update
+			if err := json.Unmarshal(m.Value, &u); err != nil {
+				t.Fatalf("decoding %s: %v", m.Value, err)
+			}
+			return u
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for a message")
+		}
+		return Update{}
+	}
+	u := next()
+	if !u.Connected || u.PV != "dev:x" || u.Value["value"] != 1.0 || u.Changed != nil {
+		t.Errorf("first update = %+v, want the whole value 1", u)
+	}
+	x = 2
+	ch.Set(&x)
+	u = next()
+	if !u.Connected || u.Value["value"] != 2.0 {
+		t.Errorf("second update = %+v, want value 2", u)
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Run = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	c := client.New()
+	defer c.Close()
+	p := &publisher{}
+	for _, b := range []*Bridge{
+		{Publisher: p},
+		{Client: c},
+		{Client: c, Publisher: p, PVs: []PV{{Topic: "x"}}},
+		{Client: c, Publisher: p, PVs: []PV{{Name: "a"}, {Name: "a", Topic: "b"}}},
+	} {
+		if err := b.Run(context.Background()); err == nil {
+			t.Errorf("Run(%+v) succeeded", b)
+		}
+	}
+}
+
+func TestMQTT(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	type packet struct {
+		header byte
+		body   []byte
+	}
+	packets := make(chan packet, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			header, body, err := readMQTTPacket(r)
+			if err != nil {
+				close(packets)
+				return
+			}
+			packets <- packet{header, body}
+			switch header & 0xf0 {
+			case mqttConnect:
+				writeMQTTPacket(conn, mqttConnack, []byte{0, 0})
+			case mqttPublish:
+				// The packet ID follows the topic.
+				n := 2 + int(binary.BigEndian.Uint16(body))
+				writeMQTTPacket(conn, mqttPuback, body[n:n+2])
+			}
+		}
+	}()
+
+	p := &MQTT{Addr: ln.Addr().String(), ClientID: "bridge", Username: "user", Password: "secret", QoS: 1}
+	if err := p.Publish(context.Background(), []Message{{Topic: "a", Value: []byte("1")}, {Topic: "b", Value: []byte("2")}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var got []packet
+	for p := range packets {
+		got = append(got, p)
+	}
+	want := []packet{
+		{mqttConnect, []byte("\x00\x04MQTT\x04\xc2\x00\x00\x00\x06bridge\x00\x04user\x00\x06secret")},
+		{mqttPublish | 2, []byte("\x00\x01a\x00\x011")},
+		{mqttPublish | 2, []byte("\x00\x01b\x00\x022")},
+		{mqttDisconnect, []byte{}},
+	}
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(packet{})); diff != "" {
+		t.Errorf("broker received packets that differ (-want +got):\n%s", diff)
+	}
+}
+
+func TestKafkaREST(t *testing.T) {
+	var got []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/vnd.kafka.binary.v2+json" {
+			t.Errorf("request has content type %q", ct)
+		}
+		body, _ := io.ReadAll(r.Body)
+		got = append(got, r.URL.Path+" "+string(body))
+		if r.URL.Path == "/topics/bad" {
+			w.Write([]byte(`{"offsets":[{"partition":null,"offset":null,"error_code":40403,"error":"unknown topic"}]}`))
+			return
+		}
+		w.Write([]byte(`{"offsets":[{"partition":0,"offset":1}]}`))
+	}))
+	defer ts.Close()
+
+	p := &KafkaREST{URL: ts.URL}
+	if err := p.Publish(context.Background(), []Message{
+		{Topic: "a", Key: []byte("x"), Value: []byte("1")},
+		{Topic: "b", Value: []byte("2")},
+		{Topic: "a", Key: []byte("x"), Value: []byte("3")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`/topics/a {"records":[{"key":"eA==","value":"MQ=="},{"key":"eA==","value":"Mw=="}]}`,
+		`/topics/b {"records":[{"value":"Mg=="}]}`,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("requests differ (-want +got):\n%s", diff)
+	}
+	if err := p.Publish(context.Background(), []Message{{Topic: "bad", Value: []byte("1")}}); err == nil {
+		t.Error("producing to an unknown topic succeeded")
+	}
+}
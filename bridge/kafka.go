@@ -0,0 +1,104 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// KafkaREST is a Publisher that produces messages to Kafka topics through a Kafka REST Proxy, with its v2 API.
+// Keys and values are sent in the binary embedded format, so that they reach Kafka as they were encoded.
+type KafkaREST struct {
+	// URL is the base URL of the proxy, e.g. "http://kafka-rest:8082".
+	URL string
+	// HTTPClient sends the requests. Nil selects http.DefaultClient.
+	HTTPClient *http.Client
+	// Username and Password, if Username is set, authenticate requests with HTTP basic authentication.
+	Username, Password string
+}
+
+// kafkaRecords is the body of a request to produce messages to a topic.
+type kafkaRecords struct {
+	Records []kafkaRecord `json:"records"`
+}
+
+// kafkaRecord is a message in the binary embedded format, in which keys and values are base64,
+// as encoding/json encodes byte slices.
+type kafkaRecord struct {
+	Key   []byte `json:"key,omitempty"`
+	Value []byte `json:"value"`
+}
+
+// kafkaOffsets is the response to a request to produce messages, with the result of each.
+type kafkaOffsets struct {
+	Offsets []struct {
+		ErrorCode *int   `json:"error_code"`
+		Error     string `json:"error"`
+	} `json:"offsets"`
+}
+
+func (p *KafkaREST) Publish(ctx context.Context, msgs []Message) error {
+	// Each request produces to one topic. The messages for each topic keep their order.
+	var topics []string
+	byTopic := make(map[string]*kafkaRecords)
+	for _, m := range msgs {
+		r := byTopic[m.Topic]
+		if r == nil {
+			r = &kafkaRecords{}
+			byTopic[m.Topic] = r
+			topics = append(topics, m.Topic)
+		}
+		r.Records = append(r.Records, kafkaRecord{Key: m.Key, Value: m.Value})
+	}
+	for _, topic := range topics {
+		if err := p.produce(ctx, topic, byTopic[topic]); err != nil {
+			return fmt.Errorf("producing to Kafka topic %q: %w", topic, err)
+		}
+	}
+	return nil
+}
+
+// produce sends records to topic.
+func (p *KafkaREST) produce(ctx context.Context, topic string, records *kafkaRecords) error {
+	body, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(p.URL, "/")+"/topics/"+url.PathEscape(topic), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.binary.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	if p.Username != "" {
+		req.SetBasicAuth(p.Username, p.Password)
+	}
+	hc := p.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	var offsets kafkaOffsets
+	if err := json.NewDecoder(resp.Body).Decode(&offsets); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	for _, o := range offsets.Offsets {
+		if o.ErrorCode != nil {
+			return fmt.Errorf("error %d: %s", *o.ErrorCode, o.Error)
+		}
+	}
+	return nil
+}
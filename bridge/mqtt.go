@@ -0,0 +1,260 @@
+package bridge
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// MQTT is a Publisher that publishes messages to an MQTT 3.1.1 broker.
+// It connects when it first publishes, and again after any error.
+type MQTT struct {
+	// Addr is the host:port of the broker.
+	Addr string
+	// Dial connects to the broker, e.g. with TLS. Nil selects a net.Dialer.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+	// ClientID identifies the connection to the broker. Empty asks the broker to assign one.
+	ClientID string
+	// Username and Password, if Username is set, authenticate the connection.
+	Username, Password string
+	// QoS is the quality of service of the messages: 0 to publish them at most once, or 1 to wait for the broker
+	// to acknowledge each of them, so that messages published by a connection that fails are published again.
+	QoS byte
+	// Retain asks the broker to keep the last message of each topic for new subscribers.
+	Retain bool
+	// Timeout limits connecting and waiting for acknowledgements, unless the context's deadline is sooner.
+	// Zero selects a default of 10 seconds.
+	Timeout time.Duration
+
+	mu     sync.Mutex
+	conn   net.Conn
+	r      *bufio.Reader
+	nextID uint16
+}
+
+// MQTT control packet types, shifted into the high nibble of the fixed header.
+const (
+	mqttConnect    = 1 << 4
+	mqttConnack    = 2 << 4
+	mqttPublish    = 3 << 4
+	mqttPuback     = 4 << 4
+	mqttDisconnect = 14 << 4
+)
+
+const defaultMQTTTimeout = 10 * time.Second
+
+func (p *MQTT) Publish(ctx context.Context, msgs []Message) error {
+	if p.QoS > 1 {
+		return fmt.Errorf("unsupported MQTT QoS %d", p.QoS)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = defaultMQTTTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if p.conn == nil {
+		if err := p.connect(ctx, deadline); err != nil {
+			return fmt.Errorf("connecting to MQTT broker %s: %w", p.Addr, err)
+		}
+	}
+	if err := p.publish(msgs, deadline); err != nil {
+		p.conn.Close()
+		p.conn = nil
+		return fmt.Errorf("publishing to MQTT broker %s: %w", p.Addr, err)
+	}
+	return nil
+}
+
+// Close disconnects from the broker.
+func (p *MQTT) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil {
+		return nil
+	}
+	p.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	p.conn.Write([]byte{mqttDisconnect, 0})
+	err := p.conn.Close()
+	p.conn = nil
+	return err
+}
+
+// connect connects to the broker. p.mu must be held.
+func (p *MQTT) connect(ctx context.Context, deadline time.Time) error {
+	dial := p.Dial
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	dctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	conn, err := dial(dctx, "tcp", p.Addr)
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	writeMQTTString(&body, "MQTT")
+	// Protocol level 4 is MQTT 3.1.1. Sessions are clean, since messages are acknowledged before Publish returns.
+	flags := byte(0x02)
+	if p.Username != "" {
+		flags |= 0xc0
+	}
+	// Keep alive is disabled: failed connections are found by the next Publish.
+	body.Write([]byte{4, flags, 0, 0})
+	writeMQTTString(&body, p.ClientID)
+	if p.Username != "" {
+		writeMQTTString(&body, p.Username)
+		writeMQTTString(&body, p.Password)
+	}
+	conn.SetDeadline(deadline)
+	r := bufio.NewReader(conn)
+	err = writeMQTTPacket(conn, mqttConnect, body.Bytes())
+	var typ byte
+	var ack []byte
+	if err == nil {
+		typ, ack, err = readMQTTPacket(r)
+	}
+	switch {
+	case err != nil:
+	case typ&0xf0 != mqttConnack || len(ack) != 2:
+		err = fmt.Errorf("unexpected packet type %d in reply to CONNECT", typ>>4)
+	case ack[1] != 0:
+		err = fmt.Errorf("connection refused: %s", mqttConnackCode(ack[1]))
+	}
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	p.conn, p.r = conn, r
+	return nil
+}
+
+// mqttConnackCode describes the return code of a CONNACK packet.
+func mqttConnackCode(code byte) string {
+	switch code {
+	case 1:
+		return "unacceptable protocol version"
+	case 2:
+		return "identifier rejected"
+	case 3:
+		return "server unavailable"
+	case 4:
+		return "bad user name or password"
+	case 5:
+		return "not authorized"
+	}
+	return fmt.Sprintf("return code %d", code)
+}
+
+// publish sends msgs and, for QoS 1, waits for them to be acknowledged. p.mu must be held.
+func (p *MQTT) publish(msgs []Message, deadline time.Time) error {
+	p.conn.SetDeadline(deadline)
+	w := bufio.NewWriter(p.conn)
+	unacked := make(map[uint16]bool)
+	header := byte(mqttPublish) | p.QoS<<1
+	if p.Retain {
+		header |= 1
+	}
+	var body bytes.Buffer
+	for _, m := range msgs {
+		body.Reset()
+		writeMQTTString(&body, m.Topic)
+		if p.QoS > 0 {
+			p.nextID++
+			if p.nextID == 0 {
+				p.nextID++
+			}
+			binary.Write(&body, binary.BigEndian, p.nextID)
+			unacked[p.nextID] = true
+		}
+		body.Write(m.Value)
+		if err := writeMQTTPacket(w, header, body.Bytes()); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	for len(unacked) > 0 {
+		typ, data, err := readMQTTPacket(p.r)
+		if err != nil {
+			return err
+		}
+		if typ&0xf0 == mqttPuback && len(data) == 2 {
+			delete(unacked, binary.BigEndian.Uint16(data))
+		}
+	}
+	return nil
+}
+
+// writeMQTTString writes s as a length-prefixed UTF-8 string.
+func writeMQTTString(b *bytes.Buffer, s string) {
+	binary.Write(b, binary.BigEndian, uint16(len(s)))
+	b.WriteString(s)
+}
+
+// maxMQTTPacket is the largest remaining length that a packet may have.
+const maxMQTTPacket = 268435455
+
+// writeMQTTPacket writes a packet with the given first byte of its fixed header.
+func writeMQTTPacket(w io.Writer, header byte, body []byte) error {
+	if len(body) > maxMQTTPacket {
+		return fmt.Errorf("packet of %d bytes is too large", len(body))
+	}
+	b := []byte{header}
+	for n := len(body); ; {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if n == 0 {
+			break
+		}
+	}
+	if _, err := w.Write(b); err != nil {
+		return err
+	}
+	_, err := w.Write(body)
+	return err
+}
+
+// readMQTTPacket reads a packet, returning the first byte of its fixed header and its body.
+func readMQTTPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, mult := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return 0, nil, errors.New("malformed packet length")
+		}
+		digit, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n += int(digit&0x7f) * mult
+		mult *= 128
+		if digit&0x80 == 0 {
+			break
+		}
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
@@ -2,7 +2,9 @@ package pvaccess
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"reflect"
 	"sync"
 
 	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
@@ -14,68 +16,233 @@ import (
 type ChannelProvider = types.ChannelProvider
 type ChannelLister = types.ChannelLister
 type ChannelFinder = types.ChannelFinder
+type ChannelAliaser = types.ChannelAliaser
 type Channel = types.Channel
+type ChannelConnector = types.ChannelConnector
+type ChannelDisconnector = types.ChannelDisconnector
 type ChannelGetCreator = types.ChannelGetCreator
 type ChannelGeter = types.ChannelGeter
+type ChannelPutCreator = types.ChannelPutCreator
+type ChannelPuter = types.ChannelPuter
+type ChannelFieldPuter = types.ChannelFieldPuter
+type GroupPuter = types.GroupPuter
+type Reloadable = types.Reloadable
+type Validator = types.Validator
+type FieldValidator = types.FieldValidator
+type AccessRights = types.AccessRights
+type AccessController = types.AccessController
+type AccessControllerFunc = types.AccessControllerFunc
 type ChannelRPCCreator = types.ChannelRPCCreator
 type ChannelRPCer = types.ChannelRPCer
 type ChannelMonitorCreator = types.ChannelMonitorCreator
 type Nexter = types.Nexter
 
-func (conn *serverConn) createChannel(ctx context.Context, channelID pvdata.PVInt, name string) (Channel, error) {
+type RetryableError = types.RetryableError
+
+var (
+	ErrNotFound         = types.ErrNotFound
+	ErrPermissionDenied = types.ErrPermissionDenied
+)
+
+const (
+	AccessNone      = types.AccessNone
+	AccessRead      = types.AccessRead
+	AccessWrite     = types.AccessWrite
+	AccessReadWrite = types.AccessReadWrite
+)
+
+// createChannel creates the channel name as client channel clientID, and returns it along with its server channel ID.
+// It returns a nil Channel if no provider has the channel.
+func (conn *serverConn) createChannel(ctx context.Context, clientID pvdata.PVInt, name string) (Channel, pvdata.PVInt, error) {
 	conn.mu.Lock()
-	if _, ok := conn.channels[channelID]; ok {
+	if _, ok := conn.channelIDs[clientID]; ok {
 		conn.mu.Unlock()
-		return nil, fmt.Errorf("channel %d already created", channelID)
+		return nil, 0, fmt.Errorf("channel %d already created", clientID)
 	}
 	conn.mu.Unlock()
+	channel, err := conn.srv.findChannel(ctx, name)
+	if err != nil || channel == nil {
+		return nil, 0, err
+	}
+	rights := conn.srv.accessRights(ctx, channel)
+	conn.mu.Lock()
+	// Server channel IDs are allocated independently of the client's, and are not reused while in use.
+	for {
+		conn.lastChannelID++
+		if conn.lastChannelID > 0 && conn.channels[conn.lastChannelID] == nil {
+			break
+		}
+	}
+	sid := conn.lastChannelID
+	conn.channels[sid] = &serverChannel{
+		channel:  channel,
+		clientID: clientID,
+		queue:    conn.NewQueue(),
+		rights:   rights,
+	}
+	conn.channelIDs[clientID] = sid
+	conn.mu.Unlock()
+	conn.srv.channelOpened(ctx, channel)
+	return channel, sid, nil
+}
+
+// findChannel asks every channel provider to create the channel name, and returns the first one created.
+// Providers that implement ChannelAliaser create the canonical channel if name is one of their aliases.
+// It returns nil if no provider has the channel, or if no provider has it and one failed, that provider's error.
+func (srv *Server) findChannel(ctx context.Context, name string) (Channel, error) {
 	g, ctx := errgroup.WithContext(ctx)
+	var mu sync.Mutex
 	var channel Channel
-	conn.srv.mu.RLock()
-	for _, provider := range conn.srv.channelProviders {
+	var firstErr error
+	srv.mu.RLock()
+	for _, provider := range srv.channelProvidersLocked() {
 		provider := provider
 		g.Go(func() error {
-			c, err := provider.CreateChannel(ctx, name)
+			c, err := provider.CreateChannel(ctx, canonicalName(ctx, provider, name))
+			mu.Lock()
+			defer mu.Unlock()
+			if errors.Is(err, ErrNotFound) {
+				return nil
+			}
 			if err != nil {
-				ctxlog.L(ctx).Warnf("ChannelProvider %v failed to create channel %q: %v", provider, name, err)
+				if channel == nil && ctx.Err() == nil {
+					ctxlog.L(ctx).Warnf("ChannelProvider %v failed to create channel %q: %v", provider, name, err)
+					if firstErr == nil {
+						firstErr = err
+					}
+				}
 				return nil
 			}
-			if c != nil {
+			if c != nil && channel == nil {
 				channel = c
 				return context.Canceled
 			}
 			return nil
 		})
 	}
-	conn.srv.mu.RUnlock()
+	srv.mu.RUnlock()
 	if err := g.Wait(); err != nil && err != context.Canceled {
 		return nil, err
 	}
-	conn.mu.Lock()
-	conn.channels[channelID] = channel
-	conn.mu.Unlock()
+	if channel == nil {
+		return nil, firstErr
+	}
 	return channel, nil
 }
 
-func (c *serverConn) destroyChannel(id pvdata.PVInt) error {
+// canonicalName returns the name of the channel that name is an alias for on provider p, or name if it isn't one.
+func canonicalName(ctx context.Context, p ChannelProvider, name string) string {
+	aliaser, ok := p.(ChannelAliaser)
+	if !ok {
+		return name
+	}
+	aliases, err := aliaser.ChannelAliases(ctx)
+	if err != nil {
+		ctxlog.L(ctx).Warnf("ChannelProvider %v failed to list aliases: %v", p, err)
+		return name
+	}
+	if canonical, ok := aliases[name]; ok {
+		return canonical
+	}
+	return name
+}
+
+// destroyChannel destroys the channel with server channel ID sid, which the client knows as clientID.
+func (c *serverConn) destroyChannel(ctx context.Context, sid, clientID pvdata.PVInt) error {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-	// TODO: Cancel outstanding requests?
 	// TODO: Wait for outstanding requests to finish?
-	if _, ok := c.channels[id]; ok {
-		delete(c.channels, id)
-		return nil
+	sc, ok := c.channels[sid]
+	if !ok {
+		c.mu.Unlock()
+		return fmt.Errorf("unknown channel %d", sid)
+	}
+	if sc.clientID != clientID {
+		c.mu.Unlock()
+		return fmt.Errorf("channel %d is client channel %d, not %d", sid, sc.clientID, clientID)
+	}
+	terminate := c.destroyRequestsLocked(sid, false)
+	delete(c.channels, sid)
+	delete(c.channelIDs, clientID)
+	c.mu.Unlock()
+	terminate()
+	c.srv.channelClosed(ctx, sc.channel)
+	return nil
+}
+
+// destroyChannels destroys every channel on the connection, when it is closed.
+func (c *serverConn) destroyChannels(ctx context.Context) {
+	c.mu.Lock()
+	channels := c.channels
+	c.channels = make(map[pvdata.PVInt]*serverChannel)
+	c.channelIDs = make(map[pvdata.PVInt]pvdata.PVInt)
+	terminate := c.destroyRequestsLocked(0, true)
+	c.mu.Unlock()
+	terminate()
+	for _, sc := range channels {
+		c.srv.channelClosed(ctx, sc.channel)
+	}
+}
+
+// channelUsers counts the clients using each channel that implements ChannelConnector or ChannelDisconnector.
+type channelUsers struct {
+	// mu is held while calling ChannelConnected and ChannelDisconnected, so that calls for a channel are never reordered.
+	mu    sync.Mutex
+	count map[Channel]int
+}
+
+// channelOpened records that a client has created channel, and calls ChannelConnected if it is the first.
+func (srv *Server) channelOpened(ctx context.Context, channel Channel) {
+	ctx = srv.withValues(ctx)
+	connector, ok := channel.(ChannelConnector)
+	if _, ok2 := channel.(ChannelDisconnector); !ok && !ok2 {
+		return
+	}
+	u := &srv.channelUsers
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if reflect.TypeOf(channel).Comparable() {
+		if u.count == nil {
+			u.count = make(map[Channel]int)
+		}
+		u.count[channel]++
+		if u.count[channel] > 1 {
+			return
+		}
+	}
+	if ok {
+		connector.ChannelConnected(ctx)
+	}
+}
+
+// channelClosed records that a client has stopped using channel, and calls ChannelDisconnected if it was the last.
+func (srv *Server) channelClosed(ctx context.Context, channel Channel) {
+	ctx = srv.withValues(ctx)
+	disconnector, ok := channel.(ChannelDisconnector)
+	if _, ok2 := channel.(ChannelConnector); !ok && !ok2 {
+		return
+	}
+	u := &srv.channelUsers
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if reflect.TypeOf(channel).Comparable() {
+		if u.count[channel]--; u.count[channel] > 0 {
+			return
+		}
+		delete(u.count, channel)
+	}
+	if ok {
+		disconnector.ChannelDisconnected(ctx)
 	}
-	return fmt.Errorf("unknown channel %d", id)
 }
 
 type SimpleChannel struct {
 	name string
 
-	mu    sync.Mutex
-	value interface{}
-	seq   int
-	cond  *sync.Cond
+	mu      sync.Mutex
+	value   interface{}
+	seq     int
+	cond    *sync.Cond
+	aliases []string
 }
 
 func NewSimpleChannel(name string) *SimpleChannel {
@@ -117,6 +284,23 @@ func (c *SimpleChannel) ChannelList(ctx context.Context) ([]string, error) {
 	return []string{c.Name()}, nil
 }
 
+// AddAlias makes c reachable as alias too.
+func (c *SimpleChannel) AddAlias(alias string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.aliases = append(c.aliases, alias)
+}
+
+func (c *SimpleChannel) ChannelAliases(ctx context.Context) (map[string]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	aliases := make(map[string]string, len(c.aliases))
+	for _, alias := range c.aliases {
+		aliases[alias] = c.name
+	}
+	return aliases, nil
+}
+
 type watch struct {
 	c   *SimpleChannel
 	seq int
@@ -155,6 +339,26 @@ func (bareScalar) TypeID() string {
 	return "epics:nt/NTScalar:1.0"
 }
 
+// ChannelPut replaces the value in c with the "value" field of value.
+// If possible, the new value is converted to the type of the current value.
+func (c *SimpleChannel) ChannelPut(ctx context.Context, value pvdata.PVStructure) error {
+	field := value.Field("value")
+	if field == nil {
+		return pvdata.Error("missing value field")
+	}
+	var newValue interface{} = field
+	if old := reflect.ValueOf(c.Get()); old.Kind() == reflect.Ptr {
+		if nv := reflect.ValueOf(field); nv.Kind() == reflect.Ptr && nv.Elem().Type().ConvertibleTo(old.Type().Elem()) {
+			nv = nv.Elem()
+			v := reflect.New(old.Type().Elem())
+			v.Elem().Set(nv.Convert(old.Type().Elem()))
+			newValue = v.Interface()
+		}
+	}
+	c.Set(newValue)
+	return nil
+}
+
 func (c *SimpleChannel) ChannelGet(ctx context.Context) (interface{}, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -0,0 +1,133 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+// ArchiveQuery selects the archived samples of a PV, for RetrieveArchive.
+type ArchiveQuery struct {
+	PV string
+	// Start and End bound the samples' times. Zero values are left out of the query, for the service's defaults.
+	Start, End time.Time
+	// Params are added to the query, for the parameters that only some services accept, e.g. a "count".
+	Params map[string]string
+}
+
+// ArchiveSample is an archived sample of a PV.
+type ArchiveSample struct {
+	Time time.Time
+	// Value is the sample's element of the response's value column: a Go basic type, such as float64,
+	// or for columns of structures or unions, their value as returned by pvdata.ToMap.
+	Value interface{}
+	// Status and Severity are the sample's alarm, if the response has status and severity columns.
+	Status, Severity int
+}
+
+// Column names used by archiver retrieval services for the parts of each sample's time stamp.
+var (
+	archiveSecondsColumns     = []string{"secondsPastEpoch", "secPastEpoch", "seconds"}
+	archiveNanosecondsColumns = []string{"nanoseconds", "nsec", "nanos"}
+)
+
+// RetrieveArchive fetches the archived samples selected by q from the archiver retrieval RPC service
+// on the channel named service. Like RPC, it calls the service with an NTURI, whose query has the PV
+// as its "entity", and the times, in RFC 3339 format, as its "starttime" and "endtime".
+// The response is an NTTable or NTComplexTable, returned as described by ArchiveSamples.
+func (c *Client) RetrieveArchive(ctx context.Context, service string, q ArchiveQuery) ([]ArchiveSample, error) {
+	query := map[string]interface{}{"entity": q.PV}
+	if !q.Start.IsZero() {
+		query["starttime"] = q.Start.UTC().Format(time.RFC3339Nano)
+	}
+	if !q.End.IsZero() {
+		query["endtime"] = q.End.UTC().Format(time.RFC3339Nano)
+	}
+	for k, v := range q.Params {
+		query[k] = v
+	}
+	qs, err := pvdata.NewPVStructureFromMap("", query)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := c.Channel(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+	defer ch.Close()
+	resp, err := ch.RPC(ctx, &ntURI{Scheme: "pva", Path: service, Query: qs})
+	if err != nil {
+		return nil, err
+	}
+	samples, err := ArchiveSamples(resp)
+	if err != nil {
+		return nil, fmt.Errorf("archive of %q: %w", q.PV, err)
+	}
+	return samples, nil
+}
+
+// ArchiveSamples converts the NTTable or NTComplexTable response of an archiver retrieval service to samples.
+// Each row of the table is a sample. The value column holds the samples' values, and the time stamp columns,
+// e.g. secondsPastEpoch and nanoseconds, their times since the Unix epoch; status and severity columns are optional.
+func ArchiveSamples(v pvdata.PVStructure) ([]ArchiveSample, error) {
+	if !pvdata.TypeIDCompatible(v.ID, "epics:nt/NTTable:1.0") && !pvdata.TypeIDCompatible(v.ID, "epics:nt/NTComplexTable:1.0") {
+		return nil, fmt.Errorf("response has type %q, expected NTTable or NTComplexTable", v.ID)
+	}
+	columns, _ := v.ToMap()["value"].(map[string]interface{})
+	values, ok := archiveColumn(columns, "value")
+	if !ok {
+		return nil, fmt.Errorf("response has no value column")
+	}
+	seconds, ok := archiveColumn(columns, archiveSecondsColumns...)
+	if !ok {
+		return nil, fmt.Errorf("response has no %s column", archiveSecondsColumns[0])
+	}
+	nanoseconds, hasNanoseconds := archiveColumn(columns, archiveNanosecondsColumns...)
+	status, hasStatus := archiveColumn(columns, "status")
+	severity, hasSeverity := archiveColumn(columns, "severity")
+	n := values.Len()
+	for _, c := range []struct {
+		col reflect.Value
+		ok  bool
+	}{{seconds, true}, {nanoseconds, hasNanoseconds}, {status, hasStatus}, {severity, hasSeverity}} {
+		if c.ok && c.col.Len() != n {
+			return nil, fmt.Errorf("response columns have different lengths: %d and %d", n, c.col.Len())
+		}
+	}
+	samples := make([]ArchiveSample, n)
+	for i := range samples {
+		s := &samples[i]
+		s.Value = values.Index(i).Interface()
+		secs, ok := pvdata.Int64Value(seconds.Index(i).Interface())
+		if !ok {
+			return nil, fmt.Errorf("row %d: time stamp %v is not an integer", i, seconds.Index(i))
+		}
+		var nsecs int64
+		if hasNanoseconds {
+			nsecs, _ = pvdata.Int64Value(nanoseconds.Index(i).Interface())
+		}
+		s.Time = time.Unix(secs, nsecs)
+		if hasStatus {
+			s.Status, _ = pvdata.IntValue(status.Index(i).Interface())
+		}
+		if hasSeverity {
+			s.Severity, _ = pvdata.IntValue(severity.Index(i).Interface())
+		}
+	}
+	return samples, nil
+}
+
+// archiveColumn returns the first of the named columns that the table has.
+func archiveColumn(columns map[string]interface{}, names ...string) (reflect.Value, bool) {
+	for _, name := range names {
+		if c, ok := columns[name]; ok {
+			if v := reflect.ValueOf(c); v.Kind() == reflect.Slice {
+				return v, true
+			}
+		}
+	}
+	return reflect.Value{}, false
+}
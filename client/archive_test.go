@@ -0,0 +1,96 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/Lexcelon/go-pvaccess/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+// archiveService answers archiver retrieval RPCs with two samples of the PV "dev:x".
+type archiveService struct{}
+
+func (archiveService) Name() string { return "archiver" }
+
+func (s archiveService) CreateChannel(ctx context.Context, name string) (types.Channel, error) {
+	if name == s.Name() {
+		return s, nil
+	}
+	return nil, nil
+}
+
+type archiveTable struct {
+	Labels []string `pvaccess:"labels"`
+	Value  struct {
+		Value            []float64 `pvaccess:"value"`
+		SecondsPastEpoch []int64   `pvaccess:"secondsPastEpoch"`
+		Nanoseconds      []int32   `pvaccess:"nanoseconds"`
+		Severity         []int32   `pvaccess:"severity"`
+	} `pvaccess:"value"`
+}
+
+func (archiveTable) TypeID() string { return "epics:nt/NTTable:1.1" }
+
+func (archiveService) ChannelRPC(ctx context.Context, args pvdata.PVStructure) (interface{}, error) {
+	query := args.SubField("query")
+	if query == nil {
+		return nil, fmt.Errorf("missing query in %v", args)
+	}
+	m, _ := pvdata.ToMap(query)
+	want := map[string]interface{}{"entity": "dev:x", "starttime": "2020-01-02T03:04:05Z", "count": "2"}
+	if diff := cmp.Diff(want, m); diff != "" {
+		return nil, fmt.Errorf("query differs (-want +got):\n%s", diff)
+	}
+	t := &archiveTable{Labels: []string{"value", "seconds", "nanoseconds", "severity"}}
+	t.Value.Value = []float64{1.5, 2.5}
+	t.Value.SecondsPastEpoch = []int64{1577934245, 1577934246}
+	t.Value.Nanoseconds = []int32{0, 500000000}
+	t.Value.Severity = []int32{0, 2}
+	return t, nil
+}
+
+func TestRetrieveArchive(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv := &pvaccess.Server{DisableSearch: true}
+	srv.AddChannelProvider(archiveService{})
+	addr, _ := serve(t, srv, "127.0.0.1:0")
+	c := New(addr)
+	defer c.Close()
+
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	got, err := c.RetrieveArchive(ctx, "archiver", ArchiveQuery{PV: "dev:x", Start: start, Params: map[string]string{"count": "2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ArchiveSample{
+		{Time: start, Value: 1.5},
+		{Time: start.Add(1500 * time.Millisecond), Value: 2.5, Severity: 2},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("samples differ (-want +got):\n%s", diff)
+	}
+	if _, err := c.RetrieveArchive(ctx, "archiver", ArchiveQuery{PV: "dev:y"}); err == nil {
+		t.Error("retrieving a PV the service rejects succeeded")
+	}
+}
+
+func TestArchiveSamplesErrors(t *testing.T) {
+	short := &archiveTable{}
+	short.Value.Value = []float64{1, 2}
+	short.Value.SecondsPastEpoch = []int64{1}
+	for _, v := range []interface{}{&rpcScalar{1}, &rpcTable{}, short} {
+		s, err := pvdata.NewPVStructure(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ArchiveSamples(s); err == nil {
+			t.Errorf("ArchiveSamples(%v) succeeded", s)
+		}
+	}
+}
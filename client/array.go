@@ -0,0 +1,169 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+// arrayChunkLen is the most elements that GetArray passes to its function at once.
+const arrayChunkLen = 4096
+
+// GetArray reads the current value of the channel like Get, but passes the elements of the array field at path,
+// such as "value" or "image.data", to fn in chunks as they are received instead of collecting them, so that
+// consumers of huge arrays, such as detector images, don't have to hold a whole array in memory. When the server
+// sends the value in segmented messages, each segment is decoded as soon as it arrives, so fn also reports
+// the progress of the transfer.
+//
+// fn is called with consecutive chunks of elements, as a slice of the elements' Go type such as []float64,
+// which is reused once fn returns, with the index of the chunk's first element and the array's length.
+// It runs in the connection's read loop, which reads nothing else while it runs: a slow fn slows the server,
+// rather than data piling up in the client. An error from fn is returned by GetArray, and fn isn't called once
+// GetArray has returned.
+//
+// The returned value holds the channel's other fields; ToMap omits the array field. The array of a local channel
+// is already in memory, and is passed to fn in chunks of the value that is returned.
+func (ch *Channel) GetArray(ctx context.Context, path string, fn func(chunk interface{}, offset, length int) error) (pvdata.PVStructure, error) {
+	if lc := ch.localChannel(); lc != nil {
+		value, err := lc.Get(ctx, emptyRequest().Data.(pvdata.PVStructure))
+		if err != nil {
+			return value, err
+		}
+		return value, chunkArray(value, path, fn)
+	}
+	var mu sync.Mutex
+	var returned bool
+	defer func() {
+		mu.Lock()
+		returned = true
+		mu.Unlock()
+	}()
+	sink := &pvdata.ArraySink{ChunkLen: arrayChunkLen, Chunk: func(chunk interface{}, offset, length int) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if returned {
+			return context.Canceled
+		}
+		return fn(chunk, offset, length)
+	}}
+	return ch.get(ctx, func(fd pvdata.FieldDesc) (pvdata.PVStructure, error) {
+		return fd.ZeroWithSinks(map[string]*pvdata.ArraySink{path: sink})
+	}, true)
+}
+
+// chunkArray passes the array field at path in value to fn in chunks, as GetArray does.
+func chunkArray(value pvdata.PVStructure, path string, fn func(chunk interface{}, offset, length int) error) error {
+	var field interface{} = value.ToMap()
+	for _, name := range strings.Split(path, ".") {
+		m, ok := field.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("channel has no field %s", path)
+		}
+		field = m[name]
+	}
+	v := reflect.ValueOf(field)
+	if v.Kind() != reflect.Slice {
+		return fmt.Errorf("field %s is %T, not an array", path, field)
+	}
+	for offset := 0; offset < v.Len(); offset += arrayChunkLen {
+		end := offset + arrayChunkLen
+		if end > v.Len() {
+			end = v.Len()
+		}
+		if err := fn(v.Slice(offset, end).Interface(), offset, v.Len()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ArrayReader reads the elements of an array field in chunks as they are received, like GetArray,
+// for consumers that would rather ask for each chunk than be called with it.
+// While the reader holds a chunk that hasn't been consumed, nothing else is read from the channel's connection.
+type ArrayReader struct {
+	cancel   context.CancelFunc
+	chunks   chan arrayChunk
+	consumed chan struct{}
+	// pending is set while the caller holds a chunk returned by Next.
+	pending bool
+	chunk   arrayChunk
+	// value and err are the results of GetArray, written before chunks is closed.
+	value pvdata.PVStructure
+	err   error
+}
+
+type arrayChunk struct {
+	elements       interface{}
+	offset, length int
+}
+
+// ReadArray starts reading the array field at path of the channel's current value, as described by GetArray.
+// The reader must be closed once it is no longer needed.
+func (ch *Channel) ReadArray(ctx context.Context, path string) *ArrayReader {
+	ctx, cancel := context.WithCancel(ctx)
+	r := &ArrayReader{
+		cancel:   cancel,
+		chunks:   make(chan arrayChunk),
+		consumed: make(chan struct{}),
+	}
+	go func() {
+		defer close(r.chunks)
+		r.value, r.err = ch.GetArray(ctx, path, func(chunk interface{}, offset, length int) error {
+			select {
+			case r.chunks <- arrayChunk{chunk, offset, length}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			// The chunk is reused once this returns.
+			select {
+			case <-r.consumed:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+	return r
+}
+
+// Next returns the next chunk of elements, as a slice of their Go type such as []float64, which is only valid until
+// the next call to Next or Close. Once every chunk has been read, it returns io.EOF, or the error that ended the read.
+func (r *ArrayReader) Next() (interface{}, error) {
+	if r.pending {
+		r.pending = false
+		r.consumed <- struct{}{}
+	}
+	c, ok := <-r.chunks
+	if !ok {
+		if r.err != nil {
+			return nil, r.err
+		}
+		return nil, io.EOF
+	}
+	r.chunk, r.pending = c, true
+	return c.elements, nil
+}
+
+// Offset returns the index in the array of the first element of the last chunk returned by Next, and Len the
+// array's length, which is known once Next has returned the first chunk.
+func (r *ArrayReader) Offset() int { return r.chunk.offset }
+
+func (r *ArrayReader) Len() int { return r.chunk.length }
+
+// Value returns the channel's other fields once Next has returned io.EOF, as returned by GetArray.
+func (r *ArrayReader) Value() pvdata.PVStructure {
+	return r.value
+}
+
+// Close stops reading the array. It is safe to call more than once.
+func (r *ArrayReader) Close() error {
+	r.cancel()
+	for range r.chunks {
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultBreakerThreshold = 3
+	defaultBreakerBackoff   = 10 * time.Second
+)
+
+// ErrServerUnhealthy is returned when connecting to a server that has failed too many times in a row,
+// until its backoff period has passed.
+var ErrServerUnhealthy = errors.New("client: server is unhealthy")
+
+// serverHealth tracks the failed connection attempts to one server.
+type serverHealth struct {
+	// failures is the number of consecutive failed attempts.
+	failures int
+	// until is when the server may be tried again, once it has been marked unhealthy.
+	until time.Time
+}
+
+func (c *Client) breakerSettings() (threshold int, backoff time.Duration) {
+	threshold, backoff = c.BreakerThreshold, c.BreakerBackoff
+	if threshold == 0 {
+		threshold = defaultBreakerThreshold
+	}
+	if backoff <= 0 {
+		backoff = defaultBreakerBackoff
+	}
+	return threshold, backoff
+}
+
+// checkHealthy returns ErrServerUnhealthy, wrapped with how long the server will remain unhealthy,
+// if the server at addr is unhealthy.
+func (c *Client) checkHealthy(addr string, now time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h := c.health[addr]
+	if h == nil || !now.Before(h.until) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s failed %d times; retrying in %v", ErrServerUnhealthy, addr, h.failures, h.until.Sub(now).Round(time.Millisecond))
+}
+
+// healthy reports whether the server at addr may be connected to.
+func (c *Client) healthy(addr string) bool {
+	return c.checkHealthy(addr, c.clock().Now()) == nil
+}
+
+// connectFailed records a failed attempt to connect to addr, and marks the server unhealthy
+// once it has failed BreakerThreshold times in a row.
+func (c *Client) connectFailed(addr string, now time.Time) {
+	threshold, backoff := c.breakerSettings()
+	if threshold < 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.health == nil {
+		c.health = make(map[string]*serverHealth)
+	}
+	h := c.health[addr]
+	if h == nil {
+		h = &serverHealth{}
+		c.health[addr] = h
+	}
+	h.failures++
+	if h.failures >= threshold {
+		h.until = now.Add(backoff)
+	}
+}
+
+// connectSucceeded records a successful connection to addr, which makes it healthy again.
+func (c *Client) connectSucceeded(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.health, addr)
+}
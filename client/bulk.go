@@ -0,0 +1,196 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ConnectResult is the outcome of creating one of the channels passed to ConnectAll.
+type ConnectResult struct {
+	Name string
+	// Channel is the channel, if Err is nil.
+	Channel *Channel
+	Err     error
+}
+
+// ConnectAll creates the channels with the given names, as Channel does, and sends the result for each
+// on the returned channel as soon as it is known, in no particular order.
+// The returned channel is closed once every name has a result.
+//
+// Creating many channels with ConnectAll is much faster than calling Channel for each of them:
+// searches for all of them are batched into as few packets as possible,
+// and the channels found on each server are created with as few requests as possible.
+func (c *Client) ConnectAll(ctx context.Context, names []string) <-chan ConnectResult {
+	results := make(chan ConnectResult, len(names))
+	b := &bulkConnect{
+		client:  c,
+		results: results,
+		queued:  make(map[string][]*Channel),
+	}
+	go func() {
+		defer close(results)
+		b.run(ctx, names)
+	}()
+	return results
+}
+
+// bulkConnect is the state of one ConnectAll call.
+type bulkConnect struct {
+	client  *Client
+	results chan<- ConnectResult
+	// wg tracks the goroutines finding and creating channels.
+	wg sync.WaitGroup
+
+	mu sync.Mutex
+	// queued holds the channels found on each server that are waiting to be created, by server address.
+	// A server's address is present while a goroutine is creating its channels.
+	queued map[string][]*Channel
+}
+
+func (b *bulkConnect) run(ctx context.Context, names []string) {
+	var chs []*Channel
+	for _, name := range names {
+		ch := b.client.newChannel(name)
+		if ok, err := ch.connectLocal(ctx); ok || err != nil {
+			b.done(ch, err)
+			continue
+		}
+		chs = append(chs, ch)
+	}
+	errs := make(map[*Channel]error, len(chs))
+	for _, ch := range chs {
+		errs[ch] = errors.New("no server addresses")
+	}
+	chs = b.createListed(ctx, chs, errs)
+	for _, addr := range b.client.ServerAddrs {
+		if len(chs) == 0 {
+			break
+		}
+		chs = b.createOn(ctx, addr, chs, errs)
+	}
+	for _, ch := range chs {
+		ch, err := ch, errs[ch]
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			b.find(ctx, ch, err)
+		}()
+	}
+	b.wg.Wait()
+}
+
+// done reports the result for ch.
+func (b *bulkConnect) done(ch *Channel, err error) {
+	r := ConnectResult{Name: ch.name, Err: err}
+	if err == nil {
+		r.Channel = ch
+	}
+	b.results <- r
+}
+
+// createListed creates the channels of chs that are in the client's ChannelAddrs on their servers,
+// reporting each of them, and returns the rest.
+func (b *bulkConnect) createListed(ctx context.Context, chs []*Channel, errs map[*Channel]error) []*Channel {
+	var rest []*Channel
+	var addrs []string
+	listed := make(map[string][]*Channel)
+	for _, ch := range chs {
+		addr, ok := b.client.ChannelAddrs[ch.name]
+		if !ok {
+			rest = append(rest, ch)
+			continue
+		}
+		if listed[addr] == nil {
+			addrs = append(addrs, addr)
+		}
+		listed[addr] = append(listed[addr], ch)
+	}
+	for _, addr := range addrs {
+		for _, ch := range b.createOn(ctx, addr, listed[addr], errs) {
+			b.done(ch, fmt.Errorf("creating channel %q: %w", ch.name, errs[ch]))
+		}
+	}
+	return rest
+}
+
+// createOn creates chs on the server at addr, reports the channels that are created,
+// and returns the rest, recording why each failed in errs.
+func (b *bulkConnect) createOn(ctx context.Context, addr string, chs []*Channel, errs map[*Channel]error) []*Channel {
+	cn, err := b.client.conn(ctx, addr)
+	if err != nil {
+		for _, ch := range chs {
+			errs[ch] = err
+		}
+		return chs
+	}
+	var rest []*Channel
+	for i, r := range cn.createChannels(ctx, chs) {
+		ch, err := chs[i], r.err
+		if err == nil {
+			err = ch.created(ctx, cn, r.sid)
+		}
+		if err == nil || err == ErrClosed {
+			b.done(ch, err)
+			continue
+		}
+		errs[ch] = err
+		rest = append(rest, ch)
+	}
+	return rest
+}
+
+// find searches for a server that has ch, first over the client's existing connections and then over UDP,
+// and queues it to be created there. lastErr is why ch could not be created on the client's ServerAddrs.
+func (b *bulkConnect) find(ctx context.Context, ch *Channel, lastErr error) {
+	if addr := b.client.searchConns(ctx, ch.name, b.client.ServerAddrs); addr != "" {
+		b.enqueue(ctx, addr, ch)
+		return
+	}
+	s, err := b.client.searcher(ctx)
+	if err != nil {
+		b.done(ch, fmt.Errorf("searching for channel %q: %w", ch.name, err))
+		return
+	}
+	if s == nil {
+		b.done(ch, fmt.Errorf("creating channel %q: %w", ch.name, lastErr))
+		return
+	}
+	addr, err := s.search(ctx, ch.name)
+	if err != nil {
+		b.done(ch, fmt.Errorf("searching for channel %q: %w", ch.name, err))
+		return
+	}
+	b.enqueue(ctx, addr, ch)
+}
+
+// enqueue queues ch to be created on the server at addr, along with any other channels found there in the meantime.
+func (b *bulkConnect) enqueue(ctx context.Context, addr string, ch *Channel) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	queue, running := b.queued[addr]
+	b.queued[addr] = append(queue, ch)
+	if running {
+		return
+	}
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		for {
+			b.mu.Lock()
+			batch := b.queued[addr]
+			if len(batch) == 0 {
+				delete(b.queued, addr)
+				b.mu.Unlock()
+				return
+			}
+			b.queued[addr] = nil
+			b.mu.Unlock()
+			errs := make(map[*Channel]error, len(batch))
+			for _, ch := range b.createOn(ctx, addr, batch, errs) {
+				b.done(ch, fmt.Errorf("creating channel %q: %w", ch.name, errs[ch]))
+			}
+		}
+	}()
+}
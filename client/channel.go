@@ -0,0 +1,407 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+	"github.com/Lexcelon/go-pvaccess/clock"
+	"github.com/Lexcelon/go-pvaccess/internal/connection"
+	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/Lexcelon/go-pvaccess/types"
+)
+
+// Channel is a channel created by a Client.
+// If its connection is lost, the channel is re-created in the background; operations wait until it is connected again.
+type Channel struct {
+	client *Client
+	name   string
+	// id is the client channel ID, which stays the same across reconnections.
+	id pvdata.PVInt
+
+	mu sync.Mutex
+	// local is set if the channel was created on one of the client's LocalServers. Local channels never disconnect.
+	local *pvaccess.LocalChannel
+	conn  *conn
+	// sid is the server channel ID on conn.
+	sid pvdata.PVInt
+	// since is when the channel was created, or last lost its connection.
+	since time.Time
+	// ready is closed while the channel is connected.
+	ready    chan struct{}
+	monitors map[*Monitor]struct{}
+	closed   bool
+}
+
+// Name returns the name of the channel.
+func (ch *Channel) Name() string {
+	return ch.name
+}
+
+// connect creates the channel on the first server in the client's LocalServers or ServerAddrs that has it,
+// or else searches for a server that has it: first over the client's existing connections, and then over UDP.
+// A server that denies permission for the channel ends the search.
+// Channels in the client's ChannelAddrs are only created on their server, without searching.
+func (ch *Channel) connect(ctx context.Context) error {
+	if ok, err := ch.connectLocal(ctx); ok || err != nil {
+		return err
+	}
+	if addr, ok := ch.client.ChannelAddrs[ch.name]; ok {
+		err := ch.connectTo(ctx, addr)
+		if err == nil || err == ErrClosed {
+			return err
+		}
+		return fmt.Errorf("creating channel %q: %w", ch.name, err)
+	}
+	var lastErr error = errors.New("no server addresses")
+	for _, addr := range ch.client.ServerAddrs {
+		err := ch.connectTo(ctx, addr)
+		if err == nil || err == ErrClosed {
+			return err
+		}
+		if lastErr = err; errors.Is(err, pvaccess.ErrPermissionDenied) {
+			return fmt.Errorf("creating channel %q: %w", ch.name, err)
+		}
+	}
+	if addr := ch.client.searchConns(ctx, ch.name, ch.client.ServerAddrs); addr != "" {
+		err := ch.connectTo(ctx, addr)
+		if err == nil || err == ErrClosed {
+			return err
+		}
+		if lastErr = err; errors.Is(err, pvaccess.ErrPermissionDenied) {
+			return fmt.Errorf("creating channel %q: %w", ch.name, err)
+		}
+	}
+	s, err := ch.client.searcher(ctx)
+	if err != nil {
+		return fmt.Errorf("searching for channel %q: %w", ch.name, err)
+	}
+	if s != nil {
+		addr, err := s.search(ctx, ch.name)
+		if err != nil {
+			return fmt.Errorf("searching for channel %q: %w", ch.name, err)
+		}
+		lastErr = ch.connectTo(ctx, addr)
+		if lastErr == nil || lastErr == ErrClosed {
+			return lastErr
+		}
+	}
+	return fmt.Errorf("creating channel %q: %w", ch.name, lastErr)
+}
+
+// connectLocal creates the channel on the first of the client's LocalServers that has it.
+// It returns false if none has it.
+func (ch *Channel) connectLocal(ctx context.Context) (bool, error) {
+	for _, srv := range ch.client.LocalServers {
+		lc, err := srv.LocalChannel(ctx, ch.name)
+		if err != nil {
+			return false, fmt.Errorf("creating channel %q: %w", ch.name, err)
+		}
+		if lc != nil {
+			ch.mu.Lock()
+			defer ch.mu.Unlock()
+			if ch.closed {
+				lc.Close()
+				return true, ErrClosed
+			}
+			ch.local = lc
+			close(ch.ready)
+			ch.client.stats.channelConnected(ch.name, "", ch.client.clock().Now().Sub(ch.since))
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// connectTo creates the channel on the server at addr.
+func (ch *Channel) connectTo(ctx context.Context, addr string) error {
+	cn, err := ch.client.conn(ctx, addr)
+	if err != nil {
+		return err
+	}
+	sid, err := cn.createChannel(ctx, ch)
+	if err != nil {
+		return err
+	}
+	return ch.created(ctx, cn, sid)
+}
+
+// created is called once the channel has been created on cn as sid, and makes it ready for use.
+func (ch *Channel) created(ctx context.Context, cn *conn, sid pvdata.PVInt) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.closed {
+		cn.destroyChannel(ctx, ch, sid)
+		return ErrClosed
+	}
+	ch.conn, ch.sid = cn, sid
+	close(ch.ready)
+	ch.client.stats.channelConnected(ch.name, cn.addr, ch.client.clock().Now().Sub(ch.since))
+	return nil
+}
+
+// connection waits until the channel is connected and returns its connection and server channel ID.
+func (ch *Channel) connection(ctx context.Context) (*conn, pvdata.PVInt, error) {
+	for {
+		ch.mu.Lock()
+		cn, sid, ready, closed := ch.conn, ch.sid, ch.ready, ch.closed
+		ch.mu.Unlock()
+		if closed {
+			return nil, 0, ErrClosed
+		}
+		if cn != nil {
+			return cn, sid, nil
+		}
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		}
+	}
+}
+
+// disconnected is called when cn is lost, and starts reconnecting the channel.
+func (ch *Channel) disconnected(cn *conn, err error) {
+	ch.mu.Lock()
+	if ch.conn != cn {
+		ch.mu.Unlock()
+		return
+	}
+	ch.conn = nil
+	ch.ready = make(chan struct{})
+	ch.since = ch.client.clock().Now()
+	monitors := ch.monitorList()
+	closed := ch.closed
+	ch.mu.Unlock()
+	ch.client.stats.channelDisconnected(ch.name)
+	for _, m := range monitors {
+		m.disconnected(err)
+	}
+	if !closed {
+		go ch.reconnect()
+	}
+}
+
+// monitorList must be called with ch.mu held.
+func (ch *Channel) monitorList() []*Monitor {
+	monitors := make([]*Monitor, 0, len(ch.monitors))
+	for m := range ch.monitors {
+		monitors = append(monitors, m)
+	}
+	return monitors
+}
+
+// reconnect re-creates the channel, with exponential backoff, and then resumes its monitors.
+func (ch *Channel) reconnect() {
+	ctx := ctxlog.WithSubsystem(ctxlog.WithField(ch.client.ctx, "channel", ch.name), ctxlog.Connection)
+	delay, max := ch.client.reconnectDelays()
+	for {
+		if clock.Sleep(ctx, ch.client.clock(), delay) != nil {
+			return
+		}
+		err := ch.connect(ctx)
+		if err == nil {
+			break
+		}
+		if err == ErrClosed || ctx.Err() != nil {
+			return
+		}
+		ctxlog.L(ctx).Infof("reconnecting: %v", err)
+		if delay *= 2; delay > max {
+			delay = max
+		}
+		var retryable *pvaccess.RetryableError
+		if errors.As(err, &retryable) && retryable.After > delay {
+			delay = retryable.After
+		}
+	}
+	ctxlog.L(ctx).Infof("reconnected")
+	ch.mu.Lock()
+	monitors := ch.monitorList()
+	ch.mu.Unlock()
+	for _, m := range monitors {
+		m.resume(ctx)
+	}
+}
+
+// ConnectionInfo describes the connection the channel is currently using.
+// It returns false if the channel is not connected. Local channels have no connection,
+// and report a ConnectionInfo with only AuthNZ set, to "local".
+func (ch *Channel) ConnectionInfo() (types.ConnectionInfo, bool) {
+	ch.mu.Lock()
+	cn, local := ch.conn, ch.local
+	ch.mu.Unlock()
+	if local != nil {
+		return types.ConnectionInfo{AuthNZ: "local"}, true
+	}
+	if cn == nil {
+		return types.ConnectionInfo{}, false
+	}
+	return cn.info(), true
+}
+
+// recordOp records the latency of an operation of kind on the channel that started at start,
+// once it has finished with *err.
+func (ch *Channel) recordOp(kind pvaccess.OpKind, start time.Time, err *error) {
+	ch.client.stats.op(ch.name, kind, ch.client.clock().Now().Sub(start), *err)
+}
+
+// localChannel returns the channel's LocalChannel, or nil if it was created on a remote server.
+func (ch *Channel) localChannel() *pvaccess.LocalChannel {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return ch.local
+}
+
+// Close destroys the channel and any monitors on it.
+func (ch *Channel) Close() error {
+	ch.mu.Lock()
+	if ch.closed {
+		ch.mu.Unlock()
+		return nil
+	}
+	ch.closed = true
+	cn, sid, local := ch.conn, ch.sid, ch.local
+	monitors := ch.monitorList()
+	ch.mu.Unlock()
+	for _, m := range monitors {
+		m.Close()
+	}
+	if local != nil {
+		return local.Close()
+	}
+	if cn == nil {
+		return nil
+	}
+	return cn.destroyChannel(ch.client.ctx, ch, sid)
+}
+
+// Get reads the current value of the channel.
+// If the channel's type is already known from an earlier Get on the same server, the value is requested
+// without waiting for the server to describe its type first, saving a round trip.
+func (ch *Channel) Get(ctx context.Context) (pvdata.PVStructure, error) {
+	if lc := ch.localChannel(); lc != nil {
+		return lc.Get(ctx, emptyRequest().Data.(pvdata.PVStructure))
+	}
+	return ch.get(ctx, zeroStructure, false)
+}
+
+// GetShared reads the current value of the channel like Get, but decodes its byte and ubyte arrays, such as the
+// value of an NTNDArray holding an image, as pvdata.SharedBytes that alias the connection's buffer instead of
+// copying frames that may be megabytes long. The caller owns the buffers until it calls Release on the value,
+// which it must do once it is done with them so that they can be reused. Values of local channels are never
+// shared, and releasing them does nothing.
+func (ch *Channel) GetShared(ctx context.Context) (pvdata.PVStructure, error) {
+	if lc := ch.localChannel(); lc != nil {
+		return lc.Get(ctx, emptyRequest().Data.(pvdata.PVStructure))
+	}
+	return ch.get(ctx, func(fd pvdata.FieldDesc) (pvdata.PVStructure, error) {
+		zero, err := fd.ZeroShared()
+		if err != nil {
+			return pvdata.PVStructure{}, err
+		}
+		value, ok := zero.(pvdata.PVStructure)
+		if !ok {
+			return pvdata.PVStructure{}, fmt.Errorf("channel value is %T, expected PVStructure", zero)
+		}
+		return value, nil
+	}, false)
+}
+
+// get reads the current value of a remote channel into a structure created by zero.
+// If stream is set, a segmented response is decoded as its segments arrive.
+func (ch *Channel) get(ctx context.Context, zero func(fd pvdata.FieldDesc) (pvdata.PVStructure, error), stream bool) (_ pvdata.PVStructure, err error) {
+	defer ch.recordOp(pvaccess.OpGet, ch.client.clock().Now(), &err)
+	cn, sid, err := ch.connection(ctx)
+	if err != nil {
+		return pvdata.PVStructure{}, err
+	}
+	id := ch.client.newID()
+	if stream {
+		cn.setStreamed(id, true)
+		defer cn.setStreamed(id, false)
+	}
+	init := &proto.ChannelGetRequest{
+		ServerChannelID: sid,
+		RequestID:       id,
+		Subcommand:      proto.CHANNEL_GET_INIT,
+		PVRequest:       emptyRequest(),
+	}
+	// The DESTROY flag frees the request on the server once the value has been sent.
+	get := &proto.ChannelGetRequest{
+		ServerChannelID: sid,
+		RequestID:       id,
+		Subcommand:      proto.CHANNEL_GET_EXECUTE | proto.CHANNEL_GET_DESTROY,
+	}
+	guid, hasGUID := cn.serverGUID()
+	var fd pvdata.FieldDesc
+	initDone := func(msg *connection.Message) error {
+		var resp proto.ChannelGetResponseInit
+		if err := msg.Decode(&resp); err != nil {
+			return err
+		}
+		fd = resp.PVStructureIF
+		if err := statusError(resp.Status); err != nil {
+			return err
+		}
+		if hasGUID {
+			fd = ch.client.types.store(guid, ch.name, fd)
+		}
+		return nil
+	}
+	var value pvdata.PVStructure
+	getDone := func(msg *connection.Message) error {
+		resp := proto.ChannelGetResponse{Value: pvdata.PVStructureDiff{Value: value}}
+		if err := msg.Decode(&resp); err != nil {
+			return err
+		}
+		return statusError(resp.Status)
+	}
+	if _, known := ch.client.types.lookup(guid, ch.name); hasGUID && known {
+		// The server processes the requests in order, so the type arrives before the value.
+		if err := cn.pipeline(ctx, sid, id, proto.APP_CHANNEL_GET, []interface{}{init, get}, func(i int, msg *connection.Message) error {
+			if i == 0 {
+				if err := initDone(msg); err != nil {
+					return err
+				}
+				var err error
+				value, err = zero(fd)
+				return err
+			}
+			return getDone(msg)
+		}); err != nil {
+			return pvdata.PVStructure{}, err
+		}
+		return value, nil
+	}
+	if err := cn.roundTrip(ctx, sid, id, proto.APP_CHANNEL_GET, init, initDone); err != nil {
+		return pvdata.PVStructure{}, err
+	}
+	value, err = zero(fd)
+	if err != nil {
+		cn.destroyRequest(ctx, sid, id)
+		return pvdata.PVStructure{}, err
+	}
+	if err := cn.roundTrip(ctx, sid, id, proto.APP_CHANNEL_GET, get, getDone); err != nil {
+		return pvdata.PVStructure{}, err
+	}
+	return value, nil
+}
+
+// zeroStructure returns a new structure described by fd, for responses to be decoded into.
+func zeroStructure(fd pvdata.FieldDesc) (pvdata.PVStructure, error) {
+	zero, err := fd.Zero()
+	if err != nil {
+		return pvdata.PVStructure{}, err
+	}
+	value, ok := zero.(pvdata.PVStructure)
+	if !ok {
+		return pvdata.PVStructure{}, fmt.Errorf("channel value is %T, expected PVStructure", zero)
+	}
+	return value, nil
+}
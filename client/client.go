@@ -0,0 +1,312 @@
+// Package client implements a pvAccess client.
+//
+// A Client connects to the servers in its ServerAddrs, or to servers found by searching its SearchAddrs,
+// and creates channels on them.
+// Channels survive the loss of their connection: the client reconnects in the background,
+// re-creates the channel, and resumes any monitors on it.
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+	"github.com/Lexcelon/go-pvaccess/clock"
+	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+// Client is a pvAccess client.
+type Client struct {
+	// LocalServers lists servers running in the same process, which are asked for each channel before ServerAddrs.
+	// Channels found on a local server are connected directly to its providers, without a network connection,
+	// and their values are not serialized.
+	LocalServers []*pvaccess.Server
+	// ServerAddrs lists the TCP addresses ("host:port") of the servers to create channels on, in the order they are tried.
+	ServerAddrs []string
+	// SearchAddrs lists the UDP addresses to send search requests to, for channels that are not found on any of ServerAddrs.
+	// They may be unicast, broadcast, or multicast addresses; the port defaults to 5076.
+	// Search requests are sent to every address that a host name resolves to.
+	SearchAddrs []string
+	// ChannelAddrs maps the names of channels to the TCP addresses of the servers that have them, e.g. for containers
+	// without broadcast. Channels in ChannelAddrs are created on that server, or not at all: LocalServers are
+	// asked first, but ServerAddrs and SearchAddrs are not.
+	ChannelAddrs map[string]string
+	// Resolver, if non-nil, looks up the host names in ServerAddrs, ChannelAddrs, and SearchAddrs, instead of the
+	// system resolver, e.g. a *net.Resolver with its own Dial, or a service registry.
+	Resolver Resolver
+	// SearchConn, if non-nil, is the socket that search requests are sent from and responses are read from,
+	// instead of one opened on a random port. The client doesn't close it, so it can be shared with a server
+	// in the same process that only sends from it, as by node.Context.
+	SearchConn *net.UDPConn
+	// SearchDelay is how long to wait for a response before searching for a channel again.
+	// The delay doubles after each attempt, up to MaxSearchDelay.
+	// Zero values select defaults of 50 milliseconds and 5 seconds.
+	SearchDelay, MaxSearchDelay time.Duration
+	// SearchPolicy selects the server to use when more than one answers a search for a channel.
+	// Unless it is SearchFirst and OnSearchConflict is nil, responses are collected for SearchWindow
+	// (default 100 milliseconds) after the first before one is chosen. PreferredServers lists the TCP addresses
+	// of the servers preferred by SearchPreferred, most preferred first.
+	SearchPolicy     SearchPolicy
+	SearchWindow     time.Duration
+	PreferredServers []string
+	// OnSearchConflict, if non-nil, is called with the addresses of the servers that answered a search for the channel
+	// name, in the order they answered, whenever more than one did.
+	OnSearchConflict func(name string, servers []string)
+	// SearchBudget is the maximum number of search packets sent per second, to avoid flooding the network
+	// when many channels are being searched for. Zero selects a default of 100.
+	SearchBudget int
+	// ReconnectDelay is how long a channel waits before trying to reconnect after losing its connection.
+	// The delay doubles after each failed attempt, up to MaxReconnectDelay.
+	// Zero values select defaults of 100 milliseconds and 30 seconds.
+	ReconnectDelay, MaxReconnectDelay time.Duration
+	// Compressor, if non-nil, is offered to servers to compress messages, e.g. &pvaccess.FlateCompressor{}.
+	// It is only used with servers that have a Compressor with the same algorithm; other servers are unaffected.
+	// Only messages with payloads larger than CompressionThreshold bytes (default 1024) are compressed.
+	Compressor           pvaccess.Compressor
+	CompressionThreshold int
+	// Serializer, if non-nil, encodes the payloads of messages instead of the pvData binary encoding.
+	// It can only be used with servers that have the same Serializer; see pvaccess.Server.Serializer.
+	Serializer pvdata.Serializer
+	// BreakerThreshold is the number of consecutive failures to connect to a server, or to validate the connection,
+	// after which the server is considered unhealthy for BreakerBackoff. Unhealthy servers are not dialed:
+	// other servers that respond to a search for the same channel are preferred, and connecting to the server
+	// returns ErrServerUnhealthy. Zero values select defaults of 3 failures and 10 seconds;
+	// a negative threshold never marks servers unhealthy.
+	BreakerThreshold int
+	BreakerBackoff   time.Duration
+	// RetryPolicy, if non-nil, retries Channel, Get, and Put when they fail, e.g. because a server is restarting,
+	// instead of leaving each caller to pick a deadline. DefaultRetryPolicy is a reasonable choice for scripts.
+	// RPCs and monitors are never retried.
+	RetryPolicy *RetryPolicy
+	// MonitorQueueSize is the number of events each Monitor queues while they are not received from its Events channel.
+	// Once the queue is full, each new update is merged into the last one queued, as a server coalesces the updates
+	// of a slow client: the merged update has the latest value of every field either changed, and marks the fields
+	// that changed in both in Overrun. Zero selects a default of 100; a negative size queues without limit.
+	MonitorQueueSize int
+	// Clock measures the client's search and reconnection delays, retry backoff and timeouts, and server backoff.
+	// Nil selects the system clock; tests can set a *clock.Fake.
+	Clock clock.Clock
+
+	// lastID is used to allocate client channel IDs and request IDs.
+	lastID int32
+	// types caches the types of channels' values.
+	types typeCache
+	stats statsRecorder
+
+	ctx    context.Context
+	cancel func()
+
+	mu     sync.Mutex
+	conns  map[string]*conn
+	search *searcher
+	// health holds the servers that have recently failed, by address.
+	health map[string]*serverHealth
+}
+
+const (
+	defaultReconnectDelay       = 100 * time.Millisecond
+	defaultMaxReconnectDelay    = 30 * time.Second
+	defaultCompressionThreshold = 1024
+	defaultMonitorQueueSize     = 100
+)
+
+// clock returns the client's Clock.
+func (c *Client) clock() clock.Clock {
+	return clock.Or(c.Clock)
+}
+
+// monitorQueueSize returns the number of events a Monitor queues before merging updates, or 0 for no limit.
+func (c *Client) monitorQueueSize() int {
+	switch {
+	case c.MonitorQueueSize < 0:
+		return 0
+	case c.MonitorQueueSize == 0:
+		return defaultMonitorQueueSize
+	}
+	return c.MonitorQueueSize
+}
+
+// ErrClosed is returned by operations on a Client, Channel, or Monitor that has been closed.
+var ErrClosed = errors.New("client: closed")
+
+// ErrChannelDestroyed is reported to the monitors of a channel that the server destroyed, e.g. because it is shutting down.
+// The channel is created again, like after a lost connection.
+var ErrChannelDestroyed = errors.New("client: channel destroyed by server")
+
+// New returns a Client that creates channels on the servers at serverAddrs.
+// To find channels by searching, set SearchAddrs before creating any channels.
+func New(serverAddrs ...string) *Client {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Client{
+		ServerAddrs: serverAddrs,
+		ctx:         ctx,
+		cancel:      cancel,
+		conns:       make(map[string]*conn),
+	}
+}
+
+// Close closes all of the client's connections. Channels and monitors created by the client stop working.
+func (c *Client) Close() error {
+	c.cancel()
+	c.mu.Lock()
+	conns := c.conns
+	c.conns = make(map[string]*conn)
+	c.mu.Unlock()
+	for _, cn := range conns {
+		cn.close(ErrClosed)
+	}
+	return nil
+}
+
+// newID returns a new ID, unique within the client, for a channel or request.
+func (c *Client) newID() pvdata.PVInt {
+	return pvdata.PVInt(atomic.AddInt32(&c.lastID, 1))
+}
+
+// conn returns the connection to addr, dialing it if necessary.
+func (c *Client) conn(ctx context.Context, addr string) (*conn, error) {
+	c.mu.Lock()
+	cn := c.conns[addr]
+	c.mu.Unlock()
+	if cn != nil {
+		return cn, nil
+	}
+	if err := c.ctx.Err(); err != nil {
+		return nil, ErrClosed
+	}
+	if err := c.checkHealthy(addr, c.clock().Now()); err != nil {
+		return nil, err
+	}
+	start := c.clock().Now()
+	cn, err := c.dial(ctx, addr)
+	if err != nil {
+		if ctx.Err() == nil {
+			c.connectFailed(addr, c.clock().Now())
+			c.stats.connected(addr, 0, err)
+		}
+		return nil, err
+	}
+	c.connectSucceeded(addr)
+	c.mu.Lock()
+	if existing := c.conns[addr]; existing != nil {
+		// Another channel dialed the same server concurrently.
+		c.mu.Unlock()
+		cn.close(nil)
+		return existing, nil
+	}
+	c.conns[addr] = cn
+	c.mu.Unlock()
+	c.stats.connected(addr, c.clock().Now().Sub(start), nil)
+	go func() {
+		err := cn.serve(ctxlog.WithSubsystem(ctxlog.WithField(c.ctx, "server", addr), ctxlog.Connection))
+		c.mu.Lock()
+		if c.conns[addr] == cn {
+			delete(c.conns, addr)
+		}
+		c.mu.Unlock()
+		c.stats.disconnected(addr)
+		ctxlog.L(c.ctx).Infof("connection to %s closed: %v", addr, err)
+	}()
+	return cn, nil
+}
+
+func (c *Client) reconnectDelays() (delay, max time.Duration) {
+	delay, max = c.ReconnectDelay, c.MaxReconnectDelay
+	if delay <= 0 {
+		delay = defaultReconnectDelay
+	}
+	if max <= 0 {
+		max = defaultMaxReconnectDelay
+	}
+	return delay, max
+}
+
+// Channel creates the channel with the given name on the first server in LocalServers or ServerAddrs that has it,
+// or else on the first server that answers a search for it.
+// With a RetryPolicy, channels that are not found are retried too.
+func (c *Client) Channel(ctx context.Context, name string) (*Channel, error) {
+	ch := c.newChannel(name)
+	if err := c.RetryPolicy.do(ctx, c.clock(), connectRetryable, ch.connect); err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+func (c *Client) newChannel(name string) *Channel {
+	return &Channel{
+		client:   c,
+		name:     name,
+		id:       c.newID(),
+		since:    c.clock().Now(),
+		ready:    make(chan struct{}),
+		monitors: make(map[*Monitor]struct{}),
+	}
+}
+
+// statusError returns s as an error if it reports an error, or nil for OK and WARNING statuses.
+func statusError(s pvdata.PVStatus) error {
+	if s.Type > pvdata.PVStatus_WARNING {
+		return s
+	}
+	return nil
+}
+
+// createError returns the error for a CreateChannelResponse's status s, or nil if the channel was created.
+// The error matches pvaccess.ErrNotFound or pvaccess.ErrPermissionDenied if the server said why the channel
+// couldn't be created, and is a *pvaccess.RetryableError if the server suggested trying again.
+func createError(s pvdata.PVStatus) error {
+	if s.Type <= pvdata.PVStatus_WARNING {
+		return nil
+	}
+	f := proto.ParseCreateChannelFailure(string(s.Message))
+	var err error = &createFailure{status: s, reason: f.Reason}
+	if f.Retry {
+		err = &pvaccess.RetryableError{Err: err, After: f.RetryAfter}
+	}
+	return err
+}
+
+// createFailure is a status reporting that a channel couldn't be created, for the reason given by the server.
+type createFailure struct {
+	status pvdata.PVStatus
+	reason string
+}
+
+func (e *createFailure) Error() string { return e.status.Error() }
+
+// Unwrap returns the status, so that callers can find it with errors.As.
+func (e *createFailure) Unwrap() error { return e.status }
+
+func (e *createFailure) Is(target error) bool {
+	switch target {
+	case pvaccess.ErrNotFound:
+		return e.reason == proto.CREATE_CHANNEL_NOT_FOUND
+	case pvaccess.ErrPermissionDenied:
+		return e.reason == proto.CREATE_CHANNEL_PERMISSION_DENIED
+	}
+	return false
+}
+
+// emptyRequest is the pvRequest sent when none is given.
+func emptyRequest() pvdata.PVAny {
+	return pvdata.NewPVAny(&struct{}{})
+}
+
+// newRequest converts a pvRequest passed by the caller to the form sent on the wire.
+func newRequest(req interface{}) (pvdata.PVAny, error) {
+	if req == nil {
+		return emptyRequest(), nil
+	}
+	any := pvdata.NewPVAny(req)
+	if any.Data == nil {
+		return pvdata.PVAny{}, fmt.Errorf("cannot encode pvRequest of type %T", req)
+	}
+	return any, nil
+}
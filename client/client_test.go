@@ -0,0 +1,956 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+// serve runs srv on addr until the returned function is called.
+func serve(t *testing.T, srv *pvaccess.Server, addr string) (string, func()) {
+	t.Helper()
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.Serve(ctx, ln)
+	}()
+	stop := func() {
+		cancel()
+		<-done
+	}
+	t.Cleanup(stop)
+	return ln.Addr().String(), stop
+}
+
+func newServer(ch *pvaccess.SimpleChannel) *pvaccess.Server {
+	srv := &pvaccess.Server{DisableSearch: true}
+	srv.AddChannelProvider(ch)
+	return srv
+}
+
+func value(t *testing.T, v pvdata.PVStructure) int32 {
+	t.Helper()
+	x, ok := pvdata.IntValue(v.Field("value"))
+	if !ok {
+		t.Fatalf("value %v has no integer value field", v)
+	}
+	return int32(x)
+}
+
+func TestGet(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ch := pvaccess.NewSimpleChannel("test")
+	x := pvdata.PVInt(42)
+	ch.Set(&x)
+	addr, _ := serve(t, newServer(ch), "127.0.0.1:0")
+
+	c := New("127.0.0.1:1", addr)
+	defer c.Close()
+	channel, err := c.Channel(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := channel.ConnectionInfo(); !ok {
+		t.Error("ConnectionInfo reported channel not connected")
+	}
+	got, err := channel.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := value(t, got); v != 42 {
+		t.Errorf("Get = %d, want 42", v)
+	}
+	if _, err := c.Channel(ctx, "missing"); err == nil {
+		t.Error("creating missing channel succeeded")
+	}
+}
+
+func TestPut(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ch := pvaccess.NewSimpleChannel("test")
+	x := pvdata.PVInt(1)
+	ch.Set(&x)
+	srv := newServer(ch)
+	addr, _ := serve(t, srv, "127.0.0.1:0")
+
+	remote := New(addr)
+	defer remote.Close()
+	local := New()
+	local.LocalServers = []*pvaccess.Server{srv}
+	defer local.Close()
+	for i, c := range []*Client{remote, local} {
+		channel, err := c.Channel(ctx, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := int32(10 + i)
+		if err := channel.Put(ctx, &struct {
+			Value int64 `pvaccess:"value"`
+		}{int64(want)}); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		got, err := channel.Get(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := value(t, got); v != want {
+			t.Errorf("Get after Put = %d, want %d", v, want)
+		}
+		if err := channel.PutFields(ctx, &struct {
+			Value int64 `pvaccess:"value"`
+		}{1}, []string{"missing"}); err == nil {
+			t.Error("PutFields of missing field succeeded")
+		}
+	}
+}
+
+// slowChannel answers its first Get, which describes its type, and then blocks each Get until it is cancelled.
+type slowChannel struct {
+	gets      int32
+	cancelled chan struct{}
+}
+
+func (s *slowChannel) Name() string { return "slow" }
+
+func (s *slowChannel) CreateChannel(ctx context.Context, name string) (pvaccess.Channel, error) {
+	if name == s.Name() {
+		return s, nil
+	}
+	return nil, nil
+}
+
+func (s *slowChannel) ChannelGet(ctx context.Context) (interface{}, error) {
+	x := pvdata.PVInt(1)
+	v := &pvdata.NTScalar{Value: &x}
+	if atomic.AddInt32(&s.gets, 1) == 1 {
+		return v, nil
+	}
+	<-ctx.Done()
+	s.cancelled <- struct{}{}
+	return v, nil
+}
+
+func TestDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ch := &slowChannel{cancelled: make(chan struct{}, 1)}
+	srv := &pvaccess.Server{DisableSearch: true}
+	srv.AddChannelProvider(ch)
+	addr, _ := serve(t, srv, "127.0.0.1:0")
+
+	c := New(addr)
+	defer c.Close()
+	channel, err := c.Channel(ctx, "slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	getCtx, cancelGet := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancelGet()
+	if _, err := channel.Get(getCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Get = %v, want %v", err, context.DeadlineExceeded)
+	}
+	// The client cancels the request, which cancels the provider's context on the server.
+	select {
+	case <-ch.cancelled:
+	case <-ctx.Done():
+		t.Fatal("server's Get was not cancelled")
+	}
+}
+
+func nextEvent(ctx context.Context, t *testing.T, m *Monitor) Event {
+	t.Helper()
+	select {
+	case e, ok := <-m.Events():
+		if !ok {
+			t.Fatalf("monitor closed: %v", m.Err())
+		}
+		return e
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for monitor event")
+	}
+	return Event{}
+}
+
+func TestMonitorReconnect(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ch := pvaccess.NewSimpleChannel("test")
+	set := func(v int32) {
+		x := pvdata.PVInt(v)
+		ch.Set(&x)
+	}
+	set(1)
+	srv := newServer(ch)
+	addr, stop := serve(t, srv, "127.0.0.1:0")
+
+	c := New(addr)
+	c.ReconnectDelay = 10 * time.Millisecond
+	defer c.Close()
+	channel, err := c.Channel(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := channel.Monitor(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	if e := nextEvent(ctx, t, m); e.Kind != Update || !e.Full || value(t, e.Value) != 1 {
+		t.Fatalf("first event = %v (full %v), want full update", e.Kind, e.Full)
+	}
+	set(2)
+	if e := nextEvent(ctx, t, m); e.Kind != Update || e.Full || value(t, e.Value) != 2 {
+		t.Fatalf("second event = %v (full %v), want partial update", e.Kind, e.Full)
+	}
+
+	stop()
+	if e := nextEvent(ctx, t, m); e.Kind != Disconnected || e.Err == nil {
+		t.Fatalf("event after server stopped = %v (err %v), want Disconnected with error", e.Kind, e.Err)
+	}
+	set(3)
+	serve(t, srv, addr)
+	if e := nextEvent(ctx, t, m); e.Kind != Reconnected {
+		t.Fatalf("event after server restarted = %v, want Reconnected", e.Kind)
+	}
+	if e := nextEvent(ctx, t, m); e.Kind != Update || !e.Full || value(t, e.Value) != 3 {
+		t.Fatalf("event after reconnecting = %v (full %v), want full update", e.Kind, e.Full)
+	}
+	set(4)
+	if e := nextEvent(ctx, t, m); e.Kind != Update || value(t, e.Value) != 4 {
+		t.Fatalf("event after reconnecting = %v, want update", e.Kind)
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ch := pvaccess.NewSimpleChannel("test")
+	x := pvdata.PVInt(1)
+	ch.Set(&x)
+	srv := newServer(ch)
+	srv.DrainTimeout = 5 * time.Second
+	started, release := make(chan struct{}), make(chan struct{})
+	srv.AddInterceptor(func(ctx context.Context, op *pvaccess.Op, next pvaccess.OpHandler) (interface{}, error) {
+		if op.Kind == pvaccess.OpGet && !op.Init {
+			close(started)
+			<-release
+		}
+		return next(ctx, op)
+	})
+	addr, stop := serve(t, srv, "127.0.0.1:0")
+
+	c := New(addr)
+	defer c.Close()
+	channel, err := c.Channel(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := channel.Monitor(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if e := nextEvent(ctx, t, m); e.Kind != Update {
+		t.Fatalf("first event = %v, want update", e.Kind)
+	}
+	got := make(chan error, 1)
+	go func() {
+		_, err := channel.Get(ctx)
+		got <- err
+	}()
+	<-started
+	stopped := make(chan struct{})
+	go func() {
+		stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("server stopped with a Get in progress")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	// The Get in progress completes before the channel is destroyed.
+	if err := <-got; err != nil {
+		t.Errorf("Get in progress during shutdown failed: %v", err)
+	}
+	if e := nextEvent(ctx, t, m); e.Kind != Disconnected || !errors.Is(e.Err, ErrChannelDestroyed) {
+		t.Errorf("event after shutdown = %v (err %v), want Disconnected with ErrChannelDestroyed", e.Kind, e.Err)
+	}
+	<-stopped
+}
+
+// TestWarningFailure checks that operations that fail with a WARNING status fail for the client,
+// which would otherwise take the warning to mean that its request was created.
+func TestWarningFailure(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ch := pvaccess.NewSimpleChannel("test")
+	x := pvdata.PVInt(1)
+	ch.Set(&x)
+	srv := newServer(ch)
+	srv.AddInterceptor(func(ctx context.Context, op *pvaccess.Op, next pvaccess.OpHandler) (interface{}, error) {
+		if op.Init {
+			return nil, pvdata.Warning("not now")
+		}
+		return next(ctx, op)
+	})
+	addr, _ := serve(t, srv, "127.0.0.1:0")
+	c := New(addr)
+	defer c.Close()
+	channel, err := c.Channel(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opCtx, opCancel := context.WithTimeout(ctx, 2*time.Second)
+	defer opCancel()
+	var s pvdata.PVStatus
+	if _, err := channel.Get(opCtx); !errors.As(err, &s) || s.Type != pvdata.PVStatus_ERROR || s.Message != "not now" {
+		t.Errorf("Get = %v, want an ERROR status", err)
+	}
+	if err := channel.Put(opCtx, &struct {
+		Value pvdata.PVInt `pvaccess:"value"`
+	}{2}); !errors.As(err, &s) || s.Type != pvdata.PVStatus_ERROR {
+		t.Errorf("Put = %v, want an ERROR status", err)
+	}
+	if m, err := channel.Monitor(opCtx, nil); !errors.As(err, &s) || s.Type != pvdata.PVStatus_ERROR {
+		if m != nil {
+			m.Close()
+		}
+		t.Errorf("Monitor = %v, want an ERROR status", err)
+	}
+}
+
+func TestLocal(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ch := pvaccess.NewSimpleChannel("test")
+	set := func(v int32) {
+		x := pvdata.PVInt(v)
+		ch.Set(&x)
+	}
+	set(1)
+	// The server is never started: local channels don't need a listener.
+	srv := newServer(ch)
+	ops := make(chan pvaccess.OpKind, 10)
+	srv.AddInterceptor(func(ctx context.Context, op *pvaccess.Op, next pvaccess.OpHandler) (interface{}, error) {
+		if op.Peer == nil || op.Peer.AuthNZ != "local" {
+			t.Errorf("%v operation has peer %+v, want local peer", op.Kind, op.Peer)
+		}
+		select {
+		case ops <- op.Kind:
+		default:
+		}
+		return next(ctx, op)
+	})
+
+	c := New()
+	c.LocalServers = []*pvaccess.Server{srv}
+	defer c.Close()
+	channel, err := c.Channel(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, ok := channel.ConnectionInfo(); !ok || info.AuthNZ != "local" {
+		t.Errorf("ConnectionInfo = %+v, %v, want local", info, ok)
+	}
+	if kind := <-ops; kind != pvaccess.OpCreateChannel {
+		t.Errorf("first intercepted operation = %v, want CreateChannel", kind)
+	}
+	got, err := channel.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := value(t, got); v != 1 {
+		t.Errorf("Get = %d, want 1", v)
+	}
+	m, err := channel.Monitor(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if e := nextEvent(ctx, t, m); e.Kind != Update || !e.Full || value(t, e.Value) != 1 {
+		t.Fatalf("first event = %v (full %v), want full update", e.Kind, e.Full)
+	}
+	set(2)
+	if e := nextEvent(ctx, t, m); e.Kind != Update || value(t, e.Value) != 2 {
+		t.Fatalf("second event = %v, want update", e.Kind)
+	}
+	if _, err := c.Channel(ctx, "missing"); err == nil {
+		t.Error("creating missing channel succeeded")
+	}
+}
+
+// countingCompressor counts the messages it compresses.
+type countingCompressor struct {
+	pvaccess.FlateCompressor
+	compressed int32
+}
+
+func (c *countingCompressor) Compress(dst, src []byte) ([]byte, error) {
+	atomic.AddInt32(&c.compressed, 1)
+	return c.FlateCompressor.Compress(dst, src)
+}
+
+func TestCompression(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	waveform := make([]pvdata.PVDouble, 4096)
+	for i := range waveform {
+		waveform[i] = pvdata.PVDouble(i % 10)
+	}
+	for _, test := range []struct {
+		name           string
+		serverCompress bool
+	}{
+		{"standard server", false},
+		{"compressing server", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ch := pvaccess.NewSimpleChannel("waveform")
+			ch.Set(&waveform)
+			srv := newServer(ch)
+			serverComp := &countingCompressor{}
+			if test.serverCompress {
+				srv.Compressor = serverComp
+			}
+			addr, _ := serve(t, srv, "127.0.0.1:0")
+
+			c := New(addr)
+			c.Compressor = &pvaccess.FlateCompressor{}
+			defer c.Close()
+			channel, err := c.Channel(ctx, "waveform")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Compression is agreed asynchronously after the connection is validated.
+			deadline := time.Now().Add(time.Second)
+			for test.serverCompress && time.Now().Before(deadline) {
+				if info, _ := channel.ConnectionInfo(); info.Compressed {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+			if info, _ := channel.ConnectionInfo(); info.Compressed != test.serverCompress {
+				t.Errorf("ConnectionInfo().Compressed = %v, want %v", info.Compressed, test.serverCompress)
+			}
+			got, err := channel.Get(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v, ok := got.ToMap()["value"].([]float64); !ok || len(v) != len(waveform) || v[123] != float64(waveform[123]) {
+				t.Errorf("Get returned %T, want the waveform", got.ToMap()["value"])
+			}
+			if n := atomic.LoadInt32(&serverComp.compressed); test.serverCompress && n == 0 {
+				t.Error("server compressed no messages")
+			}
+		})
+	}
+}
+
+// xorSerializer is the binary encoding with every byte inverted, so that it can't be read as the binary one.
+type xorSerializer struct{}
+
+type xorWriter struct{ w pvdata.Writer }
+
+func (w xorWriter) Write(p []byte) (int, error) {
+	q := make([]byte, len(p))
+	for i, b := range p {
+		q[i] = ^b
+	}
+	return w.w.Write(q)
+}
+
+func (w xorWriter) WriteByte(b byte) error { return w.w.WriteByte(^b) }
+
+func (w xorWriter) WriteString(s string) (int, error) { return w.Write([]byte(s)) }
+
+type xorReader struct{ r pvdata.Reader }
+
+func (r xorReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	for i := range p[:n] {
+		p[i] = ^p[i]
+	}
+	return n, err
+}
+
+func (r xorReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	return ^b, err
+}
+
+func (xorSerializer) Encode(s *pvdata.EncoderState, v interface{}) error {
+	defer s.PushWriter(xorWriter{s.Buf})()
+	return pvdata.Binary.Encode(s, v)
+}
+
+func (xorSerializer) Decode(s *pvdata.DecoderState, v interface{}) error {
+	defer s.PushReader(xorReader{s.Buf})()
+	return pvdata.Binary.Decode(s, v)
+}
+
+func TestSerializer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	waveform := make([]pvdata.PVDouble, 4096)
+	for i := range waveform {
+		waveform[i] = pvdata.PVDouble(i)
+	}
+	ch := pvaccess.NewSimpleChannel("waveform")
+	ch.Set(&waveform)
+	srv := newServer(ch)
+	srv.Serializer = xorSerializer{}
+	// Larger values are segmented, and each segment is encoded by the Serializer.
+	srv.SegmentSize = 4096
+	addr, _ := serve(t, srv, "127.0.0.1:0")
+
+	c := New(addr)
+	c.Serializer = xorSerializer{}
+	defer c.Close()
+	channel, err := c.Channel(ctx, "waveform")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := channel.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := got.ToMap()["value"].([]float64); !ok || len(v) != len(waveform) || v[123] != 123 {
+		t.Errorf("Get returned %T, want the waveform", got.ToMap()["value"])
+	}
+}
+
+func TestSegmentedArray(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	const n = 100000
+	ch := pvaccess.NewSimpleChannel("waveform")
+	ch.Set(&struct {
+		Value pvdata.ArrayStream `pvaccess:"value"`
+	}{pvdata.ArrayStream{Elem: pvdata.PVFloat(0), Len: n, Chunks: func(yield func(interface{}) error) error {
+		chunk := make([]pvdata.PVFloat, 1000)
+		for start := 0; start < n; start += len(chunk) {
+			for i := range chunk {
+				chunk[i] = pvdata.PVFloat(start + i)
+			}
+			if err := yield(chunk); err != nil {
+				return err
+			}
+		}
+		return nil
+	}}})
+	srv := newServer(ch)
+	srv.SegmentSize = 8192
+	addr, _ := serve(t, srv, "127.0.0.1:0")
+
+	c := New(addr)
+	defer c.Close()
+	channel, err := c.Channel(ctx, "waveform")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(op string, v pvdata.PVStructure) {
+		t.Helper()
+		// SimpleChannel serves the structure as the value field of its own.
+		m, _ := v.ToMap()["value"].(map[string]interface{})
+		if got, ok := m["value"].([]float32); !ok || len(got) != n || got[n-1] != n-1 {
+			t.Errorf("%s returned %T, want the waveform", op, m["value"])
+		}
+	}
+	got, err := channel.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("Get", got)
+	m, err := channel.Monitor(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	check("Monitor", nextEvent(ctx, t, m).Value)
+}
+
+func TestGetArray(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	const n = 100000
+	ch := pvaccess.NewSimpleChannel("waveform")
+	ch.Set(&struct {
+		Value pvdata.ArrayStream `pvaccess:"value"`
+		Count pvdata.PVInt       `pvaccess:"count"`
+	}{pvdata.ArrayStream{Elem: pvdata.PVFloat(0), Len: n, Chunks: func(yield func(interface{}) error) error {
+		chunk := make([]pvdata.PVFloat, 1000)
+		for start := 0; start < n; start += len(chunk) {
+			for i := range chunk {
+				chunk[i] = pvdata.PVFloat(start + i)
+			}
+			if err := yield(chunk); err != nil {
+				return err
+			}
+		}
+		return nil
+	}}, n})
+	srv := newServer(ch)
+	srv.SegmentSize = 8192
+	addr, _ := serve(t, srv, "127.0.0.1:0")
+
+	// check checks that chunks hold consecutive elements of the waveform.
+	next := 0
+	check := func(chunk interface{}, offset, length int) error {
+		elements, ok := chunk.([]float32)
+		if !ok || offset != next || length != n {
+			return fmt.Errorf("chunk of %T at %d of %d, want []float32 at %d of %d", chunk, offset, length, next, n)
+		}
+		for i, x := range elements {
+			if x != float32(offset+i) {
+				return fmt.Errorf("element %d = %v", offset+i, x)
+			}
+		}
+		next += len(elements)
+		return nil
+	}
+	for _, local := range []bool{false, true} {
+		c := New(addr)
+		defer c.Close()
+		if local {
+			c.LocalServers = []*pvaccess.Server{srv}
+		}
+		channel, err := c.Channel(ctx, "waveform")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The value is read twice, so that a pipelined get is also streamed once the type is cached.
+		for i := 0; i < 2; i++ {
+			next = 0
+			v, err := channel.GetArray(ctx, "value.value", check)
+			if err != nil {
+				t.Fatalf("GetArray (local %v): %v", local, err)
+			}
+			if next != n {
+				t.Errorf("GetArray (local %v) passed %d elements, want %d", local, next, n)
+			}
+			m, _ := v.ToMap()["value"].(map[string]interface{})
+			if m["count"] != int32(n) {
+				t.Errorf("GetArray (local %v) returned %v, want the other fields", local, v.ToMap())
+			}
+		}
+
+		next = 0
+		r := channel.ReadArray(ctx, "value.value")
+		for {
+			chunk, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := check(chunk, r.Offset(), r.Len()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		r.Close()
+		if next != n {
+			t.Errorf("ArrayReader (local %v) returned %d elements, want %d", local, next, n)
+		}
+	}
+
+	// A reader closed early leaves the connection usable.
+	c := New(addr)
+	defer c.Close()
+	channel, err := c.Channel(ctx, "waveform")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := channel.ReadArray(ctx, "value.value")
+	if _, err := r.Next(); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	if _, err := channel.Get(ctx); err != nil {
+		t.Errorf("Get after closing an ArrayReader: %v", err)
+	}
+	if _, err := channel.GetArray(ctx, "value.count", check); err == nil {
+		t.Error("GetArray of a scalar field succeeded")
+	}
+}
+
+func TestGetShared(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	frame := make([]pvdata.PVUByte, 1<<20)
+	for i := range frame {
+		frame[i] = pvdata.PVUByte(i)
+	}
+	ch := pvaccess.NewSimpleChannel("image")
+	ch.Set(&struct {
+		Data  []pvdata.PVUByte `pvaccess:"data"`
+		Count pvdata.PVInt     `pvaccess:"count"`
+	}{frame, 1})
+	addr, _ := serve(t, newServer(ch), "127.0.0.1:0")
+
+	c := New(addr)
+	defer c.Close()
+	channel, err := c.Channel(ctx, "image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		v, err := channel.GetShared(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, _ := v.ToMap()["value"].(map[string]interface{})
+		data, ok := m["data"].([]byte)
+		if !ok || len(data) != len(frame) || data[len(data)-1] != byte(len(frame)-1) || m["count"] != int32(1) {
+			t.Fatalf("GetShared returned %T of %d bytes and count %v", m["data"], len(data), m["count"])
+		}
+		v.Release()
+	}
+	if _, err := channel.Get(ctx); err != nil {
+		t.Errorf("Get after GetShared: %v", err)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	// A server that accepts connections and drops them without validating them.
+	bad, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bad.Close()
+	var accepted int32
+	go func() {
+		for {
+			nc, err := bad.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&accepted, 1)
+			nc.Close()
+		}
+	}()
+	ch := pvaccess.NewSimpleChannel("test")
+	x := pvdata.PVInt(1)
+	ch.Set(&x)
+	good, _ := serve(t, newServer(ch), "127.0.0.1:0")
+
+	c := New(bad.Addr().String(), good)
+	c.BreakerThreshold = 2
+	defer c.Close()
+	for i := 0; i < 2; i++ {
+		// Each channel fails to connect to the bad server, and is created on the good one.
+		channel, err := c.Channel(ctx, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		channel.Close()
+	}
+	if _, err := c.conn(ctx, bad.Addr().String()); !errors.Is(err, ErrServerUnhealthy) {
+		t.Errorf("connecting to unhealthy server: %v, want ErrServerUnhealthy", err)
+	}
+	if n := atomic.LoadInt32(&accepted); n != 2 {
+		t.Errorf("bad server accepted %d connections, want 2", n)
+	}
+
+	// Search responses from the unhealthy server are ignored.
+	s := &searcher{client: c, pending: make(map[pvdata.PVUInt]*pendingSearch)}
+	p := &pendingSearch{name: "test", found: make(chan searchResult, 1)}
+	s.pending[1] = p
+	respond := func(addr string) {
+		tcp, err := net.ResolveTCPAddr("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := proto.SearchResponse{Found: true, Protocol: "tcp", ServerPort: pvdata.PVUShort(tcp.Port), SearchInstanceIDs: []pvdata.PVUInt{1}}
+		copy(resp.ServerAddress[:], tcp.IP.To16())
+		s.found(&net.UDPAddr{IP: tcp.IP}, resp)
+	}
+	respond(bad.Addr().String())
+	select {
+	case r := <-p.found:
+		t.Errorf("search found unhealthy server %s", r.addr)
+	default:
+	}
+	respond(good)
+	if r := <-p.found; r.addr != good {
+		t.Errorf("search found %s, want %s", r.addr, good)
+	}
+}
+
+func TestTypeCache(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ch := pvaccess.NewSimpleChannel("test")
+	x := pvdata.PVInt(42)
+	ch.Set(&x)
+	srv := newServer(ch)
+	srv.SetGUID([12]byte{1, 2, 3})
+	addr, _ := serve(t, srv, "127.0.0.1:0")
+
+	c := New(addr)
+	defer c.Close()
+	channel, err := c.Channel(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		// The first Get learns the channel's type; the others pipeline their requests.
+		got, err := channel.Get(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := value(t, got); v != 42 {
+			t.Errorf("Get %d = %d, want 42", i, v)
+		}
+		if _, ok := c.types.lookup(srv.GUID(), "test"); !ok {
+			t.Errorf("after Get %d, type of channel is not cached", i)
+		}
+	}
+}
+
+func TestConnectAll(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv := &pvaccess.Server{DisableSearch: true}
+	names := []string{"a", "b", "c"}
+	for _, name := range names {
+		srv.AddChannelProvider(pvaccess.NewSimpleChannel(name))
+	}
+	addr, _ := serve(t, srv, "127.0.0.1:0")
+
+	c := New(addr)
+	defer c.Close()
+	results := make(map[string]ConnectResult)
+	for r := range c.ConnectAll(ctx, append(names, "missing")) {
+		if _, ok := results[r.Name]; ok {
+			t.Errorf("two results for %q", r.Name)
+		}
+		results[r.Name] = r
+	}
+	for _, name := range names {
+		r := results[name]
+		if r.Err != nil || r.Channel == nil {
+			t.Errorf("%s: %+v, want channel", name, r)
+			continue
+		}
+		if _, ok := r.Channel.ConnectionInfo(); !ok || r.Channel.Name() != name {
+			t.Errorf("%s: channel %q not connected", name, r.Channel.Name())
+		}
+	}
+	if r := results["missing"]; r.Err == nil || r.Channel != nil {
+		t.Errorf("missing: %+v, want error", r)
+	}
+	// All of the channels were requested at once.
+	if n := srv.OpStats()[pvaccess.OpCreateChannel].RequestSize.Count; n != 1 {
+		t.Errorf("server received %d create channel requests, want 1", n)
+	}
+}
+
+// failingProvider fails to create every channel with err.
+type failingProvider struct{ err error }
+
+func (p failingProvider) CreateChannel(ctx context.Context, name string) (pvaccess.Channel, error) {
+	return nil, p.err
+}
+
+func TestCreateFailure(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, tc := range []struct {
+		name             string
+		err              error
+		notFound, denied bool
+		retry            bool
+		after            time.Duration
+		statusType       pvdata.PVByte
+	}{
+		{"missing", nil, true, false, false, 0, pvdata.PVStatus_ERROR},
+		{"not found", fmt.Errorf("no such device: %w", pvaccess.ErrNotFound), true, false, false, 0, pvdata.PVStatus_ERROR},
+		{"denied", pvaccess.ErrPermissionDenied, false, true, false, 0, pvdata.PVStatus_ERROR},
+		{"booting", &pvaccess.RetryableError{Err: errors.New("booting"), After: 2 * time.Second}, false, false, true, 2 * time.Second, pvdata.PVStatus_FATAL},
+		{"broken", errors.New("broken"), false, false, false, 0, pvdata.PVStatus_FATAL},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := &pvaccess.Server{DisableSearch: true}
+			if tc.err != nil {
+				srv.AddChannelProvider(failingProvider{tc.err})
+			}
+			addr, _ := serve(t, srv, "127.0.0.1:0")
+			c := New(addr)
+			c.RetryPolicy = nil
+			defer c.Close()
+			_, err := c.Channel(ctx, "x")
+			if err == nil {
+				t.Fatal("Channel succeeded")
+			}
+			if got := errors.Is(err, pvaccess.ErrNotFound); got != tc.notFound {
+				t.Errorf("errors.Is(%v, ErrNotFound) = %v, want %v", err, got, tc.notFound)
+			}
+			if got := errors.Is(err, pvaccess.ErrPermissionDenied); got != tc.denied {
+				t.Errorf("errors.Is(%v, ErrPermissionDenied) = %v, want %v", err, got, tc.denied)
+			}
+			var retryable *pvaccess.RetryableError
+			if got := errors.As(err, &retryable); got != tc.retry {
+				t.Errorf("errors.As(%v, RetryableError) = %v, want %v", err, got, tc.retry)
+			} else if got && retryable.After != tc.after {
+				t.Errorf("retry after %v, want %v", retryable.After, tc.after)
+			}
+			var status pvdata.PVStatus
+			if !errors.As(err, &status) || status.Type != tc.statusType {
+				t.Errorf("status of %v = %v, want type %v", err, status, tc.statusType)
+			}
+		})
+	}
+}
+
+func TestCreateDeniedStopsSearch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	missing := &pvaccess.Server{DisableSearch: true}
+	missing.AddChannelProvider(failingProvider{pvaccess.ErrNotFound})
+	missingAddr, _ := serve(t, missing, "127.0.0.1:0")
+	denied := &pvaccess.Server{DisableSearch: true}
+	denied.AddChannelProvider(failingProvider{pvaccess.ErrPermissionDenied})
+	deniedAddr, _ := serve(t, denied, "127.0.0.1:0")
+	ch := pvaccess.NewSimpleChannel("x")
+	x := pvdata.PVInt(1)
+	ch.Set(&x)
+	foundAddr, _ := serve(t, newServer(ch), "127.0.0.1:0")
+
+	// A server that doesn't have the channel moves on to the next one.
+	c := New(missingAddr, foundAddr)
+	defer c.Close()
+	if _, err := c.Channel(ctx, "x"); err != nil {
+		t.Errorf("Channel after a server without it: %v", err)
+	}
+	// A server that denies permission ends the search, without retrying.
+	c = New(deniedAddr, foundAddr)
+	defer c.Close()
+	if _, err := c.Channel(ctx, "x"); !errors.Is(err, pvaccess.ErrPermissionDenied) {
+		t.Errorf("Channel after a server denying permission = %v, want ErrPermissionDenied", err)
+	}
+	if n := denied.OpStats()[pvaccess.OpCreateChannel].RequestSize.Count; n != 1 {
+		t.Errorf("denying server received %d create channel requests, want 1", n)
+	}
+}
@@ -0,0 +1,575 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/Lexcelon/go-pvaccess/internal/connection"
+	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/Lexcelon/go-pvaccess/types"
+)
+
+// conn is a connection to one server, shared by all the channels created on it.
+type conn struct {
+	*connection.Connection
+	addr  string
+	nc    net.Conn
+	stats *statsRecorder
+	// serverReceiveBufferSize and serverRegistryMaxSize are announced by the server during connection validation.
+	serverReceiveBufferSize int
+	serverRegistryMaxSize   int
+
+	mu sync.Mutex
+	// handlers receive the responses to each request ID.
+	// They are called from the connection's read loop, and must decode the message before returning.
+	handlers map[pvdata.PVInt]func(msg *connection.Message)
+	// creates receives the response to each pending channel creation, by client channel ID.
+	creates map[pvdata.PVInt]chan proto.CreateChannelResponse
+	// searches receives the answer to each pending search sent over the connection, by search instance ID.
+	searches map[pvdata.PVUInt]chan bool
+	// channels are notified when the connection is lost, by client channel ID.
+	channels map[pvdata.PVInt]*Channel
+	// streamed holds the request IDs of gets whose segmented responses are decoded as their segments arrive.
+	streamed map[pvdata.PVInt]bool
+	// guid identifies the server, once it has answered a search over the connection.
+	guid    [12]byte
+	hasGUID bool
+	err     error
+	done    chan struct{}
+}
+
+// dial opens a connection to addr, resolving its host as by dialTCP, and performs connection validation.
+// If the client has a Compressor, compression is offered to the server.
+// The caller must start the read loop by calling serve.
+func (cl *Client) dial(ctx context.Context, addr string) (*conn, error) {
+	nc, err := cl.dialTCP(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{
+		Connection: connection.New(nc, proto.FLAG_FROM_CLIENT),
+		addr:       addr,
+		nc:         nc,
+		stats:      &cl.stats,
+		handlers:   make(map[pvdata.PVInt]func(msg *connection.Message)),
+		creates:    make(map[pvdata.PVInt]chan proto.CreateChannelResponse),
+		searches:   make(map[pvdata.PVUInt]chan bool),
+		channels:   make(map[pvdata.PVInt]*Channel),
+		streamed:   make(map[pvdata.PVInt]bool),
+		done:       make(chan struct{}),
+	}
+	c.Version = 2
+	c.OnSend = func(command pvdata.PVByte, size int) {
+		cl.stats.sent(addr, size)
+	}
+	if cl.Serializer != nil {
+		// streamSegmented reads request IDs in the binary encoding.
+		c.Serializer = cl.Serializer
+	} else {
+		c.StreamSegmented = c.streamSegmented
+	}
+	if cl.Compressor != nil {
+		threshold := cl.CompressionThreshold
+		if threshold <= 0 {
+			threshold = defaultCompressionThreshold
+		}
+		c.SetCompression(cl.Compressor, threshold)
+	}
+	// Abort the handshake if ctx is cancelled.
+	handshakeDone := make(chan struct{})
+	defer close(handshakeDone)
+	go func() {
+		select {
+		case <-ctx.Done():
+			nc.Close()
+		case <-handshakeDone:
+		}
+	}()
+	if err := c.handshake(ctx); err != nil {
+		nc.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("connecting to %s: %w", addr, err)
+	}
+	if cl.Compressor != nil {
+		// The server replies from its read loop if it supports compression; until then, nothing is compressed.
+		if err := c.OfferCompression(ctx); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	// A search for no channels asks the server to identify itself; its GUID keys the client's type cache.
+	// The response is handled by the read loop, and until it arrives, no types are reused.
+	if err := c.SendApp(ctx, proto.APP_SEARCH_REQUEST, &proto.SearchRequest{
+		Flags:     proto.SEARCH_REPLY_REQUIRED | proto.SEARCH_UNICAST,
+		Protocols: []pvdata.PVString{"tcp"},
+	}); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// serverGUID returns the server's GUID, if it is known.
+func (c *conn) serverGUID() ([12]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.guid, c.hasGUID
+}
+
+// expect reads the next application message, which must have the given command, and decodes it into out.
+func (c *conn) expect(ctx context.Context, command pvdata.PVByte, out interface{}) error {
+	msg, err := c.Next(ctx)
+	if err != nil {
+		return err
+	}
+	c.stats.received(c.addr, len(msg.Data))
+	if msg.Header.MessageCommand != command {
+		return fmt.Errorf("got message command 0x%x, expected 0x%x", msg.Header.MessageCommand, command)
+	}
+	return msg.Decode(out)
+}
+
+func (c *conn) handshake(ctx context.Context) error {
+	var req proto.ConnectionValidationRequest
+	if err := c.expect(ctx, proto.APP_CONNECTION_VALIDATION, &req); err != nil {
+		return fmt.Errorf("reading connection validation request: %w", err)
+	}
+	c.serverReceiveBufferSize = int(req.ServerReceiveBufferSize)
+	c.serverRegistryMaxSize = int(req.ServerIntrospectionRegistryMaxSize)
+	if err := c.SendApp(ctx, proto.APP_CONNECTION_VALIDATION, &proto.ConnectionValidationResponse{
+		ClientReceiveBufferSize:            pvdata.PVInt(c.ReceiveBufferSize()),
+		ClientIntrospectionRegistryMaxSize: 0x7fff,
+		// Bundled messages are split up by Next, so the rest of the client never sees them.
+		ConnectionQos: proto.QOS_MULTIPLE_DATA,
+		AuthNZ:        "anonymous",
+	}); err != nil {
+		return err
+	}
+	var validated proto.ConnectionValidated
+	if err := c.expect(ctx, proto.APP_CONNECTION_VALIDATED, &validated); err != nil {
+		return fmt.Errorf("reading connection validated: %w", err)
+	}
+	return statusError(validated.Status)
+}
+
+// serve reads and dispatches messages until the connection fails, then notifies the channels created on it.
+func (c *conn) serve(ctx context.Context) error {
+	for {
+		msg, err := c.Next(ctx)
+		if err != nil {
+			c.close(err)
+			return err
+		}
+		c.stats.received(c.addr, len(msg.Data))
+		c.dispatch(ctx, msg)
+	}
+}
+
+func (c *conn) dispatch(ctx context.Context, msg *connection.Message) {
+	switch msg.Header.MessageCommand {
+	case proto.APP_CHANNEL_CREATE:
+		var resp proto.CreateChannelResponse
+		if err := msg.Decode(&resp); err != nil {
+			connection.WarnDecode(ctx, err, "decoding create channel response")
+			return
+		}
+		c.mu.Lock()
+		created := c.creates[resp.ClientChannelID]
+		delete(c.creates, resp.ClientChannelID)
+		c.mu.Unlock()
+		if created != nil {
+			created <- resp
+		}
+	case proto.APP_SEARCH_RESPONSE:
+		var resp proto.SearchResponse
+		if err := msg.Decode(&resp); err != nil {
+			connection.WarnDecode(ctx, err, "decoding search response")
+			return
+		}
+		c.mu.Lock()
+		c.guid, c.hasGUID = resp.GUID, true
+		for _, id := range resp.SearchInstanceIDs {
+			if found := c.searches[id]; found != nil {
+				delete(c.searches, id)
+				found <- bool(resp.Found)
+			}
+		}
+		c.mu.Unlock()
+	case proto.APP_CHANNEL_DESTROY:
+		var req proto.DestroyChannel
+		if err := msg.Decode(&req); err != nil {
+			connection.WarnDecode(ctx, err, "decoding channel destroy")
+			return
+		}
+		// Channels destroyed by the client are already forgotten; any other was destroyed by the server,
+		// e.g. because it is shutting down, and must be created again.
+		c.mu.Lock()
+		ch := c.channels[req.ClientChannelID]
+		delete(c.channels, req.ClientChannelID)
+		c.mu.Unlock()
+		if ch != nil {
+			ctxlog.L(ctx).Infof("channel %q destroyed by server", ch.name)
+			ch.disconnected(c, ErrChannelDestroyed)
+		}
+	case proto.APP_CHANNEL_GET, proto.APP_CHANNEL_PUT, proto.APP_CHANNEL_RPC, proto.APP_CHANNEL_MONITOR:
+		var id pvdata.PVInt
+		if err := msg.Peek(&id); err != nil {
+			connection.WarnDecode(ctx, err, "decoding request ID")
+			return
+		}
+		c.mu.Lock()
+		handler := c.handlers[id]
+		c.mu.Unlock()
+		if handler == nil {
+			ctxlog.L(ctx).Debugf("ignoring message 0x%x for unknown request %d", msg.Header.MessageCommand, id)
+			return
+		}
+		handler(msg)
+	default:
+		ctxlog.L(ctx).Debugf("ignoring message 0x%x", msg.Header.MessageCommand)
+	}
+}
+
+// close closes the connection, if it is not already closed, and tells every channel on it that it was lost because of err.
+func (c *conn) close(err error) {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return
+	}
+	if err == nil {
+		err = ErrClosed
+	}
+	c.err = err
+	close(c.done)
+	channels := c.channels
+	c.channels = nil
+	c.mu.Unlock()
+	c.Connection.Close()
+	for _, ch := range channels {
+		ch.disconnected(c, err)
+	}
+}
+
+// SendApp sends an application message on the connection.
+// Once the connection has been closed, it returns the error that closed it.
+func (c *conn) SendApp(ctx context.Context, messageCommand pvdata.PVByte, payload interface{}) error {
+	err := c.Connection.SendApp(ctx, messageCommand, payload)
+	if errors.Is(err, connection.ErrConnectionClosed) {
+		if closed := c.closedErr(); closed != nil {
+			return closed
+		}
+	}
+	return err
+}
+
+// closedErr returns the error that closed the connection, or nil if it is still open.
+func (c *conn) closedErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// setHandler registers handler for responses with the given request ID, replacing any existing handler.
+func (c *conn) setHandler(id pvdata.PVInt, handler func(msg *connection.Message)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	c.handlers[id] = handler
+	return nil
+}
+
+func (c *conn) removeHandler(id pvdata.PVInt) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.handlers, id)
+}
+
+// setStreamed sets whether segmented responses to the get with the given request ID are decoded as their
+// segments arrive, instead of once they have all been received.
+func (c *conn) setStreamed(id pvdata.PVInt, streamed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if streamed {
+		c.streamed[id] = true
+	} else {
+		delete(c.streamed, id)
+	}
+}
+
+// streamSegmented reports whether the segmented message whose first segment is first answers a streamed get.
+func (c *conn) streamSegmented(header proto.PVAccessHeader, first []byte) bool {
+	if header.MessageCommand != proto.APP_CHANNEL_GET || len(first) < 4 {
+		return false
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if header.Flags&proto.FLAG_BO_BE != 0 {
+		order = binary.BigEndian
+	}
+	id := pvdata.PVInt(order.Uint32(first))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.streamed[id]
+}
+
+// roundTrip sends req and waits for the response with the given request ID, which is passed to decode.
+// decode runs in the connection's read loop.
+// If ctx is done first, the request is cancelled on the server (see cancelRequest) and ctx.Err() is returned.
+func (c *conn) roundTrip(ctx context.Context, sid, id pvdata.PVInt, command pvdata.PVByte, req interface{}, decode func(msg *connection.Message) error) error {
+	done := make(chan error, 1)
+	if err := c.setHandler(id, func(msg *connection.Message) {
+		err := decode(msg)
+		select {
+		case done <- err:
+		default:
+			// A response already arrived; the caller has it.
+		}
+	}); err != nil {
+		return err
+	}
+	defer c.removeHandler(id)
+	if err := c.SendApp(ctx, command, req); err != nil {
+		return err
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		c.cancelRequest(ctx, sid, id)
+		return ctx.Err()
+	case <-c.done:
+		return c.err
+	}
+}
+
+// pipeline sends reqs, all for the request with the given ID, without waiting for each response before sending
+// the next. The server must answer each in turn; decode is passed the index of the request each response answers.
+// pipeline returns once every response has been decoded, or the first error.
+// Like roundTrip, it cancels the request on the server if ctx is done first.
+func (c *conn) pipeline(ctx context.Context, sid, id pvdata.PVInt, command pvdata.PVByte, reqs []interface{}, decode func(i int, msg *connection.Message) error) error {
+	done := make(chan error, 1)
+	i := 0
+	if err := c.setHandler(id, func(msg *connection.Message) {
+		if i == len(reqs) {
+			return
+		}
+		err := decode(i, msg)
+		if i++; err == nil && i < len(reqs) {
+			return
+		}
+		// Ignore the responses to the rest of the requests.
+		i = len(reqs)
+		done <- err
+	}); err != nil {
+		return err
+	}
+	defer c.removeHandler(id)
+	for _, req := range reqs {
+		if err := c.SendApp(ctx, command, req); err != nil {
+			return err
+		}
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		c.cancelRequest(ctx, sid, id)
+		return ctx.Err()
+	case <-c.done:
+		return c.err
+	}
+}
+
+// createChannel creates the channel name on the server as client channel ch.id and returns its server channel ID.
+// ch is notified if the connection is lost.
+func (c *conn) createChannel(ctx context.Context, ch *Channel) (pvdata.PVInt, error) {
+	r := c.createChannels(ctx, []*Channel{ch})[0]
+	return r.sid, r.err
+}
+
+// maxCreateBatch is the most channels created by one request.
+const maxCreateBatch = 256
+
+// createResult is the outcome of creating one channel.
+type createResult struct {
+	sid pvdata.PVInt
+	err error
+}
+
+// createChannels creates all of chs on the server, sending as few requests as possible,
+// and returns the result for each. Channels that are created are notified if the connection is lost.
+func (c *conn) createChannels(ctx context.Context, chs []*Channel) []createResult {
+	results := make([]createResult, len(chs))
+	// answered records which channels the server has responded about.
+	answered := make([]bool, len(chs))
+	fail := func(err error) []createResult {
+		for i := range results {
+			if !answered[i] {
+				results[i].err = err
+			}
+		}
+		return results
+	}
+	created := make(chan proto.CreateChannelResponse, len(chs))
+	index := make(map[pvdata.PVInt]int, len(chs))
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return fail(c.err)
+	}
+	for i, ch := range chs {
+		index[ch.id] = i
+		c.creates[ch.id] = created
+	}
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		for _, ch := range chs {
+			delete(c.creates, ch.id)
+		}
+		c.mu.Unlock()
+	}()
+	for start := 0; start < len(chs); start += maxCreateBatch {
+		end := start + maxCreateBatch
+		if end > len(chs) {
+			end = len(chs)
+		}
+		req := &proto.CreateChannelRequest{}
+		for _, ch := range chs[start:end] {
+			req.Channels = append(req.Channels, proto.CreateChannelRequest_Channel{ClientChannelID: ch.id, ChannelName: ch.name})
+		}
+		if err := c.SendApp(ctx, proto.APP_CHANNEL_CREATE, req); err != nil {
+			return fail(err)
+		}
+	}
+	for range chs {
+		var resp proto.CreateChannelResponse
+		select {
+		case resp = <-created:
+		case <-ctx.Done():
+			return fail(ctx.Err())
+		case <-c.done:
+			return fail(c.err)
+		}
+		i, ok := index[resp.ClientChannelID]
+		if !ok {
+			continue
+		}
+		answered[i] = true
+		if err := createError(resp.Status); err != nil {
+			results[i].err = err
+			continue
+		}
+		c.mu.Lock()
+		if c.err != nil {
+			results[i].err = c.err
+		} else {
+			c.channels[resp.ClientChannelID] = chs[i]
+			results[i].sid = resp.ServerChannelID
+		}
+		c.mu.Unlock()
+	}
+	return results
+}
+
+// search asks the server whether it has the channel name, using id as the search instance ID.
+func (c *conn) search(ctx context.Context, id pvdata.PVUInt, name string) (bool, error) {
+	found := make(chan bool, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return false, c.err
+	}
+	c.searches[id] = found
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.searches, id)
+		c.mu.Unlock()
+	}()
+	// Servers only answer searches for channels they don't have if a reply is required.
+	if err := c.SendApp(ctx, proto.APP_SEARCH_REQUEST, &proto.SearchRequest{
+		SearchSequenceID: id,
+		Flags:            proto.SEARCH_REPLY_REQUIRED | proto.SEARCH_UNICAST,
+		Protocols:        []pvdata.PVString{"tcp"},
+		Channels:         []proto.SearchRequest_Channel{{SearchInstanceID: id, ChannelName: name}},
+	}); err != nil {
+		return false, err
+	}
+	select {
+	case ok := <-found:
+		return ok, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case <-c.done:
+		return false, c.err
+	}
+}
+
+// destroyChannel destroys the channel on the server and stops notifying ch about the connection.
+func (c *conn) destroyChannel(ctx context.Context, ch *Channel, sid pvdata.PVInt) error {
+	c.mu.Lock()
+	delete(c.channels, ch.id)
+	c.mu.Unlock()
+	return c.SendApp(ctx, proto.APP_CHANNEL_DESTROY, &proto.DestroyChannel{
+		ServerChannelID: sid,
+		ClientChannelID: ch.id,
+	})
+}
+
+// destroyRequest tells the server to forget about a request.
+// cancelRequest tells the server to stop the operation in progress on request id, whose caller has given up
+// because its context is done, e.g. because its deadline passed. The request is destroyed too,
+// since nothing is waiting for its responses any more.
+func (c *conn) cancelRequest(ctx context.Context, sid, id pvdata.PVInt) {
+	ctx = ctxlog.Detach(ctx)
+	if err := c.SendApp(ctx, proto.APP_REQUEST_CANCEL, &proto.CancelDestroyRequest{
+		ServerChannelID: sid,
+		RequestID:       id,
+	}); err != nil {
+		ctxlog.L(ctx).Debugf("cancelling request %d: %v", id, err)
+		return
+	}
+	c.destroyRequest(ctx, sid, id)
+}
+
+func (c *conn) destroyRequest(ctx context.Context, sid, id pvdata.PVInt) error {
+	c.removeHandler(id)
+	return c.SendApp(ctx, proto.APP_REQUEST_DESTROY, &proto.CancelDestroyRequest{
+		ServerChannelID: sid,
+		RequestID:       id,
+	})
+}
+
+func (c *conn) info() types.ConnectionInfo {
+	h := c.Health()
+	version := c.Version
+	if h.PeerVersion < version {
+		version = h.PeerVersion
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return types.ConnectionInfo{
+		LocalAddr:                    c.nc.LocalAddr(),
+		RemoteAddr:                   c.nc.RemoteAddr(),
+		Version:                      version,
+		ReceiveBufferSize:            c.serverReceiveBufferSize,
+		IntrospectionRegistryMaxSize: c.serverRegistryMaxSize,
+		AuthNZ:                       "anonymous",
+		Established:                  h.Established,
+		LastActivity:                 h.LastActivity,
+		RTT:                          h.RTT,
+		Channels:                     len(c.channels),
+		Compressed:                   c.Compressed(),
+	}
+}
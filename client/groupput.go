@@ -0,0 +1,81 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+// GroupPut puts values to several channels at once, by channel name: either every value is put, or none is.
+// Each value can be anything that can be encoded as a structure, and should have the structure of the channel's Get,
+// e.g. &pvdata.NTScalar{Value: 1.5}.
+//
+// The channels must all be served by the same server, by a provider that supports group puts (see pvaccess.GroupPuter).
+// The server is the one that has the first channel, in name order. Remote servers must have been created with pvaccess.NewServer.
+func (c *Client) GroupPut(ctx context.Context, values map[string]interface{}) error {
+	if len(values) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	structures := make(map[string]pvdata.PVStructure, len(values))
+	fields := make(map[string]interface{}, len(values))
+	for i, name := range names {
+		pvs, err := pvdata.NewPVStructure(values[name])
+		if err != nil {
+			return fmt.Errorf("value for channel %q: %w", name, err)
+		}
+		structures[name] = pvs
+		fields[fmt.Sprintf("v%d", i)] = pvs
+	}
+	for _, srv := range c.LocalServers {
+		lc, err := srv.LocalChannel(ctx, names[0])
+		if err != nil {
+			return err
+		}
+		if lc != nil {
+			lc.Close()
+			return srv.GroupPut(ctx, structures)
+		}
+	}
+	ch, err := c.Channel(ctx, names[0])
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+	cn, _, err := ch.connection(ctx)
+	if err != nil {
+		return err
+	}
+	// Group puts are performed by the RPC service of the server's "server" channel.
+	sch := c.newChannel("server")
+	sid, err := cn.createChannel(ctx, sch)
+	if err != nil {
+		return fmt.Errorf("creating server channel: %w", err)
+	}
+	if err := sch.created(ctx, cn, sid); err != nil {
+		return err
+	}
+	defer sch.Close()
+	valuesStruct, err := pvdata.NewPVStructureFromMap("", fields)
+	if err != nil {
+		return err
+	}
+	args, err := pvdata.NewPVStructureFromMap("", map[string]interface{}{
+		"op":       "groupPut",
+		"channels": names,
+		"values":   valuesStruct,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := sch.RPC(ctx, args); err != nil {
+		return fmt.Errorf("group put: %w", err)
+	}
+	return nil
+}
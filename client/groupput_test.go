@@ -0,0 +1,103 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+	"github.com/Lexcelon/go-pvaccess/provider/memory"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+type groupValue struct {
+	Value interface{} `pvaccess:"value"`
+}
+
+// missingGroupPuter supports group puts, but has no channels.
+type missingGroupPuter struct{}
+
+func (missingGroupPuter) CreateChannel(ctx context.Context, name string) (pvaccess.Channel, error) {
+	return nil, fmt.Errorf("%w: %q", pvaccess.ErrNotFound, name)
+}
+
+func (missingGroupPuter) GroupPut(ctx context.Context, values map[string]pvdata.PVStructure) error {
+	return errors.New("group put to provider without channels")
+}
+
+func TestGroupPut(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	p := memory.New(nil)
+	x, y := pvdata.PVDouble(0), pvdata.PVDouble(0)
+	mode := pvdata.Enum{Choices: []string{"off", "on"}}
+	for _, pv := range []memory.PV{
+		{Name: "x", Value: &x},
+		{Name: "y", Value: &y},
+		{Name: "mode", Value: &mode},
+	} {
+		if err := p.Add(pv); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The "server" channel, which performs group puts, is added by NewServer.
+	srv, err := pvaccess.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.DisableSearch = true
+	// Providers that don't have the channels are skipped, however they say so.
+	srv.AddChannelProvider(missingGroupPuter{})
+	srv.AddChannelProvider(p)
+	other := pvaccess.NewSimpleChannel("other")
+	other.Set(&x)
+	srv.AddChannelProvider(other)
+	addr, _ := serve(t, srv, "127.0.0.1:0")
+
+	check := func(t *testing.T, wantX, wantY float64) {
+		t.Helper()
+		for name, want := range map[string]float64{"x": wantX, "y": wantY} {
+			v, _ := p.Get(name)
+			if got := float64(*v.(*pvdata.PVDouble)); got != want {
+				t.Errorf("%s = %v, want %v", name, got, want)
+			}
+		}
+	}
+	local := New()
+	local.LocalServers = []*pvaccess.Server{srv}
+	for _, c := range []*Client{New(addr), local} {
+		t.Run("", func(t *testing.T) {
+			defer c.Close()
+			if err := c.GroupPut(ctx, map[string]interface{}{
+				"x": &groupValue{pvdata.PVDouble(1)},
+				"y": &groupValue{pvdata.PVDouble(2)},
+			}); err != nil {
+				t.Fatal(err)
+			}
+			check(t, 1, 2)
+			// The out of range enum index fails the whole put.
+			if err := c.GroupPut(ctx, map[string]interface{}{
+				"x":    &groupValue{pvdata.PVDouble(3)},
+				"mode": &groupValue{&pvdata.Enum{Index: 5}},
+			}); err == nil {
+				t.Error("group put with invalid value succeeded")
+			}
+			check(t, 1, 2)
+			if err := c.GroupPut(ctx, map[string]interface{}{
+				"x":     &groupValue{pvdata.PVDouble(4)},
+				"other": &groupValue{pvdata.PVDouble(4)},
+			}); err == nil {
+				t.Error("group put across providers succeeded")
+			}
+			if err := c.GroupPut(ctx, map[string]interface{}{
+				"x": &groupValue{pvdata.PVDouble(0)},
+				"y": &groupValue{pvdata.PVDouble(0)},
+			}); err != nil {
+				t.Fatal(err)
+			}
+			check(t, 0, 0)
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package client
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/fnv"
+	"sync"
+
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+// typeCache remembers the types of channels' values, by server GUID, so that operations on a channel whose type
+// is already known don't need to wait for the server to describe it before asking for the value.
+// It survives reconnections, and servers that restart keep their types only if they keep their GUID.
+// Channels whose values have the same type share one FieldDesc.
+type typeCache struct {
+	mu sync.Mutex
+	// channels holds the hash of each channel's type, by server GUID and channel name.
+	channels map[[12]byte]map[string]uint64
+	// types holds each type by hash.
+	types map[uint64]pvdata.FieldDesc
+}
+
+// typeHash returns a hash of the encoded form of fd.
+func typeHash(fd pvdata.FieldDesc) (uint64, error) {
+	var buf bytes.Buffer
+	if err := pvdata.Encode(&pvdata.EncoderState{Buf: &buf, ByteOrder: binary.LittleEndian}, &fd); err != nil {
+		return 0, err
+	}
+	h := fnv.New64a()
+	h.Write(buf.Bytes())
+	return h.Sum64(), nil
+}
+
+// lookup returns the type of the channel name on the server with the given GUID, if it is known.
+func (tc *typeCache) lookup(guid [12]byte, name string) (pvdata.FieldDesc, bool) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	hash, ok := tc.channels[guid][name]
+	if !ok {
+		return pvdata.FieldDesc{}, false
+	}
+	return tc.types[hash], true
+}
+
+// store records that the channel name on the server with the given GUID has type fd.
+// It returns the cached FieldDesc equal to fd, which may be shared with other channels.
+func (tc *typeCache) store(guid [12]byte, name string, fd pvdata.FieldDesc) pvdata.FieldDesc {
+	hash, err := typeHash(fd)
+	if err != nil {
+		return fd
+	}
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if tc.channels == nil {
+		tc.channels = make(map[[12]byte]map[string]uint64)
+		tc.types = make(map[uint64]pvdata.FieldDesc)
+	}
+	if tc.channels[guid] == nil {
+		tc.channels[guid] = make(map[string]uint64)
+	}
+	tc.channels[guid][name] = hash
+	if cached, ok := tc.types[hash]; ok {
+		return cached
+	}
+	tc.types[hash] = fd
+	return fd
+}
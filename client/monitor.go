@@ -0,0 +1,486 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+	"github.com/Lexcelon/go-pvaccess/internal/connection"
+	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+// EventKind identifies the kind of an Event.
+type EventKind int
+
+const (
+	// Update events carry a new value from the server.
+	Update EventKind = iota
+	// Disconnected events report that the monitor's connection was lost.
+	// No updates are delivered until the channel reconnects.
+	Disconnected
+	// Reconnected events report that the monitor was re-created, with its original pvRequest, on a new connection.
+	// The next Update is a full update.
+	Reconnected
+)
+
+var eventKindNames = map[EventKind]string{
+	Update:       "Update",
+	Disconnected: "Disconnected",
+	Reconnected:  "Reconnected",
+}
+
+func (k EventKind) String() string {
+	if name, ok := eventKindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("EventKind(%d)", int(k))
+}
+
+// Event is delivered by a Monitor.
+type Event struct {
+	Kind EventKind
+	// Value is the new value, for Update events.
+	// Only the fields marked in Changed were sent by the server, unless Full is true.
+	Value pvdata.PVStructure
+	// Changed and Overrun are the bitsets sent by the server with an update, or their union for updates merged
+	// because the monitor's queue was full (see Client.MonitorQueueSize).
+	Changed, Overrun pvdata.PVBitSet
+	// ChangedFields names the fields marked in Changed by their paths, e.g. "value" or "alarm.severity",
+	// as by pvdata.ChangedFields. A changed structure is named instead of its fields, and full updates name
+	// every top-level field. See FieldChanged.
+	ChangedFields []string
+	// Full is true if Value holds every field of the structure,
+	// as for the first update after the monitor is created or reconnected.
+	Full bool
+	// Err is the reason for a Disconnected event.
+	Err error
+}
+
+// FieldChanged reports whether the field at path, e.g. "alarm" or "alarm.severity", changed in the update:
+// whether it, a field within it, or the structure containing it is one of ChangedFields.
+func (e Event) FieldChanged(path string) bool {
+	for _, f := range e.ChangedFields {
+		if f == path || strings.HasPrefix(path, f+".") || strings.HasPrefix(f, path+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// topLevelFields returns the names of the top-level fields of the structure described by fd.
+func topLevelFields(fd pvdata.FieldDesc) []string {
+	var fields []string
+	for _, f := range fd.Fields {
+		fields = append(fields, f.Name)
+	}
+	return fields
+}
+
+// Monitor receives updates to a channel's value.
+// It is re-created automatically when its channel reconnects.
+type Monitor struct {
+	ch *Channel
+	// request is the original pvRequest, which is sent again when the monitor is re-created.
+	request pvdata.PVAny
+	events  chan Event
+	stop    chan struct{}
+	// wake is signalled when an event is queued.
+	wake chan struct{}
+
+	mu sync.Mutex
+	// conn, sid, and id identify the monitor request on the server; conn is nil while disconnected.
+	conn    *conn
+	sid, id pvdata.PVInt
+	fd      pvdata.FieldDesc
+	// only, if non-empty, lists the fields that updates must change to be delivered, as for MonitorFields.
+	only []string
+	// full is true until the first update after the monitor is (re-)created.
+	full bool
+	// limit is the number of events queued before updates are merged, or 0 for no limit.
+	limit  int
+	queue  []Event
+	closed bool
+	err    error
+}
+
+// Monitor starts monitoring the channel. pvRequest selects the fields to monitor; if it is nil, an empty request is sent.
+// The channel's current value is delivered as the first, full, update.
+func (ch *Channel) Monitor(ctx context.Context, pvRequest interface{}) (*Monitor, error) {
+	return ch.monitor(ctx, pvRequest, nil)
+}
+
+// MonitorFields is like Monitor, but only delivers the updates that change one of fields, as reported by
+// Event.FieldChanged, such as "alarm" and "timeStamp" for alarm servers that watch for changes of a PV's metadata
+// rather than its value, like camonitor -m. Full updates and the other kinds of events are always delivered.
+func (ch *Channel) MonitorFields(ctx context.Context, pvRequest interface{}, fields ...string) (*Monitor, error) {
+	if len(fields) == 0 {
+		return nil, errors.New("no fields to monitor")
+	}
+	return ch.monitor(ctx, pvRequest, fields)
+}
+
+func (ch *Channel) monitor(ctx context.Context, pvRequest interface{}, only []string) (*Monitor, error) {
+	request, err := newRequest(pvRequest)
+	if err != nil {
+		return nil, err
+	}
+	m := &Monitor{
+		ch:      ch,
+		request: request,
+		only:    only,
+		limit:   ch.client.monitorQueueSize(),
+		events:  make(chan Event),
+		stop:    make(chan struct{}),
+		wake:    make(chan struct{}, 1),
+	}
+	// Register first, so that the monitor is resumed if the connection is lost while it is being created.
+	ch.mu.Lock()
+	if ch.closed {
+		ch.mu.Unlock()
+		return nil, ErrClosed
+	}
+	ch.monitors[m] = struct{}{}
+	lc := ch.local
+	ch.mu.Unlock()
+	if lc != nil {
+		err = m.startLocal(ctx, lc)
+	} else {
+		var cn *conn
+		var sid pvdata.PVInt
+		if cn, sid, err = ch.connection(ctx); err == nil {
+			err = m.start(ctx, cn, sid, false)
+		}
+	}
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+	go m.deliver()
+	return m, nil
+}
+
+// Events returns the channel on which events are delivered.
+// Events are queued until they are received, so the channel should be drained promptly:
+// once Client.MonitorQueueSize events are queued, updates are merged into the last one queued.
+// It is closed when the monitor is closed.
+func (m *Monitor) Events() <-chan Event {
+	return m.events
+}
+
+// Err returns the error that caused the monitor to stop, or nil if it is running or was closed by Close.
+func (m *Monitor) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
+
+// Close stops the monitor and closes its Events channel.
+func (m *Monitor) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	close(m.stop)
+	cn, sid, id := m.conn, m.sid, m.id
+	m.mu.Unlock()
+	m.ch.mu.Lock()
+	delete(m.ch.monitors, m)
+	m.ch.mu.Unlock()
+	if cn == nil {
+		return nil
+	}
+	return cn.destroyRequest(m.ch.client.ctx, sid, id)
+}
+
+// fail stops the monitor because of err.
+func (m *Monitor) fail(err error) {
+	m.mu.Lock()
+	if m.err == nil {
+		m.err = err
+	}
+	m.mu.Unlock()
+	m.Close()
+}
+
+// start creates the monitor request on cn and starts the subscription.
+// If resumed is true, a Reconnected event is queued before the first update.
+func (m *Monitor) start(ctx context.Context, cn *conn, sid pvdata.PVInt, resumed bool) error {
+	id := m.ch.client.newID()
+	var fd pvdata.FieldDesc
+	if err := cn.roundTrip(ctx, sid, id, proto.APP_CHANNEL_MONITOR, &proto.ChannelMonitorRequest{
+		ServerChannelID: sid,
+		RequestID:       id,
+		Subcommand:      proto.CHANNEL_MONITOR_INIT,
+		PVRequest:       m.request,
+	}, func(msg *connection.Message) error {
+		var resp proto.ChannelMonitorResponseInit
+		if err := msg.Decode(&resp); err != nil {
+			return err
+		}
+		fd = resp.PVStructureIF
+		return statusError(resp.Status)
+	}); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return cn.destroyRequest(ctx, sid, id)
+	}
+	m.conn, m.sid, m.id, m.fd, m.full = cn, sid, id, fd, true
+	m.mu.Unlock()
+	if resumed {
+		m.push(Event{Kind: Reconnected})
+	}
+	if err := cn.setHandler(id, m.handle); err != nil {
+		return err
+	}
+	return cn.SendApp(ctx, proto.APP_CHANNEL_MONITOR, &proto.ChannelMonitorRequest{
+		ServerChannelID: sid,
+		RequestID:       id,
+		Subcommand:      proto.CHANNEL_MONITOR_SUBSCRIPTION | proto.CHANNEL_MONITOR_SUBSCRIPTION_RUN,
+	})
+}
+
+// startLocal starts the monitor on a local channel, and delivers its values until the monitor is closed.
+// Every update is full, because it carries the provider's value itself.
+func (m *Monitor) startLocal(ctx context.Context, lc *pvaccess.LocalChannel) error {
+	req, ok := m.request.Data.(pvdata.PVStructure)
+	if !ok {
+		return fmt.Errorf("pvRequest is %T, expected PVStructure", m.request.Data)
+	}
+	nexter, err := lc.Monitor(ctx, req)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(m.ch.client.ctx)
+	go func() {
+		defer cancel()
+		select {
+		case <-m.stop:
+		case <-ctx.Done():
+		}
+	}()
+	go func() {
+		for {
+			value, err := nexter.Next(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					m.fail(err)
+				}
+				return
+			}
+			pvs, err := pvdata.NewPVStructure(value)
+			if err != nil {
+				m.fail(err)
+				return
+			}
+			fd, err := pvs.FieldDesc()
+			if err != nil {
+				m.fail(err)
+				return
+			}
+			m.push(Event{Kind: Update, Value: pvs, ChangedFields: topLevelFields(fd), Full: true})
+		}
+	}()
+	return nil
+}
+
+// resume re-creates the monitor after its channel has reconnected.
+func (m *Monitor) resume(ctx context.Context) {
+	m.mu.Lock()
+	closed := m.closed
+	m.mu.Unlock()
+	if closed {
+		return
+	}
+	cn, sid, err := m.ch.connection(ctx)
+	if err == nil {
+		err = m.start(ctx, cn, sid, true)
+	}
+	if err == nil || ctx.Err() != nil {
+		return
+	}
+	if cn != nil && cn.closedErr() != nil {
+		// The new connection was lost as well; the monitor is resumed again when the channel next reconnects.
+		return
+	}
+	ctxlog.L(ctx).Warnf("resuming monitor on %q: %v", m.ch.name, err)
+	m.fail(err)
+}
+
+// disconnected is called when the monitor's connection is lost.
+func (m *Monitor) disconnected(err error) {
+	m.mu.Lock()
+	m.conn = nil
+	m.mu.Unlock()
+	m.push(Event{Kind: Disconnected, Err: err})
+}
+
+// handle decodes a monitor update. It is called from the connection's read loop.
+func (m *Monitor) handle(msg *connection.Message) {
+	var head struct {
+		RequestID  pvdata.PVInt
+		Subcommand pvdata.PVByte
+	}
+	if err := msg.Peek(&head); err != nil {
+		connection.WarnDecode(m.ch.client.ctx, err, "decoding monitor response")
+		return
+	}
+	if head.Subcommand != 0 {
+		// Only errors and the final response have a subcommand.
+		var resp proto.ChannelResponseError
+		if err := msg.Decode(&resp); err == nil {
+			if err := statusError(resp.Status); err != nil {
+				go m.fail(err)
+			}
+		}
+		return
+	}
+	m.mu.Lock()
+	fd := m.fd
+	m.mu.Unlock()
+	value, err := zeroStructure(fd)
+	if err != nil {
+		ctxlog.L(m.ch.client.ctx).Warnf("monitor on %q: %v", m.ch.name, err)
+		return
+	}
+	resp := proto.ChannelMonitorResponse{Value: pvdata.PVStructureDiff{Value: value}}
+	if err := msg.Decode(&resp); err != nil {
+		connection.WarnDecode(m.ch.client.ctx, err, "decoding monitor update on %q", m.ch.name)
+		return
+	}
+	m.ch.client.stats.monitorUpdate(m.ch.name, m.ch.client.clock().Now())
+	m.mu.Lock()
+	full := m.full || resp.Value.ChangedBitSet.Get(0)
+	m.full = false
+	m.mu.Unlock()
+	fields := topLevelFields(fd)
+	if !full {
+		fields, _ = pvdata.ChangedFields(fd, resp.Value.ChangedBitSet)
+	}
+	m.push(Event{
+		Kind:          Update,
+		Value:         value,
+		Changed:       resp.Value.ChangedBitSet,
+		Overrun:       resp.OverrunBitSet,
+		ChangedFields: fields,
+		Full:          full,
+	})
+}
+
+// wanted reports whether the update e changes one of the fields the monitor delivers updates for.
+func (m *Monitor) wanted(e Event) bool {
+	if len(m.only) == 0 {
+		return true
+	}
+	for _, path := range m.only {
+		if e.FieldChanged(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// push queues e for delivery.
+func (m *Monitor) push(e Event) {
+	m.mu.Lock()
+	if m.closed || e.Kind == Update && !e.Full && !m.wanted(e) {
+		m.mu.Unlock()
+		return
+	}
+	if n := len(m.queue); m.limit > 0 && n >= m.limit && e.Kind == Update && m.queue[n-1].Kind == Update {
+		m.queue[n-1] = m.merge(m.queue[n-1], e)
+	} else {
+		m.queue = append(m.queue, e)
+	}
+	m.mu.Unlock()
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// merge combines the queued update prev with the later update e, for when the queue is full.
+// It is called with m.mu held.
+func (m *Monitor) merge(prev, e Event) Event {
+	overrun := orBits(prev.Overrun, orBits(e.Overrun, andBits(prev.Changed, e.Changed)))
+	if e.Full {
+		// A full update replaces every field, including those prev changed.
+		e.Overrun = orBits(overrun, prev.Changed)
+		return e
+	}
+	// Updates decoded from the network have values of their own, so prev's may be overwritten.
+	if err := prev.Value.CopyFields(e.Value, e.ChangedFields); err != nil {
+		ctxlog.L(m.ch.client.ctx).Warnf("monitor on %q: dropping update that can't be merged: %v", m.ch.name, err)
+		e.Overrun = overrun
+		return e
+	}
+	merged := Event{
+		Kind:    Update,
+		Value:   prev.Value,
+		Changed: orBits(prev.Changed, e.Changed),
+		Overrun: overrun,
+		Full:    prev.Full,
+	}
+	if merged.Full {
+		merged.ChangedFields = topLevelFields(m.fd)
+	} else {
+		merged.ChangedFields, _ = pvdata.ChangedFields(m.fd, merged.Changed)
+	}
+	return merged
+}
+
+// orBits returns the bits set in either a or b.
+func orBits(a, b pvdata.PVBitSet) pvdata.PVBitSet {
+	if len(a.Present) < len(b.Present) {
+		a, b = b, a
+	}
+	bs := pvdata.PVBitSet{Present: append([]bool(nil), a.Present...)}
+	for i, present := range b.Present {
+		bs.Present[i] = bs.Present[i] || present
+	}
+	return bs
+}
+
+// andBits returns the bits set in both a and b.
+func andBits(a, b pvdata.PVBitSet) pvdata.PVBitSet {
+	var bs pvdata.PVBitSet
+	for i, present := range a.Present {
+		bs.Present = append(bs.Present, present && b.Get(i))
+	}
+	return bs
+}
+
+// deliver sends queued events on m.events until the monitor is closed.
+func (m *Monitor) deliver() {
+	defer close(m.events)
+	for {
+		m.mu.Lock()
+		if len(m.queue) == 0 {
+			m.mu.Unlock()
+			select {
+			case <-m.wake:
+				continue
+			case <-m.stop:
+				return
+			}
+		}
+		e := m.queue[0]
+		m.queue[0] = Event{}
+		m.queue = m.queue[1:]
+		m.mu.Unlock()
+		select {
+		case m.events <- e:
+		case <-m.stop:
+			return
+		}
+	}
+}
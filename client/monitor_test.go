@@ -0,0 +1,168 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFieldChanged(t *testing.T) {
+	e := Event{Kind: Update, ChangedFields: []string{"value", "alarm.severity", "timeStamp"}}
+	for _, test := range []struct {
+		path string
+		want bool
+	}{
+		{"value", true},
+		{"alarm", true},
+		{"alarm.severity", true},
+		{"alarm.message", false},
+		{"timeStamp.nanoseconds", true},
+		{"display", false},
+		{"val", false},
+	} {
+		if got := e.FieldChanged(test.path); got != test.want {
+			t.Errorf("FieldChanged(%q) = %v, want %v", test.path, got, test.want)
+		}
+	}
+}
+
+func TestMonitorFieldsFilter(t *testing.T) {
+	m := &Monitor{only: []string{"alarm", "timeStamp"}, wake: make(chan struct{}, 1)}
+	for _, e := range []Event{
+		{Kind: Update, ChangedFields: []string{"value", "alarm", "timeStamp"}, Full: true},
+		{Kind: Update, ChangedFields: []string{"value"}},
+		{Kind: Update, ChangedFields: []string{"value", "alarm.severity"}},
+		{Kind: Disconnected},
+		{Kind: Update, ChangedFields: []string{"timeStamp.userTag"}},
+	} {
+		m.push(e)
+	}
+	var got [][]string
+	for _, e := range m.queue {
+		got = append(got, e.ChangedFields)
+	}
+	want := [][]string{
+		{"value", "alarm", "timeStamp"},
+		{"value", "alarm.severity"},
+		nil,
+		{"timeStamp.userTag"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("delivered events differ (-want +got):\n%s", diff)
+	}
+}
+
+func TestMonitorQueueLimit(t *testing.T) {
+	fd, err := pvdata.FieldDescOf(&struct {
+		A pvdata.PVInt `pvaccess:"a"`
+		B pvdata.PVInt `pvaccess:"b"`
+		C pvdata.PVInt `pvaccess:"c"`
+	}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Monitor{fd: fd, limit: 2, wake: make(chan struct{}, 1)}
+	// update returns a partial update setting the fields with the bits in values.
+	update := func(values map[int]pvdata.PVInt) Event {
+		v, err := zeroStructure(fd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var bits []int
+		for bit, x := range values {
+			*v.Field(fd.Fields[bit-1].Name).(*pvdata.PVInt) = x
+			bits = append(bits, bit)
+		}
+		changed := pvdata.NewBitSetWithBits(bits...)
+		fields, _ := pvdata.ChangedFields(fd, changed)
+		return Event{Kind: Update, Value: v, Changed: changed, ChangedFields: fields}
+	}
+	m.push(update(map[int]pvdata.PVInt{1: 1}))
+	m.push(update(map[int]pvdata.PVInt{1: 2, 2: 2}))
+	m.push(update(map[int]pvdata.PVInt{2: 3}))
+	m.push(update(map[int]pvdata.PVInt{3: 3}))
+	m.push(Event{Kind: Disconnected})
+	if len(m.queue) != 3 {
+		t.Fatalf("queued %d events, want 3", len(m.queue))
+	}
+	if e := m.queue[2]; e.Kind != Disconnected {
+		t.Errorf("last event is %v, want Disconnected", e.Kind)
+	}
+	e := m.queue[1]
+	if diff := cmp.Diff([]string{"a", "b", "c"}, e.ChangedFields); diff != "" {
+		t.Errorf("changed fields of merged update differ (-want +got):\n%s", diff)
+	}
+	if got, _ := pvdata.ChangedFields(fd, e.Overrun); !cmp.Equal(got, []string{"b"}) {
+		t.Errorf("merged update overran %v, want [b]", got)
+	}
+	for name, want := range map[string]pvdata.PVInt{"a": 2, "b": 3, "c": 3} {
+		if got := *e.Value.Field(name).(*pvdata.PVInt); got != want {
+			t.Errorf("merged %s = %d, want %d", name, got, want)
+		}
+	}
+}
+
+// ntChannel is an NTScalar channel whose monitors deliver the values sent on values.
+type ntChannel struct {
+	values chan *pvdata.NTScalar
+}
+
+func (c *ntChannel) Name() string { return "nt" }
+
+func (c *ntChannel) CreateChannel(ctx context.Context, name string) (pvaccess.Channel, error) {
+	if name == c.Name() {
+		return c, nil
+	}
+	return nil, nil
+}
+
+func (c *ntChannel) CreateChannelMonitor(ctx context.Context, req pvdata.PVStructure) (pvaccess.Nexter, error) {
+	return c, nil
+}
+
+func (c *ntChannel) Next(ctx context.Context) (interface{}, error) {
+	select {
+	case v := <-c.values:
+		return v, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestMonitorChangedFields(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ch := &ntChannel{values: make(chan *pvdata.NTScalar, 2)}
+	ch.values <- &pvdata.NTScalar{Value: pvdata.PVDouble(1), Alarm: &pvdata.Alarm{}}
+	srv := &pvaccess.Server{DisableSearch: true}
+	srv.AddChannelProvider(ch)
+	addr, _ := serve(t, srv, "127.0.0.1:0")
+	c := New(addr)
+	defer c.Close()
+	channel, err := c.Channel(ctx, "nt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := channel.MonitorFields(ctx, nil, "alarm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	e := nextEvent(ctx, t, m)
+	if diff := cmp.Diff([]string{"value", "alarm"}, e.ChangedFields); diff != "" || !e.Full {
+		t.Errorf("first update's changed fields differ (-want +got):\n%s", diff)
+	}
+	ch.values <- &pvdata.NTScalar{Value: pvdata.PVDouble(1), Alarm: &pvdata.Alarm{Severity: pvdata.SeverityMajor}}
+	e = nextEvent(ctx, t, m)
+	if !e.FieldChanged("alarm.severity") || e.Full {
+		t.Errorf("second update changed %v (full %v), want a partial update of alarm.severity", e.ChangedFields, e.Full)
+	}
+	if _, err := channel.MonitorFields(ctx, nil); err == nil {
+		t.Error("MonitorFields without fields succeeded")
+	}
+}
@@ -0,0 +1,93 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+	"github.com/Lexcelon/go-pvaccess/internal/connection"
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+// Put puts value to the channel. value can be anything that can be encoded as a structure,
+// e.g. &pvdata.NTScalar{Value: 1.5}; only its top-level fields are sent, and the server's other fields are unchanged.
+func (ch *Channel) Put(ctx context.Context, value interface{}) error {
+	return ch.PutFields(ctx, value, nil)
+}
+
+// PutFields puts the fields of value named in fields, e.g. "value" or "value.x" (see pvdata.ChangedFields).
+// If fields is nil, every top-level field of value is put.
+// The fields of value must match the names of the fields of the channel's put structure; their types are converted as needed.
+func (ch *Channel) PutFields(ctx context.Context, value interface{}, fields []string) error {
+	pvs, err := pvdata.NewPVStructure(value)
+	if err != nil {
+		return fmt.Errorf("put value: %w", err)
+	}
+	if fields == nil {
+		fd, err := pvs.FieldDesc()
+		if err != nil {
+			return fmt.Errorf("put value: %w", err)
+		}
+		fields = []string{}
+		for _, f := range fd.Fields {
+			fields = append(fields, f.Name)
+		}
+	}
+	return ch.client.RetryPolicy.do(ctx, ch.client.clock(), operationRetryable, func(ctx context.Context) error {
+		return ch.put(ctx, pvs, fields)
+	})
+}
+
+func (ch *Channel) put(ctx context.Context, pvs pvdata.PVStructure, fields []string) (err error) {
+	if lc := ch.localChannel(); lc != nil {
+		return lc.Put(ctx, emptyRequest().Data.(pvdata.PVStructure), pvs, fields)
+	}
+	defer ch.recordOp(pvaccess.OpPut, ch.client.clock().Now(), &err)
+	cn, sid, err := ch.connection(ctx)
+	if err != nil {
+		return err
+	}
+	id := ch.client.newID()
+	var fd pvdata.FieldDesc
+	if err := cn.roundTrip(ctx, sid, id, proto.APP_CHANNEL_PUT, &proto.ChannelPutRequest{
+		ServerChannelID: sid,
+		RequestID:       id,
+		Subcommand:      proto.CHANNEL_PUT_INIT,
+		PVRequest:       emptyRequest(),
+	}, func(msg *connection.Message) error {
+		var resp proto.ChannelPutResponseInit
+		if err := msg.Decode(&resp); err != nil {
+			return err
+		}
+		fd = resp.PVPutStructureIF
+		return statusError(resp.Status)
+	}); err != nil {
+		return err
+	}
+	// The value is sent in the server's put structure, so that the server can decode it.
+	put, err := zeroStructure(fd)
+	if err == nil {
+		err = put.SetFromMap(pvs.ToMap())
+	}
+	var changed pvdata.PVBitSet
+	if err == nil {
+		changed, err = pvdata.FieldsBitSet(fd, fields)
+	}
+	if err != nil {
+		cn.destroyRequest(ctx, sid, id)
+		return fmt.Errorf("put value: %w", err)
+	}
+	return cn.roundTrip(ctx, sid, id, proto.APP_CHANNEL_PUT, &proto.ChannelPutRequest{
+		ServerChannelID: sid,
+		RequestID:       id,
+		Subcommand:      proto.CHANNEL_PUT_DESTROY,
+		Value:           pvdata.PVStructureDiff{ChangedBitSet: changed, Value: put},
+	}, func(msg *connection.Message) error {
+		var resp proto.ChannelPutResponse
+		if err := msg.Decode(&resp); err != nil {
+			return err
+		}
+		return statusError(resp.Status)
+	})
+}
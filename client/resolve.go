@@ -0,0 +1,65 @@
+package client
+
+import (
+	"context"
+	"net"
+)
+
+// Resolver looks up the addresses of host names, as *net.Resolver does.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) (addrs []string, err error)
+}
+
+// resolver returns the client's Resolver.
+func (c *Client) resolver() Resolver {
+	if c.Resolver == nil {
+		return net.DefaultResolver
+	}
+	return c.Resolver
+}
+
+// resolve returns the addresses ("ip:port") that the host of addr ("host:port") resolves to, in the order the
+// client's Resolver returned them. A host that is an IP address isn't looked up.
+func (c *Client) resolve(ctx context.Context, addr string) ([]string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) != nil {
+		return []string{addr}, nil
+	}
+	ips, err := c.resolver().LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = net.JoinHostPort(ip, port)
+	}
+	return addrs, nil
+}
+
+// dialTCP connects to the server at addr, trying each address its host resolves to until one accepts.
+// Without a Resolver, the host is resolved by the dialer.
+func (c *Client) dialTCP(ctx context.Context, addr string) (net.Conn, error) {
+	var d net.Dialer
+	if c.Resolver == nil {
+		return d.DialContext(ctx, "tcp", addr)
+	}
+	dests, err := c.resolve(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	var lastErr error = &net.AddrError{Err: "no addresses", Addr: addr}
+	for _, dest := range dests {
+		nc, err := d.DialContext(ctx, "tcp", dest)
+		if err == nil {
+			return nc, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, lastErr
+}
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+	"github.com/google/go-cmp/cmp"
+)
+
+// staticResolver resolves the host names in hosts, and records the names it is asked for.
+type staticResolver struct {
+	hosts map[string][]string
+
+	mu      sync.Mutex
+	lookups []string
+}
+
+func (r *staticResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lookups = append(r.lookups, host)
+	addrs, ok := r.hosts[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return addrs, nil
+}
+
+func TestChannelAddrs(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv := &pvaccess.Server{DisableSearch: true}
+	for _, name := range []string{"a", "b"} {
+		srv.AddChannelProvider(pvaccess.NewSimpleChannel(name))
+	}
+	addr, _ := serve(t, srv, "127.0.0.1:0")
+	_, port, _ := net.SplitHostPort(addr)
+	server := net.JoinHostPort("pv-server", port)
+
+	c := New()
+	// The first address refuses connections, so the second is tried.
+	r := &staticResolver{hosts: map[string][]string{"pv-server": {"127.0.0.2", "127.0.0.1"}}}
+	c.Resolver = r
+	// Channels in ChannelAddrs are never searched for.
+	c.SearchAddrs = []string{"search-host"}
+	c.ChannelAddrs = map[string]string{"a": server, "b": server, "missing": server}
+	defer c.Close()
+
+	channel, err := c.Channel(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, ok := channel.ConnectionInfo(); !ok || fmt.Sprint(info.RemoteAddr) != addr {
+		t.Errorf("channel connected to %v, want %s", info.RemoteAddr, addr)
+	}
+	if _, err := c.Channel(ctx, "missing"); err == nil || ctx.Err() != nil {
+		t.Errorf("creating missing channel = %v, want an error before the deadline", err)
+	}
+	var got []string
+	for result := range c.ConnectAll(ctx, []string{"b", "missing"}) {
+		got = append(got, fmt.Sprintf("%s: %v", result.Name, result.Err == nil))
+	}
+	sort.Strings(got)
+	if diff := cmp.Diff([]string{"b: true", "missing: false"}, got); diff != "" {
+		t.Errorf("ConnectAll results differ (-want +got):\n%s", diff)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if diff := cmp.Diff([]string{"pv-server"}, r.lookups); diff != "" {
+		t.Errorf("lookups differ (-want +got):\n%s", diff)
+	}
+}
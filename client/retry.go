@@ -0,0 +1,108 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+	"github.com/Lexcelon/go-pvaccess/clock"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+// RetryPolicy retries failed operations, giving each attempt its own timeout and waiting longer after each failure.
+// Zero values select defaults of 3 attempts, a 5 second timeout per attempt, and a backoff of 100 milliseconds
+// that doubles after each failure, up to 2 seconds. Each wait is randomized, between half and all of the backoff,
+// so that clients that failed together don't retry in lockstep.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first.
+	MaxAttempts int
+	// Timeout is how long each attempt may take. A negative timeout leaves attempts with only the caller's deadline.
+	Timeout time.Duration
+	// Backoff is the wait before the second attempt, and MaxBackoff the longest wait between attempts.
+	Backoff, MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy retries with the default settings.
+var DefaultRetryPolicy = &RetryPolicy{}
+
+const (
+	defaultRetryAttempts   = 3
+	defaultRetryTimeout    = 5 * time.Second
+	defaultRetryBackoff    = 100 * time.Millisecond
+	defaultRetryMaxBackoff = 2 * time.Second
+)
+
+// jitter randomizes the waits between retries. It is seeded separately from the global source,
+// which is the same in every process.
+var jitter = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+// Do calls f until it succeeds, or it has been called MaxAttempts times, or ctx is done, and returns its last error.
+// Errors reported by the server, such as a rejected put, and ErrClosed are returned without retrying.
+// After a *pvaccess.RetryableError, Do waits at least as long as its After.
+// A nil policy calls f once, with ctx.
+func (p *RetryPolicy) Do(ctx context.Context, f func(ctx context.Context) error) error {
+	return p.do(ctx, clock.Real, operationRetryable, f)
+}
+
+// operationRetryable reports whether an operation that failed with err should be retried.
+func operationRetryable(err error) bool {
+	var status pvdata.PVStatus
+	return err != ErrClosed && !errors.As(err, &status)
+}
+
+// connectRetryable reports whether creating a channel that failed with err should be retried.
+// Unlike operations, channels that a server doesn't have are retried, since they may be about to appear,
+// but channels that a server denies permission for are not.
+func connectRetryable(err error) bool {
+	return err != ErrClosed && !errors.Is(err, pvaccess.ErrPermissionDenied)
+}
+
+// do is like Do, but measures timeouts and backoff with clk and retries errors for which retryable returns true.
+func (p *RetryPolicy) do(ctx context.Context, clk clock.Clock, retryable func(error) bool, f func(ctx context.Context) error) error {
+	if p == nil {
+		return f(ctx)
+	}
+	attempts, timeout, backoff, maxBackoff := p.MaxAttempts, p.Timeout, p.Backoff, p.MaxBackoff
+	if attempts <= 0 {
+		attempts = defaultRetryAttempts
+	}
+	if timeout == 0 {
+		timeout = defaultRetryTimeout
+	}
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = defaultRetryMaxBackoff
+	}
+	for attempt := 1; ; attempt++ {
+		actx, cancel := ctx, func() {}
+		if timeout > 0 {
+			actx, cancel = clock.WithTimeout(ctx, clk, timeout)
+		}
+		err := f(actx)
+		cancel()
+		if err == nil || attempt >= attempts || ctx.Err() != nil || !retryable(err) {
+			return err
+		}
+		jitter.Lock()
+		wait := backoff/2 + time.Duration(jitter.Int63n(int64(backoff/2)+1))
+		jitter.Unlock()
+		var hint *pvaccess.RetryableError
+		if errors.As(err, &hint) && hint.After > wait {
+			wait = hint.After
+		}
+		if clock.Sleep(ctx, clk, wait) != nil {
+			return err
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	ctx := context.Background()
+	failure := errors.New("failure")
+	p := &RetryPolicy{MaxAttempts: 4, Timeout: 20 * time.Millisecond, Backoff: time.Millisecond}
+	for _, test := range []struct {
+		name     string
+		policy   *RetryPolicy
+		err      func(attempt int, ctx context.Context) error
+		attempts int
+		wantErr  error
+	}{
+		{"nil policy", nil, func(int, context.Context) error { return failure }, 1, failure},
+		{"success", p, func(int, context.Context) error { return nil }, 1, nil},
+		{"eventual success", p, func(attempt int, ctx context.Context) error {
+			if attempt < 3 {
+				return failure
+			}
+			return nil
+		}, 3, nil},
+		{"failure", p, func(int, context.Context) error { return failure }, 4, failure},
+		{"timeout", p, func(attempt int, ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}, 4, context.DeadlineExceeded},
+		{"server error", p, func(int, context.Context) error {
+			return pvdata.PVStatus{Type: pvdata.PVStatus_ERROR, Message: "rejected"}
+		}, 1, pvdata.PVStatus{Type: pvdata.PVStatus_ERROR, Message: "rejected"}},
+		{"closed", p, func(int, context.Context) error { return ErrClosed }, 1, ErrClosed},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			attempts := 0
+			err := test.policy.Do(ctx, func(ctx context.Context) error {
+				attempts++
+				return test.err(attempts, ctx)
+			})
+			if attempts != test.attempts {
+				t.Errorf("made %d attempts, want %d", attempts, test.attempts)
+			}
+			if !errors.Is(err, test.wantErr) && err != test.wantErr {
+				t.Errorf("Do = %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestChannelRetry(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	// Reserve an address for a server that starts after the client's first attempts have failed.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	ch := pvaccess.NewSimpleChannel("test")
+	x := pvdata.PVInt(42)
+	ch.Set(&x)
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		newServer(ch).Serve(ctx, ln)
+	}()
+
+	c := New(addr)
+	defer c.Close()
+	c.BreakerThreshold = -1
+	c.RetryPolicy = &RetryPolicy{MaxAttempts: 50, Timeout: time.Second, Backoff: 20 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	channel, err := c.Channel(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := channel.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := value(t, got); v != 42 {
+		t.Errorf("Get = %d, want 42", v)
+	}
+}
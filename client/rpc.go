@@ -0,0 +1,145 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+	"github.com/Lexcelon/go-pvaccess/internal/connection"
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+// RPC calls the channel's RPC service with args, which can be anything that can be encoded as a structure.
+func (ch *Channel) RPC(ctx context.Context, args interface{}) (_ pvdata.PVStructure, err error) {
+	if lc := ch.localChannel(); lc != nil {
+		argStruct, err := pvdata.NewPVStructure(args)
+		if err != nil {
+			return pvdata.PVStructure{}, fmt.Errorf("RPC arguments: %w", err)
+		}
+		return lc.RPC(ctx, emptyRequest().Data.(pvdata.PVStructure), argStruct)
+	}
+	argAny, err := newRequest(args)
+	if err != nil {
+		return pvdata.PVStructure{}, err
+	}
+	defer ch.recordOp(pvaccess.OpRPC, ch.client.clock().Now(), &err)
+	cn, sid, err := ch.connection(ctx)
+	if err != nil {
+		return pvdata.PVStructure{}, err
+	}
+	id := ch.client.newID()
+	if err := cn.roundTrip(ctx, sid, id, proto.APP_CHANNEL_RPC, &proto.ChannelRPCRequest{
+		ServerChannelID: sid,
+		RequestID:       id,
+		Subcommand:      proto.CHANNEL_RPC_INIT,
+		PVRequest:       emptyRequest(),
+	}, func(msg *connection.Message) error {
+		var resp proto.ChannelRPCResponseInit
+		if err := msg.Decode(&resp); err != nil {
+			return err
+		}
+		return statusError(resp.Status)
+	}); err != nil {
+		return pvdata.PVStructure{}, err
+	}
+	var result pvdata.PVStructure
+	// The DESTROY flag frees the request on the server once the response has been sent.
+	if err := cn.roundTrip(ctx, sid, id, proto.APP_CHANNEL_RPC, &proto.ChannelRPCRequest{
+		ServerChannelID: sid,
+		RequestID:       id,
+		Subcommand:      proto.CHANNEL_RPC_DESTROY,
+		PVRequest:       argAny,
+	}, func(msg *connection.Message) error {
+		var resp proto.ChannelRPCResponse
+		if err := msg.Decode(&resp); err != nil {
+			return err
+		}
+		if err := statusError(resp.Status); err != nil {
+			return err
+		}
+		s, ok := resp.PVResponseData.Data.(pvdata.PVStructure)
+		if !ok {
+			return fmt.Errorf("RPC response is %T, expected PVStructure", resp.PVResponseData.Data)
+		}
+		result = s
+		return nil
+	}); err != nil {
+		return pvdata.PVStructure{}, err
+	}
+	return result, nil
+}
+
+// ntURI is the standard structure for RPC arguments.
+type ntURI struct {
+	Scheme string             `pvaccess:"scheme"`
+	Path   string             `pvaccess:"path"`
+	Query  pvdata.PVStructure `pvaccess:"query"`
+}
+
+func (ntURI) TypeID() string {
+	return "epics:nt/NTURI:1.0"
+}
+
+// Table is an NTTable returned by an RPC service.
+type Table struct {
+	// Labels are the column headings.
+	Labels []string
+	// Columns holds the values in each column, in the same order as Labels.
+	// Each column is a slice of a Go basic type, e.g. []float64.
+	Columns []interface{}
+}
+
+// RPC calls the RPC service on the channel named service, passing query as the query of an NTURI, like pvcall does.
+// The response is unwrapped: NTScalar and NTScalarArray responses return their value, NTTable responses return a Table,
+// and other structures are returned as a map (see pvdata.ToMap).
+func (c *Client) RPC(ctx context.Context, service string, query map[string]string) (interface{}, error) {
+	ch, err := c.Channel(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+	defer ch.Close()
+	m := make(map[string]interface{}, len(query))
+	for k, v := range query {
+		m[k] = v
+	}
+	q, err := pvdata.NewPVStructureFromMap("", m)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := ch.RPC(ctx, &ntURI{Scheme: "pva", Path: service, Query: q})
+	if err != nil {
+		return nil, err
+	}
+	return unwrapNT(resp)
+}
+
+// unwrapNT converts an RPC response to a Go value, according to its normative type.
+func unwrapNT(v pvdata.PVStructure) (interface{}, error) {
+	m := v.ToMap()
+	switch {
+	case pvdata.TypeIDCompatible(v.ID, "epics:nt/NTScalar:1.0"), pvdata.TypeIDCompatible(v.ID, "epics:nt/NTScalarArray:1.0"):
+		return m["value"], nil
+	case pvdata.TypeIDCompatible(v.ID, "epics:nt/NTTable:1.0"):
+		var t Table
+		if labels, ok := m["labels"].([]string); ok {
+			t.Labels = labels
+		}
+		fd, err := v.FieldDesc()
+		if err != nil {
+			return nil, err
+		}
+		values, _ := m["value"].(map[string]interface{})
+		for _, f := range fd.Fields {
+			if f.Name != "value" {
+				continue
+			}
+			// Use the order of the columns in the structure, which the map loses.
+			for _, col := range f.Field.Fields {
+				t.Columns = append(t.Columns, values[col.Name])
+			}
+		}
+		return t, nil
+	}
+	return m, nil
+}
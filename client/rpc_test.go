@@ -0,0 +1,225 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/Lexcelon/go-pvaccess/types"
+	"github.com/google/go-cmp/cmp"
+	"github.com/sirupsen/logrus"
+)
+
+// rpcService answers RPCs with an NTURI argument according to the "op" in its query.
+type rpcService struct{}
+
+func (rpcService) Name() string { return "service" }
+
+func (s rpcService) CreateChannel(ctx context.Context, name string) (types.Channel, error) {
+	if name == s.Name() {
+		return s, nil
+	}
+	return nil, nil
+}
+
+type rpcScalar struct {
+	Value int32 `pvaccess:"value"`
+}
+
+func (rpcScalar) TypeID() string { return "epics:nt/NTScalar:1.0" }
+
+type rpcTable struct {
+	Labels []string `pvaccess:"labels"`
+	Value  struct {
+		Name  []string `pvaccess:"name"`
+		Count []int32  `pvaccess:"count"`
+	} `pvaccess:"value"`
+}
+
+func (rpcTable) TypeID() string { return "epics:nt/NTTable:1.0" }
+
+func (rpcService) ChannelRPC(ctx context.Context, args pvdata.PVStructure) (interface{}, error) {
+	if args.ID != "epics:nt/NTURI:1.0" {
+		return nil, fmt.Errorf("arguments have type %q, want NTURI", args.ID)
+	}
+	path, _ := args.Field("path").(*pvdata.PVString)
+	if path == nil || *path != "service" {
+		return nil, fmt.Errorf("path = %v, want service", path)
+	}
+	op, _ := args.SubField("query", "op").(*pvdata.PVString)
+	if op == nil {
+		return nil, fmt.Errorf("missing op in %v", args)
+	}
+	switch *op {
+	case "scalar":
+		return &rpcScalar{42}, nil
+	case "table":
+		t := &rpcTable{Labels: []string{"Name", "Count"}}
+		t.Value.Name = []string{"a", "b"}
+		t.Value.Count = []int32{1, 2}
+		return t, nil
+	case "info":
+		return &struct {
+			Version string `pvaccess:"version"`
+		}{"1.0"}, nil
+	}
+	return nil, fmt.Errorf("unknown op %q", *op)
+}
+
+func TestRPC(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv := &pvaccess.Server{DisableSearch: true}
+	srv.AddChannelProvider(rpcService{})
+	addr, _ := serve(t, srv, "127.0.0.1:0")
+	remote := New(addr)
+	defer remote.Close()
+	local := New()
+	local.LocalServers = []*pvaccess.Server{srv}
+	defer local.Close()
+
+	for name, c := range map[string]*Client{"remote": remote, "local": local} {
+		for _, test := range []struct {
+			op   string
+			want interface{}
+		}{
+			{"scalar", int32(42)},
+			{"table", Table{Labels: []string{"Name", "Count"}, Columns: []interface{}{[]string{"a", "b"}, []int32{1, 2}}}},
+			{"info", map[string]interface{}{"version": "1.0"}},
+		} {
+			got, err := c.RPC(ctx, "service", map[string]string{"op": test.op})
+			if err != nil {
+				t.Errorf("%s RPC %s: %v", name, test.op, err)
+				continue
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("%s RPC %s differs (-want +got):\n%s", name, test.op, diff)
+			}
+		}
+		if _, err := c.RPC(ctx, "service", map[string]string{"op": "missing"}); err == nil {
+			t.Errorf("%s RPC with unknown op succeeded", name)
+		}
+	}
+}
+
+func TestServerDiagnostics(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv, err := pvaccess.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.DisableSearch = true
+	srv.DiagnosticsAllowed = func(ctx context.Context) bool { return true }
+	var privileged int32
+	srv.AddAccessController(pvaccess.AccessControllerFunc(func(ctx context.Context, channel string) pvaccess.AccessRights {
+		if atomic.LoadInt32(&privileged) != 0 {
+			return pvaccess.AccessReadWrite
+		}
+		return pvaccess.AccessRead
+	}))
+	addr, _ := serve(t, srv, "127.0.0.1:0")
+	c := New(addr)
+	defer c.Close()
+
+	if _, err := c.RPC(ctx, "server", map[string]string{"op": "goroutines"}); err == nil {
+		t.Error("goroutine dump allowed without write access")
+	}
+	atomic.StoreInt32(&privileged, 1)
+	dump, err := c.RPC(ctx, "server", map[string]string{"op": "goroutines"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := dump.(string); !strings.Contains(s, "goroutine ") {
+		t.Errorf("goroutine dump = %.100q...", s)
+	}
+	stats, err := c.RPC(ctx, "server", map[string]string{"op": "runtime"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := stats.(map[string]interface{}); m["goroutines"].(int64) <= 0 {
+		t.Errorf("runtime stats = %v", stats)
+	}
+	heap, err := c.RPC(ctx, "server", map[string]string{"op": "heap"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := heap.([]uint8); len(b) == 0 {
+		t.Errorf("heap profile = %v", heap)
+	}
+	defer logrus.SetLevel(logrus.GetLevel())
+	for _, test := range []struct{ enable, want string }{{"true", "debug"}, {"false", "info"}} {
+		level, err := c.RPC(ctx, "server", map[string]string{"op": "debug", "enable": test.enable})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if level != test.want || logrus.GetLevel().String() != test.want {
+			t.Errorf("debug enable=%s: level %v, want %s", test.enable, level, test.want)
+		}
+	}
+}
+
+func TestServerDiagnosticsRefused(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv, err := pvaccess.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.DisableSearch = true
+	addr, _ := serve(t, srv, "127.0.0.1:0")
+	c := New(addr)
+	defer c.Close()
+	// Every client has write access to a default server, but none may use the diagnostic ops.
+	for _, op := range []string{"runtime", "goroutines", "heap", "debug"} {
+		if _, err := c.RPC(ctx, "server", map[string]string{"op": op, "enable": "true"}); err == nil {
+			t.Errorf("op %q allowed by a default server", op)
+		}
+	}
+	if level := logrus.GetLevel(); level == logrus.DebugLevel {
+		t.Errorf("log level = %v after refused debug op", level)
+	}
+}
+
+func TestAliases(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv, err := pvaccess.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.DisableSearch = true
+	ch := pvaccess.NewSimpleChannel("new")
+	ch.AddAlias("old")
+	x := pvdata.PVInt(42)
+	ch.Set(&x)
+	srv.AddChannelProvider(ch)
+	addr, _ := serve(t, srv, "127.0.0.1:0")
+	c := New(addr)
+	defer c.Close()
+
+	channel, err := c.Channel(ctx, "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := channel.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := value(t, got); v != 42 {
+		t.Errorf("Get through alias = %d, want 42", v)
+	}
+	aliases, err := c.RPC(ctx, "server", map[string]string{"op": "aliases"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"alias": []string{"old"}, "channel": []string{"new"}}
+	if diff := cmp.Diff(want, aliases); diff != "" {
+		t.Errorf("aliases differ (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,504 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/clock"
+	"github.com/Lexcelon/go-pvaccess/internal/connection"
+	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+const (
+	defaultSearchPort     = 5076
+	defaultSearchDelay    = 50 * time.Millisecond
+	defaultMaxSearchDelay = 5 * time.Second
+	defaultSearchBudget   = 100
+	// maxSearchPayload keeps search requests within a typical Ethernet MTU.
+	maxSearchPayload = 1400
+	// tcpSearchTimeout is how long to wait for servers to answer a search sent over an existing connection.
+	tcpSearchTimeout    = time.Second
+	defaultSearchWindow = 100 * time.Millisecond
+)
+
+// SearchPolicy selects the server a channel is created on when more than one server answers a search for it.
+type SearchPolicy int
+
+const (
+	// SearchFirst uses the first server to respond.
+	SearchFirst SearchPolicy = iota
+	// SearchPreferred uses the first of the client's PreferredServers that responded,
+	// or the first server to respond if none of them did.
+	SearchPreferred
+	// SearchUnique fails with ErrAmbiguousChannel if more than one server responds.
+	SearchUnique
+)
+
+var searchPolicyNames = map[SearchPolicy]string{
+	SearchFirst:     "SearchFirst",
+	SearchPreferred: "SearchPreferred",
+	SearchUnique:    "SearchUnique",
+}
+
+func (p SearchPolicy) String() string {
+	if name, ok := searchPolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("SearchPolicy(%d)", int(p))
+}
+
+// ErrAmbiguousChannel is returned when more than one server answers a search for a channel and the client's
+// SearchPolicy is SearchUnique.
+var ErrAmbiguousChannel = errors.New("client: channel found on more than one server")
+
+// searchHeaderSize is the encoded size of a search request with no channels:
+// sequence ID, flags, reserved bytes, response address and port, one protocol name ("tcp"), and the channel count.
+const searchHeaderSize = 4 + 1 + 3 + 16 + 2 + (1 + 1 + 3) + 2
+
+// searcher locates channels by sending UDP search requests to the client's SearchAddrs.
+//
+// Channels being searched for are batched into as few packets as possible.
+// Each channel is searched for again after a delay that doubles after every attempt, up to MaxSearchDelay,
+// until a server answers. No more than SearchBudget packets are sent per second in total.
+type searcher struct {
+	client *Client
+	conn   *net.UDPConn
+	dests  []*net.UDPAddr
+	// wake is signalled when a new search is added.
+	wake chan struct{}
+
+	mu           sync.Mutex
+	pending      map[pvdata.PVUInt]*pendingSearch
+	lastInstance pvdata.PVUInt
+	sequence     pvdata.PVUInt
+	// tokens is the number of packets that may be sent now, refilled at SearchBudget tokens per second.
+	tokens     float64
+	lastRefill time.Time
+}
+
+type pendingSearch struct {
+	name  string
+	next  time.Time
+	delay time.Duration
+	found chan searchResult
+	// responders lists the servers that have answered, in the order they answered.
+	responders []string
+}
+
+type searchResult struct {
+	addr string
+	err  error
+}
+
+// searchConns asks the servers the client is already connected to, other than those at skip, whether they have
+// the channel name, and returns the address of the first that does, or "" if none answers that it does.
+// Searching over an existing connection is much faster than a UDP search, e.g. when many channels reconnect at once.
+func (c *Client) searchConns(ctx context.Context, name string, skip []string) string {
+	c.mu.Lock()
+	var conns []*conn
+	for addr, cn := range c.conns {
+		if !contains(skip, addr) {
+			conns = append(conns, cn)
+		}
+	}
+	c.mu.Unlock()
+	if len(conns) == 0 {
+		return ""
+	}
+	ctx, cancel := clock.WithTimeout(ctx, c.clock(), tcpSearchTimeout)
+	defer cancel()
+	found := make(chan string, len(conns))
+	for _, cn := range conns {
+		cn := cn
+		go func() {
+			ok, err := cn.search(ctx, pvdata.PVUInt(c.newID()), name)
+			if err != nil && ctx.Err() == nil {
+				ctxlog.L(ctx).Debugf("searching for channel %q on %s: %v", name, cn.addr, err)
+			}
+			if !ok {
+				found <- ""
+				return
+			}
+			found <- cn.addr
+		}()
+	}
+	for range conns {
+		if addr := <-found; addr != "" {
+			return addr
+		}
+	}
+	return ""
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// searcher returns the client's searcher, starting it if necessary.
+// It returns nil if the client has no SearchAddrs.
+func (c *Client) searcher(ctx context.Context) (*searcher, error) {
+	c.mu.Lock()
+	s := c.search
+	c.mu.Unlock()
+	if s != nil || len(c.SearchAddrs) == 0 {
+		return s, nil
+	}
+	// The addresses are resolved without holding c.mu, since looking up host names may take a while.
+	var dests []*net.UDPAddr
+	for _, addr := range c.SearchAddrs {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, strconv.Itoa(defaultSearchPort))
+		}
+		resolved, err := c.resolve(ctx, addr)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range resolved {
+			dest, err := net.ResolveUDPAddr("udp", addr)
+			if err != nil {
+				return nil, err
+			}
+			dests = append(dests, dest)
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.search != nil {
+		return c.search, nil
+	}
+	conn, owned := c.SearchConn, false
+	if conn == nil {
+		var err error
+		if conn, err = net.ListenUDP("udp", nil); err != nil {
+			return nil, err
+		}
+		owned = true
+	}
+	s = &searcher{
+		client:     c,
+		conn:       conn,
+		dests:      dests,
+		wake:       make(chan struct{}, 1),
+		pending:    make(map[pvdata.PVUInt]*pendingSearch),
+		tokens:     1,
+		lastRefill: c.clock().Now(),
+	}
+	// The searcher runs until the client is closed, not just for ctx.
+	searchCtx := ctxlog.WithSubsystem(ctxlog.WithField(c.ctx, "local_addr", conn.LocalAddr()), ctxlog.Search)
+	if owned {
+		go func() {
+			<-searchCtx.Done()
+			conn.Close()
+		}()
+	}
+	go s.send(searchCtx)
+	go s.receive(searchCtx)
+	c.search = s
+	return s, nil
+}
+
+func (c *Client) searchDelays() (delay, max time.Duration) {
+	delay, max = c.SearchDelay, c.MaxSearchDelay
+	if delay <= 0 {
+		delay = defaultSearchDelay
+	}
+	if max <= 0 {
+		max = defaultMaxSearchDelay
+	}
+	return delay, max
+}
+
+// searchWindow returns how long to collect responses from other servers after the first response to a search,
+// or zero if the first response is used right away.
+func (c *Client) searchWindow() time.Duration {
+	if c.SearchPolicy == SearchFirst && c.OnSearchConflict == nil {
+		return 0
+	}
+	if c.SearchWindow <= 0 {
+		return defaultSearchWindow
+	}
+	return c.SearchWindow
+}
+
+// choose picks the server to use from those that responded to a search, according to the client's SearchPolicy.
+func (c *Client) choose(name string, responders []string) (string, error) {
+	if len(responders) > 1 && c.OnSearchConflict != nil {
+		c.OnSearchConflict(name, append([]string(nil), responders...))
+	}
+	switch c.SearchPolicy {
+	case SearchPreferred:
+		for _, addr := range c.PreferredServers {
+			if contains(responders, addr) {
+				return addr, nil
+			}
+		}
+	case SearchUnique:
+		if len(responders) > 1 {
+			return "", fmt.Errorf("%w: %q is on %s", ErrAmbiguousChannel, name, strings.Join(responders, ", "))
+		}
+	}
+	return responders[0], nil
+}
+
+func (c *Client) searchBudget() float64 {
+	if c.SearchBudget <= 0 {
+		return defaultSearchBudget
+	}
+	return float64(c.SearchBudget)
+}
+
+// search waits until a server answers a search for name, and returns the server's TCP address.
+func (s *searcher) search(ctx context.Context, name string) (string, error) {
+	delay, _ := s.client.searchDelays()
+	p := &pendingSearch{
+		name:  name,
+		next:  s.client.clock().Now(),
+		delay: delay,
+		found: make(chan searchResult, 1),
+	}
+	s.mu.Lock()
+	s.lastInstance++
+	id := s.lastInstance
+	s.pending[id] = p
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, id)
+		s.mu.Unlock()
+	}()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	select {
+	case r := <-p.found:
+		return r.addr, r.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-s.client.ctx.Done():
+		return "", ErrClosed
+	}
+}
+
+// send sends search requests for pending channels as they become due, until ctx is cancelled.
+func (s *searcher) send(ctx context.Context) {
+	clk := s.client.clock()
+	timer := clk.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-timer.C():
+		}
+		wait := s.sendDue(ctx, clk.Now())
+		if !timer.Stop() {
+			select {
+			case <-timer.C():
+			default:
+			}
+		}
+		timer.Reset(wait)
+	}
+}
+
+// sendDue sends as many due searches as the budget allows, and returns how long to wait before trying again.
+func (s *searcher) sendDue(ctx context.Context, now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	budget := s.client.searchBudget()
+	s.tokens += now.Sub(s.lastRefill).Seconds() * budget
+	// Allow bursts of up to a tenth of a second's worth of packets, but always enough for one request to every destination.
+	if burst := math.Max(budget/10, float64(len(s.dests))); s.tokens > burst {
+		s.tokens = burst
+	}
+	s.lastRefill = now
+
+	var due []pvdata.PVUInt
+	next := now.Add(time.Hour)
+	for id, p := range s.pending {
+		if len(p.responders) > 0 {
+			// Collecting responses; there's no need to search again.
+			continue
+		}
+		if !p.next.After(now) {
+			due = append(due, id)
+		} else if p.next.Before(next) {
+			next = p.next
+		}
+	}
+	// Searches that have waited longest go first.
+	sort.Slice(due, func(i, j int) bool {
+		return s.pending[due[i]].next.Before(s.pending[due[j]].next)
+	})
+	_, max := s.client.searchDelays()
+	for len(due) > 0 {
+		if s.tokens < float64(len(s.dests)) {
+			// Out of budget; try again once enough tokens have accumulated.
+			refill := time.Duration((float64(len(s.dests)) - s.tokens) / budget * float64(time.Second))
+			if at := now.Add(refill); at.Before(next) {
+				next = at
+			}
+			break
+		}
+		var batch []proto.SearchRequest_Channel
+		size := searchHeaderSize
+		for len(due) > 0 {
+			p := s.pending[due[0]]
+			// Search instance ID, string length, and name.
+			n := 4 + 5 + len(p.name)
+			if len(batch) > 0 && size+n > maxSearchPayload {
+				break
+			}
+			size += n
+			batch = append(batch, proto.SearchRequest_Channel{SearchInstanceID: due[0], ChannelName: p.name})
+			s.client.stats.searched(p.name)
+			p.next = now.Add(p.delay)
+			if p.next.Before(next) {
+				next = p.next
+			}
+			if p.delay *= 2; p.delay > max {
+				p.delay = max
+			}
+			due = due[1:]
+		}
+		s.sequence++
+		for _, dest := range s.dests {
+			s.tokens--
+			if err := s.sendRequest(ctx, dest, s.sequence, batch); err != nil {
+				ctxlog.L(ctx).Warnf("sending search request to %v: %v", dest, err)
+			}
+		}
+	}
+	return next.Sub(now)
+}
+
+func (s *searcher) sendRequest(ctx context.Context, dest *net.UDPAddr, sequence pvdata.PVUInt, channels []proto.SearchRequest_Channel) error {
+	req := proto.SearchRequest{
+		SearchSequenceID: sequence,
+		// An empty response address tells servers to reply to the address the request came from.
+		ResponsePort: pvdata.PVUShort(s.conn.LocalAddr().(*net.UDPAddr).Port),
+		Protocols:    []pvdata.PVString{"tcp"},
+		Channels:     channels,
+	}
+	if !dest.IP.IsMulticast() && !dest.IP.Equal(net.IPv4bcast) {
+		req.Flags |= proto.SEARCH_UNICAST
+	}
+	var buf bytes.Buffer
+	c := connection.New(&buf, proto.FLAG_FROM_CLIENT)
+	c.Version = 2
+	if err := c.SendApp(ctx, proto.APP_SEARCH_REQUEST, &req); err != nil {
+		return err
+	}
+	_, err := s.conn.WriteToUDP(buf.Bytes(), dest)
+	return err
+}
+
+// packetReader reads a single UDP packet.
+type packetReader struct {
+	io.Reader
+}
+
+func (packetReader) Write(p []byte) (int, error) {
+	return 0, errors.New("cannot reply to a search response")
+}
+
+// receive handles search responses until the socket is closed.
+func (s *searcher) receive(ctx context.Context) {
+	buf := make([]byte, 65536)
+	for {
+		n, from, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				ctxlog.L(ctx).Errorf("reading search responses: %v", err)
+			}
+			return
+		}
+		c := connection.New(packetReader{bytes.NewReader(buf[:n])}, proto.FLAG_FROM_CLIENT)
+		for {
+			msg, err := c.Next(ctx)
+			if err != nil {
+				if err != io.EOF {
+					connection.WarnDecode(ctx, err, "decoding packet from %v", from)
+				}
+				break
+			}
+			if msg.Header.MessageCommand != proto.APP_SEARCH_RESPONSE {
+				continue
+			}
+			var resp proto.SearchResponse
+			if err := msg.Decode(&resp); err != nil {
+				connection.WarnDecode(ctx, err, "decoding search response from %v", from)
+				break
+			}
+			s.found(from, resp)
+		}
+	}
+}
+
+// found delivers a search response received from the given address.
+// Responses from unhealthy servers are ignored, so that searches keep waiting for another server to respond;
+// the unhealthy server's own responses are accepted again once its backoff has passed.
+func (s *searcher) found(from *net.UDPAddr, resp proto.SearchResponse) {
+	if !resp.Found || resp.Protocol != "tcp" {
+		return
+	}
+	ip := net.IP(resp.ServerAddress[:])
+	if ip.IsUnspecified() {
+		ip = from.IP
+	}
+	addr := net.JoinHostPort(ip.String(), strconv.Itoa(int(resp.ServerPort)))
+	if !s.client.healthy(addr) {
+		return
+	}
+	window := s.client.searchWindow()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range resp.SearchInstanceIDs {
+		p := s.pending[id]
+		if p == nil || contains(p.responders, addr) {
+			// Servers answer once for each of the client's search addresses they receive the request on.
+			continue
+		}
+		p.responders = append(p.responders, addr)
+		if window == 0 {
+			delete(s.pending, id)
+			p.found <- searchResult{addr: addr}
+		} else if len(p.responders) == 1 {
+			id := id
+			s.client.clock().AfterFunc(window, func() { s.decide(id) })
+		}
+	}
+}
+
+// decide picks the server for the search with the given ID, once its window for collecting responses has passed.
+func (s *searcher) decide(id pvdata.PVUInt) {
+	s.mu.Lock()
+	p := s.pending[id]
+	delete(s.pending, id)
+	s.mu.Unlock()
+	if p == nil {
+		// The search was abandoned.
+		return
+	}
+	addr, err := s.client.choose(p.name, p.responders)
+	p.found <- searchResult{addr, err}
+}
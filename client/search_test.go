@@ -0,0 +1,412 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+	"github.com/Lexcelon/go-pvaccess/internal/connection"
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+// listenUDP returns a socket standing in for a search server.
+func listenUDP(t *testing.T) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readSearch reads the next search request sent to conn.
+// It returns ok false if none arrives within timeout.
+func readSearch(t *testing.T, conn *net.UDPConn, timeout time.Duration) (req proto.SearchRequest, from *net.UDPAddr, size int, ok bool) {
+	t.Helper()
+	buf := make([]byte, 65536)
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	n, from, err := conn.ReadFromUDP(buf)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return req, nil, 0, false
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	c := connection.New(packetReader{bytes.NewReader(buf[:n])}, proto.FLAG_FROM_SERVER)
+	msg, err := c.Next(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Header.MessageCommand != proto.APP_SEARCH_REQUEST {
+		t.Fatalf("got message command 0x%x, expected search request", msg.Header.MessageCommand)
+	}
+	if err := msg.Decode(&req); err != nil {
+		t.Fatal(err)
+	}
+	return req, from, n, true
+}
+
+// newTestSearcher returns a searcher that sends to dest, without starting its goroutines,
+// so that tests can call sendDue with their own clock.
+func newTestSearcher(t *testing.T, c *Client, dest *net.UDPConn, now time.Time) *searcher {
+	t.Helper()
+	conn := listenUDP(t)
+	return &searcher{
+		client:     c,
+		conn:       conn,
+		dests:      []*net.UDPAddr{dest.LocalAddr().(*net.UDPAddr)},
+		pending:    make(map[pvdata.PVUInt]*pendingSearch),
+		tokens:     1,
+		lastRefill: now,
+	}
+}
+
+func (s *searcher) addTest(name string, now time.Time) {
+	delay, _ := s.client.searchDelays()
+	s.lastInstance++
+	s.pending[s.lastInstance] = &pendingSearch{name: name, next: now, delay: delay, found: make(chan searchResult, 1)}
+}
+
+func TestSearchBatching(t *testing.T) {
+	ctx := context.Background()
+	srv := listenUDP(t)
+	c := New()
+	c.SearchBudget = 1000
+	defer c.Close()
+	now := time.Now()
+	s := newTestSearcher(t, c, srv, now)
+	s.tokens = 100
+	const channels = 200
+	for i := 0; i < channels; i++ {
+		s.addTest(fmt.Sprintf("channel:%03d", i), now)
+	}
+	s.sendDue(ctx, now)
+
+	seen := make(map[string]int)
+	packets := 0
+	for {
+		req, _, size, ok := readSearch(t, srv, 100*time.Millisecond)
+		if !ok {
+			break
+		}
+		packets++
+		if size > maxSearchPayload+8 {
+			t.Errorf("packet %d has %d bytes, want at most %d", packets, size, maxSearchPayload+8)
+		}
+		if req.Flags&proto.SEARCH_UNICAST == 0 {
+			t.Errorf("packet %d to a unicast address has flags 0x%x, want unicast bit set", packets, req.Flags)
+		}
+		for _, ch := range req.Channels {
+			seen[ch.ChannelName]++
+		}
+	}
+	if packets > 3 {
+		t.Errorf("sent %d packets for %d channels, want at most 3", packets, channels)
+	}
+	if len(seen) != channels {
+		t.Errorf("searched for %d channels, want %d", len(seen), channels)
+	}
+	for name, n := range seen {
+		if n != 1 {
+			t.Errorf("searched for %q %d times, want 1", name, n)
+		}
+	}
+}
+
+func TestSearchBackoff(t *testing.T) {
+	ctx := context.Background()
+	srv := listenUDP(t)
+	c := New()
+	c.SearchDelay = 10 * time.Millisecond
+	c.MaxSearchDelay = 40 * time.Millisecond
+	c.SearchBudget = 1000
+	defer c.Close()
+	now := time.Now()
+	s := newTestSearcher(t, c, srv, now)
+	s.addTest("test", now)
+
+	for _, step := range []struct {
+		at       time.Duration
+		sent     bool
+		nextWait time.Duration
+	}{
+		{0, true, 10 * time.Millisecond},
+		{5 * time.Millisecond, false, 5 * time.Millisecond},
+		{10 * time.Millisecond, true, 20 * time.Millisecond},
+		{30 * time.Millisecond, true, 40 * time.Millisecond},
+		{70 * time.Millisecond, true, 40 * time.Millisecond},
+		{110 * time.Millisecond, true, 40 * time.Millisecond},
+	} {
+		if wait := s.sendDue(ctx, now.Add(step.at)); wait != step.nextWait {
+			t.Errorf("at %v: next search in %v, want %v", step.at, wait, step.nextWait)
+		}
+		_, _, _, sent := readSearch(t, srv, 50*time.Millisecond)
+		if sent != step.sent {
+			t.Errorf("at %v: sent = %v, want %v", step.at, sent, step.sent)
+		}
+	}
+}
+
+func TestSearchBudget(t *testing.T) {
+	ctx := context.Background()
+	srv := listenUDP(t)
+	c := New()
+	c.SearchBudget = 10
+	c.SearchDelay = time.Second
+	defer c.Close()
+	now := time.Now()
+	s := newTestSearcher(t, c, srv, now)
+	// Two of these fit in a packet.
+	for i := 0; i < 6; i++ {
+		s.addTest(fmt.Sprintf("%d:%s", i, strings.Repeat("x", 450)), now)
+	}
+
+	count := func() int {
+		n := 0
+		for {
+			if _, _, _, ok := readSearch(t, srv, 50*time.Millisecond); !ok {
+				return n
+			}
+			n++
+		}
+	}
+	if wait := s.sendDue(ctx, now); wait != 100*time.Millisecond {
+		t.Errorf("next search in %v, want 100ms", wait)
+	}
+	if n := count(); n != 1 {
+		t.Errorf("sent %d packets with budget for 1", n)
+	}
+	s.sendDue(ctx, now.Add(50*time.Millisecond))
+	if n := count(); n != 0 {
+		t.Errorf("sent %d packets with no budget", n)
+	}
+	s.sendDue(ctx, now.Add(100*time.Millisecond))
+	if n := count(); n != 1 {
+		t.Errorf("sent %d packets with budget for 1", n)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ch := pvaccess.NewSimpleChannel("test")
+	x := pvdata.PVInt(42)
+	ch.Set(&x)
+	addr, _ := serve(t, newServer(ch), "127.0.0.1:0")
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tcpPort int
+	fmt.Sscan(port, &tcpPort)
+
+	srv := listenUDP(t)
+	c := New()
+	c.SearchAddrs = []string{srv.LocalAddr().String()}
+	c.SearchDelay = 10 * time.Millisecond
+	defer c.Close()
+
+	// Answer the first search for "test", from the unspecified address; never answer any other channel.
+	answered := make(chan struct{})
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, from, err := srv.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			conn := connection.New(packetReader{bytes.NewReader(buf[:n])}, proto.FLAG_FROM_SERVER)
+			msg, err := conn.Next(ctx)
+			if err != nil {
+				continue
+			}
+			var req proto.SearchRequest
+			if err := msg.Decode(&req); err != nil {
+				continue
+			}
+			for _, ch := range req.Channels {
+				if ch.ChannelName != "test" {
+					continue
+				}
+				var out bytes.Buffer
+				resp := connection.New(&out, proto.FLAG_FROM_SERVER)
+				resp.Version = 2
+				resp.SendApp(ctx, proto.APP_SEARCH_RESPONSE, &proto.SearchResponse{
+					SearchSequenceID:  req.SearchSequenceID,
+					ServerPort:        pvdata.PVUShort(tcpPort),
+					Protocol:          "tcp",
+					Found:             true,
+					SearchInstanceIDs: []pvdata.PVUInt{ch.SearchInstanceID},
+				})
+				srv.WriteToUDP(out.Bytes(), from)
+				close(answered)
+				return
+			}
+		}
+	}()
+
+	channel, err := c.Channel(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := channel.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := value(t, got); v != 42 {
+		t.Errorf("Get = %d, want 42", v)
+	}
+	<-answered
+	// Discard searches sent before the response arrived.
+	for {
+		if _, _, _, ok := readSearch(t, srv, 20*time.Millisecond); !ok {
+			break
+		}
+	}
+	if req, _, _, ok := readSearch(t, srv, 100*time.Millisecond); ok {
+		t.Errorf("searched again after channel was found: %v", req.Channels)
+	}
+
+	short, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if _, err := c.Channel(short, "missing"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("creating missing channel returned %v, want deadline exceeded", err)
+	}
+}
+
+func TestSearchOverConnection(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	first := pvaccess.NewSimpleChannel("first")
+	second := pvaccess.NewSimpleChannel("second")
+	x := pvdata.PVInt(7)
+	second.Set(&x)
+	srv := newServer(first)
+	srv.AddChannelProvider(second)
+	addr, _ := serve(t, srv, "127.0.0.1:0")
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tcpPort int
+	fmt.Sscan(port, &tcpPort)
+
+	// Only searches for "first" are answered over UDP, so "second" can only be found over the connection.
+	udp := listenUDP(t)
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, from, err := udp.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			msg, err := connection.New(packetReader{bytes.NewReader(buf[:n])}, proto.FLAG_FROM_SERVER).Next(ctx)
+			if err != nil {
+				continue
+			}
+			var req proto.SearchRequest
+			if err := msg.Decode(&req); err != nil {
+				continue
+			}
+			for _, ch := range req.Channels {
+				if ch.ChannelName != "first" {
+					continue
+				}
+				var out bytes.Buffer
+				resp := connection.New(&out, proto.FLAG_FROM_SERVER)
+				resp.Version = 2
+				resp.SendApp(ctx, proto.APP_SEARCH_RESPONSE, &proto.SearchResponse{
+					SearchSequenceID:  req.SearchSequenceID,
+					ServerPort:        pvdata.PVUShort(tcpPort),
+					Protocol:          "tcp",
+					Found:             true,
+					SearchInstanceIDs: []pvdata.PVUInt{ch.SearchInstanceID},
+				})
+				udp.WriteToUDP(out.Bytes(), from)
+			}
+		}
+	}()
+
+	c := New()
+	c.SearchAddrs = []string{udp.LocalAddr().String()}
+	c.SearchDelay = 10 * time.Millisecond
+	defer c.Close()
+	if _, err := c.Channel(ctx, "first"); err != nil {
+		t.Fatal(err)
+	}
+	channel, err := c.Channel(ctx, "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := channel.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := value(t, got); v != 7 {
+		t.Errorf("Get = %d, want 7", v)
+	}
+
+	// Channels the connected server doesn't have are still searched for over UDP.
+	short, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if _, err := c.Channel(short, "missing"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("creating missing channel returned %v, want deadline exceeded", err)
+	}
+}
+
+func TestSearchPolicy(t *testing.T) {
+	const a, b = "127.0.0.1:5001", "127.0.0.1:5002"
+	response := func(addr string) proto.SearchResponse {
+		tcp, err := net.ResolveTCPAddr("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := proto.SearchResponse{Found: true, Protocol: "tcp", ServerPort: pvdata.PVUShort(tcp.Port), SearchInstanceIDs: []pvdata.PVUInt{1}}
+		copy(resp.ServerAddress[:], tcp.IP.To16())
+		return resp
+	}
+	for _, test := range []struct {
+		policy    SearchPolicy
+		preferred []string
+		want      string
+		wantErr   error
+	}{
+		{policy: SearchFirst, want: a},
+		{policy: SearchPreferred, preferred: []string{"127.0.0.1:1", b}, want: b},
+		{policy: SearchPreferred, preferred: []string{"127.0.0.1:1"}, want: a},
+		{policy: SearchUnique, wantErr: ErrAmbiguousChannel},
+	} {
+		var conflicts [][]string
+		c := New()
+		c.SearchPolicy = test.policy
+		c.PreferredServers = test.preferred
+		c.SearchWindow = 10 * time.Millisecond
+		c.OnSearchConflict = func(name string, servers []string) {
+			conflicts = append(conflicts, servers)
+		}
+		s := &searcher{client: c, pending: make(map[pvdata.PVUInt]*pendingSearch)}
+		p := &pendingSearch{name: "test", found: make(chan searchResult, 1)}
+		s.pending[1] = p
+		from := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+		// a answers twice, e.g. to broadcast and unicast searches.
+		s.found(from, response(a))
+		s.found(from, response(a))
+		s.found(from, response(b))
+		r := <-p.found
+		if r.addr != test.want || !errors.Is(r.err, test.wantErr) {
+			t.Errorf("%v: found %q, %v, want %q, %v", test.policy, r.addr, r.err, test.want, test.wantErr)
+		}
+		if len(conflicts) != 1 || strings.Join(conflicts[0], " ") != a+" "+b {
+			t.Errorf("%v: conflicts reported %v, want [[%s %s]]", test.policy, conflicts, a, b)
+		}
+		c.Close()
+	}
+}
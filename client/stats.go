@@ -0,0 +1,218 @@
+package client
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+)
+
+// monitorRateWindow is the time constant of the moving average of ChannelStats.MonitorRate.
+const monitorRateWindow = 10 * time.Second
+
+// OpStats describes the operations of one kind performed on a channel.
+type OpStats struct {
+	// Latency is the time taken by each operation, in seconds, from the request until its response or failure.
+	Latency pvaccess.Histogram
+	// Errors is how many of the operations failed.
+	Errors uint64
+}
+
+// ChannelStats describes the activity of the channels a Client has created with one name.
+// Channels of LocalServers are only counted as connected.
+type ChannelStats struct {
+	// Server is the address of the server the channel was last created on, or "" if it is on a local server.
+	Server string
+	// Searches is the number of UDP search requests that have been sent for the channel.
+	Searches uint64
+	// Connects is the number of times the channel has been created on a server, counting reconnections,
+	// and ConnectTime the time each took, in seconds, from when the channel was created or lost its connection.
+	Connects    uint64
+	ConnectTime pvaccess.Histogram
+	// Disconnects is the number of times the channel has lost its connection.
+	Disconnects uint64
+	// Ops describes the Gets, Puts, and RPCs performed on the channel.
+	Ops map[pvaccess.OpKind]OpStats
+	// MonitorUpdates is the number of updates received by the channel's monitors, and MonitorRate the rate at which
+	// they have recently arrived, in updates per second, averaged over about ten seconds.
+	MonitorUpdates uint64
+	MonitorRate    float64
+}
+
+// ServerStats describes a Client's connections to one server.
+type ServerStats struct {
+	// Connected is set while the client is connected to the server.
+	Connected bool
+	// Connects and ConnectFailures are the number of connections to the server that were established and that
+	// failed, and ConnectTime the time taken to establish each, in seconds, including connection validation.
+	Connects        uint64
+	ConnectFailures uint64
+	ConnectTime     pvaccess.Histogram
+	// Disconnects is the number of established connections that have been lost or closed.
+	Disconnects uint64
+	// MessagesSent and BytesSent count the application messages sent to the server and their payload sizes,
+	// before compression; MessagesReceived and BytesReceived those received from it, after decompression.
+	MessagesSent, BytesSent         uint64
+	MessagesReceived, BytesReceived uint64
+}
+
+// channelCounters accumulates the ChannelStats of a channel name.
+type channelCounters struct {
+	ChannelStats
+	// rateAt is when MonitorRate was last updated.
+	rateAt time.Time
+}
+
+// statsRecorder accumulates the statistics of a Client's channels, by name, and servers, by address.
+type statsRecorder struct {
+	mu       sync.Mutex
+	channels map[string]*channelCounters
+	servers  map[string]*ServerStats
+}
+
+func (r *statsRecorder) channel(name string, f func(s *channelCounters)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.channels == nil {
+		r.channels = make(map[string]*channelCounters)
+	}
+	s, ok := r.channels[name]
+	if !ok {
+		s = &channelCounters{ChannelStats: ChannelStats{
+			ConnectTime: pvaccess.NewDurationHistogram(),
+			Ops:         make(map[pvaccess.OpKind]OpStats),
+		}}
+		r.channels[name] = s
+	}
+	f(s)
+}
+
+func (r *statsRecorder) server(addr string, f func(s *ServerStats)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.servers == nil {
+		r.servers = make(map[string]*ServerStats)
+	}
+	s, ok := r.servers[addr]
+	if !ok {
+		s = &ServerStats{ConnectTime: pvaccess.NewDurationHistogram()}
+		r.servers[addr] = s
+	}
+	f(s)
+}
+
+func (r *statsRecorder) searched(name string) {
+	r.channel(name, func(s *channelCounters) { s.Searches++ })
+}
+
+func (r *statsRecorder) channelConnected(name, addr string, d time.Duration) {
+	r.channel(name, func(s *channelCounters) {
+		s.Server = addr
+		s.Connects++
+		s.ConnectTime.Observe(d.Seconds())
+	})
+}
+
+func (r *statsRecorder) channelDisconnected(name string) {
+	r.channel(name, func(s *channelCounters) { s.Disconnects++ })
+}
+
+func (r *statsRecorder) op(name string, kind pvaccess.OpKind, d time.Duration, err error) {
+	r.channel(name, func(s *channelCounters) {
+		op, ok := s.Ops[kind]
+		if !ok {
+			op.Latency = pvaccess.NewDurationHistogram()
+		}
+		op.Latency.Observe(d.Seconds())
+		if err != nil {
+			op.Errors++
+		}
+		s.Ops[kind] = op
+	})
+}
+
+// monitorUpdate counts an update received at now, and adds it to the moving average of the rate.
+func (r *statsRecorder) monitorUpdate(name string, now time.Time) {
+	r.channel(name, func(s *channelCounters) {
+		s.MonitorUpdates++
+		s.MonitorRate = decayRate(s.MonitorRate, s.rateAt, now) + 1/monitorRateWindow.Seconds()
+		s.rateAt = now
+	})
+}
+
+// decayRate returns what an exponential moving average of a rate that was rate at since has decayed to by now.
+func decayRate(rate float64, since, now time.Time) float64 {
+	if rate == 0 {
+		return 0
+	}
+	return rate * math.Exp(-now.Sub(since).Seconds()/monitorRateWindow.Seconds())
+}
+
+func (r *statsRecorder) connected(addr string, d time.Duration, err error) {
+	r.server(addr, func(s *ServerStats) {
+		if err != nil {
+			s.ConnectFailures++
+			return
+		}
+		s.Connected = true
+		s.Connects++
+		s.ConnectTime.Observe(d.Seconds())
+	})
+}
+
+func (r *statsRecorder) disconnected(addr string) {
+	r.server(addr, func(s *ServerStats) {
+		s.Connected = false
+		s.Disconnects++
+	})
+}
+
+func (r *statsRecorder) sent(addr string, size int) {
+	r.server(addr, func(s *ServerStats) {
+		s.MessagesSent++
+		s.BytesSent += uint64(size)
+	})
+}
+
+func (r *statsRecorder) received(addr string, size int) {
+	r.server(addr, func(s *ServerStats) {
+		s.MessagesReceived++
+		s.BytesReceived += uint64(size)
+	})
+}
+
+// ChannelStats returns the statistics of the channels the client has created, by name.
+func (c *Client) ChannelStats() map[string]ChannelStats {
+	now := c.clock().Now()
+	r := &c.stats
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := make(map[string]ChannelStats, len(r.channels))
+	for name, s := range r.channels {
+		cs := s.ChannelStats
+		cs.ConnectTime = cs.ConnectTime.Copy()
+		cs.Ops = make(map[pvaccess.OpKind]OpStats, len(s.Ops))
+		for kind, op := range s.Ops {
+			op.Latency = op.Latency.Copy()
+			cs.Ops[kind] = op
+		}
+		cs.MonitorRate = decayRate(s.MonitorRate, s.rateAt, now)
+		stats[name] = cs
+	}
+	return stats
+}
+
+// ServerStats returns the statistics of the client's connections, by server address.
+func (c *Client) ServerStats() map[string]ServerStats {
+	r := &c.stats
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := make(map[string]ServerStats, len(r.servers))
+	for addr, s := range r.servers {
+		ss := *s
+		ss.ConnectTime = ss.ConnectTime.Copy()
+		stats[addr] = ss
+	}
+	return stats
+}
@@ -0,0 +1,86 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+func TestStats(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ch := pvaccess.NewSimpleChannel("test")
+	x := pvdata.PVInt(1)
+	ch.Set(&x)
+	addr, stop := serve(t, newServer(ch), "127.0.0.1:0")
+
+	c := New(addr)
+	// Searches for channels that aren't on the server go unanswered.
+	c.SearchAddrs = []string{"127.0.0.1:1"}
+	defer c.Close()
+	channel, err := c.Channel(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := channel.Get(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := channel.Put(ctx, &struct {
+		Value pvdata.PVInt `pvaccess:"value"`
+	}{2}); err != nil {
+		t.Fatal(err)
+	}
+	m, err := channel.Monitor(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	nextEvent(ctx, t, m)
+	x = 3
+	ch.Set(&x)
+	nextEvent(ctx, t, m)
+	searchCtx, cancelSearch := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancelSearch()
+	if _, err := c.Channel(searchCtx, "missing"); err == nil {
+		t.Fatal("creating missing channel succeeded")
+	}
+
+	s := c.ChannelStats()["test"]
+	if s.Server != addr || s.Connects != 1 || s.ConnectTime.Count != 1 || s.Searches != 0 {
+		t.Errorf("channel stats = %+v, want one connection to %s without searching", s, addr)
+	}
+	if get, put := s.Ops[pvaccess.OpGet], s.Ops[pvaccess.OpPut]; get.Latency.Count != 2 || put.Latency.Count != 1 || get.Errors+put.Errors != 0 {
+		t.Errorf("op stats = %+v, want two Gets and one Put without errors", s.Ops)
+	}
+	if s.MonitorUpdates != 2 || s.MonitorRate <= 0 {
+		t.Errorf("%d monitor updates at %g per second, want 2 at a positive rate", s.MonitorUpdates, s.MonitorRate)
+	}
+	if missing := c.ChannelStats()["missing"]; missing.Searches == 0 || missing.Connects != 0 {
+		t.Errorf("missing channel stats = %+v, want searches and no connections", missing)
+	}
+	srv := c.ServerStats()[addr]
+	if !srv.Connected || srv.Connects != 1 || srv.ConnectFailures != 0 || srv.MessagesSent == 0 || srv.BytesReceived == 0 {
+		t.Errorf("server stats = %+v, want one connection with traffic", srv)
+	}
+
+	// Losing the connection is counted for the channel and the server.
+	stop()
+	deadline := time.Now().Add(5 * time.Second)
+	for c.ServerStats()[addr].Connected {
+		if time.Now().After(deadline) {
+			t.Fatal("server still connected after it stopped")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if n := c.ServerStats()[addr].Disconnects; n != 1 {
+		t.Errorf("server disconnected %d times, want 1", n)
+	}
+	if n := c.ChannelStats()["test"].Disconnects; n != 1 {
+		t.Errorf("channel disconnected %d times, want 1", n)
+	}
+}
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+// TypedChannel reads and writes the value field of a channel, such as an NTScalar or NTScalarArray, as a T,
+// such as float64 or []int32, converting it as by pvdata.FieldAs.
+type TypedChannel[T any] struct {
+	ch *Channel
+}
+
+// Typed returns a TypedChannel for the value field of ch.
+func Typed[T any](ch *Channel) TypedChannel[T] {
+	return TypedChannel[T]{ch}
+}
+
+// Channel returns the underlying channel.
+func (c TypedChannel[T]) Channel() *Channel {
+	return c.ch
+}
+
+// Get reads the channel's current value.
+func (c TypedChannel[T]) Get(ctx context.Context) (T, error) {
+	v, err := c.ch.Get(ctx)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return pvdata.FieldAs[T](v, "value")
+}
+
+// Put writes value to the channel's value field, converting it to the type of the field.
+func (c TypedChannel[T]) Put(ctx context.Context, value T) error {
+	return c.ch.PutFields(ctx, &struct {
+		Value T `pvaccess:"value"`
+	}{value}, []string{"value"})
+}
+
+// Monitor starts monitoring the channel's value, as by Channel.MonitorFields with the value field.
+func (c TypedChannel[T]) Monitor(ctx context.Context) (*TypedMonitor[T], error) {
+	m, err := c.ch.MonitorFields(ctx, nil, "value")
+	if err != nil {
+		return nil, err
+	}
+	return &TypedMonitor[T]{m}, nil
+}
+
+// TypedMonitor delivers the updates of a channel's value as a T.
+type TypedMonitor[T any] struct {
+	*Monitor
+}
+
+// Next waits for the next update of the value and returns it. Disconnected and Reconnected events are skipped,
+// since the monitor is resumed once the channel reconnects. Once the monitor has stopped, Next returns its Err,
+// or ErrClosed if it was closed.
+func (m *TypedMonitor[T]) Next(ctx context.Context) (T, error) {
+	var zero T
+	for {
+		select {
+		case e, ok := <-m.Events():
+			if !ok {
+				if err := m.Err(); err != nil {
+					return zero, err
+				}
+				return zero, ErrClosed
+			}
+			if e.Kind != Update {
+				continue
+			}
+			return pvdata.FieldAs[T](e.Value, "value")
+		case <-ctx.Done():
+			return zero, ctx.Err()
+		}
+	}
+}
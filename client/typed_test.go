@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTypedChannel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	x := pvaccess.NewTypedChannel("x", 1.5)
+	waveform := pvaccess.NewTypedChannel("waveform", []float64{1, 2})
+	srv := &pvaccess.Server{DisableSearch: true}
+	srv.AddChannelProvider(x)
+	srv.AddChannelProvider(waveform)
+	addr, _ := serve(t, srv, "127.0.0.1:0")
+	c := New(addr)
+	defer c.Close()
+	channel, err := c.Channel(ctx, "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc := Typed[float64](channel)
+	if v, err := tc.Get(ctx); err != nil || v != 1.5 {
+		t.Errorf("Get = %v, %v; want 1.5", v, err)
+	}
+	if err := tc.Put(ctx, 2.5); err != nil {
+		t.Fatal(err)
+	}
+	if v := x.Value(); v != 2.5 {
+		t.Errorf("value after Put = %v, want 2.5", v)
+	}
+	if _, err := Typed[int32](channel).Get(ctx); err == nil {
+		t.Error("Get of 2.5 as an int32 succeeded")
+	}
+
+	m, err := tc.Monitor(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	next := func(want float64) {
+		t.Helper()
+		if v, err := m.Next(ctx); err != nil || v != want {
+			t.Fatalf("Next = %v, %v; want %v", v, err, want)
+		}
+	}
+	next(2.5)
+	x.Set(3)
+	next(3)
+	m.Close()
+	if _, err := m.Next(ctx); err != ErrClosed {
+		t.Errorf("Next after Close = %v, want ErrClosed", err)
+	}
+
+	channel, err = c.Channel(ctx, "waveform")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := channel.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := v.ID; id != "epics:nt/NTScalarArray:1.0" {
+		t.Errorf("waveform type ID = %q, want NTScalarArray", id)
+	}
+	if got, err := Typed[[]int32](channel).Get(ctx); err != nil || !cmp.Equal(got, []int32{1, 2}) {
+		t.Errorf("Get as []int32 = %v, %v; want [1 2]", got, err)
+	}
+	if severity, err := pvdata.FieldAs[int32](v, "alarm.severity"); err != nil || severity != 0 {
+		t.Errorf("alarm severity = %v, %v; want 0", severity, err)
+	}
+}
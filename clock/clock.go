@@ -0,0 +1,130 @@
+// Package clock abstracts the passage of time, so that timing-dependent behavior such as beacons,
+// search backoff, timeouts, and heartbeats can be tested deterministically with a Fake.
+package clock
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and creates timers, like the functions of package time.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+	// AfterFunc calls f in its own goroutine once d has passed. The Timer's C is nil.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is like time.Timer.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker is like time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is the system clock.
+var Real Clock = realClock{}
+
+// Or returns c, or Real if c is nil, so that Clock fields can leave zero to select the system clock.
+func Or(c Clock) Clock {
+	if c == nil {
+		return Real
+	}
+	return c
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return realTimer{time.AfterFunc(d, f)}
+}
+
+type realTimer struct{ *time.Timer }
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+type realTicker struct{ *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// Sleep waits for d to pass on c, or for ctx to be done, and returns ctx.Err() in that case.
+func Sleep(ctx context.Context, c Clock, d time.Duration) error {
+	t := Or(c).NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C():
+		return nil
+	}
+}
+
+// WithTimeout is like context.WithTimeout, but the timeout is measured by c.
+func WithTimeout(parent context.Context, c Clock, d time.Duration) (context.Context, context.CancelFunc) {
+	if c = Or(c); c == Real {
+		return context.WithTimeout(parent, d)
+	}
+	ctx, cancel := context.WithCancel(parent)
+	tc := &timeoutCtx{Context: ctx, deadline: c.Now().Add(d)}
+	t := c.AfterFunc(d, func() {
+		tc.mu.Lock()
+		tc.expired = ctx.Err() == nil
+		tc.mu.Unlock()
+		cancel()
+	})
+	return tc, func() {
+		t.Stop()
+		cancel()
+	}
+}
+
+// timeoutCtx is a context cancelled by a Clock's timer, which reports context.DeadlineExceeded once the timer fires.
+type timeoutCtx struct {
+	context.Context
+	deadline time.Time
+
+	mu      sync.Mutex
+	expired bool
+}
+
+func (ctx *timeoutCtx) Deadline() (time.Time, bool) {
+	if d, ok := ctx.Context.Deadline(); ok && d.Before(ctx.deadline) {
+		return d, true
+	}
+	return ctx.deadline, true
+}
+
+func (ctx *timeoutCtx) Err() error {
+	ctx.mu.Lock()
+	expired := ctx.expired
+	ctx.mu.Unlock()
+	err := ctx.Context.Err()
+	if err != nil && expired {
+		return context.DeadlineExceeded
+	}
+	return err
+}
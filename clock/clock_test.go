@@ -0,0 +1,111 @@
+package clock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Unix(1000, 0)
+	f := NewFake(start)
+	timer := f.NewTimer(2 * time.Second)
+	ticker := f.NewTicker(time.Second)
+	defer ticker.Stop()
+	called := make(chan struct{})
+	f.AfterFunc(3*time.Second, func() { close(called) })
+
+	expect := func(ch <-chan time.Time, want time.Time) {
+		t.Helper()
+		select {
+		case got := <-ch:
+			if !got.Equal(want) {
+				t.Errorf("fired at %v, want %v", got, want)
+			}
+		default:
+			t.Errorf("didn't fire, want %v", want)
+		}
+	}
+	quiet := func(ch <-chan time.Time) {
+		t.Helper()
+		select {
+		case got := <-ch:
+			t.Errorf("fired at %v", got)
+		default:
+		}
+	}
+	f.Advance(time.Second)
+	expect(ticker.C(), start.Add(time.Second))
+	quiet(timer.C())
+	f.Advance(1500 * time.Millisecond)
+	expect(ticker.C(), start.Add(2*time.Second))
+	expect(timer.C(), start.Add(2*time.Second))
+	if got := f.Now(); !got.Equal(start.Add(2500 * time.Millisecond)) {
+		t.Errorf("Now = %v after advancing 2.5s", got)
+	}
+	if timer.Stop() {
+		t.Error("Stop of fired timer = true")
+	}
+	f.Advance(time.Second)
+	select {
+	case <-called:
+	case <-time.After(5 * time.Second):
+		t.Error("AfterFunc not called")
+	}
+
+	if timer.Reset(time.Second) {
+		t.Error("Reset of fired timer = true")
+	}
+	if f.Pending() != 2 {
+		t.Errorf("Pending = %d, want the timer and the ticker", f.Pending())
+	}
+	if !timer.Stop() {
+		t.Error("Stop of pending timer = false")
+	}
+	f.Advance(time.Second)
+	quiet(timer.C())
+}
+
+func TestFakeBlockUntil(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	f := NewFake(time.Unix(0, 0))
+	done := make(chan error, 1)
+	go func() { done <- Sleep(ctx, f, time.Minute) }()
+	if err := f.BlockUntil(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	f.Advance(time.Minute)
+	if err := <-done; err != nil {
+		t.Errorf("Sleep = %v", err)
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	f := NewFake(time.Unix(0, 0))
+	ctx, cancel := WithTimeout(context.Background(), f, time.Second)
+	defer cancel()
+	if d, ok := ctx.Deadline(); !ok || !d.Equal(time.Unix(1, 0)) {
+		t.Errorf("Deadline = %v, %v", d, ok)
+	}
+	f.Advance(999 * time.Millisecond)
+	if ctx.Err() != nil {
+		t.Fatalf("Err before the timeout = %v", ctx.Err())
+	}
+	f.Advance(time.Millisecond)
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("context not done after the timeout")
+	}
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		t.Errorf("Err = %v, want DeadlineExceeded", ctx.Err())
+	}
+
+	ctx, cancel = WithTimeout(context.Background(), f, time.Second)
+	cancel()
+	if ctx.Err() != context.Canceled {
+		t.Errorf("Err after cancel = %v, want Canceled", ctx.Err())
+	}
+}
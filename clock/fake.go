@@ -0,0 +1,181 @@
+package clock
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fake is a Clock whose time only passes when Advance is called, for deterministic tests.
+// Timers and tickers fire during Advance, in order of their deadlines, with the clock set to each deadline in turn.
+// As with the time package, ticks are dropped if the previous one hasn't been received,
+// and functions passed to AfterFunc run in their own goroutines.
+type Fake struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+	// changed is closed and replaced whenever timers changes, to wake BlockUntil.
+	changed chan struct{}
+}
+
+// NewFake returns a Fake set to now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now, changed: make(chan struct{})}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	return f.add(&fakeTimer{f: f, ch: make(chan time.Time, 1)}, d)
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	return fakeTicker{f.add(&fakeTimer{f: f, ch: make(chan time.Time, 1), period: d}, d)}
+}
+
+func (f *Fake) AfterFunc(d time.Duration, fn func()) Timer {
+	return f.add(&fakeTimer{f: f, fn: fn}, d)
+}
+
+func (f *Fake) add(t *fakeTimer, d time.Duration) *fakeTimer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t.when = f.now.Add(d)
+	f.scheduleLocked(t)
+	if d <= 0 {
+		f.fireLocked(t)
+	}
+	return t
+}
+
+// Advance moves the clock forward by d, firing the timers and tickers that become due.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	end := f.now.Add(d)
+	for len(f.timers) > 0 && !f.timers[0].when.After(end) {
+		t := f.timers[0]
+		if t.when.After(f.now) {
+			f.now = t.when
+		}
+		f.fireLocked(t)
+	}
+	f.now = end
+}
+
+// Pending returns the number of timers and tickers that have yet to fire or be stopped.
+func (f *Fake) Pending() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.timers)
+}
+
+// BlockUntil waits until at least n timers and tickers are pending, e.g. for the code under test to start waiting
+// before the test calls Advance. It returns ctx.Err() if ctx is done first.
+func (f *Fake) BlockUntil(ctx context.Context, n int) error {
+	for {
+		f.mu.Lock()
+		pending, changed := len(f.timers), f.changed
+		f.mu.Unlock()
+		if pending >= n {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// fireLocked fires t, and reschedules it if it is a ticker. f.mu must be held.
+func (f *Fake) fireLocked(t *fakeTimer) {
+	f.removeLocked(t)
+	if t.fn != nil {
+		go t.fn()
+	} else {
+		select {
+		case t.ch <- f.now:
+		default:
+		}
+	}
+	if t.period > 0 {
+		t.when = t.when.Add(t.period)
+		f.scheduleLocked(t)
+	}
+}
+
+// scheduleLocked adds t to the timers, in order of deadline. f.mu must be held.
+func (f *Fake) scheduleLocked(t *fakeTimer) {
+	i := sort.Search(len(f.timers), func(i int) bool { return f.timers[i].when.After(t.when) })
+	f.timers = append(f.timers, nil)
+	copy(f.timers[i+1:], f.timers[i:])
+	f.timers[i] = t
+	f.notifyLocked()
+}
+
+// removeLocked removes t from the timers, and reports whether it was there. f.mu must be held.
+func (f *Fake) removeLocked(t *fakeTimer) bool {
+	for i, other := range f.timers {
+		if other == t {
+			f.timers = append(f.timers[:i], f.timers[i+1:]...)
+			f.notifyLocked()
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Fake) notifyLocked() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// fakeTimer is a timer or ticker of a Fake. Its fields other than ch and fn are protected by f.mu.
+type fakeTimer struct {
+	f    *Fake
+	ch   chan time.Time
+	fn   func()
+	when time.Time
+	// period is the interval of a ticker, and zero for timers.
+	period time.Duration
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	if t.fn != nil {
+		return nil
+	}
+	return t.ch
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.f.mu.Lock()
+	defer t.f.mu.Unlock()
+	return t.f.removeLocked(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.f.mu.Lock()
+	defer t.f.mu.Unlock()
+	active := t.f.removeLocked(t)
+	t.when = t.f.now.Add(d)
+	t.f.scheduleLocked(t)
+	if d <= 0 {
+		t.f.fireLocked(t)
+	}
+	return active
+}
+
+type fakeTicker struct{ *fakeTimer }
+
+func (t fakeTicker) Stop() {
+	t.fakeTimer.Stop()
+}
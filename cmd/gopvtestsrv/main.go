@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"flag"
+	"net"
 	"os"
 	"os/signal"
 	"syscall"
@@ -12,12 +13,20 @@ import (
 
 	pvaccess "github.com/Lexcelon/go-pvaccess"
 	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
+	"github.com/Lexcelon/go-pvaccess/provider/gometrics"
+	"github.com/Lexcelon/go-pvaccess/provider/group"
+	"github.com/Lexcelon/go-pvaccess/provider/sim"
 	"github.com/Lexcelon/go-pvaccess/pvdata"
 )
 
 var (
 	disableSearch = flag.Bool("disable_search", false, "disable UDP beacon/search support")
 	verbose       = flag.Bool("v", false, "verbose mode")
+	logLevels     = flag.String("log_levels", "", `comma-separated levels of log subsystems (search, connection, codec, provider), e.g. "codec=info,connection=debug"`)
+	simInterval   = flag.Duration("sim_interval", time.Second, "update interval of the simulated gopvtest:sim:* PVs")
+	advertise     = flag.String("advertise", "", "host:port to advertise in beacons and search responses instead of the listening address (port may be empty)")
+	groups        = flag.String("groups", "", "JSON file defining group PVs whose members are gopvtest:sim:* PVs; reloaded on SIGHUP")
+	goMetrics     = flag.Bool("go_metrics", false, "serve the Go runtime metrics and expvar variables of the server process as gopvtest:go:* PVs")
 )
 
 func main() {
@@ -27,6 +36,9 @@ func main() {
 	if *verbose {
 		log.SetLevel(log.TraceLevel)
 	}
+	if err := pvaccess.SetLogLevels(*logLevels); err != nil {
+		log.Fatal(err)
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
@@ -40,6 +52,13 @@ func main() {
 		ctxlog.L(ctx).Fatalf("creating server: %v", err)
 	}
 	s.DisableSearch = *disableSearch
+	if *advertise != "" {
+		addr, err := net.ResolveTCPAddr("tcp", *advertise)
+		if err != nil {
+			ctxlog.L(ctx).Fatalf("parsing -advertise: %v", err)
+		}
+		s.ServerAddressOverride = addr
+	}
 
 	c := pvaccess.NewSimpleChannel("gopvtest")
 	value := pvdata.PVLong(256)
@@ -52,5 +71,36 @@ func main() {
 		}
 	}()
 
+	var pvs []sim.PV
+	for _, w := range []sim.Waveform{sim.Sine, sim.Ramp, sim.Noise, sim.Counter} {
+		pvs = append(pvs, sim.PV{
+			Name:      "gopvtest:sim:" + w.String(),
+			Waveform:  w,
+			Amplitude: 10,
+			Interval:  *simInterval,
+		})
+	}
+	simProvider, err := sim.New(pvs...)
+	if err != nil {
+		ctxlog.L(ctx).Fatalf("creating simulated PVs: %v", err)
+	}
+	s.AddChannelProvider(simProvider)
+	go simProvider.Run(ctx)
+
+	if *groups != "" {
+		groupProvider, err := group.NewFromFile(simProvider, *groups)
+		if err != nil {
+			ctxlog.L(ctx).Fatalf("loading groups: %v", err)
+		}
+		s.AddChannelProvider(groupProvider)
+		go groupProvider.Run(ctx)
+	}
+	if *goMetrics {
+		metricsProvider := gometrics.New("gopvtest:go:")
+		s.AddChannelProvider(metricsProvider)
+		go metricsProvider.Run(ctx)
+	}
+	go s.ReloadOnSignal(ctx)
+
 	s.ListenAndServe(ctx)
 }
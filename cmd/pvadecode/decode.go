@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+// conversation is the state shared by both directions of a connection.
+type conversation struct {
+	// types are the structures of each request's values, by request ID, as announced in INIT responses.
+	types map[pvdata.PVInt]pvdata.FieldDesc
+}
+
+func newConversation() *conversation {
+	return &conversation{types: make(map[pvdata.PVInt]pvdata.FieldDesc)}
+}
+
+// requestHead is the start of every response to a channel request.
+type requestHead struct {
+	RequestID  pvdata.PVInt
+	Subcommand pvdata.PVByte
+}
+
+// channelRequestHead is the start of every channel request.
+type channelRequestHead struct {
+	ServerChannelID pvdata.PVInt
+	RequestID       pvdata.PVInt
+	Subcommand      pvdata.PVByte
+}
+
+// decode decodes the payload of an application message.
+// It returns nil if the message's payload has no known structure.
+func (cv *conversation) decode(h proto.PVAccessHeader, payload []byte) (interface{}, error) {
+	order := binary.ByteOrder(binary.LittleEndian)
+	if h.Flags&proto.FLAG_BO_BE != 0 {
+		order = binary.BigEndian
+	}
+	decodeAs := func(out interface{}) error {
+		return pvdata.Decode(&pvdata.DecoderState{Buf: bytes.NewReader(payload), ByteOrder: order}, out)
+	}
+	fromServer := h.Flags&proto.FLAG_FROM_SERVER != 0
+	var v interface{}
+	// typeErr is set if v is only the start of the payload, because the type of its value is unknown.
+	var typeErr error
+	switch h.MessageCommand {
+	case proto.APP_BEACON:
+		v = &proto.BeaconMessage{}
+	case proto.APP_CONNECTION_VALIDATION:
+		if fromServer {
+			v = &proto.ConnectionValidationRequest{}
+		} else {
+			v = &proto.ConnectionValidationResponse{}
+		}
+	case proto.APP_CONNECTION_VALIDATED:
+		v = &proto.ConnectionValidated{}
+	case proto.APP_SEARCH_REQUEST:
+		v = &proto.SearchRequest{}
+	case proto.APP_SEARCH_RESPONSE:
+		v = &proto.SearchResponse{}
+	case proto.APP_CHANNEL_CREATE:
+		if fromServer {
+			v = &proto.CreateChannelResponse{}
+		} else {
+			v = &proto.CreateChannelRequest{}
+		}
+	case proto.APP_CHANNEL_DESTROY:
+		v = &proto.DestroyChannel{}
+	case proto.APP_REQUEST_DESTROY, proto.APP_REQUEST_CANCEL:
+		v = &proto.CancelDestroyRequest{}
+	case proto.APP_ORIGIN_TAG:
+		v = &proto.OriginTag{}
+	case proto.APP_MULTIPLE_DATA:
+		var md proto.MultipleData
+		if err := decodeAs(&md); err != nil {
+			return nil, err
+		}
+		return cv.multipleData(h, md)
+	case proto.APP_CHANNEL_GET, proto.APP_CHANNEL_PUT, proto.APP_CHANNEL_MONITOR, proto.APP_CHANNEL_RPC:
+		if fromServer {
+			var head requestHead
+			if err := decodeAs(&head); err != nil {
+				return nil, err
+			}
+			v, typeErr = cv.response(h.MessageCommand, head)
+		} else {
+			var head channelRequestHead
+			if err := decodeAs(&head); err != nil {
+				return nil, err
+			}
+			v, typeErr = cv.request(h.MessageCommand, head)
+		}
+	default:
+		return nil, nil
+	}
+	if err := decodeAs(v); err != nil {
+		return v, err
+	}
+	cv.learn(v)
+	return v, typeErr
+}
+
+// multipleData is a decoded APP_MULTIPLE_DATA message.
+type multipleData struct {
+	Messages []bundledMessage
+}
+
+type bundledMessage struct {
+	Command string
+	Value   interface{}
+}
+
+// multipleData decodes the messages bundled in md, which was sent with header h.
+func (cv *conversation) multipleData(h proto.PVAccessHeader, md proto.MultipleData) (interface{}, error) {
+	h.MessageCommand = md.MessageCommand
+	v := &multipleData{}
+	// Every message is decoded, and the first error is returned.
+	var firstErr error
+	for i, payload := range md.Payloads {
+		h.PayloadSize = pvdata.PVInt(len(payload))
+		inner, err := cv.decode(h, payload)
+		v.Messages = append(v.Messages, bundledMessage{h.CommandName(), inner})
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("message %d: %w", i, err)
+		}
+	}
+	return v, firstErr
+}
+
+// value returns the value to decode the values of request id into.
+// If their type is unknown, it returns an error, and the caller decodes only the start of the message.
+func (cv *conversation) value(id pvdata.PVInt) (pvdata.PVStructureDiff, error) {
+	fd, ok := cv.types[id]
+	if !ok {
+		return pvdata.PVStructureDiff{}, fmt.Errorf("value not decoded: type of request %d unknown (its INIT was not captured)", id)
+	}
+	zero, err := fd.Zero()
+	if err != nil {
+		return pvdata.PVStructureDiff{}, fmt.Errorf("value not decoded: %w", err)
+	}
+	pvs, ok := zero.(pvdata.PVStructure)
+	if !ok {
+		return pvdata.PVStructureDiff{}, fmt.Errorf("value not decoded: type of request %d is not a structure", id)
+	}
+	return pvdata.PVStructureDiff{Value: pvs}, nil
+}
+
+// request returns the structure of a channel request from a client.
+func (cv *conversation) request(command pvdata.PVByte, head channelRequestHead) (interface{}, error) {
+	switch command {
+	case proto.APP_CHANNEL_GET:
+		return &proto.ChannelGetRequest{}, nil
+	case proto.APP_CHANNEL_PUT:
+		req := &proto.ChannelPutRequest{Subcommand: head.Subcommand}
+		if !req.IsPut() {
+			return req, nil
+		}
+		value, err := cv.value(head.RequestID)
+		if err != nil {
+			return &head, err
+		}
+		req.Value = value
+		return req, nil
+	case proto.APP_CHANNEL_MONITOR:
+		return &proto.ChannelMonitorRequest{}, nil
+	default:
+		return &proto.ChannelRPCRequest{}, nil
+	}
+}
+
+// response returns the structure of a server's response to a channel request.
+func (cv *conversation) response(command pvdata.PVByte, head requestHead) (interface{}, error) {
+	init := head.Subcommand&0x08 != 0
+	switch command {
+	case proto.APP_CHANNEL_GET:
+		if init {
+			return &proto.ChannelGetResponseInit{}, nil
+		}
+		value, err := cv.value(head.RequestID)
+		if err != nil {
+			return &proto.ChannelResponseError{}, err
+		}
+		return &proto.ChannelGetResponse{Value: value}, nil
+	case proto.APP_CHANNEL_PUT:
+		switch {
+		case init:
+			return &proto.ChannelPutResponseInit{}, nil
+		case head.Subcommand&proto.CHANNEL_PUT_GET != 0:
+			value, err := cv.value(head.RequestID)
+			if err != nil {
+				return &proto.ChannelResponseError{}, err
+			}
+			return &proto.ChannelPutGetResponse{Value: value}, nil
+		}
+		return &proto.ChannelPutResponse{}, nil
+	case proto.APP_CHANNEL_MONITOR:
+		switch {
+		case init:
+			return &proto.ChannelMonitorResponseInit{}, nil
+		case head.Subcommand != 0:
+			// Only errors and the final response of a monitor have a subcommand.
+			return &proto.ChannelResponseError{}, nil
+		}
+		value, err := cv.value(head.RequestID)
+		if err != nil {
+			return &head, err
+		}
+		return &proto.ChannelMonitorResponse{Value: value}, nil
+	default:
+		if init {
+			return &proto.ChannelRPCResponseInit{}, nil
+		}
+		return &proto.ChannelRPCResponse{}, nil
+	}
+}
+
+// learn records the types announced by successful INIT responses.
+func (cv *conversation) learn(v interface{}) {
+	switch v := v.(type) {
+	case *proto.ChannelGetResponseInit:
+		if v.Status.Type <= pvdata.PVStatus_WARNING {
+			cv.types[v.RequestID] = v.PVStructureIF
+		}
+	case *proto.ChannelPutResponseInit:
+		if v.Status.Type <= pvdata.PVStatus_WARNING {
+			cv.types[v.RequestID] = v.PVPutStructureIF
+		}
+	case *proto.ChannelMonitorResponseInit:
+		if v.Status.Type <= pvdata.PVStatus_WARNING {
+			cv.types[v.RequestID] = v.PVStructureIF
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// parseHexDump returns the bytes in a hex dump. Each line may start with an offset, which is recognized by its length
+// or a trailing colon, and the hex bytes end at the first token that isn't a byte, such as a text column.
+func parseHexDump(r io.Reader) ([]byte, error) {
+	var data []byte
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 1 && (strings.HasSuffix(fields[0], ":") || len(fields[0]) > 2 && len(fields[0])%2 == 0 && isHex(fields[0])) {
+			fields = fields[1:]
+		}
+		for _, f := range fields {
+			// Some dumps group bytes, e.g. "ca02 4000" from xxd.
+			if len(f)%2 != 0 || !isHex(f) {
+				break
+			}
+			b, err := hex.DecodeString(f)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			data = append(data, b...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,88 @@
+// Command pvadecode prints the pvAccess messages in a packet capture or a hex dump, decoded with package pvdata,
+// for debugging interoperability with other pvAccess implementations.
+//
+// Usage:
+//
+//	pvadecode [-hex] [-x] [-ports 5075,5076] [file]
+//
+// The input is read from file, or from standard input if no file is given. By default it is a capture file in the
+// classic pcap format written by tcpdump (pcapng files can be converted with "editcap -F pcap"). TCP streams are
+// reassembled, and every TCP stream and UDP datagram whose payload starts with a pvAccess header is decoded.
+//
+// With -hex, the input is instead a hex dump of the bytes of one TCP stream or UDP datagram, such as the output of
+// xxd, hexdump -C, or Wireshark's "Copy as Hex Dump"; offsets at the start of lines and text columns are ignored.
+//
+// Each message is printed with its header and its decoded payload. The values of Get, Put, and Monitor responses
+// are decoded using the types announced by the responses to their INIT requests, so those must have been captured too.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	hexInput = flag.Bool("hex", false, "read a hex dump instead of a pcap file")
+	showHex  = flag.Bool("x", false, "also print the payload of every message in hex")
+	ports    = flag.String("ports", "", "comma-separated TCP and UDP ports to decode (default: any port carrying pvAccess)")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: pvadecode [-hex] [-x] [-ports list] [file]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var in io.Reader = os.Stdin
+	switch flag.NArg() {
+	case 0:
+	case 1:
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		in = f
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	p := &printer{w: out, showHex: *showHex}
+
+	if *hexInput {
+		data, err := parseHexDump(in)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s := newStream("hex dump", newConversation())
+		s.feed(p, data)
+		s.finish(p)
+		return
+	}
+	d := &decoder{p: p, streams: make(map[string]*stream), conversations: make(map[string]*conversation)}
+	if *ports != "" {
+		d.ports = make(map[uint16]bool)
+		for _, port := range strings.Split(*ports, ",") {
+			n, err := strconv.ParseUint(strings.TrimSpace(port), 10, 16)
+			if err != nil {
+				log.Fatalf("invalid port %q in -ports", port)
+			}
+			d.ports[uint16(n)] = true
+		}
+	}
+	if err := readPcap(bufio.NewReader(in), d.packet); err != nil {
+		out.Flush()
+		log.Fatal(err)
+	}
+	d.finish()
+}
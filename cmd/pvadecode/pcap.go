@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Link types of the captures that can be read, from https://www.tcpdump.org/linktypes.html.
+const (
+	linkNull      = 0
+	linkEthernet  = 1
+	linkRaw       = 101
+	linkLinuxSLL  = 113
+	linkIPv4      = 228
+	linkIPv6      = 229
+	linkLinuxSLL2 = 276
+)
+
+// packet is a TCP segment or UDP datagram from a capture.
+type packet struct {
+	time     time.Time
+	network  string
+	src, dst *net.TCPAddr
+	// seq and syn are only set for TCP.
+	seq     uint32
+	syn     bool
+	payload []byte
+}
+
+// readPcap calls handle with each TCP or UDP packet in the capture file r. Other packets are skipped.
+func readPcap(r io.Reader, handle func(p packet)) error {
+	var header [24]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return fmt.Errorf("reading pcap header: %w", err)
+	}
+	var order binary.ByteOrder
+	nanos := false
+	switch magic := binary.LittleEndian.Uint32(header[:]); magic {
+	case 0xa1b2c3d4:
+		order = binary.LittleEndian
+	case 0xd4c3b2a1:
+		order = binary.BigEndian
+	case 0xa1b23c4d:
+		order, nanos = binary.LittleEndian, true
+	case 0x4d3cb2a1:
+		order, nanos = binary.BigEndian, true
+	case 0x0a0d0d0a:
+		return errors.New("pcapng files are not supported; convert with editcap -F pcap")
+	default:
+		return fmt.Errorf("not a pcap file (magic %#08x)", magic)
+	}
+	link := order.Uint32(header[20:]) & 0xffff
+	switch link {
+	case linkNull, linkEthernet, linkRaw, linkLinuxSLL, linkIPv4, linkIPv6, linkLinuxSLL2:
+	default:
+		return fmt.Errorf("unsupported link type %d", link)
+	}
+	for {
+		var rec [16]byte
+		if _, err := io.ReadFull(r, rec[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("reading pcap record: %w", err)
+		}
+		sec, frac := order.Uint32(rec[0:]), order.Uint32(rec[4:])
+		if !nanos {
+			frac *= 1000
+		}
+		data := make([]byte, order.Uint32(rec[8:]))
+		if _, err := io.ReadFull(r, data); err != nil {
+			return fmt.Errorf("reading pcap record: %w", err)
+		}
+		p, ok := parseFrame(link, data)
+		if !ok {
+			continue
+		}
+		p.time = time.Unix(int64(sec), int64(frac))
+		handle(p)
+	}
+}
+
+// parseFrame parses a captured frame of the given link type.
+func parseFrame(link uint32, data []byte) (packet, bool) {
+	var ethertype uint16
+	switch link {
+	case linkNull:
+		// The address family is in the capturing host's byte order; IPv4 is 2 everywhere, IPv6 varies.
+		if len(data) < 4 {
+			return packet{}, false
+		}
+		return parseIP(data[4:])
+	case linkRaw, linkIPv4, linkIPv6:
+		return parseIP(data)
+	case linkEthernet:
+		if len(data) < 14 {
+			return packet{}, false
+		}
+		ethertype, data = binary.BigEndian.Uint16(data[12:]), data[14:]
+		for ethertype == 0x8100 && len(data) >= 4 {
+			// 802.1Q VLAN tag.
+			ethertype, data = binary.BigEndian.Uint16(data[2:]), data[4:]
+		}
+	case linkLinuxSLL:
+		if len(data) < 16 {
+			return packet{}, false
+		}
+		ethertype, data = binary.BigEndian.Uint16(data[14:]), data[16:]
+	case linkLinuxSLL2:
+		if len(data) < 20 {
+			return packet{}, false
+		}
+		ethertype, data = binary.BigEndian.Uint16(data[0:]), data[20:]
+	}
+	if ethertype != 0x0800 && ethertype != 0x86dd {
+		return packet{}, false
+	}
+	return parseIP(data)
+}
+
+// parseIP parses an IPv4 or IPv6 packet carrying TCP or UDP. Fragments and IPv6 extension headers are not supported.
+func parseIP(data []byte) (packet, bool) {
+	if len(data) < 1 {
+		return packet{}, false
+	}
+	var src, dst net.IP
+	var protocol byte
+	switch data[0] >> 4 {
+	case 4:
+		if len(data) < 20 {
+			return packet{}, false
+		}
+		headerLen := int(data[0]&0x0f) * 4
+		total := int(binary.BigEndian.Uint16(data[2:]))
+		if binary.BigEndian.Uint16(data[6:])&0x3fff != 0 || headerLen < 20 || total < headerLen || total > len(data) {
+			return packet{}, false
+		}
+		protocol = data[9]
+		src, dst = net.IP(data[12:16]), net.IP(data[16:20])
+		data = data[headerLen:total]
+	case 6:
+		if len(data) < 40 {
+			return packet{}, false
+		}
+		total := 40 + int(binary.BigEndian.Uint16(data[4:]))
+		if total > len(data) {
+			return packet{}, false
+		}
+		protocol = data[6]
+		src, dst = net.IP(data[8:24]), net.IP(data[24:40])
+		data = data[40:total]
+	default:
+		return packet{}, false
+	}
+	p := packet{src: &net.TCPAddr{IP: src}, dst: &net.TCPAddr{IP: dst}}
+	switch protocol {
+	case 6:
+		if len(data) < 20 {
+			return packet{}, false
+		}
+		offset := int(data[12]>>4) * 4
+		if offset < 20 || offset > len(data) {
+			return packet{}, false
+		}
+		p.network = "tcp"
+		p.seq = binary.BigEndian.Uint32(data[4:])
+		p.syn = data[13]&0x02 != 0
+		p.payload = data[offset:]
+	case 17:
+		if len(data) < 8 {
+			return packet{}, false
+		}
+		p.network = "udp"
+		p.payload = data[8:]
+	default:
+		return packet{}, false
+	}
+	p.src.Port = int(binary.BigEndian.Uint16(data[0:]))
+	p.dst.Port = int(binary.BigEndian.Uint16(data[2:]))
+	return p, true
+}
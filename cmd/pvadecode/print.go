@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+// printer writes decoded messages.
+type printer struct {
+	w       io.Writer
+	showHex bool
+	// time is when the packet being decoded was captured, if known.
+	time time.Time
+}
+
+func (p *printer) prefix(stream string) string {
+	if p.time.IsZero() {
+		return stream
+	}
+	return p.time.Format("15:04:05.000000") + " " + stream
+}
+
+// note reports a problem with a stream.
+func (p *printer) note(stream, msg string) {
+	fmt.Fprintf(p.w, "%s: %s\n", p.prefix(stream), msg)
+}
+
+// message prints a message, and v, its decoded payload, if it is not nil.
+func (p *printer) message(stream string, h proto.PVAccessHeader, payload []byte, v interface{}, err error) {
+	from := "client"
+	if h.Flags&proto.FLAG_FROM_SERVER != 0 {
+		from = "server"
+	}
+	if h.IsControl() {
+		fmt.Fprintf(p.w, "%s: %s control %s (%d)\n", p.prefix(stream), from, h.CommandName(), h.PayloadSize)
+		return
+	}
+	fmt.Fprintf(p.w, "%s: %s %s v%d, %d bytes\n", p.prefix(stream), from, h.CommandName(), h.Version, h.PayloadSize)
+	if v != nil {
+		writeValue(p.w, "  ", reflect.ValueOf(v))
+	}
+	if err != nil {
+		fmt.Fprintf(p.w, "  error: %v\n", err)
+	}
+	if p.showHex || v == nil || err != nil {
+		for _, line := range strings.SplitAfter(hex.Dump(payload), "\n") {
+			if line != "" {
+				fmt.Fprintf(p.w, "  %s", line)
+			}
+		}
+	}
+}
+
+var (
+	fieldDescType   = reflect.TypeOf(pvdata.FieldDesc{})
+	structDiffType  = reflect.TypeOf(pvdata.PVStructureDiff{})
+	pvStructureType = reflect.TypeOf(pvdata.PVStructure{})
+	pvAnyType       = reflect.TypeOf(pvdata.PVAny{})
+	pvStatusType    = reflect.TypeOf(pvdata.PVStatus{})
+	pvBitSetType    = reflect.TypeOf(pvdata.PVBitSet{})
+	guidType        = reflect.TypeOf([12]byte{})
+	addressType     = reflect.TypeOf([16]byte{})
+)
+
+var statusNames = map[pvdata.PVByte]string{
+	pvdata.PVStatus_OK:      "OK",
+	pvdata.PVStatus_WARNING: "WARNING",
+	pvdata.PVStatus_ERROR:   "ERROR",
+	pvdata.PVStatus_FATAL:   "FATAL",
+}
+
+// writeValue writes the fields of the structure v, one per line.
+func writeValue(w io.Writer, indent string, v reflect.Value) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		fmt.Fprintf(w, "%s%v\n", indent, v.Interface())
+		return
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath != "" {
+			continue
+		}
+		writeField(w, indent, t.Field(i).Name, v.Field(i))
+	}
+}
+
+// writeField writes the field name with value v.
+func writeField(w io.Writer, indent, name string, v reflect.Value) {
+	switch v.Type() {
+	case pvStatusType:
+		st := v.Interface().(pvdata.PVStatus)
+		fmt.Fprintf(w, "%s%s: %s", indent, name, statusNames[st.Type])
+		if st.Message != "" {
+			fmt.Fprintf(w, " %q", st.Message)
+		}
+		fmt.Fprintln(w)
+		if st.CallTree != "" {
+			fmt.Fprintf(w, "%s  call tree: %q\n", indent, st.CallTree)
+		}
+	case fieldDescType:
+		fmt.Fprintf(w, "%s%s:\n", indent, name)
+		writeFieldDesc(w, indent+"  ", "", v.Interface().(pvdata.FieldDesc))
+	case structDiffType:
+		diff := v.Interface().(pvdata.PVStructureDiff)
+		fmt.Fprintf(w, "%s%s: changed %s\n", indent, name, bits(diff.ChangedBitSet))
+		if pvs, ok := diff.Value.(pvdata.PVStructure); ok {
+			writePVStructure(w, indent+"  ", pvs)
+		}
+	case pvAnyType:
+		data := v.Interface().(pvdata.PVAny).Data
+		if data == nil {
+			fmt.Fprintf(w, "%s%s: null\n", indent, name)
+			return
+		}
+		if pvs, ok := data.(pvdata.PVStructure); ok {
+			fmt.Fprintf(w, "%s%s: %s\n", indent, name, strings.TrimSpace("structure "+string(pvs.ID)))
+			writePVStructure(w, indent+"  ", pvs)
+			return
+		}
+		fmt.Fprintf(w, "%s%s: %v\n", indent, name, reflect.Indirect(reflect.ValueOf(data)).Interface())
+	case pvBitSetType:
+		fmt.Fprintf(w, "%s%s: %s\n", indent, name, bits(v.Interface().(pvdata.PVBitSet)))
+	case guidType:
+		b := v.Interface().([12]byte)
+		fmt.Fprintf(w, "%s%s: %s\n", indent, name, hex.EncodeToString(b[:]))
+	case addressType:
+		b := v.Interface().([16]byte)
+		fmt.Fprintf(w, "%s%s: %v\n", indent, name, net.IP(b[:]))
+	default:
+		switch v.Kind() {
+		case reflect.Struct:
+			fmt.Fprintf(w, "%s%s:\n", indent, name)
+			writeValue(w, indent+"  ", v)
+		case reflect.Interface, reflect.Ptr:
+			if v.IsNil() {
+				fmt.Fprintf(w, "%s%s: null\n", indent, name)
+				return
+			}
+			writeField(w, indent, name, v.Elem())
+		case reflect.Slice:
+			if v.Type().Elem().Kind() == reflect.Struct {
+				fmt.Fprintf(w, "%s%s: %d\n", indent, name, v.Len())
+				for i := 0; i < v.Len(); i++ {
+					writeField(w, indent+"  ", fmt.Sprintf("[%d]", i), v.Index(i))
+				}
+				return
+			}
+			fmt.Fprintf(w, "%s%s: %v\n", indent, name, v.Interface())
+		default:
+			fmt.Fprintf(w, "%s%s: %v\n", indent, name, v.Interface())
+		}
+	}
+}
+
+// bits returns the set bits of bs, e.g. "{0, 3}".
+func bits(bs pvdata.PVBitSet) string {
+	var set []string
+	for i, present := range bs.Present {
+		if present {
+			set = append(set, fmt.Sprint(i))
+		}
+	}
+	return "{" + strings.Join(set, ", ") + "}"
+}
+
+// writePVStructure writes the fields of v, one per line, in the order of its type.
+func writePVStructure(w io.Writer, indent string, v pvdata.PVStructure) {
+	fd, err := v.FieldDesc()
+	if err != nil {
+		fmt.Fprintf(w, "%s%v\n", indent, v)
+		return
+	}
+	writeStructValue(w, indent, fd, v.ToMap())
+}
+
+func writeStructValue(w io.Writer, indent string, fd pvdata.FieldDesc, m map[string]interface{}) {
+	for _, f := range fd.Fields {
+		value, ok := m[f.Name]
+		if !ok {
+			continue
+		}
+		if sub, ok := value.(map[string]interface{}); ok && f.Field.TypeCode == pvdata.STRUCT {
+			fmt.Fprintf(w, "%s%s: %s\n", indent, f.Name, f.Field.StructType)
+			writeStructValue(w, indent+"  ", f.Field, sub)
+			continue
+		}
+		if s, ok := value.(string); ok {
+			value = fmt.Sprintf("%q", s)
+		}
+		fmt.Fprintf(w, "%s%s: %v\n", indent, f.Name, value)
+	}
+}
+
+var typeNames = map[byte]string{
+	pvdata.BOOLEAN:        "boolean",
+	pvdata.BYTE:           "byte",
+	pvdata.SHORT:          "short",
+	pvdata.INT:            "int",
+	pvdata.LONG:           "long",
+	pvdata.UBYTE:          "ubyte",
+	pvdata.USHORT:         "ushort",
+	pvdata.UINT:           "uint",
+	pvdata.ULONG:          "ulong",
+	pvdata.FLOAT:          "float",
+	pvdata.DOUBLE:         "double",
+	pvdata.STRING:         "string",
+	pvdata.BOUNDED_STRING: "string",
+	pvdata.STRUCT:         "structure",
+	pvdata.UNION:          "union",
+	pvdata.VARIANT_UNION:  "any",
+}
+
+// writeFieldDesc writes the type fd of the field name, with one line for each member of structures and unions.
+func writeFieldDesc(w io.Writer, indent, name string, fd pvdata.FieldDesc) {
+	if fd.TypeCode == pvdata.NULL_TYPE_CODE {
+		fmt.Fprintf(w, "%snull %s\n", indent, name)
+		return
+	}
+	base := fd.TypeCode
+	suffix := ""
+	if base&pvdata.ARRAY_BITS != 0 && base != pvdata.BOUNDED_STRING {
+		switch base & pvdata.ARRAY_BITS {
+		case pvdata.VARIABLE_ARRAY:
+			suffix = "[]"
+		default:
+			suffix = fmt.Sprintf("[%d]", fd.Size)
+		}
+		base &^= pvdata.ARRAY_BITS
+	}
+	typeName, ok := typeNames[base]
+	if !ok {
+		typeName = fmt.Sprintf("type(%#02x)", base)
+	}
+	line := strings.TrimSpace(fmt.Sprintf("%s%s %s", typeName, suffix, name))
+	if fd.StructType != "" {
+		line += " " + string(fd.StructType)
+	}
+	if fd.HasID {
+		line += fmt.Sprintf(" (id %d)", fd.ID)
+	}
+	fmt.Fprintf(w, "%s%s\n", indent, line)
+	for _, f := range fd.Fields {
+		writeFieldDesc(w, indent+"  ", f.Name, f.Field)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+// headerSize is the size of a pvAccess message header.
+const headerSize = 8
+
+// stream splits one direction of a TCP connection, or a UDP datagram, into messages.
+type stream struct {
+	name string
+	conv *conversation
+	// buf holds the start of a message that hasn't been completely received.
+	buf []byte
+
+	// started and nextSeq track the TCP sequence numbers of the stream.
+	started bool
+	nextSeq uint32
+	// checked is set once the stream's first data has been seen. Streams whose data doesn't start with
+	// a pvAccess header are ignored.
+	checked, ignored bool
+}
+
+func newStream(name string, conv *conversation) *stream {
+	return &stream{name: name, conv: conv}
+}
+
+// segment adds a TCP segment to the stream. Retransmitted data is dropped, and the stream resynchronizes
+// at the next segment that starts with a message header after data is missing from the capture.
+func (s *stream) segment(p *printer, seq uint32, syn bool, payload []byte) {
+	if syn {
+		s.started, s.nextSeq = true, seq+1
+		return
+	}
+	if len(payload) == 0 || s.ignored {
+		return
+	}
+	if !s.started {
+		s.started, s.nextSeq = true, seq
+	}
+	switch offset := int32(s.nextSeq - seq); {
+	case offset > 0:
+		// Retransmission of data already received.
+		if int(offset) >= len(payload) {
+			return
+		}
+		payload = payload[offset:]
+	case offset < 0:
+		p.note(s.name, fmt.Sprintf("%d bytes missing from capture", -offset))
+		s.buf = nil
+		s.nextSeq = seq
+	}
+	s.nextSeq += uint32(len(payload))
+	if !s.checked {
+		s.checked = true
+		s.ignored = !looksLikeMessage(payload)
+	}
+	if s.ignored {
+		return
+	}
+	if len(s.buf) == 0 && !looksLikeMessage(payload) {
+		// Still looking for the start of a message after lost data.
+		return
+	}
+	s.feed(p, payload)
+}
+
+// looksLikeMessage reports whether data starts with a pvAccess header.
+func looksLikeMessage(data []byte) bool {
+	return len(data) >= 2 && data[0] == proto.MAGIC && data[1] <= 2
+}
+
+// feed adds data to the stream, and prints every message it completes.
+func (s *stream) feed(p *printer, data []byte) {
+	s.buf = append(s.buf, data...)
+	for len(s.buf) >= headerSize {
+		if s.buf[0] != proto.MAGIC {
+			p.note(s.name, fmt.Sprintf("lost synchronization; skipping %d bytes", len(s.buf)))
+			s.buf = nil
+			return
+		}
+		h := proto.PVAccessHeader{
+			Version:        pvdata.PVByte(s.buf[1]),
+			Flags:          pvdata.PVUByte(s.buf[2]),
+			MessageCommand: pvdata.PVByte(s.buf[3]),
+		}
+		var order binary.ByteOrder = binary.LittleEndian
+		if h.Flags&proto.FLAG_BO_BE != 0 {
+			order = binary.BigEndian
+		}
+		h.PayloadSize = pvdata.PVInt(order.Uint32(s.buf[4:]))
+		if h.IsControl() {
+			p.message(s.name, h, nil, nil, nil)
+			s.buf = s.buf[headerSize:]
+			continue
+		}
+		if h.PayloadSize < 0 {
+			p.note(s.name, fmt.Sprintf("invalid payload size %d; skipping %d bytes", h.PayloadSize, len(s.buf)))
+			s.buf = nil
+			return
+		}
+		end := headerSize + int(h.PayloadSize)
+		if len(s.buf) < end {
+			return
+		}
+		payload := s.buf[headerSize:end]
+		var v interface{}
+		var err error
+		switch {
+		case h.Flags&proto.FLAG_COMPRESSED != 0:
+			err = fmt.Errorf("payload is compressed")
+		case h.Flags&proto.FLAG_SEGMENT_MASK != 0:
+			err = fmt.Errorf("segmented messages are not supported")
+		default:
+			v, err = s.conv.decode(h, payload)
+		}
+		p.message(s.name, h, payload, v, err)
+		s.buf = s.buf[end:]
+	}
+}
+
+// finish reports any incomplete message left in the stream.
+func (s *stream) finish(p *printer) {
+	if len(s.buf) > 0 {
+		p.note(s.name, fmt.Sprintf("capture ends with %d bytes of an incomplete message", len(s.buf)))
+		s.buf = nil
+	}
+}
+
+// decoder decodes the packets of a capture.
+type decoder struct {
+	p *printer
+	// ports, if non-nil, are the only ports decoded.
+	ports         map[uint16]bool
+	streams       map[string]*stream
+	conversations map[string]*conversation
+	// order lists the streams in the order they were seen.
+	order []*stream
+}
+
+func (d *decoder) packet(pk packet) {
+	if d.ports != nil && !d.ports[uint16(pk.src.Port)] && !d.ports[uint16(pk.dst.Port)] {
+		return
+	}
+	name := fmt.Sprintf("%s %v > %v", pk.network, pk.src, pk.dst)
+	d.p.time = pk.time
+	if pk.network == "udp" {
+		if !looksLikeMessage(pk.payload) {
+			return
+		}
+		s := newStream(name, newConversation())
+		s.feed(d.p, pk.payload)
+		s.finish(d.p)
+		return
+	}
+	s := d.streams[name]
+	if s == nil {
+		a, b := pk.src.String(), pk.dst.String()
+		if b < a {
+			a, b = b, a
+		}
+		key := a + " " + b
+		conv := d.conversations[key]
+		if conv == nil {
+			conv = newConversation()
+			d.conversations[key] = conv
+		}
+		s = newStream(name, conv)
+		d.streams[name] = s
+		d.order = append(d.order, s)
+	}
+	s.segment(d.p, pk.seq, pk.syn, pk.payload)
+}
+
+// finish reports the incomplete messages left at the end of the capture.
+func (d *decoder) finish() {
+	for _, s := range d.order {
+		s.finish(d.p)
+	}
+}
@@ -0,0 +1,335 @@
+// Command pvaloadtest measures the performance of a pvAccess server by running many clients against it.
+//
+// Each client opens its own TCP connection and either performs Gets at a fixed rate or monitors the channel.
+// When the test finishes, latency percentiles for Gets and event counts for monitors are reported.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Lexcelon/go-pvaccess/internal/connection"
+	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+var (
+	addr     = flag.String("addr", "localhost:5075", "address of the server to test")
+	channel  = flag.String("channel", "gopvtest", "name of the channel to use")
+	clients  = flag.Int("clients", 10, "number of concurrent clients")
+	mode     = flag.String("mode", "get", `operation performed by each client ("get" or "monitor")`)
+	rate     = flag.Float64("rate", 10, "Gets per second per client")
+	duration = flag.Duration("duration", 10*time.Second, "length of the test")
+	timeout  = flag.Duration("timeout", time.Second, "time to wait for a Get before counting it as dropped and stopping the client")
+	verbose  = flag.Bool("v", false, "verbose mode")
+)
+
+// result holds the measurements of one client.
+type result struct {
+	latencies []time.Duration
+	// events is the number of monitor updates received.
+	events int
+	// drops counts Gets that failed or timed out, and monitor updates known to be missed.
+	drops int
+	err   error
+}
+
+func main() {
+	flag.Parse()
+
+	log.SetLevel(log.WarnLevel)
+	if *verbose {
+		log.SetLevel(log.DebugLevel)
+	}
+	var run func(ctx context.Context, c *client) result
+	switch *mode {
+	case "get":
+		run = runGets
+	case "monitor":
+		run = runMonitor
+	default:
+		fmt.Fprintf(os.Stderr, "unknown mode %q\n", *mode)
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+	results := make([]result, *clients)
+	var wg sync.WaitGroup
+	for i := range results {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx := ctxlog.WithField(ctx, "client", i)
+			c, err := dial(ctx, *addr)
+			if err != nil {
+				results[i].err = err
+				return
+			}
+			defer c.Close()
+			results[i] = run(ctx, c)
+		}()
+	}
+	wg.Wait()
+	report(results, *duration)
+}
+
+// client is a single connection to the server with one channel created.
+type client struct {
+	*connection.Connection
+	conn net.Conn
+	sid  pvdata.PVInt
+}
+
+func dial(ctx context.Context, addr string) (*client, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	c := &client{
+		Connection: connection.New(conn, proto.FLAG_FROM_CLIENT),
+		conn:       conn,
+	}
+	c.Version = 2
+	if err := c.handshake(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *client) Close() error {
+	return c.conn.Close()
+}
+
+// expect reads the next application message, which must have the given command, and decodes it into out.
+func (c *client) expect(ctx context.Context, command pvdata.PVByte, out interface{}) error {
+	msg, err := c.Next(ctx)
+	if err != nil {
+		return err
+	}
+	if msg.Header.MessageCommand != command {
+		return fmt.Errorf("got message command 0x%x, expected 0x%x", msg.Header.MessageCommand, command)
+	}
+	return msg.Decode(out)
+}
+
+func (c *client) handshake(ctx context.Context) error {
+	var req proto.ConnectionValidationRequest
+	if err := c.expect(ctx, proto.APP_CONNECTION_VALIDATION, &req); err != nil {
+		return fmt.Errorf("reading connection validation request: %w", err)
+	}
+	if err := c.SendApp(ctx, proto.APP_CONNECTION_VALIDATION, &proto.ConnectionValidationResponse{
+		ClientReceiveBufferSize:            pvdata.PVInt(c.ReceiveBufferSize()),
+		ClientIntrospectionRegistryMaxSize: 0x7fff,
+		AuthNZ:                             "anonymous",
+	}); err != nil {
+		return err
+	}
+	var validated proto.ConnectionValidated
+	if err := c.expect(ctx, proto.APP_CONNECTION_VALIDATED, &validated); err != nil {
+		return fmt.Errorf("reading connection validated: %w", err)
+	}
+	if validated.Status.Type != pvdata.PVStatus_OK {
+		return validated.Status
+	}
+	if err := c.SendApp(ctx, proto.APP_CHANNEL_CREATE, &proto.CreateChannelRequest{
+		Channels: []proto.CreateChannelRequest_Channel{{ClientChannelID: 1, ChannelName: *channel}},
+	}); err != nil {
+		return err
+	}
+	var created proto.CreateChannelResponse
+	if err := c.expect(ctx, proto.APP_CHANNEL_CREATE, &created); err != nil {
+		return fmt.Errorf("creating channel: %w", err)
+	}
+	if created.Status.Type != pvdata.PVStatus_OK {
+		return created.Status
+	}
+	c.sid = created.ServerChannelID
+	return nil
+}
+
+const requestID = 1
+
+func emptyRequest() pvdata.PVAny {
+	return pvdata.NewPVAny(&struct{}{})
+}
+
+func runGets(ctx context.Context, c *client) (res result) {
+	if err := c.SendApp(ctx, proto.APP_CHANNEL_GET, &proto.ChannelGetRequest{
+		ServerChannelID: c.sid,
+		RequestID:       requestID,
+		Subcommand:      proto.CHANNEL_GET_INIT,
+		PVRequest:       emptyRequest(),
+	}); err != nil {
+		res.err = err
+		return
+	}
+	var init proto.ChannelGetResponseInit
+	if err := c.expect(ctx, proto.APP_CHANNEL_GET, &init); err != nil {
+		res.err = err
+		return
+	}
+	if init.Status.Type != pvdata.PVStatus_OK {
+		res.err = init.Status
+		return
+	}
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / *rate))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		latency, err := c.get(ctx, init.PVStructureIF)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			// After a failure the connection may be out of sync, since the response could still arrive.
+			res.drops++
+			res.err = err
+			return
+		}
+		res.latencies = append(res.latencies, latency)
+	}
+}
+
+// get performs a single Get and returns its latency.
+func (c *client) get(ctx context.Context, fd pvdata.FieldDesc) (time.Duration, error) {
+	value, err := fd.Zero()
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	if err := c.SendApp(ctx, proto.APP_CHANNEL_GET, &proto.ChannelGetRequest{
+		ServerChannelID: c.sid,
+		RequestID:       requestID,
+		Subcommand:      proto.CHANNEL_GET_EXECUTE,
+	}); err != nil {
+		return 0, err
+	}
+	c.conn.SetReadDeadline(start.Add(*timeout))
+	defer c.conn.SetReadDeadline(time.Time{})
+	resp := proto.ChannelGetResponse{Value: pvdata.PVStructureDiff{Value: value}}
+	if err := c.expect(ctx, proto.APP_CHANNEL_GET, &resp); err != nil {
+		var nerr net.Error
+		if errors.As(err, &nerr) && nerr.Timeout() {
+			return 0, fmt.Errorf("no response after %v", *timeout)
+		}
+		return 0, err
+	}
+	if resp.Status.Type == pvdata.PVStatus_ERROR || resp.Status.Type == pvdata.PVStatus_FATAL {
+		return 0, resp.Status
+	}
+	return time.Since(start), nil
+}
+
+func runMonitor(ctx context.Context, c *client) (res result) {
+	if err := c.SendApp(ctx, proto.APP_CHANNEL_MONITOR, &proto.ChannelMonitorRequest{
+		ServerChannelID: c.sid,
+		RequestID:       requestID,
+		Subcommand:      proto.CHANNEL_MONITOR_INIT,
+		PVRequest:       emptyRequest(),
+	}); err != nil {
+		res.err = err
+		return
+	}
+	var init proto.ChannelMonitorResponseInit
+	if err := c.expect(ctx, proto.APP_CHANNEL_MONITOR, &init); err != nil {
+		res.err = err
+		return
+	}
+	if init.Status.Type != pvdata.PVStatus_OK {
+		res.err = init.Status
+		return
+	}
+	if err := c.SendApp(ctx, proto.APP_CHANNEL_MONITOR, &proto.ChannelMonitorRequest{
+		ServerChannelID: c.sid,
+		RequestID:       requestID,
+		Subcommand:      proto.CHANNEL_MONITOR_SUBSCRIPTION | proto.CHANNEL_MONITOR_SUBSCRIPTION_RUN,
+	}); err != nil {
+		res.err = err
+		return
+	}
+	last := math.NaN()
+	for {
+		value, err := init.PVStructureIF.Zero()
+		if err != nil {
+			res.err = err
+			return
+		}
+		resp := proto.ChannelMonitorResponse{Value: pvdata.PVStructureDiff{Value: value}}
+		if err := c.expect(ctx, proto.APP_CHANNEL_MONITOR, &resp); err != nil {
+			if ctx.Err() == nil {
+				res.err = err
+			}
+			return
+		}
+		res.events++
+		// Integer values that count up (like a counter simulation) reveal updates that were coalesced or lost.
+		if pvs, ok := resp.Value.Value.(pvdata.PVStructure); ok {
+			if v, ok := pvdata.IntValue(pvs.Field("value")); ok {
+				if gap := float64(v) - last - 1; gap > 0 {
+					res.drops += int(gap)
+				}
+				last = float64(v)
+			}
+		}
+	}
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+func report(results []result, duration time.Duration) {
+	var latencies []time.Duration
+	var events, drops, failed int
+	for i, r := range results {
+		if r.err != nil {
+			fmt.Fprintf(os.Stderr, "client %d: %v\n", i, r.err)
+			failed++
+		}
+		latencies = append(latencies, r.latencies...)
+		events += r.events
+		drops += r.drops
+	}
+	fmt.Printf("clients: %d (%d failed)\n", len(results), failed)
+	if *mode == "monitor" {
+		fmt.Printf("events: %d (%.1f/s)\n", events, float64(events)/duration.Seconds())
+		fmt.Printf("missed: %d\n", drops)
+		return
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	fmt.Printf("gets: %d (%.1f/s)\n", len(latencies), float64(len(latencies))/duration.Seconds())
+	fmt.Printf("dropped: %d\n", drops)
+	for _, p := range []float64{50, 90, 99, 100} {
+		fmt.Printf("p%v: %v\n", p, percentile(latencies, p))
+	}
+}
@@ -0,0 +1,75 @@
+// Command pvaserver runs a pvAccess server described by a configuration file, as described by package config,
+// serving its static PVs, simulated PVs, and gateway channels, as well as the "server" channel of every server.
+//
+// Usage:
+//
+//	pvaserver [-check] [-v] [-log_levels codec=info] -config server.json
+//
+// With -check, the configuration is only validated.
+//
+// On SIGHUP, the configuration file is reread, and its access rules, static PVs, and simulated PVs replace the
+// running ones; if the file is invalid, the server keeps its configuration. On SIGINT or SIGTERM, the server stops
+// accepting connections, lets the operations in progress finish for up to its drain timeout, saves its PVs if
+// autosave is configured, and exits.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+	"github.com/Lexcelon/go-pvaccess/config"
+	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
+)
+
+var (
+	configPath = flag.String("config", "", "JSON file describing the server")
+	check      = flag.Bool("check", false, "validate the configuration file and exit")
+	verbose    = flag.Bool("v", false, "verbose mode")
+	logLevels  = flag.String("log_levels", "", `comma-separated levels of log subsystems (search, connection, codec, provider), e.g. "codec=info,connection=debug"`)
+)
+
+func main() {
+	flag.Parse()
+	if *configPath == "" {
+		fmt.Fprintln(os.Stderr, "usage: pvaserver [-check] [-v] [-log_levels levels] -config file")
+		os.Exit(2)
+	}
+
+	log.SetLevel(log.InfoLevel)
+	if *verbose {
+		log.SetLevel(log.TraceLevel)
+	}
+	if err := pvaccess.SetLogLevels(*logLevels); err != nil {
+		log.Fatal(err)
+	}
+	if *check {
+		if _, err := config.Load(*configPath); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		ctxlog.L(ctx).Infof("received signal %s; exiting", sig)
+		cancel()
+	}()
+	s, err := config.BuildFile(ctx, *configPath)
+	if err != nil {
+		ctxlog.L(ctx).Fatalf("building server: %v", err)
+	}
+	go s.ReloadOnSignal(ctx)
+	if err := s.Run(ctx); err != nil && ctx.Err() == nil {
+		ctxlog.L(ctx).Fatalf("serving: %v", err)
+	}
+}
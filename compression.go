@@ -0,0 +1,92 @@
+package pvaccess
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/Lexcelon/go-pvaccess/types"
+)
+
+type Compressor = types.Compressor
+
+// defaultCompressionThreshold is the payload size above which messages are compressed, if no threshold is set.
+const defaultCompressionThreshold = 1024
+
+// compressionThreshold returns threshold, or the default if it is zero.
+func compressionThreshold(threshold int) int {
+	if threshold <= 0 {
+		return defaultCompressionThreshold
+	}
+	return threshold
+}
+
+// FlateCompressor compresses messages with DEFLATE (RFC 1951).
+// Its zero value compresses with flate.BestSpeed and limits decompressed messages to types.MaxPayloadSize;
+// it is safe for concurrent use.
+type FlateCompressor struct {
+	// Level is the compression level, from flate.BestSpeed to flate.BestCompression.
+	// Zero selects flate.BestSpeed, since messages are compressed on the connection's send path.
+	Level int
+	// MaxSize is the largest decompressed message; Decompress fails for larger ones.
+	// Zero selects types.MaxPayloadSize.
+	MaxSize int
+
+	writers sync.Pool // *flate.Writer
+	readers sync.Pool // io.ReadCloser implementing flate.Resetter
+}
+
+func (*FlateCompressor) Algorithm() int32 {
+	return 1
+}
+
+func (f *FlateCompressor) Compress(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	w, _ := f.writers.Get().(*flate.Writer)
+	if w == nil {
+		level := f.Level
+		if level == 0 {
+			level = flate.BestSpeed
+		}
+		var err error
+		if w, err = flate.NewWriter(buf, level); err != nil {
+			return nil, err
+		}
+	} else {
+		w.Reset(buf)
+	}
+	defer f.writers.Put(w)
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (f *FlateCompressor) Decompress(dst, src []byte) ([]byte, error) {
+	r, _ := f.readers.Get().(io.ReadCloser)
+	if r == nil {
+		r = flate.NewReader(bytes.NewReader(src))
+	} else if err := r.(flate.Resetter).Reset(bytes.NewReader(src), nil); err != nil {
+		return nil, err
+	}
+	defer f.readers.Put(r)
+	limit := f.MaxSize
+	if limit <= 0 {
+		limit = types.MaxPayloadSize
+	}
+	buf := bytes.NewBuffer(dst)
+	// Read one byte more than allowed, to tell a message of exactly limit bytes from a larger one.
+	n, err := buf.ReadFrom(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > int64(limit) {
+		return nil, fmt.Errorf("message decompresses to more than %d bytes", limit)
+	}
+	return buf.Bytes(), nil
+}
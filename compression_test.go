@@ -0,0 +1,27 @@
+package pvaccess
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFlateDecompressLimit(t *testing.T) {
+	f := &FlateCompressor{MaxSize: 1000}
+	for _, test := range []struct {
+		size int
+		ok   bool
+	}{{1000, true}, {1001, false}} {
+		src := bytes.Repeat([]byte{'x'}, test.size)
+		compressed, err := f.Compress(nil, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := f.Decompress(nil, compressed)
+		if test.ok && (err != nil || !bytes.Equal(got, src)) {
+			t.Errorf("Decompress of %d bytes = %d bytes, %v; want the original", test.size, len(got), err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("Decompress of %d bytes with MaxSize %d succeeded", test.size, f.MaxSize)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"sync"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+)
+
+// parseAccess parses the name of access rights.
+func parseAccess(name string) (pvaccess.AccessRights, error) {
+	switch name {
+	case "readwrite":
+		return pvaccess.AccessReadWrite, nil
+	case "read":
+		return pvaccess.AccessRead, nil
+	case "none":
+		return pvaccess.AccessNone, nil
+	}
+	return 0, fmt.Errorf("unknown access %q; want \"readwrite\", \"read\", or \"none\"", name)
+}
+
+// parseNetworks parses networks in CIDR notation.
+func parseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// inNetworks reports whether the address of peer is in one of networks.
+func inNetworks(networks []*net.IPNet, peer *pvaccess.Peer) bool {
+	addr, ok := peer.Addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(addr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// authorizer returns the Authorize function of the listener, or nil if it accepts every client.
+func (l Listener) authorizer() (func(ctx context.Context, peer *pvaccess.Peer) error, error) {
+	if len(l.Users) > 0 && !contains(l.AuthNZ, "ca") {
+		return nil, errors.New(`users can only be checked with the "ca" authentication method`)
+	}
+	networks, err := parseNetworks(l.Networks)
+	if err != nil {
+		return nil, err
+	}
+	if len(l.Users) == 0 && len(networks) == 0 {
+		return nil, nil
+	}
+	return func(ctx context.Context, peer *pvaccess.Peer) error {
+		if len(l.Users) > 0 && (peer.AuthNZ != "ca" || !contains(l.Users, peer.User)) {
+			return fmt.Errorf("user %q is not allowed to connect", peer.User)
+		}
+		if len(networks) > 0 && !inNetworks(networks, peer) {
+			return fmt.Errorf("address %v is not allowed to connect", peer.Addr)
+		}
+		return nil
+	}, nil
+}
+
+// accessController is the AccessController described by an Access.
+type accessController struct {
+	mu    sync.RWMutex
+	rules []accessRule
+	def   pvaccess.AccessRights
+}
+
+type accessRule struct {
+	AccessRule
+	networks []*net.IPNet
+	rights   pvaccess.AccessRights
+}
+
+// newAccessController returns the AccessController described by a.
+func newAccessController(a Access) (*accessController, error) {
+	ac := &accessController{def: pvaccess.AccessReadWrite}
+	if a.Default != "" {
+		var err error
+		if ac.def, err = parseAccess(a.Default); err != nil {
+			return nil, fmt.Errorf("default access: %w", err)
+		}
+	}
+	for i, r := range a.Rules {
+		rule := accessRule{AccessRule: r}
+		var err error
+		if rule.rights, err = parseAccess(r.Access); err != nil {
+			return nil, fmt.Errorf("access rule %d: %w", i, err)
+		}
+		for _, pattern := range r.Channels {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("access rule %d: channel pattern %q: %w", i, pattern, err)
+			}
+		}
+		if rule.networks, err = parseNetworks(r.Networks); err != nil {
+			return nil, fmt.Errorf("access rule %d: %w", i, err)
+		}
+		ac.rules = append(ac.rules, rule)
+	}
+	return ac, nil
+}
+
+// set replaces the rules of ac with those of other.
+func (ac *accessController) set(other *accessController) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	ac.rules, ac.def = other.rules, other.def
+}
+
+func (ac *accessController) AccessRights(ctx context.Context, channel string) pvaccess.AccessRights {
+	peer, _ := pvaccess.PeerFromContext(ctx)
+	ac.mu.RLock()
+	defer ac.mu.RUnlock()
+	for i := range ac.rules {
+		if ac.rules[i].matches(peer, channel) {
+			return ac.rules[i].rights
+		}
+	}
+	return ac.def
+}
+
+// matches reports whether r applies to the access of peer, which may be nil, to channel.
+func (r *accessRule) matches(peer *pvaccess.Peer, channel string) bool {
+	if len(r.Channels) > 0 {
+		var ok bool
+		for _, pattern := range r.Channels {
+			if ok, _ = path.Match(pattern, channel); ok {
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	if len(r.Users)+len(r.Hosts)+len(r.networks) == 0 {
+		return true
+	}
+	if peer == nil {
+		return false
+	}
+	ca := peer.AuthNZ == "ca"
+	return (len(r.Users) == 0 || ca && contains(r.Users, peer.User)) &&
+		(len(r.Hosts) == 0 || ca && contains(r.Hosts, peer.Host)) &&
+		(len(r.networks) == 0 || inNetworks(r.networks, peer))
+}
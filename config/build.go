@@ -0,0 +1,432 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+	"github.com/Lexcelon/go-pvaccess/client"
+	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
+	"github.com/Lexcelon/go-pvaccess/provider/memory"
+	"github.com/Lexcelon/go-pvaccess/provider/mirror"
+	"github.com/Lexcelon/go-pvaccess/provider/rewrite"
+	"github.com/Lexcelon/go-pvaccess/provider/sim"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/Lexcelon/go-pvaccess/types"
+)
+
+// defaultAddress is the address listened on by a listener without one, and by a server without listeners.
+const defaultAddress = ":5075"
+
+// defaultAutosaveInterval is the interval of an Autosave without one.
+const defaultAutosaveInterval = 30 * time.Second
+
+// Server is a pvaccess.Server built from a Config, along with its listeners and the providers it runs.
+type Server struct {
+	*pvaccess.Server
+
+	stableGUID bool
+	listeners  []listener
+	access     *accessController
+	memory     *memory.Provider
+	sim        *sim.Provider
+	autosave   time.Duration
+	client     *client.Client
+	mirror     *mirror.Provider
+
+	// mu serializes reloads.
+	mu sync.Mutex
+	// config is the configuration the server was built or last reloaded from.
+	config *Config
+	// load reads the configuration to reload, if the server can be reloaded.
+	load func() (*Config, error)
+}
+
+// listener is a Listener that hasn't been opened yet.
+type listener struct {
+	address   string
+	authNZ    []string
+	authorize func(ctx context.Context, peer *pvaccess.Peer) error
+}
+
+// Build returns the server described by c, restoring the saved values of its PVs.
+// The server doesn't listen until Run is called.
+func (c *Config) Build(ctx context.Context) (*Server, error) {
+	s, err := c.build()
+	if err != nil {
+		return nil, err
+	}
+	if s.autosave > 0 {
+		if err := s.memory.Restore(ctx); err != nil {
+			s.close()
+			return nil, fmt.Errorf("restoring PVs: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// build returns the server described by c without restoring its PVs or connecting to anything.
+func (c *Config) build() (*Server, error) {
+	srv, err := pvaccess.NewServer()
+	if err != nil {
+		return nil, err
+	}
+	srv.DisableSearch = c.DisableSearch
+	srv.HeartbeatInterval = time.Duration(c.HeartbeatInterval)
+	srv.DrainTimeout = time.Duration(c.DrainTimeout)
+	srv.StuckRequestThreshold = time.Duration(c.StuckRequestThreshold)
+	srv.CancelStuckRequests = c.CancelStuckRequests
+	srv.SlowOpThreshold = time.Duration(c.SlowOpThreshold)
+	srv.MonitorFlushInterval = time.Duration(c.MonitorFlushInterval)
+	srv.MonitorDeadTime = time.Duration(c.MonitorDeadTime)
+	srv.MonitorIdleTimeout = time.Duration(c.MonitorIdleTimeout)
+	srv.ShareMonitors = c.ShareMonitors
+	srv.SegmentSize = c.SegmentSize
+	srv.ConnectionBufferLimit = c.ConnectionBufferLimit
+	srv.MaxWorkers = c.MaxWorkers
+	srv.MaxConnectionWorkers = c.MaxConnectionWorkers
+	srv.WorkerQueueLimit = c.WorkerQueueLimit
+	srv.Strict = c.Strict
+	srv.DebugStatus = c.DebugStatus
+	srv.EchoOperationIDs = c.EchoOperationIDs
+	srv.MetricsPrefix = c.MetricsPrefix
+	srv.HealthAddr = c.HealthAddr
+	if c.Advertise != "" {
+		addr, err := net.ResolveTCPAddr("tcp", c.Advertise)
+		if err != nil {
+			return nil, fmt.Errorf("advertised address: %w", err)
+		}
+		srv.ServerAddressOverride = addr
+	}
+	s := &Server{Server: srv, stableGUID: c.StableGUID}
+
+	listeners := c.Listeners
+	if len(listeners) == 0 {
+		listeners = []Listener{{}}
+	}
+	for i, l := range listeners {
+		if l.Address == "" {
+			l.Address = defaultAddress
+		}
+		if _, _, err := net.SplitHostPort(l.Address); err != nil {
+			return nil, fmt.Errorf("listener %d: %w", i, err)
+		}
+		authorize, err := l.authorizer()
+		if err != nil {
+			return nil, fmt.Errorf("listener %d: %w", i, err)
+		}
+		s.listeners = append(s.listeners, listener{address: l.Address, authNZ: l.AuthNZ, authorize: authorize})
+	}
+
+	if s.access, err = newAccessController(c.Access); err != nil {
+		return nil, err
+	}
+	srv.AddAccessController(s.access)
+
+	var store memory.Store
+	if a := c.Autosave; a != nil {
+		if a.Path == "" {
+			return nil, errors.New("autosave has no path")
+		}
+		if a.Interval < 0 {
+			return nil, fmt.Errorf("autosave interval %v is negative", time.Duration(a.Interval))
+		}
+		store = memory.FileStore{Path: a.Path}
+		s.autosave = time.Duration(a.Interval)
+		if s.autosave == 0 {
+			s.autosave = defaultAutosaveInterval
+		}
+	}
+	s.memory = memory.New(store)
+	pvs, err := c.memoryPVs()
+	if err != nil {
+		return nil, err
+	}
+	for _, pv := range pvs {
+		if err := s.memory.Add(pv); err != nil {
+			return nil, err
+		}
+	}
+	simPVs, err := c.simPVs()
+	if err != nil {
+		return nil, err
+	}
+	if s.sim, err = sim.New(simPVs...); err != nil {
+		return nil, err
+	}
+	srv.AddChannelProvider(&provider{s})
+
+	if g := c.Gateway; g != nil {
+		provider, err := s.gateway(g)
+		if err != nil {
+			s.close()
+			return nil, fmt.Errorf("gateway: %w", err)
+		}
+		srv.AddChannelProvider(provider)
+	}
+	s.config = c
+	return s, nil
+}
+
+// memoryPVs returns the memory provider's PVs described by c.PVs, checking that their names are unique.
+func (c *Config) memoryPVs() ([]memory.PV, error) {
+	names := make(map[string]bool)
+	pvs := make([]memory.PV, len(c.PVs))
+	for i, pv := range c.PVs {
+		var err error
+		if pvs[i], err = pv.memoryPV(); err != nil {
+			return nil, fmt.Errorf("PV %d: %w", i, err)
+		}
+		if names[pv.Name] {
+			return nil, fmt.Errorf("PV %d: duplicate PV %q", i, pv.Name)
+		}
+		names[pv.Name] = true
+	}
+	return pvs, nil
+}
+
+// simPVs returns the sim provider's PVs described by c.Sim, checking that their names are unique among every PV.
+func (c *Config) simPVs() ([]sim.PV, error) {
+	names := make(map[string]bool)
+	for _, pv := range c.PVs {
+		names[pv.Name] = true
+	}
+	pvs := make([]sim.PV, len(c.Sim))
+	for i, pv := range c.Sim {
+		var err error
+		if pvs[i], err = pv.simPV(); err != nil {
+			return nil, fmt.Errorf("simulated PV %d: %w", i, err)
+		}
+		if names[pv.Name] {
+			return nil, fmt.Errorf("simulated PV %d: duplicate PV %q", i, pv.Name)
+		}
+		names[pv.Name] = true
+	}
+	return pvs, nil
+}
+
+// simPV returns the sim provider's PV described by pv.
+func (pv SimPV) simPV() (sim.PV, error) {
+	if pv.Name == "" {
+		return sim.PV{}, errors.New("PV has no name")
+	}
+	w := sim.Sine
+	if pv.Waveform != "" {
+		var err error
+		if w, err = sim.ParseWaveform(pv.Waveform); err != nil {
+			return sim.PV{}, fmt.Errorf("PV %q: %w", pv.Name, err)
+		}
+	}
+	return sim.PV{
+		Name:      pv.Name,
+		Waveform:  w,
+		Amplitude: pv.Amplitude,
+		Offset:    pv.Offset,
+		Period:    time.Duration(pv.Period),
+		Interval:  time.Duration(pv.Interval),
+	}, nil
+}
+
+// gateway returns the provider serving the upstream channels of g.
+func (s *Server) gateway(g *Gateway) (types.ChannelProvider, error) {
+	if len(g.Servers) == 0 && len(g.Search) == 0 {
+		return nil, errors.New("no upstream servers or search addresses")
+	}
+	if len(g.Mirrors) == 0 {
+		return nil, errors.New("no mirrors")
+	}
+	mirrors := make([]mirror.Mirror, len(g.Mirrors))
+	for i, m := range g.Mirrors {
+		mirrors[i] = mirror.Mirror{Name: m.Name, Upstream: m.Upstream}
+	}
+	s.client = client.New(g.Servers...)
+	s.client.SearchAddrs = g.Search
+	var err error
+	if s.mirror, err = mirror.New(s.client, mirrors...); err != nil {
+		return nil, err
+	}
+	if len(g.Rewrite) == 0 {
+		if g.StrictRewrite {
+			return nil, errors.New("strictRewrite without rewrite rules")
+		}
+		return s.mirror, nil
+	}
+	rules := make([]rewrite.Rule, len(g.Rewrite))
+	for i, r := range g.Rewrite {
+		rules[i] = rewrite.Rule{Prefix: r.Prefix, Pattern: r.Pattern, Replacement: r.Replacement}
+	}
+	p, err := rewrite.New(s.mirror, rules...)
+	if err != nil {
+		return nil, err
+	}
+	p.Strict = g.StrictRewrite
+	return p, nil
+}
+
+// scalarTypes are the types of PV values, by name.
+var scalarTypes = map[string]reflect.Type{
+	"boolean": reflect.TypeOf(pvdata.PVBoolean(false)),
+	"byte":    reflect.TypeOf(pvdata.PVByte(0)),
+	"short":   reflect.TypeOf(pvdata.PVShort(0)),
+	"int":     reflect.TypeOf(pvdata.PVInt(0)),
+	"long":    reflect.TypeOf(pvdata.PVLong(0)),
+	"ubyte":   reflect.TypeOf(pvdata.PVUByte(0)),
+	"ushort":  reflect.TypeOf(pvdata.PVUShort(0)),
+	"uint":    reflect.TypeOf(pvdata.PVUInt(0)),
+	"ulong":   reflect.TypeOf(pvdata.PVULong(0)),
+	"float":   reflect.TypeOf(pvdata.PVFloat(0)),
+	"double":  reflect.TypeOf(pvdata.PVDouble(0)),
+	"string":  reflect.TypeOf(pvdata.PVString("")),
+}
+
+// memoryPV returns the memory provider's PV described by pv.
+func (pv PV) memoryPV() (memory.PV, error) {
+	if pv.Name == "" {
+		return memory.PV{}, errors.New("PV has no name")
+	}
+	typ, err := pv.typ()
+	if err != nil {
+		return memory.PV{}, fmt.Errorf("PV %q: %w", pv.Name, err)
+	}
+	var value interface{}
+	if typ == "enum" {
+		if len(pv.Choices) == 0 {
+			return memory.PV{}, fmt.Errorf("PV %q: enum has no choices", pv.Name)
+		}
+		e := &pvdata.Enum{Choices: pv.Choices}
+		if len(pv.Value) > 0 {
+			if err := json.Unmarshal(pv.Value, &e.Index); err != nil {
+				return memory.PV{}, fmt.Errorf("PV %q: enum value: %w", pv.Name, err)
+			}
+		}
+		if e.Index < 0 || int(e.Index) >= len(e.Choices) {
+			return memory.PV{}, fmt.Errorf("PV %q: enum value %d is not the index of a choice", pv.Name, e.Index)
+		}
+		value = e
+	} else {
+		t, ok := scalarTypes[strings.TrimSuffix(typ, "[]")]
+		if !ok {
+			return memory.PV{}, fmt.Errorf("PV %q: unknown type %q", pv.Name, typ)
+		}
+		if strings.HasSuffix(typ, "[]") {
+			t = reflect.SliceOf(t)
+		}
+		if len(pv.Choices) > 0 {
+			return memory.PV{}, fmt.Errorf("PV %q: only enums have choices", pv.Name)
+		}
+		v := reflect.New(t)
+		if len(pv.Value) > 0 {
+			if err := json.Unmarshal(pv.Value, v.Interface()); err != nil {
+				return memory.PV{}, fmt.Errorf("PV %q: value: %w", pv.Name, err)
+			}
+		}
+		value = v.Interface()
+	}
+	return memory.PV{Name: pv.Name, Value: value, Display: pv.display()}, nil
+}
+
+// typ returns the type of pv's value, inferring it from the value if pv has no Type.
+func (pv PV) typ() (string, error) {
+	if pv.Type != "" {
+		return pv.Type, nil
+	}
+	return inferType(pv.Value)
+}
+
+func (pv PV) display() pvdata.Display {
+	return pvdata.NewDisplay(pv.LimitLow, pv.LimitHigh, pv.Units, pv.Precision).WithDescription(pv.Description)
+}
+
+// inferType returns the type of a PV whose value is the JSON value data.
+func inferType(data json.RawMessage) (string, error) {
+	if len(data) == 0 {
+		return "", errors.New("PV has neither a type nor a value")
+	}
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return "", err
+	}
+	suffix := ""
+	if a, ok := v.([]interface{}); ok {
+		if len(a) == 0 {
+			return "", errors.New("can't tell the type of an empty array")
+		}
+		v, suffix = a[0], "[]"
+	}
+	switch v.(type) {
+	case bool:
+		return "boolean" + suffix, nil
+	case float64:
+		return "double" + suffix, nil
+	case string:
+		return "string" + suffix, nil
+	}
+	return "", fmt.Errorf("can't tell the type of the value %s", data)
+}
+
+// Run listens on the server's listeners and serves them until ctx is cancelled, updating its simulated PVs and
+// saving the values of its PVs if autosave is configured. Once ctx is cancelled, the server drains its connections
+// for up to DrainTimeout, and Run then closes the server's upstream channels and connections.
+func (s *Server) Run(ctx context.Context) error {
+	defer s.close()
+	lns := make([]pvaccess.Listener, 0, len(s.listeners))
+	defer func() {
+		for _, l := range lns {
+			l.Close()
+		}
+	}()
+	for _, l := range s.listeners {
+		nl, err := net.Listen("tcp", l.address)
+		if err != nil {
+			return err
+		}
+		lns = append(lns, pvaccess.Listener{Listener: nl, AuthNZ: l.authNZ, Authorize: l.authorize})
+	}
+	if s.stableGUID {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		s.SetGUID(pvaccess.GUIDFromHost(hostname, lns[0].Addr().(*net.TCPAddr).Port))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.sim.Run(ctx)
+	}()
+	if s.autosave > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.memory.Autosave(ctx, s.autosave); err != nil && ctx.Err() == nil {
+				ctxlog.L(ctx).Errorf("autosave stopped: %v", err)
+			}
+		}()
+	}
+	err := s.ServeListeners(ctx, lns...)
+	cancel()
+	wg.Wait()
+	return err
+}
+
+// close closes the server's upstream channels and connections.
+func (s *Server) close() {
+	if s.mirror != nil {
+		s.mirror.Close()
+	}
+	if s.client != nil {
+		s.client.Close()
+	}
+}
@@ -0,0 +1,244 @@
+// Package config builds a fully configured Server from a configuration file, so that a server can be deployed
+// without writing Go.
+//
+// A configuration file is a JSON object in the form of Config, e.g.
+//
+//	{
+//		"listeners": [{"address": ":5075", "authnz": ["ca", "anonymous"]}],
+//		"heartbeatInterval": "10s",
+//		"access": {
+//			"default": "read",
+//			"rules": [{"channels": ["SR:*"], "users": ["operator"], "access": "readwrite"}]
+//		},
+//		"pvs": [
+//			{"name": "SR:setpoint", "type": "double", "value": 1.5, "units": "A", "precision": 2},
+//			{"name": "SR:mode", "type": "enum", "choices": ["off", "on"], "value": 0}
+//		],
+//		"sim": [{"name": "SR:current", "waveform": "sine", "amplitude": 5, "offset": 100, "interval": "500ms"}],
+//		"autosave": {"path": "/var/lib/pvaserver/autosave.json"},
+//		"gateway": {
+//			"servers": ["10.0.0.2:5075"],
+//			"mirrors": [{"name": "dev1:temp"}],
+//			"rewrite": [{"prefix": "SITE:", "replacement": "dev1:"}]
+//		}
+//	}
+//
+// Durations are strings in the form of time.ParseDuration. Fields that are omitted take their defaults, and unknown
+// fields are rejected, so that misspelled settings don't go unnoticed. YAML files must be converted to JSON.
+//
+// A server built with BuildFile rereads its file when Reload is called (e.g. by ReloadOnSignal on SIGHUP).
+// The access rules, static PVs, and simulated PVs change at once; the other settings only change when the server
+// is built again.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Config describes a server. The settings that correspond to fields of pvaccess.Server are documented there.
+type Config struct {
+	// Listeners are the TCP listeners that the server accepts connections on.
+	// If empty, the server listens on port 5075 of every interface.
+	Listeners []Listener `json:"listeners"`
+	// Advertise is the "host:port" advertised in beacons and search responses instead of the first listener's
+	// address, as Server.ServerAddressOverride. The port may be empty.
+	Advertise     string `json:"advertise"`
+	DisableSearch bool   `json:"disableSearch"`
+	// StableGUID derives the server's GUID from its host name and the port of its first listener (see
+	// pvaccess.GUIDFromHost), so that it keeps its identity across restarts. Otherwise the GUID is random.
+	StableGUID bool `json:"stableGUID"`
+
+	HeartbeatInterval     Duration `json:"heartbeatInterval"`
+	DrainTimeout          Duration `json:"drainTimeout"`
+	StuckRequestThreshold Duration `json:"stuckRequestThreshold"`
+	CancelStuckRequests   bool     `json:"cancelStuckRequests"`
+	SlowOpThreshold       Duration `json:"slowOpThreshold"`
+	MonitorFlushInterval  Duration `json:"monitorFlushInterval"`
+	MonitorDeadTime       Duration `json:"monitorDeadTime"`
+	MonitorIdleTimeout    Duration `json:"monitorIdleTimeout"`
+	ShareMonitors         bool     `json:"shareMonitors"`
+	SegmentSize           int      `json:"segmentSize"`
+	ConnectionBufferLimit int      `json:"connectionBufferLimit"`
+	MaxWorkers            int      `json:"maxWorkers"`
+	MaxConnectionWorkers  int      `json:"maxConnectionWorkers"`
+	WorkerQueueLimit      int      `json:"workerQueueLimit"`
+	Strict                bool     `json:"strict"`
+	DebugStatus           bool     `json:"debugStatus"`
+	EchoOperationIDs      bool     `json:"echoOperationIDs"`
+	MetricsPrefix         string   `json:"metricsPrefix"`
+	HealthAddr            string   `json:"healthAddr"`
+
+	// Access decides the access rights of clients to every channel.
+	Access Access `json:"access"`
+	// PVs are PVs whose values the server holds in memory (see the memory provider).
+	PVs []PV `json:"pvs"`
+	// Sim are PVs whose values follow waveforms (see the sim provider).
+	Sim []SimPV `json:"sim"`
+	// Autosave, if set, saves the values of PVs to a file, and restores them when the server starts.
+	Autosave *Autosave `json:"autosave"`
+	// Gateway, if set, serves channels of upstream servers.
+	Gateway *Gateway `json:"gateway"`
+}
+
+// Listener describes a TCP listener and the clients it accepts.
+type Listener struct {
+	// Address is the "host:port" to listen on. It defaults to ":5075".
+	Address string `json:"address"`
+	// AuthNZ lists the authentication methods offered to clients, e.g. ["ca", "anonymous"].
+	// If empty, only "anonymous" is offered.
+	AuthNZ []string `json:"authnz"`
+	// Users, if set, only accepts clients that authenticate with the "ca" method as one of these users.
+	Users []string `json:"users"`
+	// Networks, if set, only accepts clients whose addresses are in one of these networks, e.g. "10.0.0.0/8".
+	Networks []string `json:"networks"`
+}
+
+// Access describes the access rights of clients to channels.
+type Access struct {
+	// Default is the access to channels that no rule matches: "readwrite" (the default), "read", or "none".
+	Default string `json:"default"`
+	// Rules are tried in order, and the first that matches a client and channel decides the client's access.
+	Rules []AccessRule `json:"rules"`
+}
+
+// AccessRule decides the access rights of the clients and channels it matches.
+// Its conditions must all be met for it to match; those that are empty always are.
+type AccessRule struct {
+	// Channels are patterns of the names of the channels the rule applies to, in the syntax of path.Match, e.g. "SR:*".
+	Channels []string `json:"channels"`
+	// Users and Hosts are the users and host names claimed by clients that authenticated with the "ca" method.
+	Users []string `json:"users"`
+	Hosts []string `json:"hosts"`
+	// Networks are the networks of the clients' addresses, e.g. "10.0.0.0/8".
+	Networks []string `json:"networks"`
+	// Access is the access to the channels: "readwrite", "read", or "none".
+	Access string `json:"access"`
+}
+
+// PV describes a PV whose value is held in memory.
+type PV struct {
+	Name string `json:"name"`
+	// Type is the type of the value: "boolean", "byte", "short", "int", "long", "ubyte", "ushort", "uint", "ulong",
+	// "float", "double", or "string", any of these followed by "[]" for an array, or "enum".
+	// If empty, it is "boolean", "double", or "string", or an array of one of them, according to Value.
+	Type string `json:"type"`
+	// Value is the initial value, which defaults to the zero value of the type. The value of an enum is the
+	// index of its choice.
+	Value json.RawMessage `json:"value"`
+	// Choices are the choices of an enum.
+	Choices []string `json:"choices"`
+	// The display metadata of the PV.
+	Description string  `json:"description"`
+	Units       string  `json:"units"`
+	Precision   int     `json:"precision"`
+	LimitLow    float64 `json:"limitLow"`
+	LimitHigh   float64 `json:"limitHigh"`
+}
+
+// SimPV describes a simulated PV, as sim.PV.
+type SimPV struct {
+	Name string `json:"name"`
+	// Waveform is "sine" (the default), "ramp", "noise", or "counter".
+	Waveform  string   `json:"waveform"`
+	Amplitude float64  `json:"amplitude"`
+	Offset    float64  `json:"offset"`
+	Period    Duration `json:"period"`
+	Interval  Duration `json:"interval"`
+}
+
+// Autosave describes where and how often the values of PVs are saved.
+type Autosave struct {
+	// Path is the JSON file the values are saved in.
+	Path string `json:"path"`
+	// Interval is how often the values are saved if any of them changed. It defaults to 30 seconds.
+	Interval Duration `json:"interval"`
+}
+
+// Gateway describes the channels of upstream servers that the server serves.
+type Gateway struct {
+	// Servers and Search are the upstream servers' TCP addresses, and the UDP addresses to search for channels
+	// that aren't found on them, as client.Client.ServerAddrs and SearchAddrs.
+	Servers []string `json:"servers"`
+	Search  []string `json:"search"`
+	// Mirrors are the upstream channels that are served.
+	Mirrors []Mirror `json:"mirrors"`
+	// Rewrite rules rename channels before they are looked up among the Mirrors, as the rewrite provider does.
+	Rewrite []RewriteRule `json:"rewrite"`
+	// StrictRewrite only serves names that a Rewrite rule matches.
+	StrictRewrite bool `json:"strictRewrite"`
+}
+
+// Mirror describes a mirrored channel, as mirror.Mirror.
+type Mirror struct {
+	Name string `json:"name"`
+	// Upstream is the name of the channel on the upstream servers. It defaults to Name.
+	Upstream string `json:"upstream"`
+}
+
+// RewriteRule renames the channels that it matches, as rewrite.Rule.
+type RewriteRule struct {
+	Prefix      string `json:"prefix"`
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+}
+
+// Duration is a time.Duration written as a string such as "1.5s" or "100ms".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration %s is not a string such as \"5s\"", data)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Load reads the configuration file at path, as Parse.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Parse parses a configuration and checks it with Validate.
+func Parse(data []byte) (*Config, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	var c Config
+	if err := d.Decode(&c); err != nil {
+		return nil, fmt.Errorf("parsing server config: %w", err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Validate checks that c describes a server that Build can build, without building it.
+func (c *Config) Validate() error {
+	s, err := c.build()
+	if err != nil {
+		return err
+	}
+	s.close()
+	return nil
+}
@@ -0,0 +1,313 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/client"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseErrors(t *testing.T) {
+	for _, test := range []struct {
+		name, config, want string
+	}{
+		{"unknown field", `{"heartbeat": "1s"}`, "unknown field"},
+		{"bad duration", `{"heartbeatInterval": 5}`, "not a string"},
+		{"bad access", `{"access": {"default": "write"}}`, "unknown access"},
+		{"bad rule access", `{"access": {"rules": [{"access": ""}]}}`, "access rule 0"},
+		{"bad channel pattern", `{"access": {"rules": [{"channels": ["["], "access": "read"}]}}`, "channel pattern"},
+		{"users without ca", `{"listeners": [{"users": ["alice"]}]}`, "listener 0"},
+		{"bad network", `{"listeners": [{"networks": ["10.0.0.0"]}]}`, "invalid CIDR"},
+		{"bad address", `{"listeners": [{"address": "localhost"}]}`, "listener 0"},
+		{"unknown PV type", `{"pvs": [{"name": "x", "type": "decimal"}]}`, "unknown type"},
+		{"untyped PV", `{"pvs": [{"name": "x"}]}`, "neither a type nor a value"},
+		{"bad PV value", `{"pvs": [{"name": "x", "type": "int", "value": "one"}]}`, `PV "x": value`},
+		{"enum out of range", `{"pvs": [{"name": "x", "type": "enum", "choices": ["a"], "value": 1}]}`, "not the index"},
+		{"choices without enum", `{"pvs": [{"name": "x", "type": "int", "choices": ["a"]}]}`, "only enums"},
+		{"duplicate PV", `{"pvs": [{"name": "x", "value": 1}, {"name": "x", "value": 2}]}`, "PV 1"},
+		{"unknown waveform", `{"sim": [{"name": "x", "waveform": "square"}]}`, "unknown waveform"},
+		{"simulated PV without name", `{"sim": [{}]}`, "simulated PV 0"},
+		{"static and simulated PV", `{"pvs": [{"name": "x", "value": 1}], "sim": [{"name": "x"}]}`, "duplicate PV"},
+		{"autosave without path", `{"autosave": {}}`, "no path"},
+		{"gateway without servers", `{"gateway": {"mirrors": [{"name": "x"}]}}`, "no upstream servers"},
+		{"gateway without mirrors", `{"gateway": {"servers": ["127.0.0.1:5075"]}}`, "no mirrors"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse([]byte(test.config))
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("Parse(%s) = %v, want an error containing %q", test.config, err, test.want)
+			}
+		})
+	}
+}
+
+func TestDuration(t *testing.T) {
+	c, err := Parse([]byte(`{"heartbeatInterval": "1.5s", "autosave": {"path": "x.json", "interval": "100ms"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := time.Duration(c.HeartbeatInterval); got != 1500*time.Millisecond {
+		t.Errorf("heartbeatInterval = %v, want 1.5s", got)
+	}
+	if got := time.Duration(c.Autosave.Interval); got != 100*time.Millisecond {
+		t.Errorf("autosave interval = %v, want 100ms", got)
+	}
+}
+
+func TestMemoryPV(t *testing.T) {
+	for _, test := range []struct {
+		config string
+		want   interface{}
+	}{
+		{`{"name": "x", "value": true}`, pvdata.PVBoolean(true)},
+		{`{"name": "x", "value": 1.5}`, pvdata.PVDouble(1.5)},
+		{`{"name": "x", "value": "on"}`, pvdata.PVString("on")},
+		{`{"name": "x", "value": [1, 2]}`, []pvdata.PVDouble{1, 2}},
+		{`{"name": "x", "type": "ushort", "value": 3}`, pvdata.PVUShort(3)},
+		{`{"name": "x", "type": "long[]"}`, []pvdata.PVLong(nil)},
+		{`{"name": "x", "type": "enum", "choices": ["off", "on"], "value": 1}`, pvdata.Enum{Index: 1, Choices: []string{"off", "on"}}},
+	} {
+		c, err := Parse([]byte(`{"pvs": [` + test.config + `]}`))
+		if err != nil {
+			t.Errorf("Parse(%s): %v", test.config, err)
+			continue
+		}
+		pv, err := c.PVs[0].memoryPV()
+		if err != nil {
+			t.Errorf("memoryPV(%s): %v", test.config, err)
+			continue
+		}
+		// memoryPV returns a pointer to the value.
+		got := pv.Value
+		switch v := got.(type) {
+		case *pvdata.PVBoolean:
+			got = *v
+		case *pvdata.PVDouble:
+			got = *v
+		case *pvdata.PVString:
+			got = *v
+		case *[]pvdata.PVDouble:
+			got = *v
+		case *pvdata.PVUShort:
+			got = *v
+		case *[]pvdata.PVLong:
+			got = *v
+		case *pvdata.Enum:
+			got = *v
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("value of %s differs (-want +got):\n%s", test.config, diff)
+		}
+	}
+}
+
+// run runs s until the test ends, returning a client connected to it.
+func run(ctx context.Context, t *testing.T, s *Server) (*client.Client, func()) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+	select {
+	case <-s.Ready():
+	case err := <-done:
+		t.Fatalf("Run: %v", err)
+	}
+	cl := client.New(s.HealthStatus().Listening...)
+	return cl, func() {
+		cl.Close()
+		cancel()
+		<-done
+	}
+}
+
+func value(ctx context.Context, t *testing.T, cl *client.Client, name string) int64 {
+	t.Helper()
+	ch, err := cl.Channel(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := ch.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, ok := pvdata.IntValue(v.Field("value"))
+	if !ok {
+		t.Fatalf("%s has no integer value: %v", name, v)
+	}
+	return int64(x)
+}
+
+func put(ctx context.Context, t *testing.T, cl *client.Client, name string, x int32) error {
+	t.Helper()
+	ch, err := cl.Channel(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ch.Put(ctx, &struct {
+		Value int32 `pvaccess:"value"`
+	}{x})
+}
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	dir := t.TempDir()
+	autosave := filepath.Join(dir, "autosave.json")
+	path := filepath.Join(dir, "server.json")
+	writeFile(t, path, `{
+		"listeners": [{"address": "127.0.0.1:0"}],
+		"disableSearch": true,
+		"access": {"rules": [
+			{"channels": ["ro:*"], "access": "read"},
+			{"channels": ["no:*"], "access": "none"}
+		]},
+		"pvs": [
+			{"name": "rw:x", "type": "int", "value": 1, "units": "A"},
+			{"name": "ro:x", "type": "int", "value": 2},
+			{"name": "no:x", "type": "int", "value": 3}
+		],
+		"sim": [{"name": "sim:count", "waveform": "counter", "offset": 10, "interval": "1ms"}],
+		"autosave": {"path": "`+autosave+`", "interval": "10ms"}
+	}`)
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := c.Build(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl, stop := run(ctx, t, s)
+	if got := value(ctx, t, cl, "rw:x"); got != 1 {
+		t.Errorf("rw:x = %d, want 1", got)
+	}
+	if got := value(ctx, t, cl, "sim:count"); got < 10 {
+		t.Errorf("sim:count = %d, want at least 10", got)
+	}
+	if err := put(ctx, t, cl, "ro:x", 3); err == nil {
+		t.Error("Put to read-only channel succeeded")
+	}
+	if ch, err := cl.Channel(ctx, "no:x"); err == nil {
+		if _, err := ch.Get(ctx); err == nil {
+			t.Error("Get from channel without access succeeded")
+		}
+	}
+	if err := put(ctx, t, cl, "no:x", 4); err == nil {
+		t.Error("Put to channel without access succeeded")
+	}
+	if err := put(ctx, t, cl, "rw:x", 4); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	for {
+		if data, err := os.ReadFile(autosave); err == nil && strings.Contains(string(data), "4") {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for autosave")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	stop()
+
+	// A new server restores the saved value.
+	s, err = c.Build(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl, stop = run(ctx, t, s)
+	defer stop()
+	if got := value(ctx, t, cl, "rw:x"); got != 4 {
+		t.Errorf("restored rw:x = %d, want 4", got)
+	}
+	if got := value(ctx, t, cl, "ro:x"); got != 2 {
+		t.Errorf("ro:x = %d, want 2", got)
+	}
+}
+
+func TestReload(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "server.json")
+	writeFile(t, path, `{
+		"listeners": [{"address": "127.0.0.1:0"}],
+		"disableSearch": true,
+		"pvs": [
+			{"name": "x", "type": "int", "value": 1},
+			{"name": "y", "type": "int", "value": 2},
+			{"name": "z", "type": "int", "value": 3}
+		],
+		"sim": [{"name": "sim:a", "waveform": "counter"}]
+	}`)
+	s, err := BuildFile(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl, stop := run(ctx, t, s)
+	defer stop()
+	if err := put(ctx, t, cl, "x", 5); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := put(ctx, t, cl, "z", 6); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	writeFile(t, path, `{
+		"listeners": [{"address": "127.0.0.1:0"}],
+		"disableSearch": true,
+		"access": {"rules": [{"channels": ["x"], "access": "read"}]},
+		"pvs": [
+			{"name": "x", "type": "int", "value": 7, "units": "A"},
+			{"name": "z", "type": "long", "value": 8},
+			{"name": "w", "type": "int", "value": 9}
+		],
+		"sim": [{"name": "sim:b", "waveform": "counter", "offset": 100}]
+	}`)
+	if err := s.Reload(ctx); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	// Channels are created again by a new client, so that their access rights are those of the new rules.
+	cl2 := client.New(s.HealthStatus().Listening...)
+	defer cl2.Close()
+	for name, want := range map[string]int64{"x": 5, "z": 8, "w": 9, "sim:b": 100} {
+		if got := value(ctx, t, cl2, name); got < want || name != "sim:b" && got != want {
+			t.Errorf("%s after reload = %d, want %d", name, got, want)
+		}
+	}
+	if err := put(ctx, t, cl2, "x", 10); err == nil {
+		t.Error("Put to a channel made read-only by reload succeeded")
+	}
+	names, err := s.memory.ChannelList(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	simNames, err := s.sim.ChannelList(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"sim:b", "w", "x", "z"}, append(simNames, names...)); diff != "" {
+		t.Errorf("channels after reload differ (-want +got):\n%s", diff)
+	}
+
+	// An invalid configuration changes nothing.
+	writeFile(t, path, `{"pvs": [{"name": "x", "type": "decimal"}]}`)
+	if err := s.Reload(ctx); err == nil {
+		t.Error("Reload of an invalid configuration succeeded")
+	}
+	if got := value(ctx, t, cl2, "w"); got != 9 {
+		t.Errorf("w after failed reload = %d, want 9", got)
+	}
+}
@@ -0,0 +1,172 @@
+package config
+
+import (
+	"context"
+	"reflect"
+	"sort"
+
+	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
+	"github.com/Lexcelon/go-pvaccess/provider/memory"
+	"github.com/Lexcelon/go-pvaccess/provider/sim"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/Lexcelon/go-pvaccess/types"
+)
+
+// BuildFile returns the server described by the configuration file at path, as Load and Build.
+// The server's Reload method rereads the file.
+func BuildFile(ctx context.Context, path string) (*Server, error) {
+	c, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := c.Build(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.load = func() (*Config, error) {
+		return Load(path)
+	}
+	return s, nil
+}
+
+// provider serves the static and simulated PVs of a Server, and reloads the Server's configuration.
+type provider struct {
+	s *Server
+}
+
+func (p *provider) CreateChannel(ctx context.Context, name string) (types.Channel, error) {
+	if c, err := p.s.memory.CreateChannel(ctx, name); c != nil || err != nil {
+		return c, err
+	}
+	return p.s.sim.CreateChannel(ctx, name)
+}
+
+func (p *provider) ChannelList(ctx context.Context) ([]string, error) {
+	names, err := p.s.memory.ChannelList(ctx)
+	if err != nil {
+		return nil, err
+	}
+	simNames, err := p.s.sim.ChannelList(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names = append(names, simNames...)
+	sort.Strings(names)
+	return names, nil
+}
+
+// GroupPut puts values to several static PVs at once.
+func (p *provider) GroupPut(ctx context.Context, values map[string]pvdata.PVStructure) error {
+	return p.s.memory.GroupPut(ctx, values)
+}
+
+func (p *provider) Reload(ctx context.Context) error {
+	return p.s.reload(ctx)
+}
+
+// reload rereads the server's configuration, if it was built with BuildFile, and applies its access rules, static
+// PVs, and simulated PVs. Static PVs whose types haven't changed keep their values, and simulated PVs keep their
+// channels. If the configuration is invalid, nothing changes.
+func (s *Server) reload(ctx context.Context) error {
+	if s.load == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, err := s.load()
+	if err != nil {
+		return err
+	}
+	access, err := newAccessController(c.Access)
+	if err != nil {
+		return err
+	}
+	pvs, err := c.memoryPVs()
+	if err != nil {
+		return err
+	}
+	simPVs, err := c.simPVs()
+	if err != nil {
+		return err
+	}
+	if restartNeeded(s.config, c) {
+		ctxlog.L(ctx).Warnf("only access rules, PVs, and simulated PVs are reloaded; the other changes need a restart")
+	}
+
+	s.access.set(access)
+	err = s.reloadPVs(c.PVs, pvs)
+	s.reloadSim(simPVs)
+	config := *s.config
+	config.Access, config.PVs, config.Sim = c.Access, c.PVs, c.Sim
+	s.config = &config
+	ctxlog.L(ctx).Infof("reloaded %d PVs and %d simulated PVs", len(pvs), len(simPVs))
+	return err
+}
+
+// restartNeeded reports whether a and b differ in settings that reload doesn't apply.
+func restartNeeded(a, b *Config) bool {
+	x, y := *a, *b
+	x.Access, x.PVs, x.Sim = Access{}, nil, nil
+	y.Access, y.PVs, y.Sim = Access{}, nil, nil
+	return !reflect.DeepEqual(x, y)
+}
+
+// reloadPVs replaces the static PVs described by s.config with configs, whose memory provider PVs are pvs.
+// It returns the first error, after applying every PV that it can.
+func (s *Server) reloadPVs(configs []PV, pvs []memory.PV) error {
+	old := make(map[string]PV, len(s.config.PVs))
+	for _, pv := range s.config.PVs {
+		old[pv.Name] = pv
+	}
+	var firstErr error
+	keep := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	for i, pv := range configs {
+		prev, ok := old[pv.Name]
+		delete(old, pv.Name)
+		switch {
+		case !ok:
+		case sameType(prev, pv):
+			if display := pv.display(); !reflect.DeepEqual(prev.display(), display) {
+				keep(s.memory.SetDisplay(pv.Name, display))
+			}
+			continue
+		default:
+			keep(s.memory.Remove(pv.Name))
+		}
+		keep(s.memory.Add(pvs[i]))
+	}
+	for name := range old {
+		keep(s.memory.Remove(name))
+	}
+	return firstErr
+}
+
+// sameType reports whether the values of a and b have the same type, so that a's value can be kept for b.
+func sameType(a, b PV) bool {
+	typeA, _ := a.typ()
+	typeB, _ := b.typ()
+	return typeA == typeB && reflect.DeepEqual(a.Choices, b.Choices)
+}
+
+// reloadSim replaces the simulated PVs described by s.config with pvs.
+func (s *Server) reloadSim(pvs []sim.PV) {
+	old := make(map[string]bool, len(s.config.Sim))
+	for _, pv := range s.config.Sim {
+		old[pv.Name] = true
+	}
+	for _, pv := range pvs {
+		if old[pv.Name] {
+			delete(old, pv.Name)
+			s.sim.Reconfigure(pv)
+		} else {
+			s.sim.Add(pv)
+		}
+	}
+	for name := range old {
+		s.sim.Remove(name)
+	}
+}
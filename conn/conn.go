@@ -0,0 +1,107 @@
+// Package conn reads and writes framed pvAccess messages on a stream, for programs that act as nonstandard
+// pvAccess endpoints, such as test harnesses and protocol translators.
+//
+// A Conn handles the protocol below the application messages: it encodes and decodes message headers,
+// honors the peer's byte order, and answers echo and flow control messages. Segmented messages are not supported.
+// Connection validation and every other application message are left to the caller; their payloads are in package proto.
+package conn
+
+import (
+	"context"
+	"io"
+
+	"github.com/Lexcelon/go-pvaccess/internal/connection"
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+// ErrClosed is returned by sends on a Conn after Close has been called, and by Next once the Conn has been closed.
+var ErrClosed = connection.ErrConnectionClosed
+
+// Conn is one end of a pvAccess connection.
+// Sends are safe to call from any goroutine, but Next must only be called from one at a time.
+type Conn struct {
+	c *connection.Connection
+}
+
+// NewClient returns a Conn that sends messages from the client end of a connection on rw.
+func NewClient(rw io.ReadWriter) *Conn {
+	return newConn(rw, proto.FLAG_FROM_CLIENT)
+}
+
+// NewServer returns a Conn that sends messages from the server end of a connection on rw.
+func NewServer(rw io.ReadWriter) *Conn {
+	return newConn(rw, proto.FLAG_FROM_SERVER)
+}
+
+func newConn(rw io.ReadWriter, direction pvdata.PVUByte) *Conn {
+	c := connection.New(rw, direction)
+	c.Version = 2
+	return &Conn{c}
+}
+
+// SetVersion sets the protocol version sent in the header of each message. The default is 2.
+// It must be called before the Conn is used.
+func (c *Conn) SetVersion(version pvdata.PVByte) {
+	c.c.Version = version
+}
+
+// SetSerializer sets the Serializer that encodes and decodes the payloads of application messages, which is
+// pvdata.Binary by default. Headers and control messages are always binary. It must be called before the Conn is used.
+func (c *Conn) SetSerializer(s pvdata.Serializer) {
+	c.c.Serializer = s
+}
+
+// Next returns the next application message. Control messages and echo requests that arrive first are handled
+// by the Conn, and are not returned.
+func (c *Conn) Next(ctx context.Context) (*Message, error) {
+	msg, err := c.c.Next(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{Header: msg.Header, Data: msg.Data, msg: msg}, nil
+}
+
+// SendApp sends an application message with the given command.
+// payload is encoded with the Conn's Serializer, such as a *proto.SearchRequest, or sent as is if it is a []byte.
+func (c *Conn) SendApp(ctx context.Context, messageCommand pvdata.PVByte, payload interface{}) error {
+	return c.c.SendApp(ctx, messageCommand, payload)
+}
+
+// SendCtrl sends a control message with the given command, whose argument is carried in place of the payload size.
+func (c *Conn) SendCtrl(ctx context.Context, messageCommand pvdata.PVByte, payloadSize pvdata.PVInt) error {
+	return c.c.SendCtrl(ctx, messageCommand, payloadSize)
+}
+
+// Close closes the Conn, and rw if it implements io.Closer. It is safe to call more than once.
+func (c *Conn) Close() error {
+	return c.c.Close()
+}
+
+// Message is an application message received by a Conn.
+type Message struct {
+	Header proto.PVAccessHeader
+	// Data is the message's payload, after decompression.
+	// It is only valid until the next call to Next; use Copy to keep a message for longer.
+	Data []byte
+
+	msg *connection.Message
+}
+
+// Decode decodes the next part of the payload into out, in the byte order of the connection.
+// Successive calls decode successive parts of the payload.
+func (m *Message) Decode(out interface{}) error {
+	return m.msg.Decode(out)
+}
+
+// Peek decodes the start of the payload into out without affecting later calls to Decode,
+// e.g. to read the request ID that determines how the rest of the message is decoded.
+func (m *Message) Peek(out interface{}) error {
+	return m.msg.Peek(out)
+}
+
+// Copy returns a copy of m whose Data remains valid after the next call to Next.
+func (m *Message) Copy() *Message {
+	msg := m.msg.Copy()
+	return &Message{Header: msg.Header, Data: msg.Data, msg: msg}
+}
@@ -0,0 +1,83 @@
+package conn
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/google/go-cmp/cmp"
+)
+
+// pair returns the client and server ends of a TCP connection.
+func pair(t *testing.T) (*Conn, *Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	clientEnd, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverEnd, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, server := NewClient(clientEnd), NewServer(serverEnd)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+func TestConn(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, server := pair(t)
+
+	want := proto.SearchRequest{
+		SearchSequenceID: 7,
+		Flags:            proto.SEARCH_REPLY_REQUIRED,
+		Protocols:        []pvdata.PVString{"tcp"},
+		Channels:         []proto.SearchRequest_Channel{{SearchInstanceID: 1, ChannelName: "test"}},
+	}
+	// Control messages are answered by the Conn and never returned by Next.
+	if err := client.SendCtrl(ctx, proto.CTRL_ECHO_REQUEST, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SendApp(ctx, proto.APP_SEARCH_REQUEST, &want); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := server.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := msg.Header.CommandName(); name != "SEARCH_REQUEST" {
+		t.Errorf("received %s, want SEARCH_REQUEST", name)
+	}
+	if msg.Header.Flags&proto.FLAG_FROM_SERVER != 0 {
+		t.Errorf("message from client has flags %#x", msg.Header.Flags)
+	}
+	var id pvdata.PVUInt
+	if err := msg.Peek(&id); err != nil || id != 7 {
+		t.Errorf("Peek = %d, %v, want 7", id, err)
+	}
+	kept := msg.Copy()
+	var got proto.SearchRequest
+	if err := kept.Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("decoded search request differs (-want +got):\n%s", diff)
+	}
+
+	server.Close()
+	if err := server.SendApp(ctx, proto.APP_SEARCH_RESPONSE, &proto.SearchResponse{}); err != ErrClosed {
+		t.Errorf("SendApp after Close = %v, want ErrClosed", err)
+	}
+}
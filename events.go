@@ -0,0 +1,89 @@
+package pvaccess
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// EventKind is the kind of an Event.
+type EventKind int
+
+const (
+	// ClientConnected is sent once a client has validated its connection.
+	ClientConnected EventKind = iota
+	// ClientDisconnected is sent when the connection of a client that connected is closed.
+	ClientDisconnected
+	// ChannelCreated is sent when a client has created a channel.
+	ChannelCreated
+	// OperationFailed is sent when an operation on behalf of a client returns an error.
+	OperationFailed
+)
+
+var eventKindNames = map[EventKind]string{
+	ClientConnected:    "ClientConnected",
+	ClientDisconnected: "ClientDisconnected",
+	ChannelCreated:     "ChannelCreated",
+	OperationFailed:    "OperationFailed",
+}
+
+func (k EventKind) String() string {
+	if name, ok := eventKindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("EventKind(%d)", int(k))
+}
+
+// Event describes something that happened on a Server. Events are received from Events.
+type Event struct {
+	Kind EventKind
+	Time time.Time
+	// Peer is the client. It is nil for operations on a LocalChannel.
+	Peer *Peer
+	// ChannelName is the channel created, or the channel operated on.
+	ChannelName string
+	// Op is the operation that failed, for OperationFailed.
+	Op *Op
+	// Err is the error the operation failed with, for OperationFailed,
+	// or the error that closed the connection, if any, for ClientDisconnected.
+	Err error
+}
+
+// eventBufferSize is the number of events buffered by the channel returned by Events.
+const eventBufferSize = 256
+
+// eventQueue holds the channel returned by Events, once it has been called.
+type eventQueue struct {
+	mu sync.Mutex
+	ch chan Event
+}
+
+// Events returns a channel that receives the server's events, so that applications can follow its activity.
+// Every call returns the same channel, which is never closed.
+// Only events that happen after the first call are sent. The channel is buffered,
+// but events are dropped rather than slowing the server down when the buffer is full,
+// so it should be read continuously.
+func (srv *Server) Events() <-chan Event {
+	q := &srv.events
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.ch == nil {
+		q.ch = make(chan Event, eventBufferSize)
+	}
+	return q.ch
+}
+
+// emit sends e to the channel returned by Events, if it has been called and has room.
+func (srv *Server) emit(e Event) {
+	q := &srv.events
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.ch == nil {
+		return
+	}
+	e.Time = time.Now()
+	select {
+	case q.ch <- e:
+	default:
+	}
+}
@@ -0,0 +1,75 @@
+package pvaccess
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+// failingRPC is a channel whose RPCs fail.
+type failingRPC struct{}
+
+func (failingRPC) Name() string { return "fail" }
+
+func (f failingRPC) CreateChannel(ctx context.Context, name string) (Channel, error) {
+	if name == f.Name() {
+		return f, nil
+	}
+	return nil, nil
+}
+
+func (failingRPC) ChannelRPC(ctx context.Context, req pvdata.PVStructure) (interface{}, error) {
+	return nil, errors.New("out of order")
+}
+
+func TestEvents(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv := &Server{}
+	srv.AddChannelProvider(failingRPC{})
+	events := srv.Events()
+	next := func(kind EventKind) Event {
+		t.Helper()
+		select {
+		case e := <-events:
+			if e.Kind != kind {
+				t.Fatalf("got %v event, want %v", e.Kind, kind)
+			}
+			return e
+		case <-ctx.Done():
+			t.Fatalf("no %v event", kind)
+		}
+		return Event{}
+	}
+
+	tc := newTestClient(ctx, t, srv)
+	if e := next(ClientConnected); e.Peer == nil || e.Peer.AuthNZ != "anonymous" {
+		t.Errorf("ClientConnected peer = %+v", e.Peer)
+	}
+	sid := tc.createChannel(ctx, 1, "fail")
+	if e := next(ChannelCreated); e.ChannelName != "fail" {
+		t.Errorf("ChannelCreated channel = %q, want fail", e.ChannelName)
+	}
+	tc.send(ctx, proto.APP_CHANNEL_RPC, &proto.ChannelRPCRequest{
+		ServerChannelID: sid,
+		RequestID:       1,
+		Subcommand:      proto.CHANNEL_RPC_INIT,
+		PVRequest:       pvdata.NewPVAny(&struct{}{}),
+	})
+	var init proto.ChannelRPCResponseInit
+	tc.expect(ctx, proto.APP_CHANNEL_RPC, &init)
+	tc.send(ctx, proto.APP_CHANNEL_RPC, &proto.ChannelRPCRequest{
+		ServerChannelID: sid,
+		RequestID:       1,
+		PVRequest:       pvdata.NewPVAny(&struct{}{}),
+	})
+	if e := next(OperationFailed); e.Op == nil || e.Op.Kind != OpRPC || e.ChannelName != "fail" || e.Err == nil {
+		t.Errorf("OperationFailed event = %+v, want failed RPC on fail", e)
+	}
+	tc.nc.Close()
+	next(ClientDisconnected)
+}
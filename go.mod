@@ -1,6 +1,6 @@
 module github.com/Lexcelon/go-pvaccess
 
-go 1.17
+go 1.18
 
 require (
 	github.com/google/go-cmp v0.5.7
@@ -0,0 +1,102 @@
+package pvaccess
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/Lexcelon/go-pvaccess/types"
+)
+
+// GroupPut puts values to several channels at once, by channel name: either every value is put, or none is.
+// The channels must all be served by a single ChannelProvider that implements GroupPuter.
+//
+// The client in ctx (see PeerFromContext) must be allowed to write every channel,
+// and every value must pass the channels' validators, before anything is put.
+// Clients perform group puts with the groupPut op of the "server" RPC channel of servers created with NewServer.
+func (srv *Server) GroupPut(ctx context.Context, values map[string]pvdata.PVStructure) error {
+	if len(values) == 0 {
+		return nil
+	}
+	ctx = srv.withValues(ctx)
+	peer, ok := PeerFromContext(ctx)
+	if !ok {
+		peer = localPeer
+		ctx = types.WithPeer(ctx, peer)
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	gp, channels, err := srv.findGroupPuter(ctx, names)
+	if err != nil {
+		return err
+	}
+	for _, channel := range channels {
+		if srv.accessRights(ctx, channel)&AccessWrite == 0 {
+			return pvdata.PVStatus{
+				Type:    pvdata.PVStatus_ERROR,
+				Message: pvdata.PVString(fmt.Sprintf("no write access to channel %q", channel.Name())),
+			}
+		}
+	}
+	_, err = srv.intercept(ctx, &Op{
+		Kind:   OpGroupPut,
+		Peer:   peer,
+		Values: values,
+	}, func(ctx context.Context, op *Op) (interface{}, error) {
+		for _, channel := range channels {
+			value, ok := op.Values[channel.Name()]
+			if !ok {
+				return nil, fmt.Errorf("no value for channel %q", channel.Name())
+			}
+			puter, _ := channel.(ChannelPuter)
+			if err := srv.validatePut(ctx, channel, puter, value, nil); err != nil {
+				return nil, err
+			}
+		}
+		return nil, gp.GroupPut(ctx, op.Values)
+	})
+	return err
+}
+
+// findGroupPuter returns the first provider that implements GroupPuter and has every one of the channels names,
+// along with the channels.
+func (srv *Server) findGroupPuter(ctx context.Context, names []string) (GroupPuter, []Channel, error) {
+	for _, provider := range srv.ChannelProviders() {
+		gp, ok := provider.(GroupPuter)
+		if !ok {
+			continue
+		}
+		channels, err := createAll(ctx, provider, names)
+		if err != nil {
+			return nil, nil, err
+		}
+		if channels != nil {
+			return gp, channels, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("channels %q are not all served by a provider that supports group puts", names)
+}
+
+// createAll creates the channels names on provider, or returns nil if it doesn't have all of them.
+func createAll(ctx context.Context, provider ChannelProvider, names []string) ([]Channel, error) {
+	channels := make([]Channel, 0, len(names))
+	for _, name := range names {
+		c, err := provider.CreateChannel(ctx, name)
+		if errors.Is(err, ErrNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("creating channel %q: %w", name, err)
+		}
+		if c == nil {
+			return nil, nil
+		}
+		channels = append(channels, c)
+	}
+	return channels, nil
+}
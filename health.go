@@ -0,0 +1,101 @@
+package pvaccess
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/clock"
+	"github.com/Lexcelon/go-pvaccess/internal/connection"
+	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
+	"github.com/Lexcelon/go-pvaccess/types"
+)
+
+type ConnectionInfo = types.ConnectionInfo
+
+type RequestInfo = types.RequestInfo
+
+// defaultHeartbeatInterval is used when Server.HeartbeatInterval is zero.
+const defaultHeartbeatInterval = 15 * time.Second
+
+// Connections returns information about every client connection, in the order they were established.
+func (srv *Server) Connections() []ConnectionInfo {
+	srv.mu.RLock()
+	conns := make([]*serverConn, 0, len(srv.conns))
+	for c := range srv.conns {
+		conns = append(conns, c)
+	}
+	srv.mu.RUnlock()
+	infos := make([]ConnectionInfo, len(conns))
+	for i, c := range conns {
+		infos[i] = c.info()
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Established.Before(infos[j].Established)
+	})
+	return infos
+}
+
+func (srv *Server) addConn(c *serverConn) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.conns == nil {
+		srv.conns = make(map[*serverConn]struct{})
+	}
+	srv.conns[c] = struct{}{}
+}
+
+func (srv *Server) removeConn(c *serverConn) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	delete(srv.conns, c)
+}
+
+func (c *serverConn) info() ConnectionInfo {
+	h := c.Health()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	peer := *c.peer
+	version := c.Version
+	if h.PeerVersion < version {
+		version = h.PeerVersion
+	}
+	return ConnectionInfo{
+		LocalAddr:                    peer.LocalAddr,
+		RemoteAddr:                   peer.Addr,
+		Version:                      version,
+		ReceiveBufferSize:            c.clientReceiveBufferSize,
+		IntrospectionRegistryMaxSize: c.clientRegistryMaxSize,
+		AuthNZ:                       peer.AuthNZ,
+		Peer:                         &peer,
+		Established:                  h.Established,
+		LastActivity:                 h.LastActivity,
+		RTT:                          h.RTT,
+		Channels:                     len(c.channels),
+		Compressed:                   c.Compressed(),
+		InProgress:                   c.inProgressLocked(),
+		Buffered:                     c.Buffered(),
+		Overruns:                     int(atomic.LoadInt64(&c.overruns)),
+	}
+}
+
+// heartbeat pings the client every interval until ctx is cancelled, so that Connections can report the RTT.
+func (c *serverConn) heartbeat(ctx context.Context, interval time.Duration) {
+	ticker := clock.Or(c.srv.Clock).NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			if err := c.Ping(ctx); err != nil {
+				if !errors.Is(err, connection.ErrConnectionClosed) {
+					ctxlog.L(ctx).Warnf("sending heartbeat: %v", err)
+				}
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package pvaccess
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/Lexcelon/go-pvaccess/types"
+)
+
+// OpKind identifies the kind of operation passed to an Interceptor.
+type OpKind int
+
+const (
+	OpCreateChannel OpKind = iota
+	OpGet
+	OpPut
+	OpRPC
+	OpMonitor
+	OpGroupPut
+)
+
+var opKindNames = map[OpKind]string{
+	OpCreateChannel: "CreateChannel",
+	OpGet:           "Get",
+	OpPut:           "Put",
+	OpRPC:           "RPC",
+	OpMonitor:       "Monitor",
+	OpGroupPut:      "GroupPut",
+}
+
+func (k OpKind) String() string {
+	if name, ok := opKindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("OpKind(%d)", int(k))
+}
+
+// Op describes an operation performed on behalf of a client.
+type Op struct {
+	// ID uniquely identifies the operation to the server. It is assigned before the first interceptor is called,
+	// and is included in the server's log messages about the operation.
+	ID   string
+	Kind OpKind
+	// Init is true if the operation is the INIT request of a Get, Put, RPC, or Monitor.
+	Init bool
+	// ChannelName is the name of the channel the operation is for.
+	// Interceptors may change it before calling next on OpCreateChannel to rewrite the name looked up in the channel providers.
+	ChannelName string
+	// Channel is the channel the operation is for. It is nil for OpCreateChannel.
+	Channel Channel
+	// Peer is the client that requested the operation.
+	Peer *Peer
+	// Args is the pvRequest structure for INIT requests, or the arguments of an RPC.
+	// Interceptors may replace it before calling next.
+	Args pvdata.PVStructure
+	// Value is the value being put, for OpPut requests other than INIT.
+	Value pvdata.PVStructure
+	// ChangedFields names the fields of Value that the client sent, for OpPut requests other than INIT.
+	ChangedFields []string
+	// Values are the values being put by an OpGroupPut, by channel name.
+	// ChannelName and Channel are not set, since the operation is on several channels.
+	Values map[string]pvdata.PVStructure
+}
+
+// OpHandler performs an operation and returns the provider's result:
+//
+// - OpCreateChannel: the Channel, or nil if no provider has the channel
+// - OpGet: a ChannelGeter for INIT, whose structure is described to the client by reading a value while handling INIT,
+// or the value read
+// - OpPut: a ChannelPuter for INIT, or nil
+// - OpRPC: a ChannelRPCer for INIT, or the RPC response
+// - OpMonitor: a Nexter (only INIT is intercepted)
+// - OpGroupPut: nil
+type OpHandler func(ctx context.Context, op *Op) (interface{}, error)
+
+// Interceptor wraps every operation performed by a Server.
+// An Interceptor may inspect or modify op, reject the operation by returning an error without calling next,
+// or wrap the result of next.
+type Interceptor func(ctx context.Context, op *Op, next OpHandler) (interface{}, error)
+
+// AddInterceptor adds i to the chain of interceptors applied to every operation.
+// Interceptors are called in the order they were added; the first one added is the outermost.
+func (srv *Server) AddInterceptor(i Interceptor) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.interceptors = append(srv.interceptors, i)
+}
+
+// operationIDs allocates the sequence numbers in operation IDs.
+type operationIDs struct {
+	mu   sync.Mutex
+	last uint64
+}
+
+func (ids *operationIDs) next() uint64 {
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+	ids.last++
+	return ids.last
+}
+
+// OperationIDFromContext returns the ID of the operation running in ctx.
+var OperationIDFromContext = types.OperationIDFromContext
+
+// intercept runs handler for op, wrapped by the server's interceptors.
+func (srv *Server) intercept(ctx context.Context, op *Op, handler OpHandler) (interface{}, error) {
+	ctx = srv.withValues(ctx)
+	srv.mu.RLock()
+	interceptors := srv.interceptors
+	guid := srv.guid
+	srv.mu.RUnlock()
+	if guid == ([12]byte{}) {
+		guid = srv.GUID()
+	}
+	op.ID = fmt.Sprintf("%x-%d", guid[:4], srv.opIDs.next())
+	fields := ctxlog.Fields{"op_id": op.ID}
+	if parent, ok := OperationIDFromContext(ctx); ok {
+		// The operation is being performed on behalf of another, e.g. by a channel that forwards to a LocalChannel.
+		fields["parent_op_id"] = parent
+	}
+	ctx = ctxlog.WithFields(types.WithOperationID(ctx, op.ID), fields)
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(ctx context.Context, op *Op) (interface{}, error) {
+			return interceptor(ctx, op, next)
+		}
+	}
+	start := time.Now()
+	result, err := handler(ctx, op)
+	srv.opFinished(ctx, op, time.Since(start))
+	if err != nil {
+		srv.emit(Event{Kind: OperationFailed, Peer: op.Peer, ChannelName: op.ChannelName, Op: op, Err: err})
+	}
+	if err != nil && srv.EchoOperationIDs {
+		err = withOperationID(err, op.ID)
+	}
+	return result, err
+}
+
+// withOperationID adds the operation ID id to the message of err, or of the status it wraps.
+func withOperationID(err error, id string) error {
+	var s pvdata.PVStatus
+	if errors.As(err, &s) {
+		s.Message = pvdata.PVString(fmt.Sprintf("%s [op %s]", s.Message, id))
+		return s
+	}
+	return operationError{id, err}
+}
+
+// operationError is an error annotated with the ID of the operation that failed with it.
+type operationError struct {
+	id  string
+	err error
+}
+
+func (e operationError) Error() string {
+	return fmt.Sprintf("%v [op %s]", e.err, e.id)
+}
+
+func (e operationError) Unwrap() error {
+	return e.err
+}
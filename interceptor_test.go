@@ -0,0 +1,85 @@
+package pvaccess
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestInterceptorChain(t *testing.T) {
+	srv := &Server{}
+	var calls []string
+	srv.AddInterceptor(func(ctx context.Context, op *Op, next OpHandler) (interface{}, error) {
+		calls = append(calls, "outer "+op.ChannelName)
+		op.ChannelName = "rewritten"
+		return next(ctx, op)
+	})
+	srv.AddInterceptor(func(ctx context.Context, op *Op, next OpHandler) (interface{}, error) {
+		calls = append(calls, "inner "+op.ChannelName)
+		return next(ctx, op)
+	})
+	result, err := srv.intercept(context.Background(), &Op{Kind: OpCreateChannel, ChannelName: "original"}, func(ctx context.Context, op *Op) (interface{}, error) {
+		calls = append(calls, "handler "+op.ChannelName)
+		return op.ChannelName, nil
+	})
+	if err != nil {
+		t.Fatalf("intercept failed: %v", err)
+	}
+	if result != "rewritten" {
+		t.Errorf("got result %v, want %q", result, "rewritten")
+	}
+	want := []string{"outer original", "inner rewritten", "handler rewritten"}
+	if diff := cmp.Diff(calls, want); diff != "" {
+		t.Errorf("wrong call order: got(-)/want(+)\n%s", diff)
+	}
+}
+
+func TestOperationIDs(t *testing.T) {
+	srv := &Server{EchoOperationIDs: true}
+	ctx := context.Background()
+	errFailed := errors.New("failed")
+	seen := make(map[string]bool)
+	for _, fail := range []error{nil, errFailed, pvdata.PVStatus{Type: pvdata.PVStatus_ERROR, Message: "failed"}} {
+		var op Op
+		_, err := srv.intercept(ctx, &op, func(ctx context.Context, op *Op) (interface{}, error) {
+			if id, ok := OperationIDFromContext(ctx); !ok || id != op.ID {
+				t.Errorf("operation ID in context = %q, want %q", id, op.ID)
+			}
+			return nil, fail
+		})
+		if op.ID == "" || seen[op.ID] {
+			t.Errorf("operation got ID %q, want a new unique ID", op.ID)
+		}
+		seen[op.ID] = true
+		if fail == nil {
+			if err != nil {
+				t.Errorf("intercept returned %v", err)
+			}
+			continue
+		}
+		want := "[op " + op.ID + "]"
+		if msg := errorMessage(err); !strings.HasSuffix(msg, want) {
+			t.Errorf("error %q does not end with %q", msg, want)
+		}
+		if fail == errFailed && !errors.Is(err, errFailed) {
+			t.Errorf("error %v does not wrap the original error", err)
+		}
+		if _, ok := fail.(pvdata.PVStatus); ok {
+			if s, ok := err.(pvdata.PVStatus); !ok || s.Type != pvdata.PVStatus_ERROR {
+				t.Errorf("status error became %#v", err)
+			}
+		}
+	}
+}
+
+// errorMessage returns the message that a client would see for err.
+func errorMessage(err error) string {
+	if s, ok := err.(pvdata.PVStatus); ok {
+		return string(s.Message)
+	}
+	return err.Error()
+}
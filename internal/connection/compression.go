@@ -0,0 +1,125 @@
+package connection
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/Lexcelon/go-pvaccess/types"
+)
+
+// Compressor compresses the payloads of large application messages.
+type Compressor = types.Compressor
+
+// compression is the state of payload compression on a connection.
+// Everything except buf and peerAccepts is protected by the connection's encoderMu.
+type compression struct {
+	compressor Compressor
+	threshold  int
+	// offered is set once compression has been offered to the peer.
+	offered bool
+	// peerAccepts is set to 1 once the peer has offered the same algorithm; only then are messages compressed.
+	// It is accessed atomically, so that Compressed doesn't wait for a message being sent.
+	peerAccepts int32
+	// buf is reused for decompressed payloads. It is only used by the goroutine calling Next.
+	buf []byte
+}
+
+// SetCompression lets the connection decompress messages from the peer, and compress application messages
+// with payloads larger than threshold bytes once the peer has offered compression with the same algorithm.
+// It must be called before the connection is used.
+//
+// Compression is only offered to the peer by OfferCompression, or in reply to the peer's own offer,
+// so peers that don't support compression never see a compressed message.
+func (c *Connection) SetCompression(comp Compressor, threshold int) {
+	c.encoderMu.Lock()
+	defer c.encoderMu.Unlock()
+	c.compression.compressor = comp
+	c.compression.threshold = threshold
+}
+
+// OfferCompression tells the peer that this end can decompress messages. SetCompression must have been called.
+func (c *Connection) OfferCompression(ctx context.Context) error {
+	c.encoderMu.Lock()
+	comp := c.compression.compressor
+	c.compression.offered = comp != nil
+	c.encoderMu.Unlock()
+	if comp == nil {
+		return fmt.Errorf("no compressor set")
+	}
+	return c.SendCtrl(ctx, proto.CTRL_OFFER_COMPRESSION, pvdata.PVInt(comp.Algorithm()))
+}
+
+// Compressed reports whether large messages sent on the connection are compressed.
+func (c *Connection) Compressed() bool {
+	return atomic.LoadInt32(&c.compression.peerAccepts) == 1
+}
+
+func (c *Connection) handleOfferCompression(ctx context.Context, header *proto.PVAccessHeader) error {
+	c.encoderMu.Lock()
+	comp := c.compression.compressor
+	if comp == nil {
+		c.encoderMu.Unlock()
+		ctxlog.L(ctx).Debugf("ignoring offer of compression algorithm %d", header.PayloadSize)
+		return nil
+	}
+	accepted := int32(header.PayloadSize) == comp.Algorithm()
+	if accepted {
+		atomic.StoreInt32(&c.compression.peerAccepts, 1)
+	} else {
+		atomic.StoreInt32(&c.compression.peerAccepts, 0)
+	}
+	reply := !c.compression.offered
+	c.compression.offered = true
+	c.encoderMu.Unlock()
+	ctxlog.L(ctx).Debugf("peer offered compression algorithm %d; compressing = %v", header.PayloadSize, accepted)
+	if reply {
+		return c.SendCtrl(ctx, proto.CTRL_OFFER_COMPRESSION, pvdata.PVInt(comp.Algorithm()))
+	}
+	return nil
+}
+
+// compress returns the payload to send for bytes, and whether it was compressed.
+// It must be called with encoderMu held.
+func (c *Connection) compress(bytes []byte) ([]byte, bool, error) {
+	comp := &c.compression
+	if atomic.LoadInt32(&comp.peerAccepts) == 0 || len(bytes) <= comp.threshold {
+		return bytes, false, nil
+	}
+	compressed, err := comp.compressor.Compress(nil, bytes)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(compressed) >= len(bytes) {
+		// Incompressible; send it as it is.
+		return bytes, false, nil
+	}
+	return compressed, true, nil
+}
+
+// decompress returns the decompressed form of a payload received with FLAG_COMPRESSED.
+// The result is only valid until the next call.
+func (c *Connection) decompress(data []byte) ([]byte, error) {
+	c.encoderMu.Lock()
+	comp, offered := c.compression.compressor, c.compression.offered
+	c.encoderMu.Unlock()
+	if comp == nil {
+		return nil, fmt.Errorf("received compressed message, but compression is not enabled")
+	}
+	// Peers may only compress once both ends have offered the same algorithm.
+	if !offered || !c.Compressed() {
+		return nil, fmt.Errorf("received compressed message before compression was negotiated")
+	}
+	buf, err := comp.Decompress(c.compression.buf[:0], data)
+	if err != nil {
+		return nil, fmt.Errorf("decompressing message: %w", err)
+	}
+	if len(buf) > types.MaxPayloadSize {
+		return nil, fmt.Errorf("decompressed message of %d bytes exceeds %d bytes", len(buf), types.MaxPayloadSize)
+	}
+	c.compression.buf = buf
+	return buf, nil
+}
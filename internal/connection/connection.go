@@ -5,52 +5,150 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
+	"errors"
 	"io"
-	"os"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
-	"github.com/Lexcelon/go-pvaccess/internal/proto"
+	"github.com/Lexcelon/go-pvaccess/proto"
 	"github.com/Lexcelon/go-pvaccess/pvdata"
 )
 
 type Connection struct {
 	Version   pvdata.PVByte
 	Direction pvdata.PVUByte
+	// OnSend, if non-nil, is called with the command and uncompressed payload size of each application message sent.
+	// It must be set before the connection is used.
+	OnSend func(messageCommand pvdata.PVByte, size int)
+	// SegmentSize, if positive, is the largest payload sent in one application message. Larger payloads are
+	// sent as segmented messages, each segment as soon as it has been encoded, and are never compressed.
+	// It must be set before the connection is used.
+	SegmentSize int
+	// MaxBuffered, if positive, is the most bytes of messages that Queues may hold waiting to be sent.
+	// Queues then encode messages before queuing them, so that they can be counted, and TrySendApp on a Queue fails
+	// with ErrBufferFull instead of exceeding MaxBuffered. A message is always accepted if no others are waiting.
+	// It must be set before the connection is used.
+	MaxBuffered int
+	// Strict makes Next reject messages whose headers break the protocol, e.g. with reserved flags set,
+	// with an error wrapping ErrProtocolViolation, instead of tolerating them. It also makes Next reject a message
+	// whose payload was decoded without reaching its end, which is otherwise skipped with a warning.
+	// It must be set before the connection is used.
+	Strict bool
+	// StreamSegmented, if non-nil, is called with the header and payload of the first segment of each segmented
+	// message. If it returns true, Next returns the message at once instead of reassembling it, and its later
+	// segments are read from the connection as it is decoded, so that only one segment is held in memory.
+	// Such a message's Data is only its first segment, and it can only be decoded until the next call to Next,
+	// which skips any segments that weren't decoded. It must be set before the connection is used.
+	StreamSegmented func(header proto.PVAccessHeader, first []byte) bool
+	// Serializer encodes and decodes the payloads of application messages; nil is pvdata.Binary.
+	// Message headers and control messages are always binary. It must be set before the connection is used.
+	Serializer pvdata.Serializer
 
 	conn io.ReadWriter
+	// recv buffers data read from conn. Payloads that fit are borrowed from it directly.
+	recv *bufio.Reader
+	// recvBuf is reused for payloads that are larger than recv's buffer.
+	recvBuf []byte
 	// encoderMu protects use of encoderState.
 	encoderMu      sync.Mutex
 	encoderState   *pvdata.EncoderState
 	decoderState   *pvdata.DecoderState
 	forceByteOrder bool
+
+	health      health
+	compression compression
+	segments    segments
+	bundled     bundled
+	// shared is the buffer of the last message returned by Next, if the connection has given it up.
+	shared *sharedBuffer
+	// last is the last message returned by Next, whose decoding is checked by the next call.
+	last *Message
+
+	// closed is set to 1 by Close.
+	closed int32
+	// bufferedMu protects buffered, the number of bytes of messages waiting in Queues if MaxBuffered is set,
+	// and queueOrder, the byte order Queues encode messages in. Unlike encoderMu, it isn't held while sending.
+	bufferedMu sync.Mutex
+	buffered   int
+	queueOrder binary.ByteOrder
 }
 
+// ErrConnectionClosed is returned by sends on a Connection after Close has been called,
+// and by Next once the connection has been closed.
+var ErrConnectionClosed = errors.New("connection closed")
+
+// ErrBufferFull is returned by TrySendApp on a Queue if sending would make the connection buffer more than MaxBuffered bytes.
+var ErrBufferFull = errors.New("connection send buffer full")
+
+// receiveBufferSize is the size of the buffer used to read from the connection.
+// Messages up to this size are decoded without being copied.
+const receiveBufferSize = 16384
+
 func New(conn io.ReadWriter, direction pvdata.PVUByte) *Connection {
-	return &Connection{
+	recv := bufio.NewReaderSize(conn, receiveBufferSize)
+	c := &Connection{
 		Direction: direction,
 		conn:      conn,
+		recv:      recv,
 		encoderState: &pvdata.EncoderState{
 			Buf:       bufio.NewWriter(conn),
 			ByteOrder: binary.LittleEndian,
 		},
 		decoderState: &pvdata.DecoderState{
-			Buf: bufio.NewReader(conn),
+			Buf: recv,
 		},
+		queueOrder: binary.LittleEndian,
+	}
+	c.health.h.Established = time.Now()
+	return c
+}
+
+// Close closes the connection. Sends that start after Close fail with ErrConnectionClosed,
+// and any pending Next returns ErrConnectionClosed once the underlying connection is closed.
+// The underlying connection is closed if it implements io.Closer.
+// It is safe to call Close more than once, and from any goroutine.
+func (c *Connection) Close() error {
+	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		return nil
+	}
+	if closer, ok := c.conn.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Closed reports whether Close has been called.
+func (c *Connection) Closed() bool {
+	return atomic.LoadInt32(&c.closed) != 0
+}
+
+// closedErr replaces err with ErrConnectionClosed if the connection has been closed,
+// since errors from the underlying connection are then only a consequence of the close.
+func (c *Connection) closedErr(err error) error {
+	if err != nil && c.Closed() {
+		return ErrConnectionClosed
 	}
+	return err
 }
 
-type filer interface {
-	File() (*os.File, error)
+type syscallConner interface {
+	SyscallConn() (syscall.RawConn, error)
 }
 
 func (c *Connection) ReceiveBufferSize() int {
 	bufSize := 32768 // default size if we can't fetch it
-	if cf, ok := c.conn.(filer); ok {
-		if file, err := cf.File(); err == nil {
-			bufSize, _ = syscall.GetsockoptInt(int(file.Fd()), syscall.SOL_SOCKET, syscall.SO_RCVBUF)
-			file.Close()
+	// Use the raw connection rather than File, which would put the socket into blocking mode
+	// so that closing it no longer interrupts a pending read.
+	if sc, ok := c.conn.(syscallConner); ok {
+		if rc, err := sc.SyscallConn(); err == nil {
+			rc.Control(func(fd uintptr) {
+				if size, err := syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF); err == nil {
+					bufSize = size
+				}
+			})
 		}
 	}
 	return bufSize
@@ -73,12 +171,22 @@ func (c *Connection) flush() error {
 
 // SendCtrl sends a control message on the wire.
 // It is safe to call SendCtrl from any goroutine.
-func (c *Connection) SendCtrl(ctx context.Context, messageCommand pvdata.PVByte, payloadSize pvdata.PVInt) error {
+func (c *Connection) SendCtrl(ctx context.Context, messageCommand pvdata.PVByte, payloadSize pvdata.PVInt) (err error) {
 	c.encoderMu.Lock()
 	defer c.encoderMu.Unlock()
-	defer c.flush()
-	ctxlog.L(ctx).WithFields(ctxlog.Fields{
-		"command":      messageCommand,
+	if c.Closed() {
+		return ErrConnectionClosed
+	}
+	defer func() {
+		if err == nil {
+			if err = c.flush(); err != nil {
+				c.writeFailed(ctx, err)
+			}
+		}
+		err = c.closedErr(err)
+	}()
+	ctxlog.For(ctx, ctxlog.Codec).WithFields(ctxlog.Fields{
+		"command":      proto.CtrlCommand(messageCommand),
 		"payload_size": payloadSize,
 	}).Debug("sending control message")
 	flags := proto.FLAG_MSG_CTRL | c.Direction
@@ -91,14 +199,34 @@ func (c *Connection) SendCtrl(ctx context.Context, messageCommand pvdata.PVByte,
 		MessageCommand: messageCommand,
 		PayloadSize:    payloadSize,
 	}
-	return h.PVEncode(c.encoderState)
+	if err := h.PVEncode(c.encoderState); err != nil {
+		return c.writeFailed(ctx, err)
+	}
+	return nil
+}
+
+// writeFailed closes the connection after a write to it failed with err, and returns err.
+// Only part of a message may have been written, so the peer couldn't find the start of the next one;
+// the connection can't be used any more, and every other user sees it closed.
+func (c *Connection) writeFailed(ctx context.Context, err error) error {
+	ctxlog.L(ctx).Infof("closing connection after failed write: %v", err)
+	c.Close()
+	return err
+}
+
+// serializer returns the Serializer for the payloads of application messages.
+func (c *Connection) serializer() pvdata.Serializer {
+	if c.Serializer == nil {
+		return pvdata.Binary
+	}
+	return c.Serializer
 }
 
 // encodePayload must be called with encoderMu held.
 func (c *Connection) encodePayload(payload interface{}) ([]byte, error) {
 	var buf bytes.Buffer
 	defer c.encoderState.PushWriter(&buf)()
-	if err := pvdata.Encode(c.encoderState, payload); err != nil {
+	if err := c.serializer().Encode(c.encoderState, payload); err != nil {
 		return nil, err
 	}
 	return buf.Bytes(), nil
@@ -108,21 +236,45 @@ func (c *Connection) encodePayload(payload interface{}) ([]byte, error) {
 // payload must be something that can be passed to pvdata.Encode; i.e. it must be either an instance of PVField or a pointer to something that can be converted to a PVField.
 // If payload is a []byte it will be sent raw.
 // It is safe to call SendApp from any goroutine.
-func (c *Connection) SendApp(ctx context.Context, messageCommand pvdata.PVByte, payload interface{}) error {
+func (c *Connection) SendApp(ctx context.Context, messageCommand pvdata.PVByte, payload interface{}) (err error) {
 	c.encoderMu.Lock()
 	defer c.encoderMu.Unlock()
-	defer c.flush()
+	if c.Closed() {
+		return ErrConnectionClosed
+	}
+	defer func() {
+		if err == nil {
+			if err = c.flush(); err != nil {
+				c.writeFailed(ctx, err)
+			}
+		}
+		err = c.closedErr(err)
+	}()
 	var bytes []byte
-	if b, ok := payload.([]byte); ok {
+	if c.SegmentSize > 0 {
+		var sent bool
+		if bytes, sent, err = c.sendSegmented(ctx, messageCommand, payload); err != nil || sent {
+			return err
+		}
+	} else if b, ok := payload.([]byte); ok {
 		bytes = b
 	} else {
-		var err error
 		bytes, err = c.encodePayload(payload)
 		if err != nil {
 			return err
 		}
 	}
+	if c.OnSend != nil {
+		c.OnSend(messageCommand, len(bytes))
+	}
 	flags := proto.FLAG_MSG_APP | c.Direction
+	bytes, compressed, err := c.compress(bytes)
+	if err != nil {
+		return err
+	}
+	if compressed {
+		flags |= proto.FLAG_COMPRESSED
+	}
 	if c.encoderState.ByteOrder == binary.BigEndian {
 		flags |= proto.FLAG_BO_BE
 	}
@@ -132,35 +284,86 @@ func (c *Connection) SendApp(ctx context.Context, messageCommand pvdata.PVByte,
 		MessageCommand: messageCommand,
 		PayloadSize:    pvdata.PVInt(len(bytes)),
 	}
-	l := ctxlog.L(ctx).WithFields(ctxlog.Fields{
-		"command":      messageCommand,
+	l := ctxlog.For(ctx, ctxlog.Codec).WithFields(ctxlog.Fields{
+		"command":      proto.AppCommand(messageCommand),
 		"payload_size": len(bytes),
 	})
 	l.Debug("sending app message")
 	l.Tracef("app message body = %x", bytes)
 	if err := h.PVEncode(c.encoderState); err != nil {
-		return err
+		return c.writeFailed(ctx, err)
 	}
-	_, err := c.encoderState.Buf.Write(bytes)
-	return err
+	if _, err := c.encoderState.Buf.Write(bytes); err != nil {
+		return c.writeFailed(ctx, err)
+	}
+	return nil
+}
+
+// controlHandlers handle each type of control message. Control messages have no payload;
+// their argument is carried in the header's payload size field.
+var controlHandlers = map[pvdata.PVByte]func(c *Connection, ctx context.Context, header *proto.PVAccessHeader) error{
+	proto.CTRL_MARK_TOTAL_BYTE_SENT: (*Connection).handleMarkTotalByteSent,
+	proto.CTRL_ACK_TOTAL_BYTE_SENT:  (*Connection).handleAckTotalByteSent,
+	proto.CTRL_SET_BYTE_ORDER:       (*Connection).handleSetByteOrder,
+	proto.CTRL_ECHO_REQUEST:         (*Connection).handleEchoRequest,
+	proto.CTRL_ECHO_RESPONSE:        (*Connection).handleEchoResponse,
+	proto.CTRL_OFFER_COMPRESSION:    (*Connection).handleOfferCompression,
 }
 
 func (c *Connection) handleControlMessage(ctx context.Context, header *proto.PVAccessHeader) error {
-	ctx = ctxlog.WithField(ctx, "request_command", header.MessageCommand)
-	switch header.MessageCommand {
-	case proto.CTRL_MARK_TOTAL_BYTE_SENT:
-		return c.SendCtrl(ctx, proto.CTRL_ACK_TOTAL_BYTE_SENT, header.PayloadSize)
-	case proto.CTRL_ACK_TOTAL_BYTE_SENT:
-		// TODO: Implement flow control
-	case proto.CTRL_SET_BYTE_ORDER:
-		if header.PayloadSize == 0 {
-			c.forceByteOrder = true
-		}
-	case proto.CTRL_ECHO_REQUEST:
-		return c.SendCtrl(ctx, proto.CTRL_ECHO_RESPONSE, header.PayloadSize)
-	default:
-		ctxlog.L(ctx).Warnf("ignoring unknown control message %02x", header.MessageCommand)
+	ctx = ctxlog.WithField(ctx, "request_command", proto.CtrlCommand(header.MessageCommand))
+	if f, ok := controlHandlers[header.MessageCommand]; ok {
+		return f(c, ctx, header)
+	}
+	ctxlog.L(ctx).Warnf("ignoring unknown control message %02x", header.MessageCommand)
+	return nil
+}
+
+// handleMarkTotalByteSent acknowledges a flow control mark, whose payload size is the number of bytes sent by the peer.
+func (c *Connection) handleMarkTotalByteSent(ctx context.Context, header *proto.PVAccessHeader) error {
+	return c.SendCtrl(ctx, proto.CTRL_ACK_TOTAL_BYTE_SENT, header.PayloadSize)
+}
+
+func (c *Connection) handleAckTotalByteSent(ctx context.Context, header *proto.PVAccessHeader) error {
+	// TODO: Implement flow control; we never send marks, so acks are only logged.
+	ctxlog.L(ctx).Debugf("peer acknowledged %d bytes", header.PayloadSize)
+	return nil
+}
+
+// handleSetByteOrder switches to the byte order of the message's header, which the peer uses from now on.
+// It may be sent at any time, not just at the start of the connection. If it arrives between the segments of
+// a message, the rest of the message keeps the byte order it started with, and the switch is made after its last
+// segment.
+// A payload size of 0 means the peer ignores the byte order flag of the messages it receives,
+// so the flags of later messages are ignored too; any other value means every message's flag is honored.
+func (c *Connection) handleSetByteOrder(ctx context.Context, header *proto.PVAccessHeader) error {
+	if c.segments.receiving() {
+		h := *header
+		c.segments.setByteOrder = &h
+		return nil
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if header.Flags&proto.FLAG_BO_BE == proto.FLAG_BO_BE {
+		order = binary.BigEndian
 	}
+	ctxlog.L(ctx).Debugf("peer set byte order to %v", order)
+	c.decoderState.ByteOrder = order
+	c.forceByteOrder = header.PayloadSize == 0
+	c.bufferedMu.Lock()
+	c.queueOrder = order
+	c.bufferedMu.Unlock()
+	c.encoderMu.Lock()
+	defer c.encoderMu.Unlock()
+	c.encoderState.ByteOrder = order
+	return nil
+}
+
+func (c *Connection) handleEchoRequest(ctx context.Context, header *proto.PVAccessHeader) error {
+	return c.SendCtrl(ctx, proto.CTRL_ECHO_RESPONSE, header.PayloadSize)
+}
+
+func (c *Connection) handleEchoResponse(ctx context.Context, header *proto.PVAccessHeader) error {
+	c.health.echoed(header.PayloadSize)
 	return nil
 }
 
@@ -173,54 +376,201 @@ func (c *Connection) handleAppEcho(ctx context.Context, header proto.PVAccessHea
 
 type Message struct {
 	Header proto.PVAccessHeader
-	Data   []byte
+	// Data is the payload of the message.
+	// It is borrowed from the connection's receive buffer and is only valid until the next call to Next;
+	// use Copy to keep a message for longer.
+	Data []byte
 
 	c      *Connection
 	reader pvdata.Reader
+	// owner is the connection's field holding the buffer of Data, if Data can be shared, and shared is the buffer
+	// once the connection has given it up. copied is set if Data belongs to msg.
+	owner  *[]byte
+	shared *sharedBuffer
+	copied bool
+	// failed is set once decoding the message has failed.
+	failed bool
+	// order is the byte order of the payload, from the header of the message or of its first segment.
+	order binary.ByteOrder
 }
 
+// Next returns the next application message, handling any control and echo messages before it.
+// Segmented messages are returned once they are complete, and the messages bundled in an APP_MULTIPLE_DATA message
+// are returned in turn.
 func (c *Connection) Next(ctx context.Context) (*Message, error) {
+	if err := c.checkDecoded(ctx); err != nil {
+		return nil, err
+	}
+	msg, err := c.next(ctx)
+	c.last = msg
+	return msg, c.closedErr(err)
+}
+
+func (c *Connection) next(ctx context.Context) (*Message, error) {
+	c.releaseShared()
+	if err := c.skipStream(ctx); err != nil {
+		return nil, err
+	}
+	for {
+		if msg := c.nextBundled(); msg != nil {
+			return msg, nil
+		}
+		header, data, err := c.readMessage(ctx)
+		if err != nil {
+			if data != nil {
+				return &Message{Header: header, Data: data, c: c}, err
+			}
+			return nil, err
+		}
+		// The header just read set the byte order of its payload.
+		order := c.decoderState.ByteOrder
+		if header.Flags&proto.FLAG_SEGMENT_MASK != proto.FLAG_SEGMENT_NONE {
+			if msg := c.streamSegmented(ctx, header, data); msg != nil {
+				return msg, nil
+			}
+			var done bool
+			if data, done, err = c.segments.add(header, data, order); err != nil {
+				return nil, err
+			} else if !done {
+				continue
+			}
+			order = c.segments.order
+			if err := c.segmentedDone(ctx); err != nil {
+				return nil, err
+			}
+			header.Flags &^= proto.FLAG_SEGMENT_MASK
+			header.PayloadSize = pvdata.PVInt(len(data))
+		}
+		msg := &Message{Header: header, Data: data, c: c, owner: c.bufferOf(data), order: order}
+		if header.MessageCommand == proto.APP_MULTIPLE_DATA {
+			if err := c.unbundle(msg); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return msg, nil
+	}
+}
+
+// readMessage reads the next application message or segment other than an echo, handling any control messages
+// and echoes before it, and returns its header and decompressed payload, which is only valid until the next read.
+// If the payload can't be read, what was read of it is returned with the error.
+func (c *Connection) readMessage(ctx context.Context) (proto.PVAccessHeader, []byte, error) {
 	for {
 		header := proto.PVAccessHeader{
 			ForceByteOrder: c.forceByteOrder,
 		}
 		if err := pvdata.Decode(c.decoderState, &header); err != nil {
-			return nil, err
+			return header, nil, err
 		}
-		ctxlog.L(ctx).WithFields(ctxlog.Fields{
+		ctxlog.For(ctx, ctxlog.Codec).WithFields(ctxlog.Fields{
 			"version":         header.Version,
-			"flags":           header.Flags,
-			"message_command": header.MessageCommand,
+			"flags":           proto.Flags(header.Flags),
+			"message_command": header.CommandName(),
 			"payload_size":    header.PayloadSize,
 		}).Debug("received packet")
+		c.health.received(&header)
+		if c.Strict {
+			if err := c.checkHeader(ctx, &header); err != nil {
+				return header, nil, err
+			}
+		}
 		if header.Flags&proto.FLAG_MSG_CTRL == proto.FLAG_MSG_CTRL {
 			if err := c.handleControlMessage(ctx, &header); err != nil {
-				return nil, err
+				return header, nil, err
 			}
 			continue
 		}
 
-		data := make([]byte, header.PayloadSize)
-		if _, err := io.ReadFull(c.decoderState.Buf, data); err != nil {
-			return &Message{Header: header, Data: data, c: c}, err
+		data, err := c.readPayload(int(header.PayloadSize))
+		if err != nil {
+			return header, data, err
+		}
+		if header.Flags&proto.FLAG_COMPRESSED == proto.FLAG_COMPRESSED {
+			if data, err = c.decompress(data); err != nil {
+				return header, nil, err
+			}
 		}
 
 		if header.MessageCommand == proto.APP_ECHO {
 			if err := c.handleAppEcho(ctx, header, data); err != nil {
-				return nil, err
+				return header, nil, err
 			}
 			continue
 		}
-		// TODO: Segmented packets
-		return &Message{Header: header, Data: data, c: c}, nil
+		return header, data, nil
+	}
+}
+
+// readPayload returns the next n bytes from the connection.
+// The returned slice is only valid until the next read.
+func (c *Connection) readPayload(n int) ([]byte, error) {
+	if n <= c.recv.Size() {
+		data, err := c.recv.Peek(n)
+		if err != nil {
+			return data, err
+		}
+		// Discarding buffered data doesn't overwrite it, so data stays valid until the buffer is next filled.
+		_, err = c.recv.Discard(n)
+		return data, err
+	}
+	if cap(c.recvBuf) < n {
+		c.recvBuf = takeBuffer(n)
+	}
+	data := c.recvBuf[:n]
+	_, err := io.ReadFull(c.recv, data)
+	return data, err
+}
+
+// Copy returns a copy of msg that does not borrow from the connection's receive buffer.
+func (msg *Message) Copy() *Message {
+	return &Message{
+		Header: msg.Header,
+		Data:   append([]byte(nil), msg.Data...),
+		c:      msg.c,
+		copied: true,
+		order:  msg.order,
 	}
 }
 
 // Decode decodes data from msg into out using the connection's established decoder state.
+// pvdata.SharedBytes in out share Data instead of copying it, unless it is in the connection's receive buffer
+// because the message is small; the connection then gives up the buffer holding Data until they are released.
+// Errors are DecodeErrors; those for payloads that end too soon wrap ErrShortPayload.
+// If the payload continues after the last call to Decode, the next call to Next handles the trailing bytes
+// (see checkDecoded).
 func (msg *Message) Decode(out interface{}) error {
 	if msg.reader == nil {
-		msg.reader = bytes.NewReader(msg.Data)
+		msg.reader = &messageReader{Reader: bytes.NewReader(msg.Data), msg: msg}
 	}
 	defer msg.c.decoderState.PushReader(msg.reader)()
-	return pvdata.Decode(msg.c.decoderState, out)
+	defer msg.useByteOrder()()
+	if err := msg.c.serializer().Decode(msg.c.decoderState, out); err != nil {
+		msg.failed = true
+		return newDecodeError(msg, decodeOffset(msg, msg.reader), payloadError(err))
+	}
+	return nil
+}
+
+// useByteOrder switches the connection's decoder state to the byte order of msg's payload, which may differ from
+// that of the last header read, and returns a function that switches it back.
+func (msg *Message) useByteOrder() func() {
+	s := msg.c.decoderState
+	bo := s.ByteOrder
+	if msg.order != nil {
+		s.ByteOrder = msg.order
+	}
+	return func() { s.ByteOrder = bo }
+}
+
+// Peek decodes data from the start of msg into out without affecting later calls to Decode.
+// It is used to read the leading fields (e.g. a request ID) that determine how the rest of the message is decoded.
+func (msg *Message) Peek(out interface{}) error {
+	r := bytes.NewReader(msg.Data)
+	defer msg.c.decoderState.PushReader(r)()
+	defer msg.useByteOrder()()
+	if err := msg.c.serializer().Decode(msg.c.decoderState, out); err != nil {
+		return newDecodeError(msg, decodeOffset(msg, r), payloadError(err))
+	}
+	return nil
 }
@@ -0,0 +1,791 @@
+package connection
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/clock"
+	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/sirupsen/logrus"
+)
+
+// loopback lets a Connection read back what it wrote.
+type loopback struct {
+	bytes.Buffer
+}
+
+type benchmarkValue struct {
+	Value    pvdata.PVDouble   `pvaccess:"value"`
+	Waveform []pvdata.PVDouble `pvaccess:"waveform"`
+}
+
+func benchmarkPayload() *proto.ChannelGetResponse {
+	return &proto.ChannelGetResponse{
+		RequestID: 1,
+		Value:     pvdata.PVStructureDiff{Value: &benchmarkValue{1, make([]pvdata.PVDouble, 1024)}},
+	}
+}
+
+func BenchmarkSendApp(b *testing.B) {
+	ctx := context.Background()
+	var buf loopback
+	c := New(&buf, proto.FLAG_FROM_SERVER)
+	payload := benchmarkPayload()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		if err := c.SendApp(ctx, proto.APP_CHANNEL_GET, payload); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.SetBytes(int64(buf.Len()))
+}
+
+func BenchmarkNext(b *testing.B) {
+	ctx := context.Background()
+	var buf loopback
+	c := New(&buf, proto.FLAG_FROM_SERVER)
+	if err := c.SendApp(ctx, proto.APP_CHANNEL_GET, benchmarkPayload()); err != nil {
+		b.Fatal(err)
+	}
+	message := append([]byte{}, buf.Bytes()...)
+	b.SetBytes(int64(len(message)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf.Write(message)
+		msg, err := c.Next(ctx)
+		if err != nil {
+			b.Fatal(err)
+		}
+		resp := proto.ChannelGetResponse{Value: pvdata.PVStructureDiff{Value: &benchmarkValue{}}}
+		if err := msg.Decode(&resp); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestMessageBorrow(t *testing.T) {
+	ctx := context.Background()
+	var buf loopback
+	c := New(&buf, proto.FLAG_FROM_SERVER)
+	small := []byte{1, 2, 3}
+	large := bytes.Repeat([]byte{4}, receiveBufferSize+1)
+	for _, payload := range [][]byte{small, large, small} {
+		if err := c.SendApp(ctx, proto.APP_CHANNEL_GET, payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var kept []*Message
+	for i := 0; i < 3; i++ {
+		msg, err := c.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kept = append(kept, msg.Copy())
+	}
+	for i, want := range [][]byte{small, large, small} {
+		if !bytes.Equal(kept[i].Data, want) {
+			t.Errorf("message %d: got %d bytes, want %d", i, len(kept[i].Data), len(want))
+		}
+	}
+}
+
+func TestClose(t *testing.T) {
+	ctx := context.Background()
+	client, server := net.Pipe()
+	defer server.Close()
+	c := New(client, proto.FLAG_FROM_SERVER)
+
+	// A pending Next and a blocked send (net.Pipe is unbuffered) both end with ErrConnectionClosed.
+	errs := make(chan error, 2)
+	go func() {
+		_, err := c.Next(ctx)
+		errs <- err
+	}()
+	go func() {
+		errs <- c.SendApp(ctx, proto.APP_CHANNEL_GET, []byte{1, 2, 3})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if err := c.Close(); err != nil {
+			t.Fatalf("Close #%d: %v", i+1, err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; !errors.Is(err, ErrConnectionClosed) {
+			t.Errorf("pending operation returned %v, want ErrConnectionClosed", err)
+		}
+	}
+	if err := c.SendCtrl(ctx, proto.CTRL_ECHO_REQUEST, 0); !errors.Is(err, ErrConnectionClosed) {
+		t.Errorf("SendCtrl after Close returned %v, want ErrConnectionClosed", err)
+	}
+	if err := c.NewQueue().SendApp(ctx, proto.APP_CHANNEL_GET, []byte{}); !errors.Is(err, ErrConnectionClosed) {
+		t.Errorf("queued SendApp after Close returned %v, want ErrConnectionClosed", err)
+	}
+}
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestWriteFailure(t *testing.T) {
+	ctx := context.Background()
+	// A payload that can't be encoded fails only its message.
+	c := New(halfDuplex{&bytes.Buffer{}, &bytes.Buffer{}}, proto.FLAG_FROM_SERVER)
+	if err := c.SendApp(ctx, proto.APP_CHANNEL_GET, make(chan int)); err == nil || errors.Is(err, ErrConnectionClosed) {
+		t.Errorf("sending an unencodable payload returned %v, want an encoding error", err)
+	}
+	if c.Closed() {
+		t.Error("connection closed after an encoding error")
+	}
+	// A failed write closes the connection, since part of the message may have been sent.
+	c = New(halfDuplex{&bytes.Buffer{}, failingWriter{}}, proto.FLAG_FROM_SERVER)
+	if err := c.SendApp(ctx, proto.APP_CHANNEL_GET, []byte{1, 2, 3}); !errors.Is(err, ErrConnectionClosed) {
+		t.Errorf("SendApp with a failing writer returned %v, want ErrConnectionClosed", err)
+	}
+	if !c.Closed() {
+		t.Error("connection still open after a failed write")
+	}
+}
+
+// halfDuplex reads from one buffer and writes to another.
+type halfDuplex struct {
+	io.Reader
+	io.Writer
+}
+
+func TestControlMessages(t *testing.T) {
+	ctx := context.Background()
+	var toClient, toServer bytes.Buffer
+	server := New(&toClient, proto.FLAG_FROM_SERVER)
+	server.Version = 2
+	client := New(halfDuplex{&toClient, &toServer}, proto.FLAG_FROM_CLIENT)
+	client.Version = 2
+
+	// The server switches to big-endian mid-stream, then pings and sends an application message.
+	server.encoderState.ByteOrder = binary.BigEndian
+	for _, ctrl := range []struct {
+		command pvdata.PVByte
+		payload pvdata.PVInt
+	}{
+		{proto.CTRL_SET_BYTE_ORDER, 0},
+		{proto.CTRL_ECHO_REQUEST, 7},
+		{proto.CTRL_MARK_TOTAL_BYTE_SENT, 100},
+	} {
+		if err := server.SendCtrl(ctx, ctrl.command, ctrl.payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := server.SendApp(ctx, proto.APP_CHANNEL_DESTROY, &proto.DestroyChannel{ServerChannelID: 1, ClientChannelID: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := client.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var destroy proto.DestroyChannel
+	if err := msg.Decode(&destroy); err != nil {
+		t.Fatal(err)
+	}
+	if destroy.ServerChannelID != 1 || destroy.ClientChannelID != 2 {
+		t.Errorf("decoded %+v after byte order change, want server channel 1, client channel 2", destroy)
+	}
+
+	// The client answers in the server's byte order.
+	flags := byte(proto.FLAG_MSG_CTRL | proto.FLAG_FROM_CLIENT | proto.FLAG_BO_BE)
+	want := []byte{
+		proto.MAGIC, 2, flags, proto.CTRL_ECHO_RESPONSE, 0, 0, 0, 7,
+		proto.MAGIC, 2, flags, proto.CTRL_ACK_TOTAL_BYTE_SENT, 0, 0, 0, 100,
+	}
+	if got := toServer.Bytes(); !bytes.Equal(got, want) {
+		t.Errorf("client sent %x, want %x", got, want)
+	}
+}
+
+// identityCompressor "compresses" messages by copying them.
+type identityCompressor struct{}
+
+func (identityCompressor) Algorithm() int32                         { return 99 }
+func (identityCompressor) Compress(dst, src []byte) ([]byte, error) { return append(dst, src...), nil }
+func (identityCompressor) Decompress(dst, src []byte) ([]byte, error) {
+	return append(dst, src...), nil
+}
+
+func TestCompressionNegotiation(t *testing.T) {
+	ctx := context.Background()
+	// compressed is a DESTROY_CHANNEL message with the compressed flag set.
+	compressed := []byte{
+		proto.MAGIC, 2, proto.FLAG_FROM_SERVER | proto.FLAG_COMPRESSED, proto.APP_CHANNEL_DESTROY, 8, 0, 0, 0,
+		1, 0, 0, 0, 2, 0, 0, 0,
+	}
+	offer := []byte{proto.MAGIC, 2, proto.FLAG_MSG_CTRL | proto.FLAG_FROM_SERVER, proto.CTRL_OFFER_COMPRESSION, 99, 0, 0, 0}
+	for _, test := range []struct {
+		name                       string
+		clientOffers, serverOffers bool
+	}{
+		{"not offered", false, false},
+		{"only offered by client", true, false},
+		{"negotiated", true, true},
+	} {
+		input := compressed
+		if test.serverOffers {
+			input = append(append([]byte(nil), offer...), compressed...)
+		}
+		c := New(halfDuplex{bytes.NewReader(input), io.Discard}, proto.FLAG_FROM_CLIENT)
+		c.Version = 2
+		c.SetCompression(identityCompressor{}, 0)
+		if test.clientOffers {
+			if err := c.OfferCompression(ctx); err != nil {
+				t.Fatal(err)
+			}
+		}
+		msg, err := c.Next(ctx)
+		if test.clientOffers && test.serverOffers {
+			var destroy proto.DestroyChannel
+			if err == nil {
+				err = msg.Decode(&destroy)
+			}
+			if err != nil || destroy.ServerChannelID != 1 || destroy.ClientChannelID != 2 {
+				t.Errorf("%s: got %+v, %v; want the decompressed message", test.name, destroy, err)
+			}
+		} else if err == nil {
+			t.Errorf("%s: compressed message was accepted", test.name)
+		}
+	}
+}
+
+func TestSegmentation(t *testing.T) {
+	ctx := context.Background()
+	var buf loopback
+	c := New(&buf, proto.FLAG_FROM_SERVER)
+	c.SegmentSize = 1000
+	var sentBeforeLastChunk bool
+	waveform := pvdata.ArrayStream{Elem: pvdata.PVDouble(0), Len: 10000, Chunks: func(yield func(interface{}) error) error {
+		chunk := make([]pvdata.PVDouble, 100)
+		for start := 0; start < 10000; start += len(chunk) {
+			if start == 10000-len(chunk) {
+				sentBeforeLastChunk = buf.Len() > 0
+			}
+			for i := range chunk {
+				chunk[i] = pvdata.PVDouble(start + i)
+			}
+			if err := yield(chunk); err != nil {
+				return err
+			}
+		}
+		return nil
+	}}
+	err := c.SendApp(ctx, proto.APP_CHANNEL_GET, &proto.ChannelGetResponse{
+		RequestID: 1,
+		Value: pvdata.PVStructureDiff{Value: &struct {
+			Value    pvdata.PVDouble    `pvaccess:"value"`
+			Waveform pvdata.ArrayStream `pvaccess:"waveform"`
+		}{1, waveform}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sentBeforeLastChunk {
+		t.Error("no segments were written before the array was fully encoded")
+	}
+	// A small message follows, sent whole.
+	if err := c.SendApp(ctx, proto.APP_CHANNEL_GET, []byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	var segments int
+	for r := bytes.NewReader(buf.Bytes()); r.Len() > 0; segments++ {
+		var h proto.PVAccessHeader
+		if err := h.PVDecode(&pvdata.DecoderState{Buf: r}); err != nil {
+			t.Fatal(err)
+		}
+		if h.PayloadSize > 1000 {
+			t.Errorf("message %d has a %d byte payload", segments, h.PayloadSize)
+		}
+		r.Seek(int64(h.PayloadSize), io.SeekCurrent)
+	}
+	if segments < 80 {
+		t.Errorf("sent %d messages, want the array in segments", segments)
+	}
+
+	msg, err := c.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Header.Flags&proto.FLAG_SEGMENT_MASK != 0 {
+		t.Errorf("reassembled message has flags %v", proto.Flags(msg.Header.Flags))
+	}
+	got := benchmarkValue{}
+	if err := msg.Decode(&proto.ChannelGetResponse{Value: pvdata.PVStructureDiff{Value: &got}}); err != nil {
+		t.Fatal(err)
+	}
+	if got.Value != 1 || len(got.Waveform) != 10000 || got.Waveform[9999] != 9999 {
+		t.Errorf("got value %v and %d elements", got.Value, len(got.Waveform))
+	}
+	msg, err = c.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg.Data, []byte{1, 2, 3}) {
+		t.Errorf("message after segmented message = %v", msg.Data)
+	}
+}
+
+func TestSegmentLimit(t *testing.T) {
+	ctx := context.Background()
+	var buf loopback
+	c := New(&buf, proto.FLAG_FROM_SERVER)
+	c.SegmentSize = 1000
+	c.segments.limit = 4000
+	if err := c.SendApp(ctx, proto.APP_CHANNEL_GET, make([]byte, 3500)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SendApp(ctx, proto.APP_CHANNEL_GET, make([]byte, 5000)); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := c.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Data) != 3500 {
+		t.Errorf("reassembled message has %d bytes, want 3500", len(msg.Data))
+	}
+	if _, err := c.Next(ctx); err == nil || !strings.Contains(err.Error(), "exceeds 4000 bytes") {
+		t.Errorf("Next of a segmented message over the limit = %v, want an error", err)
+	}
+}
+
+func TestStreamSegmented(t *testing.T) {
+	ctx := context.Background()
+	var buf loopback
+	c := New(&buf, proto.FLAG_FROM_SERVER)
+	c.SegmentSize = 1000
+	waveform := make([]pvdata.PVDouble, 10000)
+	for i := range waveform {
+		waveform[i] = pvdata.PVDouble(i)
+	}
+	for i := 0; i < 2; i++ {
+		if err := c.SendApp(ctx, proto.APP_CHANNEL_GET, &proto.ChannelGetResponse{
+			RequestID: pvdata.PVInt(i),
+			Value:     pvdata.PVStructureDiff{Value: &benchmarkValue{1, waveform}},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.SendApp(ctx, proto.APP_CHANNEL_GET, []byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	var streamed []proto.PVAccessHeader
+	c.StreamSegmented = func(header proto.PVAccessHeader, first []byte) bool {
+		streamed = append(streamed, header)
+		return true
+	}
+	fd, err := pvdata.FieldDescOf(&benchmarkValue{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first message is decoded as its segments are read, without reading the rest of the connection first.
+	msg, err := c.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n, unreadAtFirstChunk int
+	value, err := fd.ZeroWithSinks(map[string]*pvdata.ArraySink{"waveform": {ChunkLen: 100, Chunk: func(chunk interface{}, offset, length int) error {
+		if offset == 0 {
+			unreadAtFirstChunk = buf.Len()
+		}
+		for i, x := range chunk.([]float64) {
+			if x != float64(offset+i) {
+				return fmt.Errorf("element %d = %v", offset+i, x)
+			}
+		}
+		n += len(chunk.([]float64))
+		return nil
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := msg.Decode(&proto.ChannelGetResponse{Value: pvdata.PVStructureDiff{Value: value}}); err != nil {
+		t.Fatal(err)
+	}
+	if n != len(waveform) {
+		t.Errorf("decoded %d elements, want %d", n, len(waveform))
+	}
+	// Only about a receive buffer's worth of the messages should have been read.
+	if want := 2*len(waveform)*8 - 2*receiveBufferSize; unreadAtFirstChunk < want {
+		t.Errorf("%d bytes were unread when the first chunk was decoded, want at least %d", unreadAtFirstChunk, want)
+	}
+	// The segments of the second message that aren't decoded are skipped.
+	msg, err = c.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var id pvdata.PVInt
+	if err := msg.Peek(&id); err != nil || id != 1 {
+		t.Errorf("second message has request ID %d (%v), want 1", id, err)
+	}
+	msg, err = c.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg.Data, []byte{1, 2, 3}) {
+		t.Errorf("message after streamed messages = %v", msg.Data)
+	}
+	if len(streamed) != 2 {
+		t.Errorf("StreamSegmented was called for %d messages, want 2", len(streamed))
+	}
+}
+
+func TestInterleavedControl(t *testing.T) {
+	// A big-endian server sends a message in three segments, with control messages in little-endian between them,
+	// including one making the connection little-endian, followed by another message.
+	var in bytes.Buffer
+	write := func(flags, command byte, size uint32, order binary.ByteOrder, payload ...byte) {
+		in.Write([]byte{proto.MAGIC, 2, flags | proto.FLAG_FROM_SERVER, command})
+		var b [4]byte
+		order.PutUint32(b[:], size)
+		in.Write(b[:])
+		in.Write(payload)
+	}
+	write(proto.FLAG_BO_BE|proto.FLAG_SEGMENT_FIRST, proto.APP_CHANNEL_GET, 6, binary.BigEndian, 0, 0, 0, 1, 0, 0)
+	write(proto.FLAG_MSG_CTRL, proto.CTRL_ECHO_REQUEST, 7, binary.LittleEndian)
+	write(proto.FLAG_BO_BE|proto.FLAG_SEGMENT_MIDDLE, proto.APP_CHANNEL_GET, 4, binary.BigEndian, 0, 2, 0, 0)
+	write(proto.FLAG_MSG_CTRL, proto.CTRL_SET_BYTE_ORDER, 0, binary.LittleEndian)
+	write(proto.FLAG_BO_BE|proto.FLAG_SEGMENT_LAST, proto.APP_CHANNEL_GET, 2, binary.BigEndian, 0, 3)
+	// The byte order flag is now ignored.
+	write(proto.FLAG_BO_BE, proto.APP_CHANNEL_GET, 4, binary.LittleEndian, 4, 0, 0, 0)
+	input := in.Bytes()
+
+	for _, stream := range []bool{false, true} {
+		t.Run(fmt.Sprintf("stream=%v", stream), func(t *testing.T) {
+			ctx := context.Background()
+			var out bytes.Buffer
+			c := New(struct {
+				io.Reader
+				io.Writer
+			}{bytes.NewReader(input), &out}, proto.FLAG_FROM_CLIENT)
+			c.Strict = true
+			if stream {
+				c.StreamSegmented = func(proto.PVAccessHeader, []byte) bool { return true }
+			}
+			msg, err := c.Next(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got struct{ A, B, C pvdata.PVInt }
+			if err := msg.Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.A != 1 || got.B != 2 || got.C != 3 {
+				t.Errorf("segmented message = %+v, want 1, 2, 3", got)
+			}
+			if msg, err = c.Next(ctx); err != nil {
+				t.Fatal(err)
+			}
+			var x pvdata.PVInt
+			if err := msg.Decode(&x); err != nil || x != 4 {
+				t.Errorf("message after segmented message = %d (%v), want 4", x, err)
+			}
+			if out.Len() == 0 {
+				t.Error("echo request wasn't answered")
+			}
+		})
+	}
+}
+
+func TestSharedBytes(t *testing.T) {
+	ctx := context.Background()
+	var buf loopback
+	c := New(&buf, proto.FLAG_FROM_SERVER)
+	frame := func(n int, x byte) []pvdata.PVUByte {
+		b := make([]pvdata.PVUByte, n)
+		for i := range b {
+			b[i] = pvdata.PVUByte(x)
+		}
+		return b
+	}
+	type image struct {
+		Data []pvdata.PVUByte `pvaccess:"data"`
+	}
+	for _, payload := range []*image{{frame(100000, 1)}, {frame(100000, 2)}, {frame(100, 3)}} {
+		if err := c.SendApp(ctx, proto.APP_CHANNEL_MONITOR, payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fd, err := pvdata.FieldDescOf(&image{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values []pvdata.PVStructure
+	for i := 0; i < 3; i++ {
+		msg, err := c.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zero, err := fd.ZeroShared()
+		if err != nil {
+			t.Fatal(err)
+		}
+		value := zero.(pvdata.PVStructure)
+		if err := msg.Decode(value); err != nil {
+			t.Fatal(err)
+		}
+		data := value.ToMap()["data"].([]byte)
+		if shared := &data[0] == &msg.Data[len(msg.Data)-len(data)]; shared != (i < 2) {
+			t.Errorf("message %d: shared = %v, want %v", i, shared, i < 2)
+		}
+		values = append(values, value)
+	}
+	// The shared frames weren't overwritten by the messages read after them.
+	for i, value := range values {
+		data := value.ToMap()["data"].([]byte)
+		if data[0] != byte(i+1) || data[len(data)-1] != byte(i+1) {
+			t.Errorf("frame %d holds %d...%d", i, data[0], data[len(data)-1])
+		}
+		value.Release()
+	}
+}
+
+// gatedWriter holds writes until open is closed.
+type gatedWriter struct {
+	open chan struct{}
+	mu   sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (w *gatedWriter) Write(p []byte) (int, error) {
+	<-w.open
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+func TestQueue(t *testing.T) {
+	ctx := context.Background()
+	w := &gatedWriter{open: make(chan struct{})}
+	c := New(halfDuplex{bytes.NewReader(nil), w}, proto.FLAG_FROM_SERVER)
+	q := c.NewQueue()
+
+	// Messages queued while the first is being written are sent in the order they were queued.
+	const n = 5
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- q.SendApp(ctx, proto.APP_CHANNEL_GET, []byte{byte(i)})
+		}(i)
+		for q.Len() < i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	close(w.open)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := New(halfDuplex{bytes.NewReader(w.buf.Bytes()), io.Discard}, proto.FLAG_FROM_CLIENT)
+	for i := 0; i < n; i++ {
+		msg, err := client.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(msg.Data, []byte{byte(i)}) {
+			t.Errorf("message %d is %x", i, msg.Data)
+		}
+	}
+}
+
+func TestBundlingQueue(t *testing.T) {
+	ctx := context.Background()
+	w := &gatedWriter{open: make(chan struct{})}
+	c := New(halfDuplex{bytes.NewReader(nil), w}, proto.FLAG_FROM_SERVER)
+	c.Version = 2
+	q := c.NewBundlingQueue()
+
+	// The first message holds up the rest, which are sent together once it has been written.
+	const n = 5
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	send := func(i int) {
+		defer wg.Done()
+		errs <- q.SendApp(ctx, proto.APP_CHANNEL_MONITOR, []byte{byte(i), 0xff})
+	}
+	wg.Add(1)
+	go send(0)
+	for q.Len() < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 1; i < n; i++ {
+		wg.Add(1)
+		go send(i)
+		for q.Len() < i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	close(w.open)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var commands []string
+	for r := bytes.NewReader(w.buf.Bytes()); r.Len() > 0; {
+		var h proto.PVAccessHeader
+		if err := h.PVDecode(&pvdata.DecoderState{Buf: r}); err != nil {
+			t.Fatal(err)
+		}
+		commands = append(commands, h.CommandName())
+		r.Seek(int64(h.PayloadSize), io.SeekCurrent)
+	}
+	if got, want := strings.Join(commands, " "), "CHANNEL_MONITOR MULTIPLE_DATA"; got != want {
+		t.Errorf("sent %s, want %s", got, want)
+	}
+
+	// Next returns the bundled messages in turn.
+	client := New(halfDuplex{bytes.NewReader(w.buf.Bytes()), io.Discard}, proto.FLAG_FROM_CLIENT)
+	for i := 0; i < n; i++ {
+		msg, err := client.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Header.MessageCommand != proto.APP_CHANNEL_MONITOR || !bytes.Equal(msg.Data, []byte{byte(i), 0xff}) {
+			t.Errorf("message %d is %s %x", i, msg.Header.CommandName(), msg.Data)
+		}
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	ctx := context.Background()
+	var buf loopback
+	c := New(&buf, proto.FLAG_FROM_SERVER)
+	payload := []byte{0, 0, 0, 1, 0xab, 0xcd}
+	if err := c.SendApp(ctx, proto.APP_CHANNEL_GET, payload); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := c.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		A, B pvdata.PVInt
+	}
+	err = msg.Decode(&out)
+	var de *DecodeError
+	if !errors.As(err, &de) {
+		t.Fatalf("Decode = %v, want a DecodeError", err)
+	}
+	if de.PayloadSize != 6 || de.Offset != 6 || !bytes.Equal(de.Excerpt, payload) || de.ExcerptStart != 0 {
+		t.Errorf("DecodeError = %+v", de)
+	}
+	if !errors.Is(err, ErrShortPayload) {
+		t.Errorf("Decode = %v, want an error wrapping ErrShortPayload", err)
+	}
+	for _, want := range []string{"CHANNEL_GET", "0x0a", "6 bytes", "offset 6", "payload[0:6] = 00000001abcd|"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q doesn't contain %q", err, want)
+		}
+	}
+	if err := msg.Peek(&out); !errors.As(err, &de) {
+		t.Errorf("Peek = %v, want a DecodeError", err)
+	}
+
+	fake := clock.NewFake(time.Unix(0, 0))
+	defer func(l *warningLimiter) { decodeWarnings = l }(decodeWarnings)
+	decodeWarnings = &warningLimiter{interval: time.Minute, clock: fake}
+	var log bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&log)
+	ctx = ctxlog.WithLogger(ctx, logrus.NewEntry(logger))
+	for i := 0; i < 3; i++ {
+		WarnDecode(ctx, err, "decoding get")
+	}
+	fake.Advance(time.Minute)
+	WarnDecode(ctx, err, "decoding get")
+	lines := strings.Split(strings.TrimSpace(log.String()), "\n")
+	if len(lines) != 2 || strings.Contains(lines[0], "suppressed") || !strings.Contains(lines[1], "suppressed=2") {
+		t.Errorf("logged:\n%s\nwant two warnings, the second with suppressed=2", log.String())
+	}
+	if !strings.Contains(lines[0], "offset=6") || !strings.Contains(lines[0], "message_command=CHANNEL_GET") {
+		t.Errorf("warning %q is missing the error's fields", lines[0])
+	}
+}
+
+func TestTrailingData(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		t.Run(fmt.Sprintf("strict=%v", strict), func(t *testing.T) {
+			var log bytes.Buffer
+			logger := logrus.New()
+			logger.SetOutput(&log)
+			ctx := ctxlog.WithLogger(context.Background(), logrus.NewEntry(logger))
+			defer func(l *warningLimiter) { decodeWarnings = l }(decodeWarnings)
+			decodeWarnings = &warningLimiter{interval: time.Minute, clock: clock.NewFake(time.Unix(0, 0))}
+			var buf loopback
+			server := New(&buf, proto.FLAG_FROM_SERVER)
+			server.Version = 2
+			c := New(&buf, 0)
+			c.Strict = strict
+			// The first message is left undecoded, the second is only partly decoded, and the third is decoded in full.
+			for _, payload := range [][]byte{{1, 2}, {1, 0, 0, 0, 0xab, 0xcd}, {2, 0, 0, 0}} {
+				if err := server.SendApp(ctx, proto.APP_CHANNEL_GET, payload); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := c.Next(ctx); err != nil {
+				t.Fatal(err)
+			}
+			msg, err := c.Next(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out pvdata.PVInt
+			if err := msg.Decode(&out); err != nil || out != 1 {
+				t.Fatalf("Decode = %v, %v, want 1", out, err)
+			}
+			msg, err = c.Next(ctx)
+			if strict {
+				if !errors.Is(err, ErrProtocolViolation) || !strings.Contains(err.Error(), "2 bytes left") {
+					t.Errorf("Next = %v, want a protocol violation for the 2 trailing bytes", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := msg.Decode(&out); err != nil || out != 2 {
+				t.Errorf("Decode = %v, %v, want 2", out, err)
+			}
+			if _, err := c.Next(ctx); err != io.EOF {
+				t.Errorf("Next = %v, want EOF", err)
+			}
+			lines := strings.Split(strings.TrimSpace(log.String()), "\n")
+			if len(lines) != 1 || !strings.Contains(lines[0], "2 bytes left") || !strings.Contains(lines[0], "offset=4") {
+				t.Errorf("logged:\n%s\nwant one warning about the 2 bytes after offset 4", log.String())
+			}
+		})
+	}
+}
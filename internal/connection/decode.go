@@ -0,0 +1,201 @@
+package connection
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/clock"
+	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+// DecodeError is returned by Message.Decode and Peek when a message's payload can't be decoded.
+type DecodeError struct {
+	Header proto.PVAccessHeader
+	// PayloadSize is the size of the payload, which is the header's PayloadSize unless the message was segmented.
+	PayloadSize int
+	// Offset is how far decoding had read into the payload when it failed.
+	Offset int
+	// Excerpt is part of the payload around Offset, starting at ExcerptStart.
+	Excerpt      []byte
+	ExcerptStart int
+	Err          error
+}
+
+// ErrShortPayload is wrapped by DecodeErrors for messages whose payloads end before what they hold has been decoded.
+var ErrShortPayload = errors.New("payload ended before the message was decoded")
+
+// ErrTrailingData is wrapped by the errors for messages whose payloads continue after what they hold was decoded.
+var ErrTrailingData = errors.New("payload continues after the message was decoded")
+
+// payloadError returns the error for a decoding that failed with err, which wraps ErrShortPayload if the
+// payload ran out.
+func payloadError(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: %v", ErrShortPayload, err)
+	}
+	return err
+}
+
+// checkDecoded checks that the last message returned by Next was decoded to the end of its payload,
+// if it was decoded at all. Trailing bytes mean that the peer sent fields this end doesn't know about, or that it
+// disagrees about the message's layout; either way the message's declared size keeps the stream in step.
+// On a Strict connection they are a protocol violation; otherwise they are skipped with a warning.
+func (c *Connection) checkDecoded(ctx context.Context) error {
+	msg := c.last
+	c.last = nil
+	if msg == nil || msg.failed {
+		return nil
+	}
+	// Messages that were only peeked at have no reader, and streamed messages are skipped as they are.
+	r, ok := msg.reader.(*messageReader)
+	if !ok || r.Len() == 0 {
+		return nil
+	}
+	err := newDecodeError(msg, len(msg.Data)-r.Len(), fmt.Errorf("%w: %d bytes left", ErrTrailingData, r.Len()))
+	if c.Strict {
+		return Violation(ctx, &msg.Header, "%v", err)
+	}
+	WarnDecode(ctx, err, "skipping the rest of a message")
+	return nil
+}
+
+// The most bytes before and from the offset of a decoding error that are kept in its excerpt.
+const (
+	excerptBefore = 16
+	excerptAfter  = 32
+)
+
+func newDecodeError(msg *Message, offset int, err error) *DecodeError {
+	start, end := offset-excerptBefore, offset+excerptAfter
+	if start < 0 {
+		start = 0
+	}
+	if end > len(msg.Data) {
+		end = len(msg.Data)
+	}
+	if start > end {
+		start = end
+	}
+	return &DecodeError{
+		Header:       msg.Header,
+		PayloadSize:  len(msg.Data),
+		Offset:       offset,
+		Excerpt:      append([]byte(nil), msg.Data[start:end]...),
+		ExcerptStart: start,
+		Err:          err,
+	}
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decoding %s message (command 0x%02x, %d bytes) at offset %d: %v; payload[%d:%d] = %s",
+		e.Header.CommandName(), byte(e.Header.MessageCommand), e.PayloadSize, e.Offset, e.Err,
+		e.ExcerptStart, e.ExcerptStart+len(e.Excerpt), e.excerptHex())
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// excerptHex returns the excerpt in hex, with a "|" at the offset where decoding failed.
+func (e *DecodeError) excerptHex() string {
+	at := e.Offset - e.ExcerptStart
+	if at < 0 || at > len(e.Excerpt) {
+		return hex.EncodeToString(e.Excerpt)
+	}
+	return hex.EncodeToString(e.Excerpt[:at]) + "|" + hex.EncodeToString(e.Excerpt[at:])
+}
+
+// decodeOffset returns how far into msg's payload r has read.
+func decodeOffset(msg *Message, r pvdata.Reader) int {
+	if l, ok := r.(interface{ Len() int }); ok {
+		return len(msg.Data) - l.Len()
+	}
+	return 0
+}
+
+// decodeWarningInterval is how long warnings logged by WarnDecode suppress identical ones.
+const decodeWarningInterval = time.Minute
+
+// decodeWarnings limits the warnings logged by WarnDecode.
+var decodeWarnings = &warningLimiter{interval: decodeWarningInterval}
+
+// WarnDecode logs a warning that a message couldn't be handled because of err, formatted as by fmt.Sprintf
+// with args and followed by err. If err is a DecodeError, its details are logged as fields.
+// Since a peer that sends one bad message usually sends many, a warning identical to one logged in the last
+// minute is only counted, and the count is logged with the next one that is.
+func WarnDecode(ctx context.Context, err error, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	fields := ctxlog.Fields{}
+	// Identical warnings differ only in the bytes of their excerpts, which are left out of the key.
+	key := msg + ": " + err.Error()
+	var de *DecodeError
+	if errors.As(err, &de) {
+		fields["message_command"] = de.Header.CommandName()
+		fields["payload_size"] = de.PayloadSize
+		fields["offset"] = de.Offset
+		fields["excerpt"] = de.excerptHex()
+		key = fmt.Sprintf("%s: %s %d: %v", msg, de.Header.CommandName(), de.Offset, de.Err)
+	}
+	suppressed, ok := decodeWarnings.allow(key)
+	if !ok {
+		return
+	}
+	if suppressed > 0 {
+		fields["suppressed"] = suppressed
+	}
+	ctxlog.L(ctx).WithFields(fields).Warnf("%s: %v", msg, err)
+}
+
+// warningLimiter suppresses warnings that are identical to one allowed in the last interval.
+type warningLimiter struct {
+	interval time.Duration
+	clock    clock.Clock
+
+	mu       sync.Mutex
+	warnings map[string]*limitedWarning
+}
+
+type limitedWarning struct {
+	allowed    time.Time
+	suppressed int
+}
+
+// maxLimitedWarnings is the number of distinct warnings after which those that no longer suppress anything are forgotten.
+const maxLimitedWarnings = 1000
+
+// allow reports whether the warning with the given key should be logged, and if so how many identical ones
+// were suppressed since the last that was.
+func (l *warningLimiter) allow(key string) (suppressed int, ok bool) {
+	now := clock.Or(l.clock).Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.warnings == nil {
+		l.warnings = make(map[string]*limitedWarning)
+	}
+	w := l.warnings[key]
+	if w != nil && now.Sub(w.allowed) < l.interval {
+		w.suppressed++
+		return 0, false
+	}
+	if w == nil {
+		if len(l.warnings) >= maxLimitedWarnings {
+			for k, w := range l.warnings {
+				if now.Sub(w.allowed) >= l.interval {
+					delete(l.warnings, k)
+				}
+			}
+		}
+		w = &limitedWarning{}
+		l.warnings[key] = w
+	}
+	suppressed = w.suppressed
+	w.allowed, w.suppressed = now, 0
+	return suppressed, true
+}
@@ -0,0 +1,66 @@
+package connection
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+// Health describes the liveness of a connection.
+type Health struct {
+	// Established is when the Connection was created.
+	Established time.Time
+	// LastActivity is when a message was last received from the peer.
+	LastActivity time.Time
+	// RTT is the round-trip time of the most recently answered Ping, or 0 if no Ping has been answered.
+	RTT time.Duration
+	// PeerVersion is the protocol version in the peer's most recent message.
+	PeerVersion pvdata.PVByte
+}
+
+// health tracks the liveness of a connection. It is updated by the goroutine calling Next.
+type health struct {
+	mu          sync.Mutex
+	h           Health
+	pingToken   pvdata.PVInt
+	pingSent    time.Time
+	pingPending bool
+}
+
+func (h *health) received(header *proto.PVAccessHeader) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.h.LastActivity = time.Now()
+	h.h.PeerVersion = header.Version
+}
+
+func (h *health) echoed(token pvdata.PVInt) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.pingPending && token == h.pingToken {
+		h.h.RTT = time.Since(h.pingSent)
+		h.pingPending = false
+	}
+}
+
+// Health returns the current liveness information for c.
+func (c *Connection) Health() Health {
+	c.health.mu.Lock()
+	defer c.health.mu.Unlock()
+	return c.health.h
+}
+
+// Ping sends an echo request to the peer. The response is processed by Next, which updates the RTT reported by Health.
+// Only the most recent Ping is timed; sending a new one before the previous one is answered abandons it.
+func (c *Connection) Ping(ctx context.Context) error {
+	c.health.mu.Lock()
+	c.health.pingToken++
+	token := c.health.pingToken
+	c.health.pingSent = time.Now()
+	c.health.pingPending = true
+	c.health.mu.Unlock()
+	return c.SendCtrl(ctx, proto.CTRL_ECHO_REQUEST, token)
+}
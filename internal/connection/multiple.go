@@ -0,0 +1,85 @@
+package connection
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+// maxBundleSize is the most bytes of payloads that a bundling Queue puts in one APP_MULTIPLE_DATA message.
+const maxBundleSize = 65536
+
+// bundled holds the messages of an APP_MULTIPLE_DATA message that have yet to be returned by Next.
+type bundled struct {
+	header   proto.PVAccessHeader
+	order    binary.ByteOrder
+	payloads [][]byte
+}
+
+// unbundle splits an APP_MULTIPLE_DATA message into the messages it holds, which Next returns in turn.
+func (c *Connection) unbundle(msg *Message) error {
+	var md proto.MultipleData
+	if err := msg.Decode(&md); err != nil {
+		return err
+	}
+	if md.MessageCommand == proto.APP_MULTIPLE_DATA {
+		return errors.New("nested multiple data message")
+	}
+	c.bundled.header = msg.Header
+	c.bundled.header.MessageCommand = md.MessageCommand
+	c.bundled.order = msg.order
+	c.bundled.payloads = md.Payloads
+	return nil
+}
+
+// nextBundled returns the next message of the current APP_MULTIPLE_DATA message, or nil if there are none left.
+func (c *Connection) nextBundled() *Message {
+	if len(c.bundled.payloads) == 0 {
+		return nil
+	}
+	data := c.bundled.payloads[0]
+	c.bundled.payloads[0] = nil
+	c.bundled.payloads = c.bundled.payloads[1:]
+	header := c.bundled.header
+	header.PayloadSize = pvdata.PVInt(len(data))
+	return &Message{Header: header, Data: data, c: c, order: c.bundled.order}
+}
+
+// NewBundlingQueue returns a Queue that sends messages waiting for each other in one APP_MULTIPLE_DATA message,
+// when they have the same command. It is for messages whose order relative to other Queues doesn't matter,
+// such as monitor updates, and must only be used if the peer decodes APP_MULTIPLE_DATA (see QOS_MULTIPLE_DATA).
+func (c *Connection) NewBundlingQueue() *Queue {
+	return &Queue{c: c, bundle: true}
+}
+
+// nextBundle removes the messages to send next from q.pending: the first, along with those after it with the same
+// command that fit in a bundle. q.mu must be held.
+func (q *Queue) nextBundle() []*queuedMessage {
+	n := 1
+	if q.bundle {
+		// Payloads of bundling queues are always encoded before they are queued.
+		size := len(q.pending[0].payload.([]byte))
+		for ; n < len(q.pending) && q.pending[n].messageCommand == q.pending[0].messageCommand; n++ {
+			if size += len(q.pending[n].payload.([]byte)); size > maxBundleSize {
+				break
+			}
+		}
+	}
+	ms := append([]*queuedMessage(nil), q.pending[:n]...)
+	for i := 0; i < n; i++ {
+		q.pending[i] = nil
+	}
+	q.pending = q.pending[n:]
+	return ms
+}
+
+// sendBundle sends ms, which have the same command, in one APP_MULTIPLE_DATA message.
+func (q *Queue) sendBundle(ms []*queuedMessage) error {
+	md := proto.MultipleData{MessageCommand: ms[0].messageCommand}
+	for _, m := range ms {
+		md.Payloads = append(md.Payloads, m.payload.([]byte))
+	}
+	return q.c.SendApp(ms[0].ctx, proto.APP_MULTIPLE_DATA, &md)
+}
@@ -0,0 +1,166 @@
+package connection
+
+import (
+	"bytes"
+	"context"
+	"sync"
+
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+// Queue serializes application messages sent on a Connection.
+// Messages sent through the same Queue are written in the order SendApp was called;
+// messages sent through different Queues (or directly on the Connection) may interleave,
+// but each message is always written whole.
+type Queue struct {
+	c *Connection
+
+	// bundle is set for queues created by NewBundlingQueue.
+	bundle bool
+
+	mu      sync.Mutex
+	pending []*queuedMessage
+	sending bool
+}
+
+type queuedMessage struct {
+	ctx            context.Context
+	messageCommand pvdata.PVByte
+	payload        interface{}
+	done           chan error
+}
+
+// NewQueue returns a new Queue that sends messages on c.
+func (c *Connection) NewQueue() *Queue {
+	return &Queue{c: c}
+}
+
+// SendApp queues an application message and waits until it has been written.
+// See Connection.SendApp for the allowed payload types.
+// It is safe to call SendApp from any goroutine.
+func (q *Queue) SendApp(ctx context.Context, messageCommand pvdata.PVByte, payload interface{}) error {
+	return q.send(ctx, messageCommand, payload, false)
+}
+
+// TrySendApp is like SendApp, but fails with ErrBufferFull instead of making the connection
+// buffer more than MaxBuffered bytes. It is for messages that can be dropped or resent later.
+func (q *Queue) TrySendApp(ctx context.Context, messageCommand pvdata.PVByte, payload interface{}) error {
+	return q.send(ctx, messageCommand, payload, true)
+}
+
+func (q *Queue) send(ctx context.Context, messageCommand pvdata.PVByte, payload interface{}, try bool) error {
+	if q.c.MaxBuffered > 0 || q.bundle {
+		if try && q.c.Overloaded() {
+			// Nothing more fits, so don't bother encoding the payload.
+			return ErrBufferFull
+		}
+		b, ok := payload.([]byte)
+		if !ok {
+			var err error
+			if b, err = q.c.encodeQueued(payload); err != nil {
+				return err
+			}
+		}
+		if q.c.MaxBuffered > 0 {
+			if !q.c.reserve(len(b), try) {
+				return ErrBufferFull
+			}
+			defer q.c.release(len(b))
+		}
+		payload = b
+	}
+	m := &queuedMessage{
+		ctx:            ctx,
+		messageCommand: messageCommand,
+		payload:        payload,
+		done:           make(chan error, 1),
+	}
+	q.mu.Lock()
+	q.pending = append(q.pending, m)
+	if q.sending {
+		// Another goroutine is already draining the queue and will send m.
+		q.mu.Unlock()
+		return <-m.done
+	}
+	q.sending = true
+	q.mu.Unlock()
+	q.drain()
+	return <-m.done
+}
+
+// drain sends pending messages until the queue is empty.
+func (q *Queue) drain() {
+	for {
+		q.mu.Lock()
+		if len(q.pending) == 0 {
+			q.sending = false
+			q.mu.Unlock()
+			return
+		}
+		ms := q.nextBundle()
+		q.mu.Unlock()
+		var err error
+		if len(ms) == 1 {
+			err = q.c.SendApp(ms[0].ctx, ms[0].messageCommand, ms[0].payload)
+		} else {
+			err = q.sendBundle(ms)
+		}
+		for _, m := range ms {
+			m.done <- err
+		}
+	}
+}
+
+// Len returns the number of messages waiting to be sent, including one being sent.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := len(q.pending)
+	if q.sending {
+		n++
+	}
+	return n
+}
+
+// encodeQueued encodes payload before it is queued, so that its size can be counted against MaxBuffered.
+func (c *Connection) encodeQueued(payload interface{}) ([]byte, error) {
+	c.bufferedMu.Lock()
+	order := c.queueOrder
+	c.bufferedMu.Unlock()
+	var buf bytes.Buffer
+	if err := c.serializer().Encode(&pvdata.EncoderState{Buf: &buf, ByteOrder: order}, payload); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// reserve counts n more bytes as buffered. If try is set, it fails instead if that would exceed MaxBuffered
+// while other messages are waiting.
+func (c *Connection) reserve(n int, try bool) bool {
+	c.bufferedMu.Lock()
+	defer c.bufferedMu.Unlock()
+	if try && c.buffered > 0 && c.buffered+n > c.MaxBuffered {
+		return false
+	}
+	c.buffered += n
+	return true
+}
+
+func (c *Connection) release(n int) {
+	c.bufferedMu.Lock()
+	defer c.bufferedMu.Unlock()
+	c.buffered -= n
+}
+
+// Buffered returns the number of bytes of messages waiting in Queues to be sent.
+// It is only counted if MaxBuffered is set.
+func (c *Connection) Buffered() int {
+	c.bufferedMu.Lock()
+	defer c.bufferedMu.Unlock()
+	return c.buffered
+}
+
+// Overloaded reports whether the connection is buffering at least MaxBuffered bytes.
+func (c *Connection) Overloaded() bool {
+	return c.MaxBuffered > 0 && c.Buffered() >= c.MaxBuffered
+}
@@ -0,0 +1,277 @@
+package connection
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/Lexcelon/go-pvaccess/types"
+)
+
+// segmentWriter sends a payload as segmented messages of at most size bytes each, as it is written.
+// A segment is only sent once more data follows it, so that the last segment can be marked;
+// a payload that fits in one segment is left in buf to be sent as an ordinary message.
+type segmentWriter struct {
+	c       *Connection
+	ctx     context.Context
+	command pvdata.PVByte
+	// out is the connection's writer, which is replaced by the segmentWriter while the payload is encoded.
+	out  pvdata.Writer
+	size int
+	buf  []byte
+	// segments is the number of segments sent, and total the number of bytes in them.
+	segments, total int
+}
+
+func (w *segmentWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(w.buf)+len(p) > w.size {
+		k := w.size - len(w.buf)
+		w.buf = append(w.buf, p[:k]...)
+		p = p[k:]
+		flag := proto.FLAG_SEGMENT_MIDDLE
+		if w.segments == 0 {
+			flag = proto.FLAG_SEGMENT_FIRST
+		}
+		if err := w.send(pvdata.PVUByte(flag)); err != nil {
+			return n - len(p), err
+		}
+	}
+	w.buf = append(w.buf, p...)
+	return n, nil
+}
+
+func (w *segmentWriter) WriteByte(b byte) error {
+	_, err := w.Write([]byte{b})
+	return err
+}
+
+func (w *segmentWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// send sends the buffered data as a segment with the given segment flag.
+func (w *segmentWriter) send(segment pvdata.PVUByte) error {
+	flags := proto.FLAG_MSG_APP | w.c.Direction | segment
+	if w.c.encoderState.ByteOrder == binary.BigEndian {
+		flags |= proto.FLAG_BO_BE
+	}
+	h := proto.PVAccessHeader{
+		Version:        w.c.Version,
+		Flags:          flags,
+		MessageCommand: w.command,
+		PayloadSize:    pvdata.PVInt(len(w.buf)),
+	}
+	ctxlog.For(w.ctx, ctxlog.Codec).WithFields(ctxlog.Fields{
+		"command":      proto.AppCommand(w.command),
+		"flags":        proto.Flags(flags),
+		"payload_size": len(w.buf),
+	}).Debug("sending app message segment")
+	if err := h.PVEncode(&pvdata.EncoderState{Buf: w.out, ByteOrder: w.c.encoderState.ByteOrder}); err != nil {
+		return err
+	}
+	if _, err := w.out.Write(w.buf); err != nil {
+		return err
+	}
+	w.segments++
+	w.total += len(w.buf)
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// sendSegmented encodes payload, sending it in segments if it is larger than c.SegmentSize.
+// If it fits in one message, it returns the encoded payload for the caller to send, and sent is false.
+// It must be called with encoderMu held.
+func (c *Connection) sendSegmented(ctx context.Context, messageCommand pvdata.PVByte, payload interface{}) (bytes []byte, sent bool, err error) {
+	w := &segmentWriter{c: c, ctx: ctx, command: messageCommand, out: c.encoderState.Buf, size: c.SegmentSize}
+	err = func() error {
+		if b, ok := payload.([]byte); ok {
+			_, err := w.Write(b)
+			return err
+		}
+		defer c.encoderState.PushWriter(w)()
+		return c.serializer().Encode(c.encoderState, payload)
+	}()
+	if w.segments == 0 {
+		return w.buf, false, err
+	}
+	if err == nil {
+		err = w.send(proto.FLAG_SEGMENT_LAST)
+	}
+	if err != nil {
+		// The peer has received part of the message, and can't find the start of the next one.
+		c.Close()
+		return nil, true, err
+	}
+	if c.OnSend != nil {
+		c.OnSend(messageCommand, w.total)
+	}
+	return nil, true, nil
+}
+
+// segments is the state of a segmented message being received. It is only used by the goroutine calling Next.
+type segments struct {
+	// active is set between the first and last segments of a message.
+	active  bool
+	command pvdata.PVByte
+	buf     []byte
+	// order is the byte order of the message, from its first segment.
+	order binary.ByteOrder
+	// stream is the last message returned by Next without being reassembled, if it was.
+	stream *segmentStream
+	// setByteOrder is the CTRL_SET_BYTE_ORDER message received between the segments of the current message, if any.
+	// It is handled after the last segment.
+	setByteOrder *proto.PVAccessHeader
+	// limit is the largest reassembled payload accepted, or zero for types.MaxPayloadSize.
+	limit int
+}
+
+// receiving reports whether a segmented message is being received, between its first and last segments.
+func (s *segments) receiving() bool {
+	return s.active || s.stream != nil && !s.stream.last
+}
+
+// add adds the payload of a segment to the message being received, whose payload is in order. When the last
+// segment has been added, it returns the whole payload, which is valid until the next call.
+// Messages whose payload would exceed the limit are rejected, since the peer could otherwise make us buffer
+// any amount of data.
+func (s *segments) add(header proto.PVAccessHeader, data []byte, order binary.ByteOrder) ([]byte, bool, error) {
+	limit := s.limit
+	if limit == 0 {
+		limit = types.MaxPayloadSize
+	}
+	if len(data) > limit || s.active && len(s.buf) > limit-len(data) {
+		s.active = false
+		return nil, false, fmt.Errorf("segmented %v message exceeds %d bytes", proto.AppCommand(header.MessageCommand), limit)
+	}
+	switch header.Flags & proto.FLAG_SEGMENT_MASK {
+	case proto.FLAG_SEGMENT_FIRST:
+		if s.active {
+			return nil, false, fmt.Errorf("segmented %v message started before the end of %v", proto.AppCommand(header.MessageCommand), proto.AppCommand(s.command))
+		}
+		s.active, s.command, s.order, s.buf = true, header.MessageCommand, order, append(s.buf[:0], data...)
+		return nil, false, nil
+	}
+	if !s.active {
+		return nil, false, errors.New("received a message segment without the first segment")
+	}
+	if header.MessageCommand != s.command {
+		return nil, false, fmt.Errorf("received a %v segment in a segmented %v message", proto.AppCommand(header.MessageCommand), proto.AppCommand(s.command))
+	}
+	s.buf = append(s.buf, data...)
+	if header.Flags&proto.FLAG_SEGMENT_MASK == proto.FLAG_SEGMENT_LAST {
+		s.active = false
+		return s.buf, true, nil
+	}
+	return nil, false, nil
+}
+
+// segmentedDone handles the control messages that were put off until the end of a segmented message.
+func (c *Connection) segmentedDone(ctx context.Context) error {
+	header := c.segments.setByteOrder
+	if header == nil {
+		return nil
+	}
+	c.segments.setByteOrder = nil
+	return c.handleSetByteOrder(ctx, header)
+}
+
+// streamSegmented returns the message starting with the segment header and data without reassembling it,
+// if StreamSegmented asks for it, or else nil.
+func (c *Connection) streamSegmented(ctx context.Context, header proto.PVAccessHeader, data []byte) *Message {
+	if c.StreamSegmented == nil || c.segments.active || header.Flags&proto.FLAG_SEGMENT_MASK != proto.FLAG_SEGMENT_FIRST ||
+		header.MessageCommand == proto.APP_MULTIPLE_DATA || !c.StreamSegmented(header, data) {
+		return nil
+	}
+	stream := &segmentStream{c: c, ctx: ctx, command: header.MessageCommand, data: data}
+	c.segments.stream = stream
+	header.Flags &^= proto.FLAG_SEGMENT_MASK
+	return &Message{Header: header, Data: data, c: c, reader: stream, order: c.decoderState.ByteOrder}
+}
+
+// skipStream reads the rest of the last streamed message, if any, so that the next message can be read.
+func (c *Connection) skipStream(ctx context.Context) error {
+	stream := c.segments.stream
+	if stream == nil {
+		return nil
+	}
+	for !stream.last {
+		stream.data = nil
+		if err := stream.next(); err != nil {
+			return err
+		}
+	}
+	c.segments.stream = nil
+	return c.segmentedDone(ctx)
+}
+
+// segmentStream reads the payload of a streamed message, reading each segment from the connection once the
+// previous one has been decoded.
+type segmentStream struct {
+	c       *Connection
+	ctx     context.Context
+	command pvdata.PVByte
+	// data is the rest of the current segment, which is borrowed from the connection's receive buffer.
+	data []byte
+	// last is set once the last segment has been read.
+	last bool
+	err  error
+}
+
+func (r *segmentStream) Read(p []byte) (int, error) {
+	for len(r.data) == 0 {
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func (r *segmentStream) ReadByte() (byte, error) {
+	for len(r.data) == 0 {
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	b := r.data[0]
+	r.data = r.data[1:]
+	return b, nil
+}
+
+// next reads the next segment from the connection, or returns io.EOF after the last one.
+// Errors other than io.EOF break the connection, and are returned by every later read and by Next.
+func (r *segmentStream) next() error {
+	if r.err != nil {
+		return r.err
+	}
+	if r.last {
+		return io.EOF
+	}
+	// The message is being decoded from r, with the connection's decoder state.
+	s := r.c.decoderState
+	defer func(bo binary.ByteOrder) { s.ByteOrder = bo }(s.ByteOrder)
+	defer s.PushReader(r.c.recv)()
+	header, data, err := r.c.readMessage(r.ctx)
+	if err == nil {
+		switch {
+		case header.MessageCommand != r.command:
+			err = fmt.Errorf("received a %v message in a segmented %v message", proto.AppCommand(header.MessageCommand), proto.AppCommand(r.command))
+		case header.Flags&proto.FLAG_SEGMENT_MASK == proto.FLAG_SEGMENT_NONE, header.Flags&proto.FLAG_SEGMENT_MASK == proto.FLAG_SEGMENT_FIRST:
+			err = fmt.Errorf("segmented %v message started before the end of the last", proto.AppCommand(r.command))
+		}
+	}
+	if err != nil {
+		r.err = err
+		return err
+	}
+	r.data = data
+	r.last = header.Flags&proto.FLAG_SEGMENT_MASK == proto.FLAG_SEGMENT_LAST
+	return nil
+}
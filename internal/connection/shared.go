@@ -0,0 +1,100 @@
+package connection
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// receiveBuffers holds buffers for payloads larger than the receive buffer that were shared by decoded values
+// and then released, to be reused for later payloads.
+var receiveBuffers sync.Pool
+
+// takeBuffer returns a buffer of n bytes, reusing a released one if it is large enough.
+func takeBuffer(n int) []byte {
+	if p, _ := receiveBuffers.Get().(*[]byte); p != nil && cap(*p) >= n {
+		return (*p)[:n]
+	}
+	return make([]byte, n)
+}
+
+// sharedBuffer is a buffer that a connection has given up to the values sharing it. It holds a reference for
+// the message whose payload it holds until the next message is read, and one for each share.
+type sharedBuffer struct {
+	buf  []byte
+	refs int32
+}
+
+// share adds a reference to b, and returns a function that removes it.
+func (b *sharedBuffer) share() func() {
+	atomic.AddInt32(&b.refs, 1)
+	var once sync.Once
+	return func() { once.Do(b.release) }
+}
+
+// release removes a reference to b, returning its buffer to receiveBuffers once there are none.
+func (b *sharedBuffer) release() {
+	if atomic.AddInt32(&b.refs, -1) == 0 {
+		buf := b.buf[:cap(b.buf)]
+		receiveBuffers.Put(&buf)
+	}
+}
+
+// bufferOf returns the field of c holding the buffer that data is at the start of, or nil if it is in c.recv,
+// which is always reused, or is empty.
+func (c *Connection) bufferOf(data []byte) *[]byte {
+	if len(data) == 0 {
+		return nil
+	}
+	for _, b := range []*[]byte{&c.recvBuf, &c.compression.buf, &c.segments.buf} {
+		if cap(*b) > 0 && &(*b)[:1][0] == &data[0] {
+			return b
+		}
+	}
+	return nil
+}
+
+// releaseShared releases the last message's reference to the buffer that it shared, if any.
+func (c *Connection) releaseShared() {
+	if c.shared != nil {
+		c.shared.release()
+		c.shared = nil
+	}
+}
+
+// share returns a function releasing a new share of msg's Data, or nil if Data can't be shared.
+// The first share of a buffer makes the connection give it up.
+func (msg *Message) share() func() {
+	if msg.copied {
+		return func() {}
+	}
+	if msg.shared == nil {
+		if msg.owner == nil || msg.c.shared != nil {
+			return nil
+		}
+		msg.shared = &sharedBuffer{buf: *msg.owner, refs: 1}
+		*msg.owner = nil
+		msg.c.shared = msg.shared
+	}
+	return msg.shared.share()
+}
+
+// messageReader reads the payload of a message, letting pvdata.SharedBytes share it. See Message.Decode.
+type messageReader struct {
+	*bytes.Reader
+	msg *Message
+}
+
+func (r *messageReader) Share(n int) ([]byte, func(), bool) {
+	if n > r.Len() {
+		return nil, nil, false
+	}
+	release := r.msg.share()
+	if release == nil {
+		return nil, nil, false
+	}
+	off := len(r.msg.Data) - r.Len()
+	r.Seek(int64(n), io.SeekCurrent)
+	return r.msg.Data[off : off+n : off+n], release, true
+}
@@ -0,0 +1,46 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
+	"github.com/Lexcelon/go-pvaccess/proto"
+)
+
+// ErrProtocolViolation is returned by Next on a Strict connection when the peer breaks the protocol.
+var ErrProtocolViolation = errors.New("protocol violation")
+
+// knownFlags are the header flags defined by the protocol; the others are reserved and must be zero.
+const knownFlags = proto.FLAG_MSG_CTRL | proto.FLAG_COMPRESSED | proto.FLAG_SEGMENT_MASK | proto.FLAG_FROM_SERVER | proto.FLAG_BO_BE
+
+// Violation logs a protocol violation by the peer that sent header, and returns an error wrapping ErrProtocolViolation.
+func Violation(ctx context.Context, header *proto.PVAccessHeader, format string, args ...interface{}) error {
+	err := fmt.Errorf("%w: %s", ErrProtocolViolation, fmt.Sprintf(format, args...))
+	ctxlog.L(ctx).WithFields(ctxlog.Fields{
+		"version":         header.Version,
+		"flags":           proto.Flags(header.Flags),
+		"message_command": header.CommandName(),
+		"payload_size":    header.PayloadSize,
+	}).Warn(err)
+	return err
+}
+
+// checkHeader checks a header received on a Strict connection.
+// Headers with the wrong magic byte are rejected by decoding regardless.
+func (c *Connection) checkHeader(ctx context.Context, header *proto.PVAccessHeader) error {
+	if header.Version < 1 {
+		return Violation(ctx, header, "unsupported protocol version %d", header.Version)
+	}
+	if reserved := header.Flags &^ knownFlags; reserved != 0 {
+		return Violation(ctx, header, "reserved flag bits 0x%02x are set", byte(reserved))
+	}
+	if header.Flags&proto.FLAG_FROM_SERVER == c.Direction {
+		return Violation(ctx, header, "direction flag is this end's, not the peer's")
+	}
+	if header.Flags&proto.FLAG_MSG_CTRL != 0 && header.Flags&(proto.FLAG_COMPRESSED|proto.FLAG_SEGMENT_MASK) != 0 {
+		return Violation(ctx, header, "control message is segmented or compressed")
+	}
+	return nil
+}
@@ -4,6 +4,8 @@ package ctxlog
 
 import (
 	"context"
+	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
@@ -14,9 +16,56 @@ var (
 )
 
 type (
-	loggerKey struct{}
+	loggerKey    struct{}
+	subsystemKey struct{}
 )
 
+// Subsystems whose log levels can be set independently with SetLevel.
+const (
+	// Search logs UDP searches and beacons, by clients and servers.
+	Search = "search"
+	// Connection logs the lifecycle of TCP connections: validation, control messages, and disconnection.
+	Connection = "connection"
+	// Codec logs every message sent and received, and at trace level, their bytes.
+	Codec = "codec"
+	// Provider logs the dispatch of requests to channel providers, and the providers' own messages.
+	Provider = "provider"
+)
+
+var (
+	levelsMu sync.RWMutex
+	// levels are the levels set for subsystems; other subsystems use the level of their logger.
+	levels = map[string]logrus.Level{}
+)
+
+// SetLevel sets the level of the messages logged by subsystem, overriding the level of the logger.
+func SetLevel(subsystem string, level logrus.Level) {
+	levelsMu.Lock()
+	defer levelsMu.Unlock()
+	levels[subsystem] = level
+}
+
+// ResetLevel makes subsystem log at the level of the logger again.
+func ResetLevel(subsystem string) {
+	levelsMu.Lock()
+	defer levelsMu.Unlock()
+	delete(levels, subsystem)
+}
+
+func subsystemLevel(subsystem string) (logrus.Level, bool) {
+	levelsMu.RLock()
+	defer levelsMu.RUnlock()
+	level, ok := levels[subsystem]
+	return level, ok
+}
+
+// WithSubsystem returns a new context whose messages belong to subsystem: they have a "subsystem" field,
+// and are logged at the subsystem's level, if one was set with SetLevel.
+func WithSubsystem(ctx context.Context, subsystem string) context.Context {
+	ctx = WithField(ctx, "subsystem", subsystem)
+	return context.WithValue(ctx, subsystemKey{}, subsystem)
+}
+
 // WithLogger returns a new context with the provided logger. Use in
 // combination with logger.WithField(s) for great effect.
 func WithLogger(ctx context.Context, logger *logrus.Entry) context.Context {
@@ -38,6 +87,26 @@ type Fields = logrus.Fields
 // Logger retrieves the current logger from the context. If no logger is
 // available, the default logger is returned.
 func Logger(ctx context.Context) *logrus.Entry {
+	entry := contextLogger(ctx)
+	if subsystem, ok := ctx.Value(subsystemKey{}).(string); ok {
+		if level, ok := subsystemLevel(subsystem); ok {
+			return withLevel(entry, level)
+		}
+	}
+	return entry
+}
+
+// For returns the logger for messages of subsystem, like Logger(WithSubsystem(ctx, subsystem)),
+// for code that logs for a different subsystem than its context's.
+func For(ctx context.Context, subsystem string) *logrus.Entry {
+	entry := contextLogger(ctx).WithField("subsystem", subsystem)
+	if level, ok := subsystemLevel(subsystem); ok {
+		return withLevel(entry, level)
+	}
+	return entry
+}
+
+func contextLogger(ctx context.Context) *logrus.Entry {
 	logger := ctx.Value(loggerKey{})
 
 	if logger == nil {
@@ -46,3 +115,32 @@ func Logger(ctx context.Context) *logrus.Entry {
 
 	return logger.(*logrus.Entry)
 }
+
+// withLevel returns a copy of entry whose logger logs at level, but is otherwise the same as entry's.
+func withLevel(entry *logrus.Entry, level logrus.Level) *logrus.Entry {
+	base := entry.Logger
+	logger := &logrus.Logger{
+		Out:          base.Out,
+		Hooks:        base.Hooks,
+		Formatter:    base.Formatter,
+		ReportCaller: base.ReportCaller,
+		Level:        level,
+		ExitFunc:     base.ExitFunc,
+	}
+	return logrus.NewEntry(logger).WithFields(entry.Data).WithContext(entry.Context)
+}
+
+// Detach returns a context with the values of ctx, including its logger, but not its deadline or cancellation,
+// for work that must outlive ctx.
+func Detach(ctx context.Context) context.Context {
+	return detachedContext{ctx}
+}
+
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
@@ -2,28 +2,35 @@ package search
 
 import (
 	"context"
+	"net"
 
 	"github.com/Lexcelon/go-pvaccess/internal/connection"
 	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
-	"github.com/Lexcelon/go-pvaccess/internal/proto"
+	"github.com/Lexcelon/go-pvaccess/proto"
 	"github.com/Lexcelon/go-pvaccess/pvdata"
 	"github.com/Lexcelon/go-pvaccess/types"
 )
 
 func (s *Server) Search(ctx context.Context, c *connection.Connection, req proto.SearchRequest) error {
 	// TODO: When search is received over TCP, do we respond over TCP or do we respond over UDP?
+	address, port := s.advertisedAddress()
 	resp := &proto.SearchResponse{
 		GUID:             s.GUID,
 		SearchSequenceID: req.SearchSequenceID,
-		ServerPort:       pvdata.PVUShort(s.ServerAddr.Port),
+		ServerAddress:    address,
+		ServerPort:       port,
 		Protocol:         "tcp",
 		Found:            true,
 	}
-	copy(resp.ServerAddress[:], []byte(s.ServerAddr.IP.To16()))
 	for _, p := range s.Server.ChannelProviders() {
+		aliases := channelAliases(ctx, p)
 		for _, channel := range req.Channels {
+			name := channel.ChannelName
+			if canonical, ok := aliases[name]; ok {
+				name = canonical
+			}
 			if p, ok := p.(types.ChannelFinder); ok {
-				present, err := p.ChannelFind(ctx, channel.ChannelName)
+				present, err := p.ChannelFind(ctx, name)
 				if err != nil {
 					ctxlog.L(ctx).Errorf("while attempting to find channel %q: %v", channel.ChannelName, err)
 					continue
@@ -33,7 +40,7 @@ func (s *Server) Search(ctx context.Context, c *connection.Connection, req proto
 				}
 				continue
 			}
-			c, err := p.CreateChannel(ctx, channel.ChannelName)
+			c, err := p.CreateChannel(ctx, name)
 			if err != nil {
 				ctxlog.L(ctx).Errorf("while attempting to create channel %q: %v", channel.ChannelName, err)
 				continue
@@ -43,16 +50,50 @@ func (s *Server) Search(ctx context.Context, c *connection.Connection, req proto
 			}
 		}
 	}
+	// Searches that find nothing are only answered if the client requires it, so that the servers sharing
+	// the host's search port don't all answer every search for the others' channels.
 	if len(resp.SearchInstanceIDs) == 0 {
+		if req.Flags&proto.SEARCH_REPLY_REQUIRED != proto.SEARCH_REPLY_REQUIRED {
+			return nil
+		}
 		resp.Found = false
 		for _, channel := range req.Channels {
 			resp.SearchInstanceIDs = append(resp.SearchInstanceIDs, channel.SearchInstanceID)
 		}
 	}
-	if len(resp.SearchInstanceIDs) > 0 || req.Flags&proto.SEARCH_REPLY_REQUIRED == proto.SEARCH_REPLY_REQUIRED {
-		if err := c.SendApp(ctx, proto.APP_SEARCH_RESPONSE, resp); err != nil {
-			return err
+	return c.SendApp(ctx, proto.APP_SEARCH_RESPONSE, resp)
+}
+
+// channelAliases returns the aliases of p's channels, if it has any.
+func channelAliases(ctx context.Context, p types.ChannelProvider) map[string]string {
+	aliaser, ok := p.(types.ChannelAliaser)
+	if !ok {
+		return nil
+	}
+	aliases, err := aliaser.ChannelAliases(ctx)
+	if err != nil {
+		ctxlog.L(ctx).Errorf("while listing aliases of %v: %v", p, err)
+	}
+	return aliases
+}
+
+// advertisedAddress returns the TCP address that beacons and search responses direct clients to.
+// If the server listens on all interfaces, the address is 0.0.0.0 (as an IPv4-mapped address, even for IPv6 listeners),
+// which tells clients to connect to the source address of the packet; otherwise it is the listener's own address.
+func (s *Server) advertisedAddress() ([16]byte, pvdata.PVUShort) {
+	ip, port := s.ServerAddr.IP, s.ServerAddr.Port
+	if o := s.AddressOverride; o != nil {
+		if o.IP != nil {
+			ip = o.IP
 		}
+		if o.Port != 0 {
+			port = o.Port
+		}
+	}
+	if ip == nil || ip.IsUnspecified() {
+		ip = net.IPv4zero
 	}
-	return nil
+	var address [16]byte
+	copy(address[:], ip.To16())
+	return address, pvdata.PVUShort(port)
 }
@@ -0,0 +1,140 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/internal/connection"
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/Lexcelon/go-pvaccess/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAdvertisedAddress(t *testing.T) {
+	tests := []struct {
+		name     string
+		addr     *net.TCPAddr
+		override *net.TCPAddr
+		want     net.IP
+		wantPort pvdata.PVUShort
+	}{
+		{"ipv4 any", &net.TCPAddr{IP: net.IPv4zero, Port: 5075}, nil, net.IPv4zero, 5075},
+		{"ipv6 any", &net.TCPAddr{IP: net.IPv6unspecified, Port: 5075}, nil, net.IPv4zero, 5075},
+		{"no ip", &net.TCPAddr{Port: 1234}, nil, net.IPv4zero, 1234},
+		{"specific", &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 5075}, nil, net.IPv4(10, 0, 0, 1), 5075},
+		{"override", &net.TCPAddr{IP: net.IPv4zero, Port: 5075}, &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 15075}, net.IPv4(192, 0, 2, 1), 15075},
+		{"override ip only", &net.TCPAddr{IP: net.IPv4zero, Port: 5075}, &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1)}, net.IPv4(192, 0, 2, 1), 5075},
+		{"override port only", &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 5075}, &net.TCPAddr{Port: 15075}, net.IPv4(10, 0, 0, 1), 15075},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &Server{ServerAddr: test.addr, AddressOverride: test.override}
+			address, port := s.advertisedAddress()
+			if got := net.IP(address[:]); !got.Equal(test.want) || len(got) != net.IPv6len || got.To4() == nil {
+				t.Errorf("address = %v, want IPv4-mapped %v", got, test.want)
+			}
+			if port != test.wantPort {
+				t.Errorf("port = %d, want %d", port, test.wantPort)
+			}
+		})
+	}
+}
+
+type providers []types.ChannelProvider
+
+func (p providers) ChannelProviders() []types.ChannelProvider { return p }
+
+// finder is a provider with one channel, which it finds without creating it.
+type finder string
+
+func (f finder) CreateChannel(ctx context.Context, name string) (types.Channel, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (f finder) ChannelFind(ctx context.Context, name string) (bool, error) {
+	return name == string(f), nil
+}
+
+func TestSharedSearchPort(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Two servers share the search port, as if they were in different processes.
+	servers := map[[12]byte]string{}
+	for i, name := range []string{"a", "b"} {
+		s := &Server{
+			GUID:       [12]byte{byte(i + 1)},
+			ServerAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1000 + i},
+			Server:     providers{finder(name)},
+		}
+		servers[s.GUID] = name
+		go s.Serve(ctx)
+		for deadline := time.Now().Add(5 * time.Second); s.Beacons().Sent == 0; time.Sleep(10 * time.Millisecond) {
+			if err := s.Beacons().Err; err != nil {
+				t.Skipf("can't listen for searches: %v", err)
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("server did not start")
+			}
+		}
+	}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// The request has no response address, so the server it reaches has to fill it in when it forwards it.
+	var buf bytes.Buffer
+	c := connection.New(&buf, proto.FLAG_FROM_CLIENT)
+	c.Version = 2
+	c.SendApp(ctx, proto.APP_SEARCH_REQUEST, &proto.SearchRequest{
+		Flags:        proto.SEARCH_UNICAST,
+		ResponsePort: pvdata.PVUShort(conn.LocalAddr().(*net.UDPAddr).Port),
+		Protocols:    []pvdata.PVString{"tcp"},
+		Channels: []proto.SearchRequest_Channel{
+			{SearchInstanceID: 1, ChannelName: "a"},
+			{SearchInstanceID: 2, ChannelName: "b"},
+			{SearchInstanceID: 3, ChannelName: "missing"},
+		},
+	})
+	if _, err := conn.WriteToUDP(buf.Bytes(), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5076}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each server answers once, for its own channel; nobody answers for the missing one.
+	var got []string
+	conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	p := make([]byte, 65536)
+	for {
+		n, _, err := conn.ReadFromUDP(p)
+		if err != nil {
+			break
+		}
+		msg, err := connection.New(bytes.NewBuffer(p[:n]), proto.FLAG_FROM_SERVER).Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resp proto.SearchResponse
+		if err := msg.Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		name, ok := servers[resp.GUID]
+		if !ok {
+			// Another pvAccess server on the host.
+			continue
+		}
+		for _, id := range resp.SearchInstanceIDs {
+			got = append(got, name+" found "+[]string{"", "a", "b", "missing"}[id])
+		}
+	}
+	sort.Strings(got)
+	if diff := cmp.Diff([]string{"a found a", "b found b"}, got); diff != "" {
+		t.Errorf("responses differ (-want +got):\n%s", diff)
+	}
+}
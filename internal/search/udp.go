@@ -7,12 +7,14 @@ import (
 	"crypto/rand"
 	"io"
 	"net"
+	"sync"
 	"time"
 
+	"github.com/Lexcelon/go-pvaccess/clock"
 	"github.com/Lexcelon/go-pvaccess/internal/connection"
 	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
-	"github.com/Lexcelon/go-pvaccess/internal/proto"
 	"github.com/Lexcelon/go-pvaccess/internal/udpconn"
+	"github.com/Lexcelon/go-pvaccess/proto"
 	"github.com/Lexcelon/go-pvaccess/pvdata"
 	"github.com/Lexcelon/go-pvaccess/types"
 )
@@ -29,29 +31,60 @@ type ChannelProviderser interface {
 
 // Server handles UDP beacons and searches.
 type Server struct {
-	// GUID is a randomly generated GUID that identifies the server.
-	// It will be filled in by Serve if it is blank.
+	// GUID identifies the server in beacons and search responses.
 	GUID [12]byte
 	// ServerAddr is the TCP address that the TCP server is listening on.
 	ServerAddr *net.TCPAddr
+	// AddressOverride, if non-nil, replaces the address advertised in beacons and search responses,
+	// for servers that clients reach through NAT. A nil IP or zero port keeps the corresponding part of ServerAddr.
+	AddressOverride *net.TCPAddr
+
+	// Conn, if non-nil, is the socket that beacons and search responses are sent from, instead of one on a random port.
+	// It is not closed by the server.
+	Conn *net.UDPConn
 
 	Server ChannelProviderser
+	// Clock measures the intervals between beacons. Nil selects the system clock.
+	Clock clock.Clock
+
+	mu     sync.Mutex
+	beacon BeaconStatus
+}
+
+// BeaconStatus describes the beacons sent by a Server.
+type BeaconStatus struct {
+	// Sent is the number of beacons sent, and Last is when the last one was sent.
+	Sent int
+	Last time.Time
+	// Err is the error from the last beacon sent, or the error that stopped the server.
+	Err error
+}
+
+// Beacons returns the status of the server's beacons.
+func (s *Server) Beacons() BeaconStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.beacon
+}
+
+func (s *Server) beaconSent(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		s.beacon.Sent++
+		s.beacon.Last = clock.Or(s.Clock).Now()
+	}
+	s.beacon.Err = err
 }
 
 // Serve transmits beacons and listens for searches on every interface on the machine.
 func (s *Server) Serve(ctx context.Context) error {
-	if s.GUID == [12]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0} {
-		if _, err := rand.Read(s.GUID[:]); err != nil {
-			return err
-		}
-	}
 	beacon := proto.BeaconMessage{
 		GUID: s.GUID,
 	}
-	if len(s.ServerAddr.IP) > 0 {
-		copy(beacon.ServerAddress[:], s.ServerAddr.IP.To16())
-	}
-	beacon.ServerPort = uint16(s.ServerAddr.Port)
+	address, port := s.advertisedAddress()
+	beacon.ServerAddress = address
+	beacon.ServerPort = uint16(port)
 	beacon.Protocol = "tcp"
 
 	// We need a bunch of sockets.
@@ -65,9 +98,10 @@ func (s *Server) Serve(ctx context.Context) error {
 	//   Listen on 224.0.0.128:5076
 	//   IP_ADD_MEMBERSHIP 224.0.0.128, 127.0.0.1
 
-	ln, err := udpconn.Listen(ctx)
+	ln, err := udpconn.Listen(ctx, s.Conn)
 	if err != nil {
 		ctxlog.L(ctx).Errorf("udpconn Listen error: %v", err)
+		s.beaconSent(err)
 		return err
 	}
 
@@ -82,20 +116,28 @@ func (s *Server) Serve(ctx context.Context) error {
 		}
 	}()
 
-	ticker := time.NewTicker(startupInterval)
+	ticker := clock.Or(s.Clock).NewTicker(startupInterval)
 	defer func() { ticker.Stop() }()
 	i := 0
 	for {
 		select {
 		case <-ctx.Done():
+			// A beacon with a new GUID tells clients that the server at this address has changed,
+			// so that they search for its channels again instead of waiting for their connections to time out.
+			if _, err := rand.Read(beacon.GUID[:]); err == nil {
+				beacon.BeaconSequenceID++
+				if err := beaconSender.SendApp(ctxlog.Detach(ctx), proto.APP_BEACON, &beacon); err != nil {
+					ctxlog.L(ctx).Warnf("sending final beacon: %v", err)
+				}
+			}
 			return ctx.Err()
-		case <-ticker.C:
+		case <-ticker.C():
 			beacon.BeaconSequenceID++
-			beaconSender.SendApp(ctx, proto.APP_BEACON, &beacon)
+			s.beaconSent(beaconSender.SendApp(ctx, proto.APP_BEACON, &beacon))
 			i++
 			if i == startupCount {
 				ticker.Stop()
-				ticker = time.NewTicker(beaconInterval)
+				ticker = clock.Or(s.Clock).NewTicker(beaconInterval)
 			}
 		}
 	}
@@ -145,6 +187,10 @@ func (s *Server) handleConnection(ctx context.Context, ln *udpconn.Listener, con
 			if err := msg.Decode(&req); err != nil {
 				return err
 			}
+			// Searches this server forwarded come back to it through the multicast group, but it has already answered them.
+			if from := conn.Addr(); from.Port == ln.LocalAddr().Port && (from.IP.IsLoopback() || ln.IsTappedIP(from.IP)) {
+				return nil
+			}
 			forwarderAddress := net.IP(req.ForwarderAddress[:])
 			if !forwarderAddress.IsUnspecified() {
 				if !ln.IsTappedIP(forwarderAddress) {
@@ -158,11 +204,14 @@ func (s *Server) handleConnection(ctx context.Context, ln *udpconn.Listener, con
 				return err
 			}
 			ctxlog.L(ctx).Debugf("search request received: %#v", req)
-			// Process search
+			// Every server on the host binds the search port with SO_REUSEPORT, so broadcast and multicast searches reach
+			// them all, but the kernel delivers each unicast search to only one of them. That one forwards it to the
+			// multicast group that they have all joined, tagged with its origin, so that the others can answer it too.
 			if req.Flags&proto.SEARCH_UNICAST == proto.SEARCH_UNICAST {
 				var buf bytes.Buffer
+				// The tag is the address the search was sent to, which the other servers check is one of the host's.
 				var localAddrArray [16]byte
-				copy(localAddrArray[:], []byte(ln.LocalAddr().IP.To16()))
+				copy(localAddrArray[:], []byte(conn.LocalAddr().IP.To16()))
 				fwdConn := connection.New(&buf, msg.Header.Flags&proto.FLAG_FROM_SERVER)
 				fwdConn.SendApp(ctx, proto.APP_ORIGIN_TAG, &proto.OriginTag{
 					ForwarderAddress: localAddrArray,
@@ -170,7 +219,7 @@ func (s *Server) handleConnection(ctx context.Context, ln *udpconn.Listener, con
 				fwdReq := req
 				fwdReq.Flags &= ^pvdata.PVUByte(proto.SEARCH_UNICAST)
 				if net.IP(fwdReq.ResponseAddress[:]).IsUnspecified() {
-					copy(fwdReq.ResponseAddress[:], conn.Addr().IP)
+					copy(fwdReq.ResponseAddress[:], conn.Addr().IP.To16())
 				}
 				fwdConn.SendApp(ctx, proto.APP_SEARCH_REQUEST, &fwdReq)
 				if _, err := ln.WriteMulticast(buf.Bytes()); err != nil {
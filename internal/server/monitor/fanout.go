@@ -0,0 +1,223 @@
+package monitor
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
+	"github.com/Lexcelon/go-pvaccess/types"
+)
+
+// subscriberQueueSize is the number of updates queued for each subscriber to a shared subscription.
+// When a subscriber falls further behind, its oldest updates are dropped; monitors coalesce updates anyway.
+const subscriberQueueSize = 8
+
+// Fanout shares subscriptions to channels among monitors, so that a channel watched by many clients
+// with the same pvRequest is only subscribed to once, and each update is fanned out to every client.
+//
+// The zero value is ready to use.
+type Fanout struct {
+	mu     sync.Mutex
+	shared map[string]*shared
+}
+
+// shared is one subscription to a channel, and the monitors it feeds.
+type shared struct {
+	key string
+	// ready is closed once the subscription has been created, or has failed with err.
+	ready chan struct{}
+	// ctx is the context the subscription is watched with; cancel cancels it once there are no subscribers.
+	ctx    context.Context
+	cancel func()
+
+	mu   sync.Mutex
+	subs map[*subscriber]struct{}
+	// last is the latest value, sent first to new subscribers.
+	last    interface{}
+	hasLast bool
+	// done is closed when the subscription fails with err.
+	done chan struct{}
+	err  error
+}
+
+// subscriber is the Nexter for one monitor of a shared subscription.
+type subscriber struct {
+	f  *Fanout
+	sh *shared
+
+	mu     sync.Mutex
+	queue  []interface{}
+	signal chan struct{}
+}
+
+// Subscribe returns a Nexter that returns the updates from the subscription identified by key,
+// calling create to subscribe if there is no subscription with that key yet.
+// The subscription created is watched with a context derived from ctx that is only cancelled
+// once every Nexter returned for the key has been abandoned, by cancelling the context passed to its Next.
+func (f *Fanout) Subscribe(ctx context.Context, key string, create func(ctx context.Context) (types.Nexter, error)) (types.Nexter, error) {
+	f.mu.Lock()
+	if f.shared == nil {
+		f.shared = make(map[string]*shared)
+	}
+	sh, ok := f.shared[key]
+	if !ok {
+		sctx, cancel := context.WithCancel(ctxlog.Detach(ctx))
+		sh = &shared{
+			key:    key,
+			ready:  make(chan struct{}),
+			ctx:    sctx,
+			cancel: cancel,
+			subs:   make(map[*subscriber]struct{}),
+			done:   make(chan struct{}),
+		}
+		f.shared[key] = sh
+	}
+	sub := &subscriber{f: f, sh: sh, signal: make(chan struct{}, 1)}
+	sh.mu.Lock()
+	sh.subs[sub] = struct{}{}
+	if sh.hasLast {
+		sub.push(sh.last)
+	}
+	sh.mu.Unlock()
+	f.mu.Unlock()
+
+	if ok {
+		select {
+		case <-sh.ready:
+		case <-ctx.Done():
+			sub.unsubscribe()
+			return nil, ctx.Err()
+		}
+		if err := sh.failed(); err != nil {
+			sub.unsubscribe()
+			return nil, err
+		}
+		return sub, nil
+	}
+	nexter, err := create(sh.ctx)
+	if err != nil {
+		sh.cancel()
+		sh.fail(f, err)
+		close(sh.ready)
+		return nil, err
+	}
+	close(sh.ready)
+	go sh.run(sh.ctx, f, nexter)
+	return sub, nil
+}
+
+// run fans out the updates from nexter until it fails or ctx is cancelled.
+func (sh *shared) run(ctx context.Context, f *Fanout, nexter types.Nexter) {
+	for {
+		value, err := nexter.Next(ctx)
+		if err == nil {
+			err = ctx.Err()
+		}
+		if err != nil {
+			sh.fail(f, err)
+			return
+		}
+		sh.mu.Lock()
+		sh.last, sh.hasLast = value, true
+		for sub := range sh.subs {
+			sub.push(value)
+		}
+		sh.mu.Unlock()
+	}
+}
+
+// fail stops the subscription with err, so that it is created again for the next subscriber.
+func (sh *shared) fail(f *Fanout, err error) {
+	f.mu.Lock()
+	if f.shared[sh.key] == sh {
+		delete(f.shared, sh.key)
+	}
+	f.mu.Unlock()
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if sh.err == nil {
+		sh.err = err
+		close(sh.done)
+	}
+}
+
+func (sh *shared) failed() error {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return sh.err
+}
+
+// Subscribers returns the number of monitors sharing the subscription identified by key.
+func (f *Fanout) Subscribers(key string) int {
+	f.mu.Lock()
+	sh := f.shared[key]
+	f.mu.Unlock()
+	if sh == nil {
+		return 0
+	}
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return len(sh.subs)
+}
+
+// push queues value for the subscriber. sh.mu must be held.
+func (s *subscriber) push(value interface{}) {
+	s.mu.Lock()
+	if len(s.queue) == subscriberQueueSize {
+		s.queue = append(s.queue[:0], s.queue[1:]...)
+	}
+	s.queue = append(s.queue, value)
+	s.mu.Unlock()
+	select {
+	case s.signal <- struct{}{}:
+	default:
+	}
+}
+
+// Next returns the next update. Cancelling ctx unsubscribes.
+func (s *subscriber) Next(ctx context.Context) (interface{}, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			s.unsubscribe()
+			return nil, err
+		}
+		s.mu.Lock()
+		if len(s.queue) > 0 {
+			value := s.queue[0]
+			s.queue[0] = nil
+			s.queue = s.queue[1:]
+			s.mu.Unlock()
+			return value, nil
+		}
+		s.mu.Unlock()
+		select {
+		case <-s.signal:
+		case <-s.sh.done:
+			s.unsubscribe()
+			return nil, s.sh.failed()
+		case <-ctx.Done():
+			s.unsubscribe()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// unsubscribe removes the subscriber, and cancels the subscription if it was the last.
+func (s *subscriber) unsubscribe() {
+	f, sh := s.f, s.sh
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if _, ok := sh.subs[s]; !ok {
+		return
+	}
+	delete(sh.subs, s)
+	if len(sh.subs) > 0 {
+		return
+	}
+	if f.shared[sh.key] == sh {
+		delete(f.shared, sh.key)
+	}
+	sh.cancel()
+}
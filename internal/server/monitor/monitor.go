@@ -2,33 +2,96 @@ package monitor
 
 import (
 	"context"
+	"strconv"
 	"sync"
+	"time"
 
+	"github.com/Lexcelon/go-pvaccess/clock"
 	"github.com/Lexcelon/go-pvaccess/pvdata"
 	"github.com/Lexcelon/go-pvaccess/types"
 )
 
 type Monitor struct {
-	sendValue  func(interface{})
+	clock      clock.Clock
+	sendValue  func(interface{}) bool
 	mu         sync.Mutex
 	cancel     func()
 	pipeline   bool
 	running    bool
 	windowOpen int
 	toSend     interface{}
+	// sending is set while sendValue is running, without mu held.
+	sending    bool
+	filter     Filter
+	lastSent   time.Time
+	flushTimer clock.Timer
+	// lastActive is when the monitor was created or last sent an update, or was started, stopped, or acknowledged.
+	lastActive time.Time
 }
 
-func New(ctx context.Context, request pvdata.PVStructure, nexter types.Nexter, sendValue func(interface{})) *Monitor {
+// Filter limits the rate of updates sent for a monitor.
+type Filter struct {
+	// FlushInterval is the minimum time between updates. Values produced in between are coalesced,
+	// so that only the latest is sent once the interval has passed.
+	FlushInterval time.Duration
+	// DeadTime is how long after sending an update new values are discarded.
+	// Unlike values coalesced by FlushInterval, discarded values are never sent,
+	// so the client only sees the channel's next update after the dead time.
+	DeadTime time.Duration
+}
+
+// requestFilter returns filter, made stricter by the options of the pvRequest request:
+// record._options.rate is the maximum number of updates per second, and record._options.deadTime is a dead time in seconds.
+// Clients can't loosen the server's limits.
+func requestFilter(request pvdata.PVStructure, filter Filter) Filter {
+	if rate, ok := floatOption(request, "rate"); ok && rate > 0 {
+		if interval := time.Duration(float64(time.Second) / rate); interval > filter.FlushInterval {
+			filter.FlushInterval = interval
+		}
+	}
+	if seconds, ok := floatOption(request, "deadTime"); ok && seconds > 0 {
+		if deadTime := time.Duration(seconds * float64(time.Second)); deadTime > filter.DeadTime {
+			filter.DeadTime = deadTime
+		}
+	}
+	return filter
+}
+
+// floatOption returns the numeric record option name. Options are usually strings, as parsed from "record[rate=10]".
+func floatOption(request pvdata.PVStructure, name string) (float64, bool) {
+	field := request.SubField("record", "_options", name)
+	if x, ok := pvdata.FloatValue(field); ok {
+		return x, true
+	}
+	if s, ok := field.(*pvdata.PVString); ok {
+		x, err := strconv.ParseFloat(string(*s), 64)
+		return x, err == nil
+	}
+	return 0, false
+}
+
+// retryInterval is how long a monitor waits before trying again to send a value that sendValue refused.
+const retryInterval = 100 * time.Millisecond
+
+// New starts watching nexter, and calls sendValue with each value that should be sent to the client.
+// Updates are limited by filter, and by the options of request (see requestFilter), as measured by clk;
+// nil selects the system clock.
+// If sendValue returns false, the value wasn't sent, and the latest value is sent after retryInterval.
+func New(ctx context.Context, clk clock.Clock, request pvdata.PVStructure, nexter types.Nexter, filter Filter, sendValue func(interface{}) bool) *Monitor {
 	var pipeline bool
 	field := request.SubField("record", "_options", "pipeline")
 	if field, ok := pvdata.BoolValue(field); ok {
 		pipeline = field
 	}
 	ctx, cancel := context.WithCancel(ctx)
+	clk = clock.Or(clk)
 	m := &Monitor{
-		pipeline:  pipeline,
-		sendValue: sendValue,
-		cancel:    cancel,
+		clock:      clk,
+		pipeline:   pipeline,
+		sendValue:  sendValue,
+		cancel:     cancel,
+		filter:     requestFilter(request, filter),
+		lastActive: clk.Now(),
 	}
 	go m.Watch(ctx, nexter)
 	return m
@@ -36,14 +99,15 @@ func New(ctx context.Context, request pvdata.PVStructure, nexter types.Nexter, s
 
 func (m *Monitor) Watch(ctx context.Context, nexter types.Nexter) error {
 	for {
-		// Check if context is canceled in case Next doesn't use context.
-		if err := ctx.Err(); err != nil {
-			return err
-		}
+		// Next is called even if ctx is already cancelled, since that is how nexter learns it has been abandoned.
 		value, err := nexter.Next(ctx)
 		if err != nil {
 			return err
 		}
+		// Check if context is canceled in case Next doesn't use context.
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		m.Send(ctx, value)
 	}
 }
@@ -52,6 +116,7 @@ func (m *Monitor) Start(ctx context.Context) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.running = true
+	m.lastActive = m.clock.Now()
 	m.drain()
 }
 
@@ -59,6 +124,7 @@ func (m *Monitor) Stop(ctx context.Context) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.running = false
+	m.lastActive = m.clock.Now()
 	m.drain()
 }
 
@@ -66,22 +132,70 @@ func (m *Monitor) Ack(ctx context.Context, nfree int) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.windowOpen += nfree
+	m.lastActive = m.clock.Now()
 	m.drain()
 }
 
+// drain sends the latest value, if the monitor can send it now. m.mu must be held.
+// m.mu is released while sendValue runs, so that sending can't deadlock with callers that hold other locks, and
+// sending is set in the meantime so that values are still sent one at a time, in order.
 func (m *Monitor) drain() {
-	if m.running && (!m.pipeline || m.windowOpen > 0) && m.toSend != nil {
+	for !m.sending && m.running && (!m.pipeline || m.windowOpen > 0) && m.toSend != nil {
+		if m.filter.FlushInterval > 0 {
+			if wait := m.lastSent.Add(m.filter.FlushInterval).Sub(m.clock.Now()); wait > 0 {
+				if m.flushTimer == nil {
+					m.flushTimer = m.clock.AfterFunc(wait, m.flush)
+				}
+				return
+			}
+		}
+		now := m.clock.Now()
+		value := m.toSend
+		m.toSend = nil
+		m.sending = true
+		m.mu.Unlock()
+		sent := m.sendValue(value)
+		m.mu.Lock()
+		m.sending = false
+		if !sent {
+			// A value that arrived while sending replaces the one that was refused.
+			if m.toSend == nil {
+				m.toSend = value
+			}
+			if m.flushTimer == nil && m.running {
+				m.flushTimer = m.clock.AfterFunc(retryInterval, m.flush)
+			}
+			return
+		}
+		m.lastSent, m.lastActive = now, now
 		if m.windowOpen > 0 {
 			m.windowOpen--
 		}
-		m.sendValue(m.toSend)
-		m.toSend = nil
 	}
 }
 
+// LastActive returns when the monitor last sent an update, or was started, stopped, or acknowledged by the client,
+// or when it was created if none of those has happened.
+func (m *Monitor) LastActive() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lastActive
+}
+
+// flush sends the latest value once the flush interval has passed, or when it is time to try again.
+func (m *Monitor) flush() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.flushTimer = nil
+	m.drain()
+}
+
 func (m *Monitor) Send(ctx context.Context, value interface{}) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	if m.filter.DeadTime > 0 && !m.lastSent.IsZero() && m.clock.Now().Sub(m.lastSent) < m.filter.DeadTime {
+		return
+	}
 	m.toSend = value
 	m.drain()
 }
@@ -90,6 +204,11 @@ func (m *Monitor) Terminate(ctx context.Context) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.cancel()
+	if m.flushTimer != nil {
+		m.flushTimer.Stop()
+		m.flushTimer = nil
+	}
+	m.running = false
 	// TODO: Send final update to client
 	return nil
 }
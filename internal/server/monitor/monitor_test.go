@@ -0,0 +1,257 @@
+package monitor
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/Lexcelon/go-pvaccess/types"
+)
+
+// chanNexter returns the values sent on a channel.
+type chanNexter chan interface{}
+
+func (n chanNexter) Next(ctx context.Context) (interface{}, error) {
+	select {
+	case v := <-n:
+		return v, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestFlushInterval(t *testing.T) {
+	ctx := context.Background()
+	values := make(chanNexter)
+	var mu sync.Mutex
+	var sent []interface{}
+	req, err := pvdata.NewPVStructure(&struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := New(ctx, nil, req, values, Filter{FlushInterval: 50 * time.Millisecond}, func(v interface{}) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, v)
+		return true
+	})
+	defer m.Terminate(ctx)
+	m.Start(ctx)
+	for i := 0; i < 100; i++ {
+		values <- i
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := len(sent)
+		last := interface{}(nil)
+		if n > 0 {
+			last = sent[n-1]
+		}
+		mu.Unlock()
+		if last == 99 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("last value was never sent; sent %d values", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if sent[0] != 0 {
+		t.Errorf("first value sent was %v, want 0", sent[0])
+	}
+	if len(sent) >= 100 {
+		t.Errorf("sent %d updates for 100 values, want them coalesced", len(sent))
+	}
+}
+
+func TestDeadTime(t *testing.T) {
+	ctx := context.Background()
+	values := make(chanNexter)
+	sent := make(chan interface{}, 100)
+	req, err := pvdata.NewPVStructure(&struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := New(ctx, nil, req, values, Filter{DeadTime: 200 * time.Millisecond}, func(v interface{}) bool {
+		sent <- v
+		return true
+	})
+	defer m.Terminate(ctx)
+	m.Start(ctx)
+	for i := 0; i < 10; i++ {
+		values <- i
+	}
+	if v := <-sent; v != 0 {
+		t.Errorf("first value sent was %v, want 0", v)
+	}
+	time.Sleep(250 * time.Millisecond)
+	values <- 10
+	if v := <-sent; v != 10 {
+		t.Errorf("value sent after the dead time was %v, want 10", v)
+	}
+}
+
+func TestRefused(t *testing.T) {
+	ctx := context.Background()
+	values := make(chanNexter)
+	sent := make(chan interface{}, 100)
+	var mu sync.Mutex
+	refuse := true
+	req, err := pvdata.NewPVStructure(&struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := New(ctx, nil, req, values, Filter{}, func(v interface{}) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if refuse {
+			return false
+		}
+		sent <- v
+		return true
+	})
+	defer m.Terminate(ctx)
+	m.Start(ctx)
+	for i := 0; i < 10; i++ {
+		values <- i
+	}
+	mu.Lock()
+	refuse = false
+	mu.Unlock()
+	select {
+	case v := <-sent:
+		if v != 9 {
+			t.Errorf("value sent once accepted was %v, want the latest", v)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("refused value was never sent again")
+	}
+	select {
+	case v := <-sent:
+		t.Errorf("sent %v after the latest value", v)
+	case <-time.After(2 * retryInterval):
+	}
+}
+
+// TestTerminateWhileSending checks that a monitor can be terminated by a caller holding a lock that sendValue needs.
+func TestTerminateWhileSending(t *testing.T) {
+	ctx := context.Background()
+	values := make(chanNexter)
+	sending := make(chan struct{})
+	var outer sync.Mutex
+	req, err := pvdata.NewPVStructure(&struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := New(ctx, nil, req, values, Filter{}, func(v interface{}) bool {
+		close(sending)
+		outer.Lock()
+		defer outer.Unlock()
+		return true
+	})
+	m.Start(ctx)
+	outer.Lock()
+	values <- 1
+	<-sending
+	done := make(chan struct{})
+	go func() {
+		m.Terminate(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Terminate blocked while the monitor was sending")
+	}
+	outer.Unlock()
+}
+
+func TestRequestFilter(t *testing.T) {
+	var req struct {
+		Record struct {
+			Options struct {
+				Rate     pvdata.PVString `pvaccess:"rate"`
+				DeadTime pvdata.PVDouble `pvaccess:"deadTime"`
+			} `pvaccess:"_options"`
+		} `pvaccess:"record"`
+	}
+	req.Record.Options.Rate = "10"
+	req.Record.Options.DeadTime = 0.5
+	pvs, err := pvdata.NewPVStructure(&req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		server, want Filter
+	}{
+		{Filter{}, Filter{FlushInterval: 100 * time.Millisecond, DeadTime: 500 * time.Millisecond}},
+		{Filter{FlushInterval: time.Second, DeadTime: time.Second}, Filter{FlushInterval: time.Second, DeadTime: time.Second}},
+	} {
+		if got := requestFilter(pvs, test.server); got != test.want {
+			t.Errorf("requestFilter(%+v) = %+v, want %+v", test.server, got, test.want)
+		}
+	}
+}
+
+func TestFanout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var f Fanout
+	values := make(chanNexter)
+	created := 0
+	var providerCtx context.Context
+	create := func(ctx context.Context) (types.Nexter, error) {
+		created++
+		providerCtx = ctx
+		return values, nil
+	}
+	firstCtx, unsubFirst := context.WithCancel(ctx)
+	first, err := f.Subscribe(firstCtx, "a", create)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values <- 1
+	if v, err := first.Next(ctx); err != nil || v != 1 {
+		t.Fatalf("first Next = %v, %v, want 1", v, err)
+	}
+	// A second subscriber shares the subscription, and gets the latest value first.
+	second, err := f.Subscribe(ctx, "a", create)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := second.Next(ctx); err != nil || v != 1 {
+		t.Fatalf("second Next = %v, %v, want 1", v, err)
+	}
+	values <- 2
+	for i, n := range []types.Nexter{first, second} {
+		if v, err := n.Next(ctx); err != nil || v != 2 {
+			t.Errorf("subscriber %d: Next = %v, %v, want 2", i, v, err)
+		}
+	}
+	if created != 1 || f.Subscribers("a") != 2 {
+		t.Errorf("created %d subscriptions for %d subscribers, want 1 for 2", created, f.Subscribers("a"))
+	}
+
+	// The subscription outlives the subscriber that created it, and ends with the last.
+	unsubFirst()
+	if _, err := first.Next(firstCtx); err == nil {
+		t.Error("Next succeeded after unsubscribing")
+	}
+	if providerCtx.Err() != nil {
+		t.Error("subscription cancelled with subscribers left")
+	}
+	secondCtx, unsubSecond := context.WithCancel(ctx)
+	unsubSecond()
+	second.Next(secondCtx)
+	if providerCtx.Err() == nil {
+		t.Error("subscription not cancelled after last subscriber left")
+	}
+	if n := f.Subscribers("a"); n != 0 {
+		t.Errorf("%d subscribers left", n)
+	}
+}
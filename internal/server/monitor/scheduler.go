@@ -0,0 +1,131 @@
+package monitor
+
+import (
+	"container/heap"
+	"context"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/clock"
+)
+
+// Scheduler orders the monitor updates sent by a server's connections by connection priority.
+// At most Limit updates are sent at once; when more are waiting, updates for higher-priority
+// connections are sent first, and updates with the same priority in the order they arrived.
+//
+// An update that has waited MaxWait is sent regardless, so that clients that are slow to read
+// can't hold up updates to everyone else indefinitely.
+//
+// The zero value is ready to use.
+type Scheduler struct {
+	// Limit is the number of updates that may be sent at once. Zero selects GOMAXPROCS.
+	Limit int
+	// MaxWait is the longest an update waits for its turn. Zero selects a default of 10 milliseconds.
+	MaxWait time.Duration
+
+	mu      sync.Mutex
+	active  int
+	waiting waiters
+	seq     uint64
+}
+
+const defaultMaxWait = 10 * time.Millisecond
+
+type waiter struct {
+	priority int
+	seq      uint64
+	index    int
+	granted  bool
+	ready    chan struct{}
+}
+
+// waiters is a heap of waiters, highest priority first.
+type waiters []*waiter
+
+func (w waiters) Len() int { return len(w) }
+func (w waiters) Less(i, j int) bool {
+	if w[i].priority != w[j].priority {
+		return w[i].priority > w[j].priority
+	}
+	return w[i].seq < w[j].seq
+}
+func (w waiters) Swap(i, j int) {
+	w[i], w[j] = w[j], w[i]
+	w[i].index = i
+	w[j].index = j
+}
+func (w *waiters) Push(x interface{}) {
+	wt := x.(*waiter)
+	wt.index = len(*w)
+	*w = append(*w, wt)
+}
+func (w *waiters) Pop() interface{} {
+	old := *w
+	wt := old[len(old)-1]
+	old[len(old)-1] = nil
+	*w = old[:len(old)-1]
+	return wt
+}
+
+func (s *Scheduler) limit() int {
+	if s.Limit > 0 {
+		return s.Limit
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// Acquire waits for the turn of an update with the given priority, measuring MaxWait with clk (nil selects the system clock),
+// and returns a function that must be called once the update has been sent.
+func (s *Scheduler) Acquire(ctx context.Context, clk clock.Clock, priority int) (release func()) {
+	s.mu.Lock()
+	if s.active < s.limit() && len(s.waiting) == 0 {
+		s.active++
+		s.mu.Unlock()
+		return s.release
+	}
+	s.seq++
+	w := &waiter{priority: priority, seq: s.seq, ready: make(chan struct{})}
+	heap.Push(&s.waiting, w)
+	s.mu.Unlock()
+
+	maxWait := s.MaxWait
+	if maxWait <= 0 {
+		maxWait = defaultMaxWait
+	}
+	timer := clock.Or(clk).NewTimer(maxWait)
+	defer timer.Stop()
+	select {
+	case <-w.ready:
+		return s.release
+	case <-timer.C():
+	case <-ctx.Done():
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w.granted {
+		return s.release
+	}
+	heap.Remove(&s.waiting, w.index)
+	// Send without taking a slot.
+	return func() {}
+}
+
+func (s *Scheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active--
+	for s.active < s.limit() && len(s.waiting) > 0 {
+		w := heap.Pop(&s.waiting).(*waiter)
+		w.granted = true
+		s.active++
+		close(w.ready)
+	}
+}
+
+// Waiting returns the number of updates waiting for their turn.
+func (s *Scheduler) Waiting() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.waiting)
+}
@@ -0,0 +1,59 @@
+package monitor
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSchedulerPriority(t *testing.T) {
+	ctx := context.Background()
+	s := &Scheduler{Limit: 1, MaxWait: time.Minute}
+	release := s.Acquire(ctx, nil, 0)
+
+	order := make(chan int, 3)
+	for i, priority := range []int{10, 50, 10} {
+		go func(priority int) {
+			defer s.Acquire(ctx, nil, priority)()
+			order <- priority
+		}(priority)
+		// Wait for the update to be queued, so that updates with the same priority keep their order.
+		for {
+			s.mu.Lock()
+			n := len(s.waiting)
+			s.mu.Unlock()
+			if n == i+1 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	release()
+	for i, want := range []int{50, 10, 10} {
+		if got := <-order; got != want {
+			t.Errorf("update %d had priority %d, want %d", i, got, want)
+		}
+	}
+}
+
+func TestSchedulerMaxWait(t *testing.T) {
+	ctx := context.Background()
+	s := &Scheduler{Limit: 1, MaxWait: 10 * time.Millisecond}
+	defer s.Acquire(ctx, nil, 0)()
+
+	done := make(chan struct{})
+	go func() {
+		s.Acquire(ctx, nil, 0)()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("update waited for a slot that was never released")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active != 1 || len(s.waiting) != 0 {
+		t.Errorf("after timeout: %d active, %d waiting; want 1 and 0", s.active, len(s.waiting))
+	}
+}
@@ -0,0 +1,124 @@
+package status
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/sirupsen/logrus"
+)
+
+// startTime is when the process started, approximately.
+var startTime = time.Now()
+
+// diagnosticOps are the privileged ops, which are only allowed for clients that Channel.Privileged accepts.
+var diagnosticOps = map[pvdata.PVString]func(ctx context.Context, args pvdata.PVStructure) (interface{}, error){
+	"runtime":    runtimeStats,
+	"goroutines": goroutineDump,
+	"heap":       heapProfile,
+	"debug":      setDebug,
+}
+
+type ntScalar struct {
+	Value interface{} `pvaccess:"value"`
+}
+
+func (ntScalar) TypeID() string {
+	return "epics:nt/NTScalar:1.0"
+}
+
+// diagnostic runs the privileged op.
+func (c *Channel) diagnostic(ctx context.Context, op pvdata.PVString, args pvdata.PVStructure) (interface{}, error) {
+	if c.Privileged == nil || !c.Privileged(ctx) {
+		ctxlog.L(ctx).Warnf("rejected unprivileged request for op %q", op)
+		return &struct{}{}, pvdata.PVStatus{
+			Type:    pvdata.PVStatus_ERROR,
+			Message: pvdata.PVString(fmt.Sprintf("not allowed to use op %q", op)),
+		}
+	}
+	ctxlog.L(ctx).Infof("running diagnostic op %q", op)
+	return diagnosticOps[op](ctx, args)
+}
+
+func runtimeStats(ctx context.Context, args pvdata.PVStructure) (interface{}, error) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return &struct {
+		Uptime       float64 `pvaccess:"uptime"`
+		Goroutines   int64   `pvaccess:"goroutines"`
+		GOMAXPROCS   int64   `pvaccess:"gomaxprocs"`
+		HeapAlloc    uint64  `pvaccess:"heapAlloc"`
+		HeapSys      uint64  `pvaccess:"heapSys"`
+		HeapObjects  uint64  `pvaccess:"heapObjects"`
+		TotalAlloc   uint64  `pvaccess:"totalAlloc"`
+		NumGC        uint32  `pvaccess:"numGC"`
+		PauseTotalNs uint64  `pvaccess:"pauseTotalNs"`
+	}{
+		time.Since(startTime).Seconds(),
+		int64(runtime.NumGoroutine()),
+		int64(runtime.GOMAXPROCS(0)),
+		m.HeapAlloc,
+		m.HeapSys,
+		m.HeapObjects,
+		m.TotalAlloc,
+		m.NumGC,
+		m.PauseTotalNs,
+	}, nil
+}
+
+// goroutineDump returns the stacks of every goroutine, in the format of an unrecovered panic.
+func goroutineDump(ctx context.Context, args pvdata.PVStructure) (interface{}, error) {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 2); err != nil {
+		return &struct{}{}, err
+	}
+	return &ntScalar{buf.String()}, nil
+}
+
+// heapProfile returns a heap profile, which can be read by go tool pprof.
+func heapProfile(ctx context.Context, args pvdata.PVStructure) (interface{}, error) {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("heap").WriteTo(&buf, 0); err != nil {
+		return &struct{}{}, err
+	}
+	return &ntScalar{buf.Bytes()}, nil
+}
+
+// setDebug enables debug logging if the enable argument is true, or disables it if it is false.
+// It returns the log level, which is unchanged if enable is missing.
+func setDebug(ctx context.Context, args pvdata.PVStructure) (interface{}, error) {
+	logger := ctxlog.L(ctx).Logger
+	if enable, ok := args.ToMap()["enable"]; ok {
+		var on bool
+		switch enable := enable.(type) {
+		case bool:
+			on = enable
+		case string:
+			var err error
+			if on, err = strconv.ParseBool(enable); err != nil {
+				return &struct{}{}, pvdata.PVStatus{
+					Type:    pvdata.PVStatus_ERROR,
+					Message: pvdata.PVString(fmt.Sprintf("invalid argument (enable %q)", enable)),
+				}
+			}
+		default:
+			return &struct{}{}, pvdata.PVStatus{
+				Type:    pvdata.PVStatus_ERROR,
+				Message: pvdata.PVString(fmt.Sprintf("invalid argument (enable is %T)", enable)),
+			}
+		}
+		level := logrus.InfoLevel
+		if on {
+			level = logrus.DebugLevel
+		}
+		logger.SetLevel(level)
+		ctxlog.L(ctx).Infof("log level set to %v", level)
+	}
+	return &ntScalar{logger.GetLevel().String()}, nil
+}
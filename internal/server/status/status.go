@@ -5,7 +5,7 @@ import (
 	"fmt"
 	"os"
 	"runtime"
-	"strings"
+	"sort"
 
 	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
 	"github.com/Lexcelon/go-pvaccess/pvdata"
@@ -16,8 +16,16 @@ type ChannelProviderser interface {
 	ChannelProviders() []types.ChannelProvider
 }
 
+// GroupPuter is implemented by servers that support the groupPut op.
+type GroupPuter interface {
+	GroupPut(ctx context.Context, values map[string]pvdata.PVStructure) error
+}
+
 type Channel struct {
 	Server ChannelProviderser
+	// Privileged reports whether the client in ctx may use the diagnostic ops, which reveal the server's internals
+	// and change its logging. If it is nil, no client may.
+	Privileged func(ctx context.Context) bool
 }
 
 func (Channel) Name() string {
@@ -40,7 +48,7 @@ func (NTScalarArray) TypeID() string {
 }
 
 func (c *Channel) ChannelRPC(ctx context.Context, args pvdata.PVStructure) (interface{}, error) {
-	if strings.HasPrefix(args.ID, "epics:nt/NTURI:1.") {
+	if pvdata.TypeIDCompatible(args.ID, "epics:nt/NTURI:1.0") {
 		if q, ok := args.Field("query").(pvdata.PVStructure); ok {
 			args = q
 		} else {
@@ -62,6 +70,9 @@ func (c *Channel) ChannelRPC(ctx context.Context, args pvdata.PVStructure) (inte
 
 	ctxlog.L(ctx).Debugf("op = %s", op)
 
+	if _, ok := diagnosticOps[op]; ok {
+		return c.diagnostic(ctx, op, args)
+	}
 	switch op {
 	case "channels":
 		resp := &NTScalarArray{}
@@ -77,6 +88,8 @@ func (c *Channel) ChannelRPC(ctx context.Context, args pvdata.PVStructure) (inte
 			}
 		}
 		return resp, nil
+	case "aliases":
+		return channelAliases(ctx, c.Server.ChannelProviders()), nil
 	case "info":
 		hostname, _ := os.Hostname()
 		info := &struct {
@@ -98,6 +111,22 @@ func (c *Channel) ChannelRPC(ctx context.Context, args pvdata.PVStructure) (inte
 		}
 		ctxlog.L(ctx).Debugf("returning info %+v", info)
 		return info, nil
+	case "groupPut":
+		gp, ok := c.Server.(GroupPuter)
+		if !ok {
+			break
+		}
+		values, err := groupPutValues(args)
+		if err != nil {
+			return &struct{}{}, pvdata.PVStatus{
+				Type:    pvdata.PVStatus_ERROR,
+				Message: pvdata.PVString(fmt.Sprintf("invalid argument (%v)", err)),
+			}
+		}
+		if err := gp.GroupPut(ctx, values); err != nil {
+			return &struct{}{}, err
+		}
+		return &struct{}{}, nil
 	}
 
 	return &struct{}{}, pvdata.PVStatus{
@@ -105,3 +134,67 @@ func (c *Channel) ChannelRPC(ctx context.Context, args pvdata.PVStructure) (inte
 		Message: pvdata.PVString(fmt.Sprintf("invalid argument (unknown op %q)", op)),
 	}
 }
+
+// aliasTable lists channel aliases, with the canonical name of the channel of each alias.
+type aliasTable struct {
+	Alias   []string `pvaccess:"alias"`
+	Channel []string `pvaccess:"channel"`
+}
+
+// channelAliases returns the aliases of the providers' channels, sorted by alias.
+func channelAliases(ctx context.Context, providers []types.ChannelProvider) *aliasTable {
+	canonical := make(map[string]string)
+	for _, p := range providers {
+		if p, ok := p.(types.ChannelAliaser); ok {
+			aliases, err := p.ChannelAliases(ctx)
+			if err != nil {
+				ctxlog.L(ctx).Errorf("failed to list aliases on %v", p)
+				continue
+			}
+			for alias, name := range aliases {
+				if _, ok := canonical[alias]; !ok {
+					canonical[alias] = name
+				}
+			}
+		}
+	}
+	resp := &aliasTable{Alias: []string{}, Channel: []string{}}
+	for alias := range canonical {
+		resp.Alias = append(resp.Alias, alias)
+	}
+	sort.Strings(resp.Alias)
+	for _, alias := range resp.Alias {
+		resp.Channel = append(resp.Channel, canonical[alias])
+	}
+	return resp
+}
+
+// groupPutValues returns the values of a groupPut op.
+// The names of the channels are in the channels field, and the value for channels[i] is the field "v<i>" of the values structure.
+func groupPutValues(args pvdata.PVStructure) (map[string]pvdata.PVStructure, error) {
+	names, ok := args.ToMap()["channels"].([]string)
+	if !ok {
+		return nil, fmt.Errorf("missing channels")
+	}
+	var fields pvdata.PVStructure
+	switch v := args.Field("values").(type) {
+	case pvdata.PVStructure:
+		fields = v
+	case *pvdata.PVStructure:
+		fields = *v
+	default:
+		return nil, fmt.Errorf("missing values")
+	}
+	values := make(map[string]pvdata.PVStructure, len(names))
+	for i, name := range names {
+		switch v := fields.Field(fmt.Sprintf("v%d", i)).(type) {
+		case pvdata.PVStructure:
+			values[name] = v
+		case *pvdata.PVStructure:
+			values[name] = *v
+		default:
+			return nil, fmt.Errorf("missing value for channel %q", name)
+		}
+	}
+	return values, nil
+}
@@ -15,8 +15,13 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
+// mcastIP is the local multicast group, which every server on the host joins on the loopback interface,
+// so that searches sent to the shared search port can be forwarded to all of them.
 var mcastIP = net.IP{224, 0, 0, 128}
 
+// loopbackIP is the interface that the local multicast group is joined and sent on.
+var loopbackIP = [4]byte{127, 0, 0, 1}
+
 // TODO: EPICS_PVA_BROADCAST_PORT environment variable
 const udpPort = 5076
 
@@ -58,19 +63,29 @@ type Listener struct {
 	g                      errgroup.Group
 }
 
-func Listen(ctx context.Context) (*Listener, error) {
+// Listen opens the sockets. If sendConn is non-nil, it is sent from instead of a new socket on a random port,
+// and it is left open by Close, so that it can be shared, e.g. with a client's searches.
+func Listen(ctx context.Context, sendConn *net.UDPConn) (*Listener, error) {
 	ctxlog.L(ctx).Infof("udpconn Listen")
-	sendConn, err := net.ListenUDP("udp", &net.UDPAddr{})
-	if err != nil {
-		ctxlog.L(ctx).Errorf("Err %v", err)
-		return nil, err
-	}
 	ch := make(chan *Conn)
 	ln := &Listener{
 		sendConn: sendConn,
-		lns:      []*net.UDPConn{sendConn},
 		connCh:   ch,
 	}
+	if sendConn == nil {
+		var err error
+		sendConn, err = net.ListenUDP("udp", &net.UDPAddr{})
+		if err != nil {
+			ctxlog.L(ctx).Errorf("Err %v", err)
+			return nil, err
+		}
+		ln.sendConn = sendConn
+		ln.lns = []*net.UDPConn{sendConn}
+	}
+	if err := setMulticastLoopback(sendConn); err != nil {
+		// Forwarded searches go out on the default interface instead, where the ORIGIN_TAG still stops other hosts from answering them.
+		ctxlog.L(ctx).Warnf("can't send to the local multicast group on the loopback interface: %v", err)
+	}
 	if err := ln.bindInterfaces(ctx); err != nil {
 		ln.Close()
 		ctxlog.L(ctx).Errorf("bind Interfaces Err %v", err)
@@ -89,6 +104,25 @@ func Listen(ctx context.Context) (*Listener, error) {
 	return ln, nil
 }
 
+// setMulticastLoopback makes conn send multicast packets on the loopback interface, so that they stay on the host,
+// and deliver them to its own host.
+func setMulticastLoopback(conn *net.UDPConn) error {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	if err := rawConn.Control(func(fd uintptr) {
+		if serr = syscall.SetsockoptInet4Addr(int(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_IF, loopbackIP); serr != nil {
+			return
+		}
+		serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_LOOP, 1)
+	}); err != nil {
+		return err
+	}
+	return serr
+}
+
 func (ln *Listener) LocalAddr() *net.UDPAddr {
 	return ln.sendConn.LocalAddr().(*net.UDPAddr)
 }
@@ -243,7 +277,7 @@ func (ln *Listener) bindMulticast(ctx context.Context) error {
 	if err := rawConn.Control(func(fd uintptr) {
 		if err := syscall.SetsockoptIPMreq(int(fd), syscall.IPPROTO_IP, syscall.IP_ADD_MEMBERSHIP, &syscall.IPMreq{
 			Multiaddr: [4]byte{224, 0, 0, 128},
-			Interface: [4]byte{0, 0, 0, 0},
+			Interface: loopbackIP,
 		}); err != nil {
 			cerr = err
 		}
@@ -309,6 +343,7 @@ func (ln *Listener) BroadcastConn() *Conn {
 	}
 }
 
+// WriteMulticast sends p to the local multicast group, which only servers on this host receive.
 func (ln *Listener) WriteMulticast(p []byte) (int, error) {
 	return ln.sendConn.WriteToUDP(p, &net.UDPAddr{
 		IP:   mcastIP,
@@ -0,0 +1,243 @@
+package pvaccess
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/Lexcelon/go-pvaccess/types"
+)
+
+// LocalChannel is a channel created directly on a Server's channel providers,
+// for clients running in the same process as the server (e.g. gateways).
+//
+// Operations on a LocalChannel bypass the network and serialization entirely:
+// values are passed between the provider and the caller as Go values.
+// Values returned by the provider may be shared with it, and must not be modified.
+// Every operation still goes through the server's interceptors, access controllers, and validators.
+type LocalChannel struct {
+	srv     *Server
+	channel Channel
+	peer    *Peer
+	// rights are the caller's access rights, decided when the channel was created.
+	rights AccessRights
+
+	closeOnce sync.Once
+}
+
+// localPeer identifies operations performed through a LocalChannel to interceptors,
+// unless the caller's context already carries a Peer.
+var localPeer = &Peer{AuthNZ: "local"}
+
+// LocalChannel creates the channel name on the server's channel providers.
+// It returns nil if no provider has the channel.
+func (srv *Server) LocalChannel(ctx context.Context, name string) (*LocalChannel, error) {
+	peer, ok := PeerFromContext(ctx)
+	if !ok {
+		peer = localPeer
+	}
+	ctx = types.WithPeer(ctx, peer)
+	result, err := srv.intercept(ctx, &Op{
+		Kind:        OpCreateChannel,
+		ChannelName: name,
+		Peer:        peer,
+	}, func(ctx context.Context, op *Op) (interface{}, error) {
+		return srv.findChannel(ctx, op.ChannelName)
+	})
+	if err != nil {
+		return nil, err
+	}
+	channel, _ := result.(Channel)
+	if channel == nil {
+		return nil, nil
+	}
+	srv.channelOpened(ctx, channel)
+	return &LocalChannel{srv: srv, channel: channel, peer: peer, rights: srv.accessRights(ctx, channel)}, nil
+}
+
+// Close releases the channel. It must be called once the channel is no longer needed.
+func (lc *LocalChannel) Close() error {
+	lc.closeOnce.Do(func() {
+		lc.srv.channelClosed(types.WithPeer(context.Background(), lc.peer), lc.channel)
+	})
+	return nil
+}
+
+// Name returns the name of the channel.
+func (lc *LocalChannel) Name() string {
+	return lc.channel.Name()
+}
+
+func (lc *LocalChannel) newOp(kind OpKind, init bool, args pvdata.PVStructure) *Op {
+	return &Op{
+		Kind:        kind,
+		Init:        init,
+		ChannelName: lc.channel.Name(),
+		Channel:     lc.channel,
+		Peer:        lc.peer,
+		Args:        args,
+	}
+}
+
+// Get reads the channel's current value. req is the pvRequest structure, which may be empty.
+func (lc *LocalChannel) Get(ctx context.Context, req pvdata.PVStructure) (pvdata.PVStructure, error) {
+	ctx = types.WithPeer(ctx, lc.peer)
+	channel := lc.channel
+	if err := checkRead(lc.rights, channel); err != nil {
+		return pvdata.PVStructure{}, err
+	}
+	result, err := lc.srv.intercept(ctx, lc.newOp(OpGet, true, req), func(ctx context.Context, op *Op) (interface{}, error) {
+		if getc, ok := channel.(ChannelGetCreator); ok {
+			return getc.CreateChannelGet(ctx, op.Args)
+		} else if g, ok := channel.(ChannelGeter); ok {
+			return g, nil
+		}
+		return nil, fmt.Errorf("channel %q does not support Get", channel.Name())
+	})
+	if err != nil {
+		return pvdata.PVStructure{}, err
+	}
+	geter, ok := result.(ChannelGeter)
+	if !ok {
+		return pvdata.PVStructure{}, fmt.Errorf("channel %q does not support Get", channel.Name())
+	}
+	value, err := lc.srv.intercept(ctx, lc.newOp(OpGet, false, pvdata.PVStructure{}), func(ctx context.Context, op *Op) (interface{}, error) {
+		return geter.ChannelGet(ctx)
+	})
+	if err != nil {
+		return pvdata.PVStructure{}, err
+	}
+	return pvdata.NewPVStructure(value)
+}
+
+// RPC calls the channel's RPC service with args. req is the pvRequest structure, which may be empty.
+func (lc *LocalChannel) RPC(ctx context.Context, req, args pvdata.PVStructure) (pvdata.PVStructure, error) {
+	ctx = types.WithPeer(ctx, lc.peer)
+	channel := lc.channel
+	if err := checkRead(lc.rights, channel); err != nil {
+		return pvdata.PVStructure{}, err
+	}
+	result, err := lc.srv.intercept(ctx, lc.newOp(OpRPC, true, req), func(ctx context.Context, op *Op) (interface{}, error) {
+		if rpcc, ok := channel.(ChannelRPCCreator); ok {
+			return rpcc.CreateChannelRPC(ctx, op.Args)
+		} else if r, ok := channel.(ChannelRPCer); ok {
+			return r, nil
+		}
+		return nil, fmt.Errorf("channel %q does not support RPC", channel.Name())
+	})
+	if err != nil {
+		return pvdata.PVStructure{}, err
+	}
+	rpcer, ok := result.(ChannelRPCer)
+	if !ok {
+		return pvdata.PVStructure{}, fmt.Errorf("channel %q does not support RPC", channel.Name())
+	}
+	value, err := lc.srv.intercept(ctx, lc.newOp(OpRPC, false, args), func(ctx context.Context, op *Op) (interface{}, error) {
+		return rpcer.ChannelRPC(ctx, op.Args)
+	})
+	if err != nil {
+		return pvdata.PVStructure{}, err
+	}
+	return pvdata.NewPVStructure(value)
+}
+
+// Put puts value to the channel. req is the pvRequest structure, which may be empty.
+// changed names the fields of value to put, as for ChannelFieldPuter; if it is nil, the whole value is put.
+// Partial puts to channels that don't implement ChannelFieldPuter are merged into the channel's current value first.
+func (lc *LocalChannel) Put(ctx context.Context, req, value pvdata.PVStructure, changed []string) error {
+	ctx = types.WithPeer(ctx, lc.peer)
+	channel := lc.channel
+	if err := checkWrite(lc.rights, channel); err != nil {
+		return err
+	}
+	result, err := lc.srv.intercept(ctx, lc.newOp(OpPut, true, req), func(ctx context.Context, op *Op) (interface{}, error) {
+		if putc, ok := channel.(ChannelPutCreator); ok {
+			return putc.CreateChannelPut(ctx, op.Args)
+		} else if p, ok := channel.(ChannelPuter); ok {
+			return p, nil
+		}
+		return nil, fmt.Errorf("channel %q does not support Put", channel.Name())
+	})
+	if err != nil {
+		return err
+	}
+	puter, ok := result.(ChannelPuter)
+	if !ok {
+		return fmt.Errorf("channel %q does not support Put", channel.Name())
+	}
+	fp, isFieldPuter := puter.(ChannelFieldPuter)
+	if changed != nil && !isFieldPuter {
+		pr := &putRequest{puter: puter}
+		if g, ok := puter.(ChannelGeter); ok {
+			pr.geter = g
+		} else if g, ok := channel.(ChannelGeter); ok {
+			pr.geter = g
+		}
+		if pr.geter == nil {
+			return fmt.Errorf("channel %q does not support partial puts", channel.Name())
+		}
+		if fder, ok := puter.(pvdata.FieldDescer); ok {
+			pr.fd, err = fder.FieldDesc()
+		} else {
+			pr.fd, err = getFieldDesc(ctx, pr.geter)
+		}
+		if err != nil {
+			return err
+		}
+		// Convert value to the put structure first, so that its fields may have other numeric types.
+		zero, err := pr.fd.Zero()
+		if err != nil {
+			return err
+		}
+		put, ok := zero.(pvdata.PVStructure)
+		if !ok {
+			return fmt.Errorf("put structure is %T, expected PVStructure", zero)
+		}
+		if err := put.SetFromMap(value.ToMap()); err != nil {
+			return fmt.Errorf("put value: %w", err)
+		}
+		if value, err = pr.merge(ctx, put, changed); err != nil {
+			return err
+		}
+		changed = nil
+	}
+	op := lc.newOp(OpPut, false, pvdata.PVStructure{})
+	op.Value = value
+	op.ChangedFields = changed
+	_, err = lc.srv.intercept(ctx, op, func(ctx context.Context, op *Op) (interface{}, error) {
+		if err := lc.srv.validatePut(ctx, channel, puter, op.Value, op.ChangedFields); err != nil {
+			return nil, err
+		}
+		if isFieldPuter && op.ChangedFields != nil {
+			return nil, fp.ChannelPutFields(ctx, op.Value, op.ChangedFields)
+		}
+		return nil, puter.ChannelPut(ctx, op.Value)
+	})
+	return err
+}
+
+// Monitor starts monitoring the channel. req is the pvRequest structure, which may be empty.
+// The returned Nexter's Next method returns the channel's current value first, and then each new value.
+// Monitoring stops when the ctx passed to Next is cancelled.
+func (lc *LocalChannel) Monitor(ctx context.Context, req pvdata.PVStructure) (Nexter, error) {
+	ctx = types.WithPeer(ctx, lc.peer)
+	channel := lc.channel
+	if err := checkRead(lc.rights, channel); err != nil {
+		return nil, err
+	}
+	result, err := lc.srv.intercept(ctx, lc.newOp(OpMonitor, true, req), func(ctx context.Context, op *Op) (interface{}, error) {
+		if nextc, ok := channel.(ChannelMonitorCreator); ok {
+			return nextc.CreateChannelMonitor(ctx, op.Args)
+		}
+		return nil, fmt.Errorf("channel %q does not support Monitor", channel.Name())
+	})
+	if err != nil {
+		return nil, err
+	}
+	nexter, ok := result.(Nexter)
+	if !ok {
+		return nil, fmt.Errorf("channel %q does not support Monitor", channel.Name())
+	}
+	return nexter, nil
+}
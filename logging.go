@@ -0,0 +1,58 @@
+package pvaccess
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
+	"github.com/sirupsen/logrus"
+)
+
+// Subsystems of the client and server whose log levels can be set with SetLogLevel.
+// Their messages have a "subsystem" field with the subsystem's name.
+const (
+	// LogSearch is UDP searches and beacons.
+	LogSearch = ctxlog.Search
+	// LogConnection is the lifecycle of TCP connections: validation, control messages, and disconnection.
+	LogConnection = ctxlog.Connection
+	// LogCodec is every message sent and received, and at trace level, their bytes.
+	LogCodec = ctxlog.Codec
+	// LogProvider is the dispatch of requests to channel providers, and the providers' own messages.
+	LogProvider = ctxlog.Provider
+)
+
+var logSubsystems = []string{LogSearch, LogConnection, LogCodec, LogProvider}
+
+// SetLogLevel sets the level of the messages logged by subsystem, overriding the level of the logrus logger
+// for that subsystem only; e.g. the codec can be kept at InfoLevel while debugging connections.
+func SetLogLevel(subsystem string, level logrus.Level) {
+	ctxlog.SetLevel(subsystem, level)
+}
+
+// SetLogLevels parses a comma-separated list of subsystem=level settings, such as "codec=warn,connection=debug",
+// and sets each with SetLogLevel. It is meant for command line flags.
+func SetLogLevels(spec string) error {
+	for _, setting := range strings.Split(spec, ",") {
+		if setting = strings.TrimSpace(setting); setting == "" {
+			continue
+		}
+		eq := strings.IndexByte(setting, '=')
+		if eq < 0 {
+			return fmt.Errorf("log level setting %q is not subsystem=level", setting)
+		}
+		subsystem, name := setting[:eq], setting[eq+1:]
+		known := false
+		for _, s := range logSubsystems {
+			known = known || s == subsystem
+		}
+		if !known {
+			return fmt.Errorf("unknown log subsystem %q; expected one of %s", subsystem, strings.Join(logSubsystems, ", "))
+		}
+		level, err := logrus.ParseLevel(name)
+		if err != nil {
+			return fmt.Errorf("log subsystem %s: %w", subsystem, err)
+		}
+		SetLogLevel(subsystem, level)
+	}
+	return nil
+}
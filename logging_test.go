@@ -0,0 +1,52 @@
+package pvaccess
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
+	"github.com/sirupsen/logrus"
+)
+
+func TestSetLogLevels(t *testing.T) {
+	logger := logrus.StandardLogger()
+	var buf bytes.Buffer
+	out, level := logger.Out, logger.Level
+	logger.SetOutput(&buf)
+	logger.SetLevel(logrus.InfoLevel)
+	defer func() {
+		logger.SetOutput(out)
+		logger.SetLevel(level)
+		for _, s := range logSubsystems {
+			ctxlog.ResetLevel(s)
+		}
+	}()
+
+	if err := SetLogLevels("codec=debug, connection=warn"); err != nil {
+		t.Fatal(err)
+	}
+	ctx := ctxlog.WithField(context.Background(), "conn", 1)
+	ctxlog.For(ctx, LogCodec).Debug("codec debug")
+	ctxlog.L(ctxlog.WithSubsystem(ctx, LogConnection)).Info("connection info")
+	ctxlog.L(ctxlog.WithSubsystem(ctx, LogProvider)).Info("provider info")
+	ctxlog.L(ctxlog.WithSubsystem(ctx, LogProvider)).Debug("provider debug")
+	got := buf.String()
+	for _, want := range []string{"codec debug", "subsystem=codec", "conn=1", "provider info"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("log output is missing %q:\n%s", want, got)
+		}
+	}
+	for _, unwanted := range []string{"connection info", "provider debug"} {
+		if strings.Contains(got, unwanted) {
+			t.Errorf("log output has %q:\n%s", unwanted, got)
+		}
+	}
+
+	for _, spec := range []string{"codec", "nosuch=debug", "codec=loud"} {
+		if err := SetLogLevels(spec); err == nil {
+			t.Errorf("SetLogLevels(%q) succeeded", spec)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package pvaccess
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/Lexcelon/go-pvaccess/types"
+)
+
+// metricsInterval is how often monitored metrics are sampled.
+const metricsInterval = time.Second
+
+// metric is one of the server's metrics, served as a PV named by Server.MetricsPrefix and the metric's name.
+type metric struct {
+	description string
+	units       string
+	value       func(m *serverMetrics) interface{}
+}
+
+var metrics = map[string]metric{
+	"connCount": {"Client connections", "", func(m *serverMetrics) interface{} {
+		return pvdata.PVLong(m.conns)
+	}},
+	"channelCount": {"Channels open by clients", "", func(m *serverMetrics) interface{} {
+		return pvdata.PVLong(m.channels)
+	}},
+	"requestCount": {"Requests in progress, including monitors", "", func(m *serverMetrics) interface{} {
+		return pvdata.PVLong(m.requests)
+	}},
+	"monQueueMax": {"Most messages waiting to be sent on one channel, or as one connection's bundled monitor updates", "", func(m *serverMetrics) interface{} {
+		return pvdata.PVLong(m.queueMax)
+	}},
+	"monWaiting": {"Monitor updates waiting for their turn to be sent", "", func(m *serverMetrics) interface{} {
+		return pvdata.PVLong(m.updatesWaiting)
+	}},
+	"rttMax": {"Longest round-trip time to a client", "s", func(m *serverMetrics) interface{} {
+		return pvdata.PVDouble(m.rttMax.Seconds())
+	}},
+}
+
+// serverMetrics is a snapshot of the server's state.
+type serverMetrics struct {
+	conns, channels, requests int
+	queueMax, updatesWaiting  int
+	rttMax                    time.Duration
+}
+
+func (srv *Server) metrics() *serverMetrics {
+	srv.mu.RLock()
+	conns := make([]*serverConn, 0, len(srv.conns))
+	for c := range srv.conns {
+		conns = append(conns, c)
+	}
+	srv.mu.RUnlock()
+	m := &serverMetrics{
+		conns:          len(conns),
+		updatesWaiting: srv.updates.Waiting(),
+	}
+	for _, c := range conns {
+		if rtt := c.Health().RTT; rtt > m.rttMax {
+			m.rttMax = rtt
+		}
+		c.mu.Lock()
+		m.channels += len(c.channels)
+		m.requests += len(c.requests)
+		for _, sc := range c.channels {
+			if n := sc.queue.Len(); n > m.queueMax {
+				m.queueMax = n
+			}
+		}
+		if c.updates != nil {
+			if n := c.updates.Len(); n > m.queueMax {
+				m.queueMax = n
+			}
+		}
+		c.mu.Unlock()
+	}
+	return m
+}
+
+// metricsProvider serves the server's metrics when Server.MetricsPrefix is set.
+type metricsProvider struct {
+	srv    *Server
+	prefix string
+}
+
+func (p metricsProvider) CreateChannel(ctx context.Context, name string) (Channel, error) {
+	if !strings.HasPrefix(name, p.prefix) {
+		return nil, nil
+	}
+	m, ok := metrics[strings.TrimPrefix(name, p.prefix)]
+	if !ok {
+		return nil, nil
+	}
+	return &metricChannel{srv: p.srv, name: name, metric: m}, nil
+}
+
+func (p metricsProvider) ChannelList(ctx context.Context) ([]string, error) {
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, p.prefix+name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+type metricChannel struct {
+	srv    *Server
+	name   string
+	metric metric
+}
+
+func (c *metricChannel) Name() string {
+	return c.name
+}
+
+func (c *metricChannel) sample() (*pvdata.NTScalar, interface{}) {
+	value := c.metric.value(c.srv.metrics())
+	display := pvdata.NewDisplay(0, 0, c.metric.units, 0).WithDescription(c.metric.description)
+	if _, ok := value.(pvdata.PVDouble); ok {
+		display.Precision = 3
+	}
+	return &pvdata.NTScalar{
+		Value:     value,
+		TimeStamp: &pvdata.Time{Time: time.Now()},
+		Display:   &display,
+	}, value
+}
+
+func (c *metricChannel) ChannelGet(ctx context.Context) (interface{}, error) {
+	v, _ := c.sample()
+	return v, nil
+}
+
+func (c *metricChannel) CreateChannelMonitor(ctx context.Context, req pvdata.PVStructure) (types.Nexter, error) {
+	return &metricWatch{c: c}, nil
+}
+
+// metricWatch samples a metric every metricsInterval, and returns it whenever it changes.
+type metricWatch struct {
+	c       *metricChannel
+	started bool
+	last    interface{}
+}
+
+func (w *metricWatch) Next(ctx context.Context) (interface{}, error) {
+	if !w.started {
+		w.started = true
+		v, value := w.c.sample()
+		w.last = value
+		return v, nil
+	}
+	ticker := time.NewTicker(metricsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+		if v, value := w.c.sample(); value != w.last {
+			w.last = value
+			return v, nil
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package mockpeer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+	"github.com/Lexcelon/go-pvaccess/client"
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/google/go-cmp/cmp"
+)
+
+// serve serves srv on a local TCP port until the test ends, and returns its address.
+func serve(t *testing.T, srv *pvaccess.Server) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.Serve(ctx, ln)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return ln.Addr().String()
+}
+
+// record proxies one connection to addr, recording the client's end of it while f runs with the proxy's address.
+func record(t *testing.T, addr string, f func(proxy string)) Recording {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var rec *Recorder
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		down, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer down.Close()
+		up, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		rec = NewRecorder(up)
+		defer rec.Close()
+		go func() {
+			io.Copy(rec, down)
+			rec.Close()
+		}()
+		io.Copy(down, rec)
+	}()
+	f(ln.Addr().String())
+	ln.Close()
+	wg.Wait()
+	if rec == nil {
+		t.Fatal("nothing connected to the proxy")
+	}
+	r, err := rec.Recording()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func commands(rec Recording) []string {
+	var names []string
+	for _, m := range rec {
+		names = append(names, m.Dir.String()+" "+m.Header().CommandName())
+	}
+	return names
+}
+
+func TestRecordReplay(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ch := pvaccess.NewSimpleChannel("test")
+	x := pvdata.PVInt(1)
+	ch.Set(&x)
+	srv := &pvaccess.Server{DisableSearch: true}
+	srv.AddChannelProvider(ch)
+	addr := serve(t, srv)
+
+	rec := record(t, addr, func(proxy string) {
+		c := client.New(proxy)
+		defer c.Close()
+		channel, err := c.Channel(ctx, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := channel.Get(ctx); err != nil {
+			t.Fatal(err)
+		}
+	})
+	// The recording ends with the response to the Get, which the client has received.
+	for len(rec) > 0 && rec[len(rec)-1].Dir == Sent {
+		rec = rec[:len(rec)-1]
+	}
+	received := 0
+	for _, m := range rec {
+		if m.Dir == Received && !SkipEcho(m) {
+			received++
+		}
+	}
+	if last := rec[len(rec)-1]; last.Header().MessageCommand != proto.APP_CHANNEL_GET {
+		t.Fatalf("recording ends with %v, want a CHANNEL_GET response\n%v", last, commands(rec))
+	}
+
+	var text bytes.Buffer
+	if _, err := rec.WriteTo(&text); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ReadRecording(strings.NewReader("# recorded by TestRecordReplay\n\n" + text.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(rec, parsed); diff != "" {
+		t.Fatalf("ReadRecording after WriteTo differs: (-want +got)\n%s", diff)
+	}
+
+	replay := func(rec Recording) (Recording, error) {
+		t.Helper()
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		return Replay(ctx, conn, rec)
+	}
+	got, err := replay(parsed)
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if len(got) < received {
+		t.Errorf("Replay received %d messages, want at least %d", len(got), received)
+	}
+
+	// A server that answers the Get with something else fails the replay at that step.
+	wrong := append(Recording(nil), parsed...)
+	last := wrong[len(wrong)-1]
+	last.Data = append([]byte(nil), last.Data...)
+	last.Data[3] = byte(proto.APP_CHANNEL_PUT)
+	wrong[len(wrong)-1] = last
+	if _, err := replay(wrong); err == nil || !strings.Contains(err.Error(), "step ") {
+		t.Errorf("Replay with the wrong response = %v, want an error naming the step", err)
+	}
+}
+
+func TestReadRecordingErrors(t *testing.T) {
+	for _, test := range []struct {
+		name, text string
+	}{
+		{"no direction", "ca0240030000000\n"},
+		{"bad hex", "> zz\n"},
+		{"bad magic", "> 0002000000000000\n"},
+		{"truncated", "> ca020002 08000000 0102\n"},
+		{"two messages", "> ca01410300000000ca01410300000000\n"},
+	} {
+		if _, err := ReadRecording(strings.NewReader(test.text)); err == nil {
+			t.Errorf("%s: ReadRecording succeeded", test.name)
+		}
+	}
+	rec, err := ReadRecording(strings.NewReader("> ca020002 02000000 0102\n< ca01c10300000000\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := commands(rec), []string{"sent ECHO", "received ECHO_REQUEST"}; !cmp.Equal(got, want) {
+		t.Errorf("commands = %v, want %v", got, want)
+	}
+}
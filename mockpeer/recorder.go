@@ -0,0 +1,59 @@
+package mockpeer
+
+import (
+	"net"
+	"sync"
+)
+
+// Recorder is a net.Conn that records the messages written to and read from the connection it wraps.
+type Recorder struct {
+	net.Conn
+
+	mu  sync.Mutex
+	rec Recording
+	// partial holds the start of the next message in each direction.
+	partial [2][]byte
+	err     error
+}
+
+// NewRecorder returns a Recorder wrapping conn.
+func NewRecorder(conn net.Conn) *Recorder {
+	return &Recorder{Conn: conn}
+}
+
+func (r *Recorder) Read(b []byte) (int, error) {
+	n, err := r.Conn.Read(b)
+	r.record(Received, b[:n])
+	return n, err
+}
+
+func (r *Recorder) Write(b []byte) (int, error) {
+	n, err := r.Conn.Write(b)
+	r.record(Sent, b[:n])
+	return n, err
+}
+
+func (r *Recorder) record(dir Direction, b []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil || len(b) == 0 {
+		return
+	}
+	msgs, rest, err := split(append(r.partial[dir], b...))
+	if err != nil {
+		r.err = err
+		return
+	}
+	for _, m := range msgs {
+		r.rec = append(r.rec, Message{Dir: dir, Data: append([]byte(nil), m...)})
+	}
+	r.partial[dir] = append([]byte(nil), rest...)
+}
+
+// Recording returns the messages recorded so far. It returns an error if the data on the connection
+// stopped looking like pvAccess messages, along with the messages recorded until then.
+func (r *Recorder) Recording() (Recording, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append(Recording(nil), r.rec...), r.err
+}
@@ -0,0 +1,150 @@
+// Package mockpeer records the pvAccess messages exchanged on a connection, and replays them against a server
+// or client while checking its responses, for regression tests of tricky orderings of messages such as handshakes.
+//
+// A Recording is the sequence of messages sent and received by one end of a connection. Recordings can be made
+// with a Recorder, written by hand, or copied from a capture (e.g. with pvadecode -x), and are stored in a text
+// format with one message per line. Replaying a recording sends the recorded end's messages as they were sent,
+// and checks that each message it received is matched by one from the peer under test.
+package mockpeer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+// Direction is whether a message was sent or received by the recorded end of a connection.
+type Direction int
+
+const (
+	Sent Direction = iota
+	Received
+)
+
+func (d Direction) String() string {
+	if d == Sent {
+		return "sent"
+	}
+	return "received"
+}
+
+// headerSize is the size of a pvAccess message header.
+const headerSize = 8
+
+// Message is a pvAccess message of a Recording.
+type Message struct {
+	Dir Direction
+	// Data is the message's header and payload, as sent on the connection.
+	Data []byte
+}
+
+// Header returns the message's header.
+func (m Message) Header() proto.PVAccessHeader {
+	h := proto.PVAccessHeader{
+		Version:        pvdata.PVByte(m.Data[1]),
+		Flags:          pvdata.PVUByte(m.Data[2]),
+		MessageCommand: pvdata.PVByte(m.Data[3]),
+	}
+	h.PayloadSize = pvdata.PVInt(byteOrder(h.Flags).Uint32(m.Data[4:headerSize]))
+	return h
+}
+
+// Payload returns the payload of an application message.
+func (m Message) Payload() []byte {
+	return m.Data[headerSize:]
+}
+
+func (m Message) String() string {
+	h := m.Header()
+	return fmt.Sprintf("%s %s (%d bytes)", m.Dir, h.CommandName(), len(m.Payload()))
+}
+
+func byteOrder(flags pvdata.PVUByte) binary.ByteOrder {
+	if flags&proto.FLAG_BO_BE != 0 {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// Recording is a sequence of messages sent and received by one end of a connection.
+type Recording []Message
+
+// WriteTo writes the recording in the text format read by ReadRecording: one message per line, in hex,
+// after "> " for messages sent or "< " for messages received.
+func (r Recording) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	for _, m := range r {
+		prefix := "> "
+		if m.Dir == Received {
+			prefix = "< "
+		}
+		k, err := fmt.Fprintf(w, "%s%s\n", prefix, hex.EncodeToString(m.Data))
+		n += int64(k)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// ReadRecording reads a recording written by Recording.WriteTo. Blank lines and lines starting with "#" are ignored,
+// and the hex of each message may contain spaces.
+func ReadRecording(r io.Reader) (Recording, error) {
+	var rec Recording
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		var dir Direction
+		switch text[0] {
+		case '>':
+			dir = Sent
+		case '<':
+			dir = Received
+		default:
+			return nil, fmt.Errorf("line %d: message doesn't start with > or <", line)
+		}
+		data, err := hex.DecodeString(strings.Join(strings.Fields(text[1:]), ""))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		msgs, rest, err := split(data)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if len(msgs) != 1 || len(rest) != 0 {
+			return nil, fmt.Errorf("line %d: not exactly one message", line)
+		}
+		rec = append(rec, Message{Dir: dir, Data: msgs[0]})
+	}
+	return rec, scanner.Err()
+}
+
+// split splits data into whole messages, and returns them along with the start of an incomplete message.
+func split(data []byte) (msgs [][]byte, rest []byte, err error) {
+	for len(data) >= headerSize {
+		if data[0] != proto.MAGIC {
+			return nil, nil, fmt.Errorf("unexpected magic %x", data[0])
+		}
+		size := headerSize
+		flags := pvdata.PVUByte(data[2])
+		if flags&proto.FLAG_MSG_CTRL == 0 {
+			size += int(byteOrder(flags).Uint32(data[4:headerSize]))
+		}
+		if len(data) < size {
+			break
+		}
+		msgs = append(msgs, data[:size:size])
+		data = data[size:]
+	}
+	return msgs, data, nil
+}
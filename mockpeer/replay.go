@@ -0,0 +1,132 @@
+package mockpeer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/proto"
+)
+
+// Peer replays recordings, playing the recorded end of a connection against the peer under test.
+type Peer struct {
+	// Match checks a message received from the peer under test against the one in the recording.
+	// Nil compares only the messages' commands, since most payloads contain IDs, timestamps and
+	// addresses that differ between runs.
+	Match func(want, got Message) error
+	// Skip reports whether a message received from the peer under test should be ignored instead of matched.
+	// Nil skips echo requests and responses, which are sent on a timer rather than in response to messages.
+	Skip func(Message) bool
+}
+
+// Replay replays rec against the peer under test connected to rw, and returns the messages received from it.
+// The messages of rec that were sent are written unchanged, and each message that was received must be matched
+// by the next message read from rw. If rw is a net.Conn, ctx's deadline is applied to it.
+func (p *Peer) Replay(ctx context.Context, rw io.ReadWriter, rec Recording) (Recording, error) {
+	match, skip := p.Match, p.Skip
+	if match == nil {
+		match = MatchCommand
+	}
+	if skip == nil {
+		skip = SkipEcho
+	}
+	if conn, ok := rw.(net.Conn); ok {
+		deadline, _ := ctx.Deadline()
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+		defer conn.SetDeadline(time.Time{})
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-ctx.Done():
+				conn.SetDeadline(time.Now())
+			case <-stop:
+			}
+		}()
+	}
+	var got Recording
+	var buf []byte
+	read := func() (Message, error) {
+		for {
+			msgs, rest, err := split(buf)
+			if err != nil {
+				return Message{}, err
+			}
+			if len(msgs) > 0 {
+				m := Message{Dir: Received, Data: append([]byte(nil), msgs[0]...)}
+				buf = buf[len(msgs[0]):]
+				return m, nil
+			}
+			chunk := make([]byte, 4096)
+			n, err := rw.Read(chunk)
+			buf = append(rest, chunk[:n]...)
+			if n == 0 && err != nil {
+				return Message{}, err
+			}
+		}
+	}
+	for i, want := range rec {
+		if want.Dir == Sent {
+			if _, err := rw.Write(want.Data); err != nil {
+				return got, fmt.Errorf("step %d: sending %v: %w", i, want, err)
+			}
+			continue
+		}
+		for {
+			m, err := read()
+			if err != nil {
+				if ctx.Err() != nil {
+					err = ctx.Err()
+				}
+				return got, fmt.Errorf("step %d: waiting for %v: %w", i, want, err)
+			}
+			got = append(got, m)
+			if skip(m) {
+				continue
+			}
+			if err := match(want, m); err != nil {
+				return got, fmt.Errorf("step %d: %w", i, err)
+			}
+			break
+		}
+	}
+	return got, nil
+}
+
+// Replay replays rec against the peer under test connected to rw, with the default Peer.
+func Replay(ctx context.Context, rw io.ReadWriter, rec Recording) (Recording, error) {
+	return (&Peer{}).Replay(ctx, rw, rec)
+}
+
+// MatchCommand checks that got is the same kind of message as want, with the same command.
+func MatchCommand(want, got Message) error {
+	wh, gh := want.Header(), got.Header()
+	if wh.IsControl() != gh.IsControl() || wh.MessageCommand != gh.MessageCommand {
+		return fmt.Errorf("got %v, want %v", got, want)
+	}
+	return nil
+}
+
+// MatchExact checks that got is identical to want, for messages without run-specific contents.
+func MatchExact(want, got Message) error {
+	if err := MatchCommand(want, got); err != nil {
+		return err
+	}
+	if string(want.Data) != string(got.Data) {
+		return fmt.Errorf("got %v %x, want %x", got, got.Data, want.Data)
+	}
+	return nil
+}
+
+// SkipEcho reports whether m is an echo request or response.
+func SkipEcho(m Message) bool {
+	h := m.Header()
+	if !h.IsControl() {
+		return false
+	}
+	return h.MessageCommand == proto.CTRL_ECHO_REQUEST || h.MessageCommand == proto.CTRL_ECHO_RESPONSE
+}
@@ -0,0 +1,99 @@
+// Package node runs a pvAccess server and client together, for processes that both serve PVs and use remote ones,
+// such as middle-layer services that serve PVs computed from others.
+//
+// A Context's server and client share one UDP socket: the server sends its beacons and search responses from it,
+// and the client sends its search requests from it and reads the responses, so that the process uses one port
+// for both roles. They also share a GUID, which identifies the process in beacons and search responses, and a
+// Clock and Compressor. The client looks up channels on the server first, without a network connection,
+// as with client.Client's LocalServers.
+package node
+
+import (
+	"context"
+	"net"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+	"github.com/Lexcelon/go-pvaccess/client"
+	"github.com/Lexcelon/go-pvaccess/clock"
+)
+
+// Config configures a Context.
+type Config struct {
+	// GUID identifies the process in beacons and search responses, e.g. pvaccess.GUIDFromHost.
+	// The zero GUID selects a random one.
+	GUID [12]byte
+	// UDPAddr is the local address of the shared UDP socket. Empty selects a random port on every interface.
+	UDPAddr string
+	// ServerAddrs and SearchAddrs are where the client finds channels that the server doesn't have,
+	// as in client.Client.
+	ServerAddrs, SearchAddrs []string
+	// Clock and Compressor are used by both the server and the client. Nil values select the system clock
+	// and no compression.
+	Clock      clock.Clock
+	Compressor pvaccess.Compressor
+}
+
+// Context is a pvAccess server and client that share a UDP socket, a GUID, and configuration.
+// Server and Client may be configured further before the server is served or any channels are created.
+type Context struct {
+	Server *pvaccess.Server
+	Client *client.Client
+
+	conn *net.UDPConn
+}
+
+// New returns a Context configured by cfg. Its server has no channel providers other than the "server" channel
+// of pvaccess.NewServer.
+func New(cfg Config) (*Context, error) {
+	var laddr *net.UDPAddr
+	if cfg.UDPAddr != "" {
+		var err error
+		if laddr, err = net.ResolveUDPAddr("udp", cfg.UDPAddr); err != nil {
+			return nil, err
+		}
+	}
+	srv, err := pvaccess.NewServer()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.GUID != ([12]byte{}) {
+		srv.SetGUID(cfg.GUID)
+	}
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, err
+	}
+	srv.UDPConn = conn
+	srv.Clock = cfg.Clock
+	srv.Compressor = cfg.Compressor
+
+	c := client.New(cfg.ServerAddrs...)
+	c.SearchAddrs = cfg.SearchAddrs
+	c.SearchConn = conn
+	c.LocalServers = []*pvaccess.Server{srv}
+	c.Clock = cfg.Clock
+	c.Compressor = cfg.Compressor
+	return &Context{Server: srv, Client: c, conn: conn}, nil
+}
+
+// GUID returns the GUID that identifies the process.
+func (c *Context) GUID() [12]byte {
+	return c.Server.GUID()
+}
+
+// UDPAddr returns the address of the shared UDP socket.
+func (c *Context) UDPAddr() *net.UDPAddr {
+	return c.conn.LocalAddr().(*net.UDPAddr)
+}
+
+// Run serves lns with the server until ctx is cancelled, as by ServeListeners, and then closes the Context.
+func (c *Context) Run(ctx context.Context, lns ...pvaccess.Listener) error {
+	defer c.Close()
+	return c.Server.ServeListeners(ctx, lns...)
+}
+
+// Close closes the client and the shared UDP socket.
+func (c *Context) Close() error {
+	c.Client.Close()
+	return c.conn.Close()
+}
@@ -0,0 +1,136 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+	"github.com/Lexcelon/go-pvaccess/internal/connection"
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+// serve runs srv on a random port until the test ends.
+func serve(t *testing.T, srv *pvaccess.Server) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.Serve(ctx, ln)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return ln.Addr().String()
+}
+
+func TestContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	remote := pvaccess.NewSimpleChannel("remote:x")
+	x := pvdata.PVInt(1)
+	remote.Set(&x)
+	remoteSrv := &pvaccess.Server{DisableSearch: true}
+	remoteSrv.AddChannelProvider(remote)
+	_, port, _ := net.SplitHostPort(serve(t, remoteSrv))
+	tcpPort, _ := strconv.Atoi(port)
+
+	// The search server answers every search with the remote server, and reports where searches come from.
+	search, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer search.Close()
+	from := make(chan *net.UDPAddr, 10)
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, addr, err := search.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			msg, err := connection.New(bytes.NewBuffer(buf[:n]), proto.FLAG_FROM_SERVER).Next(ctx)
+			if err != nil {
+				continue
+			}
+			var req proto.SearchRequest
+			if err := msg.Decode(&req); err != nil {
+				continue
+			}
+			from <- addr
+			var out bytes.Buffer
+			c := connection.New(&out, proto.FLAG_FROM_SERVER)
+			c.Version = 2
+			for _, ch := range req.Channels {
+				c.SendApp(ctx, proto.APP_SEARCH_RESPONSE, &proto.SearchResponse{
+					SearchSequenceID:  req.SearchSequenceID,
+					ServerPort:        pvdata.PVUShort(tcpPort),
+					Protocol:          "tcp",
+					Found:             true,
+					SearchInstanceIDs: []pvdata.PVUInt{ch.SearchInstanceID},
+				})
+			}
+			search.WriteToUDP(out.Bytes(), addr)
+		}
+	}()
+
+	guid := pvaccess.GUIDFromHost("node", 5075)
+	n, err := New(Config{GUID: guid, UDPAddr: "127.0.0.1:0", SearchAddrs: []string{search.LocalAddr().String()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	if n.GUID() != guid {
+		t.Errorf("GUID = %x, want %x", n.GUID(), guid)
+	}
+	if n.Server.UDPConn == nil || n.Server.UDPConn != n.Client.SearchConn {
+		t.Error("server and client don't share a UDP socket")
+	}
+	local := pvaccess.NewSimpleChannel("local:x")
+	y := pvdata.PVInt(2)
+	local.Set(&y)
+	n.Server.AddChannelProvider(local)
+	n.Server.DisableSearch = true
+	serve(t, n.Server)
+
+	for _, tc := range []struct {
+		name string
+		want interface{}
+	}{
+		{"local:x", int32(2)},
+		{"remote:x", int32(1)},
+	} {
+		ch, err := n.Client.Channel(ctx, tc.name)
+		if err != nil {
+			t.Fatalf("Channel(%q): %v", tc.name, err)
+		}
+		v, err := ch.Get(ctx)
+		if err != nil {
+			t.Fatalf("Get(%q): %v", tc.name, err)
+		}
+		if got := v.ToMap()["value"]; got != tc.want {
+			t.Errorf("Get(%q) = %v (%T), want %v", tc.name, got, got, tc.want)
+		}
+		ch.Close()
+	}
+	// Only the remote channel was searched for, from the shared socket.
+	select {
+	case addr := <-from:
+		if addr.Port != n.UDPAddr().Port {
+			t.Errorf("search sent from %v, want the shared socket %v", addr, n.UDPAddr())
+		}
+	default:
+		t.Error("remote channel was not searched for")
+	}
+}
@@ -0,0 +1,159 @@
+package pvaccess
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+// Histogram counts observations in buckets.
+type Histogram struct {
+	// Bounds are the inclusive upper bounds of the buckets, in increasing order.
+	// Counts[i] is the number of observations in the bucket ending at Bounds[i];
+	// the last element of Counts counts the observations larger than every bound.
+	Bounds []float64
+	Counts []uint64
+	// Count and Sum are the number and total of all observations.
+	Count uint64
+	Sum   float64
+}
+
+// NewHistogram returns an empty Histogram with the given bucket bounds.
+func NewHistogram(bounds []float64) Histogram {
+	return Histogram{Bounds: bounds, Counts: make([]uint64, len(bounds)+1)}
+}
+
+// NewDurationHistogram returns an empty Histogram of durations in seconds, with the buckets of OpStats.Duration.
+func NewDurationHistogram() Histogram {
+	return NewHistogram(durationBounds)
+}
+
+// Observe counts v in its bucket.
+func (h *Histogram) Observe(v float64) {
+	i := 0
+	for i < len(h.Bounds) && v > h.Bounds[i] {
+		i++
+	}
+	h.Counts[i]++
+	h.Count++
+	h.Sum += v
+}
+
+// Copy returns a copy of h that doesn't share its counts.
+func (h Histogram) Copy() Histogram {
+	h.Counts = append([]uint64(nil), h.Counts...)
+	return h
+}
+
+// durationBounds are the buckets of operation durations, in seconds.
+var durationBounds = []float64{0.0001, 0.001, 0.01, 0.1, 1, 10}
+
+// sizeBounds are the buckets of payload sizes, in bytes.
+var sizeBounds = []float64{64, 256, 1024, 4096, 16384, 65536, 262144, 1048576}
+
+// OpStats describes the operations of one kind performed by a Server.
+type OpStats struct {
+	// Duration is the time taken to perform each operation, in seconds, including the server's interceptors.
+	Duration Histogram
+	// RequestSize and ResponseSize are the payload sizes, in bytes, of the messages received from and sent to clients
+	// for the operations. Operations on a LocalChannel are not included.
+	RequestSize  Histogram
+	ResponseSize Histogram
+}
+
+func newOpStats() *OpStats {
+	return &OpStats{
+		Duration:     NewHistogram(durationBounds),
+		RequestSize:  NewHistogram(sizeBounds),
+		ResponseSize: NewHistogram(sizeBounds),
+	}
+}
+
+// opKindsByCommand is the kind of operation each message is part of.
+var opKindsByCommand = map[pvdata.PVByte]OpKind{
+	proto.APP_CHANNEL_CREATE:  OpCreateChannel,
+	proto.APP_CHANNEL_GET:     OpGet,
+	proto.APP_CHANNEL_PUT:     OpPut,
+	proto.APP_CHANNEL_RPC:     OpRPC,
+	proto.APP_CHANNEL_MONITOR: OpMonitor,
+}
+
+// opStatsRecorder accumulates OpStats for each kind of operation.
+type opStatsRecorder struct {
+	mu    sync.Mutex
+	stats map[OpKind]*OpStats
+}
+
+func (r *opStatsRecorder) record(kind OpKind, f func(s *OpStats)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stats == nil {
+		r.stats = make(map[OpKind]*OpStats)
+	}
+	s, ok := r.stats[kind]
+	if !ok {
+		s = newOpStats()
+		r.stats[kind] = s
+	}
+	f(s)
+}
+
+func (r *opStatsRecorder) duration(kind OpKind, d time.Duration) {
+	r.record(kind, func(s *OpStats) { s.Duration.Observe(d.Seconds()) })
+}
+
+// received records the size of a message received from a client.
+func (r *opStatsRecorder) received(command pvdata.PVByte, size int) {
+	if kind, ok := opKindsByCommand[command]; ok {
+		r.record(kind, func(s *OpStats) { s.RequestSize.Observe(float64(size)) })
+	}
+}
+
+// sent records the size of a message sent to a client.
+func (r *opStatsRecorder) sent(command pvdata.PVByte, size int) {
+	if kind, ok := opKindsByCommand[command]; ok {
+		r.record(kind, func(s *OpStats) { s.ResponseSize.Observe(float64(size)) })
+	}
+}
+
+// OpStats returns the statistics of each kind of operation the server has performed.
+func (srv *Server) OpStats() map[OpKind]OpStats {
+	r := &srv.opStats
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := make(map[OpKind]OpStats, len(r.stats))
+	for kind, s := range r.stats {
+		stats[kind] = OpStats{
+			Duration:     s.Duration.Copy(),
+			RequestSize:  s.RequestSize.Copy(),
+			ResponseSize: s.ResponseSize.Copy(),
+		}
+	}
+	return stats
+}
+
+// opFinished records the duration of op, and logs it if it took longer than the server's SlowOpThreshold.
+func (srv *Server) opFinished(ctx context.Context, op *Op, d time.Duration) {
+	srv.opStats.duration(op.Kind, d)
+	if srv.SlowOpThreshold <= 0 || d < srv.SlowOpThreshold {
+		return
+	}
+	fields := ctxlog.Fields{
+		"op":       op.Kind,
+		"init":     op.Init,
+		"channel":  op.ChannelName,
+		"duration": d,
+	}
+	if op.Peer != nil {
+		fields["peer"] = op.Peer.Addr
+		fields["authnz"] = op.Peer.AuthNZ
+		if op.Peer.User != "" {
+			fields["user"] = op.Peer.User
+		}
+	}
+	ctxlog.L(ctx).WithFields(fields).Warnf("slow %s took %v", op.Kind, d)
+}
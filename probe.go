@@ -0,0 +1,148 @@
+package pvaccess
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
+	"golang.org/x/sync/errgroup"
+)
+
+// HealthStatus reports whether a server is ready to serve clients, for readiness and liveness probes.
+type HealthStatus struct {
+	// Ready is true once the server is listening and has at least one channel provider.
+	Ready bool `json:"ready"`
+	// Live is false once the server has stopped serving.
+	Live bool `json:"live"`
+	// Listening lists the addresses the server accepts connections on.
+	Listening []string `json:"listening"`
+	// Providers is the number of channel providers registered on the server.
+	Providers int `json:"providers"`
+	// Beacons describes the UDP beacons sent by the server. It is nil if search is disabled or hasn't started.
+	Beacons *BeaconStatus `json:"beacons,omitempty"`
+}
+
+// BeaconStatus describes the beacons sent by a server.
+type BeaconStatus struct {
+	// Sent is the number of beacons sent so far.
+	Sent int `json:"sent"`
+	// Last is when the last beacon was sent.
+	Last time.Time `json:"last"`
+	// Error is the error from the last attempt to send a beacon, if it failed.
+	Error string `json:"error,omitempty"`
+}
+
+// Ready returns a channel that is closed once the server is accepting connections on all the listeners passed to Serve or ServeListeners.
+func (srv *Server) Ready() <-chan struct{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.readyLocked()
+}
+
+func (srv *Server) readyLocked() chan struct{} {
+	if srv.ready == nil {
+		srv.ready = make(chan struct{})
+	}
+	return srv.ready
+}
+
+// serving records that the server is accepting connections on lns.
+func (srv *Server) serving(lns []Listener) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.stopped = false
+	srv.draining = false
+	for _, l := range lns {
+		srv.listening = append(srv.listening, l.Addr())
+	}
+	ready := srv.readyLocked()
+	select {
+	case <-ready:
+	default:
+		close(ready)
+	}
+}
+
+// stop records that the server has stopped serving.
+func (srv *Server) stop() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.stopped = true
+	srv.listening = nil
+}
+
+// HealthStatus returns the server's current status.
+func (srv *Server) HealthStatus() HealthStatus {
+	srv.mu.RLock()
+	st := HealthStatus{
+		Live:      !srv.stopped,
+		Listening: make([]string, len(srv.listening)),
+		Providers: len(srv.channelProviders),
+	}
+	for i, addr := range srv.listening {
+		st.Listening[i] = addr.String()
+	}
+	started := false
+	if srv.ready != nil {
+		select {
+		case <-srv.ready:
+			started = true
+		default:
+		}
+	}
+	search, disabled := srv.search, srv.DisableSearch
+	srv.mu.RUnlock()
+	st.Ready = started && st.Live && st.Providers > 0
+	if search != nil && !disabled {
+		b := search.Beacons()
+		st.Beacons = &BeaconStatus{Sent: b.Sent, Last: b.Last}
+		if b.Err != nil {
+			st.Beacons.Error = b.Err.Error()
+		}
+	}
+	return st
+}
+
+// HealthHandler returns an HTTP handler for readiness and liveness probes, such as Kubernetes'.
+// Requests for /livez report whether the server is live, and requests for any other path whether it is ready,
+// with the status 200 OK or 503 Service Unavailable. The body of the response is the server's HealthStatus, as JSON.
+func (srv *Server) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		st := srv.HealthStatus()
+		ok := st.Ready
+		if r.URL.Path == "/livez" {
+			ok = st.Live
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !ok {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(st)
+	})
+}
+
+// serveHealth serves HealthHandler on srv.HealthAddr until ctx is cancelled.
+func (srv *Server) serveHealth(ctx context.Context, g *errgroup.Group) error {
+	ln, err := net.Listen("tcp", srv.HealthAddr)
+	if err != nil {
+		return err
+	}
+	ctxlog.L(ctx).Infof("serving health probes on %v", ln.Addr())
+	hs := &http.Server{Handler: srv.HealthHandler()}
+	g.Go(func() error {
+		<-ctx.Done()
+		return hs.Close()
+	})
+	g.Go(func() error {
+		if err := hs.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			ctxlog.L(ctx).Errorf("failed to serve health probes: %v", err)
+			return err
+		}
+		return nil
+	})
+	return nil
+}
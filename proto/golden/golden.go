@@ -0,0 +1,293 @@
+// Package golden holds test vectors for the pvAccess wire format: a message of every type, and the bytes
+// that it is encoded to in golden files, so that changes to the encoding that would break interoperability
+// with peers are caught, and so that forks can check that they still speak the same protocol.
+//
+// The golden files for Vectors are embedded as Files. Check verifies an encoder against them:
+//
+//	for _, err := range golden.Check(golden.Files(), golden.Vectors()) {
+//		t.Error(err)
+//	}
+//
+// A golden file is text: lines starting with "#" are comments, and the remaining lines are the message's
+// header and then its payload, in hex. After an intended change to the wire format, the files are
+// regenerated with "go test ./proto/golden -update", and the diff shows each changed byte.
+package golden
+
+import (
+	"bufio"
+	"bytes"
+	"embed"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"reflect"
+	"strings"
+
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+// Version is the protocol version in the headers of the golden files.
+const Version = 2
+
+//go:embed vectors
+var files embed.FS
+
+// Files returns the golden files of Vectors, named as by FileName.
+func Files() fs.FS {
+	sub, err := fs.Sub(files, "vectors")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+// Vector is a message and how it is sent.
+type Vector struct {
+	// Name identifies the vector, and names its golden file.
+	Name    string
+	Command pvdata.PVByte
+	// Control is set for control messages, which have no payload; their header's payload size is Argument.
+	Control  bool
+	Argument pvdata.PVInt
+	// FromServer and BigEndian set the header's flags.
+	FromServer bool
+	BigEndian  bool
+	// Payload is the payload of an application message: a value that pvdata.Encode accepts,
+	// such as a *proto.SearchRequest, or a []byte for raw payloads such as echoes.
+	Payload interface{}
+	// New returns a value to decode the payload into. Nil selects a new value of Payload's type; payloads with a
+	// pvdata.PVStructureDiff need one whose Value is prepopulated, as described by the proto package.
+	New func() interface{}
+}
+
+// FileName returns the name of v's golden file.
+func (v Vector) FileName() string {
+	return v.Name + ".golden"
+}
+
+// header returns the header of v's message, with the given payload size unless v is a control message.
+func (v Vector) header(payloadSize int) proto.PVAccessHeader {
+	h := proto.PVAccessHeader{
+		Version:        Version,
+		Flags:          proto.FLAG_MSG_APP,
+		MessageCommand: v.Command,
+		PayloadSize:    pvdata.PVInt(payloadSize),
+	}
+	if v.Control {
+		h.Flags = proto.FLAG_MSG_CTRL
+		h.PayloadSize = v.Argument
+	}
+	if v.FromServer {
+		h.Flags |= proto.FLAG_FROM_SERVER
+	}
+	if v.BigEndian {
+		h.Flags |= proto.FLAG_BO_BE
+	}
+	return h
+}
+
+func (v Vector) byteOrder() binary.ByteOrder {
+	if v.BigEndian {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// Encode returns v's message as it is sent on a connection: its header followed by its payload.
+func (v Vector) Encode() ([]byte, error) {
+	return v.encode(v.Payload)
+}
+
+// encode returns v's message with the given payload.
+func (v Vector) encode(payload interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if !v.Control {
+		if err := encodePayload(&pvdata.EncoderState{Buf: &buf, ByteOrder: v.byteOrder()}, payload); err != nil {
+			return nil, fmt.Errorf("encoding %s payload: %w", v.Name, err)
+		}
+	}
+	h := v.header(buf.Len())
+	var msg bytes.Buffer
+	if err := h.PVEncode(&pvdata.EncoderState{Buf: &msg, ByteOrder: v.byteOrder()}); err != nil {
+		return nil, fmt.Errorf("encoding %s header: %w", v.Name, err)
+	}
+	msg.Write(buf.Bytes())
+	return msg.Bytes(), nil
+}
+
+func encodePayload(s *pvdata.EncoderState, payload interface{}) error {
+	switch p := payload.(type) {
+	case []byte:
+		_, err := s.Buf.Write(p)
+		return err
+	case *[]byte:
+		_, err := s.Buf.Write(*p)
+		return err
+	}
+	return pvdata.Encode(s, payload)
+}
+
+// headerSize is the size of a pvAccess message header.
+const headerSize = 8
+
+// EncodeToGolden encodes v and writes it to w as a golden file.
+func EncodeToGolden(w io.Writer, v Vector) error {
+	data, err := v.Encode()
+	if err != nil {
+		return err
+	}
+	h := v.header(len(data) - headerSize)
+	from := "client"
+	if v.FromServer {
+		from = "server"
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# %s: %s from %s, protocol version %d, %s\n", v.Name, h.CommandName(), from, Version, v.byteOrder())
+	fmt.Fprintf(bw, "%s\n", hex.EncodeToString(data[:headerSize]))
+	for p := data[headerSize:]; len(p) > 0; {
+		n := len(p)
+		if n > 16 {
+			n = 16
+		}
+		fmt.Fprintf(bw, "%s\n", hex.EncodeToString(p[:n]))
+		p = p[n:]
+	}
+	return bw.Flush()
+}
+
+// readGolden returns the bytes of the message in a golden file.
+func readGolden(r io.Reader) ([]byte, error) {
+	var data []byte
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		b, err := hex.DecodeString(strings.Join(strings.Fields(text), ""))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		data = append(data, b...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(data) < headerSize {
+		return nil, fmt.Errorf("message of %d bytes is shorter than a header", len(data))
+	}
+	return data, nil
+}
+
+// DecodeFromGolden reads a golden file and decodes the payload of its message into out, which is a pointer
+// as accepted by pvdata.Decode, or a *[]byte for raw payloads, and is ignored for control messages.
+// It returns the message's header. Payloads that aren't decoded completely are an error.
+func DecodeFromGolden(r io.Reader, out interface{}) (proto.PVAccessHeader, error) {
+	data, err := readGolden(r)
+	if err != nil {
+		return proto.PVAccessHeader{}, err
+	}
+	return decode(data, out)
+}
+
+func decode(data []byte, out interface{}) (proto.PVAccessHeader, error) {
+	var h proto.PVAccessHeader
+	buf := bytes.NewReader(data)
+	s := &pvdata.DecoderState{Buf: buf}
+	if err := h.PVDecode(s); err != nil {
+		return h, fmt.Errorf("decoding header: %w", err)
+	}
+	if h.IsControl() {
+		if buf.Len() > 0 {
+			return h, fmt.Errorf("control message has %d bytes of payload", buf.Len())
+		}
+		return h, nil
+	}
+	if int(h.PayloadSize) != buf.Len() {
+		return h, fmt.Errorf("header has payload size %d, but the payload has %d bytes", h.PayloadSize, buf.Len())
+	}
+	if p, ok := out.(*[]byte); ok {
+		*p = append([]byte{}, data[headerSize:]...)
+		return h, nil
+	}
+	if err := pvdata.Decode(s, out); err != nil {
+		return h, fmt.Errorf("decoding %s payload at offset %d: %w", h.CommandName(), int(h.PayloadSize)-buf.Len(), err)
+	}
+	if buf.Len() > 0 {
+		return h, fmt.Errorf("decoding %s payload left %d of %d bytes", h.CommandName(), buf.Len(), h.PayloadSize)
+	}
+	return h, nil
+}
+
+// newValue returns a value to decode v's payload into.
+func (v Vector) newValue() interface{} {
+	if v.New != nil {
+		return v.New()
+	}
+	if _, ok := v.Payload.([]byte); ok {
+		return new([]byte)
+	}
+	t := reflect.TypeOf(v.Payload)
+	if t == nil {
+		return nil
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return reflect.New(t).Interface()
+}
+
+// Check checks each vector against its golden file in fsys: the vector must encode to the file's bytes,
+// and decoding the file's message and encoding it again must give the same bytes, so that decoding
+// loses nothing. It returns an error for each vector that fails.
+func Check(fsys fs.FS, vectors []Vector) []error {
+	var errs []error
+	for _, v := range vectors {
+		if err := check(fsys, v); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", v.Name, err))
+		}
+	}
+	return errs
+}
+
+func check(fsys fs.FS, v Vector) error {
+	f, err := fsys.Open(v.FileName())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	want, err := readGolden(f)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", v.FileName(), err)
+	}
+	got, err := v.Encode()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, want) {
+		return fmt.Errorf("encodes to\n%s\nwant\n%s", hex.Dump(got), hex.Dump(want))
+	}
+	out := v.newValue()
+	h, err := decode(want, out)
+	if err != nil {
+		return err
+	}
+	if h != v.header(len(want)-headerSize) {
+		return fmt.Errorf("decoded header %+v, want %+v", h, v.header(len(want)-headerSize))
+	}
+	if v.Control {
+		return nil
+	}
+	again, err := v.encode(out)
+	if err != nil {
+		return fmt.Errorf("encoding decoded payload: %w", err)
+	}
+	if !bytes.Equal(again, want) {
+		return errors.New("decoding and encoding again changes the payload to\n" + hex.Dump(again[headerSize:]))
+	}
+	return nil
+}
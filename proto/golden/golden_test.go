@@ -0,0 +1,93 @@
+package golden
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/google/go-cmp/cmp"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+func TestGolden(t *testing.T) {
+	if *update {
+		for _, v := range Vectors() {
+			var buf bytes.Buffer
+			if err := EncodeToGolden(&buf, v); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join("vectors", v.FileName()), buf.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return
+	}
+	for _, err := range Check(Files(), Vectors()) {
+		t.Error(err)
+	}
+}
+
+func TestVectorsNamed(t *testing.T) {
+	names := make(map[string]bool)
+	for _, v := range Vectors() {
+		if names[v.Name] {
+			t.Errorf("two vectors are named %q", v.Name)
+		}
+		names[v.Name] = true
+	}
+	entries, err := os.ReadDir("vectors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if name := strings.TrimSuffix(e.Name(), ".golden"); !names[name] {
+			t.Errorf("golden file %s has no vector", e.Name())
+		}
+	}
+}
+
+func TestDecodeFromGolden(t *testing.T) {
+	var got proto.CancelDestroyRequest
+	h, err := DecodeFromGolden(strings.NewReader("# comment\n\nca02000f 08000000\n01010000 07000000\n"), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.MessageCommand != proto.APP_REQUEST_DESTROY || h.PayloadSize != 8 {
+		t.Errorf("header = %+v, want REQUEST_DESTROY with 8 bytes of payload", h)
+	}
+	if diff := cmp.Diff(proto.CancelDestroyRequest{ServerChannelID: 0x101, RequestID: 7}, got); diff != "" {
+		t.Errorf("payload differs (-want +got):\n%s", diff)
+	}
+
+	for _, tc := range []struct {
+		name, file string
+	}{
+		{"short header", "ca02000f\n"},
+		{"bad hex", "ca02000f 0800000x\n"},
+		{"bad magic", "cb02000f 08000000 01010000 07000000\n"},
+		{"short payload", "ca02000f 08000000 01010000\n"},
+		{"leftover payload", "ca02000f 0c000000 01010000 07000000 00000000\n"},
+		{"control payload", "ca010003 00000000 00\n"},
+	} {
+		if _, err := DecodeFromGolden(strings.NewReader(tc.file), &got); err == nil {
+			t.Errorf("%s: DecodeFromGolden succeeded", tc.name)
+		}
+	}
+}
+
+func TestCheckMismatch(t *testing.T) {
+	v := Vectors()[0]
+	v.Payload.(*proto.BeaconMessage).BeaconSequenceID++
+	if errs := Check(Files(), []Vector{v}); len(errs) != 1 {
+		t.Errorf("Check of a changed vector returned %v, want one error", errs)
+	}
+	v.Name = "missing"
+	if errs := Check(Files(), []Vector{v}); len(errs) != 1 {
+		t.Errorf("Check of a vector without a golden file returned %v, want one error", errs)
+	}
+}
@@ -0,0 +1,461 @@
+package golden
+
+import (
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+// scalar is the value of the channel in the vectors' channel operations.
+type scalar struct {
+	Value     pvdata.PVDouble `pvaccess:"value"`
+	Alarm     pvdata.Alarm    `pvaccess:"alarm"`
+	TimeStamp pvdata.Time     `pvaccess:"timeStamp"`
+}
+
+func (scalar) TypeID() string {
+	return "epics:nt/NTScalar:1.0"
+}
+
+// pvRequest is the pvRequest "field(value)".
+type pvRequest struct {
+	Field struct {
+		Value struct{} `pvaccess:"value"`
+	} `pvaccess:"field"`
+}
+
+// rpcArgs and rpcResult are the argument and result of the vectors' RPC.
+type rpcArgs struct {
+	A pvdata.PVInt `pvaccess:"a"`
+	B pvdata.PVInt `pvaccess:"b"`
+}
+
+type rpcResult struct {
+	Value pvdata.PVLong `pvaccess:"value"`
+}
+
+// The identifiers shared by the vectors.
+var (
+	guid = [12]byte{0x2a, 0x3b, 0x4c, 0x5d, 0x6e, 0x7f, 0x80, 0x91, 0xa2, 0xb3, 0xc4, 0xd5}
+	// localhost is 127.0.0.1 as an IPv4-mapped IPv6 address.
+	localhost = [16]byte{10: 0xff, 11: 0xff, 12: 127, 15: 1}
+)
+
+const (
+	clientChannelID = 1
+	serverChannelID = 0x101
+	requestID       = 7
+)
+
+// sample returns the channel's value, with the given fields selected by the changed bit set.
+func sample(bits ...int) pvdata.PVStructureDiff {
+	return pvdata.PVStructureDiff{
+		ChangedBitSet: pvdata.NewBitSetWithBits(bits...),
+		Value: &scalar{
+			Value:     2.5,
+			Alarm:     pvdata.Alarm{Severity: 1, Status: 1, Message: "HIGH"},
+			TimeStamp: pvdata.Time{Time: time.Unix(1700000000, 123456789), UserTag: 3},
+		},
+	}
+}
+
+// emptySample returns a diff to decode a sample into.
+func emptySample() pvdata.PVStructureDiff {
+	return pvdata.PVStructureDiff{Value: &scalar{}}
+}
+
+// scalarDesc returns the introspection data of the channel's value.
+func scalarDesc() pvdata.FieldDesc {
+	pvs, err := pvdata.NewPVStructure(&scalar{})
+	if err != nil {
+		panic(err)
+	}
+	fd, err := pvs.FieldDesc()
+	if err != nil {
+		panic(err)
+	}
+	return fd
+}
+
+// Vectors returns the test vectors: at least one message of every type of application and control message
+// that go-pvaccess sends or receives, with the subcommands of each channel operation.
+// CHANNEL_ARRAY, CHANNEL_PROCESS, CHANNEL_INTROSPECTION and MESSAGE have no vectors, as they aren't implemented.
+func Vectors() []Vector {
+	ok := pvdata.PVStatus{}
+	return []Vector{
+		{
+			Name:       "beacon",
+			Command:    proto.APP_BEACON,
+			FromServer: true,
+			Payload: &proto.BeaconMessage{
+				GUID:             guid,
+				BeaconSequenceID: 5,
+				ChangeCount:      2,
+				ServerAddress:    localhost,
+				ServerPort:       5075,
+				Protocol:         "tcp",
+			},
+		},
+		{
+			Name:       "connection_validation_request",
+			Command:    proto.APP_CONNECTION_VALIDATION,
+			FromServer: true,
+			Payload: &proto.ConnectionValidationRequest{
+				ServerReceiveBufferSize:            16384,
+				ServerIntrospectionRegistryMaxSize: 0x7fff,
+				AuthNZ:                             []string{"anonymous", "ca"},
+			},
+		},
+		{
+			Name:    "connection_validation_response",
+			Command: proto.APP_CONNECTION_VALIDATION,
+			Payload: &proto.ConnectionValidationResponse{
+				ClientReceiveBufferSize:            16384,
+				ClientIntrospectionRegistryMaxSize: 0x7fff,
+				ConnectionQos:                      proto.QOS_MULTIPLE_DATA,
+				AuthNZ:                             "ca",
+				Data: pvdata.NewPVAny(&struct {
+					User pvdata.PVString `pvaccess:"user"`
+					Host pvdata.PVString `pvaccess:"host"`
+				}{"operator", "console"}),
+			},
+		},
+		{
+			Name:       "connection_validated",
+			Command:    proto.APP_CONNECTION_VALIDATED,
+			FromServer: true,
+			Payload:    &proto.ConnectionValidated{Status: ok},
+		},
+		{
+			Name:       "connection_validated_error",
+			Command:    proto.APP_CONNECTION_VALIDATED,
+			FromServer: true,
+			Payload:    &proto.ConnectionValidated{Status: pvdata.PVStatus{Type: pvdata.PVStatus_ERROR, Message: "access denied"}},
+		},
+		{
+			Name:    "echo",
+			Command: proto.APP_ECHO,
+			Payload: []byte("ping"),
+		},
+		{
+			Name:    "search_request",
+			Command: proto.APP_SEARCH_REQUEST,
+			Payload: &proto.SearchRequest{
+				SearchSequenceID: 11,
+				Flags:            proto.SEARCH_REPLY_REQUIRED | proto.SEARCH_UNICAST,
+				ResponseAddress:  localhost,
+				ResponsePort:     5076,
+				Protocols:        []pvdata.PVString{"tcp"},
+				Channels: []proto.SearchRequest_Channel{
+					{SearchInstanceID: 1, ChannelName: "dev:x"},
+					{SearchInstanceID: 2, ChannelName: "dev:y"},
+				},
+			},
+		},
+		{
+			Name:      "search_request_big_endian",
+			Command:   proto.APP_SEARCH_REQUEST,
+			BigEndian: true,
+			Payload: &proto.SearchRequest{
+				SearchSequenceID: 12,
+				ResponseAddress:  localhost,
+				ResponsePort:     5076,
+				Protocols:        []pvdata.PVString{"tcp"},
+				Channels:         []proto.SearchRequest_Channel{{SearchInstanceID: 3, ChannelName: "dev:x"}},
+			},
+		},
+		{
+			Name:       "search_response",
+			Command:    proto.APP_SEARCH_RESPONSE,
+			FromServer: true,
+			Payload: &proto.SearchResponse{
+				GUID:              guid,
+				SearchSequenceID:  11,
+				ServerAddress:     localhost,
+				ServerPort:        5075,
+				Protocol:          "tcp",
+				Found:             true,
+				SearchInstanceIDs: []pvdata.PVUInt{1, 2},
+			},
+		},
+		{
+			Name:    "create_channel_request",
+			Command: proto.APP_CHANNEL_CREATE,
+			Payload: &proto.CreateChannelRequest{
+				Channels: []proto.CreateChannelRequest_Channel{{ClientChannelID: clientChannelID, ChannelName: "dev:x"}},
+			},
+		},
+		{
+			Name:       "create_channel_response",
+			Command:    proto.APP_CHANNEL_CREATE,
+			FromServer: true,
+			Payload: &proto.CreateChannelResponse{
+				ClientChannelID: clientChannelID,
+				ServerChannelID: serverChannelID,
+				Status:          ok,
+				AccessRights:    3,
+			},
+		},
+		{
+			Name:       "create_channel_response_error",
+			Command:    proto.APP_CHANNEL_CREATE,
+			FromServer: true,
+			Payload: &proto.CreateChannelResponse{
+				ClientChannelID: clientChannelID,
+				Status:          pvdata.PVStatus{Type: pvdata.PVStatus_ERROR, Message: "no such channel"},
+			},
+		},
+		{
+			Name:    "destroy_channel_request",
+			Command: proto.APP_CHANNEL_DESTROY,
+			Payload: &proto.DestroyChannel{ServerChannelID: serverChannelID, ClientChannelID: clientChannelID},
+		},
+		{
+			Name:       "destroy_channel_response",
+			Command:    proto.APP_CHANNEL_DESTROY,
+			FromServer: true,
+			Payload:    &proto.DestroyChannel{ServerChannelID: serverChannelID, ClientChannelID: clientChannelID},
+		},
+		{
+			Name:    "channel_get_init",
+			Command: proto.APP_CHANNEL_GET,
+			Payload: &proto.ChannelGetRequest{
+				ServerChannelID: serverChannelID,
+				RequestID:       requestID,
+				Subcommand:      proto.CHANNEL_GET_INIT,
+				PVRequest:       pvdata.NewPVAny(&pvRequest{}),
+			},
+		},
+		{
+			Name:       "channel_get_init_response",
+			Command:    proto.APP_CHANNEL_GET,
+			FromServer: true,
+			Payload: &proto.ChannelGetResponseInit{
+				RequestID:     requestID,
+				Subcommand:    proto.CHANNEL_GET_INIT,
+				Status:        ok,
+				PVStructureIF: scalarDesc(),
+			},
+		},
+		{
+			Name:    "channel_get",
+			Command: proto.APP_CHANNEL_GET,
+			Payload: &proto.ChannelGetRequest{
+				ServerChannelID: serverChannelID,
+				RequestID:       requestID,
+				Subcommand:      proto.CHANNEL_GET_EXECUTE | proto.CHANNEL_GET_DESTROY,
+			},
+		},
+		{
+			Name:       "channel_get_response",
+			Command:    proto.APP_CHANNEL_GET,
+			FromServer: true,
+			Payload: &proto.ChannelGetResponse{
+				RequestID:  requestID,
+				Subcommand: proto.CHANNEL_GET_EXECUTE | proto.CHANNEL_GET_DESTROY,
+				Status:     ok,
+				Value:      sample(0),
+			},
+			New: func() interface{} { return &proto.ChannelGetResponse{Value: emptySample()} },
+		},
+		{
+			Name:       "channel_response_error",
+			Command:    proto.APP_CHANNEL_GET,
+			FromServer: true,
+			Payload: &proto.ChannelResponseError{
+				RequestID:  requestID,
+				Subcommand: proto.CHANNEL_GET_INIT,
+				Status:     pvdata.PVStatus{Type: pvdata.PVStatus_ERROR, Message: "invalid pvRequest", CallTree: "get"},
+			},
+		},
+		{
+			Name:    "channel_put_init",
+			Command: proto.APP_CHANNEL_PUT,
+			Payload: &proto.ChannelPutRequest{
+				ServerChannelID: serverChannelID,
+				RequestID:       requestID,
+				Subcommand:      proto.CHANNEL_PUT_INIT,
+				PVRequest:       pvdata.NewPVAny(&pvRequest{}),
+			},
+		},
+		{
+			Name:       "channel_put_init_response",
+			Command:    proto.APP_CHANNEL_PUT,
+			FromServer: true,
+			Payload: &proto.ChannelPutResponseInit{
+				RequestID:        requestID,
+				Subcommand:       proto.CHANNEL_PUT_INIT,
+				Status:           ok,
+				PVPutStructureIF: scalarDesc(),
+			},
+		},
+		{
+			Name:    "channel_put",
+			Command: proto.APP_CHANNEL_PUT,
+			Payload: &proto.ChannelPutRequest{
+				ServerChannelID: serverChannelID,
+				RequestID:       requestID,
+				Subcommand:      0,
+				Value:           sample(1),
+			},
+			New: func() interface{} { return &proto.ChannelPutRequest{Value: emptySample()} },
+		},
+		{
+			Name:       "channel_put_response",
+			Command:    proto.APP_CHANNEL_PUT,
+			FromServer: true,
+			Payload:    &proto.ChannelPutResponse{RequestID: requestID, Status: ok},
+		},
+		{
+			Name:    "channel_put_get",
+			Command: proto.APP_CHANNEL_PUT,
+			Payload: &proto.ChannelPutRequest{
+				ServerChannelID: serverChannelID,
+				RequestID:       requestID,
+				Subcommand:      proto.CHANNEL_PUT_GET,
+			},
+		},
+		{
+			Name:       "channel_put_get_response",
+			Command:    proto.APP_CHANNEL_PUT,
+			FromServer: true,
+			Payload: &proto.ChannelPutGetResponse{
+				RequestID:  requestID,
+				Subcommand: proto.CHANNEL_PUT_GET,
+				Status:     ok,
+				Value:      sample(0),
+			},
+			New: func() interface{} { return &proto.ChannelPutGetResponse{Value: emptySample()} },
+		},
+		{
+			Name:    "channel_monitor_init",
+			Command: proto.APP_CHANNEL_MONITOR,
+			Payload: &proto.ChannelMonitorRequest{
+				ServerChannelID: serverChannelID,
+				RequestID:       requestID,
+				Subcommand:      proto.CHANNEL_MONITOR_INIT | proto.CHANNEL_MONITOR_PIPELINE_SUPPORT,
+				PVRequest:       pvdata.NewPVAny(&pvRequest{}),
+				NFree:           4,
+				QueueSize:       4,
+			},
+		},
+		{
+			Name:       "channel_monitor_init_response",
+			Command:    proto.APP_CHANNEL_MONITOR,
+			FromServer: true,
+			Payload: &proto.ChannelMonitorResponseInit{
+				RequestID:     requestID,
+				Subcommand:    proto.CHANNEL_MONITOR_INIT,
+				Status:        ok,
+				PVStructureIF: scalarDesc(),
+			},
+		},
+		{
+			Name:    "channel_monitor_start",
+			Command: proto.APP_CHANNEL_MONITOR,
+			Payload: &proto.ChannelMonitorRequest{
+				ServerChannelID: serverChannelID,
+				RequestID:       requestID,
+				Subcommand:      proto.CHANNEL_MONITOR_SUBSCRIPTION | proto.CHANNEL_MONITOR_SUBSCRIPTION_RUN,
+			},
+		},
+		{
+			Name:    "channel_monitor_ack",
+			Command: proto.APP_CHANNEL_MONITOR,
+			Payload: &proto.ChannelMonitorRequest{
+				ServerChannelID: serverChannelID,
+				RequestID:       requestID,
+				Subcommand:      proto.CHANNEL_MONITOR_PIPELINE_SUPPORT,
+				NFree:           2,
+			},
+		},
+		{
+			Name:       "channel_monitor_update",
+			Command:    proto.APP_CHANNEL_MONITOR,
+			FromServer: true,
+			Payload: &proto.ChannelMonitorResponse{
+				RequestID:     requestID,
+				Value:         sample(1, 5),
+				OverrunBitSet: pvdata.NewBitSetWithBits(1),
+			},
+			New: func() interface{} { return &proto.ChannelMonitorResponse{Value: emptySample()} },
+		},
+		{
+			Name:    "channel_monitor_terminate",
+			Command: proto.APP_CHANNEL_MONITOR,
+			Payload: &proto.ChannelMonitorRequest{
+				ServerChannelID: serverChannelID,
+				RequestID:       requestID,
+				Subcommand:      proto.CHANNEL_MONITOR_TERMINATE,
+			},
+		},
+		{
+			Name:    "channel_rpc_init",
+			Command: proto.APP_CHANNEL_RPC,
+			Payload: &proto.ChannelRPCRequest{
+				ServerChannelID: serverChannelID,
+				RequestID:       requestID,
+				Subcommand:      proto.CHANNEL_RPC_INIT,
+				PVRequest:       pvdata.NewPVAny(&struct{}{}),
+			},
+		},
+		{
+			Name:       "channel_rpc_init_response",
+			Command:    proto.APP_CHANNEL_RPC,
+			FromServer: true,
+			Payload:    &proto.ChannelRPCResponseInit{RequestID: requestID, Subcommand: proto.CHANNEL_RPC_INIT, Status: ok},
+		},
+		{
+			Name:    "channel_rpc",
+			Command: proto.APP_CHANNEL_RPC,
+			Payload: &proto.ChannelRPCRequest{
+				ServerChannelID: serverChannelID,
+				RequestID:       requestID,
+				Subcommand:      proto.CHANNEL_RPC_DESTROY,
+				PVRequest:       pvdata.NewPVAny(&rpcArgs{A: 2, B: 3}),
+			},
+		},
+		{
+			Name:       "channel_rpc_response",
+			Command:    proto.APP_CHANNEL_RPC,
+			FromServer: true,
+			Payload: &proto.ChannelRPCResponse{
+				RequestID:      requestID,
+				Subcommand:     proto.CHANNEL_RPC_DESTROY,
+				Status:         ok,
+				PVResponseData: pvdata.NewPVAny(&rpcResult{Value: 5}),
+			},
+		},
+		{
+			Name:    "request_destroy",
+			Command: proto.APP_REQUEST_DESTROY,
+			Payload: &proto.CancelDestroyRequest{ServerChannelID: serverChannelID, RequestID: requestID},
+		},
+		{
+			Name:    "request_cancel",
+			Command: proto.APP_REQUEST_CANCEL,
+			Payload: &proto.CancelDestroyRequest{ServerChannelID: serverChannelID, RequestID: requestID},
+		},
+		{
+			Name:       "multiple_data",
+			Command:    proto.APP_MULTIPLE_DATA,
+			FromServer: true,
+			Payload: &proto.MultipleData{
+				MessageCommand: proto.APP_CHANNEL_MONITOR,
+				Payloads:       [][]byte{{7, 0, 0, 0, 0, 1, 0x02, 0}, {8, 0, 0, 0, 0, 1, 0x02, 0}},
+			},
+		},
+		{
+			Name:    "origin_tag",
+			Command: proto.APP_ORIGIN_TAG,
+			Payload: &proto.OriginTag{ForwarderAddress: localhost},
+		},
+		{Name: "ctrl_mark_total_byte_sent", Command: proto.CTRL_MARK_TOTAL_BYTE_SENT, Control: true, Argument: 65536},
+		{Name: "ctrl_ack_total_byte_sent", Command: proto.CTRL_ACK_TOTAL_BYTE_SENT, Control: true, Argument: 65536},
+		{Name: "ctrl_set_byte_order", Command: proto.CTRL_SET_BYTE_ORDER, Control: true, FromServer: true, BigEndian: true},
+		{Name: "ctrl_echo_request", Command: proto.CTRL_ECHO_REQUEST, Control: true},
+		{Name: "ctrl_echo_response", Command: proto.CTRL_ECHO_RESPONSE, Control: true, FromServer: true},
+		{Name: "ctrl_offer_compression", Command: proto.CTRL_OFFER_COMPRESSION, Control: true, Argument: 1},
+	}
+}
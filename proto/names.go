@@ -0,0 +1,173 @@
+package proto
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+var appCommandNames = map[pvdata.PVByte]string{
+	APP_BEACON:                "BEACON",
+	APP_CONNECTION_VALIDATION: "CONNECTION_VALIDATION",
+	APP_ECHO:                  "ECHO",
+	APP_SEARCH_REQUEST:        "SEARCH_REQUEST",
+	APP_SEARCH_RESPONSE:       "SEARCH_RESPONSE",
+	APP_CHANNEL_CREATE:        "CHANNEL_CREATE",
+	APP_CHANNEL_DESTROY:       "CHANNEL_DESTROY",
+	APP_CONNECTION_VALIDATED:  "CONNECTION_VALIDATED",
+	APP_CHANNEL_GET:           "CHANNEL_GET",
+	APP_CHANNEL_PUT:           "CHANNEL_PUT",
+	APP_CHANNEL_PUT_GET:       "CHANNEL_PUT_GET",
+	APP_CHANNEL_MONITOR:       "CHANNEL_MONITOR",
+	APP_CHANNEL_ARRAY:         "CHANNEL_ARRAY",
+	APP_REQUEST_DESTROY:       "REQUEST_DESTROY",
+	APP_CHANNEL_PROCESS:       "CHANNEL_PROCESS",
+	APP_CHANNEL_INTROSPECTION: "CHANNEL_INTROSPECTION",
+	APP_MESSAGE:               "MESSAGE",
+	APP_MULTIPLE_DATA:         "MULTIPLE_DATA",
+	APP_CHANNEL_RPC:           "CHANNEL_RPC",
+	APP_REQUEST_CANCEL:        "REQUEST_CANCEL",
+	APP_ORIGIN_TAG:            "ORIGIN_TAG",
+}
+
+var ctrlCommandNames = map[pvdata.PVByte]string{
+	CTRL_MARK_TOTAL_BYTE_SENT: "MARK_TOTAL_BYTE_SENT",
+	CTRL_ACK_TOTAL_BYTE_SENT:  "ACK_TOTAL_BYTE_SENT",
+	CTRL_SET_BYTE_ORDER:       "SET_BYTE_ORDER",
+	CTRL_ECHO_REQUEST:         "ECHO_REQUEST",
+	CTRL_ECHO_RESPONSE:        "ECHO_RESPONSE",
+	CTRL_OFFER_COMPRESSION:    "OFFER_COMPRESSION",
+}
+
+// AppCommand is the message command of an application message, e.g. APP_CHANNEL_GET.
+type AppCommand pvdata.PVByte
+
+// String returns the name of the command, e.g. "CHANNEL_GET". Unknown commands are shown in hex.
+func (c AppCommand) String() string {
+	return commandName(appCommandNames, pvdata.PVByte(c))
+}
+
+// CtrlCommand is the message command of a control message, e.g. CTRL_ECHO_REQUEST.
+type CtrlCommand pvdata.PVByte
+
+// String returns the name of the command, e.g. "ECHO_REQUEST". Unknown commands are shown in hex.
+func (c CtrlCommand) String() string {
+	return commandName(ctrlCommandNames, pvdata.PVByte(c))
+}
+
+func commandName(names map[pvdata.PVByte]string, command pvdata.PVByte) string {
+	if name, ok := names[command]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02x", byte(command))
+}
+
+// Flags are the flags of a PVAccessHeader.
+type Flags pvdata.PVUByte
+
+var segmentNames = map[Flags]string{
+	FLAG_SEGMENT_FIRST:  "SEGMENT_FIRST",
+	FLAG_SEGMENT_MIDDLE: "SEGMENT_MIDDLE",
+	FLAG_SEGMENT_LAST:   "SEGMENT_LAST",
+}
+
+// String describes every flag, e.g. "APP|FROM_SERVER|LE" or "CTRL|FROM_CLIENT|BE".
+// Segmentation and compression are only shown when they are set, and reserved bits are shown in hex.
+func (f Flags) String() string {
+	parts := []string{"APP"}
+	if f&FLAG_MSG_CTRL != 0 {
+		parts[0] = "CTRL"
+	}
+	if f&FLAG_COMPRESSED != 0 {
+		parts = append(parts, "COMPRESSED")
+	}
+	if name, ok := segmentNames[f&FLAG_SEGMENT_MASK]; ok {
+		parts = append(parts, name)
+	}
+	if f&FLAG_FROM_SERVER != 0 {
+		parts = append(parts, "FROM_SERVER")
+	} else {
+		parts = append(parts, "FROM_CLIENT")
+	}
+	if f&FLAG_BO_BE != 0 {
+		parts = append(parts, "BE")
+	} else {
+		parts = append(parts, "LE")
+	}
+	if reserved := f &^ (FLAG_MSG_CTRL | FLAG_COMPRESSED | FLAG_SEGMENT_MASK | FLAG_FROM_SERVER | FLAG_BO_BE); reserved != 0 {
+		parts = append(parts, fmt.Sprintf("0x%02x", byte(reserved)))
+	}
+	return strings.Join(parts, "|")
+}
+
+// subcommandBits name the bits of the subcommands of channel requests other than monitors.
+var subcommandBits = []struct {
+	bit  byte
+	name string
+}{
+	{CHANNEL_GET_INIT, "INIT"},
+	{CHANNEL_PUT_GET, "GET"},
+	{CHANNEL_GET_DESTROY, "DESTROY"},
+}
+
+// monitorSubcommandNames name the subcommands of monitor requests, which are not simply combinations of bits.
+var monitorSubcommandNames = map[byte]string{
+	CHANNEL_MONITOR_INIT: "INIT",
+	CHANNEL_MONITOR_INIT | CHANNEL_MONITOR_PIPELINE_SUPPORT:         "INIT|PIPELINE",
+	CHANNEL_MONITOR_SUBSCRIPTION | CHANNEL_MONITOR_SUBSCRIPTION_RUN: "START",
+	CHANNEL_MONITOR_SUBSCRIPTION:                                    "STOP",
+	CHANNEL_MONITOR_PIPELINE_SUPPORT:                                "ACK",
+	CHANNEL_MONITOR_TERMINATE:                                       "DESTROY",
+}
+
+// SubcommandName returns the name of the subcommand of a request or response with the given application command,
+// e.g. "INIT", "GET|DESTROY", or for monitors, "START". It returns "" for a subcommand of 0, which performs a request's
+// operation, and shows bits it can't name in hex. The subcommand is a byte, since it is a PVByte in most requests
+// but a PVUByte in ChannelMonitorRequest.
+func SubcommandName(command pvdata.PVByte, subcommand byte) string {
+	if subcommand == 0 {
+		return ""
+	}
+	if command == APP_CHANNEL_MONITOR {
+		if name, ok := monitorSubcommandNames[subcommand]; ok {
+			return name
+		}
+		return fmt.Sprintf("0x%02x", subcommand)
+	}
+	var parts []string
+	rest := subcommand
+	for _, b := range subcommandBits {
+		if subcommand&b.bit != 0 {
+			parts = append(parts, b.name)
+			rest &^= b.bit
+		}
+	}
+	if rest != 0 {
+		parts = append(parts, fmt.Sprintf("0x%02x", rest))
+	}
+	return strings.Join(parts, "|")
+}
+
+// DescribeCommand returns the name of an application command with its subcommand, e.g. "CHANNEL_MONITOR START"
+// or "CHANNEL_GET", for logs.
+func DescribeCommand(command pvdata.PVByte, subcommand byte) string {
+	if sub := SubcommandName(command, subcommand); sub != "" {
+		return AppCommand(command).String() + " " + sub
+	}
+	return AppCommand(command).String()
+}
+
+// IsControl reports whether the header is for a control message, which has no payload.
+func (h PVAccessHeader) IsControl() bool {
+	return h.Flags&FLAG_MSG_CTRL == FLAG_MSG_CTRL
+}
+
+// CommandName returns the name of the header's message command, e.g. "CHANNEL_GET" or "ECHO_REQUEST",
+// for logging and debugging tools. Unknown commands are shown in hex.
+func (h PVAccessHeader) CommandName() string {
+	if h.IsControl() {
+		return CtrlCommand(h.MessageCommand).String()
+	}
+	return AppCommand(h.MessageCommand).String()
+}
@@ -1,8 +1,21 @@
+// Package proto defines the pvAccess wire protocol: the message header and its flags, the message command constants,
+// and the structures of the request and response payloads, which are encoded and decoded with package pvdata.
+//
+// It is the protocol layer used by go-pvaccess itself, and is exported for tools that need to speak or inspect
+// the protocol directly, such as sniffers, fuzzers, and proxies. Names follow the pvAccess protocol specification
+// and will not change; new messages, flags, and fields may be added.
+//
+// A message is a PVAccessHeader followed by PayloadSize bytes of payload. For application messages (FLAG_MSG_APP)
+// the payload is the structure for the MessageCommand, such as SearchRequest for APP_SEARCH_REQUEST;
+// control messages (FLAG_MSG_CTRL) have no payload, and carry their argument in PayloadSize instead.
 package proto
 
 import (
 	"encoding/binary"
 	"fmt"
+	"io"
+	"strings"
+	"time"
 
 	"github.com/Lexcelon/go-pvaccess/pvdata"
 )
@@ -11,12 +24,18 @@ import (
 
 const MAGIC = 0xCA
 
+// Header flags. See Flags for their names.
 const (
-	FLAG_MSG_APP        = 0
-	FLAG_MSG_CTRL       = 1
-	FLAG_SEGMENT_FIRST  = 0x08
-	FLAG_SEGMENT_LAST   = 0x10
-	FLAG_SEGMENT_MIDDLE = 0x18
+	FLAG_MSG_APP  = 0
+	FLAG_MSG_CTRL = 1
+	// FLAG_COMPRESSED marks a compressed payload. It is a go-pvaccess extension, only sent to peers that offered compression.
+	FLAG_COMPRESSED = 0x02
+	// The segment of a segmented message is FLAG_SEGMENT_MASK of the flags: none, the first, a middle one, or the last.
+	FLAG_SEGMENT_MASK   = 0x30
+	FLAG_SEGMENT_NONE   = 0x00
+	FLAG_SEGMENT_FIRST  = 0x10
+	FLAG_SEGMENT_LAST   = 0x20
+	FLAG_SEGMENT_MIDDLE = 0x30
 	FLAG_FROM_CLIENT    = 0x00
 	FLAG_FROM_SERVER    = 0x40
 	FLAG_BO_LE          = 0x00
@@ -59,6 +78,8 @@ func (v *PVAccessHeader) PVDecode(s *pvdata.DecoderState) error {
 	return v.PayloadSize.PVDecode(s)
 }
 
+// Message commands. They are untyped, so that they can be used as a PVAccessHeader's MessageCommand;
+// convert them to AppCommand or CtrlCommand to print their names.
 const (
 	APP_BEACON                = 0x00
 	APP_CONNECTION_VALIDATION = 0x01
@@ -77,6 +98,7 @@ const (
 	APP_CHANNEL_PROCESS       = 0x10
 	APP_CHANNEL_INTROSPECTION = 0x11
 	APP_MESSAGE               = 0x12
+	APP_MULTIPLE_DATA         = 0x13
 	APP_CHANNEL_RPC           = 0x14
 	APP_REQUEST_CANCEL        = 0x15
 	APP_ORIGIN_TAG            = 0x16
@@ -86,6 +108,9 @@ const (
 	CTRL_SET_BYTE_ORDER       = 0x02
 	CTRL_ECHO_REQUEST         = 0x03
 	CTRL_ECHO_RESPONSE        = 0x04
+	// CTRL_OFFER_COMPRESSION is a go-pvaccess extension announcing that the sender can decompress messages.
+	// The payload size holds the compression algorithm.
+	CTRL_OFFER_COMPRESSION = 0x40
 )
 
 type BeaconMessage struct {
@@ -116,6 +141,17 @@ type ConnectionValidationResponse struct {
 	Data pvdata.PVAny
 }
 
+// Connection QoS bits. The low bits carry the connection's priority.
+const (
+	QOS_PRIORITY_MASK       = 0x007f
+	QOS_PRIORITY_MAX        = 99
+	QOS_LOW_LATENCY         = 0x0100
+	QOS_THROUGHPUT_PRIORITY = 0x0200
+	QOS_ENABLE_COMPRESSION  = 0x0400
+	// QOS_MULTIPLE_DATA is a go-pvaccess extension announcing that the client decodes APP_MULTIPLE_DATA messages.
+	QOS_MULTIPLE_DATA = 0x1000
+)
+
 type ConnectionValidated struct {
 	Status pvdata.PVStatus
 }
@@ -125,8 +161,8 @@ type ConnectionValidated struct {
 // Search
 
 const (
-	SEARCH_REPLY_REQUIRED = 0x80
-	SEARCH_UNICAST        = 0x01
+	SEARCH_REPLY_REQUIRED = 0x01
+	SEARCH_UNICAST        = 0x80
 )
 
 type SearchRequest struct {
@@ -149,6 +185,59 @@ type SearchRequest_Channel struct {
 	ChannelName      string `pvaccess:",bound=500"`
 }
 
+func (r SearchRequest) PVEncode(s *pvdata.EncoderState) error {
+	if err := pvdata.Encode(s, &r.SearchSequenceID, &r.Flags, &r.Reserved, &r.ResponseAddress, &r.ResponsePort, &r.Protocols); err != nil {
+		return err
+	}
+	// Encoded as a PVShort length, without the null markers of a structure array.
+	count := pvdata.PVShort(len(r.Channels))
+	if err := pvdata.Encode(s, &count); err != nil {
+		return err
+	}
+	for _, c := range r.Channels {
+		if err := pvdata.Encode(s, &c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (r *SearchRequest) PVDecode(s *pvdata.DecoderState) error {
+	if err := pvdata.Decode(s, &r.SearchSequenceID, &r.Flags, &r.Reserved, &r.ResponseAddress, &r.ResponsePort, &r.Protocols); err != nil {
+		return err
+	}
+	count, err := decodeChannelCount(s)
+	if err != nil {
+		return err
+	}
+	r.Channels = make([]SearchRequest_Channel, count)
+	for i := range r.Channels {
+		if err := pvdata.Decode(s, &r.Channels[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// minChannelSize is the smallest encoding of a channel in a search or create channel request:
+// a 4-byte ID and a name of at least one byte.
+const minChannelSize = 5
+
+// decodeChannelCount decodes the number of channels in a search or create channel request.
+// Counts that are negative, or too large for the rest of the payload, are rejected before anything is allocated for them.
+func decodeChannelCount(s *pvdata.DecoderState) (int, error) {
+	var count pvdata.PVShort
+	if err := pvdata.Decode(s, &count); err != nil {
+		return 0, err
+	}
+	if count < 0 {
+		return 0, fmt.Errorf("negative channel count %d", count)
+	}
+	if r, ok := s.Buf.(interface{ Len() int }); ok && int(count)*minChannelSize > r.Len() {
+		return 0, fmt.Errorf("%d channels don't fit in the remaining %d bytes", count, r.Len())
+	}
+	return int(count), nil
+}
+
 type SearchResponse struct {
 	GUID              [12]byte
 	SearchSequenceID  pvdata.PVUInt
@@ -183,11 +272,11 @@ func (c CreateChannelRequest) PVEncode(s *pvdata.EncoderState) error {
 	return nil
 }
 func (c *CreateChannelRequest) PVDecode(s *pvdata.DecoderState) error {
-	var count pvdata.PVShort
-	if err := pvdata.Decode(s, &count); err != nil {
+	count, err := decodeChannelCount(s)
+	if err != nil {
 		return err
 	}
-	c.Channels = make([]CreateChannelRequest_Channel, int(count))
+	c.Channels = make([]CreateChannelRequest_Channel, count)
 	for i := range c.Channels {
 		if err := pvdata.Decode(s, &c.Channels[i]); err != nil {
 			return err
@@ -203,6 +292,56 @@ type CreateChannelResponse struct {
 	AccessRights    pvdata.PVShort
 }
 
+// Reasons that a channel couldn't be created. go-pvaccess servers start the message of the error status of a
+// CreateChannelResponse with the reason, if it is one of these, followed by ": " and details, and end it with
+// a retry hint, "[retry]" or e.g. "[retry after 2s]", if the client may succeed by trying again later.
+// They are a go-pvaccess extension: other servers' messages are free-form.
+const (
+	CREATE_CHANNEL_NOT_FOUND         = "channel not found"
+	CREATE_CHANNEL_PERMISSION_DENIED = "permission denied"
+)
+
+// CreateChannelFailure is why a channel couldn't be created, as described by the status message of a CreateChannelResponse.
+type CreateChannelFailure struct {
+	// Reason is CREATE_CHANNEL_NOT_FOUND, CREATE_CHANNEL_PERMISSION_DENIED, or empty if the server didn't say.
+	Reason string
+	// Retry is set if the client may try again, after RetryAfter if it is non-zero.
+	Retry      bool
+	RetryAfter time.Duration
+}
+
+// ParseCreateChannelFailure returns the failure described by the message of a CreateChannelResponse's status.
+func ParseCreateChannelFailure(message string) CreateChannelFailure {
+	var f CreateChannelFailure
+	for _, reason := range []string{CREATE_CHANNEL_NOT_FOUND, CREATE_CHANNEL_PERMISSION_DENIED} {
+		if strings.HasPrefix(message, reason) {
+			f.Reason = reason
+		}
+	}
+	if i := strings.LastIndex(message, "[retry"); i >= 0 {
+		if j := strings.IndexByte(message[i:], ']'); j >= 0 {
+			hint := message[i+len("[retry") : i+j]
+			if hint == "" {
+				f.Retry = true
+			} else if d, err := time.ParseDuration(strings.TrimPrefix(hint, " after ")); err == nil && strings.HasPrefix(hint, " after ") {
+				f.Retry, f.RetryAfter = true, d
+			}
+		}
+	}
+	return f
+}
+
+// RetryHint returns the retry hint for f, preceded by a space, or "" if f isn't retryable.
+func (f CreateChannelFailure) RetryHint() string {
+	if !f.Retry {
+		return ""
+	}
+	if f.RetryAfter > 0 {
+		return fmt.Sprintf(" [retry after %v]", f.RetryAfter)
+	}
+	return " [retry]"
+}
+
 // Destroy Channel
 type DestroyChannel struct {
 	ServerChannelID, ClientChannelID pvdata.PVInt
@@ -222,7 +361,8 @@ type ChannelResponseError struct {
 const (
 	CHANNEL_GET_INIT    = 0x08
 	CHANNEL_GET_DESTROY = 0x10
-	// 0x40 means "get" but is optional
+	// CHANNEL_GET_EXECUTE marks a request that gets the value. It is optional, since any request other than INIT does.
+	CHANNEL_GET_EXECUTE = 0x40
 )
 
 type ChannelGetRequest struct {
@@ -268,10 +408,79 @@ type ChannelGetResponse struct {
 	Value pvdata.PVStructureDiff
 }
 
-// channelGetRequest
-// channelGetResponse
-// channelPutRequestInit
-// channelPutResponseInit
+// Channel Put
+
+const (
+	CHANNEL_PUT_INIT    = 0x08
+	CHANNEL_PUT_DESTROY = 0x10
+	// CHANNEL_PUT_GET requests the current value instead of putting a new one.
+	CHANNEL_PUT_GET = 0x40
+)
+
+type ChannelPutRequest struct {
+	ServerChannelID pvdata.PVInt
+	RequestID       pvdata.PVInt
+	Subcommand      pvdata.PVByte
+	// PVRequest is the requested fields, only present if Subcommand is CHANNEL_PUT_INIT.
+	PVRequest pvdata.PVAny
+	// Value is the partial structure to put, only present if Subcommand is neither CHANNEL_PUT_INIT nor CHANNEL_PUT_GET.
+	// On decode, Value.Value needs to be prepopulated with the struct to decode into; if it is nil, decoding stops before Value.
+	Value pvdata.PVStructureDiff
+}
+
+// IsPut returns true if r carries a value to put.
+func (r ChannelPutRequest) IsPut() bool {
+	return r.Subcommand&(CHANNEL_PUT_INIT|CHANNEL_PUT_GET) == 0
+}
+
+func (r ChannelPutRequest) PVEncode(s *pvdata.EncoderState) error {
+	if err := pvdata.Encode(s, &r.ServerChannelID, &r.RequestID, &r.Subcommand); err != nil {
+		return err
+	}
+	if r.Subcommand&CHANNEL_PUT_INIT == CHANNEL_PUT_INIT {
+		return pvdata.Encode(s, &r.PVRequest)
+	}
+	if r.IsPut() {
+		return pvdata.Encode(s, &r.Value)
+	}
+	return nil
+}
+func (r *ChannelPutRequest) PVDecode(s *pvdata.DecoderState) error {
+	if err := pvdata.Decode(s, &r.ServerChannelID, &r.RequestID, &r.Subcommand); err != nil {
+		return err
+	}
+	if r.Subcommand&CHANNEL_PUT_INIT == CHANNEL_PUT_INIT {
+		return pvdata.Decode(s, &r.PVRequest)
+	}
+	if r.IsPut() && r.Value.Value != nil {
+		return pvdata.Decode(s, &r.Value)
+	}
+	return nil
+}
+
+type ChannelPutResponseInit struct {
+	RequestID        pvdata.PVInt
+	Subcommand       pvdata.PVByte
+	Status           pvdata.PVStatus `pvaccess:",breakonerror"`
+	PVPutStructureIF pvdata.FieldDesc
+}
+
+type ChannelPutResponse struct {
+	RequestID  pvdata.PVInt
+	Subcommand pvdata.PVByte
+	Status     pvdata.PVStatus
+}
+
+// ChannelPutGetResponse is the response to a ChannelPutRequest with CHANNEL_PUT_GET.
+type ChannelPutGetResponse struct {
+	RequestID  pvdata.PVInt
+	Subcommand pvdata.PVByte
+	Status     pvdata.PVStatus `pvaccess:",breakonerror"`
+	// Value is the current value of the channel.
+	// On decode, Value.Value needs to be prepopulated with the struct to decode into.
+	Value pvdata.PVStructureDiff
+}
+
 // channelPutGetRequestInit
 // channelPutGetResponseInit
 // channelArrayRequestInit
@@ -388,6 +597,67 @@ type ChannelMonitorResponse struct {
 	OverrunBitSet pvdata.PVBitSet
 }
 
+// Multiple Data
+
+// MultipleData bundles the payloads of several application messages with the same command, such as monitor
+// updates for different requests, into one APP_MULTIPLE_DATA message. Each payload is preceded by its size.
+type MultipleData struct {
+	MessageCommand pvdata.PVByte
+	Payloads       [][]byte
+}
+
+func (m MultipleData) PVEncode(s *pvdata.EncoderState) error {
+	count := pvdata.PVSize(len(m.Payloads))
+	if err := pvdata.Encode(s, &m.MessageCommand, &count); err != nil {
+		return err
+	}
+	for _, p := range m.Payloads {
+		size := pvdata.PVSize(len(p))
+		if err := pvdata.Encode(s, &size); err != nil {
+			return err
+		}
+		if _, err := s.Buf.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (m *MultipleData) PVDecode(s *pvdata.DecoderState) error {
+	var count pvdata.PVSize
+	if err := pvdata.Decode(s, &m.MessageCommand, &count); err != nil {
+		return err
+	}
+	if count < 0 {
+		return fmt.Errorf("negative payload count %d", count)
+	}
+	m.Payloads = nil
+	for i := pvdata.PVSize(0); i < count; i++ {
+		var size pvdata.PVSize
+		if err := pvdata.Decode(s, &size); err != nil {
+			return err
+		}
+		if size < 0 {
+			return fmt.Errorf("negative payload size %d", size)
+		}
+		// Read in chunks, so that a corrupt size can't allocate more memory than the message holds.
+		var p []byte
+		for remaining := int(size); remaining > 0; {
+			n := remaining
+			if n > 4096 {
+				n = 4096
+			}
+			chunk := make([]byte, n)
+			if _, err := io.ReadFull(s.Buf, chunk); err != nil {
+				return err
+			}
+			p = append(p, chunk...)
+			remaining -= n
+		}
+		m.Payloads = append(m.Payloads, p)
+	}
+	return nil
+}
+
 // Cancel Request and Destroy Request
 type CancelDestroyRequest struct {
 	ServerChannelID pvdata.PVInt
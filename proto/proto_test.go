@@ -0,0 +1,120 @@
+package proto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+func TestHeader(t *testing.T) {
+	for _, test := range []struct {
+		header PVAccessHeader
+		name   string
+	}{
+		{PVAccessHeader{Version: 2, Flags: FLAG_FROM_SERVER, MessageCommand: APP_CHANNEL_GET, PayloadSize: 12}, "CHANNEL_GET"},
+		{PVAccessHeader{Version: 2, Flags: FLAG_MSG_CTRL | FLAG_BO_BE, MessageCommand: CTRL_ECHO_REQUEST, PayloadSize: 7}, "ECHO_REQUEST"},
+		{PVAccessHeader{Version: 2, MessageCommand: 0x7e}, "0x7e"},
+	} {
+		if got := test.header.CommandName(); got != test.name {
+			t.Errorf("CommandName(%+v) = %q, want %q", test.header, got, test.name)
+		}
+		var buf bytes.Buffer
+		order := binary.ByteOrder(binary.LittleEndian)
+		if test.header.Flags&FLAG_BO_BE != 0 {
+			order = binary.BigEndian
+		}
+		if err := test.header.PVEncode(&pvdata.EncoderState{Buf: &buf, ByteOrder: order}); err != nil {
+			t.Fatal(err)
+		}
+		if buf.Len() != 8 {
+			t.Errorf("encoded header is %d bytes, want 8", buf.Len())
+		}
+		var got PVAccessHeader
+		if err := got.PVDecode(&pvdata.DecoderState{Buf: bytes.NewReader(buf.Bytes())}); err != nil {
+			t.Fatal(err)
+		}
+		if got != test.header {
+			t.Errorf("decoded header = %+v, want %+v", got, test.header)
+		}
+	}
+}
+
+func TestNames(t *testing.T) {
+	for _, test := range []struct {
+		got, want string
+	}{
+		{Flags(FLAG_FROM_SERVER | FLAG_BO_BE).String(), "APP|FROM_SERVER|BE"},
+		{Flags(FLAG_MSG_CTRL).String(), "CTRL|FROM_CLIENT|LE"},
+		{Flags(FLAG_SEGMENT_MIDDLE | FLAG_COMPRESSED | 0x04).String(), "APP|COMPRESSED|SEGMENT_MIDDLE|FROM_CLIENT|LE|0x04"},
+		{AppCommand(APP_CHANNEL_RPC).String(), "CHANNEL_RPC"},
+		{CtrlCommand(CTRL_SET_BYTE_ORDER).String(), "SET_BYTE_ORDER"},
+		{CtrlCommand(0x7e).String(), "0x7e"},
+		{DescribeCommand(APP_CHANNEL_MONITOR, CHANNEL_MONITOR_SUBSCRIPTION|CHANNEL_MONITOR_SUBSCRIPTION_RUN), "CHANNEL_MONITOR START"},
+		{DescribeCommand(APP_CHANNEL_MONITOR, CHANNEL_MONITOR_INIT|CHANNEL_MONITOR_PIPELINE_SUPPORT), "CHANNEL_MONITOR INIT|PIPELINE"},
+		{DescribeCommand(APP_CHANNEL_GET, CHANNEL_GET_INIT), "CHANNEL_GET INIT"},
+		{DescribeCommand(APP_CHANNEL_GET, 0), "CHANNEL_GET"},
+		{SubcommandName(APP_CHANNEL_PUT, CHANNEL_PUT_GET|CHANNEL_PUT_DESTROY), "GET|DESTROY"},
+		{SubcommandName(APP_CHANNEL_PUT, 0x01), "0x01"},
+	} {
+		if test.got != test.want {
+			t.Errorf("got %q, want %q", test.got, test.want)
+		}
+	}
+}
+
+func TestParseCreateChannelFailure(t *testing.T) {
+	for _, test := range []struct {
+		message string
+		want    CreateChannelFailure
+	}{
+		{`channel not found: "x"`, CreateChannelFailure{Reason: CREATE_CHANNEL_NOT_FOUND}},
+		{"permission denied", CreateChannelFailure{Reason: CREATE_CHANNEL_PERMISSION_DENIED}},
+		{"booting [retry after 2s]", CreateChannelFailure{Retry: true, RetryAfter: 2 * time.Second}},
+		{"server is shutting down [retry]", CreateChannelFailure{Retry: true}},
+		{`channel not found: "x" [retry after 1.5s]`, CreateChannelFailure{Reason: CREATE_CHANNEL_NOT_FOUND, Retry: true, RetryAfter: 1500 * time.Millisecond}},
+		{"unknown channel", CreateChannelFailure{}},
+		{"bad hint [retry after soon]", CreateChannelFailure{}},
+		{"unterminated [retry", CreateChannelFailure{}},
+	} {
+		got := ParseCreateChannelFailure(test.message)
+		if got != test.want {
+			t.Errorf("ParseCreateChannelFailure(%q) = %+v, want %+v", test.message, got, test.want)
+		}
+		if test.want.Retry && !strings.HasSuffix(test.message, got.RetryHint()) {
+			t.Errorf("RetryHint of %+v = %q, want a suffix of %q", got, got.RetryHint(), test.message)
+		}
+	}
+}
+
+func TestDecodeChannelCount(t *testing.T) {
+	// encode encodes the fields of a request up to its channels, followed by count.
+	encode := func(fields []interface{}, count pvdata.PVShort) []byte {
+		var buf bytes.Buffer
+		s := &pvdata.EncoderState{Buf: &buf, ByteOrder: binary.LittleEndian}
+		if err := pvdata.Encode(s, append(fields, &count)...); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	var r SearchRequest
+	search := []interface{}{&r.SearchSequenceID, &r.Flags, &r.Reserved, &r.ResponseAddress, &r.ResponsePort, &r.Protocols}
+	for _, test := range []struct {
+		name string
+		req  pvdata.PVField
+		data []byte
+	}{
+		{"negative search", &SearchRequest{}, encode(search, -1)},
+		{"oversized search", &SearchRequest{}, encode(search, 1000)},
+		{"negative create", &CreateChannelRequest{}, encode(nil, -1)},
+		{"oversized create", &CreateChannelRequest{}, encode(nil, 1000)},
+	} {
+		s := &pvdata.DecoderState{Buf: bytes.NewReader(test.data), ByteOrder: binary.LittleEndian}
+		if err := test.req.PVDecode(s); err == nil || !strings.Contains(err.Error(), "channel") {
+			t.Errorf("%s: PVDecode returned %v, want an error about the channel count", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+// Package alarm implements a channel provider serving alarm summary PVs, for building simple alarm trees.
+//
+// A summary PV watches a list of source channels. Its severity is the highest severity of its sources,
+// and it lists the sources that are in alarm, most severe first. Summaries can be sources of other
+// summaries of the same Provider, so that a tree of summaries reports the state of a whole system.
+package alarm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/Lexcelon/go-pvaccess/types"
+)
+
+// Summary configures an alarm summary PV.
+type Summary struct {
+	Name string
+	// Sources are the names of the channels summarized: channels of the Provider's source, or other summaries.
+	// Sources must have an alarm field, like the normative types; sources without one are never in alarm.
+	Sources []string
+}
+
+// SeverityNames are the names of the alarm severities, which are the choices of a summary's value.
+var SeverityNames = []string{"NO_ALARM", "MINOR", "MAJOR", "INVALID", "UNDEFINED"}
+
+// Alarm statuses set by summaries, from the alarm_t status values of the normative types.
+const (
+	alarmStatusNone   pvdata.PVInt = 0
+	alarmStatusRecord pvdata.PVInt = 3
+)
+
+// Offenders is the table of the sources of a summary that are in alarm, with one element per source in each column.
+type Offenders struct {
+	Channel  []pvdata.PVString `pvaccess:"channel"`
+	Severity []pvdata.PVInt    `pvaccess:"severity"`
+	Status   []pvdata.PVInt    `pvaccess:"status"`
+	Message  []pvdata.PVString `pvaccess:"message"`
+}
+
+// Value is the value of a summary PV: an NTEnum of the highest severity of its sources, with their alarms in Offenders.
+type Value struct {
+	// Value selects the summary's severity from SeverityNames.
+	Value     pvdata.Enum  `pvaccess:"value"`
+	Alarm     pvdata.Alarm `pvaccess:"alarm"`
+	TimeStamp pvdata.Time  `pvaccess:"timeStamp"`
+	Offenders Offenders    `pvaccess:"offenders"`
+}
+
+func (Value) TypeID() string {
+	return "epics:nt/NTEnum:1.0"
+}
+
+// Provider is a ChannelProvider serving alarm summary PVs.
+// Sources count as INVALID until Run has received their first value.
+type Provider struct {
+	source types.ChannelProvider
+
+	mu        sync.Mutex
+	summaries map[string]*summary
+}
+
+// New returns a Provider serving summaries, whose sources are channels of source or other summaries.
+func New(source types.ChannelProvider, summaries ...Summary) (*Provider, error) {
+	p := &Provider{source: source, summaries: make(map[string]*summary)}
+	for _, config := range summaries {
+		if config.Name == "" {
+			return nil, errors.New("alarm summary has no name")
+		}
+		if _, ok := p.summaries[config.Name]; ok {
+			return nil, fmt.Errorf("duplicate alarm summary %q", config.Name)
+		}
+		if len(config.Sources) == 0 {
+			return nil, fmt.Errorf("alarm summary %q has no sources", config.Name)
+		}
+		s := &summary{config: config, alarms: make([]pvdata.Alarm, len(config.Sources))}
+		for i := range s.alarms {
+			s.alarms[i] = noValue
+		}
+		s.recompute()
+		p.summaries[config.Name] = s
+	}
+	for _, s := range p.summaries {
+		if err := p.checkCycle(s, map[*summary]bool{}); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// checkCycle returns an error if s is one of its own sources, directly or through other summaries.
+func (p *Provider) checkCycle(s *summary, visiting map[*summary]bool) error {
+	if visiting[s] {
+		return fmt.Errorf("alarm summary %q is its own source", s.config.Name)
+	}
+	visiting[s] = true
+	defer delete(visiting, s)
+	for _, name := range s.config.Sources {
+		if sub, ok := p.summaries[name]; ok {
+			if err := p.checkCycle(sub, visiting); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// noValue is the alarm of a source that has no value yet.
+var noValue = pvdata.Alarm{Severity: pvdata.SeverityInvalid, Status: alarmStatusRecord, Message: "no value"}
+
+// Run keeps the summaries up to date with their sources until ctx is cancelled.
+// Sources that implement neither monitors nor Get are logged and stay INVALID.
+func (p *Provider) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	p.mu.Lock()
+	for _, s := range p.summaries {
+		for i, name := range s.config.Sources {
+			s, i, name := s, i, name
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				p.watch(ctxlog.WithFields(ctx, ctxlog.Fields{"summary": s.config.Name, "source": name}), s, i)
+			}()
+		}
+	}
+	p.mu.Unlock()
+	<-ctx.Done()
+	wg.Wait()
+	return ctx.Err()
+}
+
+func (p *Provider) CreateChannel(ctx context.Context, name string) (types.Channel, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if s, ok := p.summaries[name]; ok {
+		return s, nil
+	}
+	return nil, nil
+}
+
+func (p *Provider) ChannelList(ctx context.Context) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var names []string
+	for name := range p.summaries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// watch updates s with the alarms of source i until ctx is cancelled.
+func (p *Provider) watch(ctx context.Context, s *summary, i int) {
+	name := s.config.Sources[i]
+	var c types.Channel
+	p.mu.Lock()
+	sub, ok := p.summaries[name]
+	p.mu.Unlock()
+	if ok {
+		c = sub
+	} else {
+		var err error
+		if c, err = p.source.CreateChannel(ctx, name); err != nil || c == nil {
+			ctxlog.L(ctx).Warnf("source channel not found: %v", err)
+			s.update(i, pvdata.Alarm{Severity: pvdata.SeverityInvalid, Status: alarmStatusRecord, Message: "channel not found"})
+			return
+		}
+	}
+	req, err := pvdata.NewPVStructure(&struct{}{})
+	if err != nil {
+		ctxlog.L(ctx).Warnf("watching source: %v", err)
+		return
+	}
+	if mc, ok := c.(types.ChannelMonitorCreator); ok {
+		w, err := mc.CreateChannelMonitor(ctx, req)
+		if err != nil {
+			ctxlog.L(ctx).Warnf("monitoring source: %v", err)
+			return
+		}
+		for {
+			v, err := w.Next(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					ctxlog.L(ctx).Warnf("monitoring source: %v", err)
+					s.update(i, pvdata.Alarm{Severity: pvdata.SeverityInvalid, Status: alarmStatusRecord, Message: pvdata.PVString(err.Error())})
+				}
+				return
+			}
+			s.update(i, alarmOf(v))
+		}
+	}
+	if geter, ok := c.(types.ChannelGeter); ok {
+		v, err := geter.ChannelGet(ctx)
+		if err != nil {
+			ctxlog.L(ctx).Warnf("getting source: %v", err)
+			return
+		}
+		s.update(i, alarmOf(v))
+		return
+	}
+	ctxlog.L(ctx).Warnf("source channel supports neither Monitor nor Get")
+}
+
+// alarmOf returns the alarm field of the value v.
+func alarmOf(v interface{}) pvdata.Alarm {
+	m, err := pvdata.ToMap(v)
+	if err != nil {
+		return pvdata.Alarm{Severity: pvdata.SeverityInvalid, Status: alarmStatusRecord, Message: pvdata.PVString(err.Error())}
+	}
+	alarm, ok := m["alarm"].(map[string]interface{})
+	if !ok {
+		return pvdata.Alarm{}
+	}
+	severity, _ := pvdata.IntValue(alarm["severity"])
+	status, _ := pvdata.IntValue(alarm["status"])
+	message, _ := alarm["message"].(string)
+	return pvdata.Alarm{Severity: pvdata.PVInt(severity), Status: pvdata.PVInt(status), Message: pvdata.PVString(message)}
+}
+
+// summary is an alarm summary PV. Its current value is kept in a Snapshot.
+type summary struct {
+	pvaccess.Snapshot
+
+	config Summary
+
+	// mu guards alarms and last.
+	mu sync.Mutex
+	// alarms are the latest alarms of the sources.
+	alarms []pvdata.Alarm
+	// last is the value last stored, without its timestamp.
+	last *Value
+}
+
+func (s *summary) Name() string {
+	return s.config.Name
+}
+
+// update sets the alarm of source i, and updates the summary if it changed.
+func (s *summary) update(i int, alarm pvdata.Alarm) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.alarms[i] = alarm
+	s.recompute()
+}
+
+// recompute stores the summary of the sources' alarms, if it changed. s.mu must be held.
+func (s *summary) recompute() {
+	var order []int
+	for i, alarm := range s.alarms {
+		if alarm.Severity > pvdata.SeverityNoAlarm {
+			order = append(order, i)
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return s.alarms[order[a]].Severity > s.alarms[order[b]].Severity
+	})
+	v := &Value{
+		Value: pvdata.Enum{Choices: SeverityNames},
+		Offenders: Offenders{
+			Channel:  []pvdata.PVString{},
+			Severity: []pvdata.PVInt{},
+			Status:   []pvdata.PVInt{},
+			Message:  []pvdata.PVString{},
+		},
+	}
+	for _, i := range order {
+		alarm := s.alarms[i]
+		v.Offenders.Channel = append(v.Offenders.Channel, pvdata.PVString(s.config.Sources[i]))
+		v.Offenders.Severity = append(v.Offenders.Severity, alarm.Severity)
+		v.Offenders.Status = append(v.Offenders.Status, alarm.Status)
+		v.Offenders.Message = append(v.Offenders.Message, alarm.Message)
+	}
+	switch len(order) {
+	case 0:
+		v.Alarm = pvdata.Alarm{Status: alarmStatusNone}
+	case 1:
+		worst := s.alarms[order[0]]
+		v.Alarm = pvdata.Alarm{
+			Severity: worst.Severity,
+			Status:   alarmStatusRecord,
+			Message:  pvdata.PVString(fmt.Sprintf("%s: %s", s.config.Sources[order[0]], worst.Message)),
+		}
+	default:
+		v.Alarm = pvdata.Alarm{
+			Severity: s.alarms[order[0]].Severity,
+			Status:   alarmStatusRecord,
+			Message:  pvdata.PVString(fmt.Sprintf("%d sources in alarm", len(order))),
+		}
+	}
+	v.Value.Index = v.Alarm.Severity
+	if int(v.Value.Index) >= len(SeverityNames) {
+		v.Value.Index = pvdata.PVInt(len(SeverityNames) - 1)
+	}
+	if s.last != nil && reflect.DeepEqual(s.last, v) {
+		return
+	}
+	s.last = v
+	stored := *v
+	stored.TimeStamp = pvdata.Time{Time: time.Now()}
+	s.Store(&stored)
+}
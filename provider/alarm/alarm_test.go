@@ -0,0 +1,129 @@
+package alarm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/Lexcelon/go-pvaccess/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+// source serves NTScalars with alarms.
+type source map[string]*sourceChannel
+
+type sourceChannel struct {
+	pvaccess.Snapshot
+	name string
+}
+
+func (c *sourceChannel) Name() string {
+	return c.name
+}
+
+func (s source) CreateChannel(ctx context.Context, name string) (types.Channel, error) {
+	if c, ok := s[name]; ok {
+		return c, nil
+	}
+	return nil, nil
+}
+
+func (s source) set(name string, severity pvdata.PVInt, message string) {
+	c, ok := s[name]
+	if !ok {
+		c = &sourceChannel{name: name}
+		s[name] = c
+	}
+	x := pvdata.PVDouble(1)
+	c.Store(&pvdata.NTScalar{
+		Value: &x,
+		Alarm: &pvdata.Alarm{Severity: severity, Message: pvdata.PVString(message)},
+	})
+}
+
+// next waits for the summary's value to satisfy ok.
+func next(ctx context.Context, t *testing.T, w types.Nexter, ok func(v *Value) bool) *Value {
+	t.Helper()
+	for {
+		v, err := w.Next(ctx)
+		if err != nil {
+			t.Fatalf("waiting for summary: %v", err)
+		}
+		if v := v.(*Value); ok(v) {
+			return v
+		}
+	}
+}
+
+func TestSummary(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	src := source{}
+	for _, name := range []string{"a", "b", "c"} {
+		src.set(name, pvdata.SeverityNoAlarm, "")
+	}
+	p, err := New(src,
+		Summary{Name: "sub", Sources: []string{"b", "c"}},
+		Summary{Name: "top", Sources: []string{"a", "sub"}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := p.CreateChannel(ctx, "top")
+	if err != nil || c == nil {
+		t.Fatalf("CreateChannel = %v, %v", c, err)
+	}
+	// Before Run, every source counts as INVALID.
+	initial, err := c.(types.ChannelGeter).ChannelGet(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sev := initial.(*Value).Alarm.Severity; sev != pvdata.SeverityInvalid {
+		t.Errorf("severity before Run = %d, want INVALID", sev)
+	}
+	w, err := c.(types.ChannelMonitorCreator).CreateChannelMonitor(ctx, pvdata.PVStructure{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Run(ctx)
+	next(ctx, t, w, func(v *Value) bool { return v.Alarm.Severity == pvdata.SeverityNoAlarm })
+
+	src.set("c", pvdata.SeverityMinor, "low")
+	v := next(ctx, t, w, func(v *Value) bool { return v.Alarm.Severity == pvdata.SeverityMinor })
+	if v.Value.Index != pvdata.SeverityMinor || v.Alarm.Message != "sub: c: low" {
+		t.Errorf("top = %+v, want MINOR from sub", v)
+	}
+
+	src.set("a", pvdata.SeverityMajor, "high")
+	v = next(ctx, t, w, func(v *Value) bool { return v.Alarm.Severity == pvdata.SeverityMajor })
+	want := Offenders{
+		Channel:  []pvdata.PVString{"a", "sub"},
+		Severity: []pvdata.PVInt{pvdata.SeverityMajor, pvdata.SeverityMinor},
+		Status:   []pvdata.PVInt{0, alarmStatusRecord},
+		Message:  []pvdata.PVString{"high", "c: low"},
+	}
+	if diff := cmp.Diff(want, v.Offenders); diff != "" {
+		t.Errorf("offenders differ (-want +got):\n%s", diff)
+	}
+	if v.Alarm.Message != "2 sources in alarm" {
+		t.Errorf("alarm message = %q, want count of sources", v.Alarm.Message)
+	}
+	if _, err := pvdata.NewPVStructure(v); err != nil {
+		t.Errorf("summary can't be encoded: %v", err)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	for _, summaries := range [][]Summary{
+		{{Sources: []string{"a"}}},
+		{{Name: "x"}},
+		{{Name: "x", Sources: []string{"a"}}, {Name: "x", Sources: []string{"b"}}},
+		{{Name: "x", Sources: []string{"y"}}, {Name: "y", Sources: []string{"x"}}},
+	} {
+		if _, err := New(source{}, summaries...); err == nil {
+			t.Errorf("New(%+v) succeeded", summaries)
+		}
+	}
+}
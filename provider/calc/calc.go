@@ -0,0 +1,290 @@
+// Package calc implements a channel provider serving PVs whose values are computed from other channels,
+// like the calc records of an EPICS IOC.
+//
+// Each PV is an NTScalar double, recomputed whenever one of its inputs updates. Its alarm is the most
+// severe alarm of its inputs, and its timestamp is the latest timestamp of its inputs, so that clients
+// can tell when a computed value is derived from bad or stale data.
+//
+// Inputs are channels of another provider. To compute PVs from channels of remote servers,
+// use a mirror.Provider as the source.
+package calc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/Lexcelon/go-pvaccess/types"
+)
+
+// Func computes the value of a PV from the values of its inputs, in order.
+// If it returns an error, the PV's value is NaN with an INVALID alarm showing the error.
+type Func func(inputs []float64) (float64, error)
+
+// PV configures a computed PV.
+type PV struct {
+	Name string
+	// Inputs are the names of the channels the value is computed from.
+	// Their "value" fields must be numbers, booleans, or enums (whose index is used).
+	Inputs []string
+	// Func computes the value. If it is nil, Expression is evaluated instead.
+	Func Func
+	// Expression computes the value with the syntax described by Expr; the inputs are the variables A, B, C, ...
+	Expression string
+}
+
+// alarmStatusRecord is the alarm_t status of alarms raised by computed PVs themselves.
+const alarmStatusRecord pvdata.PVInt = 3
+
+// Provider is a ChannelProvider serving computed PVs whose inputs are channels of another provider.
+// PVs only have a value once Run has received a value from every input.
+type Provider struct {
+	source types.ChannelProvider
+
+	mu  sync.Mutex
+	pvs map[string]*calcPV
+}
+
+// New returns a Provider serving pvs, whose inputs are channels of source.
+func New(source types.ChannelProvider, pvs ...PV) (*Provider, error) {
+	p := &Provider{source: source, pvs: make(map[string]*calcPV)}
+	for _, pv := range pvs {
+		if pv.Name == "" {
+			return nil, errors.New("computed PV has no name")
+		}
+		if _, ok := p.pvs[pv.Name]; ok {
+			return nil, fmt.Errorf("duplicate computed PV %q", pv.Name)
+		}
+		if len(pv.Inputs) == 0 {
+			return nil, fmt.Errorf("computed PV %q has no inputs", pv.Name)
+		}
+		f := pv.Func
+		if f == nil {
+			if pv.Expression == "" {
+				return nil, fmt.Errorf("computed PV %q has neither a Func nor an Expression", pv.Name)
+			}
+			expr, err := Compile(pv.Expression, len(pv.Inputs))
+			if err != nil {
+				return nil, fmt.Errorf("computed PV %q: %w", pv.Name, err)
+			}
+			f = func(inputs []float64) (float64, error) {
+				return expr.Eval(inputs), nil
+			}
+		}
+		p.pvs[pv.Name] = &calcPV{
+			p:      p,
+			config: pv,
+			f:      f,
+			inputs: make([]input, len(pv.Inputs)),
+		}
+	}
+	return p, nil
+}
+
+// Run keeps the PVs' values up to date with their inputs until ctx is cancelled.
+// Inputs that implement neither monitors nor Get are logged and never get a value.
+func (p *Provider) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	p.mu.Lock()
+	for _, pv := range p.pvs {
+		for i, name := range pv.config.Inputs {
+			pv, i, name := pv, i, name
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				pv.watch(ctxlog.WithFields(ctx, ctxlog.Fields{"pv": pv.config.Name, "input": name}), i)
+			}()
+		}
+	}
+	p.mu.Unlock()
+	<-ctx.Done()
+	wg.Wait()
+	return ctx.Err()
+}
+
+func (p *Provider) CreateChannel(ctx context.Context, name string) (types.Channel, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if pv, ok := p.pvs[name]; ok {
+		return pv, nil
+	}
+	return nil, nil
+}
+
+func (p *Provider) ChannelList(ctx context.Context) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var names []string
+	for name := range p.pvs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// input is the latest value of an input.
+type input struct {
+	valid bool
+	value float64
+	alarm pvdata.Alarm
+	time  time.Time
+}
+
+// calcPV is a computed PV. Its current value is kept in a Snapshot, so that Get and Monitor never wait for inputs to update.
+type calcPV struct {
+	pvaccess.Snapshot
+
+	p      *Provider
+	config PV
+	f      Func
+
+	// mu guards inputs.
+	mu     sync.Mutex
+	inputs []input
+}
+
+func (pv *calcPV) Name() string {
+	return pv.config.Name
+}
+
+// watch updates the PV with the values of input i until ctx is cancelled.
+func (pv *calcPV) watch(ctx context.Context, i int) {
+	c, err := pv.p.source.CreateChannel(ctx, pv.config.Inputs[i])
+	if err != nil || c == nil {
+		ctxlog.L(ctx).Warnf("input channel not found: %v", err)
+		return
+	}
+	req, err := pvdata.NewPVStructure(&struct{}{})
+	if err != nil {
+		ctxlog.L(ctx).Warnf("watching input: %v", err)
+		return
+	}
+	if mc, ok := c.(types.ChannelMonitorCreator); ok {
+		w, err := mc.CreateChannelMonitor(ctx, req)
+		if err != nil {
+			ctxlog.L(ctx).Warnf("monitoring input: %v", err)
+			return
+		}
+		for {
+			v, err := w.Next(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					ctxlog.L(ctx).Warnf("monitoring input: %v", err)
+				}
+				return
+			}
+			pv.update(i, v)
+		}
+	}
+	if geter, ok := c.(types.ChannelGeter); ok {
+		v, err := geter.ChannelGet(ctx)
+		if err != nil {
+			ctxlog.L(ctx).Warnf("getting input: %v", err)
+			return
+		}
+		pv.update(i, v)
+		return
+	}
+	ctxlog.L(ctx).Warnf("input channel supports neither Monitor nor Get")
+}
+
+// update sets the value of input i, and recomputes the PV once every input has a value.
+func (pv *calcPV) update(i int, v interface{}) {
+	in := parseInput(pv.config.Inputs[i], v)
+	pv.mu.Lock()
+	defer pv.mu.Unlock()
+	pv.inputs[i] = in
+	values := make([]float64, len(pv.inputs))
+	alarm := pvdata.Alarm{}
+	var ts time.Time
+	for i, in := range pv.inputs {
+		if !in.valid {
+			return
+		}
+		values[i] = in.value
+		// The most severe alarm wins; of equally severe alarms, the first input's is kept.
+		if in.alarm.Severity > alarm.Severity {
+			alarm = in.alarm
+		}
+		if in.time.After(ts) {
+			ts = in.time
+		}
+	}
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	value, err := pv.f(values)
+	if err != nil {
+		value = math.NaN()
+		alarm = pvdata.Alarm{
+			Severity: pvdata.SeverityInvalid,
+			Status:   alarmStatusRecord,
+			Message:  pvdata.PVString(err.Error()),
+		}
+	}
+	x := pvdata.PVDouble(value)
+	pv.Store(&pvdata.NTScalar{
+		Value:     &x,
+		Alarm:     &alarm,
+		TimeStamp: &pvdata.Time{Time: ts},
+	})
+}
+
+// parseInput returns the value, alarm, and timestamp of the input channel name from its value v.
+// Values that aren't numbers are replaced by NaN with an INVALID alarm.
+func parseInput(name string, v interface{}) input {
+	in := input{valid: true}
+	m, err := pvdata.ToMap(v)
+	if err != nil {
+		return invalidInput(name, err.Error())
+	}
+	value := m["value"]
+	if enum, ok := value.(map[string]interface{}); ok {
+		value = enum["index"]
+	}
+	if x, ok := pvdata.FloatValue(value); ok {
+		in.value = x
+	} else if b, ok := value.(bool); ok {
+		in.value = 0
+		if b {
+			in.value = 1
+		}
+	} else {
+		return invalidInput(name, fmt.Sprintf("value %v is not a number", value))
+	}
+	if alarm, ok := m["alarm"].(map[string]interface{}); ok {
+		severity, _ := pvdata.IntValue(alarm["severity"])
+		status, _ := pvdata.IntValue(alarm["status"])
+		in.alarm = pvdata.Alarm{Severity: pvdata.PVInt(severity), Status: pvdata.PVInt(status)}
+		if message, ok := alarm["message"].(string); ok && message != "" {
+			in.alarm.Message = pvdata.PVString(name + ": " + message)
+		}
+	}
+	if ts, ok := m["timeStamp"].(map[string]interface{}); ok {
+		sec, _ := pvdata.IntValue(ts["secondsPastEpoch"])
+		nsec, _ := pvdata.IntValue(ts["nanoseconds"])
+		if sec != 0 || nsec != 0 {
+			in.time = time.Unix(int64(sec), int64(nsec))
+		}
+	}
+	return in
+}
+
+func invalidInput(name, message string) input {
+	return input{
+		valid: true,
+		value: math.NaN(),
+		alarm: pvdata.Alarm{
+			Severity: pvdata.SeverityInvalid,
+			Status:   alarmStatusRecord,
+			Message:  pvdata.PVString(name + ": " + message),
+		},
+	}
+}
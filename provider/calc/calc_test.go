@@ -0,0 +1,150 @@
+package calc
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/Lexcelon/go-pvaccess/types"
+)
+
+// source serves NTScalars with alarms and timestamps.
+type source map[string]*sourceChannel
+
+type sourceChannel struct {
+	pvaccess.Snapshot
+	name string
+}
+
+func (c *sourceChannel) Name() string {
+	return c.name
+}
+
+func (s source) CreateChannel(ctx context.Context, name string) (types.Channel, error) {
+	if c, ok := s[name]; ok {
+		return c, nil
+	}
+	return nil, nil
+}
+
+func (s source) set(name string, value float64, severity pvdata.PVInt, ts time.Time) {
+	c, ok := s[name]
+	if !ok {
+		c = &sourceChannel{name: name}
+		s[name] = c
+	}
+	x := pvdata.PVDouble(value)
+	c.Store(&pvdata.NTScalar{
+		Value:     &x,
+		Alarm:     &pvdata.Alarm{Severity: severity, Message: "alarm"},
+		TimeStamp: &pvdata.Time{Time: ts},
+	})
+}
+
+type result struct {
+	value    float64
+	severity int
+	message  string
+	time     time.Time
+}
+
+// next waits for the PV's value to satisfy ok.
+func next(ctx context.Context, t *testing.T, w types.Nexter, ok func(r result) bool) result {
+	t.Helper()
+	for {
+		v, err := w.Next(ctx)
+		if err != nil {
+			t.Fatalf("waiting for computed value: %v", err)
+		}
+		m, err := pvdata.ToMap(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r result
+		r.value, _ = pvdata.FloatValue(m["value"])
+		alarm := m["alarm"].(map[string]interface{})
+		r.severity, _ = pvdata.IntValue(alarm["severity"])
+		r.message, _ = alarm["message"].(string)
+		ts := m["timeStamp"].(map[string]interface{})
+		sec, _ := pvdata.IntValue(ts["secondsPastEpoch"])
+		nsec, _ := pvdata.IntValue(ts["nanoseconds"])
+		r.time = time.Unix(int64(sec), int64(nsec))
+		if ok(r) {
+			return r
+		}
+	}
+}
+
+func TestCalc(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	src := source{}
+	t1, t2 := time.Unix(100, 0), time.Unix(200, 0)
+	src.set("a", 1, pvdata.SeverityNoAlarm, t1)
+	src.set("b", 2, pvdata.SeverityMinor, t2)
+	p, err := New(src,
+		PV{Name: "sum", Inputs: []string{"a", "b"}, Expression: "A + B"},
+		PV{Name: "ratio", Inputs: []string{"a", "b"}, Func: func(in []float64) (float64, error) {
+			if in[1] == 0 {
+				return 0, errors.New("division by zero")
+			}
+			return in[0] / in[1], nil
+		}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	monitor := func(name string) types.Nexter {
+		t.Helper()
+		c, err := p.CreateChannel(ctx, name)
+		if err != nil || c == nil {
+			t.Fatalf("CreateChannel(%q) = %v, %v", name, c, err)
+		}
+		w, err := c.(types.ChannelMonitorCreator).CreateChannelMonitor(ctx, pvdata.PVStructure{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+	go p.Run(ctx)
+
+	sum := monitor("sum")
+	r := next(ctx, t, sum, func(r result) bool { return r.value == 3 })
+	if r.severity != int(pvdata.SeverityMinor) || r.message != "b: alarm" || !r.time.Equal(t2) {
+		t.Errorf("sum = %+v, want the alarm of b and the time of b", r)
+	}
+	t3 := time.Unix(300, 0)
+	src.set("a", 10, pvdata.SeverityMajor, t3)
+	r = next(ctx, t, sum, func(r result) bool { return r.value == 12 })
+	if r.severity != int(pvdata.SeverityMajor) || r.message != "a: alarm" || !r.time.Equal(t3) {
+		t.Errorf("sum = %+v, want the alarm of a and the time of a", r)
+	}
+
+	ratio := monitor("ratio")
+	next(ctx, t, ratio, func(r result) bool { return r.value == 5 })
+	src.set("b", 0, pvdata.SeverityNoAlarm, t3)
+	r = next(ctx, t, ratio, func(r result) bool { return math.IsNaN(r.value) })
+	if r.severity != int(pvdata.SeverityInvalid) || r.message != "division by zero" {
+		t.Errorf("ratio = %+v, want INVALID alarm", r)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	for _, pv := range []PV{
+		{Inputs: []string{"a"}, Expression: "A"},
+		{Name: "x", Expression: "1"},
+		{Name: "x", Inputs: []string{"a"}},
+		{Name: "x", Inputs: []string{"a"}, Expression: "A + B"},
+	} {
+		if _, err := New(source{}, pv); err == nil {
+			t.Errorf("New(%+v) succeeded", pv)
+		}
+	}
+	if _, err := New(source{}, PV{Name: "x", Inputs: []string{"a"}, Expression: "A"}, PV{Name: "x", Inputs: []string{"a"}, Expression: "A"}); err == nil {
+		t.Error("New with duplicate PVs succeeded")
+	}
+}
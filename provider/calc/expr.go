@@ -0,0 +1,390 @@
+package calc
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expr is a compiled expression.
+//
+// Expressions use the syntax of C arithmetic, similar to the CALC fields of EPICS calc records:
+//
+//   - numbers, PI, and the variables A, B, C, ..., which are the values of the inputs in order
+//   - operators, from lowest to highest precedence: ?: || && (== != < <= > >=) (+ -) (* / %) (unary - + !) ^
+//   - functions abs, sqrt, exp, ln, log (base 10), sin, cos, tan, asin, acos, atan, atan2, floor, ceil, nint, min, max, isnan
+//
+// Comparisons and logical operators return 1 for true and 0 for false; any non-zero value is true.
+// Names are case-insensitive.
+type Expr struct {
+	src  string
+	eval func(in []float64) float64
+}
+
+// Compile compiles expr, which may refer to the values of the given number of inputs.
+func Compile(expr string, inputs int) (*Expr, error) {
+	p := &parser{src: expr, inputs: inputs}
+	p.next()
+	eval, err := p.ternary()
+	if err == nil && p.tok.kind != tokEOF {
+		err = p.errorf("unexpected %s", p.tok)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("expression %q: %w", expr, err)
+	}
+	return &Expr{src: expr, eval: eval}, nil
+}
+
+// Eval evaluates the expression with the values of its inputs.
+func (e *Expr) Eval(inputs []float64) float64 {
+	return e.eval(inputs)
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokName
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	num  float64
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// parser is a recursive descent parser, with one function for each level of precedence.
+type parser struct {
+	src    string
+	pos    int
+	inputs int
+	tok    token
+	err    error
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("at offset %d: %s", p.tok.pos, fmt.Sprintf(format, args...))
+}
+
+// operators are the operator tokens, longest first.
+var operators = []string{"**", "==", "!=", "<=", ">=", "&&", "||", "+", "-", "*", "/", "%", "^", "<", ">", "!", "?", ":", "(", ")", ","}
+
+// next reads the next token into p.tok.
+func (p *parser) next() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+	start := p.pos
+	if p.pos == len(p.src) {
+		p.tok = token{kind: tokEOF, pos: start}
+		return
+	}
+	c := p.src[p.pos]
+	switch {
+	case c >= '0' && c <= '9' || c == '.':
+		for p.pos < len(p.src) && (isDigit(p.src[p.pos]) || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		if p.pos < len(p.src) && (p.src[p.pos] == 'e' || p.src[p.pos] == 'E') {
+			end := p.pos + 1
+			if end < len(p.src) && (p.src[end] == '+' || p.src[end] == '-') {
+				end++
+			}
+			if end < len(p.src) && isDigit(p.src[end]) {
+				p.pos = end
+				for p.pos < len(p.src) && isDigit(p.src[p.pos]) {
+					p.pos++
+				}
+			}
+		}
+		text := p.src[start:p.pos]
+		num, err := strconv.ParseFloat(text, 64)
+		if err != nil && p.err == nil {
+			p.err = fmt.Errorf("at offset %d: invalid number %q", start, text)
+		}
+		p.tok = token{kind: tokNumber, text: text, num: num, pos: start}
+		return
+	case c == '_' || unicode.IsLetter(rune(c)):
+		for p.pos < len(p.src) && (p.src[p.pos] == '_' || isDigit(p.src[p.pos]) || unicode.IsLetter(rune(p.src[p.pos]))) {
+			p.pos++
+		}
+		p.tok = token{kind: tokName, text: p.src[start:p.pos], pos: start}
+		return
+	}
+	for _, op := range operators {
+		if strings.HasPrefix(p.src[p.pos:], op) {
+			p.pos += len(op)
+			p.tok = token{kind: tokOp, text: op, pos: start}
+			return
+		}
+	}
+	p.pos++
+	p.tok = token{kind: tokOp, text: p.src[start:p.pos], pos: start}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// accept consumes the current token if it is the operator op.
+func (p *parser) accept(op string) bool {
+	if p.tok.kind == tokOp && p.tok.text == op {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(op string) error {
+	if !p.accept(op) {
+		return p.errorf("expected %q, found %s", op, p.tok)
+	}
+	return nil
+}
+
+type evalFunc = func(in []float64) float64
+
+func truth(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (p *parser) ternary() (evalFunc, error) {
+	cond, err := p.binary(0)
+	if err != nil || !p.accept("?") {
+		return cond, err
+	}
+	a, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	b, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	return func(in []float64) float64 {
+		if cond(in) != 0 {
+			return a(in)
+		}
+		return b(in)
+	}, nil
+}
+
+// binaryOps are the binary operators at each level of precedence, from lowest to highest.
+var binaryOps = []map[string]func(a, b float64) float64{
+	{"||": func(a, b float64) float64 { return truth(a != 0 || b != 0) }},
+	{"&&": func(a, b float64) float64 { return truth(a != 0 && b != 0) }},
+	{
+		"==": func(a, b float64) float64 { return truth(a == b) },
+		"!=": func(a, b float64) float64 { return truth(a != b) },
+		"<":  func(a, b float64) float64 { return truth(a < b) },
+		"<=": func(a, b float64) float64 { return truth(a <= b) },
+		">":  func(a, b float64) float64 { return truth(a > b) },
+		">=": func(a, b float64) float64 { return truth(a >= b) },
+	},
+	{
+		"+": func(a, b float64) float64 { return a + b },
+		"-": func(a, b float64) float64 { return a - b },
+	},
+	{
+		"*": func(a, b float64) float64 { return a * b },
+		"/": func(a, b float64) float64 { return a / b },
+		"%": math.Mod,
+	},
+}
+
+// binary parses the left-associative binary operators at and above the given level of precedence.
+func (p *parser) binary(level int) (evalFunc, error) {
+	if level == len(binaryOps) {
+		return p.unary()
+	}
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokOp {
+		op, ok := binaryOps[level][p.tok.text]
+		if !ok {
+			break
+		}
+		p.next()
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(in []float64) float64 { return op(l(in), right(in)) }
+	}
+	return left, nil
+}
+
+func (p *parser) unary() (evalFunc, error) {
+	switch {
+	case p.accept("-"):
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(in []float64) float64 { return -x(in) }, nil
+	case p.accept("+"):
+		return p.unary()
+	case p.accept("!"):
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(in []float64) float64 { return truth(x(in) == 0) }, nil
+	}
+	return p.power()
+}
+
+// power parses exponentiation, which is right-associative and binds more tightly than unary minus on its left.
+func (p *parser) power() (evalFunc, error) {
+	base, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	if !p.accept("^") && !p.accept("**") {
+		return base, nil
+	}
+	exp, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	return func(in []float64) float64 { return math.Pow(base(in), exp(in)) }, nil
+}
+
+// functions are the functions that can be called in expressions, by number of arguments; -1 means any number.
+var functions = map[string]struct {
+	args int
+	f    func(args []float64) float64
+}{
+	"abs":   {1, func(a []float64) float64 { return math.Abs(a[0]) }},
+	"sqrt":  {1, func(a []float64) float64 { return math.Sqrt(a[0]) }},
+	"exp":   {1, func(a []float64) float64 { return math.Exp(a[0]) }},
+	"ln":    {1, func(a []float64) float64 { return math.Log(a[0]) }},
+	"log":   {1, func(a []float64) float64 { return math.Log10(a[0]) }},
+	"sin":   {1, func(a []float64) float64 { return math.Sin(a[0]) }},
+	"cos":   {1, func(a []float64) float64 { return math.Cos(a[0]) }},
+	"tan":   {1, func(a []float64) float64 { return math.Tan(a[0]) }},
+	"asin":  {1, func(a []float64) float64 { return math.Asin(a[0]) }},
+	"acos":  {1, func(a []float64) float64 { return math.Acos(a[0]) }},
+	"atan":  {1, func(a []float64) float64 { return math.Atan(a[0]) }},
+	"atan2": {2, func(a []float64) float64 { return math.Atan2(a[0], a[1]) }},
+	"floor": {1, func(a []float64) float64 { return math.Floor(a[0]) }},
+	"ceil":  {1, func(a []float64) float64 { return math.Ceil(a[0]) }},
+	"nint":  {1, func(a []float64) float64 { return math.Round(a[0]) }},
+	"isnan": {1, func(a []float64) float64 { return truth(math.IsNaN(a[0])) }},
+	"min": {-1, func(a []float64) float64 {
+		m := a[0]
+		for _, x := range a[1:] {
+			m = math.Min(m, x)
+		}
+		return m
+	}},
+	"max": {-1, func(a []float64) float64 {
+		m := a[0]
+		for _, x := range a[1:] {
+			m = math.Max(m, x)
+		}
+		return m
+	}},
+}
+
+func (p *parser) primary() (evalFunc, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	tok := p.tok
+	switch tok.kind {
+	case tokNumber:
+		p.next()
+		return func([]float64) float64 { return tok.num }, nil
+	case tokName:
+		p.next()
+		name := strings.ToLower(tok.text)
+		if fn, ok := functions[name]; ok {
+			return p.call(tok, fn.args, fn.f)
+		}
+		if name == "pi" {
+			return func([]float64) float64 { return math.Pi }, nil
+		}
+		if len(name) == 1 && name[0] >= 'a' && name[0] <= 'z' {
+			i := int(name[0] - 'a')
+			if i >= p.inputs {
+				return nil, fmt.Errorf("at offset %d: variable %s refers to input %d, but there are only %d inputs", tok.pos, tok.text, i+1, p.inputs)
+			}
+			return func(in []float64) float64 { return in[i] }, nil
+		}
+		return nil, fmt.Errorf("at offset %d: unknown name %q", tok.pos, tok.text)
+	case tokOp:
+		if p.accept("(") {
+			x, err := p.ternary()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return x, nil
+		}
+	}
+	return nil, p.errorf("unexpected %s", tok)
+}
+
+// call parses the arguments of a call to the function named by tok.
+func (p *parser) call(tok token, nargs int, f func([]float64) float64) (evalFunc, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var args []evalFunc
+	if !p.accept(")") {
+		for {
+			arg, err := p.ternary()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if p.accept(")") {
+				break
+			}
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+	}
+	switch {
+	case nargs < 0 && len(args) == 0:
+		return nil, fmt.Errorf("at offset %d: %s needs at least one argument", tok.pos, tok.text)
+	case nargs >= 0 && len(args) != nargs:
+		return nil, fmt.Errorf("at offset %d: %s takes %d arguments, not %d", tok.pos, tok.text, nargs, len(args))
+	}
+	return func(in []float64) float64 {
+		values := make([]float64, len(args))
+		for i, arg := range args {
+			values[i] = arg(in)
+		}
+		return f(values)
+	}, nil
+}
@@ -0,0 +1,59 @@
+package calc
+
+import (
+	"math"
+	"testing"
+)
+
+func TestExpr(t *testing.T) {
+	inputs := []float64{1, 2, 3}
+	for _, test := range []struct {
+		expr string
+		want float64
+	}{
+		{"A+B*C", 7},
+		{"(A+B)*C", 9},
+		{"a + b", 3},
+		{"-B^2", -4},
+		{"2^3^2", 512},
+		{"2**-1", 0.5},
+		{"C % B", 1},
+		{"A < B && B < C", 1},
+		{"A > B || !C", 0},
+		{"A == 1 ? B : C", 2},
+		{"A != 1 ? B : C", 3},
+		{"max(A, C, B) - min(C, B)", 1},
+		{"abs(-C) + sqrt(4) + nint(2.5)", 8},
+		{"atan2(0, 1) + floor(1.5) + ceil(1.5)", 3},
+		{"log(100) + ln(exp(1))", 3},
+		{"cos(PI)", -1},
+		{"1e3 + .5 + 2E-1", 1000.7},
+		{"isnan(0/0)", 1},
+	} {
+		e, err := Compile(test.expr, len(inputs))
+		if err != nil {
+			t.Errorf("Compile(%q): %v", test.expr, err)
+			continue
+		}
+		if got := e.Eval(inputs); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%q = %v, want %v", test.expr, got, test.want)
+		}
+	}
+	for _, expr := range []string{
+		"",
+		"A +",
+		"D",
+		"(A",
+		"A B",
+		"foo(A)",
+		"sin(A, B)",
+		"max()",
+		"A ? B",
+		"1.2.3",
+		"A $ B",
+	} {
+		if _, err := Compile(expr, len(inputs)); err == nil {
+			t.Errorf("Compile(%q) succeeded", expr)
+		}
+	}
+}
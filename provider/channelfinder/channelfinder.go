@@ -0,0 +1,135 @@
+// Package channelfinder integrates servers with a ChannelFinder directory, such as the one fed by recsync,
+// so that Go services take part in site-wide channel directories.
+//
+// A Provider serves only the channels of another provider that the directory assigns to this server,
+// and Register publishes the channels a provider hosts.
+package channelfinder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/clock"
+	"github.com/Lexcelon/go-pvaccess/types"
+)
+
+// Provider is a ChannelProvider serving the channels of its source that the directory lists with a property
+// set to a value identifying this server, e.g. "hostName" set to the server's host name. Servers that share a
+// source, or that see every channel in a gateway, then each answer only for their own channels.
+type Provider struct {
+	client          *Client
+	source          types.ChannelProvider
+	property, value string
+
+	// CacheTTL is how long the directory's answer for each name is remembered; errors are not remembered.
+	// Zero selects a default of one minute, and a negative TTL asks the directory every time.
+	// It must be set before the Provider is used.
+	CacheTTL time.Duration
+	// Clock measures CacheTTL. Nil selects the system clock.
+	Clock clock.Clock
+
+	mu        sync.Mutex
+	cache     map[string]ownership
+	nextSweep time.Time
+}
+
+// ownership is a cached answer from the directory.
+type ownership struct {
+	owned   bool
+	expires time.Time
+}
+
+const defaultCacheTTL = time.Minute
+
+// New returns a Provider serving the channels of source that c's directory lists with property set to value.
+func New(c *Client, source types.ChannelProvider, property, value string) (*Provider, error) {
+	switch {
+	case c == nil || c.URL == "":
+		return nil, errors.New("channelfinder provider has no directory URL")
+	case source == nil:
+		return nil, errors.New("channelfinder provider has no source")
+	case property == "":
+		return nil, errors.New("channelfinder provider has no property")
+	}
+	return &Provider{client: c, source: source, property: property, value: value, cache: make(map[string]ownership)}, nil
+}
+
+// Owns reports whether the directory assigns the channel called name to this server.
+func (p *Provider) Owns(ctx context.Context, name string) (bool, error) {
+	ttl := p.CacheTTL
+	if ttl == 0 {
+		ttl = defaultCacheTTL
+	}
+	now := clock.Or(p.Clock).Now()
+	p.mu.Lock()
+	cached, ok := p.cache[name]
+	p.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.owned, nil
+	}
+	ch, err := p.client.Channel(ctx, name)
+	if err != nil {
+		return false, fmt.Errorf("looking up %q in the channel directory: %w", name, err)
+	}
+	owned := false
+	if ch != nil {
+		value, ok := ch.Property(p.property)
+		owned = ok && value == p.value
+	}
+	if ttl > 0 {
+		p.mu.Lock()
+		p.cache[name] = ownership{owned: owned, expires: now.Add(ttl)}
+		// Drop expired answers once per TTL, so that names that are never asked for again don't pile up.
+		if !now.Before(p.nextSweep) {
+			for n, o := range p.cache {
+				if !now.Before(o.expires) {
+					delete(p.cache, n)
+				}
+			}
+			p.nextSweep = now.Add(ttl)
+		}
+		p.mu.Unlock()
+	}
+	return owned, nil
+}
+
+func (p *Provider) CreateChannel(ctx context.Context, name string) (types.Channel, error) {
+	if owned, err := p.Owns(ctx, name); err != nil || !owned {
+		return nil, err
+	}
+	return p.source.CreateChannel(ctx, name)
+}
+
+// ChannelFind reports whether the directory assigns the channel to this server and the source has it,
+// asking the source's ChannelFind if it has one.
+func (p *Provider) ChannelFind(ctx context.Context, name string) (bool, error) {
+	if owned, err := p.Owns(ctx, name); err != nil || !owned {
+		return false, err
+	}
+	if f, ok := p.source.(types.ChannelFinder); ok {
+		return f.ChannelFind(ctx, name)
+	}
+	c, err := p.source.CreateChannel(ctx, name)
+	return c != nil, err
+}
+
+// Register records every channel listed by source in c's directory, with the given properties,
+// e.g. "hostName" set to the server's host name for a Provider to find. Entries for channels the source
+// no longer lists are left in the directory.
+func Register(ctx context.Context, c *Client, source types.ChannelLister, properties ...Property) error {
+	names, err := source.ChannelList(ctx)
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	channels := make([]Channel, len(names))
+	for i, name := range names {
+		channels[i] = Channel{Name: name, Properties: properties}
+	}
+	return c.Update(ctx, channels)
+}
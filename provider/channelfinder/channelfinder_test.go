@@ -0,0 +1,163 @@
+package channelfinder
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+	"github.com/Lexcelon/go-pvaccess/clock"
+	"github.com/Lexcelon/go-pvaccess/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+// directory is a ChannelFinder service holding channels in memory.
+type directory struct {
+	mu       sync.Mutex
+	channels map[string]Channel
+	lookups  int
+}
+
+func (d *directory) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch {
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/resources/channels/"):
+		d.lookups++
+		ch, ok := d.channels[strings.TrimPrefix(r.URL.Path, "/resources/channels/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(ch)
+	case r.Method == http.MethodPut && r.URL.Path == "/resources/channels":
+		if user, password, ok := r.BasicAuth(); !ok || user != "admin" || password != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var chs []Channel
+		if err := json.NewDecoder(r.Body).Decode(&chs); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, ch := range chs {
+			d.channels[ch.Name] = ch
+		}
+		json.NewEncoder(w).Encode(chs)
+	default:
+		http.Error(w, "unsupported", http.StatusBadRequest)
+	}
+}
+
+// source serves SimpleChannels.
+type source map[string]*pvaccess.SimpleChannel
+
+func (s source) CreateChannel(ctx context.Context, name string) (types.Channel, error) {
+	if c, ok := s[name]; ok {
+		return c, nil
+	}
+	return nil, nil
+}
+
+func (s source) ChannelList(ctx context.Context) ([]string, error) {
+	var names []string
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func TestProvider(t *testing.T) {
+	ctx := context.Background()
+	dir := &directory{channels: map[string]Channel{
+		"other:temp": {Name: "other:temp", Properties: []Property{{Name: "hostName", Value: "other"}}},
+	}}
+	ts := httptest.NewServer(dir)
+	defer ts.Close()
+	c := &Client{URL: ts.URL + "/resources/", Username: "admin", Password: "secret"}
+
+	src := source{}
+	for _, name := range []string{"ioc1:temp", "ioc1:pressure", "other:temp"} {
+		src[name] = pvaccess.NewSimpleChannel(name)
+	}
+	if err := Register(ctx, c, source{"ioc1:temp": src["ioc1:temp"], "ioc1:pressure": src["ioc1:pressure"]},
+		Property{Name: "hostName", Value: "ioc1"}); err != nil {
+		t.Fatal(err)
+	}
+	want := Channel{Name: "ioc1:temp", Owner: "admin", Properties: []Property{{Name: "hostName", Owner: "admin", Value: "ioc1"}}, Tags: []Tag{}}
+	if diff := cmp.Diff(want, dir.channels["ioc1:temp"]); diff != "" {
+		t.Errorf("registered channel differs (-want +got):\n%s", diff)
+	}
+	if err := (&Client{URL: ts.URL + "/resources"}).Update(ctx, []Channel{{Name: "x"}}); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("unauthenticated Update = %v, want 401 error", err)
+	}
+
+	p, err := New(c, src, "hostName", "ioc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := clock.NewFake(time.Unix(0, 0))
+	p.Clock = fake
+	for _, test := range []struct {
+		name  string
+		owned bool
+	}{
+		{"ioc1:temp", true},
+		{"ioc1:pressure", true},
+		{"other:temp", false},
+		{"unknown", false},
+	} {
+		ch, err := p.CreateChannel(ctx, test.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (ch != nil) != test.owned {
+			t.Errorf("CreateChannel(%q) = %v, want owned = %v", test.name, ch, test.owned)
+		}
+		if found, err := p.ChannelFind(ctx, test.name); err != nil || found != test.owned {
+			t.Errorf("ChannelFind(%q) = %v, %v", test.name, found, err)
+		}
+	}
+	// Answers are cached until the TTL has passed.
+	if dir.lookups != 4 {
+		t.Errorf("directory was asked %d times, want 4", dir.lookups)
+	}
+	fake.Advance(defaultCacheTTL)
+	if owned, err := p.Owns(ctx, "ioc1:temp"); err != nil || !owned {
+		t.Errorf("Owns after TTL = %v, %v", owned, err)
+	}
+	if dir.lookups != 5 {
+		t.Errorf("directory was asked %d times after TTL, want 5", dir.lookups)
+	}
+
+	ts.Close()
+	fake.Advance(defaultCacheTTL)
+	if _, err := p.CreateChannel(ctx, "ioc1:temp"); err == nil {
+		t.Error("CreateChannel succeeded without a directory")
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	c := &Client{URL: "http://localhost/"}
+	for _, test := range []struct {
+		c        *Client
+		src      types.ChannelProvider
+		property string
+	}{
+		{nil, source{}, "hostName"},
+		{&Client{}, source{}, "hostName"},
+		{c, nil, "hostName"},
+		{c, source{}, ""},
+	} {
+		if _, err := New(test.c, test.src, test.property, "x"); err == nil {
+			t.Errorf("New(%+v, %v, %q) succeeded", test.c, test.src, test.property)
+		}
+	}
+}
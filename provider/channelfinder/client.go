@@ -0,0 +1,157 @@
+package channelfinder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Channel is a channel entry of the directory.
+type Channel struct {
+	Name       string     `json:"name"`
+	Owner      string     `json:"owner"`
+	Properties []Property `json:"properties"`
+	Tags       []Tag      `json:"tags"`
+}
+
+// Property returns the value of the channel's property called name, and whether it has one.
+func (c *Channel) Property(name string) (string, bool) {
+	for _, p := range c.Properties {
+		if p.Name == name {
+			return p.Value, true
+		}
+	}
+	return "", false
+}
+
+// Property is a named value attached to channels, such as the "hostName" of the server hosting them.
+type Property struct {
+	Name  string `json:"name"`
+	Owner string `json:"owner"`
+	Value string `json:"value"`
+}
+
+// Tag is a label attached to channels.
+type Tag struct {
+	Name  string `json:"name"`
+	Owner string `json:"owner"`
+}
+
+// Client talks to a ChannelFinder service over its REST API.
+type Client struct {
+	// URL is the base URL of the service's resources, e.g. "https://cf.example.com/ChannelFinder/resources".
+	URL string
+	// HTTPClient sends the requests. Nil selects http.DefaultClient.
+	HTTPClient *http.Client
+	// Username and Password, if Username is set, authenticate requests with HTTP basic authentication.
+	// The service requires them to change the directory.
+	Username, Password string
+	// Owner is recorded as the owner of the channels and properties that are registered. It defaults to Username.
+	Owner string
+}
+
+// Channel returns the directory's entry for the channel called name, or nil if it has none.
+func (c *Client) Channel(ctx context.Context, name string) (*Channel, error) {
+	var ch Channel
+	if err := c.do(ctx, http.MethodGet, "channels/"+url.PathEscape(name), nil, &ch); err != nil {
+		if errors.Is(err, errNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &ch, nil
+}
+
+// Find returns the channels that match query, e.g. url.Values{"~name": {"SR:*"}, "hostName": {"ioc1"}}.
+func (c *Client) Find(ctx context.Context, query url.Values) ([]Channel, error) {
+	var chs []Channel
+	if err := c.do(ctx, http.MethodGet, "channels?"+query.Encode(), nil, &chs); err != nil {
+		return nil, err
+	}
+	return chs, nil
+}
+
+// Update creates or replaces the directory's entries for channels. Empty owners are set to the Client's Owner.
+func (c *Client) Update(ctx context.Context, channels []Channel) error {
+	owner := c.Owner
+	if owner == "" {
+		owner = c.Username
+	}
+	chs := make([]Channel, len(channels))
+	for i, ch := range channels {
+		if ch.Owner == "" {
+			ch.Owner = owner
+		}
+		// Lists are copied to set their owners, and sent as [] rather than null when empty.
+		ch.Properties = append([]Property{}, ch.Properties...)
+		for j := range ch.Properties {
+			if ch.Properties[j].Owner == "" {
+				ch.Properties[j].Owner = owner
+			}
+		}
+		ch.Tags = append([]Tag{}, ch.Tags...)
+		for j := range ch.Tags {
+			if ch.Tags[j].Owner == "" {
+				ch.Tags[j].Owner = owner
+			}
+		}
+		chs[i] = ch
+	}
+	return c.do(ctx, http.MethodPut, "channels", chs, nil)
+}
+
+// errNotFound is returned by do when the service answers 404 Not Found.
+var errNotFound = errors.New("not found")
+
+// do sends a request for the resource at path, with in encoded as JSON if it isn't nil,
+// and decodes the JSON response into out if it isn't nil.
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.URL, "/")+"/"+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return fmt.Errorf("channelfinder: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("channelfinder: %s %s: %s: %s", method, req.URL.Path, resp.Status, bytes.TrimSpace(msg))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("channelfinder: decoding response to %s %s: %w", method, req.URL.Path, err)
+	}
+	return nil
+}
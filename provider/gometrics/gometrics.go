@@ -0,0 +1,303 @@
+// Package gometrics implements a channel provider that serves the metrics of the Go process it runs in as
+// read-only PVs.
+//
+// The PVs let EPICS tools trend the health of a Go service, such as its heap size, goroutine count, and garbage
+// collection pauses, alongside the rest of its PVs. Their values come from the runtime/metrics package and from
+// the variables published with the expvar package. Importing this package imports expvar, which registers its
+// handler for /debug/vars on http.DefaultServeMux.
+package gometrics
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"math"
+	"runtime/metrics"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/Lexcelon/go-pvaccess/types"
+)
+
+// Provider is a ChannelProvider serving the process's metrics as NTScalar PVs.
+//
+// A runtime metric is served as a PV named by the prefix and the metric's name, with its leading slash removed and
+// its other slashes replaced by colons; e.g. with the prefix "IOC:go:", /sched/goroutines:goroutines is served as
+// IOC:go:sched:goroutines:goroutines. Its unit is the PV's display units. Histograms, such as /gc/pauses:seconds,
+// are served as the mean of the values observed since the previous sample, estimated from the histogram's buckets,
+// and keep their value while there are none.
+//
+// An expvar variable is served as a PV named by the prefix and the variable's name, if it is a number. If it is a map
+// or a JSON object, such as memstats, each of its numeric fields is served as a PV named by the variable's name,
+// a colon, and the field's name, recursively.
+//
+// The PVs appear once they are first sampled, by Run or by the first call to CreateChannel or ChannelList.
+// They only update while Run is running, and only when their values change.
+type Provider struct {
+	prefix string
+
+	// Interval is the time between samples. It defaults to 1 second.
+	Interval time.Duration
+	// Metrics are the names of the runtime metrics to serve. If nil, every metric is served; an empty list serves none.
+	// Names that the runtime doesn't support are ignored.
+	Metrics []string
+	// Vars are the names of the expvar variables to serve. If nil, every variable is served; an empty list serves none.
+	Vars []string
+
+	mu      sync.Mutex
+	sampled bool
+	samples []metrics.Sample
+	descs   map[string]metrics.Description
+	// counts are the bucket counts of each histogram at the previous sample.
+	counts map[string][]uint64
+	pvs    map[string]*metricPV
+}
+
+// New returns a Provider serving the process's metrics as PVs whose names start with prefix.
+func New(prefix string) *Provider {
+	return &Provider{
+		prefix: prefix,
+		counts: make(map[string][]uint64),
+		pvs:    make(map[string]*metricPV),
+	}
+}
+
+// metricPV is a read-only PV serving one metric.
+type metricPV struct {
+	pvaccess.Snapshot
+
+	name    string
+	display pvdata.Display
+	last    interface{}
+}
+
+func (pv *metricPV) Name() string {
+	return pv.name
+}
+
+// reading is the value of a metric in a sample. A nil value leaves the PV unchanged.
+type reading struct {
+	name, description, units string
+	value                    interface{}
+}
+
+// Run samples the metrics every Interval until ctx is cancelled.
+func (p *Provider) Run(ctx context.Context) error {
+	p.sample()
+	interval := p.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			p.sample()
+		}
+	}
+}
+
+// sampleOnce samples the metrics if they haven't been yet, so that their PVs exist.
+func (p *Provider) sampleOnce() {
+	p.mu.Lock()
+	sampled := p.sampled
+	p.mu.Unlock()
+	if !sampled {
+		p.sample()
+	}
+}
+
+// sample reads the metrics and updates their PVs.
+func (p *Provider) sample() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sampled = true
+	now := time.Now()
+	seen := make(map[string]bool)
+	add := func(r reading) {
+		// A variable whose name matches a runtime metric's is hidden by it.
+		if seen[r.name] {
+			return
+		}
+		seen[r.name] = true
+		p.update(r, now)
+	}
+	p.readMetrics(add)
+	p.readVars(add)
+}
+
+// update sets the PV of r to its value, creating it if need be.
+func (p *Provider) update(r reading, now time.Time) {
+	pv, ok := p.pvs[r.name]
+	if !ok {
+		display := pvdata.NewDisplay(0, 0, r.units, 0).WithDescription(r.description)
+		if r.value == nil {
+			r.value = pvdata.PVDouble(0)
+		}
+		if _, ok := r.value.(pvdata.PVDouble); ok {
+			display.Precision = 3
+		}
+		pv = &metricPV{name: r.name, display: display}
+		p.pvs[r.name] = pv
+	} else if r.value == nil || r.value == pv.last {
+		return
+	}
+	pv.last = r.value
+	pv.Store(&pvdata.NTScalar{
+		Value:     r.value,
+		TimeStamp: &pvdata.Time{Time: now},
+		Display:   &pv.display,
+	})
+}
+
+// readMetrics reads the runtime metrics.
+func (p *Provider) readMetrics(add func(reading)) {
+	if p.descs == nil {
+		p.descs = make(map[string]metrics.Description)
+		for _, d := range metrics.All() {
+			if wanted(p.Metrics, d.Name) {
+				p.descs[d.Name] = d
+				p.samples = append(p.samples, metrics.Sample{Name: d.Name})
+			}
+		}
+	}
+	metrics.Read(p.samples)
+	for _, s := range p.samples {
+		r := reading{
+			name:        p.prefix + strings.ReplaceAll(strings.TrimPrefix(s.Name, "/"), "/", ":"),
+			description: p.descs[s.Name].Description,
+		}
+		if i := strings.LastIndexByte(s.Name, ':'); i >= 0 {
+			r.units = s.Name[i+1:]
+		}
+		switch s.Value.Kind() {
+		case metrics.KindUint64:
+			r.value = pvdata.PVLong(s.Value.Uint64())
+		case metrics.KindFloat64:
+			r.value = pvdata.PVDouble(s.Value.Float64())
+		case metrics.KindFloat64Histogram:
+			h := s.Value.Float64Histogram()
+			if mean, ok := histogramMean(h, p.counts[s.Name]); ok {
+				r.value = pvdata.PVDouble(mean)
+			}
+			p.counts[s.Name] = append(p.counts[s.Name][:0], h.Counts...)
+		default:
+			continue
+		}
+		add(r)
+	}
+}
+
+// histogramMean returns the mean of the values counted by h but not by prev, the counts of an earlier reading of
+// the same histogram, taking each value to be at the middle of its bucket. It reports false if there are none.
+func histogramMean(h *metrics.Float64Histogram, prev []uint64) (float64, bool) {
+	var n uint64
+	var sum float64
+	for i, count := range h.Counts {
+		if i < len(prev) {
+			count -= prev[i]
+		}
+		if count == 0 {
+			continue
+		}
+		// The first and last buckets may be unbounded.
+		low, high := h.Buckets[i], h.Buckets[i+1]
+		if math.IsInf(low, -1) {
+			low = high
+		}
+		if math.IsInf(high, 1) {
+			high = low
+		}
+		n += count
+		sum += float64(count) * (low + high) / 2
+	}
+	if n == 0 {
+		return 0, false
+	}
+	return sum / float64(n), true
+}
+
+// readVars reads the expvar variables.
+func (p *Provider) readVars(add func(reading)) {
+	expvar.Do(func(kv expvar.KeyValue) {
+		if wanted(p.Vars, kv.Key) {
+			readVar(add, p.prefix+kv.Key, kv.Value)
+		}
+	})
+}
+
+func readVar(add func(reading), name string, v expvar.Var) {
+	switch v := v.(type) {
+	case *expvar.Int:
+		add(reading{name: name, value: pvdata.PVLong(v.Value())})
+	case *expvar.Float:
+		add(reading{name: name, value: pvdata.PVDouble(v.Value())})
+	case *expvar.Map:
+		v.Do(func(kv expvar.KeyValue) {
+			readVar(add, name+":"+kv.Key, kv.Value)
+		})
+	default:
+		// Other variables can only be read as JSON, whose numbers are all served as doubles,
+		// so that their PVs' types don't depend on the values.
+		var x interface{}
+		if err := json.Unmarshal([]byte(v.String()), &x); err == nil {
+			readJSON(add, name, x)
+		}
+	}
+}
+
+func readJSON(add func(reading), name string, x interface{}) {
+	switch x := x.(type) {
+	case float64:
+		add(reading{name: name, value: pvdata.PVDouble(x)})
+	case map[string]interface{}:
+		for key, v := range x {
+			readJSON(add, name+":"+key, v)
+		}
+	}
+}
+
+// wanted reports whether name is in names, or names is nil.
+func wanted(names []string, name string) bool {
+	if names == nil {
+		return true
+	}
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Provider) CreateChannel(ctx context.Context, name string) (types.Channel, error) {
+	if !strings.HasPrefix(name, p.prefix) {
+		return nil, nil
+	}
+	p.sampleOnce()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if pv, ok := p.pvs[name]; ok {
+		return pv, nil
+	}
+	return nil, nil
+}
+
+func (p *Provider) ChannelList(ctx context.Context) ([]string, error) {
+	p.sampleOnce()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	names := make([]string, 0, len(p.pvs))
+	for name := range p.pvs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
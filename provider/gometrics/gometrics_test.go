@@ -0,0 +1,105 @@
+package gometrics
+
+import (
+	"context"
+	"expvar"
+	"math"
+	"runtime/metrics"
+	"testing"
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/google/go-cmp/cmp"
+)
+
+var (
+	testVars  = expvar.NewMap("gometrics_test")
+	testCount = new(expvar.Int)
+)
+
+func init() {
+	testVars.Set("count", testCount)
+	testVars.Set("stats", expvar.Func(func() interface{} {
+		return map[string]interface{}{"ratio": 0.5, "name": "not a number"}
+	}))
+}
+
+func TestProvider(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	p := New("go:")
+	p.Interval = time.Millisecond
+	p.Metrics = []string{"/sched/goroutines:goroutines", "/gc/pauses:seconds", "/no/such:metric"}
+	p.Vars = []string{"gometrics_test"}
+	testCount.Set(1)
+
+	names, err := p.ChannelList(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"go:gc:pauses:seconds",
+		"go:gometrics_test:count",
+		"go:gometrics_test:stats:ratio",
+		"go:sched:goroutines:goroutines",
+	}
+	if diff := cmp.Diff(want, names); diff != "" {
+		t.Errorf("ChannelList differs (-want +got):\n%s", diff)
+	}
+	c, err := p.CreateChannel(ctx, "go:sched:goroutines:goroutines")
+	if err != nil || c == nil {
+		t.Fatalf("CreateChannel = %v, %v", c, err)
+	}
+	v, err := c.(*metricPV).ChannelGet(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nt := v.(*pvdata.NTScalar)
+	if n, ok := nt.Value.(pvdata.PVLong); !ok || n < 1 || nt.Display.Units != "goroutines" || nt.Display.Description == "" {
+		t.Errorf("goroutines PV = %#v with display %+v, want a positive count of goroutines", nt.Value, nt.Display)
+	}
+	if c, err := p.CreateChannel(ctx, "go:gometrics_test"); c != nil || err != nil {
+		t.Errorf("CreateChannel of a map variable = %v, %v, want nil", c, err)
+	}
+
+	c, err = p.CreateChannel(ctx, "go:gometrics_test:count")
+	if err != nil || c == nil {
+		t.Fatalf("CreateChannel = %v, %v", c, err)
+	}
+	w, err := c.(*metricPV).CreateChannelMonitor(ctx, pvdata.PVStructure{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Next(ctx); err != nil {
+		t.Fatal(err)
+	}
+	go p.Run(ctx)
+	testCount.Set(2)
+	v, err = w.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := v.(*pvdata.NTScalar).Value; got != pvdata.PVLong(2) {
+		t.Errorf("count updated to %v, want 2", got)
+	}
+}
+
+func TestHistogramMean(t *testing.T) {
+	h := &metrics.Float64Histogram{
+		Counts:  []uint64{1, 2, 3, 4},
+		Buckets: []float64{math.Inf(-1), 0, 1, 2, math.Inf(1)},
+	}
+	for _, test := range []struct {
+		prev []uint64
+		want float64
+		ok   bool
+	}{
+		{nil, (0*1 + 0.5*2 + 1.5*3 + 2*4) / 10.0, true},
+		{[]uint64{1, 2, 1, 2}, (1.5*2 + 2*2) / 4.0, true},
+		{[]uint64{1, 2, 3, 4}, 0, false},
+	} {
+		if got, ok := histogramMean(h, test.prev); ok != test.ok || math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("histogramMean with previous counts %v = %v, %v, want %v, %v", test.prev, got, ok, test.want, test.ok)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package group
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// memberConfig is a member in a JSON group definition.
+type memberConfig struct {
+	Channel string `json:"+channel"`
+	Type    string `json:"+type"`
+}
+
+// ParseConfig parses group definitions in the JSON format used by QSRV, e.g.
+//
+//	{
+//		"dev:axes": {
+//			"x": {"+channel": "dev:x"},
+//			"y": {"+channel": "dev:y", "+type": "plain"}
+//		}
+//	}
+//
+// Each group maps its fields to member channels. Nested fields are named by their path, such as "axis.x".
+// "+type" is the name of a MemberType, and defaults to "scalar".
+// Since JSON objects are unordered, the groups and their members are sorted by name.
+func ParseConfig(data []byte) ([]Group, error) {
+	var config map[string]map[string]memberConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("parsing group config: %w", err)
+	}
+	names := make([]string, 0, len(config))
+	for name := range config {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	groups := make([]Group, 0, len(names))
+	for _, name := range names {
+		g := Group{Name: name}
+		fields := make([]string, 0, len(config[name]))
+		for field := range config[name] {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			mc := config[name][field]
+			m := Member{Field: field, Channel: mc.Channel}
+			if mc.Type != "" {
+				t, err := ParseMemberType(mc.Type)
+				if err != nil {
+					return nil, fmt.Errorf("group %q member %q: %w", name, field, err)
+				}
+				m.Type = t
+			}
+			g.Members = append(g.Members, m)
+		}
+		groups = append(groups, g)
+	}
+	return groups, nil
+}
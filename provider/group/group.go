@@ -0,0 +1,480 @@
+// Package group implements a channel provider serving group PVs, like the groups of QSRV.
+//
+// A group PV is a single structure assembled from other channels: each member channel's value
+// is a field of the group's structure, so that clients can get, monitor, and put related channels
+// (e.g. the axes of a device) together.
+package group
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/Lexcelon/go-pvaccess/types"
+)
+
+// MemberType selects how a member channel's value appears in its group.
+type MemberType int
+
+const (
+	// Scalar members include the member channel's whole structure, e.g. an NTScalar with its metadata.
+	Scalar MemberType = iota
+	// Plain members include only the member channel's value field.
+	Plain
+)
+
+var memberTypeNames = map[MemberType]string{
+	Scalar: "scalar",
+	Plain:  "plain",
+}
+
+func (t MemberType) String() string {
+	if name, ok := memberTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("MemberType(%d)", int(t))
+}
+
+// ParseMemberType returns the MemberType with the given name, as returned by String.
+func ParseMemberType(name string) (MemberType, error) {
+	for t, n := range memberTypeNames {
+		if n == name {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown member type %q", name)
+}
+
+// Member maps a field of a group's structure to a member channel.
+type Member struct {
+	// Field is the path of the member in the group's structure, e.g. "x" or "axis.x".
+	Field   string
+	Channel string
+	Type    MemberType
+}
+
+// Group configures a group PV.
+type Group struct {
+	Name string
+	// Members are the group's fields, in the order they appear in its structure.
+	Members []Member
+}
+
+// Provider is a ChannelProvider serving group PVs whose members are channels of another provider.
+// Groups only have a value once Run has received a value from every member.
+type Provider struct {
+	source types.ChannelProvider
+	// load returns the groups' configuration, or is nil if the groups were given to New.
+	load func() ([]Group, error)
+
+	mu     sync.Mutex
+	groups map[string]*group
+	// ctx is Run's context while it is running, so that Reload can watch the members of new groups.
+	ctx context.Context
+	// watches counts the goroutines watching members.
+	watches sync.WaitGroup
+}
+
+// New returns a Provider serving groups, whose members are channels of source.
+//
+// Puts to a group are made with a group put (see types.GroupPuter) if source supports them,
+// so that either every member changes or none does. Otherwise members are put one at a time.
+func New(source types.ChannelProvider, groups ...Group) (*Provider, error) {
+	p := &Provider{source: source}
+	var err error
+	if p.groups, err = p.newGroups(groups); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// NewFromFile returns a Provider serving the groups configured in the file at path, in the format read by ParseConfig.
+// Reload re-reads the file.
+func NewFromFile(source types.ChannelProvider, path string) (*Provider, error) {
+	p := &Provider{
+		source: source,
+		load: func() ([]Group, error) {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			return ParseConfig(data)
+		},
+	}
+	groups, err := p.load()
+	if err != nil {
+		return nil, err
+	}
+	if p.groups, err = p.newGroups(groups); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// newGroups returns the groups for configs, which are not watched yet.
+func (p *Provider) newGroups(configs []Group) (map[string]*group, error) {
+	groups := make(map[string]*group)
+	for _, config := range configs {
+		if _, ok := groups[config.Name]; ok {
+			return nil, fmt.Errorf("duplicate group %q", config.Name)
+		}
+		g, err := p.newGroup(config)
+		if err != nil {
+			return nil, err
+		}
+		groups[config.Name] = g
+	}
+	return groups, nil
+}
+
+func (p *Provider) newGroup(config Group) (*group, error) {
+	if config.Name == "" {
+		return nil, fmt.Errorf("group has no name")
+	}
+	if len(config.Members) == 0 {
+		return nil, fmt.Errorf("group %q has no members", config.Name)
+	}
+	g := &group{p: p, name: config.Name, config: config, root: &node{}}
+	for _, m := range config.Members {
+		if m.Channel == "" {
+			return nil, fmt.Errorf("group %q: member %q has no channel", config.Name, m.Field)
+		}
+		if _, ok := memberTypeNames[m.Type]; !ok {
+			return nil, fmt.Errorf("group %q: member %q has unknown type %v", config.Name, m.Field, m.Type)
+		}
+		gm := &member{Member: m, path: strings.Split(m.Field, ".")}
+		if err := g.root.add(gm, gm.path); err != nil {
+			return nil, fmt.Errorf("group %q: %w", config.Name, err)
+		}
+		g.members = append(g.members, gm)
+	}
+	return g, nil
+}
+
+// Run keeps the groups' values up to date with their members until ctx is cancelled.
+// Members that implement neither monitors nor Get are logged and never get a value.
+func (p *Provider) Run(ctx context.Context) error {
+	p.mu.Lock()
+	p.ctx = ctx
+	for _, g := range p.groups {
+		p.watchLocked(g)
+	}
+	p.mu.Unlock()
+	<-ctx.Done()
+	p.mu.Lock()
+	p.ctx = nil
+	p.mu.Unlock()
+	p.watches.Wait()
+	return ctx.Err()
+}
+
+// watchLocked starts watching the members of g, if Run is running. p.mu must be held.
+func (p *Provider) watchLocked(g *group) {
+	if p.ctx == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(p.ctx)
+	g.stop = cancel
+	for _, m := range g.members {
+		m := m
+		p.watches.Add(1)
+		go func() {
+			defer p.watches.Done()
+			g.watch(ctxlog.WithFields(ctx, ctxlog.Fields{"group": g.name, "member": m.Channel}), m)
+		}()
+	}
+}
+
+// Reload re-reads the configuration of a Provider returned by NewFromFile, and replaces its groups.
+// Groups whose configuration is unchanged keep their values and clients. Clients of groups that were
+// changed or removed stay connected, but the groups' values are no longer updated.
+// If the new configuration is invalid, Reload returns an error and the groups are unchanged.
+func (p *Provider) Reload(ctx context.Context) error {
+	if p.load == nil {
+		return nil
+	}
+	configs, err := p.load()
+	if err != nil {
+		return err
+	}
+	groups, err := p.newGroups(configs)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for name, g := range groups {
+		if old, ok := p.groups[name]; ok && reflect.DeepEqual(old.config, g.config) {
+			groups[name] = old
+			continue
+		}
+		p.watchLocked(g)
+	}
+	for name, old := range p.groups {
+		if groups[name] != old && old.stop != nil {
+			old.stop()
+		}
+	}
+	p.groups = groups
+	ctxlog.L(ctx).Infof("reloaded %d groups", len(groups))
+	return nil
+}
+
+func (p *Provider) CreateChannel(ctx context.Context, name string) (types.Channel, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if g, ok := p.groups[name]; ok {
+		return g, nil
+	}
+	return nil, nil
+}
+
+func (p *Provider) ChannelList(ctx context.Context) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var names []string
+	for name := range p.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// member is a member of a group.
+type member struct {
+	Member
+	path []string
+
+	// value is the member's field in the group's structure. It is guarded by the group's mu.
+	value interface{}
+}
+
+// node is a structure in a group's value: either the group itself, or an intermediate structure like "axis" in "axis.x".
+type node struct {
+	names []string
+	// Each field is either a *member or a *node.
+	fields []interface{}
+}
+
+func (n *node) add(m *member, path []string) error {
+	for i, name := range n.names {
+		if name != path[0] {
+			continue
+		}
+		child, ok := n.fields[i].(*node)
+		if !ok || len(path) == 1 {
+			return fmt.Errorf("member %q overlaps another member", m.Field)
+		}
+		return child.add(m, path[1:])
+	}
+	if path[0] == "" {
+		return fmt.Errorf("member %q has an empty field name", m.Field)
+	}
+	n.names = append(n.names, path[0])
+	if len(path) == 1 {
+		n.fields = append(n.fields, m)
+		return nil
+	}
+	child := &node{}
+	n.fields = append(n.fields, child)
+	return child.add(m, path[1:])
+}
+
+var interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+
+// build returns the value of the structure n. Every member must have a value.
+func (n *node) build() interface{} {
+	fields := make([]reflect.StructField, len(n.names))
+	for i, name := range n.names {
+		fields[i] = reflect.StructField{
+			Name: fmt.Sprintf("Field%d", i),
+			Type: interfaceType,
+			Tag:  reflect.StructTag(fmt.Sprintf("pvaccess:%q", name)),
+		}
+	}
+	v := reflect.New(reflect.StructOf(fields))
+	for i, f := range n.fields {
+		switch f := f.(type) {
+		case *member:
+			v.Elem().Field(i).Set(reflect.ValueOf(f.value))
+		case *node:
+			v.Elem().Field(i).Set(reflect.ValueOf(f.build()))
+		}
+	}
+	return v.Interface()
+}
+
+// group is a group PV. Its current value is kept in a Snapshot, so that Get and Monitor always see
+// the values of all the members at once, and never wait for members to update.
+type group struct {
+	pvaccess.Snapshot
+
+	p       *Provider
+	name    string
+	config  Group
+	root    *node
+	members []*member
+	// stop stops watching the members. It is guarded by the Provider's mu.
+	stop context.CancelFunc
+
+	// mu guards the members' values.
+	mu sync.Mutex
+}
+
+func (g *group) Name() string {
+	return g.name
+}
+
+// watch updates the group with the values of m until ctx is cancelled.
+func (g *group) watch(ctx context.Context, m *member) {
+	c, err := g.p.source.CreateChannel(ctx, m.Channel)
+	if err != nil || c == nil {
+		ctxlog.L(ctx).Warnf("member channel not found: %v", err)
+		return
+	}
+	req, err := pvdata.NewPVStructure(&struct{}{})
+	if err != nil {
+		ctxlog.L(ctx).Warnf("watching member: %v", err)
+		return
+	}
+	if mc, ok := c.(types.ChannelMonitorCreator); ok {
+		w, err := mc.CreateChannelMonitor(ctx, req)
+		if err != nil {
+			ctxlog.L(ctx).Warnf("monitoring member: %v", err)
+			return
+		}
+		for {
+			v, err := w.Next(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					ctxlog.L(ctx).Warnf("monitoring member: %v", err)
+				}
+				return
+			}
+			g.update(ctx, m, v)
+		}
+	}
+	if geter, ok := c.(types.ChannelGeter); ok {
+		v, err := geter.ChannelGet(ctx)
+		if err != nil {
+			ctxlog.L(ctx).Warnf("getting member: %v", err)
+			return
+		}
+		g.update(ctx, m, v)
+		return
+	}
+	ctxlog.L(ctx).Warnf("member channel supports neither Monitor nor Get")
+}
+
+// update sets the value of m, and replaces the group's value once every member has one.
+func (g *group) update(ctx context.Context, m *member, v interface{}) {
+	if m.Type == Plain {
+		pvs, err := pvdata.NewPVStructure(v)
+		if err != nil {
+			ctxlog.L(ctx).Warnf("member value: %v", err)
+			return
+		}
+		field := pvs.Field("value")
+		if field == nil {
+			ctxlog.L(ctx).Warnf("member value has no value field")
+			return
+		}
+		v = field
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	m.value = v
+	for _, m := range g.members {
+		if m.value == nil {
+			return
+		}
+	}
+	g.Store(g.root.build())
+}
+
+// plainValue is put to Plain members.
+type plainValue struct {
+	Value interface{} `pvaccess:"value"`
+}
+
+func (g *group) ChannelPut(ctx context.Context, value pvdata.PVStructure) error {
+	return g.put(ctx, value, nil)
+}
+
+// ChannelPutFields only puts to the members whose fields were sent.
+func (g *group) ChannelPutFields(ctx context.Context, value pvdata.PVStructure, changed []string) error {
+	return g.put(ctx, value, changed)
+}
+
+// put puts value to the members affected by changed, or to every member if changed is nil.
+func (g *group) put(ctx context.Context, value pvdata.PVStructure, changed []string) error {
+	values := make(map[string]pvdata.PVStructure)
+	for _, m := range g.members {
+		if changed != nil && !affected(m.Field, changed) {
+			continue
+		}
+		v, err := m.putValue(value)
+		if err != nil {
+			return err
+		}
+		values[m.Channel] = v
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	if gp, ok := g.p.source.(types.GroupPuter); ok {
+		return gp.GroupPut(ctx, values)
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c, err := g.p.source.CreateChannel(ctx, name)
+		if err != nil {
+			return fmt.Errorf("member %q: %w", name, err)
+		}
+		puter, ok := c.(types.ChannelPuter)
+		if !ok {
+			return fmt.Errorf("member %q does not support Put", name)
+		}
+		if err := puter.ChannelPut(ctx, values[name]); err != nil {
+			return fmt.Errorf("member %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// affected reports whether the member with the given field is changed by a put of changed.
+func affected(field string, changed []string) bool {
+	for _, c := range changed {
+		if c == field || strings.HasPrefix(field, c+".") || strings.HasPrefix(c, field+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// putValue returns the value put to m by a put of value to its group.
+func (m *member) putValue(value pvdata.PVStructure) (pvdata.PVStructure, error) {
+	field := value.SubField(m.path...)
+	if field == nil {
+		return pvdata.PVStructure{}, fmt.Errorf("missing field %q", m.Field)
+	}
+	if m.Type == Plain {
+		return pvdata.NewPVStructure(&plainValue{field})
+	}
+	pvs, err := pvdata.NewPVStructure(field)
+	if err != nil {
+		return pvdata.PVStructure{}, fmt.Errorf("field %q: %w", m.Field, err)
+	}
+	return pvs, nil
+}
@@ -0,0 +1,231 @@
+package group
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+	"github.com/Lexcelon/go-pvaccess/provider/memory"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/Lexcelon/go-pvaccess/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+const testConfig = `{
+	"dev:axes": {
+		"x": {"+channel": "dev:x"},
+		"axis.y": {"+channel": "dev:y", "+type": "plain"}
+	}
+}`
+
+func TestParseConfig(t *testing.T) {
+	groups, err := ParseConfig([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Group{{
+		Name: "dev:axes",
+		Members: []Member{
+			{Field: "axis.y", Channel: "dev:y", Type: Plain},
+			{Field: "x", Channel: "dev:x", Type: Scalar},
+		},
+	}}
+	if diff := cmp.Diff(want, groups); diff != "" {
+		t.Errorf("ParseConfig differs (-want +got):\n%s", diff)
+	}
+	if _, err := ParseConfig([]byte(`{"g": {"x": {"+channel": "x", "+type": "fancy"}}}`)); err == nil {
+		t.Error("ParseConfig accepted unknown member type")
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	for _, g := range []Group{
+		{Name: "", Members: []Member{{Field: "x", Channel: "x"}}},
+		{Name: "g"},
+		{Name: "g", Members: []Member{{Field: "x"}}},
+		{Name: "g", Members: []Member{{Field: "a", Channel: "a"}, {Field: "a.b", Channel: "b"}}},
+		{Name: "g", Members: []Member{{Field: "a.b", Channel: "b"}, {Field: "a", Channel: "a"}}},
+		{Name: "g", Members: []Member{{Field: "a..b", Channel: "b"}}},
+	} {
+		if _, err := New(memory.New(nil), g); err == nil {
+			t.Errorf("New(%+v) succeeded", g)
+		}
+	}
+}
+
+// next waits for the group's value to satisfy ok.
+func next(ctx context.Context, t *testing.T, w types.Nexter, ok func(m map[string]interface{}) bool) map[string]interface{} {
+	t.Helper()
+	for {
+		v, err := w.Next(ctx)
+		if err != nil {
+			t.Fatalf("waiting for group value: %v", err)
+		}
+		m, err := pvdata.ToMap(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok(m) {
+			return m
+		}
+	}
+}
+
+func axes(m map[string]interface{}) (x, y interface{}) {
+	xm, _ := m["x"].(map[string]interface{})
+	am, _ := m["axis"].(map[string]interface{})
+	return xm["value"], am["y"]
+}
+
+func TestGroup(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	source := memory.New(nil)
+	x, y := pvdata.PVDouble(1), pvdata.PVDouble(2)
+	for _, pv := range []memory.PV{{Name: "dev:x", Value: &x}, {Name: "dev:y", Value: &y}} {
+		if err := source.Add(pv); err != nil {
+			t.Fatal(err)
+		}
+	}
+	groups, err := ParseConfig([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := New(source, groups...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := p.CreateChannel(ctx, "dev:axes")
+	if err != nil || c == nil {
+		t.Fatalf("CreateChannel = %v, %v", c, err)
+	}
+	g := c.(*group)
+	if _, err := g.ChannelGet(ctx); err == nil {
+		t.Error("Get succeeded before the members had values")
+	}
+	go p.Run(ctx)
+	w, err := g.CreateChannelMonitor(ctx, pvdata.PVStructure{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next(ctx, t, w, func(m map[string]interface{}) bool {
+		x, y := axes(m)
+		return x == 1.0 && y == 2.0
+	})
+
+	current, err := g.ChannelGet(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd, err := pvdata.FieldDescOf(current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newValue := func(x, y float64) pvdata.PVStructure {
+		zero, err := fd.Zero()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pvs := zero.(pvdata.PVStructure)
+		if err := pvs.SetFromMap(map[string]interface{}{
+			"x":    map[string]interface{}{"value": x},
+			"axis": map[string]interface{}{"y": y},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return pvs
+	}
+	if err := g.ChannelPut(ctx, newValue(10, 20)); err != nil {
+		t.Fatal(err)
+	}
+	next(ctx, t, w, func(m map[string]interface{}) bool {
+		x, y := axes(m)
+		return x == 10.0 && y == 20.0
+	})
+	// Only the changed member is put.
+	if err := g.ChannelPutFields(ctx, newValue(0, 30), []string{"axis.y"}); err != nil {
+		t.Fatal(err)
+	}
+	m := next(ctx, t, w, func(m map[string]interface{}) bool {
+		_, y := axes(m)
+		return y == 30.0
+	})
+	if x, _ := axes(m); x != 10.0 {
+		t.Errorf("x = %v after put to axis.y, want 10", x)
+	}
+}
+
+func TestReload(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	source := memory.New(nil)
+	x, y := pvdata.PVDouble(1), pvdata.PVDouble(2)
+	for _, pv := range []memory.PV{{Name: "dev:x", Value: &x}, {Name: "dev:y", Value: &y}} {
+		if err := source.Add(pv); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "groups.json")
+	write := func(config string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(testConfig)
+	p, err := NewFromFile(source, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &pvaccess.Server{}
+	srv.AddChannelProvider(p)
+	go p.Run(ctx)
+	before, err := p.CreateChannel(ctx, "dev:axes")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write(`{
+		"dev:axes": {
+			"x": {"+channel": "dev:x"},
+			"axis.y": {"+channel": "dev:y", "+type": "plain"}
+		},
+		"dev:y": {"y": {"+channel": "dev:y"}}
+	}`)
+	if err := srv.Reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	names, err := p.ChannelList(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"dev:axes", "dev:y"}, names); diff != "" {
+		t.Errorf("ChannelList after reload differs (-want +got):\n%s", diff)
+	}
+	if after, _ := p.CreateChannel(ctx, "dev:axes"); after != before {
+		t.Error("unchanged group was replaced by reload")
+	}
+	c, err := p.CreateChannel(ctx, "dev:y")
+	if err != nil || c == nil {
+		t.Fatalf("CreateChannel(dev:y) = %v, %v", c, err)
+	}
+	w, err := c.(*group).CreateChannelMonitor(ctx, pvdata.PVStructure{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next(ctx, t, w, func(m map[string]interface{}) bool {
+		ym, _ := m["y"].(map[string]interface{})
+		return ym["value"] == 2.0
+	})
+
+	write(`{"dev:axes": {"x": {}}}`)
+	if err := srv.Reload(ctx); err == nil {
+		t.Error("Reload accepted invalid config")
+	}
+	if names, _ := p.ChannelList(ctx); len(names) != 2 {
+		t.Errorf("ChannelList after failed reload = %q, want the previous groups", names)
+	}
+}
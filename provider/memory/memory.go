@@ -0,0 +1,285 @@
+// Package memory implements a channel provider that holds PV values in memory.
+//
+// Values can optionally be saved to and restored from a Store, giving the autosave/restore behavior of an IOC.
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/Lexcelon/go-pvaccess/types"
+)
+
+// PV configures a PV served by a Provider.
+type PV struct {
+	Name string
+	// Value is a pointer to the initial value, e.g. *pvdata.PVDouble, *[]pvdata.PVInt, or *pvdata.Enum.
+	// The type of the value is fixed when the PV is added; puts are converted to it if possible.
+	Value interface{}
+	// Display is the metadata served in the display field.
+	Display pvdata.Display
+}
+
+// Provider is a ChannelProvider serving PVs whose values are held in memory.
+type Provider struct {
+	store Store
+
+	mu      sync.Mutex
+	records map[string]*record
+	// saved holds restored states of PVs that have not been added yet.
+	saved map[string]State
+
+	// dirty is set when a PV changes and cleared when the PVs are saved.
+	// It is accessed atomically, since records set it while holding their own lock.
+	dirty int32
+}
+
+// New returns an empty Provider.
+// If store is not nil, it is used by Restore, Save, and Autosave.
+func New(store Store) *Provider {
+	return &Provider{
+		store:   store,
+		records: make(map[string]*record),
+		saved:   make(map[string]State),
+	}
+}
+
+// Add adds a PV to p.
+// If a state for the PV was previously restored, it replaces the initial value and display.
+func (p *Provider) Add(pv PV) error {
+	if pv.Name == "" {
+		return fmt.Errorf("PV has no name")
+	}
+	if v := reflect.ValueOf(pv.Value); v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("PV %q: value must be a non-nil pointer, got %T", pv.Name, pv.Value)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.records[pv.Name]; ok {
+		return fmt.Errorf("duplicate PV %q", pv.Name)
+	}
+	r := newRecord(p, pv)
+	if state, ok := p.saved[pv.Name]; ok {
+		if err := r.restore(state); err != nil {
+			return fmt.Errorf("restoring PV %q: %w", pv.Name, err)
+		}
+		delete(p.saved, pv.Name)
+	}
+	p.records[pv.Name] = r
+	return nil
+}
+
+// Remove stops serving the named PV, and stops saving its state.
+// Clients that already created its channel can keep using it.
+func (p *Provider) Remove(name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.records[name]; !ok {
+		return fmt.Errorf("unknown PV %q", name)
+	}
+	delete(p.records, name)
+	p.markDirty()
+	return nil
+}
+
+// Get returns the current value of the named PV.
+func (p *Provider) Get(name string) (interface{}, bool) {
+	r, ok := p.record(name)
+	if !ok {
+		return nil, false
+	}
+	value, _ := r.get()
+	return value, true
+}
+
+// Set changes the value of the named PV and notifies monitoring clients.
+// value must be a pointer of the same type as the PV's initial value.
+func (p *Provider) Set(name string, value interface{}) error {
+	r, ok := p.record(name)
+	if !ok {
+		return fmt.Errorf("unknown PV %q", name)
+	}
+	return r.set(value)
+}
+
+// SetDisplay changes the display metadata of the named PV.
+func (p *Provider) SetDisplay(name string, display pvdata.Display) error {
+	r, ok := p.record(name)
+	if !ok {
+		return fmt.Errorf("unknown PV %q", name)
+	}
+	r.mu.Lock()
+	r.display = display
+	r.changed()
+	r.mu.Unlock()
+	return nil
+}
+
+func (p *Provider) record(name string) (*record, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r, ok := p.records[name]
+	return r, ok
+}
+
+func (p *Provider) markDirty() {
+	atomic.StoreInt32(&p.dirty, 1)
+}
+
+// GroupPut puts values to several PVs at once, as described by types.GroupPuter:
+// if any of the values can't be put, none of the PVs are changed.
+func (p *Provider) GroupPut(ctx context.Context, values map[string]pvdata.PVStructure) error {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	records := make([]*record, len(names))
+	for i, name := range names {
+		r, ok := p.record(name)
+		if !ok {
+			return fmt.Errorf("unknown PV %q", name)
+		}
+		records[i] = r
+	}
+	// Records are always locked in name order, so concurrent group puts can't deadlock.
+	for _, r := range records {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+	}
+	newValues := make([]interface{}, len(records))
+	for i, r := range records {
+		nv, err := r.putValue(values[r.name])
+		if err != nil {
+			return err
+		}
+		newValues[i] = nv
+	}
+	for i, r := range records {
+		r.value = newValues[i]
+		r.changed()
+	}
+	return nil
+}
+
+func (p *Provider) CreateChannel(ctx context.Context, name string) (types.Channel, error) {
+	if r, ok := p.record(name); ok {
+		return r, nil
+	}
+	return nil, nil
+}
+
+func (p *Provider) ChannelList(ctx context.Context) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var names []string
+	for name := range p.records {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Restore loads the saved states from the store and applies them to the PVs.
+// States for PVs that have not been added yet are applied when they are added.
+// A state that cannot be applied (e.g. because the PV's type changed) is logged and skipped.
+func (p *Provider) Restore(ctx context.Context) error {
+	if p.store == nil {
+		return nil
+	}
+	states, err := p.store.Load(ctx)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for name, state := range states {
+		r, ok := p.records[name]
+		if !ok {
+			p.saved[name] = state
+			continue
+		}
+		if err := r.restore(state); err != nil {
+			ctxlog.L(ctx).Warnf("not restoring PV %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Save writes the state of every PV to the store.
+func (p *Provider) Save(ctx context.Context) error {
+	if p.store == nil {
+		return nil
+	}
+	p.mu.Lock()
+	states := make(map[string]State, len(p.records)+len(p.saved))
+	// Keep states of PVs that were never added, so they are not lost.
+	for name, state := range p.saved {
+		states[name] = state
+	}
+	records := make([]*record, 0, len(p.records))
+	for _, r := range p.records {
+		records = append(records, r)
+	}
+	// The PVs are clean from here on unless they change while they are saved, or the save fails.
+	atomic.StoreInt32(&p.dirty, 0)
+	p.mu.Unlock()
+	err := p.save(ctx, records, states)
+	if err != nil {
+		atomic.StoreInt32(&p.dirty, 1)
+	}
+	return err
+}
+
+// save adds the states of records to states, and writes them to the store.
+func (p *Provider) save(ctx context.Context, records []*record, states map[string]State) error {
+	for _, r := range records {
+		state, err := r.state()
+		if err != nil {
+			return fmt.Errorf("saving PV %q: %w", r.name, err)
+		}
+		states[r.name] = state
+	}
+	return p.store.Save(ctx, states)
+}
+
+// Autosave saves the state of the PVs every interval if any of them changed, until ctx is cancelled.
+// A final save is made before returning.
+func (p *Provider) Autosave(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if atomic.LoadInt32(&p.dirty) != 0 {
+				// ctx is already cancelled, so the final save can't use it.
+				if err := p.Save(context.Background()); err != nil {
+					return err
+				}
+			}
+			return ctx.Err()
+		case <-ticker.C:
+			if atomic.LoadInt32(&p.dirty) == 0 {
+				continue
+			}
+			if err := p.Save(ctx); err != nil {
+				ctxlog.L(ctx).Errorf("autosave failed: %v", err)
+			}
+		}
+	}
+}
+
+// State is the saved state of a PV.
+type State struct {
+	// Value is the JSON encoding of the PV's value.
+	Value   json.RawMessage `json:"value"`
+	Display *pvdata.Display `json:"display,omitempty"`
+}
@@ -0,0 +1,161 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/google/go-cmp/cmp"
+)
+
+func newTestProvider(t *testing.T, store Store) *Provider {
+	t.Helper()
+	p := New(store)
+	setpoint := pvdata.PVDouble(1.5)
+	mode := pvdata.Enum{Index: 0, Choices: []string{"off", "on"}}
+	for _, pv := range []PV{
+		{Name: "test:setpoint", Value: &setpoint, Display: pvdata.Display{Units: "mm"}},
+		{Name: "test:mode", Value: &mode},
+	} {
+		if err := p.Add(pv); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return p
+}
+
+func put(t *testing.T, p *Provider, name string, value interface{}) {
+	t.Helper()
+	c, _ := p.CreateChannel(context.Background(), name)
+	pvs, err := pvdata.NewPVStructure(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.(*record).ChannelPut(context.Background(), pvs); err != nil {
+		t.Fatalf("put to %s: %v", name, err)
+	}
+}
+
+func TestSaveRestore(t *testing.T) {
+	ctx := context.Background()
+	store := FileStore{Path: filepath.Join(t.TempDir(), "autosave.json")}
+
+	p := newTestProvider(t, store)
+	put(t, p, "test:setpoint", &struct {
+		Value pvdata.PVFloat `pvaccess:"value"`
+	}{7})
+	put(t, p, "test:mode", &struct {
+		Value pvdata.Enum `pvaccess:"value"`
+	}{pvdata.Enum{Index: 1}})
+	if err := p.SetDisplay("test:setpoint", pvdata.Display{Units: "um"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Save(ctx); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	p = newTestProvider(t, store)
+	if err := p.Restore(ctx); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	value, _ := p.Get("test:setpoint")
+	if got := *value.(*pvdata.PVDouble); got != 7 {
+		t.Errorf("restored setpoint = %v, want 7", got)
+	}
+	if _, display := p.records["test:setpoint"].get(); display.Units != "um" {
+		t.Errorf("restored units = %q, want %q", display.Units, "um")
+	}
+	value, _ = p.Get("test:mode")
+	if diff := cmp.Diff(value, &pvdata.Enum{Index: 1, Choices: []string{"off", "on"}}); diff != "" {
+		t.Errorf("restored mode: got(-)/want(+)\n%s", diff)
+	}
+}
+
+func TestAutosave(t *testing.T) {
+	store := FileStore{Path: filepath.Join(t.TempDir(), "autosave.json")}
+	p := newTestProvider(t, store)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- p.Autosave(ctx, time.Hour)
+	}()
+	value := pvdata.PVDouble(3)
+	if err := p.Set("test:setpoint", &value); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	<-done
+	states, err := store.Load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(states["test:setpoint"].Value); got != "3" {
+		t.Errorf("autosaved setpoint = %s, want 3", got)
+	}
+}
+
+// failingStore is a Store whose saves fail until fail is cleared.
+type failingStore struct {
+	fail  bool
+	saved map[string]State
+}
+
+func (s *failingStore) Load(ctx context.Context) (map[string]State, error) {
+	return s.saved, nil
+}
+
+func (s *failingStore) Save(ctx context.Context, states map[string]State) error {
+	if s.fail {
+		return errors.New("disk full")
+	}
+	s.saved = states
+	return nil
+}
+
+func TestFailedSave(t *testing.T) {
+	store := &failingStore{fail: true}
+	p := newTestProvider(t, store)
+	value := pvdata.PVDouble(3)
+	if err := p.Set("test:setpoint", &value); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Save(context.Background()); err == nil {
+		t.Fatal("Save to a failing store succeeded")
+	}
+	// The changes are still unsaved, so the final autosave saves them.
+	store.fail = false
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p.Autosave(ctx, time.Hour)
+	if got := string(store.saved["test:setpoint"].Value); got != "3" {
+		t.Errorf("setpoint saved after a failed save = %q, want 3", got)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	ctx := context.Background()
+	store := FileStore{Path: filepath.Join(t.TempDir(), "autosave.json")}
+	p := newTestProvider(t, store)
+	if err := p.Remove("test:mode"); err != nil {
+		t.Fatal(err)
+	}
+	if c, err := p.CreateChannel(ctx, "test:mode"); c != nil || err != nil {
+		t.Errorf("CreateChannel of a removed PV = %v, %v, want nil", c, err)
+	}
+	if err := p.Remove("test:mode"); err == nil {
+		t.Error("removing an unknown PV succeeded")
+	}
+	if err := p.Save(ctx); err != nil {
+		t.Fatal(err)
+	}
+	states, err := store.Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := states["test:mode"]; ok || len(states) != 1 {
+		t.Errorf("saved states %v, want only test:setpoint", states)
+	}
+}
@@ -0,0 +1,204 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/Lexcelon/go-pvaccess/types"
+)
+
+// record is a PV held by a Provider. It is also the PV's Channel.
+type record struct {
+	p    *Provider
+	name string
+	// typ is the type pointed to by value.
+	typ reflect.Type
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	seq     int
+	value   interface{}
+	display pvdata.Display
+}
+
+func newRecord(p *Provider, pv PV) *record {
+	r := &record{
+		p:       p,
+		name:    pv.Name,
+		typ:     reflect.TypeOf(pv.Value).Elem(),
+		value:   pv.Value,
+		display: pv.Display,
+	}
+	r.cond = sync.NewCond(&r.mu)
+	return r
+}
+
+// changed must be called with r.mu held after value or display are replaced.
+// Values are never modified in place, so they can be encoded without holding r.mu.
+func (r *record) changed() {
+	r.seq++
+	r.cond.Broadcast()
+	r.p.markDirty()
+}
+
+func (r *record) get() (interface{}, pvdata.Display) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.value, r.display
+}
+
+func (r *record) set(value interface{}) error {
+	if t := reflect.TypeOf(value); t == nil || t.Kind() != reflect.Ptr || t.Elem() != r.typ {
+		return fmt.Errorf("PV %q holds %v, got %T", r.name, r.typ, value)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.value = value
+	r.changed()
+	return nil
+}
+
+// restore must be called with r.p.mu held.
+func (r *record) restore(state State) error {
+	value := reflect.New(r.typ)
+	if err := json.Unmarshal(state.Value, value.Interface()); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.value = value.Interface()
+	if state.Display != nil {
+		r.display = *state.Display
+	}
+	r.seq++
+	r.cond.Broadcast()
+	return nil
+}
+
+func (r *record) state() (State, error) {
+	value, display := r.get()
+	data, err := json.Marshal(value)
+	if err != nil {
+		return State{}, err
+	}
+	return State{Value: data, Display: &display}, nil
+}
+
+func (r *record) Name() string {
+	return r.name
+}
+
+type ntScalar struct {
+	Value   interface{}    `pvaccess:"value"`
+	Display pvdata.Display `pvaccess:"display"`
+}
+
+func (ntScalar) TypeID() string {
+	return "epics:nt/NTScalar:1.0"
+}
+
+type ntScalarArray ntScalar
+
+func (ntScalarArray) TypeID() string {
+	return "epics:nt/NTScalarArray:1.0"
+}
+
+type ntEnum struct {
+	Value   interface{}    `pvaccess:"value"`
+	Display pvdata.Display `pvaccess:"display"`
+}
+
+func (ntEnum) TypeID() string {
+	return "epics:nt/NTEnum:1.0"
+}
+
+// structure returns the normative type structure for value.
+func (r *record) structure(value interface{}, display pvdata.Display) interface{} {
+	switch {
+	case r.typ == reflect.TypeOf(pvdata.Enum{}):
+		return &ntEnum{value, display}
+	case r.typ.Kind() == reflect.Slice:
+		return &ntScalarArray{value, display}
+	}
+	return &ntScalar{value, display}
+}
+
+func (r *record) ChannelGet(ctx context.Context) (interface{}, error) {
+	return r.structure(r.get()), nil
+}
+
+// ChannelPut replaces the value of the PV with the "value" field of value, converted to the PV's type.
+// For enums, only the index is changed and it must select one of the choices.
+func (r *record) ChannelPut(ctx context.Context, value pvdata.PVStructure) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	nv, err := r.putValue(value)
+	if err != nil {
+		return err
+	}
+	r.value = nv
+	r.changed()
+	return nil
+}
+
+// putValue returns the new value of the PV after value is put to it.
+// It must be called with r.mu held.
+func (r *record) putValue(value pvdata.PVStructure) (interface{}, error) {
+	field := value.Field("value")
+	if field == nil {
+		return nil, pvdata.Error("missing value field")
+	}
+	if old, ok := r.value.(*pvdata.Enum); ok {
+		index, ok := pvdata.IntValue(value.SubField("value", "index"))
+		if !ok {
+			return nil, fmt.Errorf("PV %q: missing enum index", r.name)
+		}
+		if index < 0 || index >= len(old.Choices) {
+			return nil, fmt.Errorf("PV %q: enum index %d out of range", r.name, index)
+		}
+		return &pvdata.Enum{Index: pvdata.PVInt(index), Choices: old.Choices}, nil
+	}
+	nv := reflect.ValueOf(field)
+	if nv.Kind() == reflect.Ptr {
+		nv = nv.Elem()
+	}
+	if !nv.Type().ConvertibleTo(r.typ) {
+		return nil, fmt.Errorf("PV %q: cannot convert %v to %v", r.name, nv.Type(), r.typ)
+	}
+	v := reflect.New(r.typ)
+	v.Elem().Set(nv.Convert(r.typ))
+	return v.Interface(), nil
+}
+
+type watch struct {
+	r   *record
+	seq int
+}
+
+func (w *watch) Next(ctx context.Context) (interface{}, error) {
+	r := w.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		r.cond.Broadcast()
+	}()
+	for w.seq >= r.seq {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		r.cond.Wait()
+	}
+	w.seq = r.seq
+	return r.structure(r.value, r.display), nil
+}
+
+func (r *record) CreateChannelMonitor(ctx context.Context, req pvdata.PVStructure) (types.Nexter, error) {
+	return &watch{r, -1}, nil
+}
@@ -0,0 +1,64 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Store persists the state of a Provider's PVs.
+type Store interface {
+	// Load returns the saved states, keyed by PV name.
+	Load(ctx context.Context) (map[string]State, error)
+	// Save replaces the saved states.
+	Save(ctx context.Context, states map[string]State) error
+}
+
+// FileStore stores PV states in a JSON file.
+type FileStore struct {
+	Path string
+}
+
+// Load reads the states from the file. A missing file has no states.
+func (f FileStore) Load(ctx context.Context) (map[string]State, error) {
+	data, err := os.ReadFile(f.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var states map[string]State
+	if err := json.Unmarshal(data, &states); err != nil {
+		return nil, err
+	}
+	return states, nil
+}
+
+// Save writes the states to a temporary file and then renames it over the file, so a crash never leaves a partial file.
+func (f FileStore) Save(ctx context.Context, states map[string]State) error {
+	data, err := json.MarshalIndent(states, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.Path), filepath.Base(f.Path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.Path)
+}
@@ -0,0 +1,259 @@
+// Package mirror implements a channel provider that re-serves channels of other servers.
+//
+// Each mirrored channel is created upstream with a client.Client the first time it is used,
+// and Get, Put, Monitor, and RPC operations are passed through to it. Mirrors can serve a channel
+// under a different name, which makes them the building block of aliases and simple gateways.
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/Lexcelon/go-pvaccess/client"
+	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/Lexcelon/go-pvaccess/types"
+)
+
+// Mirror configures a mirrored channel.
+type Mirror struct {
+	// Name is the name of the channel served by the Provider.
+	Name string
+	// Upstream is the name of the channel on the upstream servers. It defaults to Name.
+	Upstream string
+}
+
+// Provider is a ChannelProvider serving channels that are mirrored from upstream servers.
+type Provider struct {
+	client *client.Client
+
+	mu       sync.Mutex
+	channels map[string]*channel
+}
+
+// New returns a Provider serving mirrors, whose upstream channels are created with c.
+// The Provider doesn't close c.
+func New(c *client.Client, mirrors ...Mirror) (*Provider, error) {
+	p := &Provider{client: c, channels: make(map[string]*channel)}
+	for _, m := range mirrors {
+		if m.Name == "" {
+			return nil, errors.New("mirror has no name")
+		}
+		if _, ok := p.channels[m.Name]; ok {
+			return nil, fmt.Errorf("duplicate mirror %q", m.Name)
+		}
+		if m.Upstream == "" {
+			m.Upstream = m.Name
+		}
+		p.channels[m.Name] = &channel{p: p, Mirror: m}
+	}
+	return p, nil
+}
+
+func (p *Provider) CreateChannel(ctx context.Context, name string) (types.Channel, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c, ok := p.channels[name]; ok {
+		return c, nil
+	}
+	return nil, nil
+}
+
+func (p *Provider) ChannelList(ctx context.Context) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var names []string
+	for name := range p.channels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Close closes the upstream channels. Mirrors that are used again are re-created upstream.
+func (p *Provider) Close() error {
+	p.mu.Lock()
+	channels := make([]*channel, 0, len(p.channels))
+	for _, c := range p.channels {
+		channels = append(channels, c)
+	}
+	p.mu.Unlock()
+	for _, c := range channels {
+		c.closeUpstream()
+	}
+	return nil
+}
+
+// channel is a mirrored channel.
+type channel struct {
+	p *Provider
+	Mirror
+
+	mu sync.Mutex
+	// upstream is the upstream channel, or nil if it hasn't been created yet.
+	upstream *client.Channel
+}
+
+func (c *channel) Name() string {
+	return c.Mirror.Name
+}
+
+// connect returns the upstream channel, creating it if needed.
+func (c *channel) connect(ctx context.Context) (*client.Channel, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.upstream != nil {
+		return c.upstream, nil
+	}
+	up, err := c.p.client.Channel(ctx, c.Upstream)
+	if err != nil {
+		return nil, fmt.Errorf("upstream channel %q: %w", c.Upstream, err)
+	}
+	c.upstream = up
+	return up, nil
+}
+
+func (c *channel) closeUpstream() {
+	c.mu.Lock()
+	up := c.upstream
+	c.upstream = nil
+	c.mu.Unlock()
+	if up != nil {
+		up.Close()
+	}
+}
+
+// ChannelDisconnected closes the upstream channel once the mirror is no longer used.
+func (c *channel) ChannelDisconnected(ctx context.Context) {
+	c.closeUpstream()
+}
+
+func (c *channel) ChannelGet(ctx context.Context) (interface{}, error) {
+	up, err := c.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return up.Get(ctx)
+}
+
+func (c *channel) ChannelPut(ctx context.Context, value pvdata.PVStructure) error {
+	up, err := c.connect(ctx)
+	if err != nil {
+		return err
+	}
+	return up.Put(ctx, value)
+}
+
+// ChannelPutFields passes partial puts upstream, so that the upstream server merges them with its current value.
+func (c *channel) ChannelPutFields(ctx context.Context, value pvdata.PVStructure, changed []string) error {
+	up, err := c.connect(ctx)
+	if err != nil {
+		return err
+	}
+	return up.PutFields(ctx, value, changed)
+}
+
+func (c *channel) ChannelRPC(ctx context.Context, req pvdata.PVStructure) (interface{}, error) {
+	up, err := c.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return up.RPC(ctx, req)
+}
+
+// CreateChannelMonitor monitors the upstream channel with the same pvRequest.
+// The monitor lasts until ctx is cancelled, or until Next is cancelled.
+func (c *channel) CreateChannelMonitor(ctx context.Context, req pvdata.PVStructure) (types.Nexter, error) {
+	up, err := c.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m, err := up.Monitor(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		m.Close()
+	}()
+	return &monitor{m: m, name: c.Upstream}, nil
+}
+
+// monitor passes the updates of an upstream monitor on as full values.
+type monitor struct {
+	m    *client.Monitor
+	name string
+	// value is the last value returned by Next.
+	value pvdata.PVStructure
+	valid bool
+}
+
+func (m *monitor) Next(ctx context.Context) (interface{}, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			m.m.Close()
+			return nil, ctx.Err()
+		case e, ok := <-m.m.Events():
+			if !ok {
+				if err := m.m.Err(); err != nil {
+					return nil, err
+				}
+				return nil, client.ErrClosed
+			}
+			switch e.Kind {
+			case client.Disconnected:
+				// The upstream monitor is resumed when its channel reconnects, starting with a full update.
+				ctxlog.L(ctx).Warnf("upstream monitor on %q disconnected: %v", m.name, e.Err)
+				continue
+			case client.Update:
+			default:
+				continue
+			}
+			value, err := m.update(e)
+			if err != nil {
+				return nil, fmt.Errorf("upstream monitor on %q: %w", m.name, err)
+			}
+			if value == nil {
+				continue
+			}
+			return value, nil
+		}
+	}
+}
+
+// update returns the full value after e, or nil if it isn't known yet.
+// Partial updates are merged into a copy of the last value, because values returned by Next may still be in use.
+func (m *monitor) update(e client.Event) (interface{}, error) {
+	if e.Full {
+		m.value, m.valid = e.Value, true
+		return e.Value, nil
+	}
+	if !m.valid {
+		return nil, nil
+	}
+	fd, err := m.value.FieldDesc()
+	if err != nil {
+		return nil, err
+	}
+	zero, err := fd.Zero()
+	if err != nil {
+		return nil, err
+	}
+	value, ok := zero.(pvdata.PVStructure)
+	if !ok {
+		return nil, fmt.Errorf("value is %T, expected PVStructure", zero)
+	}
+	if err := value.SetFromMap(m.value.ToMap()); err != nil {
+		return nil, err
+	}
+	changed, _ := pvdata.ChangedFields(fd, e.Changed)
+	if err := value.CopyFields(e.Value, changed); err != nil {
+		return nil, err
+	}
+	m.value = value
+	return value, nil
+}
@@ -0,0 +1,131 @@
+package mirror
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+	"github.com/Lexcelon/go-pvaccess/client"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+// serve runs srv on a random port until the test ends.
+func serve(t *testing.T, srv *pvaccess.Server) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.Serve(ctx, ln)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return ln.Addr().String()
+}
+
+func value(t *testing.T, v pvdata.PVStructure) int64 {
+	t.Helper()
+	x, ok := pvdata.IntValue(v.Field("value"))
+	if !ok {
+		t.Fatalf("value %v has no integer value field", v)
+	}
+	return int64(x)
+}
+
+func TestMirror(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	up := pvaccess.NewSimpleChannel("dev:x")
+	x := pvdata.PVInt(1)
+	up.Set(&x)
+	upstream := &pvaccess.Server{DisableSearch: true}
+	upstream.AddChannelProvider(up)
+	upstreamClient := client.New(serve(t, upstream))
+	defer upstreamClient.Close()
+
+	p, err := New(upstreamClient, Mirror{Name: "alias:x", Upstream: "dev:x"}, Mirror{Name: "dev:x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	srv := &pvaccess.Server{DisableSearch: true}
+	srv.AddChannelProvider(p)
+	c := client.New(serve(t, srv))
+	defer c.Close()
+
+	ch, err := c.Channel(ctx, "alias:x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ch.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := value(t, got); v != 1 {
+		t.Errorf("Get = %d, want 1", v)
+	}
+	m, err := ch.Monitor(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	next := func() int64 {
+		t.Helper()
+		for {
+			select {
+			case e := <-m.Events():
+				if e.Kind == client.Update {
+					return value(t, e.Value)
+				}
+			case <-ctx.Done():
+				t.Fatal("timed out waiting for monitor update")
+			}
+		}
+	}
+	if v := next(); v != 1 {
+		t.Errorf("first monitor update = %d, want 1", v)
+	}
+	if err := ch.Put(ctx, &struct {
+		Value int64 `pvaccess:"value"`
+	}{2}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if v, ok := pvdata.IntValue(up.Get()); !ok || v != 2 {
+		t.Errorf("upstream value after Put = %v, want 2", up.Get())
+	}
+	if v := next(); v != 2 {
+		t.Errorf("monitor update after Put = %d, want 2", v)
+	}
+
+	names, err := p.ChannelList(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0] != "alias:x" || names[1] != "dev:x" {
+		t.Errorf("ChannelList = %v, want [alias:x dev:x]", names)
+	}
+	if _, err := c.Channel(ctx, "missing"); err == nil {
+		t.Error("creating unmirrored channel succeeded")
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	c := client.New()
+	defer c.Close()
+	for _, mirrors := range [][]Mirror{
+		{{Upstream: "x"}},
+		{{Name: "a"}, {Name: "a", Upstream: "b"}},
+	} {
+		if _, err := New(c, mirrors...); err == nil {
+			t.Errorf("New(%+v) succeeded", mirrors)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package record
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+// CSVSink writes events as CSV rows of time (RFC 3339), channel, and value.
+// For normative types, the value column holds the "value" field.
+type CSVSink struct {
+	mu sync.Mutex
+	w  *csv.Writer
+}
+
+// NewCSVSink returns a CSVSink writing to w.
+func NewCSVSink(w io.Writer) *CSVSink {
+	return &CSVSink{w: csv.NewWriter(w)}
+}
+
+func (s *CSVSink) Record(ctx context.Context, e Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.w.Write([]string{
+		e.Time.Format(time.RFC3339Nano),
+		e.Channel,
+		formatValue(e.Value),
+	}); err != nil {
+		return err
+	}
+	s.w.Flush()
+	return s.w.Error()
+}
+
+func formatValue(value interface{}) string {
+	if pvs, err := pvdata.NewPVStructure(value); err == nil {
+		if field := pvs.Field("value"); field != nil {
+			value = field
+		}
+	}
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return ""
+	}
+	return fmt.Sprint(v.Interface())
+}
@@ -0,0 +1,128 @@
+// Package record implements a channel provider wrapper that records monitor events to a Sink.
+//
+// Wrapping a provider with a recorder allows lightweight archiving of its channels from a Go service.
+package record
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/Lexcelon/go-pvaccess/types"
+)
+
+// Event is a single update of a channel.
+type Event struct {
+	Channel string
+	// Time is when the update was received by the recorder.
+	Time time.Time
+	// Value is the value returned by the channel's monitor.
+	Value interface{}
+}
+
+// Sink stores recorded events.
+// Record is called from one goroutine per channel, so implementations must be safe for concurrent use.
+type Sink interface {
+	Record(ctx context.Context, e Event) error
+}
+
+// SinkFunc adapts a function to a Sink.
+type SinkFunc func(ctx context.Context, e Event) error
+
+func (f SinkFunc) Record(ctx context.Context, e Event) error {
+	return f(ctx, e)
+}
+
+// Provider wraps a ChannelProvider, serving its channels unchanged while recording their monitor events.
+type Provider struct {
+	types.ChannelProvider
+	sink Sink
+	// Names are the channels to record. If empty, all channels listed by the wrapped provider are recorded.
+	Names []string
+}
+
+// Wrap returns a Provider recording the channels of p to sink.
+func Wrap(p types.ChannelProvider, sink Sink) *Provider {
+	return &Provider{ChannelProvider: p, sink: sink}
+}
+
+func (p *Provider) ChannelList(ctx context.Context) ([]string, error) {
+	if l, ok := p.ChannelProvider.(types.ChannelLister); ok {
+		return l.ChannelList(ctx)
+	}
+	return nil, nil
+}
+
+// ChannelFind reports whether the wrapped provider has the channel, asking its ChannelFind if it has one.
+func (p *Provider) ChannelFind(ctx context.Context, name string) (bool, error) {
+	if f, ok := p.ChannelProvider.(types.ChannelFinder); ok {
+		return f.ChannelFind(ctx, name)
+	}
+	c, err := p.ChannelProvider.CreateChannel(ctx, name)
+	return c != nil, err
+}
+
+// Run records events until ctx is cancelled.
+// Channels that can't be created or monitored are logged and skipped.
+func (p *Provider) Run(ctx context.Context) error {
+	names := p.Names
+	if len(names) == 0 {
+		var err error
+		names, err = p.ChannelList(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	if len(names) == 0 {
+		return errors.New("no channels to record")
+	}
+	var wg sync.WaitGroup
+	for _, name := range names {
+		name := name
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx := ctxlog.WithField(ctx, "channel", name)
+			if err := p.record(ctx, name); err != nil && ctx.Err() == nil {
+				ctxlog.L(ctx).Warnf("not recording channel: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+func (p *Provider) record(ctx context.Context, name string) error {
+	c, err := p.CreateChannel(ctx, name)
+	if err != nil {
+		return err
+	}
+	if c == nil {
+		return fmt.Errorf("channel %q not found", name)
+	}
+	mc, ok := c.(types.ChannelMonitorCreator)
+	if !ok {
+		return fmt.Errorf("channel %q does not support Monitor", name)
+	}
+	req, err := pvdata.NewPVStructure(&struct{}{})
+	if err != nil {
+		return err
+	}
+	nexter, err := mc.CreateChannelMonitor(ctx, req)
+	if err != nil {
+		return err
+	}
+	for {
+		value, err := nexter.Next(ctx)
+		if err != nil {
+			return err
+		}
+		if err := p.sink.Record(ctx, Event{Channel: name, Time: time.Now(), Value: value}); err != nil {
+			ctxlog.L(ctx).Errorf("recording event: %v", err)
+		}
+	}
+}
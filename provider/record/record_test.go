@@ -0,0 +1,96 @@
+package record
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+	"github.com/Lexcelon/go-pvaccess/internal/connection"
+	"github.com/Lexcelon/go-pvaccess/internal/search"
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/Lexcelon/go-pvaccess/provider/memory"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/Lexcelon/go-pvaccess/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRecordCSV(t *testing.T) {
+	c := pvaccess.NewSimpleChannel("test")
+	value := pvdata.PVLong(1)
+	c.Set(&value)
+
+	var buf bytes.Buffer
+	sink := NewCSVSink(&buf)
+	events := make(chan Event)
+	p := Wrap(c, SinkFunc(func(ctx context.Context, e Event) error {
+		if err := sink.Record(ctx, e); err != nil {
+			return err
+		}
+		events <- e
+		return nil
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+	<-events
+	value2 := pvdata.PVLong(2)
+	c.Set(&value2)
+	<-events
+	cancel()
+	<-done
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d rows, want 2:\n%s", len(lines), buf.String())
+	}
+	for i, want := range []string{",test,1", ",test,2"} {
+		if !strings.HasSuffix(lines[i], want) {
+			t.Errorf("row %d = %q, want suffix %q", i, lines[i], want)
+		}
+	}
+}
+
+type providers []types.ChannelProvider
+
+func (p providers) ChannelProviders() []types.ChannelProvider { return p }
+
+// TestSearch checks that the channels of a wrapped provider that can't find channels without creating them
+// are still found by searches.
+func TestSearch(t *testing.T) {
+	ctx := context.Background()
+	m := memory.New(nil)
+	x := pvdata.PVLong(1)
+	if err := m.Add(memory.PV{Name: "x", Value: &x}); err != nil {
+		t.Fatal(err)
+	}
+	s := &search.Server{ServerAddr: &net.TCPAddr{Port: 5075}, Server: providers{Wrap(m, SinkFunc(func(ctx context.Context, e Event) error { return nil }))}}
+	var buf bytes.Buffer
+	c := connection.New(&buf, proto.FLAG_FROM_SERVER)
+	if err := s.Search(ctx, c, proto.SearchRequest{
+		Channels: []proto.SearchRequest_Channel{
+			{SearchInstanceID: 1, ChannelName: "x"},
+			{SearchInstanceID: 2, ChannelName: "missing"},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := connection.New(&buf, proto.FLAG_FROM_CLIENT).Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp proto.SearchResponse
+	if err := msg.Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]pvdata.PVUInt{1}, resp.SearchInstanceIDs); diff != "" {
+		t.Errorf("channels found differ (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,115 @@
+// Package rewrite implements a channel provider that rewrites the names of channels before looking them up
+// in another provider, so that one server can front devices whose native names differ from the site's
+// naming convention.
+//
+// Rules are tried in order, and the first that matches a name rewrites it. Channels keep their native names,
+// which are what the server's access control and listings see.
+package rewrite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Lexcelon/go-pvaccess/types"
+)
+
+// Rule rewrites the channel names that it matches. Exactly one of Prefix and Pattern must be set.
+type Rule struct {
+	// Prefix matches names that start with it, and is replaced by Replacement:
+	// e.g. the prefix "SITE:" with the replacement "dev1:" rewrites SITE:temp to dev1:temp.
+	Prefix string
+	// Pattern is a regular expression that must match the whole name. The name is replaced by Replacement,
+	// in which $1 or ${name} stand for submatches, as in regexp.Regexp.Expand.
+	Pattern     string
+	Replacement string
+}
+
+type rule struct {
+	Rule
+	re *regexp.Regexp
+}
+
+// rewrite returns the rewritten name, and whether r matches name.
+func (r *rule) rewrite(name string) (string, bool) {
+	if r.re == nil {
+		if !strings.HasPrefix(name, r.Prefix) {
+			return "", false
+		}
+		return r.Replacement + name[len(r.Prefix):], true
+	}
+	m := r.re.FindStringSubmatchIndex(name)
+	if m == nil {
+		return "", false
+	}
+	return string(r.re.ExpandString(nil, r.Replacement, name, m)), true
+}
+
+// Provider is a ChannelProvider serving the channels of another provider under rewritten names.
+// Names that no rule matches are looked up unchanged, unless Strict is set.
+type Provider struct {
+	source types.ChannelProvider
+	rules  []rule
+
+	// Strict makes names that no rule matches unknown, so that only rewritten names reach the source.
+	// It must be set before the Provider is used.
+	Strict bool
+}
+
+// New returns a Provider serving channels of source, whose names are rewritten by rules.
+func New(source types.ChannelProvider, rules ...Rule) (*Provider, error) {
+	if source == nil {
+		return nil, errors.New("rewrite provider has no source")
+	}
+	p := &Provider{source: source}
+	for i, r := range rules {
+		compiled := rule{Rule: r}
+		switch {
+		case r.Prefix == "" && r.Pattern == "":
+			return nil, fmt.Errorf("rewrite rule %d has neither a prefix nor a pattern", i)
+		case r.Prefix != "" && r.Pattern != "":
+			return nil, fmt.Errorf("rewrite rule %d has both a prefix and a pattern", i)
+		case r.Pattern != "":
+			re, err := regexp.Compile(`^(?:` + r.Pattern + `)$`)
+			if err != nil {
+				return nil, fmt.Errorf("rewrite rule %d: %w", i, err)
+			}
+			compiled.re = re
+		}
+		p.rules = append(p.rules, compiled)
+	}
+	return p, nil
+}
+
+// Rewrite returns the name that name is looked up as in the source, and false if it isn't looked up at all.
+func (p *Provider) Rewrite(name string) (string, bool) {
+	for i := range p.rules {
+		if rewritten, ok := p.rules[i].rewrite(name); ok {
+			return rewritten, true
+		}
+	}
+	return name, !p.Strict
+}
+
+func (p *Provider) CreateChannel(ctx context.Context, name string) (types.Channel, error) {
+	rewritten, ok := p.Rewrite(name)
+	if !ok {
+		return nil, nil
+	}
+	return p.source.CreateChannel(ctx, rewritten)
+}
+
+// ChannelFind reports whether the source has the channel, asking the source's ChannelFind if it has one.
+func (p *Provider) ChannelFind(ctx context.Context, name string) (bool, error) {
+	rewritten, ok := p.Rewrite(name)
+	if !ok {
+		return false, nil
+	}
+	if f, ok := p.source.(types.ChannelFinder); ok {
+		return f.ChannelFind(ctx, rewritten)
+	}
+	c, err := p.source.CreateChannel(ctx, rewritten)
+	return c != nil, err
+}
@@ -0,0 +1,76 @@
+package rewrite
+
+import (
+	"context"
+	"testing"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+	"github.com/Lexcelon/go-pvaccess/types"
+)
+
+// source serves SimpleChannels by their native names.
+type source map[string]*pvaccess.SimpleChannel
+
+func (s source) CreateChannel(ctx context.Context, name string) (types.Channel, error) {
+	if c, ok := s[name]; ok {
+		return c, nil
+	}
+	return nil, nil
+}
+
+func TestRewrite(t *testing.T) {
+	ctx := context.Background()
+	src := source{}
+	for _, name := range []string{"dev1:temp", "psu-3.voltage", "plain"} {
+		src[name] = pvaccess.NewSimpleChannel(name)
+	}
+	p, err := New(src,
+		Rule{Prefix: "SITE:T:", Replacement: "dev1:"},
+		Rule{Pattern: `SITE:PSU(\d+):(\w+)`, Replacement: "psu-$1.${2}"},
+		Rule{Prefix: "SITE:", Replacement: "unused:"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name, want string
+	}{
+		{"SITE:T:temp", "dev1:temp"},
+		{"SITE:PSU3:voltage", "psu-3.voltage"},
+		{"SITE:PSU3:voltage:extra", ""},
+		{"SITE:other", ""},
+		{"plain", "plain"},
+		{"missing", ""},
+	} {
+		c, err := p.CreateChannel(ctx, test.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if c != nil {
+			got = c.Name()
+		}
+		if got != test.want {
+			t.Errorf("CreateChannel(%q) = %q, want %q", test.name, got, test.want)
+		}
+		if found, err := p.ChannelFind(ctx, test.name); err != nil || found != (test.want != "") {
+			t.Errorf("ChannelFind(%q) = %v, %v", test.name, found, err)
+		}
+	}
+	p.Strict = true
+	if c, _ := p.CreateChannel(ctx, "plain"); c != nil {
+		t.Errorf("strict CreateChannel(%q) = %q, want no channel", "plain", c.Name())
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	for _, rule := range []Rule{
+		{Replacement: "x"},
+		{Prefix: "a", Pattern: "b"},
+		{Pattern: "("},
+	} {
+		if _, err := New(source{}, rule); err == nil {
+			t.Errorf("New(%+v) succeeded", rule)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+// Package sim implements a channel provider that serves simulated PVs.
+//
+// Simulated PVs are useful for demos, for load testing monitors, and for developing clients without an IOC.
+package sim
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/Lexcelon/go-pvaccess/types"
+)
+
+// Waveform selects how a simulated PV's value changes over time.
+type Waveform int
+
+const (
+	// Sine oscillates between Offset-Amplitude and Offset+Amplitude.
+	Sine Waveform = iota
+	// Ramp rises linearly from Offset to Offset+Amplitude and then jumps back.
+	Ramp
+	// Noise is uniformly distributed between Offset-Amplitude and Offset+Amplitude.
+	Noise
+	// Counter starts at Offset and increases by one on every update.
+	Counter
+)
+
+var waveformNames = map[Waveform]string{
+	Sine:    "sine",
+	Ramp:    "ramp",
+	Noise:   "noise",
+	Counter: "counter",
+}
+
+func (w Waveform) String() string {
+	if name, ok := waveformNames[w]; ok {
+		return name
+	}
+	return fmt.Sprintf("Waveform(%d)", int(w))
+}
+
+// ParseWaveform returns the Waveform with the given name, as returned by String.
+func ParseWaveform(name string) (Waveform, error) {
+	for w, n := range waveformNames {
+		if n == name {
+			return w, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown waveform %q", name)
+}
+
+// PV configures a simulated PV.
+type PV struct {
+	Name     string
+	Waveform Waveform
+	// Amplitude and Offset scale the waveform. They are ignored for Counter, except that it starts at Offset.
+	Amplitude, Offset float64
+	// Period is the period of Sine and Ramp waveforms. It defaults to 10 seconds.
+	Period time.Duration
+	// Interval is the time between updates. It defaults to 1 second.
+	Interval time.Duration
+}
+
+// value returns the value of the waveform elapsed after the start of the simulation, on update number n.
+func (pv PV) value(elapsed time.Duration, n int64) interface{} {
+	phase := float64(elapsed) / float64(pv.Period)
+	switch pv.Waveform {
+	case Sine:
+		return pvdata.PVDouble(pv.Offset + pv.Amplitude*math.Sin(2*math.Pi*phase))
+	case Ramp:
+		return pvdata.PVDouble(pv.Offset + pv.Amplitude*(phase-math.Floor(phase)))
+	case Noise:
+		return pvdata.PVDouble(pv.Offset + pv.Amplitude*(2*rand.Float64()-1))
+	default:
+		return pvdata.PVLong(int64(pv.Offset) + n)
+	}
+}
+
+// simPV is a running simulated PV.
+type simPV struct {
+	channel *pvaccess.SimpleChannel
+
+	mu     sync.Mutex
+	config PV
+	// reset is signalled when config changes.
+	reset chan struct{}
+	// stop stops the PV's updates, once Run has started them.
+	stop context.CancelFunc
+}
+
+func (s *simPV) getConfig() PV {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.config
+}
+
+func (s *simPV) update(start time.Time, n int64) {
+	v := s.getConfig().value(time.Since(start), n)
+	switch v := v.(type) {
+	case pvdata.PVDouble:
+		s.channel.Set(&v)
+	case pvdata.PVLong:
+		s.channel.Set(&v)
+	}
+}
+
+func (s *simPV) run(ctx context.Context, start time.Time) {
+	var n int64
+	s.update(start, n)
+	for {
+		timer := time.NewTimer(s.getConfig().Interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-s.reset:
+			timer.Stop()
+		case <-timer.C:
+			n++
+			s.update(start, n)
+		}
+	}
+}
+
+// Provider is a ChannelProvider serving simulated PVs.
+// PVs only update while Run is running.
+type Provider struct {
+	mu  sync.Mutex
+	pvs map[string]*simPV
+	// ctx and start are the context and start time of Run while it is running.
+	ctx   context.Context
+	start time.Time
+	wg    sync.WaitGroup
+}
+
+// New returns a Provider serving pvs.
+func New(pvs ...PV) (*Provider, error) {
+	p := &Provider{
+		pvs: make(map[string]*simPV),
+	}
+	for _, pv := range pvs {
+		if err := p.add(pv); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (p *Provider) add(pv PV) error {
+	if pv.Name == "" {
+		return fmt.Errorf("simulated PV has no name")
+	}
+	if _, ok := p.pvs[pv.Name]; ok {
+		return fmt.Errorf("duplicate simulated PV %q", pv.Name)
+	}
+	pv = withDefaults(pv)
+	s := &simPV{
+		channel: pvaccess.NewSimpleChannel(pv.Name),
+		config:  pv,
+		reset:   make(chan struct{}, 1),
+	}
+	s.update(time.Now(), 0)
+	p.pvs[pv.Name] = s
+	return nil
+}
+
+func withDefaults(pv PV) PV {
+	if pv.Period <= 0 {
+		pv.Period = 10 * time.Second
+	}
+	if pv.Interval <= 0 {
+		pv.Interval = time.Second
+	}
+	return pv
+}
+
+// Run updates the simulated PVs until ctx is cancelled.
+func (p *Provider) Run(ctx context.Context) error {
+	p.mu.Lock()
+	p.ctx, p.start = ctx, time.Now()
+	for _, s := range p.pvs {
+		p.startLocked(s)
+	}
+	p.mu.Unlock()
+	<-ctx.Done()
+	p.mu.Lock()
+	p.ctx = nil
+	p.mu.Unlock()
+	p.wg.Wait()
+	return ctx.Err()
+}
+
+// startLocked starts updating s while Run is running.
+func (p *Provider) startLocked(s *simPV) {
+	ctx, cancel := context.WithCancel(p.ctx)
+	s.stop = cancel
+	start := p.start
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		s.run(ctx, start)
+	}()
+}
+
+// Add adds a simulated PV, which starts updating at once if Run is running.
+func (p *Provider) Add(pv PV) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.add(pv); err != nil {
+		return err
+	}
+	if p.ctx != nil {
+		p.startLocked(p.pvs[pv.Name])
+	}
+	return nil
+}
+
+// Remove stops serving the named PV. Clients that already created its channel keep it, but it no longer updates.
+func (p *Provider) Remove(name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, ok := p.pvs[name]
+	if !ok {
+		return fmt.Errorf("unknown simulated PV %q", name)
+	}
+	delete(p.pvs, name)
+	if s.stop != nil {
+		s.stop()
+	}
+	return nil
+}
+
+// Reconfigure replaces the configuration of the PV named pv.Name, keeping its channel.
+func (p *Provider) Reconfigure(pv PV) error {
+	return p.reconfigure(pv.Name, func(config *PV) {
+		*config = pv
+	})
+}
+
+// SetInterval changes the time between updates of the named PV.
+func (p *Provider) SetInterval(name string, interval time.Duration) error {
+	return p.reconfigure(name, func(pv *PV) {
+		pv.Interval = interval
+	})
+}
+
+// SetWaveform changes the waveform of the named PV.
+func (p *Provider) SetWaveform(name string, w Waveform) error {
+	return p.reconfigure(name, func(pv *PV) {
+		pv.Waveform = w
+	})
+}
+
+func (p *Provider) reconfigure(name string, f func(*PV)) error {
+	p.mu.Lock()
+	s, ok := p.pvs[name]
+	p.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown simulated PV %q", name)
+	}
+	s.mu.Lock()
+	f(&s.config)
+	s.config = withDefaults(s.config)
+	s.mu.Unlock()
+	select {
+	case s.reset <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (p *Provider) CreateChannel(ctx context.Context, name string) (types.Channel, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if s, ok := p.pvs[name]; ok {
+		return &channel{s.channel}, nil
+	}
+	return nil, nil
+}
+
+func (p *Provider) ChannelList(ctx context.Context) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var names []string
+	for name := range p.pvs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// channel is a read-only view of a simulated PV.
+type channel struct {
+	c *pvaccess.SimpleChannel
+}
+
+func (c *channel) Name() string {
+	return c.c.Name()
+}
+
+func (c *channel) ChannelGet(ctx context.Context) (interface{}, error) {
+	return c.c.ChannelGet(ctx)
+}
+
+func (c *channel) CreateChannelMonitor(ctx context.Context, req pvdata.PVStructure) (types.Nexter, error) {
+	return c.c.CreateChannelMonitor(ctx, req)
+}
@@ -0,0 +1,108 @@
+package sim
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+func TestWaveforms(t *testing.T) {
+	for _, test := range []struct {
+		pv      PV
+		elapsed time.Duration
+		n       int64
+		want    float64
+	}{
+		{PV{Waveform: Sine, Amplitude: 2, Offset: 1, Period: 4 * time.Second}, time.Second, 1, 3},
+		{PV{Waveform: Sine, Amplitude: 2, Offset: 1, Period: 4 * time.Second}, 3 * time.Second, 3, -1},
+		{PV{Waveform: Ramp, Amplitude: 10, Period: 4 * time.Second}, time.Second, 1, 2.5},
+		{PV{Waveform: Ramp, Amplitude: 10, Period: 4 * time.Second}, 5 * time.Second, 5, 2.5},
+		{PV{Waveform: Counter, Offset: 100}, time.Hour, 7, 107},
+	} {
+		got, ok := pvdata.FloatValue(test.pv.value(test.elapsed, test.n))
+		if !ok || math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%v at %v: got %v, want %v", test.pv.Waveform, test.elapsed, got, test.want)
+		}
+	}
+}
+
+func TestProvider(t *testing.T) {
+	p, err := New(PV{Name: "noise", Waveform: Noise, Amplitude: 1, Interval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c, err := p.CreateChannel(ctx, "noise")
+	if err != nil || c == nil {
+		t.Fatalf("CreateChannel = %v, %v", c, err)
+	}
+	go p.Run(ctx)
+	w, err := c.(*channel).CreateChannelMonitor(ctx, pvdata.PVStructure{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := w.Next(ctx); err != nil {
+			t.Fatalf("waiting for update %d: %v", i, err)
+		}
+	}
+	if err := p.SetInterval("missing", time.Second); err == nil {
+		t.Error("SetInterval succeeded on unknown PV")
+	}
+}
+
+func TestAddRemove(t *testing.T) {
+	p, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- p.Run(ctx) }()
+	if err := p.Add(PV{Name: "counter", Waveform: Counter, Interval: time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Add(PV{Name: "counter"}); err == nil {
+		t.Error("adding a duplicate PV succeeded")
+	}
+	c, err := p.CreateChannel(ctx, "counter")
+	if err != nil || c == nil {
+		t.Fatalf("CreateChannel = %v, %v", c, err)
+	}
+	w, err := c.(*channel).CreateChannelMonitor(ctx, pvdata.PVStructure{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := w.Next(ctx); err != nil {
+			t.Fatalf("waiting for update %d: %v", i, err)
+		}
+	}
+	if err := p.Reconfigure(PV{Name: "counter", Waveform: Counter, Offset: 1000, Interval: time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if _, err := w.Next(ctx); err != nil {
+			t.Fatalf("waiting for reconfigured update: %v", err)
+		}
+		if n, _ := pvdata.IntValue(c.(*channel).c.Get()); n >= 1000 {
+			break
+		}
+	}
+	if err := p.Remove("counter"); err != nil {
+		t.Fatal(err)
+	}
+	if c, err := p.CreateChannel(ctx, "counter"); c != nil || err != nil {
+		t.Errorf("CreateChannel of a removed PV = %v, %v, want nil", c, err)
+	}
+	if err := p.Remove("counter"); err == nil {
+		t.Error("removing an unknown PV succeeded")
+	}
+	cancel()
+	<-done
+}
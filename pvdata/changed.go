@@ -0,0 +1,152 @@
+package pvdata
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ChangedFields returns the names of the fields of a structure described by fd whose bits are set in changed,
+// numbered as in PVStructureDiff. Nested fields are named by their path, e.g. "value.x".
+// A changed structure is named instead of its fields.
+//
+// all reports whether the whole structure changed. If it did, fields lists every top-level field.
+func ChangedFields(fd FieldDesc, changed PVBitSet) (fields []string, all bool) {
+	if changed.Get(0) {
+		for _, f := range fd.Fields {
+			fields = append(fields, f.Name)
+		}
+		return fields, true
+	}
+	index := 0
+	var walk func(fds []StructFieldDesc, path string) bool
+	walk = func(fds []StructFieldDesc, path string) bool {
+		every := true
+		for _, f := range fds {
+			index++
+			name := joinPath(path, f.Name)
+			if changed.Get(index) {
+				fields = append(fields, name)
+				index += countFields(f.Field)
+				continue
+			}
+			if f.Field.TypeCode != STRUCT || !walk(f.Field.Fields, name) {
+				every = false
+			}
+		}
+		return every
+	}
+	all = walk(fd.Fields, "")
+	return fields, all
+}
+
+// FieldsBitSet returns the bitset marking the named fields of a structure described by fd,
+// numbered as in PVStructureDiff. It is the inverse of ChangedFields.
+func FieldsBitSet(fd FieldDesc, fields []string) (PVBitSet, error) {
+	var bits []int
+	for _, name := range fields {
+		bit, err := fieldBit(fd, name)
+		if err != nil {
+			return PVBitSet{}, err
+		}
+		bits = append(bits, bit)
+	}
+	return NewBitSetWithBits(bits...), nil
+}
+
+// fieldBit returns the bit of the field with the dotted path name in a structure described by fd.
+func fieldBit(fd FieldDesc, path string) (int, error) {
+	bit := 0
+	for _, name := range strings.Split(path, ".") {
+		if fd.TypeCode != STRUCT {
+			return 0, fmt.Errorf("no field %q: not a structure", path)
+		}
+		found := false
+		for _, f := range fd.Fields {
+			bit++
+			if f.Name == name {
+				fd, found = f.Field, true
+				break
+			}
+			bit += countFields(f.Field)
+		}
+		if !found {
+			return 0, fmt.Errorf("no field %q", path)
+		}
+	}
+	return bit, nil
+}
+
+// countFields returns the number of bits used by the fields nested in a field described by fd.
+func countFields(fd FieldDesc) int {
+	if fd.TypeCode != STRUCT {
+		return 0
+	}
+	n := 0
+	for _, f := range fd.Fields {
+		n += 1 + countFields(f.Field)
+	}
+	return n
+}
+
+// CopyFields sets the fields of v named in fields (as returned by ChangedFields) to the fields with the same names in src.
+// v must have been created by FieldDesc.Zero, or from a pointer, so that its fields can be set.
+// Values are copied shallowly, so v may share slices with src.
+func (v PVStructure) CopyFields(src PVStructure, fields []string) error {
+	for _, name := range fields {
+		dst, err := fieldByPath(v.v, name)
+		if err != nil {
+			return err
+		}
+		sv, err := fieldByPath(src.v, name)
+		if err != nil {
+			return err
+		}
+		if !dst.CanSet() {
+			return fmt.Errorf("field %q is not settable", name)
+		}
+		if !sv.Type().AssignableTo(dst.Type()) {
+			return fmt.Errorf("field %q is %v, can't copy %v", name, dst.Type(), sv.Type())
+		}
+		dst.Set(sv)
+	}
+	return nil
+}
+
+// fieldByPath returns the field of the struct v with the dotted path name.
+func fieldByPath(v reflect.Value, path string) (reflect.Value, error) {
+	for _, name := range strings.Split(path, ".") {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, fmt.Errorf("no field %q: absent structure", path)
+			}
+			v = v.Elem()
+		}
+		if v.Type() == pvStructureType {
+			v = v.Interface().(PVStructure).v
+		}
+		if v.Kind() != reflect.Struct {
+			return reflect.Value{}, fmt.Errorf("no field %q: %v is not a structure", path, v.Type())
+		}
+		f, ok := structField(v, name)
+		if !ok {
+			return reflect.Value{}, fmt.Errorf("no field %q", path)
+		}
+		v = f
+	}
+	return v, nil
+}
+
+func structField(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < v.NumField(); i++ {
+		got, _ := parseTag(t.Field(i).Tag.Get("pvaccess"))
+		if got == "" {
+			got = t.Field(i).Name
+		}
+		if got == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
@@ -0,0 +1,143 @@
+package pvdata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type changedInner struct {
+	X PVDouble `pvaccess:"x"`
+	Y PVDouble `pvaccess:"y"`
+}
+
+type changedT struct {
+	Value changedInner `pvaccess:"value"`
+	Label PVString     `pvaccess:"label"`
+}
+
+func TestChangedFields(t *testing.T) {
+	fd, err := FieldDescOf(&changedT{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		bits    []int
+		want    []string
+		wantAll bool
+	}{
+		{[]int{0}, []string{"value", "label"}, true},
+		{[]int{2}, []string{"value.x"}, false},
+		{[]int{1}, []string{"value"}, false},
+		{[]int{1, 2}, []string{"value"}, false},
+		{[]int{3, 4}, []string{"value.y", "label"}, false},
+		{[]int{1, 4}, []string{"value", "label"}, true},
+	} {
+		got, all := ChangedFields(fd, NewBitSetWithBits(test.bits...))
+		if diff := cmp.Diff(test.want, got); diff != "" || all != test.wantAll {
+			t.Errorf("ChangedFields(%v) = %v, %v; want %v, %v", test.bits, got, all, test.want, test.wantAll)
+		}
+	}
+}
+
+func TestFieldsBitSet(t *testing.T) {
+	fd, err := FieldDescOf(&changedT{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		fields []string
+		want   []int
+	}{
+		{[]string{"value"}, []int{1}},
+		{[]string{"value.x"}, []int{2}},
+		{[]string{"value.y", "label"}, []int{3, 4}},
+	} {
+		got, err := FieldsBitSet(fd, test.fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(NewBitSetWithBits(test.want...), got); diff != "" {
+			t.Errorf("FieldsBitSet(%v) differs (-want +got):\n%s", test.fields, diff)
+		}
+		if back, _ := ChangedFields(fd, got); !cmp.Equal(back, test.fields) {
+			t.Errorf("ChangedFields(FieldsBitSet(%v)) = %v", test.fields, back)
+		}
+	}
+	for _, fields := range [][]string{{"value.z"}, {"label.x"}} {
+		if _, err := FieldsBitSet(fd, fields); err == nil {
+			t.Errorf("FieldsBitSet(%v) succeeded", fields)
+		}
+	}
+}
+
+func TestPartialStructureDiff(t *testing.T) {
+	src := &changedT{Value: changedInner{1, 2}, Label: "a"}
+	for _, test := range []struct {
+		bits []int
+		want changedT
+	}{
+		{[]int{2}, changedT{Value: changedInner{X: 1}}},
+		{[]int{1}, changedT{Value: changedInner{1, 2}}},
+		// Setting the structure's bit includes its nested fields.
+		{[]int{0}, *src},
+	} {
+		var buf bytes.Buffer
+		if err := Encode(&EncoderState{Buf: &buf, ByteOrder: binary.LittleEndian}, &PVStructureDiff{
+			ChangedBitSet: NewBitSetWithBits(test.bits...),
+			Value:         src,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		var got changedT
+		diff := PVStructureDiff{Value: &got}
+		if err := Decode(&DecoderState{Buf: &buf, ByteOrder: binary.LittleEndian}, &diff); err != nil {
+			t.Fatal(err)
+		}
+		if d := cmp.Diff(test.want, got); d != "" {
+			t.Errorf("bits %v: decoded value differs (-want +got):\n%s", test.bits, d)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("bits %v: %d bytes left after decoding", test.bits, buf.Len())
+		}
+	}
+}
+
+func TestCopyFields(t *testing.T) {
+	fd, err := FieldDescOf(&changedT{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	zero, err := fd.Zero()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := zero.(PVStructure)
+	if err := dst.SetFromMap(map[string]interface{}{
+		"value": map[string]interface{}{"x": 1.0, "y": 2.0},
+		"label": "old",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	zero, err = fd.Zero()
+	if err != nil {
+		t.Fatal(err)
+	}
+	put := zero.(PVStructure)
+	*put.SubField("value", "x").(*PVDouble) = 10
+	if err := dst.CopyFields(put, []string{"value.x"}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"value": map[string]interface{}{"x": 10.0, "y": 2.0},
+		"label": "old",
+	}
+	if diff := cmp.Diff(want, dst.ToMap()); diff != "" {
+		t.Errorf("after CopyFields (-want +got):\n%s", diff)
+	}
+	if err := dst.CopyFields(put, []string{"value.z"}); err == nil {
+		t.Error("CopyFields of missing field succeeded")
+	}
+}
@@ -0,0 +1,117 @@
+package pvdata
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+)
+
+// fieldDescCache holds the descriptions of types whose description doesn't depend on their value.
+var fieldDescCache sync.Map // map[reflect.Type]FieldDesc
+
+// FieldDescOf returns the introspection data describing v, which may be a PVField,
+// a struct or pointer to a struct, or any other value that can be encoded.
+// Nested structures, arrays, and type IDs (from TypeID methods, including on v itself) are all described.
+//
+// The description of a type is computed once and cached, unless it depends on the value
+// (the type has interface or pointer fields, or implements FieldDescer or PVMarshaler outside this package).
+// The returned FieldDesc may be shared and must not be modified.
+func FieldDescOf(v interface{}) (FieldDesc, error) {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		return FieldDesc{}, errors.New("cannot describe nil value")
+	}
+	t := rv.Type()
+	if fd, ok := fieldDescCache.Load(t); ok {
+		return fd.(FieldDesc), nil
+	}
+	if !staticType(t, nil) {
+		return valueToField(rv)
+	}
+	// Describe the zero value, so that the cached description doesn't depend on v.
+	var zero reflect.Value
+	if t.Kind() == reflect.Ptr {
+		zero = reflect.New(t.Elem())
+	} else {
+		zero = reflect.New(t).Elem()
+	}
+	fd, err := valueToField(zero)
+	if err != nil {
+		return FieldDesc{}, err
+	}
+	fieldDescCache.Store(t, fd)
+	return fd, nil
+}
+
+var pvdataPkgPath = reflect.TypeOf(FieldDesc{}).PkgPath()
+
+var (
+	fieldDescerType = reflect.TypeOf((*FieldDescer)(nil)).Elem()
+	pvFieldType     = reflect.TypeOf((*PVField)(nil)).Elem()
+)
+
+// dynamicTypes are types in this package whose description depends on their value.
+var dynamicTypes = map[reflect.Type]bool{
+	reflect.TypeOf(PVStructure{}):     true,
+	reflect.TypeOf(PVStructureDiff{}): true,
+	reflect.TypeOf(PVArray{}):         true,
+	reflect.TypeOf(PVAny{}):           true,
+	reflect.TypeOf(PVBoundedString{}): true,
+	reflect.TypeOf(ArrayStream{}):     true,
+	reflect.TypeOf(ArraySink{}):       true,
+	reflect.TypeOf(SharedBytes{}):     true,
+}
+
+// implements reports whether t or a pointer to t implements iface.
+func implements(t, iface reflect.Type) bool {
+	return t.Implements(iface) || reflect.PtrTo(t).Implements(iface)
+}
+
+// staticType reports whether every value of type t has the same description.
+// A top-level pointer is allowed, since FieldDescOf is usually passed a pointer to a struct.
+func staticType(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if seen == nil {
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		seen = make(map[reflect.Type]bool)
+	}
+	if seen[t] {
+		// Recursive types can't be described.
+		return false
+	}
+	seen[t] = true
+	defer delete(seen, t)
+	if dynamicTypes[t] {
+		return false
+	}
+	if t == goTimeType {
+		return true
+	}
+	custom := implements(t, fieldDescerType) || implements(t, pvFieldType) ||
+		implements(t, pvMarshalerType) || implements(t, pvUnmarshalerType)
+	if custom && t.PkgPath() != pvdataPkgPath {
+		return false
+	}
+	switch t.Kind() {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.String:
+		return true
+	case reflect.Slice, reflect.Array:
+		return staticType(t.Elem(), seen)
+	case reflect.Struct:
+		if custom {
+			// Structures from this package that describe themselves, such as Time.
+			return true
+		}
+		for i := 0; i < t.NumField(); i++ {
+			if !staticType(t.Field(i).Type, seen) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
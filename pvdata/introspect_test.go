@@ -0,0 +1,101 @@
+package pvdata
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type introspectPoint struct {
+	X PVDouble `pvaccess:"x"`
+	Y PVDouble `pvaccess:"y"`
+}
+
+func (introspectPoint) TypeID() string {
+	return "point_t"
+}
+
+type introspectValue struct {
+	Value     []introspectPoint `pvaccess:"value"`
+	Corners   [2]PVInt          `pvaccess:"corners"`
+	Label     string            `pvaccess:"label"`
+	TimeStamp Time              `pvaccess:"timeStamp"`
+}
+
+func (introspectValue) TypeID() string {
+	return "polygon_t"
+}
+
+type introspectDynamic struct {
+	Value interface{} `pvaccess:"value"`
+}
+
+func TestFieldDescOf(t *testing.T) {
+	want := FieldDesc{
+		TypeCode:   STRUCT,
+		StructType: "polygon_t",
+		Fields: []StructFieldDesc{
+			{"value", FieldDesc{
+				TypeCode:   STRUCT_ARRAY,
+				StructType: "point_t",
+				Fields: []StructFieldDesc{
+					{"x", FieldDesc{TypeCode: DOUBLE}},
+					{"y", FieldDesc{TypeCode: DOUBLE}},
+				},
+			}},
+			{"corners", FieldDesc{TypeCode: INT | FIXED_ARRAY, Size: 2}},
+			{"label", FieldDesc{TypeCode: STRING}},
+			{"timeStamp", FieldDesc{
+				TypeCode:   STRUCT,
+				StructType: "time_t",
+				Fields: []StructFieldDesc{
+					{"secondsPastEpoch", FieldDesc{TypeCode: LONG}},
+					{"nanoseconds", FieldDesc{TypeCode: INT}},
+					{"userTag", FieldDesc{TypeCode: INT}},
+				},
+			}},
+		},
+	}
+	for _, v := range []*introspectValue{
+		{},
+		{Value: []introspectPoint{{1, 2}}, Label: "a longer label"},
+	} {
+		got, err := FieldDescOf(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("FieldDescOf(%+v) differs (-want +got):\n%s", v, diff)
+		}
+	}
+	if _, ok := fieldDescCache.Load(reflect.TypeOf(&introspectValue{})); !ok {
+		t.Error("description of static type was not cached")
+	}
+}
+
+func TestFieldDescOfDynamic(t *testing.T) {
+	i := PVInt(1)
+	for _, test := range []struct {
+		value interface{}
+		want  byte
+	}{
+		{&i, INT},
+		{"string", STRING},
+		{[]PVDouble{1}, DOUBLE | VARIABLE_ARRAY},
+	} {
+		got, err := FieldDescOf(&introspectDynamic{Value: test.value})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got.Fields) != 1 || got.Fields[0].Field.TypeCode != test.want {
+			t.Errorf("FieldDescOf with value %#v = %+v, want value field with type code 0x%x", test.value, got, test.want)
+		}
+	}
+	if _, ok := fieldDescCache.Load(reflect.TypeOf(&introspectDynamic{})); ok {
+		t.Error("description of type with an interface field was cached")
+	}
+	if _, err := FieldDescOf(nil); err == nil {
+		t.Error("FieldDescOf(nil) succeeded")
+	}
+}
@@ -0,0 +1,489 @@
+package pvdata
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Maps are used to handle structures whose shape is not known at compile time.
+//
+// In a map, structures are represented as map[string]interface{}, arrays of scalars as
+// slices of Go basic types (e.g. []float64), arrays of structures as []interface{}, and
+// scalars as Go basic types (e.g. int32 for PVInt). time_t is represented as a map with
+// the secondsPastEpoch, nanoseconds, and userTag fields of its wire format.
+
+// ToMap returns the contents of v as a map.
+// v can be anything accepted by NewPVStructure, such as a PVStructure returned by FieldDesc.Zero.
+func ToMap(v interface{}) (map[string]interface{}, error) {
+	pvs, err := NewPVStructure(v)
+	if err != nil {
+		return nil, err
+	}
+	return pvs.ToMap(), nil
+}
+
+// ToMap returns the contents of v as a map.
+// Type IDs are not included; use FieldDesc to preserve them.
+func (v PVStructure) ToMap() map[string]interface{} {
+	return structToMap(v.v)
+}
+
+func structToMap(v reflect.Value) map[string]interface{} {
+	m := make(map[string]interface{})
+	t := v.Type()
+	for i := 0; i < v.NumField(); i++ {
+		if t.Field(i).PkgPath != "" {
+			// Unexported field.
+			continue
+		}
+		if isAbsent(v.Field(i)) || v.Field(i).Type() == arraySinkType {
+			continue
+		}
+		name, _ := parseTag(t.Field(i).Tag.Get("pvaccess"))
+		if name == "" {
+			name = t.Field(i).Name
+		}
+		m[name] = toInterface(v.Field(i))
+	}
+	return m
+}
+
+var (
+	pvStructureType     = reflect.TypeOf(PVStructure{})
+	pvAnyType           = reflect.TypeOf(PVAny{})
+	pvArrayType         = reflect.TypeOf(PVArray{})
+	pvBoundedStringType = reflect.TypeOf(PVBoundedString{})
+	timeType            = reflect.TypeOf(Time{})
+	goTimeType          = reflect.TypeOf(time.Time{})
+	arrayStreamType     = reflect.TypeOf(ArrayStream{})
+	arraySinkType       = reflect.TypeOf(ArraySink{})
+	sharedBytesType     = reflect.TypeOf(SharedBytes{})
+)
+
+// basicTypes maps reflect kinds to the Go basic type used to represent them in maps.
+var basicTypes = map[reflect.Kind]reflect.Type{
+	reflect.Bool:    reflect.TypeOf(false),
+	reflect.Int8:    reflect.TypeOf(int8(0)),
+	reflect.Int16:   reflect.TypeOf(int16(0)),
+	reflect.Int32:   reflect.TypeOf(int32(0)),
+	reflect.Int64:   reflect.TypeOf(int64(0)),
+	reflect.Int:     reflect.TypeOf(int64(0)),
+	reflect.Uint8:   reflect.TypeOf(uint8(0)),
+	reflect.Uint16:  reflect.TypeOf(uint16(0)),
+	reflect.Uint32:  reflect.TypeOf(uint32(0)),
+	reflect.Uint64:  reflect.TypeOf(uint64(0)),
+	reflect.Uint:    reflect.TypeOf(uint64(0)),
+	reflect.Float32: reflect.TypeOf(float32(0)),
+	reflect.Float64: reflect.TypeOf(float64(0)),
+	reflect.String:  reflect.TypeOf(""),
+}
+
+func toInterface(v reflect.Value) interface{} {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	i := v.Interface()
+	if v.CanAddr() {
+		i = v.Addr().Interface()
+	}
+	if m, ok := i.(PVMarshaler); ok {
+		if out, err := m.MarshalPVData(); err == nil {
+			return toInterface(reflect.ValueOf(out))
+		}
+	}
+	switch v.Type() {
+	case pvStructureType:
+		return v.Interface().(PVStructure).ToMap()
+	case pvAnyType:
+		return toInterface(reflect.ValueOf(v.Interface().(PVAny).Data))
+	case pvArrayType:
+		return toInterface(v.Interface().(PVArray).v)
+	case pvBoundedStringType:
+		return toInterface(v.Field(0))
+	case timeType:
+		t := v.Interface().(Time)
+		return map[string]interface{}{
+			"secondsPastEpoch": t.Time.Unix(),
+			"nanoseconds":      int32(t.Time.Nanosecond()),
+			"userTag":          int32(t.UserTag),
+		}
+	case goTimeType:
+		return toInterface(reflect.ValueOf(Time{Time: v.Interface().(time.Time)}))
+	case sharedBytesType:
+		return v.Interface().(SharedBytes).Bytes
+	case arrayStreamType:
+		out, err := v.Interface().(ArrayStream).collect()
+		if err != nil {
+			return nil
+		}
+		return toInterface(out)
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		return structToMap(v)
+	case reflect.Slice, reflect.Array:
+		if bt, ok := basicTypes[v.Type().Elem().Kind()]; ok {
+			out := reflect.MakeSlice(reflect.SliceOf(bt), v.Len(), v.Len())
+			for i := 0; i < v.Len(); i++ {
+				out.Index(i).Set(v.Index(i).Convert(bt))
+			}
+			return out.Interface()
+		}
+		out := make([]interface{}, v.Len())
+		for i := range out {
+			out[i] = toInterface(v.Index(i))
+		}
+		return out
+	}
+	if bt, ok := basicTypes[v.Kind()]; ok {
+		return v.Convert(bt).Interface()
+	}
+	return v.Interface()
+}
+
+// NewPVStructureFromMap returns a PVStructure with the contents of m and type ID id.
+// Since maps are unordered, fields are sorted by name; use SetFromMap on a structure created
+// by FieldDesc.Zero to encode values in a specific layout.
+// Field types are taken from the Go types of the values; int and uint are encoded as PVLong and PVULong.
+func NewPVStructureFromMap(id string, m map[string]interface{}) (PVStructure, error) {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fields := make([]reflect.StructField, len(names))
+	values := make([]reflect.Value, len(names))
+	for i, name := range names {
+		v, err := fromInterface(m[name])
+		if err != nil {
+			return PVStructure{}, fmt.Errorf("field %q: %w", name, err)
+		}
+		fields[i] = reflect.StructField{
+			Name: fmt.Sprintf("Field%d", i),
+			Type: v.Type(),
+			Tag:  reflect.StructTag(fmt.Sprintf("pvaccess:%q", name)),
+		}
+		values[i] = v
+	}
+	val := reflect.New(reflect.StructOf(fields)).Elem()
+	for i, v := range values {
+		val.Field(i).Set(v)
+	}
+	return PVStructure{ID: id, v: val}, nil
+}
+
+// fromInterface returns a value of a type that can be used as a structure field for x.
+func fromInterface(x interface{}) (reflect.Value, error) {
+	switch x := x.(type) {
+	case nil:
+		return reflect.ValueOf(PVAny{}), nil
+	case map[string]interface{}:
+		pvs, err := NewPVStructureFromMap("", x)
+		return reflect.ValueOf(pvs), err
+	case int:
+		return reflect.ValueOf(PVLong(x)), nil
+	case uint:
+		return reflect.ValueOf(PVULong(x)), nil
+	case []interface{}:
+		return sliceFromInterfaces(x)
+	case time.Time:
+		return reflect.ValueOf(Time{Time: x}), nil
+	}
+	v := reflect.ValueOf(x)
+	if pvf := valueToPVField(reflect.New(v.Type())); pvf == nil {
+		return reflect.Value{}, fmt.Errorf("can't encode %T", x)
+	}
+	return v, nil
+}
+
+// sliceFromInterfaces converts a slice with elements of the same basic type (as produced by encoding/json) to a typed slice.
+func sliceFromInterfaces(x []interface{}) (reflect.Value, error) {
+	if len(x) == 0 {
+		return reflect.ValueOf([]PVAny{}), nil
+	}
+	t := reflect.TypeOf(x[0])
+	if t == nil || basicTypes[t.Kind()] != t {
+		return reflect.Value{}, fmt.Errorf("can't encode array of %T", x[0])
+	}
+	out := reflect.MakeSlice(reflect.SliceOf(t), len(x), len(x))
+	for i, e := range x {
+		if reflect.TypeOf(e) != t {
+			return reflect.Value{}, fmt.Errorf("array element %d is %T, not %v", i, e, t)
+		}
+		out.Index(i).Set(reflect.ValueOf(e))
+	}
+	return out, nil
+}
+
+// SetFromMap sets the fields of v from the values in m, converting numeric values as needed.
+// Integers must fit in their field exactly: a negative value for an unsigned field, a value that overflows
+// the field, or a number with a fraction for an integer field is an error.
+// Fields missing from m are left unchanged. It is an error for m to contain unknown fields.
+func (v PVStructure) SetFromMap(m map[string]interface{}) error {
+	if !v.v.CanSet() {
+		return fmt.Errorf("structure %s is not settable", v.v.Type())
+	}
+	return (&setter{strictUnknown: true}).setStruct(v.v, m, "")
+}
+
+// FieldAs returns the field of v at path, e.g. "value" or "alarm.severity", converted to a T as by SetFromMap,
+// such as the value of an NTScalar as a float64 or the value of an NTScalarArray as a []float64.
+func FieldAs[T any](v PVStructure, path string) (T, error) {
+	var out struct {
+		Value T `pvaccess:"value"`
+	}
+	var field interface{} = v.ToMap()
+	for _, name := range strings.Split(path, ".") {
+		m, ok := field.(map[string]interface{})
+		if !ok {
+			return out.Value, fmt.Errorf("no field %s", path)
+		}
+		if field, ok = m[name]; !ok {
+			return out.Value, fmt.Errorf("no field %s", path)
+		}
+	}
+	pvs, err := NewPVStructure(&out)
+	if err != nil {
+		return out.Value, err
+	}
+	if err := pvs.SetFromMap(map[string]interface{}{"value": field}); err != nil {
+		return out.Value, fmt.Errorf("field %s: %w", path, err)
+	}
+	return out.Value, nil
+}
+
+// DecodeAs decodes data described by fd into out, matching structure fields by name instead of by position.
+// This allows decoding values from servers whose normative type versions differ from the Go structs.
+// See DecoderState.Strict for how mismatched fields are handled.
+func DecodeAs(s *DecoderState, fd FieldDesc, out interface{}) error {
+	zero, err := fd.Zero()
+	if err != nil {
+		return err
+	}
+	ov := reflect.ValueOf(out)
+	if ov.Kind() != reflect.Ptr || ov.IsNil() {
+		return fmt.Errorf("can't decode into non-pointer %T", out)
+	}
+	if err := Decode(s, zero); err != nil {
+		return err
+	}
+	st := &setter{strictUnknown: s.Strict, strictMissing: s.Strict}
+	if pvs, ok := zero.(PVStructure); ok {
+		if err := st.setValue(ov, pvs.ToMap(), ""); err != nil {
+			return err
+		}
+	} else if err := st.setValue(ov, toInterface(reflect.ValueOf(zero)), ""); err != nil {
+		return err
+	}
+	s.Dropped = append(s.Dropped, st.dropped...)
+	s.Missing = append(s.Missing, st.missing...)
+	return nil
+}
+
+// setter assigns map values to Go values, matching structure fields by name.
+type setter struct {
+	// strictUnknown causes fields in the map that don't exist in the Go value (or can't be converted to it) to be an error.
+	// Otherwise, they are added to dropped.
+	strictUnknown bool
+	// strictMissing causes fields of the Go value that are not in the map to be an error.
+	// Otherwise, they are added to missing.
+	strictMissing bool
+
+	dropped, missing []string
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// drop handles a field that can't be set, returning err if unknown fields are not allowed.
+func (s *setter) drop(path string, err error) error {
+	if s.strictUnknown {
+		return err
+	}
+	s.dropped = append(s.dropped, path)
+	return nil
+}
+
+func (s *setter) setStruct(v reflect.Value, m map[string]interface{}, path string) error {
+	t := v.Type()
+	seen := make(map[string]bool)
+	for i := 0; i < v.NumField(); i++ {
+		if t.Field(i).PkgPath != "" {
+			continue
+		}
+		name, _ := parseTag(t.Field(i).Tag.Get("pvaccess"))
+		if name == "" {
+			name = t.Field(i).Name
+		}
+		fieldPath := joinPath(path, name)
+		x, ok := m[name]
+		if !ok && v.Field(i).Kind() == reflect.Ptr {
+			// Pointer fields are optional; like other missing fields, they are left unchanged (usually nil).
+			continue
+		}
+		if !ok {
+			if s.strictMissing {
+				return fmt.Errorf("missing field %q", fieldPath)
+			}
+			s.missing = append(s.missing, fieldPath)
+			continue
+		}
+		seen[name] = true
+		if err := s.setValue(v.Field(i), x, fieldPath); err != nil {
+			return err
+		}
+	}
+	if len(seen) < len(m) {
+		var unknown []string
+		for name := range m {
+			if !seen[name] {
+				unknown = append(unknown, name)
+			}
+		}
+		sort.Strings(unknown)
+		for _, name := range unknown {
+			fieldPath := joinPath(path, name)
+			if err := s.drop(fieldPath, fmt.Errorf("unknown field %q", fieldPath)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *setter) setValue(v reflect.Value, x interface{}, path string) error {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	mismatch := func(want string) error {
+		return s.drop(path, fmt.Errorf("field %q: got %T, want %s", path, x, want))
+	}
+	switch v.Type() {
+	case pvStructureType:
+		m, ok := x.(map[string]interface{})
+		if !ok {
+			return mismatch("map")
+		}
+		return s.setStruct(v.Interface().(PVStructure).v, m, path)
+	case pvAnyType:
+		nv, err := fromInterface(x)
+		if err != nil {
+			return s.drop(path, fmt.Errorf("field %q: %w", path, err))
+		}
+		p := reflect.New(nv.Type())
+		p.Elem().Set(nv)
+		v.Set(reflect.ValueOf(PVAny{valueToPVField(p)}))
+		return nil
+	case pvBoundedStringType:
+		return s.setValue(v.Field(0), x, path)
+	case timeType:
+		m, ok := x.(map[string]interface{})
+		if !ok {
+			return mismatch("map")
+		}
+		var fields struct {
+			SecondsPastEpoch int64 `pvaccess:"secondsPastEpoch"`
+			Nanoseconds      int32 `pvaccess:"nanoseconds"`
+			UserTag          int32 `pvaccess:"userTag"`
+		}
+		if err := s.setStruct(reflect.ValueOf(&fields).Elem(), m, path); err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(Time{
+			Time:    time.Unix(fields.SecondsPastEpoch, int64(fields.Nanoseconds)),
+			UserTag: PVInt(fields.UserTag),
+		}))
+		return nil
+	}
+	xv := reflect.ValueOf(x)
+	switch v.Kind() {
+	case reflect.Struct:
+		m, ok := x.(map[string]interface{})
+		if !ok {
+			return mismatch("map")
+		}
+		return s.setStruct(v, m, path)
+	case reflect.Slice, reflect.Array:
+		if xv.Kind() != reflect.Slice && xv.Kind() != reflect.Array {
+			return mismatch("slice")
+		}
+		if v.Kind() == reflect.Array && xv.Len() != v.Len() {
+			return s.drop(path, fmt.Errorf("field %q: got %d elements, want %d", path, xv.Len(), v.Len()))
+		}
+		if v.Kind() == reflect.Slice {
+			v.Set(reflect.MakeSlice(v.Type(), xv.Len(), xv.Len()))
+		}
+		for i := 0; i < xv.Len(); i++ {
+			if err := s.setValue(v.Index(i), xv.Index(i).Interface(), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if !xv.IsValid() || !sameKindClass(v.Kind(), xv.Kind()) {
+		return mismatch(v.Type().String())
+	}
+	cv := xv.Convert(v.Type())
+	if !exactConversion(xv, cv) {
+		return s.drop(path, fmt.Errorf("field %q: %v does not fit in %v", path, x, v.Type()))
+	}
+	v.Set(cv)
+	return nil
+}
+
+// exactConversion reports whether the number to was converted from from without overflowing, changing sign,
+// or losing a fraction. Conversions to floating point always succeed, rounding if necessary.
+func exactConversion(from, to reflect.Value) bool {
+	switch to.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch from.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return from.Int() == to.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return to.Int() >= 0 && from.Uint() == uint64(to.Int())
+		case reflect.Float32, reflect.Float64:
+			return from.Float() == float64(to.Int()) && from.Float() >= -(1<<63) && from.Float() < 1<<63
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		switch from.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return from.Int() >= 0 && uint64(from.Int()) == to.Uint()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return from.Uint() == to.Uint()
+		case reflect.Float32, reflect.Float64:
+			return from.Float() == float64(to.Uint()) && from.Float() >= 0 && from.Float() < 1<<64
+		}
+	}
+	return true
+}
+
+// sameKindClass reports whether values of kind b can be converted to kind a without changing their meaning.
+func sameKindClass(a, b reflect.Kind) bool {
+	class := func(k reflect.Kind) int {
+		switch k {
+		case reflect.Bool:
+			return 1
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			return 2
+		case reflect.String:
+			return 3
+		}
+		return 0
+	}
+	return class(a) != 0 && class(a) == class(b)
+}
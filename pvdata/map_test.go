@@ -0,0 +1,312 @@
+package pvdata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type mapTestStruct struct {
+	Value     PVDouble   `pvaccess:"value"`
+	Waveform  []PVFloat  `pvaccess:"waveform"`
+	Labels    []string   `pvaccess:"labels"`
+	Fixed     [2]PVShort `pvaccess:"fixed"`
+	Enum      Enum       `pvaccess:"enum"`
+	Timestamp Time       `pvaccess:"timeStamp"`
+	Name      string     `pvaccess:"name,bound=10"`
+}
+
+func newMapTestStruct() *mapTestStruct {
+	return &mapTestStruct{
+		Value:     1.5,
+		Waveform:  []PVFloat{1, 2, 3},
+		Labels:    []string{"a", "b"},
+		Fixed:     [2]PVShort{4, 5},
+		Enum:      Enum{Index: 1, Choices: []string{"off", "on"}},
+		Timestamp: Time{Time: time.Unix(1600000000, 5)},
+		Name:      "test",
+	}
+}
+
+var mapTestWant = map[string]interface{}{
+	"value":    float64(1.5),
+	"waveform": []float32{1, 2, 3},
+	"labels":   []string{"a", "b"},
+	"fixed":    []int16{4, 5},
+	"enum": map[string]interface{}{
+		"index":   int32(1),
+		"choices": []string{"off", "on"},
+	},
+	"timeStamp": map[string]interface{}{
+		"secondsPastEpoch": int64(1600000000),
+		"nanoseconds":      int32(5),
+		"userTag":          int32(0),
+	},
+	"name": "test",
+}
+
+func TestToMap(t *testing.T) {
+	got, err := ToMap(newMapTestStruct())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, mapTestWant); diff != "" {
+		t.Errorf("ToMap: got(-)/want(+)\n%s", diff)
+	}
+}
+
+// TestDecodeToMap decodes a structure using only its FieldDesc, as a gateway would.
+func TestDecodeToMap(t *testing.T) {
+	in, err := NewPVStructure(newMapTestStruct())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd, err := in.FieldDesc()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	es := &EncoderState{Buf: &buf, ByteOrder: binary.LittleEndian}
+	if err := Encode(es, &fd, in); err != nil {
+		t.Fatal(err)
+	}
+	ds := &DecoderState{Buf: bytes.NewReader(buf.Bytes()), ByteOrder: binary.LittleEndian}
+	var gotFD FieldDesc
+	if err := Decode(ds, &gotFD); err != nil {
+		t.Fatal(err)
+	}
+	zero, err := gotFD.Zero()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Decode(ds, zero); err != nil {
+		t.Fatal(err)
+	}
+	got := zero.(PVStructure).ToMap()
+	if diff := cmp.Diff(got, mapTestWant); diff != "" {
+		t.Errorf("decoded map: got(-)/want(+)\n%s", diff)
+	}
+
+	// Values from JSON can be put back into the same layout.
+	var fromJSON map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"value": 3, "waveform": [7, 8], "enum": {"index": 0}}`), &fromJSON); err != nil {
+		t.Fatal(err)
+	}
+	if err := zero.(PVStructure).SetFromMap(fromJSON); err != nil {
+		t.Fatalf("SetFromMap: %v", err)
+	}
+	got = zero.(PVStructure).ToMap()
+	want := copyMap(mapTestWant)
+	want["value"] = float64(3)
+	want["waveform"] = []float32{7, 8}
+	want["enum"] = map[string]interface{}{"index": int32(0), "choices": []string{"off", "on"}}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("after SetFromMap: got(-)/want(+)\n%s", diff)
+	}
+	if err := zero.(PVStructure).SetFromMap(map[string]interface{}{"unknown": 1}); err == nil {
+		t.Error("SetFromMap with unknown field succeeded")
+	}
+	if err := zero.(PVStructure).SetFromMap(map[string]interface{}{"name": 1}); err == nil {
+		t.Error("SetFromMap of number into string succeeded")
+	}
+}
+
+func copyMap(m map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{})
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+func TestNewPVStructureFromMap(t *testing.T) {
+	in := map[string]interface{}{
+		"b": []interface{}{1.0, 2.0},
+		"a": "x",
+		"c": map[string]interface{}{"n": 3},
+	}
+	pvs, err := NewPVStructureFromMap("test_t", in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd, err := pvs.FieldDesc()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fd.StructType != "test_t" || len(fd.Fields) != 3 || fd.Fields[0].Name != "a" || fd.Fields[2].Field.Fields[0].Field.TypeCode != LONG {
+		t.Errorf("unexpected FieldDesc %+v", fd)
+	}
+	want := map[string]interface{}{
+		"a": "x",
+		"b": []float64{1, 2},
+		"c": map[string]interface{}{"n": int64(3)},
+	}
+	if diff := cmp.Diff(pvs.ToMap(), want); diff != "" {
+		t.Errorf("round trip: got(-)/want(+)\n%s", diff)
+	}
+}
+
+func TestDecodeAs(t *testing.T) {
+	// The server sends a newer version of the structure, with an extra field and without name.
+	type serverStruct struct {
+		Extra PVInt      `pvaccess:"extra"`
+		Value PVDouble   `pvaccess:"value"`
+		Alarm Alarm      `pvaccess:"alarm"`
+		Fixed [2]PVShort `pvaccess:"fixed"`
+	}
+	in, err := NewPVStructure(&serverStruct{Extra: 7, Value: 2.5, Alarm: Alarm{Severity: 2, Message: "high"}, Fixed: [2]PVShort{1, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd, err := in.FieldDesc()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Encode(&EncoderState{Buf: &buf, ByteOrder: binary.LittleEndian}, in); err != nil {
+		t.Fatal(err)
+	}
+
+	type clientStruct struct {
+		Value PVFloat    `pvaccess:"value"`
+		Alarm Alarm      `pvaccess:"alarm"`
+		Fixed [2]PVShort `pvaccess:"fixed"`
+		Name  string     `pvaccess:"name"`
+	}
+	for _, strict := range []bool{false, true} {
+		ds := &DecoderState{Buf: bytes.NewReader(buf.Bytes()), ByteOrder: binary.LittleEndian, Strict: strict}
+		out := clientStruct{Name: "unchanged"}
+		err := DecodeAs(ds, fd, &out)
+		if strict {
+			if err == nil {
+				t.Error("strict DecodeAs succeeded with mismatched fields")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("lenient DecodeAs: %v", err)
+		}
+		want := clientStruct{Value: 2.5, Alarm: Alarm{Severity: 2, Message: "high"}, Fixed: [2]PVShort{1, 2}, Name: "unchanged"}
+		if diff := cmp.Diff(out, want); diff != "" {
+			t.Errorf("lenient DecodeAs: got(-)/want(+)\n%s", diff)
+		}
+		if diff := cmp.Diff(ds.Dropped, []string{"extra"}); diff != "" {
+			t.Errorf("dropped fields: got(-)/want(+)\n%s", diff)
+		}
+		if diff := cmp.Diff(ds.Missing, []string{"name"}); diff != "" {
+			t.Errorf("missing fields: got(-)/want(+)\n%s", diff)
+		}
+	}
+}
+
+func TestSetFromMapConversion(t *testing.T) {
+	type target struct {
+		Counter PVULong `pvaccess:"counter"`
+		Short   PVShort `pvaccess:"short"`
+		Byte    PVUByte `pvaccess:"byte"`
+	}
+	for _, test := range []struct {
+		in      map[string]interface{}
+		want    target
+		wantErr bool
+	}{
+		{map[string]interface{}{"counter": uint64(1<<64 - 1)}, target{Counter: 1<<64 - 1}, false},
+		{map[string]interface{}{"counter": int64(12), "short": uint16(300), "byte": 255.0}, target{Counter: 12, Short: 300, Byte: 255}, false},
+		{map[string]interface{}{"counter": int64(-1)}, target{}, true},
+		{map[string]interface{}{"short": 40000}, target{}, true},
+		{map[string]interface{}{"byte": 256}, target{}, true},
+		{map[string]interface{}{"byte": 1.5}, target{}, true},
+	} {
+		var got target
+		pvs, err := NewPVStructure(&got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = pvs.SetFromMap(test.in)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("SetFromMap(%v) succeeded with %+v", test.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("SetFromMap(%v): %v", test.in, err)
+		}
+		if got != test.want {
+			t.Errorf("SetFromMap(%v) = %+v, want %+v", test.in, got, test.want)
+		}
+	}
+}
+
+func TestFieldAs(t *testing.T) {
+	v, err := NewPVStructure(&NTScalar{Value: PVInt(7), Alarm: &Alarm{Severity: SeverityMajor, Message: "high"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x, err := FieldAs[float64](v, "value"); err != nil || x != 7 {
+		t.Errorf("value as float64 = %v, %v; want 7", x, err)
+	}
+	if x, err := FieldAs[uint8](v, "alarm.severity"); err != nil || x != 2 {
+		t.Errorf("alarm.severity as uint8 = %v, %v; want 2", x, err)
+	}
+	if x, err := FieldAs[Alarm](v, "alarm"); err != nil || x.Message != "high" {
+		t.Errorf("alarm = %+v, %v; want message high", x, err)
+	}
+	for _, path := range []string{"display", "value.x", "alarm.message"} {
+		if x, err := FieldAs[int32](v, path); err == nil {
+			t.Errorf("FieldAs[int32](%q) = %v, want error", path, x)
+		}
+	}
+}
+
+func TestOptionalFields(t *testing.T) {
+	type value struct {
+		Value   PVDouble `pvaccess:"value"`
+		Alarm   *Alarm   `pvaccess:"alarm"`
+		Display *Display `pvaccess:"display"`
+	}
+	for _, in := range []*value{
+		{Value: 1},
+		{Value: 2, Alarm: &Alarm{Severity: 1, Message: "minor"}},
+	} {
+		pvs, err := NewPVStructure(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fd, err := pvs.FieldDesc()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, f := range fd.Fields {
+			names = append(names, f.Name)
+		}
+		wantNames := []string{"value"}
+		if in.Alarm != nil {
+			wantNames = append(wantNames, "alarm")
+		}
+		if diff := cmp.Diff(wantNames, names); diff != "" {
+			t.Errorf("fields of %+v differ (-want +got):\n%s", in, diff)
+		}
+		if _, ok := pvs.ToMap()["display"]; ok {
+			t.Errorf("ToMap of %+v has absent display field", in)
+		}
+
+		var buf bytes.Buffer
+		if err := Encode(&EncoderState{Buf: &buf, ByteOrder: binary.LittleEndian}, pvs); err != nil {
+			t.Fatal(err)
+		}
+		var out value
+		ds := &DecoderState{Buf: bytes.NewReader(buf.Bytes()), ByteOrder: binary.LittleEndian, Strict: true}
+		if err := DecodeAs(ds, fd, &out); err != nil {
+			t.Fatalf("DecodeAs: %v", err)
+		}
+		if diff := cmp.Diff(in, &out); diff != "" {
+			t.Errorf("decoded value differs (-want +got):\n%s", diff)
+		}
+	}
+}
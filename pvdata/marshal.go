@@ -0,0 +1,92 @@
+package pvdata
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// PVMarshaler is implemented by types that choose their own pvData representation,
+// instead of being encoded field by field.
+//
+// MarshalPVData returns the value to encode in place of the receiver. It can be anything
+// that can be encoded, such as a PVField or a struct (e.g. Enum for a Go enum type).
+// The description of a type implementing PVMarshaler is computed from the returned value.
+type PVMarshaler interface {
+	MarshalPVData() (interface{}, error)
+}
+
+// PVUnmarshaler is implemented by types that decode themselves from their own pvData representation.
+//
+// UnmarshalPVData is passed a function that decodes the received value into v, which must be
+// a pointer to something that can be decoded, usually the type returned by MarshalPVData.
+type PVUnmarshaler interface {
+	UnmarshalPVData(decode func(v interface{}) error) error
+}
+
+var (
+	pvMarshalerType   = reflect.TypeOf((*PVMarshaler)(nil)).Elem()
+	pvUnmarshalerType = reflect.TypeOf((*PVUnmarshaler)(nil)).Elem()
+)
+
+// marshalerField adapts a PVMarshaler and/or PVUnmarshaler to a PVField.
+type marshalerField struct {
+	v interface{}
+}
+
+// newMarshalerField returns a PVField for i, or nil if i implements neither PVMarshaler nor PVUnmarshaler.
+func newMarshalerField(i interface{}) PVField {
+	_, m := i.(PVMarshaler)
+	_, u := i.(PVUnmarshaler)
+	if !m && !u {
+		return nil
+	}
+	return marshalerField{i}
+}
+
+// marshal returns the value that represents f on the wire, as a pointer so that it can be encoded.
+func (f marshalerField) marshal() (reflect.Value, error) {
+	m, ok := f.v.(PVMarshaler)
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("%T does not implement PVMarshaler", f.v)
+	}
+	out, err := m.MarshalPVData()
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	v := reflect.ValueOf(out)
+	if !v.IsValid() {
+		return reflect.Value{}, fmt.Errorf("%T.MarshalPVData returned nil", f.v)
+	}
+	if v.Kind() != reflect.Ptr {
+		c := reflect.New(v.Type())
+		c.Elem().Set(v)
+		v = c
+	}
+	return v, nil
+}
+
+func (f marshalerField) PVEncode(s *EncoderState) error {
+	v, err := f.marshal()
+	if err != nil {
+		return err
+	}
+	return Encode(s, v.Interface())
+}
+
+func (f marshalerField) PVDecode(s *DecoderState) error {
+	u, ok := f.v.(PVUnmarshaler)
+	if !ok {
+		return fmt.Errorf("%T does not implement PVUnmarshaler", f.v)
+	}
+	return u.UnmarshalPVData(func(v interface{}) error {
+		return Decode(s, v)
+	})
+}
+
+func (f marshalerField) FieldDesc() (FieldDesc, error) {
+	v, err := f.marshal()
+	if err != nil {
+		return FieldDesc{}, err
+	}
+	return valueToField(v)
+}
@@ -0,0 +1,112 @@
+package pvdata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// marshalState is a Go enum that is sent as an NTEnum.
+type marshalState int
+
+var marshalStateChoices = []string{"off", "on"}
+
+func (s marshalState) MarshalPVData() (interface{}, error) {
+	return Enum{Index: PVInt(s), Choices: marshalStateChoices}, nil
+}
+
+func (s *marshalState) UnmarshalPVData(decode func(v interface{}) error) error {
+	var e Enum
+	if err := decode(&e); err != nil {
+		return err
+	}
+	if e.Index < 0 || int(e.Index) >= len(marshalStateChoices) {
+		return fmt.Errorf("state index %d out of range", e.Index)
+	}
+	*s = marshalState(e.Index)
+	return nil
+}
+
+type marshalValue struct {
+	State     marshalState `pvaccess:"state"`
+	TimeStamp time.Time    `pvaccess:"timeStamp"`
+}
+
+func TestMarshaler(t *testing.T) {
+	in := &marshalValue{
+		State:     1,
+		TimeStamp: time.Unix(1600000000, 5),
+	}
+	want := FieldDesc{
+		TypeCode: STRUCT,
+		Fields: []StructFieldDesc{
+			{"state", FieldDesc{
+				TypeCode:   STRUCT,
+				StructType: "enum_t",
+				Fields: []StructFieldDesc{
+					{"index", FieldDesc{TypeCode: INT}},
+					{"choices", FieldDesc{TypeCode: STRING | VARIABLE_ARRAY}},
+				},
+			}},
+			{"timeStamp", FieldDesc{
+				TypeCode:   STRUCT,
+				StructType: "time_t",
+				Fields: []StructFieldDesc{
+					{"secondsPastEpoch", FieldDesc{TypeCode: LONG}},
+					{"nanoseconds", FieldDesc{TypeCode: INT}},
+					{"userTag", FieldDesc{TypeCode: INT}},
+				},
+			}},
+		},
+	}
+	got, err := FieldDescOf(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("FieldDescOf differs (-want +got):\n%s", diff)
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&EncoderState{Buf: &buf, ByteOrder: binary.BigEndian}, in); err != nil {
+		t.Fatal(err)
+	}
+	var wire struct {
+		State     Enum `pvaccess:"state"`
+		TimeStamp Time `pvaccess:"timeStamp"`
+	}
+	if err := Decode(&DecoderState{Buf: bytes.NewReader(buf.Bytes()), ByteOrder: binary.BigEndian}, &wire); err != nil {
+		t.Fatal(err)
+	}
+	if wire.State.Index != 1 || !wire.TimeStamp.Time.Equal(in.TimeStamp) {
+		t.Errorf("encoded as %+v", wire)
+	}
+
+	var out marshalValue
+	if err := Decode(&DecoderState{Buf: bytes.NewReader(buf.Bytes()), ByteOrder: binary.BigEndian}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.State != in.State || !out.TimeStamp.Equal(in.TimeStamp) {
+		t.Errorf("decoded %+v, want %+v", out, in)
+	}
+
+	m, err := ToMap(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantMap := map[string]interface{}{
+		"state": map[string]interface{}{"index": int32(1), "choices": []string{"off", "on"}},
+		"timeStamp": map[string]interface{}{
+			"secondsPastEpoch": int64(1600000000),
+			"nanoseconds":      int32(5),
+			"userTag":          int32(0),
+		},
+	}
+	if diff := cmp.Diff(wantMap, m); diff != "" {
+		t.Errorf("ToMap differs (-want +got):\n%s", diff)
+	}
+}
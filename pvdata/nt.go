@@ -34,6 +34,17 @@ func (t Time) PVEncode(s *EncoderState) error {
 	nanoseconds := PVInt(t.Time.Nanosecond())
 	return Encode(s, &secondsPastEpoch, &nanoseconds, &t.UserTag)
 }
+func (Time) FieldDesc() (FieldDesc, error) {
+	return FieldDesc{
+		TypeCode:   STRUCT,
+		StructType: "time_t",
+		Fields: []StructFieldDesc{
+			{"secondsPastEpoch", FieldDesc{TypeCode: LONG}},
+			{"nanoseconds", FieldDesc{TypeCode: INT}},
+			{"userTag", FieldDesc{TypeCode: INT}},
+		},
+	}, nil
+}
 func (t *Time) PVDecode(s *DecoderState) error {
 	var secondsPastEpoch PVLong
 	var nanoseconds PVInt
@@ -44,6 +55,26 @@ func (t *Time) PVDecode(s *DecoderState) error {
 	return nil
 }
 
+// timeField encodes a time.Time as a time_t structure with a zero user tag.
+type timeField struct {
+	t *time.Time
+}
+
+func (f timeField) PVEncode(s *EncoderState) error {
+	return Time{Time: *f.t}.PVEncode(s)
+}
+func (timeField) FieldDesc() (FieldDesc, error) {
+	return Time{}.FieldDesc()
+}
+func (f timeField) PVDecode(s *DecoderState) error {
+	var t Time
+	if err := t.PVDecode(s); err != nil {
+		return err
+	}
+	*f.t = t.Time
+	return nil
+}
+
 type Alarm struct {
 	Severity PVInt    `pvaccess:"severity"`
 	Status   PVInt    `pvaccess:"status"`
@@ -54,6 +85,15 @@ func (Alarm) TypeID() string {
 	return "alarm_t"
 }
 
+// Alarm severities.
+const (
+	SeverityNoAlarm PVInt = iota
+	SeverityMinor
+	SeverityMajor
+	SeverityInvalid
+	SeverityUndefined
+)
+
 type AlarmLimit struct {
 	Active           PVBoolean `pvaccess:"active"`
 	LowAlarmLimit    PVDouble  `pvaccess:"lowAlarmLimit"`
@@ -81,19 +121,73 @@ func (ValueAlarm) TypeID() string {
 	return "valueAlarm_t"
 }
 
+// NewValueAlarm returns active alarm limits, with minor severity outside the warning limits
+// and major severity outside the alarm limits.
+func NewValueAlarm(lowAlarm, lowWarning, highWarning, highAlarm float64) ValueAlarm {
+	return ValueAlarm{
+		Active:           true,
+		LowAlarmLimit:    PVDouble(lowAlarm),
+		LowWarningLimit:  PVDouble(lowWarning),
+		HighWarningLimit: PVDouble(highWarning),
+		HighAlarmLimit:   PVDouble(highAlarm),
+
+		LowAlarmSeverity:    SeverityMajor,
+		LowWarningSeverity:  SeverityMinor,
+		HighWarningSeverity: SeverityMinor,
+		HighAlarmSeverity:   SeverityMajor,
+	}
+}
+
 type Display struct {
 	LimitLow    PVDouble `pvaccess:"limitLow"`
 	LimitHigh   PVDouble `pvaccess:"limitHigh"`
 	Description PVString `pvaccess:"description"`
 	Units       PVString `pvaccess:"units"`
 	Precision   PVInt    `pvaccess:"precision"`
-	Form        Enum     `pvaccess:"enum"`
+	Form        Enum     `pvaccess:"form"`
 }
 
 func (Display) TypeID() string {
 	return "display_t"
 }
 
+// DisplayForms are the choices of Display.Form.
+var DisplayForms = []string{"Default", "String", "Binary", "Decimal", "Hex", "Exponential", "Engineering"}
+
+// Indexes of DisplayForms.
+const (
+	FormDefault PVInt = iota
+	FormString
+	FormBinary
+	FormDecimal
+	FormHex
+	FormExponential
+	FormEngineering
+)
+
+// NewDisplay returns display metadata with the given limits, units, and precision, and the default form.
+func NewDisplay(limitLow, limitHigh float64, units string, precision int) Display {
+	return Display{
+		LimitLow:  PVDouble(limitLow),
+		LimitHigh: PVDouble(limitHigh),
+		Units:     PVString(units),
+		Precision: PVInt(precision),
+		Form:      Enum{Index: FormDefault, Choices: DisplayForms},
+	}
+}
+
+// WithDescription returns a copy of d with the given description.
+func (d Display) WithDescription(description string) Display {
+	d.Description = PVString(description)
+	return d
+}
+
+// WithForm returns a copy of d with the given form, one of the Form constants.
+func (d Display) WithForm(form PVInt) Display {
+	d.Form = Enum{Index: form, Choices: DisplayForms}
+	return d
+}
+
 type Control struct {
 	LimitLow  PVDouble `pvaccess:"limitLow"`
 	LimitHigh PVDouble `pvaccess:"limitHigh"`
@@ -103,3 +197,27 @@ type Control struct {
 func (Control) TypeID() string {
 	return "control_t"
 }
+
+// NewControl returns control limits for values written to a channel.
+func NewControl(limitLow, limitHigh, minStep float64) Control {
+	return Control{
+		LimitLow:  PVDouble(limitLow),
+		LimitHigh: PVDouble(limitHigh),
+		MinStep:   PVDouble(minStep),
+	}
+}
+
+// NTScalar is the epics:nt/NTScalar:1.0 normative type, for serving a scalar with the metadata GUIs display.
+// Value must be a scalar, such as a PVDouble or float64. Metadata fields that are nil are omitted.
+type NTScalar struct {
+	Value      interface{} `pvaccess:"value"`
+	Alarm      *Alarm      `pvaccess:"alarm"`
+	TimeStamp  *Time       `pvaccess:"timeStamp"`
+	Display    *Display    `pvaccess:"display"`
+	Control    *Control    `pvaccess:"control"`
+	ValueAlarm *ValueAlarm `pvaccess:"valueAlarm"`
+}
+
+func (NTScalar) TypeID() string {
+	return "epics:nt/NTScalar:1.0"
+}
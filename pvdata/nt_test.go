@@ -0,0 +1,59 @@
+package pvdata
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNTScalarMetadata(t *testing.T) {
+	display := NewDisplay(0, 10, "mm", 3).WithDescription("position")
+	v := &NTScalar{
+		Value:      PVDouble(2.5),
+		Display:    &display,
+		ValueAlarm: &ValueAlarm{},
+	}
+	pvs, err := NewPVStructure(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pvs.ID != "epics:nt/NTScalar:1.0" {
+		t.Errorf("ID = %q, want epics:nt/NTScalar:1.0", pvs.ID)
+	}
+	fd, err := pvs.FieldDesc()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range fd.Fields {
+		names = append(names, f.Name)
+	}
+	if diff := cmp.Diff([]string{"value", "display", "valueAlarm"}, names); diff != "" {
+		t.Errorf("fields differ (-want +got):\n%s", diff)
+	}
+	want := map[string]interface{}{
+		"limitLow":    float64(0),
+		"limitHigh":   float64(10),
+		"description": "position",
+		"units":       "mm",
+		"precision":   int32(3),
+		"form": map[string]interface{}{
+			"index":   int32(0),
+			"choices": DisplayForms,
+		},
+	}
+	if diff := cmp.Diff(want, pvs.ToMap()["display"]); diff != "" {
+		t.Errorf("display differs (-want +got):\n%s", diff)
+	}
+}
+
+func TestNewValueAlarm(t *testing.T) {
+	va := NewValueAlarm(-10, -5, 5, 10)
+	if !va.Active {
+		t.Error("alarm limits are not active")
+	}
+	if va.LowAlarmSeverity != SeverityMajor || va.LowWarningSeverity != SeverityMinor ||
+		va.HighWarningSeverity != SeverityMinor || va.HighAlarmSeverity != SeverityMajor {
+		t.Errorf("NewValueAlarm severities = %+v", va)
+	}
+}
@@ -0,0 +1,25 @@
+package pvdata
+
+// Serializer encodes and decodes the payloads of messages, so that connections can carry values in an encoding
+// other than the pvData binary one, e.g. JSON while debugging, or protobuf to bridge to other systems.
+// Both ends of a connection must use the same Serializer.
+type Serializer interface {
+	// Encode writes v, which is anything that Encode accepts, to s.Buf.
+	Encode(s *EncoderState, v interface{}) error
+	// Decode reads into v, which is anything that Decode accepts, from s.Buf.
+	// It must only consume the bytes of v, since a message's fields may be decoded by several calls.
+	Decode(s *DecoderState, v interface{}) error
+}
+
+// Binary is the pvData binary encoding that PVAccess uses, as by Encode and Decode.
+var Binary Serializer = binarySerializer{}
+
+type binarySerializer struct{}
+
+func (binarySerializer) Encode(s *EncoderState, v interface{}) error {
+	return Encode(s, v)
+}
+
+func (binarySerializer) Decode(s *DecoderState, v interface{}) error {
+	return Decode(s, v)
+}
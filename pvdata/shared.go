@@ -0,0 +1,114 @@
+package pvdata
+
+import (
+	"io"
+	"reflect"
+)
+
+// SharedReader is implemented by Readers whose data SharedBytes can alias instead of copying.
+type SharedReader interface {
+	Reader
+	// Share returns the next n bytes without copying them, and consumes them as Read would. The bytes stay valid
+	// until release is called. Share returns false, and consumes nothing, if the bytes can't be shared.
+	Share(n int) (data []byte, release func(), ok bool)
+}
+
+// SharedBytes is a variable-size byte array field, such as the value of an NTNDArray of image data, that aliases
+// the buffer it is decoded from when the decoder's Reader is a SharedReader, instead of copying what may be
+// megabytes of data. Connections are SharedReaders for the payloads of messages too large for their receive buffer.
+//
+// Decoding transfers the ownership of a buffer to Bytes: the buffer isn't reused until Release is called, after
+// which Bytes must no longer be used. Release must be called once the value is no longer needed, so that the
+// buffer can be reused; a value that is never released is garbage collected, but its buffer is never reused.
+// Decoding into a SharedBytes again releases its previous Bytes. ToMap returns Bytes itself.
+type SharedBytes struct {
+	Bytes []byte
+	// Signed describes the field as a byte array, instead of a ubyte array.
+	Signed bool
+
+	release func()
+}
+
+func (b *SharedBytes) PVEncode(s *EncoderState) error {
+	if err := PVSize(len(b.Bytes)).PVEncode(s); err != nil {
+		return err
+	}
+	_, err := s.Buf.Write(b.Bytes)
+	return err
+}
+
+func (b *SharedBytes) PVDecode(s *DecoderState) error {
+	b.Release()
+	var size PVSize
+	if err := size.PVDecode(s); err != nil {
+		return err
+	}
+	if size <= 0 {
+		return nil
+	}
+	if r, ok := s.Buf.(SharedReader); ok {
+		if data, release, ok := r.Share(int(size)); ok {
+			b.Bytes, b.release = data, release
+			return nil
+		}
+	}
+	b.Bytes = make([]byte, size)
+	_, err := io.ReadFull(s.Buf, b.Bytes)
+	return err
+}
+
+func (b SharedBytes) FieldDesc() (FieldDesc, error) {
+	var code byte = UBYTE
+	if b.Signed {
+		code = BYTE
+	}
+	return FieldDesc{TypeCode: code | VARIABLE_ARRAY}, nil
+}
+
+// Release gives up Bytes, letting the buffer that it aliases be reused. It is safe to call more than once.
+func (b *SharedBytes) Release() {
+	if b.release != nil {
+		b.release()
+	}
+	b.Bytes, b.release = nil, nil
+}
+
+// Release releases every SharedBytes field in v and in the structures within it. It does nothing for
+// structures without any, such as those decoded into values from FieldDesc.Zero rather than ZeroShared.
+func (v PVStructure) Release() {
+	if v.v.IsValid() {
+		releaseShared(v.v)
+	}
+}
+
+func releaseShared(v reflect.Value) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	switch v.Type() {
+	case sharedBytesType:
+		if v.CanAddr() {
+			v.Addr().Interface().(*SharedBytes).Release()
+		}
+		return
+	case pvStructureType:
+		v.Interface().(PVStructure).Release()
+		return
+	}
+	if v.Kind() == reflect.Struct {
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath == "" {
+				releaseShared(v.Field(i))
+			}
+		}
+	}
+}
+
+// ZeroShared is like Zero, but creates byte and ubyte arrays as SharedBytes, so that decoding into the value
+// aliases the decoder's buffer instead of copying it. The value must be released with Release once it has been used.
+func (f FieldDesc) ZeroShared() (PVField, error) {
+	return f.zero("", zeroOptions{sharedBytes: true})
+}
@@ -0,0 +1,99 @@
+package pvdata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// sharingReader shares the bytes of data, counting the shares that haven't been released.
+type sharingReader struct {
+	*bytes.Reader
+	data     []byte
+	unshared int
+}
+
+func (r *sharingReader) Share(n int) ([]byte, func(), bool) {
+	off := len(r.data) - r.Len()
+	if n > r.Len() {
+		return nil, nil, false
+	}
+	r.Seek(int64(n), 1)
+	r.unshared++
+	return r.data[off : off+n : off+n], func() { r.unshared-- }, true
+}
+
+func TestSharedBytes(t *testing.T) {
+	type value struct {
+		Image struct {
+			Data []PVUByte `pvaccess:"data"`
+			Tags []PVByte  `pvaccess:"tags"`
+		} `pvaccess:"image"`
+		Count PVInt `pvaccess:"count"`
+	}
+	in := &value{Count: 3}
+	in.Image.Data = []PVUByte{1, 2, 3}
+	in.Image.Tags = []PVByte{-1}
+	pvs, err := NewPVStructure(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd, err := pvs.FieldDesc()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Encode(&EncoderState{Buf: &buf, ByteOrder: binary.LittleEndian}, in); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	zero, err := fd.ZeroShared()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := zero.(PVStructure)
+	r := &sharingReader{Reader: bytes.NewReader(data), data: data}
+	if err := Decode(&DecoderState{Buf: r, ByteOrder: binary.LittleEndian}, out); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"image": map[string]interface{}{"data": []byte{1, 2, 3}, "tags": []byte{0xff}},
+		"count": int32(3),
+	}
+	if diff := cmp.Diff(want, out.ToMap()); diff != "" {
+		t.Errorf("decoded value differs (-want +got):\n%s", diff)
+	}
+	image := out.ToMap()["image"].(map[string]interface{})
+	if got := image["data"].([]byte); &got[0] != &data[1] {
+		t.Error("data was copied instead of shared")
+	}
+	if r.unshared != 2 {
+		t.Errorf("%d shares, want 2", r.unshared)
+	}
+	outFD, err := out.FieldDesc()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(fd, outFD); diff != "" {
+		t.Errorf("field description differs (-want +got):\n%s", diff)
+	}
+	out.Release()
+	out.Release()
+	if r.unshared != 0 {
+		t.Errorf("%d shares left after Release, want 0", r.unshared)
+	}
+	if m := out.ToMap()["image"].(map[string]interface{}); len(m["data"].([]byte)) != 0 {
+		t.Errorf("data after Release = %v, want none", m["data"])
+	}
+
+	// Readers that can't share are copied from.
+	if err := Decode(&DecoderState{Buf: bytes.NewReader(data), ByteOrder: binary.LittleEndian}, out); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, out.ToMap()); diff != "" {
+		t.Errorf("copied value differs (-want +got):\n%s", diff)
+	}
+}
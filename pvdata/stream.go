@@ -0,0 +1,239 @@
+package pvdata
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// ArrayStream is a variable-size array field whose elements are produced in chunks as it is encoded,
+// so that a huge array, such as a long waveform, never has to be held in memory at once.
+// Connections that send segmented messages send each segment as soon as it has been encoded.
+//
+// ArrayStreams can only be encoded; peers receive an ordinary array. ToMap collects every element.
+type ArrayStream struct {
+	// Elem is a value of the type of the elements, such as PVDouble(0), from which the field is described.
+	Elem interface{}
+	// Len is the number of elements.
+	Len int
+	// Chunks calls yield with consecutive slices of elements, which must hold Len elements in total,
+	// and returns the first error from yield. It is called each time the field is encoded.
+	// A chunk may be reused once yield returns.
+	Chunks func(yield func(chunk interface{}) error) error
+}
+
+// errStreamBuffered is returned by ArrayStream.PVEncode to a PVStructureDiff that is buffering its value,
+// which then encodes the value again without buffering it.
+var errStreamBuffered = errors.New("array stream can't be buffered")
+
+func (a ArrayStream) PVEncode(s *EncoderState) error {
+	if s.buffering {
+		return errStreamBuffered
+	}
+	if s.skipStreams {
+		return nil
+	}
+	if err := PVSize(a.Len).PVEncode(s); err != nil {
+		return err
+	}
+	elem := reflect.TypeOf(a.Elem)
+	n := 0
+	if err := a.Chunks(func(chunk interface{}) error {
+		v := reflect.ValueOf(chunk)
+		if v.Kind() != reflect.Slice || v.Type().Elem() != elem {
+			return fmt.Errorf("array stream of %v yielded %T", elem, chunk)
+		}
+		if n += v.Len(); n > a.Len {
+			return fmt.Errorf("array stream yielded more than %d elements", a.Len)
+		}
+		return PVArray{fixed: true, v: v}.PVEncode(s)
+	}); err != nil {
+		return err
+	}
+	if n != a.Len {
+		return fmt.Errorf("array stream yielded %d elements, want %d", n, a.Len)
+	}
+	return nil
+}
+
+func (a ArrayStream) PVDecode(s *DecoderState) error {
+	return errors.New("array streams can't be decoded")
+}
+
+func (a ArrayStream) FieldDesc() (FieldDesc, error) {
+	if a.Elem == nil {
+		return FieldDesc{}, errors.New("array stream has no element type")
+	}
+	return valueToField(reflect.New(reflect.SliceOf(reflect.TypeOf(a.Elem))))
+}
+
+// collect returns a slice holding all of the elements of a, for maps.
+func (a ArrayStream) collect() (reflect.Value, error) {
+	out := reflect.MakeSlice(reflect.SliceOf(reflect.TypeOf(a.Elem)), 0, a.Len)
+	err := a.Chunks(func(chunk interface{}) error {
+		v := reflect.ValueOf(chunk)
+		if v.Kind() != reflect.Slice || v.Type() != out.Type() {
+			return fmt.Errorf("array stream of %v yielded %T", out.Type().Elem(), chunk)
+		}
+		out = reflect.AppendSlice(out, v)
+		return nil
+	})
+	return out, err
+}
+
+// ArraySink is a variable-size array field whose elements are passed to Chunk in chunks as it is decoded,
+// instead of being collected, so that a huge array can be consumed without holding it in memory at once.
+// Connections that stream segmented messages decode each segment as it arrives.
+//
+// ArraySinks can only be decoded, and are usually created by FieldDesc.ZeroWithSinks. ToMap omits them.
+type ArraySink struct {
+	// Elem is a value of the Go type of the elements, such as float64(0) for a double array.
+	Elem interface{}
+	// ChunkLen is the most elements passed to Chunk at once. Zero selects 4096.
+	ChunkLen int
+	// Chunk is called with consecutive chunks of the elements each time the field is decoded, as a slice of Elem's
+	// type that is reused once Chunk returns, with the index in the array of the chunk's first element and the
+	// array's length. Empty arrays have no chunks. An error stops decoding.
+	Chunk func(chunk interface{}, offset, length int) error
+}
+
+const defaultSinkChunkLen = 4096
+
+func (a ArraySink) PVEncode(s *EncoderState) error {
+	return errors.New("array sinks can't be encoded")
+}
+
+func (a ArraySink) PVDecode(s *DecoderState) error {
+	if a.Elem == nil {
+		return errors.New("array sink has no element type")
+	}
+	if s.useChangedBitSet {
+		// Arrays do not contribute to the bitset.
+		defer func() { s.useChangedBitSet = true }()
+		s.useChangedBitSet = false
+	}
+	var size PVSize
+	if err := size.PVDecode(s); err != nil {
+		return err
+	}
+	if size < 0 {
+		size = 0
+	}
+	n := a.ChunkLen
+	if n <= 0 {
+		n = defaultSinkChunkLen
+	}
+	if int(size) < n {
+		n = int(size)
+	}
+	chunk := reflect.MakeSlice(reflect.SliceOf(reflect.TypeOf(a.Elem)), n, n)
+	for offset := 0; offset < int(size); offset += n {
+		if int(size)-offset < n {
+			n = int(size) - offset
+		}
+		c := chunk.Slice(0, n)
+		if err := decodeElements(s, c, n); err != nil {
+			return err
+		}
+		if err := a.Chunk(c.Interface(), offset, int(size)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a ArraySink) FieldDesc() (FieldDesc, error) {
+	if a.Elem == nil {
+		return FieldDesc{}, errors.New("array sink has no element type")
+	}
+	return valueToField(reflect.New(reflect.SliceOf(reflect.TypeOf(a.Elem))))
+}
+
+// describe sets a's element type to that of the field f, which must be a variable-size array of scalars.
+func (a *ArraySink) describe(f FieldDesc) error {
+	if f.TypeCode&ARRAY_BITS != VARIABLE_ARRAY || f.TypeCode&STRUCT != 0 {
+		return fmt.Errorf("type 0x%x is not a variable-size array of scalars", f.TypeCode)
+	}
+	elem := f
+	elem.TypeCode &^= ARRAY_BITS
+	prototype, err := elem.createZero()
+	if err != nil {
+		return err
+	}
+	v := reflect.ValueOf(prototype)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if bt, ok := basicTypes[v.Kind()]; ok {
+		a.Elem = reflect.Zero(bt).Interface()
+	} else {
+		a.Elem = v.Interface()
+	}
+	return nil
+}
+
+// ZeroWithSinks is like Zero for a structure, but decodes the array fields named by the keys of sinks, such as
+// "value" or "image.data", into the corresponding ArraySinks, whose Elem is set from the field's type.
+func (f FieldDesc) ZeroWithSinks(sinks map[string]*ArraySink) (PVStructure, error) {
+	if f.TypeCode != STRUCT {
+		return PVStructure{}, fmt.Errorf("type 0x%x is not a structure", f.TypeCode)
+	}
+	for _, sink := range sinks {
+		sink.Elem = nil
+	}
+	zero, err := f.zero("", zeroOptions{sinks: sinks})
+	if err != nil {
+		return PVStructure{}, err
+	}
+	for path, sink := range sinks {
+		if sink.Elem == nil {
+			return PVStructure{}, fmt.Errorf("structure has no field %s", path)
+		}
+	}
+	pvs, ok := zero.(PVStructure)
+	if !ok {
+		return PVStructure{}, fmt.Errorf("%s structures can't have array sinks", f.StructType)
+	}
+	return pvs, nil
+}
+
+// encodeStreaming encodes a value containing ArrayStreams without buffering it, by first numbering its fields
+// to find the changed bitset that precedes it.
+func (v PVStructureDiff) encodeStreaming(s *EncoderState) error {
+	changed := v.ChangedBitSet
+	if len(changed.Present) == 0 {
+		err := func() error {
+			defer s.PushWriter(discard{})()
+			s.skipStreams = true
+			defer func() { s.skipStreams = false }()
+			s.changedBitSet = PVBitSet{Present: []bool{false}}
+			s.useChangedBitSet = true
+			return Encode(s, v.Value)
+		}()
+		if err != nil {
+			return err
+		}
+		changed = s.changedBitSet
+	}
+	if err := Encode(s, &changed); err != nil {
+		return err
+	}
+	if len(v.ChangedBitSet.Present) > 0 {
+		s.changedBitSet = v.ChangedBitSet
+		s.onlyChanged = true
+		s.changedBitSetIndex = 0
+		s.changedParent = false
+		defer func() { s.onlyChanged = false }()
+	} else {
+		s.changedBitSet = PVBitSet{Present: []bool{false}}
+	}
+	s.useChangedBitSet = true
+	return Encode(s, v.Value)
+}
+
+// discard is a Writer that discards everything written to it.
+type discard struct{}
+
+func (discard) Write(p []byte) (int, error)       { return len(p), nil }
+func (discard) WriteByte(byte) error              { return nil }
+func (discard) WriteString(s string) (int, error) { return len(s), nil }
@@ -0,0 +1,166 @@
+package pvdata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestArrayStream(t *testing.T) {
+	elements := []PVInt{1, 2, 3, 4, 5}
+	stream := ArrayStream{Elem: PVInt(0), Len: len(elements), Chunks: func(yield func(interface{}) error) error {
+		for i := 0; i < len(elements); i += 2 {
+			end := i + 2
+			if end > len(elements) {
+				end = len(elements)
+			}
+			if err := yield(elements[i:end]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}}
+	type value struct {
+		Name  PVString `pvaccess:"name"`
+		Array []PVInt  `pvaccess:"array"`
+		Meta  struct {
+			Count PVInt `pvaccess:"count"`
+		} `pvaccess:"meta"`
+	}
+	type streamValue struct {
+		Name  PVString    `pvaccess:"name"`
+		Array ArrayStream `pvaccess:"array"`
+		Meta  struct {
+			Count PVInt `pvaccess:"count"`
+		} `pvaccess:"meta"`
+	}
+	encode := func(v interface{}, changed PVBitSet) []byte {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := Encode(&EncoderState{Buf: &buf, ByteOrder: binary.LittleEndian}, &PVStructureDiff{ChangedBitSet: changed, Value: v}); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	want := &value{Name: "x", Array: elements}
+	want.Meta.Count = 5
+	got := &streamValue{Name: "x", Array: stream}
+	got.Meta.Count = 5
+	for _, changed := range []PVBitSet{{}, NewBitSetWithBits(2, 4)} {
+		if diff := cmp.Diff(encode(want, changed), encode(got, changed)); diff != "" {
+			t.Errorf("encoding with bitset %v differs from a slice (-want +got):\n%s", changed, diff)
+		}
+	}
+
+	describe := func(v interface{}) FieldDesc {
+		t.Helper()
+		pvs, err := NewPVStructure(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fd, err := pvs.FieldDesc()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fd
+	}
+	wantFD, gotFD := describe(want), describe(got)
+	if diff := cmp.Diff(wantFD, gotFD); diff != "" {
+		t.Errorf("field description differs from a slice (-want +got):\n%s", diff)
+	}
+	m, err := ToMap(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]int32{1, 2, 3, 4, 5}, m["array"]); diff != "" {
+		t.Errorf("ToMap array differs (-want +got):\n%s", diff)
+	}
+
+	short := stream
+	short.Len = 6
+	if err := Encode(&EncoderState{Buf: &bytes.Buffer{}, ByteOrder: binary.LittleEndian}, &short); err == nil {
+		t.Error("encoding a stream shorter than Len succeeded")
+	}
+}
+
+func TestArraySink(t *testing.T) {
+	type value struct {
+		Name  PVString   `pvaccess:"name"`
+		Array []PVDouble `pvaccess:"array"`
+		Meta  struct {
+			Count PVInt    `pvaccess:"count"`
+			Data  []PVUInt `pvaccess:"data"`
+		} `pvaccess:"meta"`
+	}
+	in := &value{Name: "x", Array: []PVDouble{1, 2, 3, 4, 5}}
+	in.Meta.Count = 5
+	in.Meta.Data = []PVUInt{6, 7}
+	pvs, err := NewPVStructure(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd, err := pvs.FieldDesc()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Encode(&EncoderState{Buf: &buf, ByteOrder: binary.LittleEndian}, &PVStructureDiff{Value: in}); err != nil {
+		t.Fatal(err)
+	}
+
+	type chunk struct {
+		Elements      interface{}
+		Offset, Total int
+	}
+	var chunks []chunk
+	sinks := map[string]*ArraySink{
+		"array":     {ChunkLen: 2},
+		"meta.data": {},
+	}
+	for _, sink := range sinks {
+		sink.Chunk = func(c interface{}, offset, total int) error {
+			// Chunks are reused, so copy their elements.
+			v := reflect.ValueOf(c)
+			chunks = append(chunks, chunk{reflect.AppendSlice(reflect.MakeSlice(v.Type(), 0, v.Len()), v).Interface(), offset, total})
+			return nil
+		}
+	}
+	out, err := fd.ZeroWithSinks(sinks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Decode(&DecoderState{Buf: bytes.NewReader(buf.Bytes()), ByteOrder: binary.LittleEndian}, &PVStructureDiff{Value: out}); err != nil {
+		t.Fatal(err)
+	}
+	want := []chunk{
+		{[]float64{1, 2}, 0, 5},
+		{[]float64{3, 4}, 2, 5},
+		{[]float64{5}, 4, 5},
+		{[]uint32{6, 7}, 0, 2},
+	}
+	if diff := cmp.Diff(want, chunks); diff != "" {
+		t.Errorf("chunks differ (-want +got):\n%s", diff)
+	}
+	wantMap := map[string]interface{}{"name": "x", "meta": map[string]interface{}{"count": int32(5)}}
+	if diff := cmp.Diff(wantMap, out.ToMap()); diff != "" {
+		t.Errorf("ToMap differs (-want +got):\n%s", diff)
+	}
+
+	for _, path := range []string{"name", "missing", "meta.missing"} {
+		if _, err := fd.ZeroWithSinks(map[string]*ArraySink{path: {}}); err == nil {
+			t.Errorf("ZeroWithSinks of field %s succeeded", path)
+		}
+	}
+	stop := errors.New("stop")
+	out, err = fd.ZeroWithSinks(map[string]*ArraySink{"array": {ChunkLen: 2, Chunk: func(interface{}, int, int) error { return stop }}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Decode(&DecoderState{Buf: bytes.NewReader(buf.Bytes()), ByteOrder: binary.LittleEndian}, &PVStructureDiff{Value: out}); !errors.Is(err, stop) {
+		t.Errorf("Decode with a failing sink = %v, want %v", err, stop)
+	}
+}
@@ -0,0 +1,133 @@
+package pvdata
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TypeID is a parsed structure type ID. Normative type IDs such as "epics:nt/NTScalar:1.2" end with a version
+// after the last colon; other IDs, such as "alarm_t", have none.
+//
+// Minor versions of a type only add optional fields, so a value of a newer minor version can be handled as
+// an older one, with the new fields ignored. Types with different major versions are incompatible.
+type TypeID struct {
+	// Name is the type ID without its version, e.g. "epics:nt/NTScalar".
+	Name string
+	// Versioned is set if the type ID has a version, which is Major.Minor.
+	Versioned    bool
+	Major, Minor int
+}
+
+// ParseTypeID parses a structure type ID. An ID whose last colon isn't followed by a version of the form
+// major.minor, such as "1.0", is unversioned, and its Name is the whole ID.
+func ParseTypeID(id string) TypeID {
+	i := strings.LastIndexByte(id, ':')
+	if i < 0 {
+		return TypeID{Name: id}
+	}
+	major, minor, ok := parseVersion(id[i+1:])
+	if !ok {
+		return TypeID{Name: id}
+	}
+	return TypeID{Name: id[:i], Versioned: true, Major: major, Minor: minor}
+}
+
+func parseVersion(v string) (major, minor int, ok bool) {
+	dot := strings.IndexByte(v, '.')
+	if dot < 0 {
+		return 0, 0, false
+	}
+	major, ok = parseVersionNumber(v[:dot])
+	if !ok {
+		return 0, 0, false
+	}
+	minor, ok = parseVersionNumber(v[dot+1:])
+	return major, minor, ok
+}
+
+// parseVersionNumber parses part of a version, which must be decimal digits without a sign.
+func parseVersionNumber(s string) (int, bool) {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, false
+	}
+	n, err := strconv.Atoi(s)
+	return n, err == nil
+}
+
+func (t TypeID) String() string {
+	if !t.Versioned {
+		return t.Name
+	}
+	return fmt.Sprintf("%s:%d.%d", t.Name, t.Major, t.Minor)
+}
+
+// Compatible reports whether values of type u can be handled as type t: they have the same name,
+// and either neither is versioned or both have the same major version.
+func (t TypeID) Compatible(u TypeID) bool {
+	if t.Name != u.Name || t.Versioned != u.Versioned {
+		return false
+	}
+	return !t.Versioned || t.Major == u.Major
+}
+
+// Compare compares the versions of t and u, returning -1 if t is older, 1 if t is newer, and 0 if they are the same.
+// An unversioned ID is older than any version. Names are not compared.
+func (t TypeID) Compare(u TypeID) int {
+	switch {
+	case t.Versioned != u.Versioned:
+		if t.Versioned {
+			return 1
+		}
+		return -1
+	case t.Major != u.Major:
+		return compareInts(t.Major, u.Major)
+	default:
+		return compareInts(t.Minor, u.Minor)
+	}
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// TypeIDCompatible reports whether values with the type ID id can be handled as the type ID want,
+// e.g. whether an "epics:nt/NTScalar:1.2" from a newer server can be decoded as an "epics:nt/NTScalar:1.0".
+func TypeIDCompatible(id, want string) bool {
+	return ParseTypeID(want).Compatible(ParseTypeID(id))
+}
+
+// SelectTypeID returns the index of the type ID in candidates that best handles values with the type ID id,
+// or -1 if none are compatible with it. It is for choosing how to decode versions of a type that differ:
+// the best candidate is the newest that is no newer than id, or failing that the oldest compatible one,
+// since a value may lack fields that were added after its version.
+func SelectTypeID(id string, candidates ...string) int {
+	t := ParseTypeID(id)
+	best := -1
+	var bestID TypeID
+	for i, c := range candidates {
+		cid := ParseTypeID(c)
+		if cid.Compatible(t) && (best < 0 || betterTypeID(cid, bestID, t)) {
+			best, bestID = i, cid
+		}
+	}
+	return best
+}
+
+// betterTypeID reports whether candidate handles values of type t better than best does.
+func betterTypeID(candidate, best, t TypeID) bool {
+	candidateOlder, bestOlder := candidate.Compare(t) <= 0, best.Compare(t) <= 0
+	if candidateOlder != bestOlder {
+		return candidateOlder
+	}
+	if candidateOlder {
+		return candidate.Compare(best) > 0
+	}
+	return candidate.Compare(best) < 0
+}
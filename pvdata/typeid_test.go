@@ -0,0 +1,71 @@
+package pvdata
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseTypeID(t *testing.T) {
+	for _, test := range []struct {
+		id   string
+		want TypeID
+	}{
+		{"epics:nt/NTScalar:1.2", TypeID{Name: "epics:nt/NTScalar", Versioned: true, Major: 1, Minor: 2}},
+		{"epics:nt/NTTable:10.0", TypeID{Name: "epics:nt/NTTable", Versioned: true, Major: 10}},
+		{"alarm_t", TypeID{Name: "alarm_t"}},
+		{"", TypeID{}},
+		{"epics:nt/NTScalar", TypeID{Name: "epics:nt/NTScalar"}},
+		{"epics:nt/NTScalar:1", TypeID{Name: "epics:nt/NTScalar:1"}},
+		{"epics:nt/NTScalar:1.x", TypeID{Name: "epics:nt/NTScalar:1.x"}},
+		{"epics:nt/NTScalar:-1.0", TypeID{Name: "epics:nt/NTScalar:-1.0"}},
+	} {
+		got := ParseTypeID(test.id)
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("ParseTypeID(%q) differs (-want +got):\n%s", test.id, diff)
+		}
+		if got.String() != test.id {
+			t.Errorf("ParseTypeID(%q).String() = %q", test.id, got.String())
+		}
+	}
+}
+
+func TestTypeIDCompatible(t *testing.T) {
+	for _, test := range []struct {
+		id, want string
+		ok       bool
+	}{
+		{"epics:nt/NTScalar:1.0", "epics:nt/NTScalar:1.0", true},
+		{"epics:nt/NTScalar:1.3", "epics:nt/NTScalar:1.0", true},
+		{"epics:nt/NTScalar:1.0", "epics:nt/NTScalar:1.3", true},
+		{"epics:nt/NTScalar:2.0", "epics:nt/NTScalar:1.0", false},
+		{"epics:nt/NTScalarArray:1.0", "epics:nt/NTScalar:1.0", false},
+		{"epics:nt/NTScalar", "epics:nt/NTScalar:1.0", false},
+		{"alarm_t", "alarm_t", true},
+	} {
+		if got := TypeIDCompatible(test.id, test.want); got != test.ok {
+			t.Errorf("TypeIDCompatible(%q, %q) = %v, want %v", test.id, test.want, got, test.ok)
+		}
+	}
+}
+
+func TestSelectTypeID(t *testing.T) {
+	candidates := []string{"epics:nt/NTTable:1.0", "epics:nt/NTTable:1.2", "epics:nt/NTTable:2.1", "epics:nt/NTTable:2.3"}
+	for _, test := range []struct {
+		id   string
+		want int
+	}{
+		{"epics:nt/NTTable:1.0", 0},
+		{"epics:nt/NTTable:1.1", 0},
+		{"epics:nt/NTTable:1.5", 1},
+		{"epics:nt/NTTable:2.0", 2},
+		{"epics:nt/NTTable:2.2", 2},
+		{"epics:nt/NTTable:2.9", 3},
+		{"epics:nt/NTTable:3.0", -1},
+		{"epics:nt/NTScalar:1.0", -1},
+	} {
+		if got := SelectTypeID(test.id, candidates...); got != test.want {
+			t.Errorf("SelectTypeID(%q) = %d, want %d", test.id, got, test.want)
+		}
+	}
+}
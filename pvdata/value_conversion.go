@@ -5,6 +5,7 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func parseTag(tag string) (name string, tags map[string]string) {
@@ -98,6 +99,9 @@ type TypeIDer interface {
 }
 
 func valueToPVField(v reflect.Value, options ...option) PVField {
+	if !v.IsValid() {
+		return nil
+	}
 	for _, o := range options {
 		if pvf := o(v); pvf != nil {
 			return pvf
@@ -117,6 +121,9 @@ func valueToPVField(v reflect.Value, options ...option) PVField {
 		if i, ok := i.(PVField); ok {
 			return i
 		}
+		if pvf := newMarshalerField(i); pvf != nil {
+			return pvf
+		}
 		switch i := i.(type) {
 		case *PVField:
 			return *i
@@ -138,14 +145,30 @@ func valueToPVField(v reflect.Value, options ...option) PVField {
 			return (*PVLong)(i)
 		case *uint64:
 			return (*PVULong)(i)
+		case *int:
+			return intField{i}
+		case *uint:
+			return uintField{i}
 		case *float32:
 			return (*PVFloat)(i)
 		case *float64:
 			return (*PVDouble)(i)
 		case *string:
 			return (*PVString)(i)
+		case *time.Time:
+			return timeField{i}
 		}
 	}
+	if v.Kind() == reflect.Interface && !v.IsNil() {
+		// Convert the dynamic value, copying it if necessary so that it is addressable.
+		e := v.Elem()
+		if e.Kind() != reflect.Ptr {
+			c := reflect.New(e.Type())
+			c.Elem().Set(e)
+			e = c
+		}
+		return valueToPVField(e, options...)
+	}
 	if v.Kind() == reflect.Ptr {
 		v = v.Elem()
 	}
@@ -196,6 +219,15 @@ type FieldDescer interface {
 }
 
 func valueToField(v reflect.Value) (FieldDesc, error) {
+	if v.Kind() == reflect.Interface && !v.IsNil() {
+		// Describe the dynamic value, copying it if necessary so that it can be converted to a PVField.
+		v = v.Elem()
+		if v.Kind() != reflect.Ptr {
+			c := reflect.New(v.Type())
+			c.Elem().Set(v)
+			v = c
+		}
+	}
 	if f, ok := v.Interface().(FieldDescer); ok {
 		return f.FieldDesc()
 	}
@@ -57,3 +57,29 @@ func TestField(t *testing.T) {
 		})
 	}
 }
+
+func TestEncodeInterfaceField(t *testing.T) {
+	type dynamic struct {
+		Value interface{} `pvaccess:"value"`
+	}
+	waveform := []PVDouble{1, 2}
+	var want bytes.Buffer
+	if err := Encode(&EncoderState{Buf: &want, ByteOrder: binary.LittleEndian}, &waveform); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []interface{}{waveform, &waveform, [2]PVDouble{1, 2}} {
+		var buf bytes.Buffer
+		if err := Encode(&EncoderState{Buf: &buf, ByteOrder: binary.LittleEndian}, &dynamic{v}); err != nil {
+			t.Errorf("encoding %T in an interface field: %v", v, err)
+			continue
+		}
+		wantBytes := want.Bytes()
+		if _, fixed := v.([2]PVDouble); fixed {
+			// Fixed arrays have no size.
+			wantBytes = wantBytes[1:]
+		}
+		if diff := cmp.Diff(wantBytes, buf.Bytes()); diff != "" {
+			t.Errorf("encoding %T in an interface field differs (-want +got):\n%s", v, diff)
+		}
+	}
+}
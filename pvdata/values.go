@@ -32,6 +32,16 @@ type EncoderState struct {
 
 	changedBitSet    PVBitSet
 	useChangedBitSet bool
+	// onlyChanged is set when changedBitSet was given by the caller, and only the fields it selects are encoded.
+	onlyChanged        bool
+	changedBitSetIndex int
+	// changedParent is set while encoding or decoding the fields of a structure whose own bit is set.
+	changedParent bool
+
+	// buffering is set while a PVStructureDiff is encoded into a buffer, which ArrayStreams refuse.
+	buffering bool
+	// skipStreams is set while a PVStructureDiff numbers its fields, and ArrayStreams encode nothing.
+	skipStreams bool
 }
 
 func (s *EncoderState) WriteUint16(v uint16) error {
@@ -66,9 +76,18 @@ type DecoderState struct {
 	Buf       Reader
 	ByteOrder binary.ByteOrder
 
+	// Strict causes DecodeAs to fail if the fields on the wire don't exactly match the Go value.
+	Strict bool
+	// Dropped and Missing are appended to by DecodeAs when Strict is false.
+	// Dropped lists fields on the wire that were ignored because the Go value has no matching field (or it has an incompatible type).
+	// Missing lists fields of the Go value that were not on the wire and were left unchanged.
+	// Fields are named by their path, e.g. "alarm.severity".
+	Dropped, Missing []string
+
 	changedBitSet      PVBitSet
 	useChangedBitSet   bool
 	changedBitSetIndex int
+	changedParent      bool
 }
 
 func (s *DecoderState) ReadUint16() (uint16, error) {
@@ -101,13 +120,16 @@ func (s *DecoderState) pushChangedBitSet(bs PVBitSet) func() {
 	oldCBS := s.changedBitSet
 	oldUCBS := s.useChangedBitSet
 	oldCBSI := s.changedBitSetIndex
+	oldCP := s.changedParent
 	s.changedBitSet = bs
 	s.useChangedBitSet = true
 	s.changedBitSetIndex = 0
+	s.changedParent = false
 	return func() {
 		s.changedBitSet = oldCBS
 		s.useChangedBitSet = oldUCBS
 		s.changedBitSetIndex = oldCBSI
+		s.changedParent = oldCP
 	}
 }
 
@@ -326,6 +348,52 @@ func (PVULong) FieldDesc() (FieldDesc, error) {
 	return FieldDesc{TypeCode: ULONG}, nil
 }
 
+// intField encodes a Go int as a PVLong, so that it keeps its full range on every platform.
+type intField struct {
+	i *int
+}
+
+func (f intField) PVEncode(s *EncoderState) error {
+	return PVLong(*f.i).PVEncode(s)
+}
+func (f intField) PVDecode(s *DecoderState) error {
+	var v PVLong
+	if err := v.PVDecode(s); err != nil {
+		return err
+	}
+	if int64(int(v)) != int64(v) {
+		return fmt.Errorf("long %d overflows int", v)
+	}
+	*f.i = int(v)
+	return nil
+}
+func (intField) FieldDesc() (FieldDesc, error) {
+	return FieldDesc{TypeCode: LONG}, nil
+}
+
+// uintField encodes a Go uint as a PVULong.
+type uintField struct {
+	u *uint
+}
+
+func (f uintField) PVEncode(s *EncoderState) error {
+	return PVULong(*f.u).PVEncode(s)
+}
+func (f uintField) PVDecode(s *DecoderState) error {
+	var v PVULong
+	if err := v.PVDecode(s); err != nil {
+		return err
+	}
+	if uint64(uint(v)) != uint64(v) {
+		return fmt.Errorf("ulong %d overflows uint", v)
+	}
+	*f.u = uint(v)
+	return nil
+}
+func (uintField) FieldDesc() (FieldDesc, error) {
+	return FieldDesc{TypeCode: ULONG}, nil
+}
+
 type PVFloat float32
 
 func (v PVFloat) PVEncode(s *EncoderState) error {
@@ -447,8 +515,16 @@ func (a PVArray) PVDecode(s *DecoderState) error {
 		}
 		a.v.SetLen(int(size))
 	}
-	for i := 0; i < int(size); i++ {
-		item := a.v.Index(i).Addr()
+	return decodeElements(s, a.v, int(size))
+}
+
+// decodeElements decodes the first n elements of the slice or array v.
+func decodeElements(s *DecoderState, v reflect.Value, n int) error {
+	if ok, err := decodeScalarArray(s, v, n); ok {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		item := v.Index(i).Addr()
 		pvf := valueToPVField(item)
 		if pvf == nil {
 			return fmt.Errorf("don't know how to decode %#v", item.Interface())
@@ -459,6 +535,70 @@ func (a PVArray) PVDecode(s *DecoderState) error {
 	}
 	return nil
 }
+
+// scalarSizes holds the encoded size of array element types that decodeScalarArray can decode in bulk.
+var scalarSizes = map[reflect.Type]int{}
+
+func init() {
+	for _, v := range []interface{}{
+		PVBoolean(false), false,
+		PVByte(0), int8(0), PVUByte(0), uint8(0),
+		PVShort(0), int16(0), PVUShort(0), uint16(0),
+		PVInt(0), int32(0), PVUInt(0), uint32(0),
+		PVLong(0), int64(0), PVULong(0), uint64(0),
+		PVFloat(0), float32(0), PVDouble(0), float64(0),
+	} {
+		t := reflect.TypeOf(v)
+		scalarSizes[t] = int(t.Size())
+	}
+}
+
+// decodeScalarArray decodes n elements into v with a single read, if its elements are scalars.
+// It returns false if v must be decoded element by element.
+func decodeScalarArray(s *DecoderState, v reflect.Value, n int) (bool, error) {
+	size, ok := scalarSizes[v.Type().Elem()]
+	if !ok || n == 0 {
+		return ok, nil
+	}
+	kind := v.Type().Elem().Kind()
+	if kind == reflect.Uint8 {
+		_, err := io.ReadFull(s.Buf, v.Slice(0, n).Bytes())
+		return true, err
+	}
+	buf := make([]byte, n*size)
+	if _, err := io.ReadFull(s.Buf, buf); err != nil {
+		return true, err
+	}
+	bo := s.ByteOrder
+	for i := 0; i < n; i++ {
+		b := buf[i*size:]
+		e := v.Index(i)
+		switch kind {
+		case reflect.Bool:
+			e.SetBool(b[0] != 0)
+		case reflect.Int8:
+			e.SetInt(int64(int8(b[0])))
+		case reflect.Int16:
+			e.SetInt(int64(int16(bo.Uint16(b))))
+		case reflect.Uint16:
+			e.SetUint(uint64(bo.Uint16(b)))
+		case reflect.Int32:
+			e.SetInt(int64(int32(bo.Uint32(b))))
+		case reflect.Uint32:
+			e.SetUint(uint64(bo.Uint32(b)))
+		case reflect.Int64:
+			e.SetInt(int64(bo.Uint64(b)))
+		case reflect.Uint64:
+			e.SetUint(bo.Uint64(b))
+		case reflect.Float32:
+			e.SetFloat(float64(math.Float32frombits(bo.Uint32(b))))
+		case reflect.Float64:
+			e.SetFloat(math.Float64frombits(bo.Uint64(b)))
+		}
+	}
+	return true, nil
+}
+
 func (a PVArray) FieldDesc() (FieldDesc, error) {
 	var prototype reflect.Value
 	if a.v.Len() > 0 {
@@ -514,10 +654,7 @@ func (v *PVString) PVDecode(s *DecoderState) error {
 	return nil
 }
 func (v PVString) FieldDesc() (FieldDesc, error) {
-	return FieldDesc{
-		TypeCode: STRING,
-		Size:     PVSize(len(v)),
-	}, nil
+	return FieldDesc{TypeCode: STRING}, nil
 }
 
 type PVBoundedString struct {
@@ -546,6 +683,7 @@ type PVStructure struct {
 }
 
 // NewPVStructure creates a PVStructure from a pointer to a struct type or a PVStructure.
+// The structure's type ID is taken from the struct's TypeID method, if it has one.
 func NewPVStructure(data interface{}) (PVStructure, error) {
 	if data, ok := data.(PVStructure); ok {
 		return data, nil
@@ -560,18 +698,35 @@ func NewPVStructure(data interface{}) (PVStructure, error) {
 	if v.Kind() != reflect.Struct {
 		return PVStructure{}, errors.New("data was not a struct")
 	}
+	var typeID string
+	if t, ok := data.(TypeIDer); ok {
+		typeID = t.TypeID()
+	}
 	return PVStructure{
-		v: v,
+		ID: typeID,
+		v:  v,
 	}, nil
 }
 
-// TODO: Support bitfields for partial pack/unpack
+// isAbsent reports whether v is a structure field that is left out of the structure.
+// Pointer fields are optional: a nil pointer means the field is absent.
+func isAbsent(v reflect.Value) bool {
+	return v.Kind() == reflect.Ptr && v.IsNil()
+}
+
 func (v PVStructure) PVEncode(s *EncoderState) error {
+	onlyChanged := s.useChangedBitSet && s.onlyChanged
+	// As when decoding, every field of a structure whose bit is set is encoded.
+	fullStruct := !onlyChanged || s.changedParent || s.changedBitSet.Get(s.changedBitSetIndex)
+	if onlyChanged {
+		defer func(old bool) { s.changedParent = old }(s.changedParent)
+		s.changedParent = fullStruct
+	}
 	t := v.v.Type()
 	for i := 0; i < v.v.NumField(); i++ {
 		vf := v.v.Field(i)
 		_, tags := parseTag(t.Field(i).Tag.Get("pvaccess"))
-		if tags["omitifnil"] != "" && vf.Kind() == reflect.Ptr && (!vf.IsValid() || vf.IsNil()) {
+		if isAbsent(vf) {
 			continue
 		}
 		item := vf.Addr()
@@ -579,7 +734,12 @@ func (v PVStructure) PVEncode(s *EncoderState) error {
 		if pvf == nil {
 			return fmt.Errorf("don't know how to encode %#v", item.Interface())
 		}
-		if s.useChangedBitSet {
+		if onlyChanged {
+			s.changedBitSetIndex++
+			if _, isStruct := pvf.(PVStructure); !fullStruct && !isStruct && !s.changedBitSet.Get(s.changedBitSetIndex) {
+				continue
+			}
+		} else if s.useChangedBitSet {
 			// TODO: Check if the field has actually changed.
 			s.changedBitSet.Present = append(s.changedBitSet.Present, true)
 		}
@@ -598,11 +758,18 @@ func (v PVStructure) PVDecode(s *DecoderState) error {
 	if !v.v.IsValid() {
 		return errors.New("zero PVStructure is not usable")
 	}
-	// If the struct's bit itself is set, all the fields must be serialized
-	// TODO: What about fields of child structs? Is this recursive?
-	fullStruct := !s.useChangedBitSet || s.changedBitSet.Get(s.changedBitSetIndex)
+	// If the struct's bit itself is set, all the fields must be serialized, including those of child structs.
+	fullStruct := !s.useChangedBitSet || s.changedParent || s.changedBitSet.Get(s.changedBitSetIndex)
+	if s.useChangedBitSet {
+		defer func(old bool) { s.changedParent = old }(s.changedParent)
+		s.changedParent = fullStruct
+	}
 	t := v.v.Type()
 	for i := 0; i < v.v.NumField(); i++ {
+		if vf := v.v.Field(i); isAbsent(vf) {
+			// Fields are decoded by position, so an optional field is always present in the data.
+			vf.Set(reflect.New(vf.Type().Elem()))
+		}
 		item := v.v.Field(i).Addr()
 		_, tags := parseTag(t.Field(i).Tag.Get("pvaccess"))
 		if s.useChangedBitSet {
@@ -634,12 +801,11 @@ func (v PVStructure) FieldDesc() (FieldDesc, error) {
 	var fields []StructFieldDesc
 	t := v.v.Type()
 	for i := 0; i < v.v.NumField(); i++ {
-		name, tags := parseTag(t.Field(i).Tag.Get("pvaccess"))
+		name, _ := parseTag(t.Field(i).Tag.Get("pvaccess"))
 		if name == "" {
 			name = t.Field(i).Name
 		}
-		vf := v.v.Field(i)
-		if tags["omitifnil"] != "" && vf.Kind() == reflect.Ptr && (!vf.IsValid() || vf.IsNil()) {
+		if isAbsent(v.v.Field(i)) {
 			continue
 		}
 		f, err := valueToField(v.v.Field(i))
@@ -676,7 +842,10 @@ func (v PVStructure) SubField(name ...string) PVField {
 	field := v.Field(name[0])
 	if field != nil {
 		if len(name) > 1 {
-			if s, ok := field.(*PVStructure); ok {
+			switch s := field.(type) {
+			case PVStructure:
+				return s.SubField(name[1:]...)
+			case *PVStructure:
 				return s.SubField(name[1:]...)
 			}
 		} else {
@@ -686,7 +855,12 @@ func (v PVStructure) SubField(name ...string) PVField {
 	return nil
 }
 
+// PVStructureDiff is a structure preceded by a BitSet of the fields that are present.
+// Bit 0 is the whole structure, and the following bits number its fields depth-first, so that "value.x" follows "value".
+// A structure's bit stands for all of its fields.
 type PVStructureDiff struct {
+	// ChangedBitSet selects the fields that are encoded. If it is empty, every field is encoded.
+	// It is set to the fields that were present when decoding.
 	ChangedBitSet PVBitSet
 	Value         interface{}
 }
@@ -695,10 +869,22 @@ func (v PVStructureDiff) PVEncode(s *EncoderState) error {
 	var buf bytes.Buffer
 	if err := func() error {
 		defer s.PushWriter(&buf)()
-		s.changedBitSet = PVBitSet{Present: []bool{false}}
+		defer func(old bool) { s.buffering = old }(s.buffering)
+		s.buffering = true
+		if len(v.ChangedBitSet.Present) > 0 {
+			s.changedBitSet = v.ChangedBitSet
+			s.onlyChanged = true
+			s.changedBitSetIndex = 0
+			s.changedParent = false
+			defer func() { s.onlyChanged = false }()
+		} else {
+			s.changedBitSet = PVBitSet{Present: []bool{false}}
+		}
 		s.useChangedBitSet = true
 		return Encode(s, v.Value)
-	}(); err != nil {
+	}(); errors.Is(err, errStreamBuffered) {
+		return v.encodeStreaming(s)
+	} else if err != nil {
 		return err
 	}
 	v.ChangedBitSet = s.changedBitSet
@@ -893,6 +1079,27 @@ type PVStatus struct {
 	CallTree PVString
 }
 
+// OK returns an OK status, which is encoded as a single byte.
+func OK() PVStatus {
+	return PVStatus{}
+}
+
+// Warning returns a WARNING status with message. Clients treat an operation that reports a warning as successful.
+// A warning returned as an error still fails the operation, so servers report it as an ERROR status.
+func Warning(message string) PVStatus {
+	return PVStatus{Type: PVStatus_WARNING, Message: PVString(message)}
+}
+
+// Error returns an ERROR status with message.
+func Error(message string) PVStatus {
+	return PVStatus{Type: PVStatus_ERROR, Message: PVString(message)}
+}
+
+// Fatal returns a FATAL status with message.
+func Fatal(message string) PVStatus {
+	return PVStatus{Type: PVStatus_FATAL, Message: PVString(message)}
+}
+
 func (v *PVStatus) PVEncode(s *EncoderState) error {
 	if v.Type == PVStatus_OK && len(v.Message) == 0 && len(v.CallTree) == 0 {
 		return s.Buf.WriteByte(0xFF)
@@ -1019,7 +1226,8 @@ func (f *FieldDesc) PVEncode(s *EncoderState) error {
 	if f.TypeCode == UNION_ARRAY {
 		s.Buf.WriteByte(UNION)
 	}
-	if f.TypeCode&STRUCT == STRUCT || f.TypeCode&UNION == UNION {
+	switch f.TypeCode {
+	case STRUCT, UNION, STRUCT_ARRAY, UNION_ARRAY:
 		if err := Encode(s, &f.StructType, f.Fields); err != nil {
 			return err
 		}
@@ -1083,12 +1291,49 @@ func (f *FieldDesc) PVDecode(s *DecoderState) error {
 	return nil
 }
 
+// Zero returns a new zero value that data described by f can be decoded into.
+// Structures are returned as a PVStructure whose fields can be accessed with Field and SubField.
+func (f FieldDesc) Zero() (PVField, error) {
+	return f.createZero()
+}
+
 func (f FieldDesc) createZero() (PVField, error) {
+	return f.zero("", zeroOptions{})
+}
+
+// zeroOptions changes the values that zero creates for some fields.
+type zeroOptions struct {
+	// sinks replaces the fields of structures whose paths are its keys.
+	sinks map[string]*ArraySink
+	// sharedBytes creates byte and ubyte arrays as SharedBytes.
+	sharedBytes bool
+}
+
+// zero creates a zero value of the field at path, as changed by opts.
+func (f FieldDesc) zero(path string, opts zeroOptions) (PVField, error) {
 	switch f.TypeCode {
 	case NULL_TYPE_CODE:
 		return nil, nil
 	}
-	// TODO: Create arrays
+	if opts.sharedBytes && (f.TypeCode == BYTE|VARIABLE_ARRAY || f.TypeCode == UBYTE|VARIABLE_ARRAY) {
+		return &SharedBytes{Signed: f.TypeCode == BYTE|VARIABLE_ARRAY}, nil
+	}
+	if f.TypeCode&ARRAY_BITS != 0 && f.TypeCode&STRUCT == 0 {
+		elem := f
+		elem.TypeCode &^= ARRAY_BITS
+		prototype, err := elem.createZero()
+		if err != nil {
+			return nil, err
+		}
+		t := reflect.TypeOf(prototype)
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if f.TypeCode&ARRAY_BITS == FIXED_ARRAY {
+			return PVArray{fixed: true, v: reflect.New(reflect.ArrayOf(int(f.Size), t)).Elem()}, nil
+		}
+		return PVArray{v: reflect.New(reflect.SliceOf(t)).Elem()}, nil
+	}
 	if f.TypeCode&ARRAY_BITS == 0 {
 		var prototype interface{}
 		switch f.TypeCode {
@@ -1128,20 +1373,31 @@ func (f FieldDesc) createZero() (PVField, error) {
 		if f.StructType != "" {
 			for _, t := range ntTypes {
 				if string(f.StructType) == t.TypeID() {
-					val := reflect.New(reflect.TypeOf(t)).Elem()
-					return PVStructure{ID: string(f.StructType), v: val}, nil
+					val := reflect.New(reflect.TypeOf(t))
+					if pvf, ok := val.Interface().(PVField); ok {
+						// Types like Time have their own encoding.
+						return pvf, nil
+					}
+					return PVStructure{ID: string(f.StructType), v: val.Elem()}, nil
 				}
 			}
 		}
 		// TODO: Support other NT types specially?
 		var fields []reflect.StructField
-		var zeros []PVField
+		var zeros []reflect.Value
 		for _, field := range f.Fields {
-			prototype, err := field.Field.createZero()
-			if err != nil {
-				return nil, err
+			var prototype PVField
+			if sink, ok := opts.sinks[joinPath(path, field.Name)]; ok {
+				if err := sink.describe(field.Field); err != nil {
+					return nil, fmt.Errorf("field %s: %w", joinPath(path, field.Name), err)
+				}
+				prototype = sink
+			} else {
+				var err error
+				if prototype, err = field.Field.zero(joinPath(path, field.Name), opts); err != nil {
+					return nil, err
+				}
 			}
-			zeros = append(zeros, prototype)
 			name := field.Name
 			if len(name) > 0 {
 				name = strings.ToUpper(name[0:1]) + name[1:]
@@ -1149,23 +1405,24 @@ func (f FieldDesc) createZero() (PVField, error) {
 			if name[0] == '_' {
 				name = "X" + name
 			}
-			t := reflect.TypeOf(prototype)
-			if t.Kind() == reflect.Ptr {
-				t = t.Elem()
+			zero := reflect.ValueOf(prototype)
+			if zero.Kind() == reflect.Ptr {
+				zero = zero.Elem()
+			}
+			if a, ok := prototype.(PVArray); ok {
+				// Store arrays as Go slices and arrays so their fields can be used directly.
+				zero = a.v
 			}
+			zeros = append(zeros, zero)
 			fields = append(fields, reflect.StructField{
 				Name: name,
-				Type: t,
+				Type: zero.Type(),
 				Tag:  reflect.StructTag("pvaccess:\"" + field.Name + "\""),
 			})
 		}
 		val := reflect.New(reflect.StructOf(fields))
 		for i, zero := range zeros {
-			v := reflect.ValueOf(zero)
-			if v.Kind() == reflect.Ptr {
-				v = v.Elem()
-			}
-			val.Elem().Field(i).Set(v)
+			val.Elem().Field(i).Set(zero)
 		}
 		pvs := PVStructure{ID: string(f.StructType), v: val.Elem()}
 		return pvs, nil
@@ -1181,16 +1438,48 @@ func BoolValue(x interface{}) (bool, bool) {
 	return false, false
 }
 
+// FloatValue returns the value of any integer or floating point number, or a pointer to one.
+func FloatValue(x interface{}) (float64, bool) {
+	v := reflect.ValueOf(x)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	}
+	return 0, false
+}
+
+// IntValue returns the value of any integer or boolean, or a pointer to one.
+// It returns false for values that don't fit in an int, such as a ulong counter above math.MaxInt64.
 func IntValue(x interface{}) (int, bool) {
+	i, ok := Int64Value(x)
+	if !ok || int64(int(i)) != i {
+		return 0, false
+	}
+	return int(i), true
+}
+
+// Int64Value returns the value of any integer or boolean, or a pointer to one.
+// It returns false for unsigned values above math.MaxInt64, which would otherwise become negative.
+func Int64Value(x interface{}) (int64, bool) {
 	v := reflect.ValueOf(x)
 	if v.Kind() == reflect.Ptr {
 		v = v.Elem()
 	}
 	switch v.Kind() {
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		return int(v.Int()), true
+		return v.Int(), true
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		return int(v.Uint()), true
+		if v.Uint() > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(v.Uint()), true
 	case reflect.Bool:
 		if v.Bool() {
 			return 1, true
@@ -1199,3 +1488,21 @@ func IntValue(x interface{}) (int, bool) {
 	}
 	return 0, false
 }
+
+// Uint64Value returns the value of any integer or boolean, or a pointer to one, as an unsigned integer.
+// It returns false for negative values.
+func Uint64Value(x interface{}) (uint64, bool) {
+	v := reflect.ValueOf(x)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint(), true
+	}
+	i, ok := Int64Value(x)
+	if !ok || i < 0 {
+		return 0, false
+	}
+	return uint64(i), true
+}
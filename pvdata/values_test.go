@@ -68,6 +68,11 @@ func TestRoundTrip(t *testing.T) {
 		{int64(1), []byte{0, 0, 0, 0, 0, 0, 0, 1}, []byte{}},
 		{PVULong(0x8000000000000000), []byte{0x80, 0, 0, 0, 0, 0, 0, 0}, []byte{}},
 		{uint64(13), []byte{0, 0, 0, 0, 0, 0, 0, 13}, []byte{}},
+		{int(-2), []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xfe}, []byte{}},
+		{uint(0xffffffffffffffff), []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, nil},
+		{[]uint16{1, 0xffff}, []byte{2, 0, 1, 0xff, 0xff}, []byte{2, 1, 0, 0xff, 0xff}},
+		{[]PVULong{0xffffffffffffffff}, []byte{1, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, nil},
+		{[]uint{1}, []byte{1, 0, 0, 0, 0, 0, 0, 0, 1}, []byte{1, 1, 0, 0, 0, 0, 0, 0, 0}},
 		{float32(85.125), []byte{0x42, 0xAA, 0x40, 0x00}, []byte{}},
 		{float64(85.125), []byte{0x40, 0x55, 0x48, 0, 0, 0, 0, 0}, []byte{}},
 		{[]PVBoolean{true, false, false}, []byte{3, 1, 0, 0}, nil},
@@ -227,3 +232,116 @@ func TestStructureBitSetEncode(t *testing.T) {
 	}
 	// TODO: Encode again with testStruct2 and check that diff is computed correctly.
 }
+
+type benchStruct struct {
+	Value    PVDouble   `pvaccess:"value"`
+	Waveform []PVDouble `pvaccess:"waveform"`
+	Alarm    Alarm      `pvaccess:"alarm"`
+	Name     string     `pvaccess:"name"`
+}
+
+func newBenchStruct() *benchStruct {
+	v := &benchStruct{Value: 3.5, Name: "bench:channel", Waveform: make([]PVDouble, 1024)}
+	for i := range v.Waveform {
+		v.Waveform[i] = PVDouble(i)
+	}
+	return v
+}
+
+func BenchmarkEncode(b *testing.B) {
+	v := newBenchStruct()
+	var buf bytes.Buffer
+	s := &EncoderState{Buf: &buf, ByteOrder: binary.LittleEndian}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		if err := Encode(s, v); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.SetBytes(int64(buf.Len()))
+}
+
+func BenchmarkDecode(b *testing.B) {
+	var buf bytes.Buffer
+	if err := Encode(&EncoderState{Buf: &buf, ByteOrder: binary.LittleEndian}, newBenchStruct()); err != nil {
+		b.Fatal(err)
+	}
+	data := buf.Bytes()
+	r := bytes.NewReader(data)
+	s := &DecoderState{Buf: r, ByteOrder: binary.LittleEndian}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r.Reset(data)
+		var v benchStruct
+		if err := Decode(s, &v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFieldDesc(b *testing.B) {
+	pvs, err := NewPVStructure(newBenchStruct())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := pvs.FieldDesc(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestSubField(t *testing.T) {
+	v := struct {
+		Record struct {
+			Options struct {
+				Pipeline PVBoolean `pvaccess:"pipeline"`
+			} `pvaccess:"_options"`
+		} `pvaccess:"record"`
+	}{}
+	v.Record.Options.Pipeline = true
+	pvs, err := NewPVStructure(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := BoolValue(pvs.SubField("record", "_options", "pipeline")); !ok || !got {
+		t.Errorf("SubField(record._options.pipeline) = %v, %v; want true", got, ok)
+	}
+}
+
+func TestIntValue(t *testing.T) {
+	for _, test := range []struct {
+		in      interface{}
+		i64     int64
+		i64OK   bool
+		u64     uint64
+		u64OK   bool
+		intOK   bool
+		float   float64
+		floatOK bool
+	}{
+		{PVByte(-1), -1, true, 0, false, true, -1, true},
+		{PVUByte(255), 255, true, 255, true, true, 255, true},
+		{uint32(0xffffffff), 0xffffffff, true, 0xffffffff, true, true, 0xffffffff, true},
+		{PVULong(1 << 63), 0, false, 1 << 63, true, false, 1 << 63, true},
+		{PVLong(-1 << 63), -1 << 63, true, 0, false, true, -1 << 63, true},
+		{true, 1, true, 1, true, true, 0, false},
+		{"1", 0, false, 0, false, false, 0, false},
+	} {
+		if i, ok := Int64Value(test.in); i != test.i64 || ok != test.i64OK {
+			t.Errorf("Int64Value(%T(%v)) = %d, %v, want %d, %v", test.in, test.in, i, ok, test.i64, test.i64OK)
+		}
+		if u, ok := Uint64Value(test.in); u != test.u64 || ok != test.u64OK {
+			t.Errorf("Uint64Value(%T(%v)) = %d, %v, want %d, %v", test.in, test.in, u, ok, test.u64, test.u64OK)
+		}
+		if _, ok := IntValue(test.in); ok != test.intOK {
+			t.Errorf("IntValue(%T(%v)) ok = %v, want %v", test.in, test.in, ok, test.intOK)
+		}
+		if f, ok := FloatValue(test.in); f != test.float || ok != test.floatOK {
+			t.Errorf("FloatValue(%T(%v)) = %v, %v, want %v, %v", test.in, test.in, f, ok, test.float, test.floatOK)
+		}
+	}
+}
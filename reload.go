@@ -0,0 +1,54 @@
+package pvaccess
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
+)
+
+// Reload reloads the configuration of every channel provider that implements Reloadable.
+// Each provider swaps in its new channels at once; channels already created by clients are not disconnected.
+// Every provider is reloaded even if some fail, and the first error is returned.
+func (srv *Server) Reload(ctx context.Context) error {
+	ctx = srv.withValues(ctx)
+	var firstErr error
+	for _, provider := range srv.ChannelProviders() {
+		r, ok := provider.(Reloadable)
+		if !ok {
+			continue
+		}
+		if err := r.Reload(ctx); err != nil {
+			ctxlog.L(ctx).Errorf("reloading %T: %v", provider, err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("reloading %T: %w", provider, err)
+			}
+		}
+	}
+	return firstErr
+}
+
+// ReloadOnSignal calls Reload whenever the process receives one of sigs (by default SIGHUP), until ctx is cancelled.
+// Errors are logged, and the failing providers keep their previous configuration.
+func (srv *Server) ReloadOnSignal(ctx context.Context, sigs ...os.Signal) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGHUP}
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+	defer signal.Stop(ch)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-ch:
+			ctxlog.L(ctx).Infof("received signal %s; reloading configuration", sig)
+			if err := srv.Reload(ctx); err == nil {
+				ctxlog.L(ctx).Infof("configuration reloaded")
+			}
+		}
+	}
+}
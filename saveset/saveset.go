@@ -0,0 +1,240 @@
+// Package saveset captures the values of a list of PVs in a snapshot, saves snapshots as JSON,
+// and restores them, like the MASAR service.
+//
+// Capture connects to every PV at once with client.ConnectAll and then gets their values together,
+// so that a snapshot is close to, but not exactly, a single moment. Restore puts each PV's saved value
+// and reports the outcome for each, since PVs that fail leave the others restored.
+package saveset
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/client"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+// Snapshot holds the values of PVs at a moment.
+type Snapshot struct {
+	// Time is when the values were requested.
+	Time    time.Time `json:"time"`
+	Comment string    `json:"comment,omitempty"`
+	PVs     []PV      `json:"pvs"`
+}
+
+// PV is a PV's value in a snapshot.
+type PV struct {
+	Name string `json:"name"`
+	// Value is the PV's whole value, in the form of pvdata.ToMap, unless Error is set.
+	Value map[string]interface{} `json:"value,omitempty"`
+	// Error is why the PV's value couldn't be captured.
+	Error string `json:"error,omitempty"`
+}
+
+// Result is the outcome of restoring one of the PVs of a snapshot.
+type Result struct {
+	Name string
+	// Err is why the PV couldn't be restored, or nil if it was.
+	Err error
+}
+
+// ErrNotCaptured is reported when restoring a PV whose value couldn't be captured.
+var ErrNotCaptured = errors.New("value was not captured")
+
+// Capture returns a snapshot of the PVs with the given names, in the same order. PVs that can't be
+// connected to or read have an Error instead of a Value; only a ctx that is done makes Capture fail.
+func Capture(ctx context.Context, c *client.Client, names []string) (*Snapshot, error) {
+	s := &Snapshot{Time: time.Now(), PVs: make([]PV, len(names))}
+	index := make(map[string][]int, len(names))
+	for i, name := range names {
+		s.PVs[i].Name = name
+		index[name] = append(index[name], i)
+	}
+	unique := make([]string, 0, len(index))
+	for name := range index {
+		unique = append(unique, name)
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	set := func(name string, value map[string]interface{}, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, i := range index[name] {
+			if err != nil {
+				s.PVs[i].Error = err.Error()
+			} else {
+				s.PVs[i].Value = value
+			}
+		}
+	}
+	for r := range c.ConnectAll(ctx, unique) {
+		if r.Err != nil {
+			set(r.Name, nil, r.Err)
+			continue
+		}
+		wg.Add(1)
+		go func(r client.ConnectResult) {
+			defer wg.Done()
+			defer r.Channel.Close()
+			v, err := r.Channel.Get(ctx)
+			if err != nil {
+				set(r.Name, nil, err)
+				return
+			}
+			set(r.Name, v.ToMap(), nil)
+		}(r)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Restore puts the saved values of s's PVs, and returns the outcome for each, in the same order.
+// Only the named fields of each value are put; nil fields selects "value", leaving fields such as
+// the alarm and time stamp to the server.
+func (s *Snapshot) Restore(ctx context.Context, c *client.Client, fields ...string) []Result {
+	if fields == nil {
+		fields = []string{"value"}
+	}
+	results := make([]Result, len(s.PVs))
+	names := make([]string, 0, len(s.PVs))
+	index := make(map[string][]int, len(s.PVs))
+	for i, pv := range s.PVs {
+		results[i].Name = pv.Name
+		if pv.Error != "" || pv.Value == nil {
+			results[i].Err = ErrNotCaptured
+			continue
+		}
+		if _, ok := index[pv.Name]; !ok {
+			names = append(names, pv.Name)
+		}
+		index[pv.Name] = append(index[pv.Name], i)
+	}
+	var wg sync.WaitGroup
+	for r := range c.ConnectAll(ctx, names) {
+		if r.Err != nil {
+			for _, i := range index[r.Name] {
+				results[i].Err = r.Err
+			}
+			continue
+		}
+		wg.Add(1)
+		go func(r client.ConnectResult) {
+			defer wg.Done()
+			defer r.Channel.Close()
+			for _, i := range index[r.Name] {
+				results[i].Err = restore(ctx, r.Channel, s.PVs[i].Value, fields)
+			}
+		}(r)
+	}
+	wg.Wait()
+	return results
+}
+
+// restore puts the named fields of value to ch.
+func restore(ctx context.Context, ch *client.Channel, value map[string]interface{}, fields []string) error {
+	put := make(map[string]interface{})
+	var present []string
+	for _, f := range fields {
+		if copyField(put, value, strings.Split(f, ".")) {
+			present = append(present, f)
+		}
+	}
+	if len(present) == 0 {
+		return fmt.Errorf("saved value has none of the fields %v", fields)
+	}
+	pvs, err := pvdata.NewPVStructureFromMap("", put)
+	if err != nil {
+		return fmt.Errorf("saved value: %w", err)
+	}
+	return ch.PutFields(ctx, pvs, present)
+}
+
+// copyField copies the field at path in src to dst, creating the structures that hold it, and reports whether src has it.
+func copyField(dst, src map[string]interface{}, path []string) bool {
+	x, ok := src[path[0]]
+	if !ok {
+		return false
+	}
+	if len(path) == 1 {
+		dst[path[0]] = x
+		return true
+	}
+	sub, ok := x.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	d, _ := dst[path[0]].(map[string]interface{})
+	if d == nil {
+		d = make(map[string]interface{})
+	}
+	if !copyField(d, sub, path[1:]) {
+		return false
+	}
+	dst[path[0]] = d
+	return true
+}
+
+// Write writes s to w as JSON. Values that JSON can't represent, such as a NaN, make it fail.
+func (s *Snapshot) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+// Read reads a snapshot written by Write. Numbers in values are read as int64 if they are integers,
+// and as float64 otherwise, except that arrays with any non-integer numbers are read as []float64.
+func Read(r io.Reader) (*Snapshot, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	var s Snapshot
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("reading snapshot: %w", err)
+	}
+	for i := range s.PVs {
+		for k, v := range s.PVs[i].Value {
+			s.PVs[i].Value[k] = numbers(v)
+		}
+	}
+	return &s, nil
+}
+
+// numbers replaces the json.Numbers in x with int64s or float64s.
+func numbers(x interface{}) interface{} {
+	switch x := x.(type) {
+	case json.Number:
+		if i, err := strconv.ParseInt(string(x), 10, 64); err == nil {
+			return i
+		}
+		f, _ := x.Float64()
+		return f
+	case map[string]interface{}:
+		for k, v := range x {
+			x[k] = numbers(v)
+		}
+	case []interface{}:
+		float := false
+		for i, v := range x {
+			x[i] = numbers(v)
+			_, isFloat := x[i].(float64)
+			float = float || isFloat
+		}
+		if float {
+			for i, v := range x {
+				if n, ok := v.(int64); ok {
+					x[i] = float64(n)
+				}
+			}
+		}
+	}
+	return x
+}
@@ -0,0 +1,99 @@
+package saveset
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	pvaccess "github.com/Lexcelon/go-pvaccess"
+	"github.com/Lexcelon/go-pvaccess/client"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/google/go-cmp/cmp"
+)
+
+// serve runs srv on a random port until the test ends.
+func serve(t *testing.T, srv *pvaccess.Server) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.Serve(ctx, ln)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return ln.Addr().String()
+}
+
+func TestSnapshot(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	a := pvaccess.NewSimpleChannel("dev:a")
+	x := pvdata.PVLong(1 << 60)
+	a.Set(&x)
+	b := pvaccess.NewSimpleChannel("dev:b")
+	arr := []float64{1.5, 2}
+	b.Set(&arr)
+	srv := &pvaccess.Server{DisableSearch: true}
+	srv.AddChannelProvider(a)
+	srv.AddChannelProvider(b)
+	c := client.New(serve(t, srv))
+	defer c.Close()
+
+	s, err := Capture(ctx, c, []string{"dev:a", "dev:missing", "dev:b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.PVs[1].Error == "" || s.PVs[0].Error != "" || s.PVs[2].Error != "" {
+		t.Fatalf("captured %+v, want an error for dev:missing only", s.PVs)
+	}
+	var buf bytes.Buffer
+	if err := s.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := saved.PVs[0].Value["value"]; got != int64(1<<60) {
+		t.Errorf("read value %v (%T), want %d", got, got, int64(1<<60))
+	}
+
+	y := pvdata.PVLong(2)
+	a.Set(&y)
+	arr2 := []float64{3}
+	b.Set(&arr2)
+	results := saved.Restore(ctx, c)
+	if len(results) != 3 || results[1].Err != ErrNotCaptured {
+		t.Errorf("Restore = %+v, want dev:missing to be %v", results, ErrNotCaptured)
+	}
+	for _, i := range []int{0, 2} {
+		if results[i].Err != nil {
+			t.Errorf("restoring %s: %v", results[i].Name, results[i].Err)
+		}
+	}
+	if v, ok := pvdata.Int64Value(a.Get()); !ok || v != 1<<60 {
+		t.Errorf("dev:a = %v after Restore, want %d", a.Get(), int64(1<<60))
+	}
+	restored, err := Capture(ctx, c, []string{"dev:b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]float64{1.5, 2}, restored.PVs[0].Value["value"]); diff != "" {
+		t.Errorf("dev:b differs after Restore (-want +got):\n%s", diff)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	if _, err := Read(bytes.NewBufferString(`{"pvs": [`)); err == nil {
+		t.Error("reading a truncated snapshot succeeded")
+	}
+}
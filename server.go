@@ -1,45 +1,251 @@
 package pvaccess
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/Lexcelon/go-pvaccess/clock"
 	"github.com/Lexcelon/go-pvaccess/internal/connection"
 	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
-	"github.com/Lexcelon/go-pvaccess/internal/proto"
 	"github.com/Lexcelon/go-pvaccess/internal/search"
 	"github.com/Lexcelon/go-pvaccess/internal/server/monitor"
 	"github.com/Lexcelon/go-pvaccess/internal/server/status"
+	"github.com/Lexcelon/go-pvaccess/proto"
 	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/Lexcelon/go-pvaccess/types"
 	"golang.org/x/sync/errgroup"
 )
 
 type Server struct {
 	DisableSearch bool
+	// HeartbeatInterval is how often each client connection is pinged to measure its round-trip time.
+	// Zero selects a default of 15 seconds; a negative interval disables heartbeats.
+	HeartbeatInterval time.Duration
+	// Clock measures the intervals between beacons and heartbeats, the DrainTimeout, how long requests have been running
+	// or monitors idle, and the intervals between monitor updates.
+	// Nil selects the system clock; tests can set a *clock.Fake.
+	Clock clock.Clock
+	// DrainTimeout is how long operations in progress may run once ServeListeners' context is cancelled.
+	// When the server shuts down, it sends a final beacon with a new GUID, refuses new channels, and waits for operations
+	// in progress to finish before destroying every client's channels and closing the connections, so that clients
+	// search for the channels again at once instead of waiting for their connections to time out.
+	// Zero selects a default of 5 seconds; a negative timeout closes the connections without notifying clients.
+	DrainTimeout time.Duration
+	// DebugStatus includes the chain of wrapped errors behind each failure in the call tree of the status sent to the client.
+	// It can reveal details of the server's implementation, so it should only be enabled while debugging.
+	DebugStatus bool
+	// EchoOperationIDs appends the ID of the failed operation to the message of error statuses sent to clients,
+	// e.g. "channel not found [op 1a2b3c4d-42]", so that a client's error report can be found in the server's logs.
+	// Operation IDs are always included in the server's log messages.
+	EchoOperationIDs bool
+	// StuckRequestThreshold is how long a Get, Put, or RPC may run before the server logs a warning about it,
+	// so that stuck channel implementations don't go unnoticed. Zero disables the warnings.
+	// Operations in progress are reported in Connections regardless.
+	StuckRequestThreshold time.Duration
+	// CancelStuckRequests cancels operations that run longer than StuckRequestThreshold
+	// and reports the failure to the client.
+	CancelStuckRequests bool
+	// SlowOpThreshold, if non-zero, logs a warning with the channel and client of each operation that takes longer,
+	// once it completes. Durations of every operation are reported by OpStats regardless.
+	SlowOpThreshold time.Duration
+	// MonitorFlushInterval is the minimum time between updates sent for each monitor.
+	// Values produced by a channel within the interval are coalesced, and only the latest is sent,
+	// cutting the number of messages sent for high-rate channels. Zero sends every value as soon as possible.
+	MonitorFlushInterval time.Duration
+	// MonitorDeadTime is how long after sending an update for a monitor the channel's new values are discarded,
+	// rather than coalesced as with MonitorFlushInterval. Zero disables the dead time.
+	// Clients can ask for a longer flush interval or dead time with the record options "rate" (updates per second)
+	// and "deadTime" (in seconds) of their pvRequests, but not for a shorter one.
+	MonitorDeadTime time.Duration
+	// MonitorIdleTimeout, if positive, expires monitors that have sent no updates and received no START, STOP, or ACK
+	// from their client for that long, telling the client that the monitor has been destroyed, so that monitors aren't
+	// kept running for clients, such as GUIs, that vanish without closing their connections cleanly.
+	// Monitors of channels that change less often than the timeout expire too, unless their clients acknowledge them.
+	// Zero never expires monitors.
+	MonitorIdleTimeout time.Duration
+	// ShareMonitors subscribes to each channel only once for all the clients monitoring it with equivalent pvRequests,
+	// fanning the updates out to each client, which has its own queue. The provider's Nexter is created with the
+	// context of the first client to subscribe, without its cancellation, and is shared until the last client unsubscribes;
+	// it must not depend on which client is asking.
+	ShareMonitors bool
+	// Compressor, if non-nil, compresses messages to clients that offer compression with the same algorithm,
+	// such as go-pvaccess clients with the same Compressor. Other clients are unaffected.
+	// Only messages with payloads larger than CompressionThreshold bytes (default 1024) are compressed.
+	Compressor           Compressor
+	CompressionThreshold int
+	// Serializer, if non-nil, encodes the payloads of messages instead of the pvData binary encoding, for
+	// experimental transports such as JSON while debugging. This is a go-pvaccess extension: only clients with the
+	// same Serializer, such as go-pvaccess clients, can connect, and searches and beacons are unaffected.
+	Serializer pvdata.Serializer
+	// SegmentSize, if positive, splits messages with payloads larger than SegmentSize bytes into segmented messages.
+	// Each segment is sent as soon as it has been encoded, so that values with pvdata.ArrayStream fields are
+	// streamed to clients without being held in memory whole. Segmented messages are never compressed.
+	// Zero sends every message whole.
+	SegmentSize int
+	// ConnectionBufferLimit, if positive, is how many bytes of responses and monitor updates the server may hold
+	// waiting to be sent to one client before it sheds load, so that a slow client can't exhaust the server's memory.
+	// While a connection is at its limit, new operations are rejected, and monitor updates are deferred and counted
+	// as overruns; the latest value is sent once the client has caught up. Messages are encoded before they
+	// are counted, so values with pvdata.ArrayStream fields are held in memory whole.
+	// Zero leaves connections unlimited.
+	ConnectionBufferLimit int
+	// MaxWorkers, if positive, is the most Get, Put, and RPC executions that the server runs at once across every
+	// connection, and MaxConnectionWorkers the most that it runs at once for each connection, so that a burst of
+	// expensive requests can't start thousands of goroutines. Executions beyond a limit wait in a queue of up to
+	// WorkerQueueLimit executions, which is unlimited if zero and holds none if negative; once it is full, new
+	// executions are rejected with an error status. INITs and monitors aren't limited.
+	// Zero limits run every execution on its own goroutine.
+	MaxWorkers           int
+	MaxConnectionWorkers int
+	WorkerQueueLimit     int
+	// Strict enforces the behavior the protocol requires of clients, to help validate third-party implementations:
+	// clients must validate their connection only once, must send known commands,
+	// and must send headers with a supported version, the client's direction flag, and no reserved flags set.
+	// A client that breaks the protocol is logged with a diagnostic and disconnected.
+	// Without Strict, such clients are tolerated as far as possible.
+	// A client that sends anything but a connection validation before validating its connection is always disconnected.
+	Strict bool
+	// MetricsPrefix, if set, serves the server's own metrics as PVs whose names start with the prefix,
+	// so that standard EPICS tools can monitor the server: e.g. with the prefix "SRV:", SRV:connCount is the number of
+	// client connections. The metrics are connCount, channelCount, requestCount, monQueueMax, monWaiting, and rttMax.
+	MetricsPrefix string
+	// DiagnosticsAllowed reports whether the client in ctx (see PeerFromContext) may use the diagnostic ops of the
+	// "server" channel served by servers created with NewServer, which reveal the server's internals.
+	// The client also needs write access to the channel. Nil refuses the ops to every client.
+	DiagnosticsAllowed func(ctx context.Context) bool
+	// HealthAddr, if set, is the address on which ServeListeners serves HTTP readiness and liveness probes
+	// (see HealthHandler), e.g. ":8080" for a Kubernetes pod.
+	HealthAddr string
+	// ServerAddressOverride, if non-nil, is the address advertised in beacons and search responses instead of the listener's,
+	// for servers behind NAT. A nil IP or zero port keeps the listener's IP or port.
+	// Without an override, a listener on all interfaces is advertised as 0.0.0.0, which tells clients to use the
+	// source address of the response.
+	ServerAddressOverride *net.TCPAddr
+	// UDPConn, if non-nil, is the socket that beacons and search responses are sent from, instead of one that
+	// ServeListeners opens on a random port. The server never reads from it or closes it, so it can be shared
+	// with a client in the same process, as by node.Context.
+	UDPConn *net.UDPConn
 
-	search *search.Server
-	ln     net.Listener
+	mu                sync.RWMutex
+	search            *search.Server
+	guid              [12]byte
+	channelProviders  []ChannelProvider
+	interceptors      []Interceptor
+	validators        []Validator
+	accessControllers []AccessController
+	conns             map[*serverConn]struct{}
+	// ready is closed once the server is accepting connections; listening holds the addresses it accepts them on.
+	ready     chan struct{}
+	listening []net.Addr
+	stopped   bool
+	// draining is set once the server has started shutting down, and it no longer creates channels.
+	draining bool
 
-	mu               sync.RWMutex
-	channelProviders []ChannelProvider
+	channelUsers channelUsers
+	opIDs        operationIDs
+	opStats      opStatsRecorder
+	// workers runs the executions of every connection if MaxWorkers is set; otherwise it is nil.
+	workers *workerPool
+	// updates orders monitor updates across connections by priority.
+	updates monitor.Scheduler
+	// monitors holds the subscriptions shared by clients, if ShareMonitors is set.
+	monitors monitor.Fanout
+	events   eventQueue
+	// valuesMu protects values, the values set with SetValue. It is separate from mu, since contexts
+	// look up values while mu may be held.
+	valuesMu sync.RWMutex
+	values   map[interface{}]interface{}
 }
 
+// Listener is a network listener served by a Server, along with the policy for connections accepted on it.
+type Listener struct {
+	net.Listener
+	// AuthNZ lists the authentication methods offered to clients on this listener.
+	// If empty, only "anonymous" is offered.
+	AuthNZ []string
+	// Authorize, if non-nil, is called once a client has validated its connection.
+	// Returning an error rejects the connection.
+	Authorize func(ctx context.Context, peer *Peer) error
+}
+
+type Peer = types.Peer
+
+// PeerFromContext returns the client that initiated the operation running in ctx.
+var PeerFromContext = types.PeerFromContext
+
 const udpAddr = ":5076"
 
 // TODO: Use this port if it's available.
 const tcpAddr = ":5075"
 
+// NewServer returns a Server with a random GUID, serving the "server" channel.
+//
+// The server channel's RPC service lists the server's channels and performs group puts (see GroupPut).
+// Clients that DiagnosticsAllowed accepts, and that have write access to it (see AddAccessController), can also use its
+// diagnostic ops: "runtime" returns runtime statistics, "goroutines" dumps every goroutine's stack, "heap" returns a
+// heap profile for go tool pprof, and "debug" turns debug logging on or off according to its "enable" argument.
+// By default, no client can.
 func NewServer() (*Server, error) {
 	s := &Server{}
-	s.channelProviders = []ChannelProvider{&status.Channel{s}}
+	if _, err := rand.Read(s.guid[:]); err != nil {
+		return nil, err
+	}
+	sc := &status.Channel{Server: s}
+	sc.Privileged = func(ctx context.Context) bool {
+		return s.DiagnosticsAllowed != nil && s.DiagnosticsAllowed(ctx) && s.accessRights(ctx, sc)&AccessWrite != 0
+	}
+	s.channelProviders = []ChannelProvider{sc}
 	return s, nil
 }
 
+// GUID returns the 12-byte GUID that identifies the server in beacons and search responses.
+func (srv *Server) GUID() [12]byte {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.guidLocked()
+}
+
+// guidLocked returns the server's GUID, choosing a random one for servers that weren't created by NewServer and
+// haven't had one set. srv.mu must be held for writing.
+func (srv *Server) guidLocked() [12]byte {
+	if srv.guid == ([12]byte{}) {
+		var guid [12]byte
+		// If the system can't provide randomness, the GUID stays blank until it can.
+		if _, err := rand.Read(guid[:]); err == nil {
+			srv.guid = guid
+		}
+	}
+	return srv.guid
+}
+
+// SetGUID changes the server's GUID.
+// It must be called before Serve; by default a random GUID is chosen by NewServer, or when it is first needed.
+func (srv *Server) SetGUID(guid [12]byte) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.guid = guid
+}
+
+// GUIDFromHost derives a stable GUID from a hostname and TCP port,
+// so that a server keeps the same identity across restarts.
+func GUIDFromHost(hostname string, port int) [12]byte {
+	var guid [12]byte
+	sum := sha1.Sum([]byte(fmt.Sprintf("%s:%d", hostname, port)))
+	copy(guid[:], sum[:])
+	return guid
+}
+
 // ListenAndServe listens on a random port and then calls Serve.
 func (srv *Server) ListenAndServe(ctx context.Context) error {
 	ln, err := net.Listen("tcp", "")
@@ -51,46 +257,95 @@ func (srv *Server) ListenAndServe(ctx context.Context) error {
 
 // Serve runs a PVAccess server on l until the context is cancelled.
 func (srv *Server) Serve(ctx context.Context, l net.Listener) error {
-	srv.search = &search.Server{
-		ServerAddr: l.Addr().(*net.TCPAddr),
-		Server:     srv,
-	}
-	srv.ln = l
-	ctxlog.L(ctx).Infof("PVAccess server listening on %v", srv.ln.Addr())
+	return srv.ServeListeners(ctx, Listener{Listener: l})
+}
+
+// ServeListeners runs a PVAccess server on all of lns until the context is cancelled.
+// Channel providers are shared by all listeners.
+// Beacons and UDP search responses advertise the first TCP listener.
+func (srv *Server) ServeListeners(ctx context.Context, lns ...Listener) error {
 	var g errgroup.Group
-	g.Go(func() error {
-		<-ctx.Done()
-		ctxlog.L(ctx).Infof("PVAccess server shutting down")
-		return srv.ln.Close()
-	})
-	if !srv.DisableSearch {
+	// Connections outlive ctx while they are drained.
+	connCtx, closeConns := context.WithCancel(ctxlog.Detach(ctx))
+	defer closeConns()
+	if srv.HealthAddr != "" {
+		if err := srv.serveHealth(ctx, &g); err != nil {
+			return err
+		}
+	}
+	for _, l := range lns {
+		l := l
+		ctxlog.L(ctx).Infof("PVAccess server listening on %v", l.Addr())
+		if addr, ok := l.Addr().(*net.TCPAddr); ok {
+			if s := srv.startSearch(addr); s != nil && !srv.DisableSearch {
+				g.Go(func() error {
+					ctx := ctxlog.WithSubsystem(srv.withValues(ctx), ctxlog.Search)
+					if err := s.Serve(ctx); err != nil {
+						ctxlog.L(ctx).Errorf("failed to serve search requests: %v", err)
+						return err
+					}
+					return nil
+				})
+			}
+		}
 		g.Go(func() error {
-			if err := srv.search.Serve(ctx); err != nil {
-				ctxlog.L(ctx).Errorf("failed to serve search requests: %v", err)
-				return err
+			<-ctx.Done()
+			ctxlog.L(ctx).Infof("PVAccess server on %v shutting down", l.Addr())
+			return l.Close()
+		})
+		g.Go(func() error {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					if ne, ok := err.(net.Error); ok && ne.Temporary() {
+						time.Sleep(5 * time.Millisecond)
+						continue
+					}
+					return err
+				}
+				g.Go(func() error {
+					srv.handleConnection(connCtx, &l, conn)
+					return nil
+				})
 			}
-			return nil
 		})
 	}
 	g.Go(func() error {
-		for {
-			conn, err := srv.ln.Accept()
-			if err != nil {
-				if ne, ok := err.(net.Error); ok && ne.Temporary() {
-					time.Sleep(5 * time.Millisecond)
-					continue
-				}
-				return err
-			}
-			g.Go(func() error {
-				srv.handleConnection(ctx, conn)
-				return nil
-			})
-		}
+		<-ctx.Done()
+		srv.drain(connCtx)
+		closeConns()
+		return nil
 	})
+	srv.serving(lns)
+	defer srv.stop()
 	return g.Wait()
 }
 
+// startSearch creates the search server advertising addr, if one does not already exist.
+// It returns nil if the search server was already created.
+func (srv *Server) startSearch(addr *net.TCPAddr) *search.Server {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.search != nil {
+		return nil
+	}
+	srv.search = &search.Server{
+		GUID:            srv.guidLocked(),
+		ServerAddr:      addr,
+		AddressOverride: srv.ServerAddressOverride,
+		Conn:            srv.UDPConn,
+		Server:          srv,
+		Clock:           srv.Clock,
+	}
+	return srv.search
+}
+
+func (srv *Server) searchServer() *search.Server {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+	return srv.search
+}
+
 func (s *Server) AddChannelProvider(provider ChannelProvider) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -100,22 +355,66 @@ func (s *Server) AddChannelProvider(provider ChannelProvider) {
 func (s *Server) ChannelProviders() []ChannelProvider {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return append([]ChannelProvider{}, s.channelProviders...)
+	return s.channelProvidersLocked()
+}
+
+// channelProvidersLocked returns the server's providers, including the built-in metrics provider if it is enabled.
+// It must be called with s.mu held.
+func (s *Server) channelProvidersLocked() []ChannelProvider {
+	providers := append([]ChannelProvider{}, s.channelProviders...)
+	if s.MetricsPrefix != "" {
+		providers = append(providers, metricsProvider{s, s.MetricsPrefix})
+	}
+	return providers
 }
 
 type serverConn struct {
 	*connection.Connection
 	srv *Server
-	g   *errgroup.Group
+	// workers runs the connection's executions, and ops counts the operations started by goOp and the executions
+	// submitted to workers that haven't finished.
+	workers *workerPool
+	ops     sync.WaitGroup
+
+	authNZ    []string
+	authorize func(ctx context.Context, peer *Peer) error
+
+	mu   sync.Mutex
+	peer *Peer
+	// channels holds the channels created on the connection, by server channel ID.
+	channels map[pvdata.PVInt]*serverChannel
+	// channelIDs maps client channel IDs to server channel IDs.
+	channelIDs    map[pvdata.PVInt]pvdata.PVInt
+	lastChannelID pvdata.PVInt
+	requests      map[pvdata.PVInt]*request
+	// inits holds the requests whose INIT is being processed, so that requests pipelined after them can wait.
+	inits map[pvdata.PVInt]chan struct{}
+	// clientReceiveBufferSize and clientRegistryMaxSize are announced by the client during connection validation.
+	clientReceiveBufferSize int
+	clientRegistryMaxSize   int
+	// connected is set once the client has validated the connection.
+	connected bool
+	// overruns counts the monitor updates deferred because the connection was at its buffer limit.
+	// It is updated atomically, since monitors send their updates without holding mu.
+	overruns int64
+	// updates bundles monitor updates for clients that decode APP_MULTIPLE_DATA, and is nil for other clients.
+	updates *connection.Queue
+}
 
-	mu       sync.Mutex
-	channels map[pvdata.PVInt]Channel
-	requests map[pvdata.PVInt]*request
+// serverChannel is a channel created on a connection.
+type serverChannel struct {
+	channel Channel
+	// clientID is the client's ID for the channel.
+	clientID pvdata.PVInt
+	// queue orders all messages sent about this channel.
+	queue *connection.Queue
+	// rights are the client's access rights, decided when the channel was created.
+	rights AccessRights
 }
 
-type connChannel struct {
-	name      string
-	handleRPC func(ctx context.Context, args pvdata.PVStructure) (response interface{}, err error)
+// sender is implemented by *connection.Connection and *connection.Queue.
+type sender interface {
+	SendApp(ctx context.Context, messageCommand pvdata.PVByte, payload interface{}) error
 }
 
 type requestStatus int
@@ -141,9 +440,19 @@ func (r requestStatus) String() string {
 }
 
 type request struct {
-	doer   interface{}
+	doer interface{}
+	// channelID is the server channel ID the request was created on.
+	channelID pvdata.PVInt
+	// cancel cancels the context of the operation in progress, if any.
 	cancel func()
-	status requestStatus
+	// terminate releases the resources held by the request itself (e.g. a running monitor) when it is destroyed.
+	terminate func()
+	status    requestStatus
+	// kind and started describe the operation in progress, if any.
+	kind    OpKind
+	started time.Time
+	// stuck records what the watchdog has done about the operation in progress.
+	stuck stuckState
 }
 
 func (c *serverConn) addRequest(id pvdata.PVInt, r *request) error {
@@ -158,9 +467,58 @@ func (c *serverConn) addRequest(id pvdata.PVInt, r *request) error {
 	return nil
 }
 
+// beginInit records that the INIT of request id is being processed, and returns the function to call once it has been.
+// It must be called from the read loop, before the next message is read, so that the requests that follow can waitInit.
+func (c *serverConn) beginInit(id pvdata.PVInt) (done func()) {
+	ch := make(chan struct{})
+	c.mu.Lock()
+	c.inits[id] = ch
+	c.mu.Unlock()
+	return func() {
+		c.mu.Lock()
+		if c.inits[id] == ch {
+			delete(c.inits, id)
+		}
+		c.mu.Unlock()
+		close(ch)
+	}
+}
+
+// initOrder orders request id after its INIT, which is the request if init is set, so that clients may send requests
+// without waiting for the response to INIT. It must be called from the read loop, and the operation must begin with
+//
+//	defer order(ctx)()
+//
+// with the function it returns, so that the response to INIT is sent before any pipelined request is processed.
+func (c *serverConn) initOrder(id pvdata.PVInt, init bool) (order func(ctx context.Context) (done func())) {
+	if init {
+		done := c.beginInit(id)
+		return func(context.Context) func() { return done }
+	}
+	return func(ctx context.Context) func() {
+		c.waitInit(ctx, id)
+		return func() {}
+	}
+}
+
+// waitInit waits until the INIT of request id has been processed, if it is being processed.
+func (c *serverConn) waitInit(ctx context.Context, id pvdata.PVInt) {
+	c.mu.Lock()
+	ch := c.inits[id]
+	c.mu.Unlock()
+	if ch == nil {
+		return
+	}
+	select {
+	case <-ch:
+	case <-ctx.Done():
+	}
+}
+
 func (c *serverConn) cancelRequestLocked(id pvdata.PVInt) error {
 	if existing, ok := c.requests[id]; ok {
-		if existing.status < CANCELLED {
+		// Only the operation in progress is cancelled; a request that is idle stays usable.
+		if existing.status == REQUEST_IN_PROGRESS {
 			existing.status = CANCELLED
 		}
 		if existing.cancel != nil {
@@ -172,7 +530,10 @@ func (c *serverConn) cancelRequestLocked(id pvdata.PVInt) error {
 	return fmt.Errorf("unknown request %d", id)
 }
 
-func (c *serverConn) destroyRequestLocked(id pvdata.PVInt) error {
+// destroyRequestLocked destroys the request with the given ID, and returns the function that terminates it.
+// The caller must call the function once c.mu is released, since terminating a monitor waits for the update it is sending,
+// which can itself need c.mu.
+func (c *serverConn) destroyRequestLocked(id pvdata.PVInt) (func(), error) {
 	if existing, ok := c.requests[id]; ok {
 		if existing.status < DESTROYED {
 			existing.status = DESTROYED
@@ -181,47 +542,140 @@ func (c *serverConn) destroyRequestLocked(id pvdata.PVInt) error {
 			existing.cancel()
 			existing.cancel = nil
 		}
+		terminate := existing.terminate
+		existing.terminate = nil
 		delete(c.requests, id)
-		return nil
+		if terminate == nil {
+			terminate = func() {}
+		}
+		return terminate, nil
 	}
-	return fmt.Errorf("unknown request %d", id)
+	return func() {}, fmt.Errorf("unknown request %d", id)
+}
+
+// destroyRequestsLocked destroys every request on the channel with the given ID, or every request on the connection if all is true.
+// Like destroyRequestLocked, it returns the function that terminates the requests, to be called once c.mu is released.
+func (c *serverConn) destroyRequestsLocked(channelID pvdata.PVInt, all bool) func() {
+	var terminate []func()
+	for id, r := range c.requests {
+		if all || r.channelID == channelID {
+			t, _ := c.destroyRequestLocked(id)
+			terminate = append(terminate, t)
+		}
+	}
+	return func() {
+		for _, t := range terminate {
+			t()
+		}
+	}
+}
+
+// finishRequest is called when the operation in progress on r completes. ctx is the operation's context.
+// It returns r to READY (or destroys it, if destroy is true) and reports whether the operation's result should be sent.
+// The result of an operation that was cancelled, or whose request was destroyed while it ran, is discarded.
+func (c *serverConn) finishRequest(ctx context.Context, id pvdata.PVInt, r *request, destroy bool) bool {
+	c.mu.Lock()
+	// Requests cancelled by the watchdog still report their failure to the client.
+	send := (ctx.Err() == nil || r.stuck == stuckCancelled) && r.status == REQUEST_IN_PROGRESS
+	r.cancel = nil
+	if r.status == DESTROYED {
+		c.mu.Unlock()
+		return false
+	}
+	r.status = READY
+	terminate := func() {}
+	if destroy {
+		terminate, _ = c.destroyRequestLocked(id)
+	}
+	c.mu.Unlock()
+	terminate()
+	return send
 }
 
 func (srv *Server) newConn(conn io.ReadWriter) *serverConn {
 	c := connection.New(conn, proto.FLAG_FROM_SERVER)
+	c.OnSend = srv.opStats.sent
+	c.SegmentSize = srv.SegmentSize
+	c.MaxBuffered = srv.ConnectionBufferLimit
+	c.Strict = srv.Strict
+	c.Serializer = srv.Serializer
+	if srv.Compressor != nil {
+		// Compression is only used if the client offers it.
+		c.SetCompression(srv.Compressor, compressionThreshold(srv.CompressionThreshold))
+	}
 	return &serverConn{
 		Connection: c,
 		srv:        srv,
-		channels:   make(map[pvdata.PVInt]Channel),
+		workers:    newWorkerPool(srv.MaxConnectionWorkers, srv.WorkerQueueLimit, srv.serverWorkers()),
+		authNZ:     []string{"anonymous"},
+		peer:       &Peer{},
+		channels:   make(map[pvdata.PVInt]*serverChannel),
+		channelIDs: make(map[pvdata.PVInt]pvdata.PVInt),
 		requests:   make(map[pvdata.PVInt]*request),
+		inits:      make(map[pvdata.PVInt]chan struct{}),
 	}
 }
 
-func (srv *Server) handleConnection(ctx context.Context, conn net.Conn) {
-	ctx = ctxlog.WithFields(ctx, ctxlog.Fields{
-		"local_addr":  srv.ln.Addr(),
+func (srv *Server) handleConnection(ctx context.Context, l *Listener, conn net.Conn) {
+	ctx = ctxlog.WithSubsystem(ctxlog.WithFields(ctx, ctxlog.Fields{
+		"local_addr":  l.Addr(),
 		"remote_addr": conn.RemoteAddr(),
-		"proto":       "tcp",
-	})
+		"proto":       l.Addr().Network(),
+	}), ctxlog.Connection)
 	c := srv.newConn(conn)
-	g, ctx := errgroup.WithContext(ctx)
-	c.g = g
-	g.Go(func() error {
+	c.peer = &Peer{
+		Addr:      conn.RemoteAddr(),
+		LocalAddr: l.Addr(),
+	}
+	if len(l.AuthNZ) > 0 {
+		c.authNZ = l.AuthNZ
+	}
+	c.authorize = l.Authorize
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
 		<-ctx.Done()
 		// TODO: Gracefully destroy requests and channels before closing?
-		return conn.Close()
-	})
-	g.Go(func() error {
-		ctxlog.L(ctx).Infof("new connection")
-		return c.serve(ctx)
-	})
-	if err := g.Wait(); err != nil {
+		c.Close()
+	}()
+	ctxlog.L(ctx).Infof("new connection")
+	err := c.serve(ctx)
+	// Close the connection once the client goes away, so that every operation still running sees it closed.
+	cancel()
+	c.ops.Wait()
+	var decodeErr *connection.DecodeError
+	if errors.As(err, &decodeErr) {
+		// Clients that send one malformed message tend to reconnect and send it again.
+		connection.WarnDecode(ctx, err, "closing connection")
+	} else if err != nil && !errors.Is(err, connection.ErrConnectionClosed) {
 		ctxlog.L(ctx).Errorf("error on connection: %v", err)
 	}
 }
 
-func (c *serverConn) serve(ctx context.Context) error {
-	ctx, cancel := context.WithCancel(ctx)
+// goOp runs f, which handles a message with the given command, on its own goroutine. f reports its failures to the
+// client itself, so an error from f, such as a failure to send the response, only fails that operation: it is
+// logged, and the connection carries on. Transport failures close the connection, which the read loop then sees.
+func (c *serverConn) goOp(ctx context.Context, command pvdata.PVByte, f func() error) {
+	c.ops.Add(1)
+	go func() {
+		defer c.ops.Done()
+		if err := f(); err != nil && !errors.Is(err, connection.ErrConnectionClosed) {
+			ctxlog.L(ctx).Errorf("%s: %v", proto.AppCommand(command), err)
+		}
+	}()
+}
+
+func (c *serverConn) serve(ctx context.Context) (err error) {
+	ctx, cancel := context.WithCancel(c.srv.withValues(ctx))
+	defer cancel()
+	defer func() {
+		c.mu.Lock()
+		connected, peer := c.connected, c.peer
+		c.mu.Unlock()
+		if connected {
+			c.srv.emit(Event{Kind: ClientDisconnected, Peer: peer, Err: err})
+		}
+	}()
 	c.Version = pvdata.PVByte(2)
 	// 0 = Ignore byte order field in header
 	if err := c.SendCtrl(ctx, proto.CTRL_SET_BYTE_ORDER, 0); err != nil {
@@ -231,18 +685,39 @@ func (c *serverConn) serve(ctx context.Context) error {
 	req := proto.ConnectionValidationRequest{
 		ServerReceiveBufferSize:            pvdata.PVInt(c.ReceiveBufferSize()),
 		ServerIntrospectionRegistryMaxSize: 0x7fff,
-		AuthNZ: []string{"anonymous"},
+		AuthNZ:                             c.authNZ,
 	}
 	c.SendApp(ctx, proto.APP_CONNECTION_VALIDATION, &req)
 
+	c.srv.addConn(c)
+	defer c.srv.removeConn(c)
+	// Cancel any operations still in progress, since their results can no longer be delivered, and release the channels.
+	defer c.destroyChannels(ctx)
+	interval := c.srv.HeartbeatInterval
+	if interval == 0 {
+		interval = defaultHeartbeatInterval
+	}
+	if interval > 0 {
+		// The heartbeat stops when serve returns and cancels ctx.
+		go c.heartbeat(ctx, interval)
+	}
+	if threshold := c.srv.StuckRequestThreshold; threshold > 0 {
+		go c.watchdog(ctx, threshold)
+	}
+	if timeout := c.srv.MonitorIdleTimeout; timeout > 0 {
+		go c.expireMonitors(ctx, timeout)
+	}
+
 	for {
 		if err := c.handleServerOnePacket(ctx); err != nil {
 			if err == io.EOF {
-				cancel()
-				// TODO: Cleanup resources (requests, channels, etc.)
 				ctxlog.L(ctx).Infof("client went away, closing connection")
 				return nil
 			}
+			if errors.Is(err, connection.ErrConnectionClosed) {
+				ctxlog.L(ctx).Infof("connection closed")
+				return nil
+			}
 			return err
 		}
 	}
@@ -252,6 +727,27 @@ func (c *serverConn) handleServerOnePacket(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	c.srv.opStats.received(msg.Header.MessageCommand, len(msg.Data))
+	c.mu.Lock()
+	ctx = types.WithPeer(ctx, c.peer)
+	connected := c.connected
+	c.mu.Unlock()
+	if !connected && msg.Header.MessageCommand != proto.APP_CONNECTION_VALIDATION {
+		// Nothing is served to a client that hasn't been authenticated, Strict or not.
+		return connection.Violation(ctx, &msg.Header, "message sent before the connection was validated")
+	}
+	if c.srv.Strict {
+		if err := checkOrder(ctx, &msg.Header, connected); err != nil {
+			return err
+		}
+	}
+	switch msg.Header.MessageCommand {
+	case proto.APP_CONNECTION_VALIDATION:
+	case proto.APP_SEARCH_REQUEST:
+		ctx = ctxlog.WithSubsystem(ctx, ctxlog.Search)
+	default:
+		ctx = ctxlog.WithSubsystem(ctx, ctxlog.Provider)
+	}
 	if f, ok := serverDispatch[msg.Header.MessageCommand]; ok {
 		return f(c, ctx, msg)
 	} else {
@@ -260,11 +756,23 @@ func (c *serverConn) handleServerOnePacket(ctx context.Context) error {
 	return nil
 }
 
+// checkOrder checks that a message from a client in strict mode is allowed at this point of the connection.
+func checkOrder(ctx context.Context, header *proto.PVAccessHeader, connected bool) error {
+	if connected && header.MessageCommand == proto.APP_CONNECTION_VALIDATION {
+		return connection.Violation(ctx, header, "connection validated more than once")
+	}
+	if _, ok := serverDispatch[header.MessageCommand]; !ok {
+		return connection.Violation(ctx, header, "unknown command 0x%x", header.MessageCommand)
+	}
+	return nil
+}
+
 var serverDispatch = map[pvdata.PVByte]func(c *serverConn, ctx context.Context, msg *connection.Message) error{
 	proto.APP_CONNECTION_VALIDATION: (*serverConn).handleConnectionValidation,
 	proto.APP_CHANNEL_CREATE:        (*serverConn).handleCreateChannelRequest,
 	proto.APP_CHANNEL_DESTROY:       (*serverConn).handleChannelDestroy,
 	proto.APP_CHANNEL_GET:           (*serverConn).handleChannelGet,
+	proto.APP_CHANNEL_PUT:           (*serverConn).handleChannelPut,
 	proto.APP_CHANNEL_RPC:           (*serverConn).handleChannelRPC,
 	proto.APP_CHANNEL_MONITOR:       (*serverConn).handleChannelMonitor,
 	proto.APP_REQUEST_CANCEL:        (*serverConn).handleRequestCancelDestroy,
@@ -279,34 +787,155 @@ func (c *serverConn) handleConnectionValidation(ctx context.Context, msg *connec
 	}
 	ctxlog.L(ctx).Infof("received connection validation %#v", resp)
 	// TODO: Implement flow control
+	peer, err := c.validatePeer(ctx, resp)
+	if err != nil {
+		ctxlog.L(ctx).Warnf("rejecting connection: %v", err)
+		if err := c.SendApp(ctx, proto.APP_CONNECTION_VALIDATED, &proto.ConnectionValidated{
+			Status: c.errorToStatus(err),
+		}); err != nil {
+			return err
+		}
+		return err
+	}
+	c.mu.Lock()
+	c.peer = peer
+	c.clientReceiveBufferSize = int(resp.ClientReceiveBufferSize)
+	c.clientRegistryMaxSize = int(resp.ClientIntrospectionRegistryMaxSize)
+	if resp.ConnectionQos&proto.QOS_MULTIPLE_DATA != 0 {
+		c.updates = c.NewBundlingQueue()
+	}
+	c.connected = true
+	c.mu.Unlock()
+	c.srv.emit(Event{Kind: ClientConnected, Peer: peer})
 	return c.SendApp(ctx, proto.APP_CONNECTION_VALIDATED, &proto.ConnectionValidated{})
 }
 
+// validatePeer checks the client's connection validation against the listener's policy
+// and returns the resulting identity.
+func (c *serverConn) validatePeer(ctx context.Context, resp proto.ConnectionValidationResponse) (*Peer, error) {
+	c.mu.Lock()
+	peer := *c.peer
+	c.mu.Unlock()
+	peer.AuthNZ = string(resp.AuthNZ)
+	peer.Priority = int(resp.ConnectionQos & proto.QOS_PRIORITY_MASK)
+	if peer.Priority > proto.QOS_PRIORITY_MAX {
+		peer.Priority = proto.QOS_PRIORITY_MAX
+	}
+	offered := false
+	for _, m := range c.authNZ {
+		if m == peer.AuthNZ {
+			offered = true
+		}
+	}
+	if !offered {
+		return nil, pvdata.PVStatus{
+			Type:    pvdata.PVStatus_ERROR,
+			Message: pvdata.PVString(fmt.Sprintf("authentication method %q not offered", peer.AuthNZ)),
+		}
+	}
+	if peer.AuthNZ == "ca" {
+		if data, ok := resp.Data.Data.(pvdata.PVStructure); ok {
+			if user, ok := data.Field("user").(*pvdata.PVString); ok {
+				peer.User = string(*user)
+			}
+			if host, ok := data.Field("host").(*pvdata.PVString); ok {
+				peer.Host = string(*host)
+			}
+		}
+	}
+	if c.authorize != nil {
+		if err := c.authorize(ctx, &peer); err != nil {
+			return nil, err
+		}
+	}
+	return &peer, nil
+}
+
 func (c *serverConn) handleCreateChannelRequest(ctx context.Context, msg *connection.Message) error {
 	var req proto.CreateChannelRequest
 	if err := msg.Decode(&req); err != nil {
 		return err
 	}
-	var resp proto.CreateChannelResponse
-	if len(req.Channels) == 1 {
-		ch := req.Channels[0]
-		ctxlog.L(ctx).Infof("received request to create channel %q as client channel ID %x", ch.ChannelName, ch.ClientChannelID)
-		resp.ClientChannelID = ch.ClientChannelID
-		channel, err := c.createChannel(ctx, ch.ClientChannelID, ch.ChannelName)
-		if err != nil {
-			resp.Status = errorToStatus(err)
-		} else if channel != nil {
-			resp.ServerChannelID = ch.ClientChannelID
-		} else {
-			resp.Status.Type = pvdata.PVStatus_ERROR
-			resp.Status.Message = pvdata.PVString(fmt.Sprintf("unknown channel %q", ch.ChannelName))
-		}
-		ctxlog.L(ctx).Infof("channel status = %v", resp.Status)
-	} else {
+	if len(req.Channels) == 0 {
+		return c.SendApp(ctx, proto.APP_CHANNEL_CREATE, &proto.CreateChannelResponse{
+			Status: pvdata.PVStatus{
+				Type:    pvdata.PVStatus_ERROR,
+				Message: "no channels requested",
+			},
+		})
+	}
+	// Each channel gets its own response, with its own status.
+	for _, ch := range req.Channels {
+		resp := c.createRequestedChannel(ctx, ch)
+		if err := c.SendApp(ctx, proto.APP_CHANNEL_CREATE, &resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createRequestedChannel creates one of the channels in a CreateChannelRequest.
+func (c *serverConn) createRequestedChannel(ctx context.Context, ch proto.CreateChannelRequest_Channel) proto.CreateChannelResponse {
+	ctxlog.L(ctx).Infof("received request to create channel %q as client channel ID %x", ch.ChannelName, ch.ClientChannelID)
+	resp := proto.CreateChannelResponse{ClientChannelID: ch.ClientChannelID}
+	if c.srv.isDraining() {
 		resp.Status.Type = pvdata.PVStatus_ERROR
-		resp.Status.Message = "wrong number of channels"
+		resp.Status.Message = pvdata.PVString("server is shutting down" + proto.CreateChannelFailure{Retry: true}.RetryHint())
+		return resp
+	}
+	peer, _ := PeerFromContext(ctx)
+	var sid pvdata.PVInt
+	result, err := c.intercept(ctx, &Op{
+		Kind:        OpCreateChannel,
+		ChannelName: ch.ChannelName,
+		Peer:        peer,
+	}, func(ctx context.Context, op *Op) (interface{}, error) {
+		channel, id, err := c.createChannel(ctx, ch.ClientChannelID, op.ChannelName)
+		sid = id
+		return channel, err
+	})
+	channel, _ := result.(Channel)
+	if err == nil && channel == nil {
+		err = fmt.Errorf("%w: %q", ErrNotFound, ch.ChannelName)
 	}
-	return c.SendApp(ctx, proto.APP_CHANNEL_CREATE, &resp)
+	if err != nil {
+		resp.Status = c.createFailureStatus(err)
+	} else {
+		c.srv.emit(Event{Kind: ChannelCreated, Peer: peer, ChannelName: ch.ChannelName})
+		resp.ServerChannelID = sid
+		c.mu.Lock()
+		if sc := c.channels[sid]; sc != nil {
+			resp.AccessRights = pvdata.PVShort(sc.rights)
+		}
+		c.mu.Unlock()
+	}
+	ctxlog.L(ctx).Infof("channel status = %v", resp.Status)
+	return resp
+}
+
+// createFailureStatus converts an error creating a channel to the status sent to the client, whose message
+// starts with the reason if err is ErrNotFound or ErrPermissionDenied, and ends with a retry hint if err is
+// a RetryableError, as described by proto.ParseCreateChannelFailure.
+func (c *serverConn) createFailureStatus(err error) pvdata.PVStatus {
+	s := c.errorToStatus(err)
+	var reason string
+	switch {
+	case errors.Is(err, ErrNotFound):
+		reason = proto.CREATE_CHANNEL_NOT_FOUND
+	case errors.Is(err, ErrPermissionDenied):
+		reason = proto.CREATE_CHANNEL_PERMISSION_DENIED
+	}
+	if reason != "" {
+		s.Type = pvdata.PVStatus_ERROR
+		if !strings.HasPrefix(string(s.Message), reason) {
+			s.Message = pvdata.PVString(reason + ": " + string(s.Message))
+		}
+	}
+	var retryable *RetryableError
+	if errors.As(err, &retryable) {
+		s.Message += pvdata.PVString(proto.CreateChannelFailure{Retry: true, RetryAfter: retryable.After}.RetryHint())
+	}
+	return s
 }
 
 func (c *serverConn) handleChannelDestroy(ctx context.Context, msg *connection.Message) error {
@@ -315,40 +944,89 @@ func (c *serverConn) handleChannelDestroy(ctx context.Context, msg *connection.M
 		return err
 	}
 	ctxlog.L(ctx).Infof("CHANNEL_DESTROY(%d, %d)", req.ServerChannelID, req.ClientChannelID)
-	if req.ServerChannelID != req.ClientChannelID {
+	if err := c.destroyChannel(ctx, req.ServerChannelID, req.ClientChannelID); err != nil {
 		// TODO: Spec says we "MUST respond with an error status", but response struct doesn't contain a status code...
 		// Returning nil will just cause the client to time out.
-		return nil
-	}
-	if err := c.destroyChannel(req.ServerChannelID); err != nil {
 		ctxlog.L(ctx).Errorf("destroying channel: %v", err)
+		return nil
 	}
 	// Response is just a copy of the request.
 	return c.SendApp(ctx, proto.APP_CHANNEL_DESTROY, &req)
 }
 
-func errorToStatus(err error) pvdata.PVStatus {
+// errOverloaded rejects new operations on a connection that is at its ConnectionBufferLimit.
+var errOverloaded = pvdata.Error("server is busy sending to this client; try again later")
+
+// intercept runs handler for op with the server's interceptors, rejecting new operations while the connection
+// is at its buffer limit.
+func (c *serverConn) intercept(ctx context.Context, op *Op, handler OpHandler) (interface{}, error) {
+	return c.srv.intercept(ctx, op, func(ctx context.Context, op *Op) (interface{}, error) {
+		if (op.Init || op.Kind == OpCreateChannel) && c.Overloaded() {
+			return nil, errOverloaded
+		}
+		return handler(ctx, op)
+	})
+}
+
+// newOp returns an Op describing an operation on channel requested by the client in ctx.
+func (c *serverConn) newOp(ctx context.Context, kind OpKind, init bool, channel Channel, args pvdata.PVStructure) *Op {
+	peer, _ := PeerFromContext(ctx)
+	return &Op{
+		Kind:        kind,
+		Init:        init,
+		ChannelName: channel.Name(),
+		Channel:     channel,
+		Peer:        peer,
+		Args:        args,
+	}
+}
+
+// errorToStatus converts an error returned by a handler or channel to the status sent to the client.
+// If err is or wraps a PVStatus, that status is sent; other errors are sent as FATAL statuses.
+// OK and WARNING statuses are sent as ERROR statuses, since clients take them to mean that the operation succeeded.
+// If the server's DebugStatus is set, the status's call tree describes err and every error it wraps.
+func (c *serverConn) errorToStatus(err error) pvdata.PVStatus {
 	if err == nil {
-		return pvdata.PVStatus{}
+		return pvdata.OK()
+	}
+	var s pvdata.PVStatus
+	if !errors.As(err, &s) {
+		s = pvdata.Fatal(err.Error())
+	}
+	if s.Type < pvdata.PVStatus_ERROR {
+		s.Type = pvdata.PVStatus_ERROR
 	}
-	if s, ok := err.(pvdata.PVStatus); ok {
-		return s
+	if c.srv.DebugStatus && s.CallTree == "" {
+		var b strings.Builder
+		writeCallTree(&b, err, "")
+		s.CallTree = pvdata.PVString(strings.TrimSuffix(b.String(), "\n"))
 	}
-	return pvdata.PVStatus{
-		Type:    pvdata.PVStatus_FATAL,
-		Message: pvdata.PVString(err.Error()),
+	return s
+}
+
+// writeCallTree writes one line for err and for each error it wraps, indenting each cause under its wrapper.
+func writeCallTree(b *strings.Builder, err error, indent string) {
+	for ; err != nil; indent += "  " {
+		fmt.Fprintf(b, "%s%T: %v\n", indent, err, err)
+		if multi, ok := err.(interface{ Unwrap() []error }); ok {
+			for _, err := range multi.Unwrap() {
+				writeCallTree(b, err, indent+"  ")
+			}
+			return
+		}
+		err = errors.Unwrap(err)
 	}
 }
 
-func (c *serverConn) getChannel(ctx context.Context, id pvdata.PVInt) (Channel, error) {
+func (c *serverConn) getChannel(ctx context.Context, id pvdata.PVInt) (*serverChannel, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	channel := c.channels[id]
-	if channel == nil {
+	sc := c.channels[id]
+	if sc == nil {
 		return nil, fmt.Errorf("unknown channel ID %x", id)
 	}
-	ctxlog.L(ctx).Debugf("channel = %#v", channel)
-	return channel, nil
+	ctxlog.L(ctx).Debugf("channel = %#v", sc.channel)
+	return sc, nil
 }
 
 func (c *serverConn) handleChannelGet(ctx context.Context, msg *connection.Message) error {
@@ -356,22 +1034,27 @@ func (c *serverConn) handleChannelGet(ctx context.Context, msg *connection.Messa
 	if err := msg.Decode(&req); err != nil {
 		return err
 	}
-	ctxlog.L(ctx).Debugf("CHANNEL_GET(%#v)", req)
-	c.g.Go(func() (err error) {
+	ctxlog.L(ctx).Debugf("%s: %#v", proto.DescribeCommand(proto.APP_CHANNEL_GET, byte(req.Subcommand)), req)
+	order := c.initOrder(req.RequestID, req.Subcommand == proto.CHANNEL_GET_INIT)
+	c.goOp(ctx, proto.APP_CHANNEL_GET, func() (err error) {
+		defer order(ctx)()
+		var s sender = c.Connection
 		defer func() {
 			if err != nil {
 				ctxlog.L(ctx).Warnf("Channel Get failed: %v", err)
-				err = c.SendApp(ctx, proto.APP_CHANNEL_GET, &proto.ChannelResponseError{
+				err = s.SendApp(ctx, proto.APP_CHANNEL_GET, &proto.ChannelResponseError{
 					RequestID:  req.RequestID,
 					Subcommand: req.Subcommand,
-					Status:     errorToStatus(err),
+					Status:     c.errorToStatus(err),
 				})
 			}
 		}()
-		channel, err := c.getChannel(ctx, req.ServerChannelID)
+		sc, err := c.getChannel(ctx, req.ServerChannelID)
 		if err != nil {
 			return err
 		}
+		s = sc.queue
+		channel := sc.channel
 		ctx = ctxlog.WithFields(ctx, ctxlog.Fields{
 			"channel":    channel.Name(),
 			"channel_id": req.ServerChannelID,
@@ -384,36 +1067,40 @@ func (c *serverConn) handleChannelGet(ctx context.Context, msg *connection.Messa
 				return fmt.Errorf("Get arguments were of type %T, expected PVStructure", req.PVRequest.Data)
 			}
 			ctxlog.L(ctx).Printf("received request to init channel get with body %v", args)
+			if err := checkRead(sc.rights, channel); err != nil {
+				return err
+			}
 			// TODO: Parse args to select output data
-			var geter ChannelGeter
-			if getc, ok := channel.(ChannelGetCreator); ok {
+			var fd pvdata.FieldDesc
+			result, err := c.intercept(ctx, c.newOp(ctx, OpGet, true, channel, args), func(ctx context.Context, op *Op) (interface{}, error) {
+				var geter ChannelGeter
 				var err error
-				geter, err = getc.CreateChannelGet(ctx, args)
-				if err != nil {
-					return err
+				if getc, ok := channel.(ChannelGetCreator); ok {
+					if geter, err = getc.CreateChannelGet(ctx, op.Args); err != nil {
+						return nil, err
+					}
+				} else if g, ok := channel.(ChannelGeter); ok {
+					geter = g
 				}
-			} else if g, ok := channel.(ChannelGeter); ok {
-				geter = g
-			} else {
-				return fmt.Errorf("channel %q (ID %x) does not support Get", channel.Name(), req.ServerChannelID)
-			}
-			if err := c.addRequest(req.RequestID, &request{doer: geter, status: READY}); err != nil {
-				return err
-			}
-			// TODO: Optional interface to get field description without having to do expensive get
-			out, err := geter.ChannelGet(ctx)
+				if geter == nil {
+					return nil, fmt.Errorf("channel %q (ID %x) does not support Get", channel.Name(), req.ServerChannelID)
+				}
+				// The value is read as part of INIT, so that interceptors see the read and any error it fails with.
+				// TODO: Optional interface to get field description without having to do expensive get
+				fd, err = getFieldDesc(ctx, geter)
+				return geter, err
+			})
 			if err != nil {
 				return err
 			}
-			pvs, err := pvdata.NewPVStructure(out)
-			if err != nil {
-				return err
+			geter, ok := result.(ChannelGeter)
+			if !ok {
+				return fmt.Errorf("channel %q (ID %x) does not support Get", channel.Name(), req.ServerChannelID)
 			}
-			fd, err := pvs.FieldDesc()
-			if err != nil {
+			if err := c.addRequest(req.RequestID, &request{doer: geter, channelID: req.ServerChannelID, status: READY}); err != nil {
 				return err
 			}
-			return c.SendApp(ctx, proto.APP_CHANNEL_GET, &proto.ChannelGetResponseInit{
+			return s.SendApp(ctx, proto.APP_CHANNEL_GET, &proto.ChannelGetResponseInit{
 				RequestID:     req.RequestID,
 				Subcommand:    req.Subcommand,
 				PVStructureIF: fd,
@@ -423,7 +1110,7 @@ func (c *serverConn) handleChannelGet(ctx context.Context, msg *connection.Messa
 			c.mu.Lock()
 			defer c.mu.Unlock()
 			r := c.requests[req.RequestID]
-			if r.status != READY {
+			if r == nil || r.status != READY {
 				return pvdata.PVStatus{
 					Type:    pvdata.PVStatus_ERROR,
 					Message: pvdata.PVString("request not READY"),
@@ -433,58 +1120,331 @@ func (c *serverConn) handleChannelGet(ctx context.Context, msg *connection.Messa
 			if !ok {
 				return errors.New("request not for get")
 			}
-			ctx, cancel := context.WithCancel(ctx)
-			r.status = REQUEST_IN_PROGRESS
-			r.cancel = cancel
-			c.g.Go(func() error {
-				respData, err := geter.ChannelGet(ctx)
+			ctx, cancel := c.startRequestLocked(ctx, r, OpGet)
+			destroy := req.Subcommand&proto.CHANNEL_GET_DESTROY == proto.CHANNEL_GET_DESTROY
+			return c.executeLocked(ctx, r, cancel, func() {
+				defer cancel()
+				respData, err := c.intercept(ctx, c.newOp(ctx, OpGet, false, channel, pvdata.PVStructure{}), func(ctx context.Context, op *Op) (interface{}, error) {
+					return geter.ChannelGet(ctx)
+				})
 				resp := &proto.ChannelGetResponse{
 					RequestID:  req.RequestID,
 					Subcommand: req.Subcommand,
-					Status:     errorToStatus(err),
+					Status:     c.errorToStatus(c.stuckErr(r, err)),
 					Value: pvdata.PVStructureDiff{
 						Value: respData,
 					},
 				}
-				if err := c.SendApp(ctx, proto.APP_CHANNEL_GET, resp); err != nil {
+				if !c.finishRequest(ctx, req.RequestID, r, destroy) {
+					ctxlog.L(ctx).Infof("discarding result of cancelled get")
+					return
+				}
+				if err := s.SendApp(ctx, proto.APP_CHANNEL_GET, resp); err != nil {
 					ctxlog.L(ctx).Errorf("sending get response: %v", err)
 				}
+			}, func(err error) {
+				defer cancel()
+				c.failExecution(ctx, s, proto.APP_CHANNEL_GET, req.RequestID, req.Subcommand, r, destroy, err)
+			})
+		}
+	})
+	return nil
+}
+
+// putRequest is the state of a Put request.
+type putRequest struct {
+	puter ChannelPuter
+	// geter is used to implement CHANNEL_PUT_GET; it is nil if the channel does not support Get.
+	geter ChannelGeter
+	// fd describes the structure that clients put.
+	fd pvdata.FieldDesc
+}
 
-				c.mu.Lock()
-				defer c.mu.Unlock()
-				r.status = READY
-				if req.Subcommand&proto.CHANNEL_GET_DESTROY == proto.CHANNEL_GET_DESTROY {
-					r.status = DESTROYED
-					delete(c.requests, req.RequestID)
+func (c *serverConn) handleChannelPut(ctx context.Context, msg *connection.Message) error {
+	var req proto.ChannelPutRequest
+	if err := msg.Decode(&req); err != nil {
+		return err
+	}
+	ctxlog.L(ctx).Debugf("%s: %#v", proto.DescribeCommand(proto.APP_CHANNEL_PUT, byte(req.Subcommand)), req)
+	init := req.Subcommand&proto.CHANNEL_PUT_INIT == proto.CHANNEL_PUT_INIT
+	order := c.initOrder(req.RequestID, init)
+	if req.IsPut() {
+		// The put data can only be decoded with the structure that was sent in the INIT response,
+		// so the read loop waits for it.
+		c.waitInit(ctx, req.RequestID)
+		if err := c.decodePutValue(msg, &req); err != nil {
+			ctxlog.L(ctx).Warnf("Channel Put failed: %v", err)
+			return c.SendApp(ctx, proto.APP_CHANNEL_PUT, &proto.ChannelResponseError{
+				RequestID:  req.RequestID,
+				Subcommand: req.Subcommand,
+				Status:     c.errorToStatus(err),
+			})
+		}
+	}
+	c.goOp(ctx, proto.APP_CHANNEL_PUT, func() (err error) {
+		defer order(ctx)()
+		var s sender = c.Connection
+		defer func() {
+			if err != nil {
+				ctxlog.L(ctx).Warnf("Channel Put failed: %v", err)
+				err = s.SendApp(ctx, proto.APP_CHANNEL_PUT, &proto.ChannelResponseError{
+					RequestID:  req.RequestID,
+					Subcommand: req.Subcommand,
+					Status:     c.errorToStatus(err),
+				})
+			}
+		}()
+		sc, err := c.getChannel(ctx, req.ServerChannelID)
+		if err != nil {
+			return err
+		}
+		s = sc.queue
+		channel := sc.channel
+		ctx = ctxlog.WithFields(ctx, ctxlog.Fields{
+			"channel":    channel.Name(),
+			"channel_id": req.ServerChannelID,
+			"request_id": req.RequestID,
+		})
+		if init {
+			args, ok := req.PVRequest.Data.(pvdata.PVStructure)
+			if !ok {
+				return fmt.Errorf("Put arguments were of type %T, expected PVStructure", req.PVRequest.Data)
+			}
+			ctxlog.L(ctx).Printf("received request to init channel put with body %v", args)
+			if err := checkWrite(sc.rights, sc.channel); err != nil {
+				return err
+			}
+			result, err := c.intercept(ctx, c.newOp(ctx, OpPut, true, channel, args), func(ctx context.Context, op *Op) (interface{}, error) {
+				if putc, ok := channel.(ChannelPutCreator); ok {
+					return putc.CreateChannelPut(ctx, op.Args)
+				} else if p, ok := channel.(ChannelPuter); ok {
+					return p, nil
 				}
-				return nil
+				return nil, fmt.Errorf("channel %q (ID %x) does not support Put", channel.Name(), req.ServerChannelID)
+			})
+			if err != nil {
+				return err
+			}
+			puter, ok := result.(ChannelPuter)
+			if !ok {
+				return fmt.Errorf("channel %q (ID %x) does not support Put", channel.Name(), req.ServerChannelID)
+			}
+			pr := &putRequest{puter: puter}
+			if g, ok := puter.(ChannelGeter); ok {
+				pr.geter = g
+			} else if g, ok := channel.(ChannelGeter); ok {
+				pr.geter = g
+			}
+			if fder, ok := puter.(pvdata.FieldDescer); ok {
+				pr.fd, err = fder.FieldDesc()
+			} else if pr.geter != nil {
+				pr.fd, err = getFieldDesc(ctx, pr.geter)
+			} else {
+				err = fmt.Errorf("channel %q (ID %x) does not describe its Put structure", channel.Name(), req.ServerChannelID)
+			}
+			if err != nil {
+				return err
+			}
+			if err := c.addRequest(req.RequestID, &request{doer: pr, channelID: req.ServerChannelID, status: READY}); err != nil {
+				return err
+			}
+			return s.SendApp(ctx, proto.APP_CHANNEL_PUT, &proto.ChannelPutResponseInit{
+				RequestID:        req.RequestID,
+				Subcommand:       req.Subcommand,
+				PVPutStructureIF: pr.fd,
 			})
 		}
-		return nil
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		r := c.requests[req.RequestID]
+		if r == nil || r.status != READY {
+			return pvdata.PVStatus{
+				Type:    pvdata.PVStatus_ERROR,
+				Message: pvdata.PVString("request not READY"),
+			}
+		}
+		pr, ok := r.doer.(*putRequest)
+		if !ok {
+			return errors.New("request not for put")
+		}
+		ctx, cancel := c.startRequestLocked(ctx, r, OpPut)
+		destroy := req.Subcommand&proto.CHANNEL_PUT_DESTROY == proto.CHANNEL_PUT_DESTROY
+		return c.executeLocked(ctx, r, cancel, func() {
+			defer cancel()
+			var resp interface{}
+			if req.Subcommand&proto.CHANNEL_PUT_GET == proto.CHANNEL_PUT_GET {
+				ctxlog.L(ctx).Printf("received request to get current value of channel put")
+				var value interface{}
+				var err error
+				if pr.geter == nil {
+					err = fmt.Errorf("channel %q (ID %x) does not support Get", channel.Name(), req.ServerChannelID)
+				} else {
+					value, err = pr.geter.ChannelGet(ctx)
+				}
+				resp = &proto.ChannelPutGetResponse{
+					RequestID:  req.RequestID,
+					Subcommand: req.Subcommand,
+					Status:     c.errorToStatus(c.stuckErr(r, err)),
+					Value: pvdata.PVStructureDiff{
+						Value: value,
+					},
+				}
+			} else {
+				value := req.Value.Value.(pvdata.PVStructure)
+				changed, all := pvdata.ChangedFields(pr.fd, req.Value.ChangedBitSet)
+				ctxlog.L(ctx).Printf("received request to put %v (fields %v)", value, changed)
+				var err error
+				fp, isFieldPuter := pr.puter.(ChannelFieldPuter)
+				if !all && !isFieldPuter && pr.geter != nil {
+					value, err = pr.merge(ctx, value, changed)
+				}
+				if err == nil {
+					op := c.newOp(ctx, OpPut, false, channel, pvdata.PVStructure{})
+					op.Value = value
+					op.ChangedFields = changed
+					_, err = c.intercept(ctx, op, func(ctx context.Context, op *Op) (interface{}, error) {
+						var sent []string
+						if isFieldPuter {
+							sent = op.ChangedFields
+						}
+						if err := c.srv.validatePut(ctx, channel, pr.puter, op.Value, sent); err != nil {
+							return nil, err
+						}
+						if isFieldPuter {
+							return nil, fp.ChannelPutFields(ctx, op.Value, op.ChangedFields)
+						}
+						return nil, pr.puter.ChannelPut(ctx, op.Value)
+					})
+				}
+				resp = &proto.ChannelPutResponse{
+					RequestID:  req.RequestID,
+					Subcommand: req.Subcommand,
+					Status:     c.errorToStatus(c.stuckErr(r, err)),
+				}
+			}
+			if !c.finishRequest(ctx, req.RequestID, r, destroy) {
+				ctxlog.L(ctx).Infof("discarding result of cancelled put")
+				return
+			}
+			if err := s.SendApp(ctx, proto.APP_CHANNEL_PUT, resp); err != nil {
+				ctxlog.L(ctx).Errorf("sending put response: %v", err)
+			}
+		}, func(err error) {
+			defer cancel()
+			c.failExecution(ctx, s, proto.APP_CHANNEL_PUT, req.RequestID, req.Subcommand, r, destroy, err)
+		})
 	})
 	return nil
 }
+
+// merge returns the channel's current value with the fields changed by a partial put replaced by those in value.
+// The merge is not atomic with the put; channels that need it to be should implement ChannelFieldPuter.
+func (pr *putRequest) merge(ctx context.Context, value pvdata.PVStructure, changed []string) (pvdata.PVStructure, error) {
+	current, err := pr.geter.ChannelGet(ctx)
+	if err != nil {
+		return pvdata.PVStructure{}, fmt.Errorf("getting value to merge put into: %w", err)
+	}
+	m, err := pvdata.ToMap(current)
+	if err != nil {
+		return pvdata.PVStructure{}, fmt.Errorf("getting value to merge put into: %w", err)
+	}
+	zero, err := pr.fd.Zero()
+	if err != nil {
+		return pvdata.PVStructure{}, err
+	}
+	merged := zero.(pvdata.PVStructure)
+	if err := merged.SetFromMap(m); err != nil {
+		return pvdata.PVStructure{}, fmt.Errorf("merging put into current value: %w", err)
+	}
+	if err := merged.CopyFields(value, changed); err != nil {
+		return pvdata.PVStructure{}, fmt.Errorf("merging put into current value: %w", err)
+	}
+	return merged, nil
+}
+
+// decodePutValue decodes the value in a put request from the rest of msg.
+func (c *serverConn) decodePutValue(msg *connection.Message, req *proto.ChannelPutRequest) error {
+	c.mu.Lock()
+	r := c.requests[req.RequestID]
+	c.mu.Unlock()
+	if r == nil {
+		return fmt.Errorf("unknown request %d", req.RequestID)
+	}
+	pr, ok := r.doer.(*putRequest)
+	if !ok {
+		return errors.New("request not for put")
+	}
+	zero, err := pr.fd.Zero()
+	if err != nil {
+		return err
+	}
+	value, ok := zero.(pvdata.PVStructure)
+	if !ok {
+		return fmt.Errorf("put structure is %T, expected PVStructure", zero)
+	}
+	req.Value.Value = value
+	return msg.Decode(&req.Value)
+}
+
+// getFieldDesc describes the value returned by geter.
+func getFieldDesc(ctx context.Context, geter ChannelGeter) (pvdata.FieldDesc, error) {
+	out, err := geter.ChannelGet(ctx)
+	if err != nil {
+		return pvdata.FieldDesc{}, err
+	}
+	return structureDesc(out)
+}
+
+// structureDesc describes value, which must be a structure.
+func structureDesc(value interface{}) (pvdata.FieldDesc, error) {
+	fd, err := pvdata.FieldDescOf(value)
+	if err != nil {
+		return pvdata.FieldDesc{}, err
+	}
+	if fd.TypeCode != pvdata.STRUCT {
+		return pvdata.FieldDesc{}, fmt.Errorf("value of type %T is not a structure", value)
+	}
+	return fd, nil
+}
+
+// monitorKey identifies the subscription to the channel name with the pvRequest req,
+// which is shared by monitors with the same name and an identical pvRequest.
+func monitorKey(name string, req pvdata.PVStructure) (string, error) {
+	var buf bytes.Buffer
+	// The type as well as the value must match.
+	any := pvdata.NewPVAny(req)
+	if err := pvdata.Encode(&pvdata.EncoderState{Buf: &buf, ByteOrder: binary.LittleEndian}, &any); err != nil {
+		return "", fmt.Errorf("encoding pvRequest: %w", err)
+	}
+	return name + "\x00" + buf.String(), nil
+}
+
 func (c *serverConn) handleChannelMonitor(ctx context.Context, msg *connection.Message) error {
 	var req proto.ChannelMonitorRequest
 	if err := msg.Decode(&req); err != nil {
 		return err
 	}
-	ctxlog.L(ctx).Debugf("CHANNEL_MONITOR(%#v)", req)
-	c.g.Go(func() (err error) {
+	ctxlog.L(ctx).Debugf("%s: %#v", proto.DescribeCommand(proto.APP_CHANNEL_MONITOR, byte(req.Subcommand)), req)
+	order := c.initOrder(req.RequestID, req.Subcommand&proto.CHANNEL_MONITOR_INIT == proto.CHANNEL_MONITOR_INIT)
+	c.goOp(ctx, proto.APP_CHANNEL_MONITOR, func() (err error) {
+		defer order(ctx)()
+		var s sender = c.Connection
 		defer func() {
 			if err != nil {
 				ctxlog.L(ctx).Warnf("Channel Monitor failed: %v", err)
-				err = c.SendApp(ctx, proto.APP_CHANNEL_MONITOR, &proto.ChannelResponseError{
+				err = s.SendApp(ctx, proto.APP_CHANNEL_MONITOR, &proto.ChannelResponseError{
 					RequestID:  req.RequestID,
 					Subcommand: pvdata.PVByte(req.Subcommand),
-					Status:     errorToStatus(err),
+					Status:     c.errorToStatus(err),
 				})
 			}
 		}()
-		channel, err := c.getChannel(ctx, req.ServerChannelID)
+		sc, err := c.getChannel(ctx, req.ServerChannelID)
 		if err != nil {
 			return err
 		}
+		s = sc.queue
+		channel := sc.channel
 		ctx = ctxlog.WithFields(ctx, ctxlog.Fields{
 			"channel":    channel.Name(),
 			"channel_id": req.ServerChannelID,
@@ -496,42 +1456,93 @@ func (c *serverConn) handleChannelMonitor(ctx context.Context, msg *connection.M
 				return fmt.Errorf("Monitor arguments were of type %T, expected PVStructure", req.PVRequest.Data)
 			}
 			ctxlog.L(ctx).Printf("received request to init channel monitor with body %v", args)
+			if err := checkRead(sc.rights, channel); err != nil {
+				return err
+			}
 			// TODO: Parse args to select output data
-			var nexter Nexter
-			if nextc, ok := channel.(ChannelMonitorCreator); ok {
-				nexter, err = nextc.CreateChannelMonitor(ctx, args)
+			// The monitor watches its Nexter with mctx, which is cancelled to abandon the Nexter if INIT fails.
+			mctx, abandon := context.WithCancel(ctx)
+			result, err := c.intercept(ctx, c.newOp(ctx, OpMonitor, true, channel, args), func(ctx context.Context, op *Op) (interface{}, error) {
+				nextc, ok := channel.(ChannelMonitorCreator)
+				if !ok {
+					return nil, fmt.Errorf("channel %q (ID %x) does not support Monitor", channel.Name(), req.ServerChannelID)
+				}
+				if !c.srv.ShareMonitors {
+					return nextc.CreateChannelMonitor(ctx, op.Args)
+				}
+				key, err := monitorKey(channel.Name(), op.Args)
 				if err != nil {
-					return err
+					return nil, err
 				}
-			} else {
+				return c.srv.monitors.Subscribe(ctx, key, func(ctx context.Context) (types.Nexter, error) {
+					return nextc.CreateChannelMonitor(ctx, op.Args)
+				})
+			})
+			if err != nil {
+				abandon()
+				return err
+			}
+			nexter, ok := result.(Nexter)
+			if !ok {
+				abandon()
 				return fmt.Errorf("channel %q (ID %x) does not support Monitor", channel.Name(), req.ServerChannelID)
 			}
-			value, err := nexter.Next(ctx)
+			value, err := nexter.Next(mctx)
+			if err != nil {
+				abandon()
+				return err
+			}
+			fd, err := structureDesc(value)
 			if err != nil {
+				abandon()
 				return err
 			}
-			m := monitor.New(ctx, args, nexter, func(value interface{}) {
-				c.SendApp(ctx, proto.APP_CHANNEL_MONITOR, &proto.ChannelMonitorResponse{
+			priority := 0
+			if peer, ok := PeerFromContext(ctx); ok {
+				priority = peer.Priority
+			}
+			// Updates for different monitors can be sent in any order, so they are bundled if the client allows it.
+			// They are still ordered after the INIT response, which has been sent by the time the first is.
+			c.mu.Lock()
+			updates := c.updates
+			c.mu.Unlock()
+			if updates == nil {
+				updates = sc.queue
+			}
+			m := monitor.New(mctx, c.srv.Clock, args, nexter, monitor.Filter{
+				FlushInterval: c.srv.MonitorFlushInterval,
+				DeadTime:      c.srv.MonitorDeadTime,
+			}, func(value interface{}) bool {
+				defer c.srv.updates.Acquire(ctx, c.srv.Clock, priority)()
+				// Updates are refused while the client is too far behind; the monitor sends the latest value later.
+				err := updates.TrySendApp(ctx, proto.APP_CHANNEL_MONITOR, &proto.ChannelMonitorResponse{
 					RequestID: req.RequestID,
 					Value: pvdata.PVStructureDiff{
 						Value: value,
 					},
 				})
+				if err == connection.ErrBufferFull {
+					atomic.AddInt64(&c.overruns, 1)
+					return false
+				}
+				return true
 			})
+			terminate := func() {
+				m.Terminate(ctx)
+				abandon()
+			}
 			m.Ack(ctx, int(req.NFree))
 			// TODO: Use QueueSize to initialize pipeline support
-			if err := c.addRequest(req.RequestID, &request{doer: m, status: READY}); err != nil {
-				return err
-			}
-			pvs, err := pvdata.NewPVStructure(value)
-			if err != nil {
-				return err
-			}
-			fd, err := pvs.FieldDesc()
-			if err != nil {
+			if err := c.addRequest(req.RequestID, &request{
+				doer:      m,
+				channelID: req.ServerChannelID,
+				terminate: terminate,
+				status:    READY,
+			}); err != nil {
+				terminate()
 				return err
 			}
-			if err := c.SendApp(ctx, proto.APP_CHANNEL_MONITOR, &proto.ChannelMonitorResponseInit{
+			if err := s.SendApp(ctx, proto.APP_CHANNEL_MONITOR, &proto.ChannelMonitorResponseInit{
 				RequestID:     req.RequestID,
 				Subcommand:    proto.CHANNEL_MONITOR_INIT,
 				PVStructureIF: fd,
@@ -541,11 +1552,13 @@ func (c *serverConn) handleChannelMonitor(ctx context.Context, msg *connection.M
 			m.Send(ctx, value)
 			return nil
 		}
-		ctxlog.L(ctx).Printf("received request on existing monitor")
+		ctxlog.L(ctx).Printf("received %s on existing monitor", proto.SubcommandName(proto.APP_CHANNEL_MONITOR, byte(req.Subcommand)))
 		c.mu.Lock()
-		defer c.mu.Unlock()
 		r := c.requests[req.RequestID]
-		if r.status != READY {
+		ready := r != nil && r.status == READY
+		// The monitor may send an update, which must not hold up other requests if the client is slow.
+		c.mu.Unlock()
+		if !ready {
 			return pvdata.PVStatus{
 				Type:    pvdata.PVStatus_ERROR,
 				Message: pvdata.PVString("request not READY"),
@@ -583,8 +1596,10 @@ func (c *serverConn) handleChannelRPC(ctx context.Context, msg *connection.Messa
 	if err := msg.Decode(&req); err != nil {
 		return err
 	}
-	ctxlog.L(ctx).Debugf("CHANNEL_RPC(%#v)", req)
-	c.g.Go(func() error {
+	ctxlog.L(ctx).Debugf("%s: %#v", proto.DescribeCommand(proto.APP_CHANNEL_RPC, byte(req.Subcommand)), req)
+	order := c.initOrder(req.RequestID, req.Subcommand == proto.CHANNEL_RPC_INIT)
+	c.goOp(ctx, proto.APP_CHANNEL_RPC, func() error {
+		defer order(ctx)()
 		return c.handleChannelRPCBody(ctx, req)
 	})
 	return nil
@@ -595,17 +1610,20 @@ func (c *serverConn) handleChannelRPCBody(ctx context.Context, req proto.Channel
 		RequestID:  req.RequestID,
 		Subcommand: req.Subcommand,
 	}
+	var s sender = c.Connection
 	defer func() {
 		if err != nil {
 			ctxlog.L(ctx).Warnf("Channel RPC failed: %v", err)
-			resp.Status = errorToStatus(err)
-			err = c.SendApp(ctx, proto.APP_CHANNEL_RPC, resp)
+			resp.Status = c.errorToStatus(err)
+			err = s.SendApp(ctx, proto.APP_CHANNEL_RPC, resp)
 		}
 	}()
-	channel, err := c.getChannel(ctx, req.ServerChannelID)
+	sc, err := c.getChannel(ctx, req.ServerChannelID)
 	if err != nil {
 		return err
 	}
+	s = sc.queue
+	channel := sc.channel
 	ctx = ctxlog.WithFields(ctx, ctxlog.Fields{
 		"channel":    channel.Name(),
 		"channel_id": req.ServerChannelID,
@@ -618,28 +1636,34 @@ func (c *serverConn) handleChannelRPCBody(ctx context.Context, req proto.Channel
 	switch req.Subcommand {
 	case proto.CHANNEL_RPC_INIT:
 		ctxlog.L(ctx).Printf("received request to init channel RPC with body %v", args)
-		var rpcer ChannelRPCer
-		if rpcc, ok := channel.(ChannelRPCCreator); ok {
-			var err error
-			rpcer, err = rpcc.CreateChannelRPC(ctx, args)
-			if err != nil {
-				return err
+		if err := checkRead(sc.rights, channel); err != nil {
+			return err
+		}
+		result, err := c.intercept(ctx, c.newOp(ctx, OpRPC, true, channel, args), func(ctx context.Context, op *Op) (interface{}, error) {
+			if rpcc, ok := channel.(ChannelRPCCreator); ok {
+				return rpcc.CreateChannelRPC(ctx, op.Args)
+			} else if r, ok := channel.(ChannelRPCer); ok {
+				return r, nil
 			}
-		} else if r, ok := channel.(ChannelRPCer); ok {
-			rpcer = r
-		} else {
+			return nil, fmt.Errorf("channel %q (ID %x) does not support RPC", channel.Name(), req.ServerChannelID)
+		})
+		if err != nil {
+			return err
+		}
+		rpcer, ok := result.(ChannelRPCer)
+		if !ok {
 			return fmt.Errorf("channel %q (ID %x) does not support RPC", channel.Name(), req.ServerChannelID)
 		}
-		if err := c.addRequest(req.RequestID, &request{doer: rpcer, status: READY}); err != nil {
+		if err := c.addRequest(req.RequestID, &request{doer: rpcer, channelID: req.ServerChannelID, status: READY}); err != nil {
 			return err
 		}
-		return c.SendApp(ctx, proto.APP_CHANNEL_RPC, resp)
+		return s.SendApp(ctx, proto.APP_CHANNEL_RPC, resp)
 	default:
 		ctxlog.L(ctx).Printf("received request to execute channel RPC with body %v", args)
 		c.mu.Lock()
 		defer c.mu.Unlock()
 		r := c.requests[req.RequestID]
-		if r.status != READY {
+		if r == nil || r.status != READY {
 			return pvdata.PVStatus{
 				Type:    pvdata.PVStatus_ERROR,
 				Message: pvdata.PVString("request not READY"),
@@ -649,31 +1673,30 @@ func (c *serverConn) handleChannelRPCBody(ctx context.Context, req proto.Channel
 		if !ok {
 			return errors.New("request not for RPC")
 		}
-		ctx, cancel := context.WithCancel(ctx)
-		r.status = REQUEST_IN_PROGRESS
-		r.cancel = cancel
-		c.g.Go(func() error {
-			respData, err := rpcer.ChannelRPC(ctx, args)
+		ctx, cancel := c.startRequestLocked(ctx, r, OpRPC)
+		destroy := req.Subcommand&proto.CHANNEL_RPC_DESTROY == proto.CHANNEL_RPC_DESTROY
+		return c.executeLocked(ctx, r, cancel, func() {
+			defer cancel()
+			respData, err := c.intercept(ctx, c.newOp(ctx, OpRPC, false, channel, args), func(ctx context.Context, op *Op) (interface{}, error) {
+				return rpcer.ChannelRPC(ctx, op.Args)
+			})
 			resp := &proto.ChannelRPCResponse{
 				RequestID:      req.RequestID,
 				Subcommand:     req.Subcommand,
-				Status:         errorToStatus(err),
+				Status:         c.errorToStatus(c.stuckErr(r, err)),
 				PVResponseData: pvdata.NewPVAny(respData),
 			}
-			if err := c.SendApp(ctx, proto.APP_CHANNEL_RPC, resp); err != nil {
-				ctxlog.L(ctx).Errorf("sending RPC response: %v", err)
+			if !c.finishRequest(ctx, req.RequestID, r, destroy) {
+				ctxlog.L(ctx).Infof("discarding result of cancelled RPC")
+				return
 			}
-
-			c.mu.Lock()
-			defer c.mu.Unlock()
-			r.status = READY
-			if req.Subcommand&proto.CHANNEL_RPC_DESTROY == proto.CHANNEL_RPC_DESTROY {
-				r.status = DESTROYED
-				delete(c.requests, req.RequestID)
+			if err := s.SendApp(ctx, proto.APP_CHANNEL_RPC, resp); err != nil {
+				ctxlog.L(ctx).Errorf("sending RPC response: %v", err)
 			}
-			return nil
+		}, func(err error) {
+			defer cancel()
+			c.failExecution(ctx, s, proto.APP_CHANNEL_RPC, req.RequestID, req.Subcommand, r, destroy, err)
 		})
-		return nil
 	}
 }
 
@@ -682,18 +1705,21 @@ func (c *serverConn) handleRequestCancelDestroy(ctx context.Context, msg *connec
 	if err := msg.Decode(&req); err != nil {
 		return err
 	}
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
 	if msg.Header.MessageCommand == proto.APP_REQUEST_DESTROY {
 		ctxlog.L(ctx).Infof("REQUEST_DESTROY(%d, %d)", req.ServerChannelID, req.RequestID)
-		if err := c.destroyRequestLocked(req.RequestID); err != nil {
+		c.mu.Lock()
+		terminate, err := c.destroyRequestLocked(req.RequestID)
+		c.mu.Unlock()
+		terminate()
+		if err != nil {
 			ctxlog.L(ctx).Errorf("destroying request %d: %v", req.RequestID, err)
 		}
 		return nil
 	}
 
 	ctxlog.L(ctx).Infof("REQUEST_CANCEL(%d, %d)", req.ServerChannelID, req.RequestID)
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if err := c.cancelRequestLocked(req.RequestID); err != nil {
 		ctxlog.L(ctx).Errorf("cancelling request %d: %v", req.RequestID, err)
 	}
@@ -706,5 +1732,10 @@ func (c *serverConn) handleSearchRequest(ctx context.Context, msg *connection.Me
 		return err
 	}
 	ctxlog.L(ctx).Infof("received search request %#v", req)
-	return c.srv.search.Search(ctx, c.Connection, req)
+	s := c.srv.searchServer()
+	if s == nil {
+		ctxlog.L(ctx).Warnf("ignoring search request; not serving on a TCP listener")
+		return nil
+	}
+	return s.Search(ctx, c.Connection, req)
 }
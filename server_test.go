@@ -4,9 +4,23 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/Lexcelon/go-pvaccess/clock"
+	"github.com/Lexcelon/go-pvaccess/internal/connection"
+	"github.com/Lexcelon/go-pvaccess/internal/server/monitor"
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -49,3 +63,1725 @@ func TestConnectionBanner(t *testing.T) {
 		t.Errorf("wrong handshake: got(-)/want(+)\n%s", diff)
 	}
 }
+
+func TestStrict(t *testing.T) {
+	ctx := context.Background()
+	// messages returns the messages sent by a client with send.
+	messages := func(send func(c *connection.Connection)) []byte {
+		var buf bytes.Buffer
+		c := connection.New(&buf, proto.FLAG_FROM_CLIENT)
+		c.Version = 2
+		send(c)
+		return buf.Bytes()
+	}
+	validate := func(c *connection.Connection) {
+		c.SendApp(ctx, proto.APP_CONNECTION_VALIDATION, &proto.ConnectionValidationResponse{AuthNZ: "anonymous"})
+	}
+	validation := messages(validate)
+	echo := func(version, flags byte) []byte {
+		return append(append([]byte(nil), validation...), proto.MAGIC, version, flags, proto.CTRL_ECHO_REQUEST, 0, 0, 0, 0)
+	}
+	for _, test := range []struct {
+		name      string
+		input     []byte
+		violation bool
+		// always is set if a violation disconnects clients even without Strict.
+		always bool
+	}{
+		{"valid", echo(2, proto.FLAG_MSG_CTRL), false, false},
+		{"version 0", echo(0, proto.FLAG_MSG_CTRL), true, false},
+		{"reserved flag", echo(2, proto.FLAG_MSG_CTRL|0x08), true, false},
+		{"server direction", echo(2, proto.FLAG_MSG_CTRL|proto.FLAG_FROM_SERVER), true, false},
+		{"segmented control message", echo(2, proto.FLAG_MSG_CTRL|proto.FLAG_SEGMENT_FIRST), true, false},
+		{"create before validation", messages(func(c *connection.Connection) {
+			c.SendApp(ctx, proto.APP_CHANNEL_CREATE, &proto.CreateChannelRequest{})
+			validate(c)
+		}), true, true},
+		{"validated twice", messages(func(c *connection.Connection) {
+			validate(c)
+			validate(c)
+		}), true, false},
+		{"unknown command", messages(func(c *connection.Connection) {
+			validate(c)
+			c.SendApp(ctx, 0x7f, []byte{})
+		}), true, false},
+	} {
+		for _, strict := range []bool{false, true} {
+			srv := &Server{Strict: strict, HeartbeatInterval: -1}
+			c := srv.newConn(readWriter{bytes.NewReader(test.input), bufio.NewWriter(io.Discard)})
+			err := c.serve(ctx)
+			if got, want := errors.Is(err, connection.ErrProtocolViolation), test.violation && (strict || test.always); got != want {
+				t.Errorf("%s: serve with Strict = %v returned %v, want protocol violation = %v", test.name, strict, err, want)
+			}
+		}
+	}
+}
+
+func TestCreateBeforeValidation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv := &Server{HeartbeatInterval: -1}
+	srv.AddChannelProvider(NewSimpleChannel("test"))
+	clientEnd, serverEnd := net.Pipe()
+	defer clientEnd.Close()
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.newConn(serverEnd).serve(ctx)
+		serverEnd.Close()
+	}()
+	tc := connection.New(clientEnd, proto.FLAG_FROM_CLIENT)
+	tc.Version = 2
+	if _, err := tc.Next(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.SendApp(ctx, proto.APP_CHANNEL_CREATE, &proto.CreateChannelRequest{
+		Channels: []proto.CreateChannelRequest_Channel{{ClientChannelID: 1, ChannelName: "test"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// The server disconnects without creating the channel.
+	if msg, err := tc.Next(ctx); err == nil {
+		t.Errorf("got %s in response to CREATE_CHANNEL before validation, want disconnection", msg.Header.CommandName())
+		clientEnd.Close()
+	}
+	if err := <-done; !errors.Is(err, connection.ErrProtocolViolation) {
+		t.Errorf("serve returned %v, want protocol violation", err)
+	}
+}
+
+// testClient is the client end of an in-memory connection to a server.
+type testClient struct {
+	*connection.Connection
+	t *testing.T
+	// server is the server end of the connection.
+	server *serverConn
+	// nc is the client end of the connection.
+	nc net.Conn
+}
+
+// newTestClient starts serving an in-memory connection on srv and completes the connection handshake.
+func newTestClient(ctx context.Context, t *testing.T, srv *Server) *testClient {
+	t.Helper()
+	return newTestClientQoS(ctx, t, srv, 0)
+}
+
+// newTestClientQoS is like newTestClient, but requests the given connection QoS.
+func newTestClientQoS(ctx context.Context, t *testing.T, srv *Server, qos pvdata.PVShort) *testClient {
+	t.Helper()
+	return newTestClientConn(ctx, t, srv, qos, nil)
+}
+
+// newTestClientConn is like newTestClientQoS, but if wrap is set, the server uses the connection it returns.
+func newTestClientConn(ctx context.Context, t *testing.T, srv *Server, qos pvdata.PVShort, wrap func(net.Conn) net.Conn) *testClient {
+	t.Helper()
+	// Use TCP rather than net.Pipe, which deadlocks when both ends write at once.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	clientEnd, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverEnd, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConn := serverEnd
+	if wrap != nil {
+		serverConn = wrap(serverEnd)
+	}
+	c := srv.newConn(serverConn)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.serve(ctx)
+		c.ops.Wait()
+	}()
+	t.Cleanup(func() {
+		clientEnd.Close()
+		serverEnd.Close()
+		<-done
+	})
+	tc := &testClient{connection.New(clientEnd, proto.FLAG_FROM_CLIENT), t, c, clientEnd}
+	tc.Version = 2
+	var req proto.ConnectionValidationRequest
+	tc.expect(ctx, proto.APP_CONNECTION_VALIDATION, &req)
+	if err := tc.SendApp(ctx, proto.APP_CONNECTION_VALIDATION, &proto.ConnectionValidationResponse{
+		ClientReceiveBufferSize:            pvdata.PVInt(16384),
+		ClientIntrospectionRegistryMaxSize: 0x7fff,
+		ConnectionQos:                      qos,
+		AuthNZ:                             "anonymous",
+	}); err != nil {
+		t.Fatalf("sending connection validation: %v", err)
+	}
+	var validated proto.ConnectionValidated
+	tc.expect(ctx, proto.APP_CONNECTION_VALIDATED, &validated)
+	if validated.Status.Type != pvdata.PVStatus_OK {
+		t.Fatalf("connection not validated: %v", validated.Status)
+	}
+	return tc
+}
+
+// expect reads the next message, checks its command, and decodes it into out.
+func (tc *testClient) expect(ctx context.Context, command pvdata.PVByte, out interface{}) {
+	tc.t.Helper()
+	msg, err := tc.Next(ctx)
+	if err != nil {
+		tc.t.Fatalf("reading message: %v", err)
+	}
+	if msg.Header.MessageCommand != command {
+		tc.t.Fatalf("got message command 0x%x, want 0x%x", msg.Header.MessageCommand, command)
+	}
+	if err := msg.Decode(out); err != nil {
+		tc.t.Fatalf("decoding message 0x%x: %v", command, err)
+	}
+}
+
+// send sends an application message.
+func (tc *testClient) send(ctx context.Context, command pvdata.PVByte, payload interface{}) {
+	tc.t.Helper()
+	if err := tc.SendApp(ctx, command, payload); err != nil {
+		tc.t.Fatalf("sending message 0x%x: %v", command, err)
+	}
+}
+
+// createChannel creates a channel and returns its server channel ID.
+func (tc *testClient) createChannel(ctx context.Context, clientID pvdata.PVInt, name string) pvdata.PVInt {
+	tc.t.Helper()
+	tc.send(ctx, proto.APP_CHANNEL_CREATE, &proto.CreateChannelRequest{
+		Channels: []proto.CreateChannelRequest_Channel{{ClientChannelID: clientID, ChannelName: name}},
+	})
+	var resp proto.CreateChannelResponse
+	tc.expect(ctx, proto.APP_CHANNEL_CREATE, &resp)
+	if resp.Status.Type != pvdata.PVStatus_OK {
+		tc.t.Fatalf("creating channel %q: %v", name, resp.Status)
+	}
+	return resp.ServerChannelID
+}
+
+func TestChannelPut(t *testing.T) {
+	ctx := context.Background()
+	srv := &Server{}
+	ch := NewSimpleChannel("test")
+	value := pvdata.PVLong(1)
+	ch.Set(&value)
+	srv.AddChannelProvider(ch)
+	srv.AddValidator(LimitValidator{Low: 0, High: 10})
+	tc := newTestClient(ctx, t, srv)
+	sid := tc.createChannel(ctx, 1, "test")
+
+	tc.send(ctx, proto.APP_CHANNEL_PUT, &proto.ChannelPutRequest{
+		ServerChannelID: sid,
+		RequestID:       2,
+		Subcommand:      proto.CHANNEL_PUT_INIT,
+		PVRequest:       pvdata.NewPVAny(&struct{}{}),
+	})
+	var init proto.ChannelPutResponseInit
+	tc.expect(ctx, proto.APP_CHANNEL_PUT, &init)
+	if init.Status.Type != pvdata.PVStatus_OK {
+		t.Fatalf("put init failed: %v", init.Status)
+	}
+
+	for _, test := range []struct {
+		put        pvdata.PVLong
+		wantStatus pvdata.PVByte
+		want       pvdata.PVLong
+	}{
+		{5, pvdata.PVStatus_OK, 5},
+		{100, pvdata.PVStatus_ERROR, 5},
+	} {
+		zero, err := init.PVPutStructureIF.Zero()
+		if err != nil {
+			t.Fatalf("creating put structure: %v", err)
+		}
+		putValue := zero.(pvdata.PVStructure)
+		*putValue.Field("value").(*pvdata.PVLong) = test.put
+		tc.send(ctx, proto.APP_CHANNEL_PUT, &proto.ChannelPutRequest{
+			ServerChannelID: sid,
+			RequestID:       2,
+			Value:           pvdata.PVStructureDiff{Value: putValue},
+		})
+		var resp proto.ChannelPutResponse
+		tc.expect(ctx, proto.APP_CHANNEL_PUT, &resp)
+		if resp.Status.Type != test.wantStatus {
+			t.Errorf("put %d: got status %v, want type %d", test.put, resp.Status, test.wantStatus)
+		}
+		if got := *ch.Get().(*pvdata.PVLong); got != test.want {
+			t.Errorf("after put %d: got value %d, want %d", test.put, got, test.want)
+		}
+	}
+}
+
+type pointValue struct {
+	Value struct {
+		X pvdata.PVDouble `pvaccess:"x"`
+		Y pvdata.PVDouble `pvaccess:"y"`
+	} `pvaccess:"value"`
+	Label pvdata.PVString `pvaccess:"label"`
+}
+
+// pointChannel records the puts it receives.
+type pointChannel struct {
+	mu      sync.Mutex
+	value   pointValue
+	changed []string
+}
+
+func (c *pointChannel) Name() string { return "point" }
+
+func (c *pointChannel) ChannelGet(ctx context.Context) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v := c.value
+	return &v, nil
+}
+
+func (c *pointChannel) ChannelPut(ctx context.Context, value pvdata.PVStructure) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.value.Value.X = *value.SubField("value", "x").(*pvdata.PVDouble)
+	c.value.Value.Y = *value.SubField("value", "y").(*pvdata.PVDouble)
+	c.value.Label = *value.Field("label").(*pvdata.PVString)
+	return nil
+}
+
+func (c *pointChannel) CreateChannel(ctx context.Context, name string) (Channel, error) {
+	if name == c.Name() {
+		return c, nil
+	}
+	return nil, nil
+}
+
+// fieldPointChannel is a pointChannel that accepts partial puts.
+type fieldPointChannel struct {
+	pointChannel
+}
+
+func (c *fieldPointChannel) CreateChannel(ctx context.Context, name string) (Channel, error) {
+	if name == c.Name() {
+		return c, nil
+	}
+	return nil, nil
+}
+
+func (c *fieldPointChannel) ChannelPutFields(ctx context.Context, value pvdata.PVStructure, changed []string) error {
+	c.mu.Lock()
+	c.changed = changed
+	c.mu.Unlock()
+	return nil
+}
+
+func TestPartialPut(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	initial := pointValue{Label: "origin"}
+	initial.Value.X, initial.Value.Y = 1, 2
+
+	put := func(t *testing.T, provider ChannelProvider) {
+		t.Helper()
+		srv := &Server{}
+		srv.AddChannelProvider(provider)
+		tc := newTestClient(ctx, t, srv)
+		sid := tc.createChannel(ctx, 1, "point")
+		tc.send(ctx, proto.APP_CHANNEL_PUT, &proto.ChannelPutRequest{
+			ServerChannelID: sid,
+			RequestID:       2,
+			Subcommand:      proto.CHANNEL_PUT_INIT,
+			PVRequest:       pvdata.NewPVAny(&struct{}{}),
+		})
+		var init proto.ChannelPutResponseInit
+		tc.expect(ctx, proto.APP_CHANNEL_PUT, &init)
+		if init.Status.Type != pvdata.PVStatus_OK {
+			t.Fatalf("put init failed: %v", init.Status)
+		}
+		zero, err := init.PVPutStructureIF.Zero()
+		if err != nil {
+			t.Fatal(err)
+		}
+		putValue := zero.(pvdata.PVStructure)
+		*putValue.SubField("value", "x").(*pvdata.PVDouble) = 10
+		// Only value.x, which is bit 2 after the structure and value.
+		tc.send(ctx, proto.APP_CHANNEL_PUT, &proto.ChannelPutRequest{
+			ServerChannelID: sid,
+			RequestID:       2,
+			Value:           pvdata.PVStructureDiff{ChangedBitSet: pvdata.NewBitSetWithBits(2), Value: putValue},
+		})
+		var resp proto.ChannelPutResponse
+		tc.expect(ctx, proto.APP_CHANNEL_PUT, &resp)
+		if resp.Status.Type != pvdata.PVStatus_OK {
+			t.Fatalf("put failed: %v", resp.Status)
+		}
+	}
+
+	t.Run("merged", func(t *testing.T) {
+		ch := &pointChannel{value: initial}
+		put(t, ch)
+		want := initial
+		want.Value.X = 10
+		ch.mu.Lock()
+		defer ch.mu.Unlock()
+		if diff := cmp.Diff(want, ch.value); diff != "" {
+			t.Errorf("value after partial put differs (-want +got):\n%s", diff)
+		}
+	})
+	t.Run("fields", func(t *testing.T) {
+		ch := &fieldPointChannel{pointChannel{value: initial}}
+		put(t, ch)
+		ch.mu.Lock()
+		defer ch.mu.Unlock()
+		if diff := cmp.Diff([]string{"value.x"}, ch.changed); diff != "" {
+			t.Errorf("changed fields differ (-want +got):\n%s", diff)
+		}
+		if diff := cmp.Diff(initial, ch.value); diff != "" {
+			t.Errorf("ChannelPut was called (-want +got):\n%s", diff)
+		}
+	})
+}
+
+// describedChannel is a channel with a numeric value and a description, which accepts partial puts.
+type describedChannel struct {
+	mu      sync.Mutex
+	changed []string
+}
+
+type describedValue struct {
+	Value   pvdata.PVDouble `pvaccess:"value"`
+	Display struct {
+		Description pvdata.PVString `pvaccess:"description"`
+	} `pvaccess:"display"`
+}
+
+func (c *describedChannel) Name() string { return "described" }
+
+func (c *describedChannel) CreateChannel(ctx context.Context, name string) (Channel, error) {
+	if name == c.Name() {
+		return c, nil
+	}
+	return nil, nil
+}
+
+func (c *describedChannel) ChannelGet(ctx context.Context) (interface{}, error) {
+	return &describedValue{Value: 5}, nil
+}
+
+func (c *describedChannel) ChannelPut(ctx context.Context, value pvdata.PVStructure) error {
+	return errors.New("ChannelPut called for a partial put")
+}
+
+func (c *describedChannel) ChannelPutFields(ctx context.Context, value pvdata.PVStructure, changed []string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.changed = changed
+	return nil
+}
+
+func TestPartialPutValidation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv := &Server{}
+	srv.AddValidator(LimitValidator{Low: 1, High: 10})
+	ch := &describedChannel{}
+	srv.AddChannelProvider(ch)
+	tc := newTestClient(ctx, t, srv)
+	sid := tc.createChannel(ctx, 1, "described")
+	tc.send(ctx, proto.APP_CHANNEL_PUT, &proto.ChannelPutRequest{
+		ServerChannelID: sid,
+		RequestID:       2,
+		Subcommand:      proto.CHANNEL_PUT_INIT,
+		PVRequest:       pvdata.NewPVAny(&struct{}{}),
+	})
+	var init proto.ChannelPutResponseInit
+	tc.expect(ctx, proto.APP_CHANNEL_PUT, &init)
+	if init.Status.Type != pvdata.PVStatus_OK {
+		t.Fatalf("put init failed: %v", init.Status)
+	}
+	for _, test := range []struct {
+		name string
+		// bit is the changed field: 1 is value, and 3 is display.description.
+		bit  int
+		want pvdata.PVByte
+	}{
+		{"description", 3, pvdata.PVStatus_OK},
+		{"value", 1, pvdata.PVStatus_ERROR},
+	} {
+		zero, err := init.PVPutStructureIF.Zero()
+		if err != nil {
+			t.Fatal(err)
+		}
+		putValue := zero.(pvdata.PVStructure)
+		*putValue.SubField("display", "description").(*pvdata.PVString) = "pump"
+		tc.send(ctx, proto.APP_CHANNEL_PUT, &proto.ChannelPutRequest{
+			ServerChannelID: sid,
+			RequestID:       2,
+			Value:           pvdata.PVStructureDiff{ChangedBitSet: pvdata.NewBitSetWithBits(test.bit), Value: putValue},
+		})
+		var resp proto.ChannelPutResponse
+		tc.expect(ctx, proto.APP_CHANNEL_PUT, &resp)
+		if resp.Status.Type != test.want {
+			t.Errorf("put of %s: status %v, want type %d", test.name, resp.Status, test.want)
+		}
+	}
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if diff := cmp.Diff([]string{"display.description"}, ch.changed); diff != "" {
+		t.Errorf("changed fields differ (-want +got):\n%s", diff)
+	}
+}
+
+func TestConnections(t *testing.T) {
+	ctx := context.Background()
+	srv := &Server{HeartbeatInterval: 5 * time.Millisecond}
+	srv.AddChannelProvider(NewSimpleChannel("test"))
+	tc := newTestClient(ctx, t, srv)
+	time.Sleep(20 * time.Millisecond)
+	// Reading the response also answers the heartbeats sent so far.
+	tc.createChannel(ctx, 1, "test")
+
+	id := pvdata.PVInt(1)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conns := srv.Connections()
+		if len(conns) != 1 {
+			t.Fatalf("got %d connections, want 1", len(conns))
+		}
+		info := conns[0]
+		if info.AuthNZ != "anonymous" || info.ReceiveBufferSize != 16384 || info.Channels != int(id) || info.Version != 2 {
+			t.Errorf("unexpected connection info %+v", info)
+		}
+		if info.RTT > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("RTT never measured")
+		}
+		// Keep the client reading so it answers heartbeats.
+		id++
+		tc.createChannel(ctx, id, "test")
+	}
+}
+
+func TestGUID(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv := &Server{DisableSearch: true}
+	srv.AddChannelProvider(NewSimpleChannel("test"))
+	guid := srv.GUID()
+	if guid == ([12]byte{}) {
+		t.Fatal("GUID of a Server literal is blank")
+	}
+	if got := srv.GUID(); got != guid {
+		t.Errorf("GUID changed from %x to %x", guid, got)
+	}
+	// Servers on TCP listeners answer searches over their connections, even if they don't serve searches over UDP.
+	srv.startSearch(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5075})
+	tc := newTestClient(ctx, t, srv)
+	tc.send(ctx, proto.APP_SEARCH_REQUEST, &proto.SearchRequest{
+		SearchSequenceID: 1,
+		Flags:            proto.SEARCH_UNICAST,
+		Protocols:        []pvdata.PVString{"tcp"},
+		Channels:         []proto.SearchRequest_Channel{{SearchInstanceID: 1, ChannelName: "test"}},
+	})
+	var resp proto.SearchResponse
+	tc.expect(ctx, proto.APP_SEARCH_RESPONSE, &resp)
+	if resp.GUID != guid {
+		t.Errorf("search response GUID = %x, want %x", resp.GUID, guid)
+	}
+
+	want := GUIDFromHost("ioc1", 5075)
+	if want == ([12]byte{}) || want != GUIDFromHost("ioc1", 5075) || want == GUIDFromHost("ioc1", 5076) {
+		t.Errorf("GUIDFromHost is not stable and specific to the host and port: %x", want)
+	}
+	srv = &Server{}
+	srv.SetGUID(want)
+	if got := srv.GUID(); got != want {
+		t.Errorf("GUID after SetGUID = %x, want %x", got, want)
+	}
+}
+
+func TestHeartbeatClock(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	clk := clock.NewFake(time.Unix(0, 0))
+	srv := &Server{Clock: clk}
+	srv.AddChannelProvider(NewSimpleChannel("test"))
+	tc := newTestClient(ctx, t, srv)
+	if err := clk.BlockUntil(ctx, 1); err != nil {
+		t.Fatalf("heartbeat never started: %v", err)
+	}
+	tc.createChannel(ctx, 1, "test")
+	if rtt := srv.Connections()[0].RTT; rtt != 0 {
+		t.Fatalf("RTT = %v before the first heartbeat", rtt)
+	}
+	clk.Advance(defaultHeartbeatInterval)
+	for id := pvdata.PVInt(2); srv.Connections()[0].RTT == 0; id++ {
+		if ctx.Err() != nil {
+			t.Fatal("RTT never measured")
+		}
+		// Keep the client reading so it answers the heartbeat.
+		tc.createChannel(ctx, id, "test")
+	}
+}
+
+// blockingRPC is a channel whose first RPC blocks until its context is cancelled.
+type blockingRPC struct {
+	calls     int
+	started   chan struct{}
+	cancelled chan struct{}
+}
+
+func (b *blockingRPC) Name() string { return "rpc" }
+
+func (b *blockingRPC) CreateChannel(ctx context.Context, name string) (Channel, error) {
+	if name == b.Name() {
+		return b, nil
+	}
+	return nil, nil
+}
+
+func (b *blockingRPC) ChannelRPC(ctx context.Context, req pvdata.PVStructure) (interface{}, error) {
+	b.calls++
+	if b.calls > 1 {
+		return &struct{}{}, nil
+	}
+	close(b.started)
+	<-ctx.Done()
+	close(b.cancelled)
+	return &struct{}{}, nil
+}
+
+func TestRequestCancel(t *testing.T) {
+	ctx := context.Background()
+	srv := &Server{}
+	ch := &blockingRPC{started: make(chan struct{}), cancelled: make(chan struct{})}
+	srv.AddChannelProvider(ch)
+	tc := newTestClient(ctx, t, srv)
+	sid := tc.createChannel(ctx, 1, "rpc")
+
+	const id = 5
+	tc.send(ctx, proto.APP_CHANNEL_RPC, &proto.ChannelRPCRequest{
+		ServerChannelID: sid,
+		RequestID:       id,
+		Subcommand:      proto.CHANNEL_RPC_INIT,
+		PVRequest:       pvdata.NewPVAny(&struct{}{}),
+	})
+	var init proto.ChannelRPCResponseInit
+	tc.expect(ctx, proto.APP_CHANNEL_RPC, &init)
+	if init.Status.Type != pvdata.PVStatus_OK {
+		t.Fatalf("RPC init failed: %v", init.Status)
+	}
+	exec := &proto.ChannelRPCRequest{
+		ServerChannelID: sid,
+		RequestID:       id,
+		PVRequest:       pvdata.NewPVAny(&struct{}{}),
+	}
+	tc.send(ctx, proto.APP_CHANNEL_RPC, exec)
+	<-ch.started
+	tc.send(ctx, proto.APP_REQUEST_CANCEL, &proto.CancelDestroyRequest{ServerChannelID: sid, RequestID: id})
+	select {
+	case <-ch.cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("provider context not cancelled")
+	}
+
+	// Once the cancelled RPC has finished, the request can be used again.
+	for {
+		tc.server.mu.Lock()
+		status := tc.server.requests[id].status
+		tc.server.mu.Unlock()
+		if status == READY {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	tc.send(ctx, proto.APP_CHANNEL_RPC, exec)
+	// The cancelled RPC's result was discarded, so the next response is for the second RPC.
+	var resp proto.ChannelRPCResponse
+	tc.expect(ctx, proto.APP_CHANNEL_RPC, &resp)
+	if resp.Status.Type != pvdata.PVStatus_OK {
+		t.Errorf("second RPC failed: %v", resp.Status)
+	}
+}
+
+// watchedChannel records when it starts and stops being used.
+type watchedChannel struct {
+	*SimpleChannel
+	events chan string
+}
+
+func (w *watchedChannel) CreateChannel(ctx context.Context, name string) (Channel, error) {
+	if w.Name() == name {
+		return w, nil
+	}
+	return nil, nil
+}
+func (w *watchedChannel) ChannelConnected(ctx context.Context) {
+	w.events <- "connected"
+}
+func (w *watchedChannel) ChannelDisconnected(ctx context.Context) {
+	w.events <- "disconnected"
+}
+
+func TestChannelLifecycle(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv := &Server{}
+	ch := &watchedChannel{NewSimpleChannel("test"), make(chan string, 10)}
+	srv.AddChannelProvider(ch)
+	expectEvent := func(want string) {
+		t.Helper()
+		select {
+		case got := <-ch.events:
+			if got != want {
+				t.Fatalf("got event %q, want %q", got, want)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+	expectNone := func() {
+		t.Helper()
+		select {
+		case got := <-ch.events:
+			t.Fatalf("got unexpected event %q", got)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	tc1 := newTestClient(ctx, t, srv)
+	sid := tc1.createChannel(ctx, 1, "test")
+	expectEvent("connected")
+	tc2 := newTestClient(ctx, t, srv)
+	tc2.createChannel(ctx, 1, "test")
+	lc, err := srv.LocalChannel(ctx, "test")
+	if err != nil || lc == nil {
+		t.Fatalf("LocalChannel = %v, %v", lc, err)
+	}
+	expectNone()
+
+	tc1.send(ctx, proto.APP_CHANNEL_DESTROY, &proto.DestroyChannel{ServerChannelID: sid, ClientChannelID: 1})
+	tc1.expect(ctx, proto.APP_CHANNEL_DESTROY, &proto.DestroyChannel{})
+	lc.Close()
+	expectNone()
+
+	// The last client leaves by disconnecting.
+	tc2.nc.Close()
+	expectEvent("disconnected")
+
+	tc1.createChannel(ctx, 2, "test")
+	expectEvent("connected")
+}
+
+func TestCreateChannels(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv := &Server{}
+	srv.AddChannelProvider(NewSimpleChannel("test"))
+	tc := newTestClient(ctx, t, srv)
+	tc.send(ctx, proto.APP_CHANNEL_CREATE, &proto.CreateChannelRequest{
+		Channels: []proto.CreateChannelRequest_Channel{
+			{ClientChannelID: 1, ChannelName: "test"},
+			{ClientChannelID: 2, ChannelName: "missing"},
+			{ClientChannelID: 3, ChannelName: "test"},
+		},
+	})
+	for _, want := range []struct {
+		id pvdata.PVInt
+		ok bool
+	}{{1, true}, {2, false}, {3, true}} {
+		var resp proto.CreateChannelResponse
+		tc.expect(ctx, proto.APP_CHANNEL_CREATE, &resp)
+		if resp.ClientChannelID != want.id {
+			t.Fatalf("got response for client channel %d, want %d", resp.ClientChannelID, want.id)
+		}
+		if ok := resp.Status.Type == pvdata.PVStatus_OK; ok != want.ok {
+			t.Errorf("channel %d: status %v, want OK = %v", want.id, resp.Status, want.ok)
+		}
+	}
+}
+
+func TestChannelIDs(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv := &Server{}
+	srv.AddChannelProvider(NewSimpleChannel("test"))
+	tc := newTestClient(ctx, t, srv)
+	sid1 := tc.createChannel(ctx, 7, "test")
+	sid2 := tc.createChannel(ctx, 8, "test")
+	if sid1 == sid2 {
+		t.Fatalf("channels got the same server channel ID %d", sid1)
+	}
+
+	// A destroy that names the other channel's client ID is ignored.
+	tc.send(ctx, proto.APP_CHANNEL_DESTROY, &proto.DestroyChannel{ServerChannelID: sid1, ClientChannelID: 8})
+	tc.send(ctx, proto.APP_CHANNEL_DESTROY, &proto.DestroyChannel{ServerChannelID: sid1, ClientChannelID: 7})
+	var resp proto.DestroyChannel
+	tc.expect(ctx, proto.APP_CHANNEL_DESTROY, &resp)
+	if resp.ServerChannelID != sid1 || resp.ClientChannelID != 7 {
+		t.Errorf("destroy response = %+v, want server channel %d, client channel 7", resp, sid1)
+	}
+
+	// The client ID can be reused once its channel is destroyed, but not while it is in use.
+	if sid := tc.createChannel(ctx, 7, "test"); sid == sid2 {
+		t.Errorf("new channel reused server channel ID %d", sid)
+	}
+	tc.send(ctx, proto.APP_CHANNEL_CREATE, &proto.CreateChannelRequest{
+		Channels: []proto.CreateChannelRequest_Channel{{ClientChannelID: 8, ChannelName: "test"}},
+	})
+	var created proto.CreateChannelResponse
+	tc.expect(ctx, proto.APP_CHANNEL_CREATE, &created)
+	if created.Status.Type == pvdata.PVStatus_OK {
+		t.Error("creating a channel with a client ID in use succeeded")
+	}
+}
+
+func TestAccessRights(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv := &Server{}
+	ch := NewSimpleChannel("readonly")
+	value := pvdata.PVLong(1)
+	ch.Set(&value)
+	srv.AddChannelProvider(ch)
+	srv.AddChannelProvider(NewSimpleChannel("writable"))
+	srv.AddAccessController(AccessControllerFunc(func(ctx context.Context, channel string) AccessRights {
+		if channel == "readonly" {
+			return AccessRead
+		}
+		return AccessReadWrite
+	}))
+	tc := newTestClient(ctx, t, srv)
+	var sid pvdata.PVInt
+	for i, want := range []struct {
+		name   string
+		rights AccessRights
+	}{{"readonly", AccessRead}, {"writable", AccessReadWrite}} {
+		tc.send(ctx, proto.APP_CHANNEL_CREATE, &proto.CreateChannelRequest{
+			Channels: []proto.CreateChannelRequest_Channel{{ClientChannelID: pvdata.PVInt(i + 1), ChannelName: want.name}},
+		})
+		var resp proto.CreateChannelResponse
+		tc.expect(ctx, proto.APP_CHANNEL_CREATE, &resp)
+		if got := AccessRights(resp.AccessRights); got != want.rights {
+			t.Errorf("channel %q: access rights %b, want %b", want.name, got, want.rights)
+		}
+		if i == 0 {
+			sid = resp.ServerChannelID
+		}
+	}
+
+	tc.send(ctx, proto.APP_CHANNEL_PUT, &proto.ChannelPutRequest{
+		ServerChannelID: sid,
+		RequestID:       3,
+		Subcommand:      proto.CHANNEL_PUT_INIT,
+		PVRequest:       pvdata.NewPVAny(&struct{}{}),
+	})
+	var init proto.ChannelPutResponseInit
+	tc.expect(ctx, proto.APP_CHANNEL_PUT, &init)
+	if init.Status.Type != pvdata.PVStatus_ERROR {
+		t.Errorf("put init on read-only channel: status %v, want error", init.Status)
+	}
+}
+
+func TestNoAccess(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv := &Server{}
+	ch := echoRPC{NewSimpleChannel("hidden")}
+	value := pvdata.PVLong(1)
+	ch.Set(&value)
+	srv.AddChannelProvider(ch)
+	srv.AddAccessController(AccessControllerFunc(func(ctx context.Context, channel string) AccessRights {
+		return AccessNone
+	}))
+	tc := newTestClient(ctx, t, srv)
+	sid := tc.createChannel(ctx, 1, "hidden")
+	args := pvdata.NewPVAny(&struct{}{})
+	for i, test := range []struct {
+		command pvdata.PVByte
+		request interface{}
+	}{
+		{proto.APP_CHANNEL_GET, &proto.ChannelGetRequest{ServerChannelID: sid, RequestID: 2, Subcommand: proto.CHANNEL_GET_INIT, PVRequest: args}},
+		{proto.APP_CHANNEL_MONITOR, &proto.ChannelMonitorRequest{ServerChannelID: sid, RequestID: 3, Subcommand: proto.CHANNEL_MONITOR_INIT, PVRequest: args}},
+		{proto.APP_CHANNEL_RPC, &proto.ChannelRPCRequest{ServerChannelID: sid, RequestID: 4, Subcommand: proto.CHANNEL_RPC_INIT, PVRequest: args}},
+	} {
+		tc.send(ctx, test.command, test.request)
+		var resp proto.ChannelResponseError
+		tc.expect(ctx, test.command, &resp)
+		if resp.RequestID != pvdata.PVInt(i+2) || resp.Status.Type != pvdata.PVStatus_ERROR {
+			t.Errorf("INIT 0x%x of channel without access: request %d, status %v, want an error for request %d", test.command, resp.RequestID, resp.Status, i+2)
+		}
+	}
+
+	lc, err := srv.LocalChannel(ctx, "hidden")
+	if err != nil || lc == nil {
+		t.Fatalf("LocalChannel = %v, %v", lc, err)
+	}
+	defer lc.Close()
+	if _, err := lc.Get(ctx, pvdata.PVStructure{}); err == nil {
+		t.Error("local Get of channel without access succeeded")
+	}
+	if _, err := lc.Monitor(ctx, pvdata.PVStructure{}); err == nil {
+		t.Error("local Monitor of channel without access succeeded")
+	}
+	if _, err := lc.RPC(ctx, pvdata.PVStructure{}, pvdata.PVStructure{}); err == nil {
+		t.Error("local RPC of channel without access succeeded")
+	}
+}
+
+func TestLocalChannelChecks(t *testing.T) {
+	ctx := context.Background()
+	srv := &Server{}
+	for _, name := range []string{"readonly", "writable"} {
+		ch := NewSimpleChannel(name)
+		value := pvdata.PVLong(1)
+		ch.Set(&value)
+		srv.AddChannelProvider(ch)
+	}
+	srv.AddAccessController(AccessControllerFunc(func(ctx context.Context, channel string) AccessRights {
+		if channel == "readonly" {
+			return AccessRead
+		}
+		return AccessReadWrite
+	}))
+	srv.AddValidator(LimitValidator{Low: 0, High: 10})
+	for _, test := range []struct {
+		channel string
+		value   pvdata.PVLong
+		ok      bool
+	}{
+		{"readonly", 5, false},
+		{"writable", 5, true},
+		{"writable", 100, false},
+	} {
+		lc, err := srv.LocalChannel(ctx, test.channel)
+		if err != nil || lc == nil {
+			t.Fatalf("LocalChannel(%q) = %v, %v", test.channel, lc, err)
+		}
+		value, err := pvdata.NewPVStructure(&struct {
+			Value pvdata.PVLong `pvaccess:"value"`
+		}{test.value})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := lc.Put(ctx, pvdata.PVStructure{}, value, nil); (err == nil) != test.ok {
+			t.Errorf("Put(%d) to %s returned %v, want success = %v", test.value, test.channel, err, test.ok)
+		}
+		lc.Close()
+	}
+}
+
+func TestConnectionQoS(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, test := range []struct {
+		qos  pvdata.PVShort
+		want int
+	}{
+		{0, 0},
+		{proto.QOS_LOW_LATENCY | 42, 42},
+		{proto.QOS_PRIORITY_MASK, proto.QOS_PRIORITY_MAX},
+	} {
+		srv := &Server{}
+		srv.AddChannelProvider(NewSimpleChannel("test"))
+		priorities := make(chan int, 1)
+		srv.AddInterceptor(func(ctx context.Context, op *Op, next OpHandler) (interface{}, error) {
+			if peer, ok := PeerFromContext(ctx); ok {
+				priorities <- peer.Priority
+			}
+			return next(ctx, op)
+		})
+		tc := newTestClientQoS(ctx, t, srv, test.qos)
+		tc.createChannel(ctx, 1, "test")
+		if got := <-priorities; got != test.want {
+			t.Errorf("QoS 0x%x: provider saw priority %d, want %d", test.qos, got, test.want)
+		}
+		if conns := srv.Connections(); len(conns) != 1 || conns[0].Peer.Priority != test.want {
+			t.Errorf("QoS 0x%x: connections = %+v, want one with priority %d", test.qos, conns, test.want)
+		}
+	}
+}
+
+func TestDebugStatus(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cause := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	for _, debug := range []bool{false, true} {
+		srv := &Server{DebugStatus: debug}
+		srv.AddInterceptor(func(ctx context.Context, op *Op, next OpHandler) (interface{}, error) {
+			return nil, fmt.Errorf("looking up %s: %w", op.ChannelName, cause)
+		})
+		tc := newTestClient(ctx, t, srv)
+		tc.send(ctx, proto.APP_CHANNEL_CREATE, &proto.CreateChannelRequest{
+			Channels: []proto.CreateChannelRequest_Channel{{ClientChannelID: 1, ChannelName: "test"}},
+		})
+		var resp proto.CreateChannelResponse
+		tc.expect(ctx, proto.APP_CHANNEL_CREATE, &resp)
+		want := ""
+		if debug {
+			want = "*fmt.wrapError: looking up test: dial tcp: connection refused\n" +
+				"  *net.OpError: dial tcp: connection refused\n" +
+				"    *errors.errorString: connection refused"
+		}
+		if got := string(resp.Status.CallTree); got != want {
+			t.Errorf("DebugStatus %v: call tree %q, want %q", debug, got, want)
+		}
+	}
+}
+
+func TestWrappedStatus(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv := &Server{}
+	srv.AddInterceptor(func(ctx context.Context, op *Op, next OpHandler) (interface{}, error) {
+		return nil, fmt.Errorf("looking up %s: %w", op.ChannelName, pvdata.Error("device offline"))
+	})
+	tc := newTestClient(ctx, t, srv)
+	tc.send(ctx, proto.APP_CHANNEL_CREATE, &proto.CreateChannelRequest{
+		Channels: []proto.CreateChannelRequest_Channel{{ClientChannelID: 1, ChannelName: "test"}},
+	})
+	var resp proto.CreateChannelResponse
+	tc.expect(ctx, proto.APP_CHANNEL_CREATE, &resp)
+	if diff := cmp.Diff(pvdata.Error("device offline"), resp.Status); diff != "" {
+		t.Errorf("status differs (-want +got):\n%s", diff)
+	}
+}
+
+func TestStuckRequests(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv := &Server{StuckRequestThreshold: 20 * time.Millisecond, CancelStuckRequests: true}
+	ch := &blockingRPC{started: make(chan struct{}), cancelled: make(chan struct{})}
+	srv.AddChannelProvider(ch)
+	tc := newTestClient(ctx, t, srv)
+	sid := tc.createChannel(ctx, 1, "rpc")
+
+	const id = 5
+	tc.send(ctx, proto.APP_CHANNEL_RPC, &proto.ChannelRPCRequest{
+		ServerChannelID: sid,
+		RequestID:       id,
+		Subcommand:      proto.CHANNEL_RPC_INIT,
+		PVRequest:       pvdata.NewPVAny(&struct{}{}),
+	})
+	var init proto.ChannelRPCResponseInit
+	tc.expect(ctx, proto.APP_CHANNEL_RPC, &init)
+	tc.send(ctx, proto.APP_CHANNEL_RPC, &proto.ChannelRPCRequest{
+		ServerChannelID: sid,
+		RequestID:       id,
+		PVRequest:       pvdata.NewPVAny(&struct{}{}),
+	})
+	<-ch.started
+	conns := srv.Connections()
+	if len(conns) != 1 || len(conns[0].InProgress) != 1 {
+		t.Fatalf("connections = %+v, want one with one request in progress", conns)
+	}
+	if got := conns[0].InProgress[0]; got.RequestID != id || got.Channel != "rpc" || got.Op != "RPC" {
+		t.Errorf("request in progress = %+v, want RPC %d on rpc", got, id)
+	}
+
+	// The watchdog cancels the RPC, and the client is told why.
+	var resp proto.ChannelRPCResponse
+	tc.expect(ctx, proto.APP_CHANNEL_RPC, &resp)
+	if resp.Status.Type != pvdata.PVStatus_ERROR || !strings.Contains(string(resp.Status.Message), "cancelled after") {
+		t.Errorf("stuck RPC returned status %v, want cancellation error", resp.Status)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv := &Server{MetricsPrefix: "SRV:"}
+	srv.AddChannelProvider(NewSimpleChannel("test"))
+	tc := newTestClient(ctx, t, srv)
+	tc.send(ctx, proto.APP_CHANNEL_CREATE, &proto.CreateChannelRequest{
+		Channels: []proto.CreateChannelRequest_Channel{{ClientChannelID: 1, ChannelName: "test"}},
+	})
+	var resp proto.CreateChannelResponse
+	tc.expect(ctx, proto.APP_CHANNEL_CREATE, &resp)
+
+	var names []string
+	for _, p := range srv.ChannelProviders() {
+		if l, ok := p.(ChannelLister); ok {
+			list, err := l.ChannelList(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, list...)
+		}
+	}
+	want := map[string]bool{"SRV:connCount": true, "SRV:channelCount": true, "SRV:rttMax": true}
+	for _, name := range names {
+		delete(want, name)
+	}
+	if len(want) > 0 {
+		t.Errorf("channel list %v is missing %v", names, want)
+	}
+
+	for name, want := range map[string]int64{"SRV:connCount": 1, "SRV:channelCount": 1} {
+		lc, err := srv.LocalChannel(ctx, name)
+		if err != nil || lc == nil {
+			t.Fatalf("LocalChannel(%q) = %v, %v", name, lc, err)
+		}
+		req, err := pvdata.NewPVStructure(&struct{}{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := lc.Get(ctx, req)
+		lc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := got.ToMap()
+		if m["value"] != want {
+			t.Errorf("%s = %v, want %d", name, m["value"], want)
+		}
+		if _, ok := m["display"]; !ok {
+			t.Errorf("%s has no display metadata: %v", name, m)
+		}
+	}
+	if lc, err := srv.LocalChannel(ctx, "SRV:bogus"); err != nil || lc != nil {
+		t.Errorf("LocalChannel(SRV:bogus) = %v, %v, want nil", lc, err)
+	}
+}
+
+func TestHealthProbes(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv := &Server{DisableSearch: true}
+	hs := httptest.NewServer(srv.HealthHandler())
+	defer hs.Close()
+	probe := func(path string, wantCode int) HealthStatus {
+		t.Helper()
+		resp, err := http.Get(hs.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var st HealthStatus
+		if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != wantCode {
+			t.Errorf("%s: status %d, want %d (%+v)", path, resp.StatusCode, wantCode, st)
+		}
+		return st
+	}
+	probe("/readyz", http.StatusServiceUnavailable)
+	probe("/livez", http.StatusOK)
+
+	srv.AddChannelProvider(NewSimpleChannel("test"))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveCtx, stop := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(serveCtx, ln) }()
+	select {
+	case <-srv.Ready():
+	case <-ctx.Done():
+		t.Fatal("server never became ready")
+	}
+	st := probe("/readyz", http.StatusOK)
+	if len(st.Listening) != 1 || st.Listening[0] != ln.Addr().String() || st.Providers != 1 || st.Beacons != nil {
+		t.Errorf("status = %+v, want listening on %v with 1 provider and no beacons", st, ln.Addr())
+	}
+
+	stop()
+	<-done
+	probe("/readyz", http.StatusServiceUnavailable)
+	probe("/livez", http.StatusServiceUnavailable)
+}
+
+func TestOpStats(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv := &Server{SlowOpThreshold: time.Nanosecond}
+	ch := NewSimpleChannel("test")
+	value := pvdata.PVDouble(1)
+	ch.Set(&value)
+	srv.AddChannelProvider(ch)
+	tc := newTestClient(ctx, t, srv)
+	sid := tc.createChannel(ctx, 1, "test")
+
+	const id = 2
+	tc.send(ctx, proto.APP_CHANNEL_GET, &proto.ChannelGetRequest{
+		ServerChannelID: sid,
+		RequestID:       id,
+		Subcommand:      proto.CHANNEL_GET_INIT,
+		PVRequest:       pvdata.NewPVAny(&struct{}{}),
+	})
+	var init proto.ChannelGetResponseInit
+	tc.expect(ctx, proto.APP_CHANNEL_GET, &init)
+	tc.send(ctx, proto.APP_CHANNEL_GET, &proto.ChannelGetRequest{
+		ServerChannelID: sid,
+		RequestID:       id,
+	})
+	var resp proto.ChannelResponseError
+	tc.expect(ctx, proto.APP_CHANNEL_GET, &resp)
+	if resp.Status.Type != pvdata.PVStatus_OK {
+		t.Fatalf("get failed: %v", resp.Status)
+	}
+
+	stats := srv.OpStats()
+	for kind, want := range map[OpKind]uint64{OpCreateChannel: 1, OpGet: 2} {
+		s := stats[kind]
+		if s.Duration.Count != want || s.RequestSize.Count != want || s.ResponseSize.Count != want {
+			t.Errorf("%s: %d durations, %d requests, %d responses, want %d of each", kind, s.Duration.Count, s.RequestSize.Count, s.ResponseSize.Count, want)
+		}
+		var n uint64
+		for _, c := range s.ResponseSize.Counts {
+			n += c
+		}
+		if n != s.ResponseSize.Count || s.ResponseSize.Sum == 0 {
+			t.Errorf("%s: response sizes %+v don't add up", kind, s.ResponseSize)
+		}
+	}
+}
+
+func TestPipelinedGet(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv := &Server{}
+	ch := NewSimpleChannel("test")
+	value := pvdata.PVDouble(1)
+	ch.Set(&value)
+	srv.AddChannelProvider(ch)
+	srv.AddInterceptor(func(ctx context.Context, op *Op, next OpHandler) (interface{}, error) {
+		if op.Init {
+			// Give the pipelined Get a chance to overtake INIT.
+			time.Sleep(10 * time.Millisecond)
+		}
+		return next(ctx, op)
+	})
+	tc := newTestClient(ctx, t, srv)
+	sid := tc.createChannel(ctx, 1, "test")
+
+	const id = 2
+	tc.send(ctx, proto.APP_CHANNEL_GET, &proto.ChannelGetRequest{
+		ServerChannelID: sid,
+		RequestID:       id,
+		Subcommand:      proto.CHANNEL_GET_INIT,
+		PVRequest:       pvdata.NewPVAny(&struct{}{}),
+	})
+	tc.send(ctx, proto.APP_CHANNEL_GET, &proto.ChannelGetRequest{
+		ServerChannelID: sid,
+		RequestID:       id,
+		Subcommand:      proto.CHANNEL_GET_DESTROY,
+	})
+	var init proto.ChannelGetResponseInit
+	tc.expect(ctx, proto.APP_CHANNEL_GET, &init)
+	if init.Status.Type != pvdata.PVStatus_OK {
+		t.Fatalf("INIT failed: %v", init.Status)
+	}
+	var resp proto.ChannelResponseError
+	tc.expect(ctx, proto.APP_CHANNEL_GET, &resp)
+	if resp.Status.Type != pvdata.PVStatus_OK {
+		t.Errorf("pipelined Get failed: %v", resp.Status)
+	}
+}
+
+// failingGet is a channel whose Gets fail, recording the operation they were made for.
+type failingGet struct {
+	opID chan string
+}
+
+func (failingGet) Name() string { return "fail" }
+
+func (f failingGet) CreateChannel(ctx context.Context, name string) (Channel, error) {
+	if name == f.Name() {
+		return f, nil
+	}
+	return nil, nil
+}
+
+func (f failingGet) ChannelGet(ctx context.Context) (interface{}, error) {
+	id, _ := OperationIDFromContext(ctx)
+	f.opID <- id
+	return nil, errors.New("out of order")
+}
+
+// TestGetInitIntercepted checks that the value read to describe a Get's structure is read under the INIT operation.
+func TestGetInitIntercepted(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv := &Server{}
+	ch := failingGet{make(chan string, 1)}
+	srv.AddChannelProvider(ch)
+	initErrs := make(chan error, 1)
+	var initID string
+	srv.AddInterceptor(func(ctx context.Context, op *Op, next OpHandler) (interface{}, error) {
+		result, err := next(ctx, op)
+		if op.Kind == OpGet && op.Init {
+			initID = op.ID
+			initErrs <- err
+		}
+		return result, err
+	})
+	tc := newTestClient(ctx, t, srv)
+	sid := tc.createChannel(ctx, 1, "fail")
+	tc.send(ctx, proto.APP_CHANNEL_GET, &proto.ChannelGetRequest{
+		ServerChannelID: sid,
+		RequestID:       2,
+		Subcommand:      proto.CHANNEL_GET_INIT,
+		PVRequest:       pvdata.NewPVAny(&struct{}{}),
+	})
+	var resp proto.ChannelResponseError
+	tc.expect(ctx, proto.APP_CHANNEL_GET, &resp)
+	if resp.Status.Type == pvdata.PVStatus_OK {
+		t.Error("INIT of a channel whose Gets fail succeeded")
+	}
+	if err := <-initErrs; err == nil {
+		t.Error("interceptor saw INIT succeed")
+	}
+	if id := <-ch.opID; id != initID {
+		t.Errorf("value was read by operation %q, want INIT %q", id, initID)
+	}
+}
+
+// echoRPC is a channel whose RPC service returns its arguments.
+type echoRPC struct {
+	*SimpleChannel
+}
+
+func (e echoRPC) CreateChannel(ctx context.Context, name string) (Channel, error) {
+	if name == e.Name() {
+		return e, nil
+	}
+	return nil, nil
+}
+
+func (echoRPC) ChannelRPC(ctx context.Context, args pvdata.PVStructure) (interface{}, error) {
+	return args, nil
+}
+
+// TestPipelinedRequests checks that requests of every kind sent right after their INIT wait for it.
+func TestPipelinedRequests(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv := &Server{}
+	ch := echoRPC{NewSimpleChannel("test")}
+	value := pvdata.PVLong(1)
+	ch.Set(&value)
+	srv.AddChannelProvider(ch)
+	srv.AddInterceptor(func(ctx context.Context, op *Op, next OpHandler) (interface{}, error) {
+		if op.Init {
+			// Give the pipelined request a chance to overtake INIT.
+			time.Sleep(10 * time.Millisecond)
+		}
+		return next(ctx, op)
+	})
+	tc := newTestClient(ctx, t, srv)
+	sid := tc.createChannel(ctx, 1, "test")
+	args := pvdata.NewPVAny(&struct{}{})
+	// header reads the request ID and subcommand of the next response, and checks that it is for id.
+	header := func(command pvdata.PVByte, id pvdata.PVInt) *connection.Message {
+		t.Helper()
+		msg, err := tc.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var h struct {
+			RequestID  pvdata.PVInt
+			Subcommand pvdata.PVByte
+		}
+		if err := msg.Peek(&h); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Header.MessageCommand != command || h.RequestID != id {
+			t.Fatalf("got command 0x%x for request %d, want 0x%x for request %d", msg.Header.MessageCommand, h.RequestID, command, id)
+		}
+		return msg
+	}
+	status := func(msg *connection.Message) pvdata.PVStatus {
+		t.Helper()
+		var resp proto.ChannelResponseError
+		if err := msg.Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Status
+	}
+
+	t.Run("put", func(t *testing.T) {
+		tc.send(ctx, proto.APP_CHANNEL_PUT, &proto.ChannelPutRequest{
+			ServerChannelID: sid,
+			RequestID:       2,
+			Subcommand:      proto.CHANNEL_PUT_INIT,
+			PVRequest:       pvdata.NewPVAny(&struct{}{}),
+		})
+		tc.send(ctx, proto.APP_CHANNEL_PUT, &proto.ChannelPutRequest{
+			ServerChannelID: sid,
+			RequestID:       2,
+			Subcommand:      proto.CHANNEL_PUT_GET,
+		})
+		if st := status(header(proto.APP_CHANNEL_PUT, 2)); st.Type != pvdata.PVStatus_OK {
+			t.Fatalf("INIT failed: %v", st)
+		}
+		if st := status(header(proto.APP_CHANNEL_PUT, 2)); st.Type != pvdata.PVStatus_OK {
+			t.Errorf("pipelined PUT_GET failed: %v", st)
+		}
+	})
+	t.Run("rpc", func(t *testing.T) {
+		tc.send(ctx, proto.APP_CHANNEL_RPC, &proto.ChannelRPCRequest{
+			ServerChannelID: sid,
+			RequestID:       3,
+			Subcommand:      proto.CHANNEL_RPC_INIT,
+			PVRequest:       args,
+		})
+		tc.send(ctx, proto.APP_CHANNEL_RPC, &proto.ChannelRPCRequest{
+			ServerChannelID: sid,
+			RequestID:       3,
+			PVRequest:       args,
+		})
+		if st := status(header(proto.APP_CHANNEL_RPC, 3)); st.Type != pvdata.PVStatus_OK {
+			t.Fatalf("INIT failed: %v", st)
+		}
+		if st := status(header(proto.APP_CHANNEL_RPC, 3)); st.Type != pvdata.PVStatus_OK {
+			t.Errorf("pipelined RPC failed: %v", st)
+		}
+	})
+	t.Run("monitor", func(t *testing.T) {
+		tc.send(ctx, proto.APP_CHANNEL_MONITOR, &proto.ChannelMonitorRequest{
+			ServerChannelID: sid,
+			RequestID:       4,
+			Subcommand:      proto.CHANNEL_MONITOR_INIT,
+			PVRequest:       args,
+		})
+		tc.send(ctx, proto.APP_CHANNEL_MONITOR, &proto.ChannelMonitorRequest{
+			ServerChannelID: sid,
+			RequestID:       4,
+			Subcommand:      proto.CHANNEL_MONITOR_SUBSCRIPTION | proto.CHANNEL_MONITOR_SUBSCRIPTION_RUN,
+		})
+		if st := status(header(proto.APP_CHANNEL_MONITOR, 4)); st.Type != pvdata.PVStatus_OK {
+			t.Fatalf("INIT failed: %v", st)
+		}
+		// A failed start would be answered with an error; a successful one starts the updates.
+		msg := header(proto.APP_CHANNEL_MONITOR, 4)
+		var update struct {
+			RequestID  pvdata.PVInt
+			Subcommand pvdata.PVByte
+		}
+		if err := msg.Peek(&update); err != nil {
+			t.Fatal(err)
+		}
+		if update.Subcommand != 0 {
+			t.Errorf("got response with subcommand 0x%x (status %v), want an update", update.Subcommand, status(msg))
+		}
+	})
+}
+
+func TestOperationFailure(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv := &Server{}
+	ch := &blockingRPC{started: make(chan struct{}), cancelled: make(chan struct{})}
+	srv.AddChannelProvider(ch)
+	tc := newTestClient(ctx, t, srv)
+	sid := tc.createChannel(ctx, 1, "rpc")
+	tc.send(ctx, proto.APP_CHANNEL_RPC, &proto.ChannelRPCRequest{
+		ServerChannelID: sid,
+		RequestID:       5,
+		Subcommand:      proto.CHANNEL_RPC_INIT,
+		PVRequest:       pvdata.NewPVAny(&struct{}{}),
+	})
+	var init proto.ChannelRPCResponseInit
+	tc.expect(ctx, proto.APP_CHANNEL_RPC, &init)
+	tc.send(ctx, proto.APP_CHANNEL_RPC, &proto.ChannelRPCRequest{
+		ServerChannelID: sid,
+		RequestID:       5,
+		PVRequest:       pvdata.NewPVAny(&struct{}{}),
+	})
+	<-ch.started
+
+	// An operation that fails without reporting it, e.g. because its response couldn't be sent,
+	// neither cancels the others nor closes the connection.
+	tc.server.goOp(ctx, proto.APP_CHANNEL_GET, func() error {
+		return errors.New("response not sent")
+	})
+	select {
+	case <-ch.cancelled:
+		t.Fatal("RPC cancelled by another operation's failure")
+	case <-time.After(50 * time.Millisecond):
+	}
+	tc.createChannel(ctx, 2, "rpc")
+}
+
+func TestShareMonitors(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv := &Server{ShareMonitors: true}
+	ch := NewSimpleChannel("test")
+	value := pvdata.PVDouble(1)
+	ch.Set(&value)
+	srv.AddChannelProvider(ch)
+	for i := 0; i < 2; i++ {
+		tc := newTestClient(ctx, t, srv)
+		sid := tc.createChannel(ctx, 1, "test")
+		tc.send(ctx, proto.APP_CHANNEL_MONITOR, &proto.ChannelMonitorRequest{
+			ServerChannelID: sid,
+			RequestID:       2,
+			Subcommand:      proto.CHANNEL_MONITOR_INIT,
+			PVRequest:       pvdata.NewPVAny(&struct{}{}),
+		})
+		var init proto.ChannelMonitorResponseInit
+		tc.expect(ctx, proto.APP_CHANNEL_MONITOR, &init)
+		if init.Status.Type != pvdata.PVStatus_OK {
+			t.Fatalf("client %d: monitor INIT failed: %v", i, init.Status)
+		}
+	}
+	req, err := pvdata.NewPVStructure(&struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := monitorKey("test", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := srv.monitors.Subscribers(key); n != 2 {
+		t.Errorf("%d clients share the subscription, want 2", n)
+	}
+}
+
+// abandonChannel is a channel whose monitors report when they are abandoned.
+type abandonChannel struct {
+	*SimpleChannel
+	created   int
+	abandoned chan int
+}
+
+func (a *abandonChannel) CreateChannel(ctx context.Context, name string) (Channel, error) {
+	if name == a.Name() {
+		return a, nil
+	}
+	return nil, nil
+}
+
+func (a *abandonChannel) CreateChannelMonitor(ctx context.Context, req pvdata.PVStructure) (Nexter, error) {
+	a.created++
+	return &abandonNexter{id: a.created, abandoned: a.abandoned}, nil
+}
+
+// abandonNexter returns one value, and then sends its id on abandoned once the context passed to Next is cancelled.
+type abandonNexter struct {
+	id        int
+	sent      bool
+	abandoned chan int
+}
+
+func (n *abandonNexter) Next(ctx context.Context) (interface{}, error) {
+	if !n.sent {
+		n.sent = true
+		return &struct {
+			Value pvdata.PVLong `pvaccess:"value"`
+		}{1}, nil
+	}
+	<-ctx.Done()
+	n.abandoned <- n.id
+	return nil, ctx.Err()
+}
+
+func TestMonitorDuplicateID(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv := &Server{}
+	ch := &abandonChannel{SimpleChannel: NewSimpleChannel("test"), abandoned: make(chan int, 2)}
+	srv.AddChannelProvider(ch)
+	tc := newTestClient(ctx, t, srv)
+	sid := tc.createChannel(ctx, 1, "test")
+	for i, wantOK := range []bool{true, false} {
+		tc.send(ctx, proto.APP_CHANNEL_MONITOR, &proto.ChannelMonitorRequest{
+			ServerChannelID: sid,
+			RequestID:       2,
+			Subcommand:      proto.CHANNEL_MONITOR_INIT,
+			PVRequest:       pvdata.NewPVAny(&struct{}{}),
+		})
+		var resp proto.ChannelResponseError
+		tc.expect(ctx, proto.APP_CHANNEL_MONITOR, &resp)
+		if ok := resp.Status.Type == pvdata.PVStatus_OK; ok != wantOK {
+			t.Fatalf("INIT %d: status %v, want OK = %v", i+1, resp.Status, wantOK)
+		}
+	}
+	// The monitor created for the duplicate is abandoned, and the first keeps running.
+	select {
+	case id := <-ch.abandoned:
+		if id != 2 {
+			t.Errorf("monitor %d was abandoned, want 2", id)
+		}
+	case <-ctx.Done():
+		t.Fatal("monitor of duplicate request was not abandoned")
+	}
+}
+
+func TestMonitorIdleTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	clk := clock.NewFake(time.Unix(0, 0))
+	srv := &Server{MonitorIdleTimeout: time.Minute, HeartbeatInterval: -1, Clock: clk}
+	ch := NewSimpleChannel("test")
+	value := pvdata.PVDouble(1)
+	ch.Set(&value)
+	srv.AddChannelProvider(ch)
+	tc := newTestClient(ctx, t, srv)
+	if err := clk.BlockUntil(ctx, 1); err != nil {
+		t.Fatalf("expiry never started: %v", err)
+	}
+	sid := tc.createChannel(ctx, 1, "test")
+	for _, id := range []pvdata.PVInt{2, 3} {
+		tc.send(ctx, proto.APP_CHANNEL_MONITOR, &proto.ChannelMonitorRequest{
+			ServerChannelID: sid,
+			RequestID:       id,
+			Subcommand:      proto.CHANNEL_MONITOR_INIT,
+			PVRequest:       pvdata.NewPVAny(&struct{}{}),
+		})
+		var init proto.ChannelMonitorResponseInit
+		tc.expect(ctx, proto.APP_CHANNEL_MONITOR, &init)
+		if init.Status.Type != pvdata.PVStatus_OK {
+			t.Fatalf("monitor %d INIT failed: %v", id, init.Status)
+		}
+	}
+	lastActive := func(id pvdata.PVInt) time.Time {
+		tc.server.mu.Lock()
+		r := tc.server.requests[id]
+		tc.server.mu.Unlock()
+		if r == nil {
+			return time.Time{}
+		}
+		return r.doer.(*monitor.Monitor).LastActive()
+	}
+	// Neither monitor is started, so neither sends updates, but monitor 3 is kept alive by its client's ACK.
+	// The first check, after 30 seconds, expires neither.
+	clk.Advance(40 * time.Second)
+	tc.send(ctx, proto.APP_CHANNEL_MONITOR, &proto.ChannelMonitorRequest{
+		ServerChannelID: sid,
+		RequestID:       3,
+		Subcommand:      proto.CHANNEL_MONITOR_PIPELINE_SUPPORT,
+	})
+	for !lastActive(3).Equal(clk.Now()) {
+		if ctx.Err() != nil {
+			t.Fatal("ACK never received")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	clk.Advance(20 * time.Second)
+	var resp proto.ChannelResponseError
+	tc.expect(ctx, proto.APP_CHANNEL_MONITOR, &resp)
+	if resp.RequestID != 2 || resp.Subcommand != proto.CHANNEL_MONITOR_TERMINATE || resp.Status.Type != pvdata.PVStatus_ERROR {
+		t.Fatalf("got %+v, want monitor 2 destroyed with an error", resp)
+	}
+	tc.server.mu.Lock()
+	_, expired := tc.server.requests[2]
+	_, active := tc.server.requests[3]
+	tc.server.mu.Unlock()
+	if expired || !active {
+		t.Errorf("monitor 2 exists: %v, monitor 3 exists: %v; want only monitor 3", expired, active)
+	}
+}
+
+// stallConn is a connection whose writes can be stalled, like those to a client that has stopped reading.
+type stallConn struct {
+	net.Conn
+	// stall is held for writing while writes are stalled.
+	stall sync.RWMutex
+}
+
+func (c *stallConn) Write(b []byte) (int, error) {
+	c.stall.RLock()
+	defer c.stall.RUnlock()
+	return c.Conn.Write(b)
+}
+
+func TestConnectionBufferLimit(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv := &Server{ConnectionBufferLimit: 64 << 10}
+	ch := NewSimpleChannel("big")
+	waveform := make([]pvdata.PVDouble, 16<<10)
+	ch.Set(&waveform)
+	srv.AddChannelProvider(ch)
+	var conn *stallConn
+	tc := newTestClientConn(ctx, t, srv, 0, func(nc net.Conn) net.Conn {
+		conn = &stallConn{Conn: nc}
+		return conn
+	})
+	sid := tc.createChannel(ctx, 1, "big")
+
+	const monitors = 8
+	for id := pvdata.PVInt(10); id < 10+monitors; id++ {
+		tc.send(ctx, proto.APP_CHANNEL_MONITOR, &proto.ChannelMonitorRequest{
+			ServerChannelID: sid,
+			RequestID:       id,
+			Subcommand:      proto.CHANNEL_MONITOR_INIT,
+			PVRequest:       pvdata.NewPVAny(&struct{}{}),
+		})
+		var init proto.ChannelMonitorResponseInit
+		tc.expect(ctx, proto.APP_CHANNEL_MONITOR, &init)
+		if init.Status.Type != pvdata.PVStatus_OK {
+			t.Fatalf("monitor INIT failed: %v", init.Status)
+		}
+	}
+	conn.stall.Lock()
+	for id := pvdata.PVInt(10); id < 10+monitors; id++ {
+		tc.send(ctx, proto.APP_CHANNEL_MONITOR, &proto.ChannelMonitorRequest{
+			ServerChannelID: sid,
+			RequestID:       id,
+			Subcommand:      proto.CHANNEL_MONITOR_SUBSCRIPTION | proto.CHANNEL_MONITOR_SUBSCRIPTION_RUN,
+		})
+	}
+	// wait polls the connection's info until ok returns true.
+	wait := func(what string, ok func(info ConnectionInfo) bool) ConnectionInfo {
+		t.Helper()
+		for {
+			info := srv.Connections()[0]
+			if ok(info) {
+				return info
+			}
+			if ctx.Err() != nil {
+				conn.stall.Unlock()
+				t.Fatalf("connection info = %+v, want %s", info, what)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	// The first update is stuck being written, and the rest are refused.
+	stuck := wait("overruns", func(info ConnectionInfo) bool {
+		return info.Overruns >= monitors-1 && info.Buffered >= srv.ConnectionBufferLimit
+	})
+	tc.send(ctx, proto.APP_CHANNEL_GET, &proto.ChannelGetRequest{
+		ServerChannelID: sid,
+		RequestID:       100,
+		Subcommand:      proto.CHANNEL_GET_INIT,
+		PVRequest:       pvdata.NewPVAny(&struct{}{}),
+	})
+	// The Get's rejection is queued whatever the limit.
+	wait("the Get's response queued", func(info ConnectionInfo) bool {
+		return info.Buffered > stuck.Buffered
+	})
+	conn.stall.Unlock()
+
+	// Once the client catches up, every monitor gets the latest value.
+	updated := make(map[pvdata.PVInt]bool)
+	var getStatus *pvdata.PVStatus
+	for len(updated) < monitors || getStatus == nil {
+		msg, err := tc.Next(ctx)
+		if err != nil {
+			t.Fatalf("reading messages: %v (%d monitors updated)", err, len(updated))
+		}
+		switch msg.Header.MessageCommand {
+		case proto.APP_CHANNEL_MONITOR:
+			var id pvdata.PVInt
+			if err := msg.Peek(&id); err != nil {
+				t.Fatal(err)
+			}
+			updated[id] = true
+		case proto.APP_CHANNEL_GET:
+			var resp proto.ChannelResponseError
+			if err := msg.Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			getStatus = &resp.Status
+		}
+	}
+	if getStatus.Type != pvdata.PVStatus_ERROR {
+		t.Errorf("Get INIT on an overloaded connection = %v, want an error", *getStatus)
+	}
+}
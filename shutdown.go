@@ -0,0 +1,111 @@
+package pvaccess
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/clock"
+	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+const defaultDrainTimeout = 5 * time.Second
+
+// drainPollInterval is how often a draining connection checks whether its operations have finished.
+const drainPollInterval = 10 * time.Millisecond
+
+func (srv *Server) isDraining() bool {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+	return srv.draining
+}
+
+// drain prepares the server's connections to be closed, once ServeListeners' context has been cancelled.
+// It waits for up to DrainTimeout for the operations in progress to finish, and then destroys every channel,
+// telling the clients. ctx must outlive ServeListeners' context.
+func (srv *Server) drain(ctx context.Context) {
+	timeout := srv.DrainTimeout
+	if timeout == 0 {
+		timeout = defaultDrainTimeout
+	}
+	srv.mu.Lock()
+	srv.draining = true
+	conns := make([]*serverConn, 0, len(srv.conns))
+	for c := range srv.conns {
+		conns = append(conns, c)
+	}
+	srv.mu.Unlock()
+	if timeout < 0 || len(conns) == 0 {
+		return
+	}
+	ctxlog.L(ctx).Infof("draining %d connections", len(conns))
+	ctx, cancel := clock.WithTimeout(ctx, srv.Clock, timeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, c := range conns {
+		c := c
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.drain(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// drain waits until no operation is in progress on c, or ctx is done, and then destroys every channel on c,
+// sending CHANNEL_DESTROY to the client so that it creates the channels again elsewhere.
+func (c *serverConn) drain(ctx context.Context) {
+	ticker := clock.Or(c.srv.Clock).NewTicker(drainPollInterval)
+	defer ticker.Stop()
+wait:
+	for c.busy() {
+		select {
+		case <-ctx.Done():
+			ctxlog.L(ctx).Warnf("closing connection with operations still in progress")
+			break wait
+		case <-ticker.C():
+		}
+	}
+	c.destroyChannelsNotify(ctx)
+}
+
+// busy reports whether any operation, or the INIT of a request, is in progress on c.
+func (c *serverConn) busy() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.inits) > 0 {
+		return true
+	}
+	for _, r := range c.requests {
+		if r.status == REQUEST_IN_PROGRESS {
+			return true
+		}
+	}
+	return false
+}
+
+// destroyChannelsNotify destroys every channel on c, and tells the client that each was destroyed.
+func (c *serverConn) destroyChannelsNotify(ctx context.Context) {
+	c.mu.Lock()
+	ids := make(map[pvdata.PVInt]pvdata.PVInt, len(c.channels))
+	for sid, sc := range c.channels {
+		ids[sid] = sc.clientID
+	}
+	c.mu.Unlock()
+	for sid, clientID := range ids {
+		if err := c.destroyChannel(ctx, sid, clientID); err != nil {
+			// The client destroyed it first.
+			continue
+		}
+		if err := c.SendApp(ctx, proto.APP_CHANNEL_DESTROY, &proto.DestroyChannel{
+			ServerChannelID: sid,
+			ClientChannelID: clientID,
+		}); err != nil {
+			ctxlog.L(ctx).Debugf("sending CHANNEL_DESTROY: %v", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package pvaccess
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/Lexcelon/go-pvaccess/types"
+)
+
+// Snapshot holds a channel's current value so that it can be read without locking.
+//
+// Each new value is built in a copy of the previous one while readers continue to see the old value,
+// and is then published atomically. Get requests therefore never wait for the provider's update path,
+// and always see a complete value, never one that is partly updated.
+//
+// Snapshot implements ChannelGeter and ChannelMonitorCreator, so a channel can embed a *Snapshot
+// to serve its value. The zero value holds no value and is ready to use.
+type Snapshot struct {
+	// mu serializes writers. Readers never take it.
+	mu      sync.Mutex
+	current atomic.Value // *snapshotValue
+}
+
+type snapshotValue struct {
+	value interface{}
+	// seq is incremented by every Store, and is zero until the first.
+	seq uint64
+	// replaced is closed when the value is replaced.
+	replaced chan struct{}
+}
+
+var errNoValue = errors.New("channel has no value")
+
+func (s *Snapshot) load() *snapshotValue {
+	if sv, ok := s.current.Load().(*snapshotValue); ok {
+		return sv
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.loadLocked()
+}
+
+func (s *Snapshot) loadLocked() *snapshotValue {
+	sv, ok := s.current.Load().(*snapshotValue)
+	if !ok {
+		sv = &snapshotValue{replaced: make(chan struct{})}
+		s.current.Store(sv)
+	}
+	return sv
+}
+
+// Load returns the current value, or nil if no value has been stored.
+// The returned value is shared with other readers and must not be modified.
+func (s *Snapshot) Load() interface{} {
+	return s.load().value
+}
+
+// Store replaces the current value and notifies monitoring clients.
+// value must not be modified once it has been stored.
+func (s *Snapshot) Store(value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.storeLocked(value)
+}
+
+func (s *Snapshot) storeLocked(value interface{}) {
+	old := s.loadLocked()
+	s.current.Store(&snapshotValue{
+		value:    value,
+		seq:      old.seq + 1,
+		replaced: make(chan struct{}),
+	})
+	close(old.replaced)
+}
+
+// Update calls f with a copy of the current value, which must be a non-nil pointer, and stores the copy
+// once f returns. If f returns an error, the current value is left unchanged and the error is returned.
+//
+// The copy is shallow: slices and maps in the value are shared with readers of the previous value,
+// so f must replace them rather than modify them in place.
+func (s *Snapshot) Update(f func(value interface{}) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := reflect.ValueOf(s.loadLocked().value)
+	if old.Kind() != reflect.Ptr || old.IsNil() {
+		return errors.New("snapshot value is not a non-nil pointer")
+	}
+	v := reflect.New(old.Type().Elem())
+	v.Elem().Set(old.Elem())
+	if err := f(v.Interface()); err != nil {
+		return err
+	}
+	s.storeLocked(v.Interface())
+	return nil
+}
+
+// ChannelGet returns the current value.
+func (s *Snapshot) ChannelGet(ctx context.Context) (interface{}, error) {
+	if v := s.Load(); v != nil {
+		return v, nil
+	}
+	return nil, errNoValue
+}
+
+// CreateChannelMonitor returns a Nexter that returns the current value, and then each new value.
+// Values stored while a client is not waiting are skipped, so slow clients only ever see the latest value.
+func (s *Snapshot) CreateChannelMonitor(ctx context.Context, req pvdata.PVStructure) (types.Nexter, error) {
+	return &snapshotWatch{s: s}, nil
+}
+
+type snapshotWatch struct {
+	s   *Snapshot
+	seq uint64
+}
+
+func (w *snapshotWatch) Next(ctx context.Context) (interface{}, error) {
+	for {
+		sv := w.s.load()
+		if sv.seq > w.seq {
+			w.seq = sv.seq
+			return sv.value, nil
+		}
+		select {
+		case <-sv.replaced:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package pvaccess
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+type snapshotPair struct {
+	A, B pvdata.PVInt
+}
+
+func TestSnapshot(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var s Snapshot
+	if _, err := s.ChannelGet(ctx); err == nil {
+		t.Error("ChannelGet on empty Snapshot succeeded")
+	}
+	w, err := s.CreateChannelMonitor(ctx, pvdata.PVStructure{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Store(&snapshotPair{})
+
+	// Readers must never see a pair that is half updated.
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				v, err := s.ChannelGet(ctx)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if p := v.(*snapshotPair); p.A != p.B {
+					t.Errorf("read inconsistent value %+v", p)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 1000; i++ {
+		if err := s.Update(func(v interface{}) error {
+			p := v.(*snapshotPair)
+			p.A++
+			p.B++
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+	if p := s.Load().(*snapshotPair); p.A != 1000 || p.B != 1000 {
+		t.Errorf("after 1000 updates, value = %+v", p)
+	}
+
+	v, err := w.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := v.(*snapshotPair); p.A != 1000 {
+		t.Errorf("monitor returned %+v, want latest value", p)
+	}
+	go s.Store(&snapshotPair{A: -1, B: -1})
+	v, err = w.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := v.(*snapshotPair); p.A != -1 {
+		t.Errorf("monitor returned %+v after Store", p)
+	}
+	short, cancelShort := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancelShort()
+	if _, err := w.Next(short); err == nil {
+		t.Error("Next returned without a new value")
+	}
+}
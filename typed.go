@@ -0,0 +1,144 @@
+package pvaccess
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+	"github.com/Lexcelon/go-pvaccess/types"
+)
+
+// TypedChannel is a channel that serves a value of the Go type T, such as float64 or []int32, as an NTScalar,
+// or an NTScalarArray if T is a slice, with an alarm and the time stamp of the last Set.
+// Clients that put to the channel can send any value that converts to a T, as by pvdata.FieldAs.
+type TypedChannel[T any] struct {
+	name string
+
+	mu        sync.Mutex
+	cond      *sync.Cond
+	seq       int
+	value     T
+	alarm     pvdata.Alarm
+	timeStamp time.Time
+}
+
+// NewTypedChannel returns a TypedChannel that serves value.
+func NewTypedChannel[T any](name string, value T) *TypedChannel[T] {
+	c := &TypedChannel[T]{name: name, value: value, timeStamp: time.Now()}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *TypedChannel[T]) Name() string {
+	return c.name
+}
+
+// Value returns the current value in c.
+func (c *TypedChannel[T]) Value() T {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.value
+}
+
+// Set changes the value in c, time stamps it, and notifies any clients that are monitoring the channel.
+func (c *TypedChannel[T]) Set(value T) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.value = value
+	c.timeStamp = time.Now()
+	c.seq++
+	c.cond.Broadcast()
+}
+
+// SetAlarm changes the alarm served with the value, and notifies any clients that are monitoring the channel.
+func (c *TypedChannel[T]) SetAlarm(alarm pvdata.Alarm) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.alarm = alarm
+	c.seq++
+	c.cond.Broadcast()
+}
+
+func (c *TypedChannel[T]) CreateChannel(ctx context.Context, name string) (Channel, error) {
+	if c.Name() == name {
+		return c, nil
+	}
+	return nil, nil
+}
+
+func (c *TypedChannel[T]) ChannelList(ctx context.Context) ([]string, error) {
+	return []string{c.Name()}, nil
+}
+
+type typedScalar struct {
+	Value     interface{}   `pvaccess:"value"`
+	Alarm     *pvdata.Alarm `pvaccess:"alarm"`
+	TimeStamp *pvdata.Time  `pvaccess:"timeStamp"`
+}
+
+func (typedScalar) TypeID() string {
+	return "epics:nt/NTScalar:1.0"
+}
+
+type typedScalarArray typedScalar
+
+func (typedScalarArray) TypeID() string {
+	return "epics:nt/NTScalarArray:1.0"
+}
+
+// structureLocked returns the normative type structure for the current value. c.mu must be held.
+func (c *TypedChannel[T]) structureLocked() interface{} {
+	value := c.value
+	alarm := c.alarm
+	s := typedScalar{&value, &alarm, &pvdata.Time{Time: c.timeStamp}}
+	if reflect.TypeOf(value) != nil && reflect.TypeOf(value).Kind() == reflect.Slice {
+		return (*typedScalarArray)(&s)
+	}
+	return &s
+}
+
+func (c *TypedChannel[T]) ChannelGet(ctx context.Context) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.structureLocked(), nil
+}
+
+// ChannelPut replaces the value in c with the "value" field of value, converted to a T.
+func (c *TypedChannel[T]) ChannelPut(ctx context.Context, value pvdata.PVStructure) error {
+	v, err := pvdata.FieldAs[T](value, "value")
+	if err != nil {
+		return pvdata.Error(err.Error())
+	}
+	c.Set(v)
+	return nil
+}
+
+func (c *TypedChannel[T]) CreateChannelMonitor(ctx context.Context, req pvdata.PVStructure) (types.Nexter, error) {
+	return &typedWatch[T]{c, -1}, nil
+}
+
+type typedWatch[T any] struct {
+	c   *TypedChannel[T]
+	seq int
+}
+
+func (w *typedWatch[T]) Next(ctx context.Context) (interface{}, error) {
+	w.c.mu.Lock()
+	defer w.c.mu.Unlock()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		w.c.cond.Broadcast()
+	}()
+	for w.seq >= w.c.seq {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		w.c.cond.Wait()
+	}
+	w.seq = w.c.seq
+	return w.c.structureLocked(), nil
+}
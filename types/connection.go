@@ -0,0 +1,68 @@
+package types
+
+import (
+	"net"
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+// ConnectionInfo describes the health and negotiated parameters of a pvAccess connection.
+type ConnectionInfo struct {
+	LocalAddr, RemoteAddr net.Addr
+	// Version is the protocol version used on the connection.
+	Version pvdata.PVByte
+	// ReceiveBufferSize and IntrospectionRegistryMaxSize are the values announced by the peer during connection validation.
+	ReceiveBufferSize            int
+	IntrospectionRegistryMaxSize int
+	// AuthNZ is the selected authentication method.
+	AuthNZ string
+	// Peer is the identity of the client, for connections accepted by a server.
+	Peer *Peer
+	// Established is when the connection was opened.
+	Established time.Time
+	// LastActivity is when a message was last received on the connection.
+	LastActivity time.Time
+	// RTT is the round-trip time measured by the most recent heartbeat, or 0 if none has been answered.
+	RTT time.Duration
+	// Channels is the number of channels currently open on the connection.
+	Channels int
+	// Compressed is set if both ends of the connection agreed to compress large messages.
+	Compressed bool
+	// InProgress lists the Get, Put, and RPC operations currently running on the connection, oldest first.
+	InProgress []RequestInfo
+	// Buffered is the number of bytes of messages waiting to be sent, counted if the server has a ConnectionBufferLimit.
+	Buffered int
+	// Overruns is the number of monitor updates the server deferred because the connection was at its buffer limit.
+	Overruns int
+}
+
+// RequestInfo describes an operation running on behalf of a client.
+type RequestInfo struct {
+	// RequestID is the ID the client assigned to the request.
+	RequestID pvdata.PVInt
+	// Channel is the name of the channel the request is on.
+	Channel string
+	// Op is the kind of operation, e.g. "Get", "Put", or "RPC".
+	Op string
+	// Started is when the operation started.
+	Started time.Time
+}
+
+// Compressor compresses the payloads of large application messages.
+//
+// Compression is an extension to the pvAccess protocol, used only between endpoints that have both offered
+// the same Algorithm, so connections to other implementations of pvAccess are unaffected.
+type Compressor interface {
+	// Algorithm identifies the compressed format; both ends of a connection must use the same one.
+	Algorithm() int32
+	// Compress appends the compressed form of src to dst.
+	Compress(dst, src []byte) ([]byte, error)
+	// Decompress appends the decompressed form of src to dst.
+	// It should fail instead if src decompresses to more than MaxPayloadSize bytes.
+	Decompress(dst, src []byte) ([]byte, error)
+}
+
+// MaxPayloadSize is the largest payload accepted from a peer once it has been decompressed,
+// so that a small compressed message can't make a connection allocate arbitrarily large buffers.
+const MaxPayloadSize = 256 << 20
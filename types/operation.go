@@ -0,0 +1,17 @@
+package types
+
+import "context"
+
+type operationIDKey struct{}
+
+// WithOperationID returns a new context carrying the ID of the operation running in it.
+func WithOperationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, operationIDKey{}, id)
+}
+
+// OperationIDFromContext returns the server-assigned ID of the operation running in ctx.
+// Channels that forward operations elsewhere (e.g. gateways) can log it to correlate their logs with the server's.
+func OperationIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(operationIDKey{}).(string)
+	return id, ok
+}
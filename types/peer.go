@@ -0,0 +1,34 @@
+package types
+
+import (
+	"context"
+	"net"
+)
+
+// Peer describes the client on the other end of a connection.
+type Peer struct {
+	// Addr is the client's address.
+	Addr net.Addr
+	// LocalAddr is the address of the listener that accepted the connection.
+	LocalAddr net.Addr
+	// AuthNZ is the authentication method selected by the client, e.g. "anonymous" or "ca".
+	AuthNZ string
+	// User and Host are the identity claimed by the client when using the "ca" method.
+	User, Host string
+	// Priority is the priority requested by the client in its connection QoS, from 0 to 99.
+	// Monitor updates for higher-priority connections are sent first when the server is busy.
+	Priority int
+}
+
+type peerKey struct{}
+
+// WithPeer returns a new context carrying peer.
+func WithPeer(ctx context.Context, peer *Peer) context.Context {
+	return context.WithValue(ctx, peerKey{}, peer)
+}
+
+// PeerFromContext returns the client that initiated the operation running in ctx.
+func PeerFromContext(ctx context.Context) (*Peer, bool) {
+	peer, ok := ctx.Value(peerKey{}).(*Peer)
+	return peer, ok && peer != nil
+}
@@ -2,15 +2,44 @@ package types
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"github.com/Lexcelon/go-pvaccess/pvdata"
 )
 
 // ChannelProvider represents the minimal channel provider.
 // Optionally, a channel provider may implement ChannelLister or ChannelFinder.
+//
+// CreateChannel returns a nil Channel, or an error wrapping ErrNotFound, if the provider doesn't have the channel.
+// Other errors are reported to the client as a failure of the provider; wrapping one in a RetryableError
+// tells the client that it may succeed later.
 type ChannelProvider interface {
 	CreateChannel(ctx context.Context, name string) (Channel, error)
 }
+
+// ErrNotFound is returned, possibly wrapped, by CreateChannel when the provider doesn't have the channel.
+// Clients that are told a channel wasn't found keep searching for it on other servers.
+var ErrNotFound = errors.New("channel not found")
+
+// ErrPermissionDenied is returned, possibly wrapped, by CreateChannel when the client may not use the channel.
+var ErrPermissionDenied = errors.New("permission denied")
+
+// RetryableError marks a failure to create a channel as temporary, e.g. because the device behind it is restarting.
+type RetryableError struct {
+	Err error
+	// After is how long the client should wait before trying again. Zero leaves the wait to the client.
+	After time.Duration
+}
+
+func (e *RetryableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
+
 type ChannelLister interface {
 	ChannelList(ctx context.Context) ([]string, error)
 }
@@ -18,6 +47,15 @@ type ChannelFinder interface {
 	ChannelFind(ctx context.Context, name string) (bool, error)
 }
 
+// ChannelAliaser is implemented by channel providers that also serve channels under other names,
+// e.g. so that renamed devices stay reachable under their legacy PV names.
+// ChannelAliases maps each alias to the canonical name of its channel, which the provider creates with CreateChannel.
+// The server resolves aliases when clients create or search for channels; the channel created is the same as for
+// its canonical name, so its Name, access rights, and users are those of the canonical channel.
+type ChannelAliaser interface {
+	ChannelAliases(ctx context.Context) (map[string]string, error)
+}
+
 // Channel represents the minimal channel.
 //
 // For a channel to be useful, it must implement one of the following additional interfaces:
@@ -29,10 +67,34 @@ type ChannelFinder interface {
 // - CreateChannelRPC
 // - CreateMonitor
 // - CreateChannelArray
+//
+// Channels may also implement ChannelConnector and ChannelDisconnector to learn when they are in use.
+//
+// The ctx passed to ChannelGet, ChannelPut, and ChannelRPC is cancelled when the client cancels or destroys the request,
+// destroys the channel, or disconnects. Implementations should return promptly once ctx is done;
+// any result returned after cancellation is silently discarded instead of being sent to the client.
 type Channel interface {
 	Name() string
 }
 
+// ChannelConnector is implemented by channels that want to know when they start being used,
+// e.g. to start polling hardware only while a client is watching.
+// ChannelConnected is called when the first client creates the channel.
+//
+// Channels are identified by the value returned from ChannelProvider.CreateChannel,
+// so a provider that returns the same Channel to every client sees a single ChannelConnected
+// until the last of them leaves.
+type ChannelConnector interface {
+	ChannelConnected(ctx context.Context)
+}
+
+// ChannelDisconnector is implemented by channels that want to know when they are no longer used.
+// ChannelDisconnected is called when the last client using the channel destroys it or disconnects.
+// ctx may already be done, if the client's connection was lost.
+type ChannelDisconnector interface {
+	ChannelDisconnected(ctx context.Context)
+}
+
 type ChannelGetCreator interface {
 	CreateChannelGet(ctx context.Context, req pvdata.PVStructure) (ChannelGeter, error)
 }
@@ -41,6 +103,83 @@ type ChannelGeter interface {
 	ChannelGet(ctx context.Context) (response interface{}, err error)
 }
 
+type ChannelPutCreator interface {
+	CreateChannelPut(ctx context.Context, req pvdata.PVStructure) (ChannelPuter, error)
+}
+
+// ChannelPuter accepts new values for a channel.
+// The value passed to ChannelPut has the structure returned by the channel's Get
+// (or by the ChannelPuter's FieldDesc method, if it implements pvdata.FieldDescer).
+type ChannelPuter interface {
+	ChannelPut(ctx context.Context, value pvdata.PVStructure) error
+}
+
+// ChannelFieldPuter is implemented by ChannelPuters that accept puts of only some fields.
+// ChannelPutFields is called instead of ChannelPut with the names of the fields the client sent,
+// e.g. "value" or "value.x" (see pvdata.ChangedFields); the other fields of value are zero.
+//
+// Puts to ChannelPuters that don't implement ChannelFieldPuter are merged into the value
+// returned by their Get before ChannelPut is called, so that fields the client didn't send are unchanged.
+type ChannelFieldPuter interface {
+	ChannelPutFields(ctx context.Context, value pvdata.PVStructure, changed []string) error
+}
+
+// GroupPuter is implemented by channel providers that can put to several of their channels at once,
+// e.g. to move the axes of a device together.
+// values maps the names of the channels to the values to put, which have the structure of each channel's Get.
+// GroupPut must either put every value or, if it returns an error, none of them.
+type GroupPuter interface {
+	GroupPut(ctx context.Context, values map[string]pvdata.PVStructure) error
+}
+
+// Reloadable is implemented by channel providers whose configuration can change while they serve channels.
+// Reload re-reads the configuration and replaces the provider's channels with the new ones at once.
+// If Reload returns an error, the provider must keep serving its previous configuration.
+// Channels that clients have already created should keep working, so that reloads don't interrupt clients.
+type Reloadable interface {
+	Reload(ctx context.Context) error
+}
+
+// Validator checks a value before it is put to a channel.
+// A Validator may modify value (e.g. to clamp it to limits) or reject it by returning an error.
+// Channels, ChannelPuters, and Servers can all have Validators.
+type Validator interface {
+	ValidatePut(ctx context.Context, channel string, value pvdata.PVStructure) error
+}
+
+// FieldValidator is implemented by Validators that can check puts of only some fields.
+// For puts to a ChannelFieldPuter, ValidatePutFields is called instead of ValidatePut with the names of the fields
+// the client sent; as for ChannelPutFields, the other fields of value are zero.
+type FieldValidator interface {
+	ValidatePutFields(ctx context.Context, channel string, value pvdata.PVStructure, changed []string) error
+}
+
+// AccessRights are the operations a client may perform on a channel, as reported in the create channel response.
+type AccessRights int16
+
+const (
+	AccessRead AccessRights = 1 << iota
+	AccessWrite
+
+	AccessNone      AccessRights = 0
+	AccessReadWrite              = AccessRead | AccessWrite
+)
+
+// AccessController decides the access rights of the client in ctx (see PeerFromContext) to a channel.
+// Channels and Servers can both have AccessControllers; a client gets only the rights granted by all of them.
+// Clients use the rights to show whether a channel can be written. Puts without AccessWrite are rejected,
+// as are Gets, Monitors, and RPCs without AccessRead.
+type AccessController interface {
+	AccessRights(ctx context.Context, channel string) AccessRights
+}
+
+// AccessControllerFunc adapts a function to an AccessController.
+type AccessControllerFunc func(ctx context.Context, channel string) AccessRights
+
+func (f AccessControllerFunc) AccessRights(ctx context.Context, channel string) AccessRights {
+	return f(ctx, channel)
+}
+
 type ChannelRPCCreator interface {
 	CreateChannelRPC(ctx context.Context, req pvdata.PVStructure) (ChannelRPCer, error)
 }
@@ -0,0 +1,104 @@
+package pvaccess
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+// AddValidator adds a Validator that is applied to puts on every channel, before any channel-specific Validator.
+func (srv *Server) AddValidator(v Validator) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.validators = append(srv.validators, v)
+}
+
+// validatePut runs the server's validators and then those implemented by channel and puter.
+// changed names the fields of value that the client sent, if value is put to a ChannelFieldPuter without being merged,
+// and is nil otherwise. Errors that are not already a PVStatus are reported as an ERROR status.
+func (srv *Server) validatePut(ctx context.Context, channel Channel, puter ChannelPuter, value pvdata.PVStructure, changed []string) error {
+	srv.mu.RLock()
+	validators := append([]Validator{}, srv.validators...)
+	srv.mu.RUnlock()
+	if v, ok := channel.(Validator); ok {
+		validators = append(validators, v)
+	}
+	if v, ok := puter.(Validator); ok && !sameValidator(puter, channel) {
+		validators = append(validators, v)
+	}
+	for _, v := range validators {
+		var err error
+		if fv, ok := v.(FieldValidator); ok && changed != nil {
+			err = fv.ValidatePutFields(ctx, channel.Name(), value, changed)
+		} else {
+			err = v.ValidatePut(ctx, channel.Name(), value)
+		}
+		if err != nil {
+			var status pvdata.PVStatus
+			if errors.As(err, &status) {
+				return status
+			}
+			return pvdata.Error(err.Error())
+		}
+	}
+	return nil
+}
+
+// sameValidator reports whether puter and channel are the same object, to avoid validating twice.
+func sameValidator(puter ChannelPuter, channel Channel) bool {
+	pv, cv := reflect.ValueOf(puter), reflect.ValueOf(channel)
+	return pv.Type() == cv.Type() && pv.Type().Comparable() && pv.Interface() == cv.Interface()
+}
+
+// LimitValidator checks that the numeric "value" field of a put is within [Low, High],
+// like the DRVL and DRVH fields of an EPICS record. Puts of only some fields that don't include "value" are allowed.
+type LimitValidator struct {
+	Low, High float64
+	// Clamp causes out-of-range values to be replaced by the nearest limit instead of being rejected.
+	Clamp bool
+}
+
+func (l LimitValidator) ValidatePut(ctx context.Context, channel string, value pvdata.PVStructure) error {
+	field := value.Field("value")
+	x, ok := pvdata.FloatValue(field)
+	if !ok {
+		return fmt.Errorf("channel %q: value of type %T is not numeric", channel, field)
+	}
+	if x >= l.Low && x <= l.High {
+		return nil
+	}
+	if !l.Clamp {
+		return fmt.Errorf("channel %q: value %v outside of limits [%v, %v]", channel, x, l.Low, l.High)
+	}
+	limit := l.Low
+	if x > l.High {
+		limit = l.High
+	}
+	v := reflect.ValueOf(field)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("channel %q: value %v outside of limits [%v, %v] cannot be clamped in a %T", channel, x, l.Low, l.High, field)
+	}
+	v = v.Elem()
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(limit)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(int64(limit))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(uint64(limit))
+	}
+	return nil
+}
+
+func (l LimitValidator) ValidatePutFields(ctx context.Context, channel string, value pvdata.PVStructure, changed []string) error {
+	for _, name := range changed {
+		if name == "value" || strings.HasPrefix(name, "value.") {
+			return l.ValidatePut(ctx, channel, value)
+		}
+	}
+	return nil
+}
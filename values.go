@@ -0,0 +1,53 @@
+package pvaccess
+
+import "context"
+
+// SetValue attaches value to the server under key, so that it is returned by ctx.Value(key) in the contexts of
+// every call the server makes to its channel providers, channels, interceptors, validators, and access controllers.
+// This passes dependencies such as database handles or device sessions to providers without global variables.
+// A nil value removes key. Values may be set at any time; calls already in progress see the change too.
+//
+// As with context.WithValue, key should be of a type defined by the package that uses it, to avoid collisions.
+// Values set on the server take precedence over values of the same key in the caller's context.
+func (srv *Server) SetValue(key, value interface{}) {
+	srv.valuesMu.Lock()
+	defer srv.valuesMu.Unlock()
+	if value == nil {
+		delete(srv.values, key)
+		return
+	}
+	if srv.values == nil {
+		srv.values = make(map[interface{}]interface{})
+	}
+	srv.values[key] = value
+}
+
+// valuesContext looks up the values set with SetValue before those of its parent.
+type valuesContext struct {
+	context.Context
+	srv *Server
+}
+
+// valuesContextKey is the key under which a valuesContext returns its Server.
+type valuesContextKey struct{}
+
+func (ctx valuesContext) Value(key interface{}) interface{} {
+	if key == (valuesContextKey{}) {
+		return ctx.srv
+	}
+	ctx.srv.valuesMu.RLock()
+	value, ok := ctx.srv.values[key]
+	ctx.srv.valuesMu.RUnlock()
+	if ok {
+		return value
+	}
+	return ctx.Context.Value(key)
+}
+
+// withValues returns a context that returns the server's values, if ctx doesn't already.
+func (srv *Server) withValues(ctx context.Context) context.Context {
+	if ctx.Value(valuesContextKey{}) == srv {
+		return ctx
+	}
+	return valuesContext{ctx, srv}
+}
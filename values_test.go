@@ -0,0 +1,70 @@
+package pvaccess
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+type sessionKey struct{}
+
+// sessionChannel answers Gets with the session in its context.
+type sessionChannel struct{}
+
+func (sessionChannel) Name() string { return "session" }
+
+func (c sessionChannel) CreateChannel(ctx context.Context, name string) (Channel, error) {
+	if name == c.Name() && ctx.Value(sessionKey{}) != nil {
+		return c, nil
+	}
+	return nil, nil
+}
+
+func (sessionChannel) ChannelGet(ctx context.Context) (interface{}, error) {
+	session, _ := ctx.Value(sessionKey{}).(string)
+	return &bareScalar{Value: pvdata.PVString(session)}, nil
+}
+
+func TestSetValue(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv := &Server{}
+	srv.AddChannelProvider(sessionChannel{})
+	srv.SetValue(sessionKey{}, "first")
+
+	lc, err := srv.LocalChannel(ctx, "session")
+	if err != nil || lc == nil {
+		t.Fatalf("LocalChannel = %v, %v", lc, err)
+	}
+	defer lc.Close()
+	get := func() string {
+		t.Helper()
+		v, err := lc.Get(ctx, pvdata.PVStructure{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(*v.Field("value").(*pvdata.PVString))
+	}
+	if got := get(); got != "first" {
+		t.Errorf("session = %q, want first", got)
+	}
+	srv.SetValue(sessionKey{}, "second")
+	if got := get(); got != "second" {
+		t.Errorf("session after SetValue = %q, want second", got)
+	}
+
+	tc := newTestClient(ctx, t, srv)
+	tc.createChannel(ctx, 1, "session")
+	srv.SetValue(sessionKey{}, nil)
+	tc.send(ctx, proto.APP_CHANNEL_CREATE, &proto.CreateChannelRequest{
+		Channels: []proto.CreateChannelRequest_Channel{{ClientChannelID: 2, ChannelName: "session"}},
+	})
+	var resp proto.CreateChannelResponse
+	tc.expect(ctx, proto.APP_CHANNEL_CREATE, &resp)
+	if resp.Status.Type == pvdata.PVStatus_OK {
+		t.Error("channel created after its value was removed")
+	}
+}
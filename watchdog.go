@@ -0,0 +1,205 @@
+package pvaccess
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/clock"
+	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
+	"github.com/Lexcelon/go-pvaccess/internal/server/monitor"
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+type stuckState int
+
+const (
+	notStuck stuckState = iota
+	// stuckWarned means a warning has been logged about the operation.
+	stuckWarned
+	// stuckCancelled means the operation was cancelled because it ran too long.
+	stuckCancelled
+)
+
+// startRequestLocked marks r as running an operation of the given kind,
+// and returns the context for the operation and the function that cancels it.
+// c.mu must be held.
+func (c *serverConn) startRequestLocked(ctx context.Context, r *request, kind OpKind) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	r.status = REQUEST_IN_PROGRESS
+	r.cancel = cancel
+	r.kind = kind
+	r.started = clock.Or(c.srv.Clock).Now()
+	r.stuck = notStuck
+	return ctx, cancel
+}
+
+// stuckErr returns the error to report for an operation on r that returned err.
+// Operations cancelled by the watchdog always fail, reporting how long they ran.
+func (c *serverConn) stuckErr(r *request, err error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if r.stuck != stuckCancelled {
+		return err
+	}
+	return pvdata.PVStatus{
+		Type:    pvdata.PVStatus_ERROR,
+		Message: pvdata.PVString(fmt.Sprintf("%s cancelled after running for more than %v", r.kind, c.srv.StuckRequestThreshold)),
+	}
+}
+
+// watchdog periodically checks for operations that have run longer than threshold,
+// logs a warning for each, and cancels them if the server's CancelStuckRequests is set.
+// It stops when ctx is cancelled.
+func (c *serverConn) watchdog(ctx context.Context, threshold time.Duration) {
+	ticker := clock.Or(c.srv.Clock).NewTicker(threshold / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C():
+			c.checkStuck(ctx, now, threshold)
+		}
+	}
+}
+
+func (c *serverConn) checkStuck(ctx context.Context, now time.Time, threshold time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, r := range c.requests {
+		if r.status != REQUEST_IN_PROGRESS || r.stuck == stuckCancelled {
+			continue
+		}
+		running := now.Sub(r.started)
+		if running < threshold {
+			continue
+		}
+		l := ctxlog.L(ctx).WithFields(ctxlog.Fields{
+			"channel":    c.channelNameLocked(r.channelID),
+			"channel_id": r.channelID,
+			"request_id": id,
+		})
+		if c.srv.CancelStuckRequests {
+			l.Warnf("cancelling %s that has been running for %v", r.kind, running)
+			r.stuck = stuckCancelled
+			if r.cancel != nil {
+				r.cancel()
+				r.cancel = nil
+			}
+		} else if r.stuck == notStuck {
+			l.Warnf("%s has been running for %v", r.kind, running)
+			r.stuck = stuckWarned
+		}
+	}
+}
+
+// expireMonitors periodically destroys the monitors that have been idle for longer than timeout, as described by
+// the server's MonitorIdleTimeout, and tells their clients. It stops when ctx is cancelled.
+func (c *serverConn) expireMonitors(ctx context.Context, timeout time.Duration) {
+	ticker := clock.Or(c.srv.Clock).NewTicker(timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C():
+			c.checkIdle(ctx, now, timeout)
+		}
+	}
+}
+
+func (c *serverConn) checkIdle(ctx context.Context, now time.Time, timeout time.Duration) {
+	type expired struct {
+		id pvdata.PVInt
+		s  sender
+	}
+	// The monitors are asked when they were last active without holding c.mu, since a monitor sending an update holds
+	// its own lock and can need c.mu.
+	c.mu.Lock()
+	candidates := make(map[pvdata.PVInt]*monitor.Monitor)
+	for id, r := range c.requests {
+		if m, ok := r.doer.(*monitor.Monitor); ok && r.status == READY {
+			candidates[id] = m
+		}
+	}
+	c.mu.Unlock()
+	idle := make(map[pvdata.PVInt]time.Duration)
+	for id, m := range candidates {
+		if d := now.Sub(m.LastActive()); d >= timeout {
+			idle[id] = d
+		}
+	}
+	if len(idle) == 0 {
+		return
+	}
+	var monitors []expired
+	var terminate []func()
+	c.mu.Lock()
+	for id, d := range idle {
+		r, ok := c.requests[id]
+		// The request may have been destroyed, or replaced, in the meantime.
+		if !ok || r.doer != candidates[id] || r.status != READY {
+			continue
+		}
+		ctxlog.L(ctx).WithFields(ctxlog.Fields{
+			"channel":    c.channelNameLocked(r.channelID),
+			"channel_id": r.channelID,
+			"request_id": id,
+		}).Infof("expiring monitor that has been idle for %v", d)
+		var s sender = c.Connection
+		if sc := c.channels[r.channelID]; sc != nil {
+			s = sc.queue
+		}
+		t, _ := c.destroyRequestLocked(id)
+		terminate = append(terminate, t)
+		monitors = append(monitors, expired{id, s})
+	}
+	c.mu.Unlock()
+	for _, t := range terminate {
+		t()
+	}
+	for _, m := range monitors {
+		if err := m.s.SendApp(ctx, proto.APP_CHANNEL_MONITOR, &proto.ChannelResponseError{
+			RequestID:  m.id,
+			Subcommand: proto.CHANNEL_MONITOR_TERMINATE,
+			Status: pvdata.PVStatus{
+				Type:    pvdata.PVStatus_ERROR,
+				Message: pvdata.PVString(fmt.Sprintf("monitor expired after %v without activity", timeout)),
+			},
+		}); err != nil {
+			ctxlog.L(ctx).Errorf("sending monitor expiry: %v", err)
+			return
+		}
+	}
+}
+
+func (c *serverConn) channelNameLocked(id pvdata.PVInt) string {
+	if sc := c.channels[id]; sc != nil {
+		return sc.channel.Name()
+	}
+	return ""
+}
+
+// inProgressLocked describes the operations running on the connection, oldest first.
+// c.mu must be held.
+func (c *serverConn) inProgressLocked() []RequestInfo {
+	var infos []RequestInfo
+	for id, r := range c.requests {
+		if r.status != REQUEST_IN_PROGRESS {
+			continue
+		}
+		infos = append(infos, RequestInfo{
+			RequestID: id,
+			Channel:   c.channelNameLocked(r.channelID),
+			Op:        r.kind.String(),
+			Started:   r.started,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Started.Before(infos[j].Started)
+	})
+	return infos
+}
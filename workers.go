@@ -0,0 +1,171 @@
+package pvaccess
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Lexcelon/go-pvaccess/internal/ctxlog"
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+// errBusy rejects operations that can't be queued because the worker pools are full.
+var errBusy = pvdata.Error("server has too many operations in progress; try again later")
+
+// workerPool runs at most max functions at once, queueing the rest. A nil pool runs every function at once.
+// A pool with a parent only runs its functions once the parent has a worker for them too, so that a connection's
+// operations are limited by the server's pool as well as its own.
+type workerPool struct {
+	max int
+	// queue is how many functions may wait for a worker; zero is unlimited, and negative queues none.
+	queue  int
+	parent *workerPool
+
+	mu      sync.Mutex
+	running int
+	waiting []poolTask
+}
+
+type poolTask struct {
+	run, reject func()
+	// finished is called once run has returned and every pool running it has freed its worker, if it is non-nil.
+	finished func()
+}
+
+// newWorkerPool returns a pool of max workers under parent, or parent itself if max is not positive.
+func newWorkerPool(max, queue int, parent *workerPool) *workerPool {
+	if max <= 0 {
+		return parent
+	}
+	return &workerPool{max: max, queue: queue, parent: parent}
+}
+
+// submit runs run on a worker, queueing it until one is free, and returns false without running it if the queue
+// is full. If the pool's parent refuses run once it has been queued, reject is called instead.
+func (p *workerPool) submit(run, reject func()) bool {
+	return p.submitTask(poolTask{run: run, reject: reject})
+}
+
+func (p *workerPool) submitTask(t poolTask) bool {
+	if p == nil {
+		go func() {
+			t.run()
+			if t.finished != nil {
+				t.finished()
+			}
+		}()
+		return true
+	}
+	p.mu.Lock()
+	if p.running < p.max {
+		p.running++
+		p.mu.Unlock()
+		if p.start(t) {
+			return true
+		}
+		p.done()
+		return false
+	}
+	defer p.mu.Unlock()
+	if p.queue < 0 || p.queue > 0 && len(p.waiting) >= p.queue {
+		return false
+	}
+	p.waiting = append(p.waiting, t)
+	return true
+}
+
+// start runs t on a worker of the pool, which it has already been counted against,
+// and reports whether the pool's parent accepted it.
+func (p *workerPool) start(t poolTask) bool {
+	// The parent's worker is freed first, so that the next function queued here can take it.
+	finished := func() {
+		p.done()
+		if t.finished != nil {
+			t.finished()
+		}
+	}
+	if p.parent == nil {
+		go func() {
+			t.run()
+			finished()
+		}()
+		return true
+	}
+	return p.parent.submitTask(poolTask{run: t.run, reject: func() {
+		p.done()
+		t.reject()
+	}, finished: finished})
+}
+
+// done frees a worker, starting the next queued function with it instead if there is one.
+func (p *workerPool) done() {
+	for {
+		p.mu.Lock()
+		if len(p.waiting) == 0 {
+			p.running--
+			p.mu.Unlock()
+			return
+		}
+		t := p.waiting[0]
+		p.waiting[0] = poolTask{}
+		p.waiting = p.waiting[1:]
+		p.mu.Unlock()
+		if p.start(t) {
+			return
+		}
+		t.reject()
+	}
+}
+
+// serverWorkers returns the server's worker pool, creating it on first use.
+func (srv *Server) serverWorkers() *workerPool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.workers == nil {
+		srv.workers = newWorkerPool(srv.MaxWorkers, srv.WorkerQueueLimit, nil)
+	}
+	return srv.workers
+}
+
+// executeLocked runs run, which executes the operation that r was started for with startRequestLocked,
+// on the connection's worker pool. It must be called with c.mu held.
+// If the pool's queue is full, r is returned to READY, and errBusy is returned to be reported to the client.
+// If the operation is refused once it has been queued, or the connection has been closed while it waited,
+// fail is called with the error instead of run.
+func (c *serverConn) executeLocked(ctx context.Context, r *request, cancel func(), run func(), fail func(err error)) error {
+	c.ops.Add(1)
+	ok := c.workers.submit(func() {
+		defer c.ops.Done()
+		if err := ctx.Err(); err != nil {
+			fail(err)
+			return
+		}
+		run()
+	}, func() {
+		defer c.ops.Done()
+		fail(errBusy)
+	})
+	if !ok {
+		c.ops.Done()
+		r.status = READY
+		r.cancel = nil
+		cancel()
+		return errBusy
+	}
+	return nil
+}
+
+// failExecution reports the failure of an execution of request r that was never run, as its response would have.
+func (c *serverConn) failExecution(ctx context.Context, s sender, command pvdata.PVByte, id pvdata.PVInt, subcommand pvdata.PVByte, r *request, destroy bool, err error) {
+	if !c.finishRequest(ctx, id, r, destroy) {
+		return
+	}
+	ctxlog.L(ctx).Warnf("%s not run: %v", proto.DescribeCommand(command, byte(subcommand)), err)
+	if err := s.SendApp(ctx, command, &proto.ChannelResponseError{
+		RequestID:  id,
+		Subcommand: subcommand,
+		Status:     c.errorToStatus(err),
+	}); err != nil {
+		ctxlog.L(ctx).Errorf("sending response: %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+package pvaccess
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Lexcelon/go-pvaccess/proto"
+	"github.com/Lexcelon/go-pvaccess/pvdata"
+)
+
+func TestWorkerPool(t *testing.T) {
+	server := newWorkerPool(2, 1, nil)
+	conn := newWorkerPool(1, 2, server)
+	other := newWorkerPool(2, -1, server)
+	if newWorkerPool(0, 1, server) != server {
+		t.Error("pool without a limit isn't its parent")
+	}
+
+	var mu sync.Mutex
+	var ran, rejected []string
+	// running counts the functions running from each pool, and most the most that ran at once.
+	running := make(map[*workerPool]int)
+	most := make(map[*workerPool]int)
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	submit := func(p *workerPool, name string) bool {
+		wg.Add(1)
+		ok := p.submit(func() {
+			defer wg.Done()
+			mu.Lock()
+			for _, q := range []*workerPool{p, server} {
+				if running[q]++; running[q] > most[q] {
+					most[q] = running[q]
+				}
+			}
+			mu.Unlock()
+			<-release
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			running[p]--
+			running[server]--
+			ran = append(ran, name)
+			mu.Unlock()
+		}, func() {
+			defer wg.Done()
+			mu.Lock()
+			rejected = append(rejected, name)
+			mu.Unlock()
+		})
+		if !ok {
+			wg.Done()
+		}
+		return ok
+	}
+
+	// conn runs one function at once, and queues two more.
+	for _, name := range []string{"conn1", "conn2", "conn3"} {
+		if !submit(conn, name) {
+			t.Fatalf("%s rejected", name)
+		}
+	}
+	if submit(conn, "conn4") {
+		t.Error("conn4 queued beyond the connection's queue")
+	}
+	// other takes the server's second worker and fills its queue, and queues nothing itself.
+	if !submit(other, "other1") || !submit(other, "other2") {
+		t.Fatal("other rejected")
+	}
+	if submit(other, "other3") {
+		t.Error("other3 queued beyond other's queue")
+	}
+	close(release)
+	wg.Wait()
+	if len(ran) != 5 || len(rejected) != 0 {
+		t.Errorf("ran %v and rejected %v, want five run and none rejected", ran, rejected)
+	}
+	if most[server] != 2 || most[conn] != 1 {
+		t.Errorf("at most %d ran on the server and %d on conn, want 2 and 1", most[server], most[conn])
+	}
+	// The workers are freed once the functions have returned.
+	waitIdle(t, server, conn, other)
+}
+
+// waitIdle waits for every worker of pools to be freed.
+func waitIdle(t *testing.T, pools ...*workerPool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for _, p := range pools {
+		for {
+			p.mu.Lock()
+			running := p.running
+			p.mu.Unlock()
+			if running == 0 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%d workers still running", running)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func TestMaxWorkers(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv := &Server{MaxWorkers: 1, WorkerQueueLimit: -1}
+	ch := &blockingRPC{started: make(chan struct{}), cancelled: make(chan struct{})}
+	srv.AddChannelProvider(ch)
+	tc := newTestClient(ctx, t, srv)
+	sid := tc.createChannel(ctx, 1, "rpc")
+
+	for _, id := range []pvdata.PVInt{5, 6} {
+		tc.send(ctx, proto.APP_CHANNEL_RPC, &proto.ChannelRPCRequest{
+			ServerChannelID: sid,
+			RequestID:       id,
+			Subcommand:      proto.CHANNEL_RPC_INIT,
+			PVRequest:       pvdata.NewPVAny(&struct{}{}),
+		})
+		var init proto.ChannelRPCResponseInit
+		tc.expect(ctx, proto.APP_CHANNEL_RPC, &init)
+		if init.Status.Type != pvdata.PVStatus_OK {
+			t.Fatalf("RPC init failed: %v", init.Status)
+		}
+	}
+	exec := func(id pvdata.PVInt) {
+		tc.send(ctx, proto.APP_CHANNEL_RPC, &proto.ChannelRPCRequest{
+			ServerChannelID: sid,
+			RequestID:       id,
+			PVRequest:       pvdata.NewPVAny(&struct{}{}),
+		})
+	}
+	exec(5)
+	<-ch.started
+
+	// The only worker is busy, so the second RPC is rejected rather than queued.
+	exec(6)
+	var rejected proto.ChannelRPCResponseInit
+	tc.expect(ctx, proto.APP_CHANNEL_RPC, &rejected)
+	if rejected.RequestID != 6 || rejected.Status.Type != pvdata.PVStatus_ERROR {
+		t.Fatalf("RPC on a busy server = %+v, want an error for request 6", rejected)
+	}
+
+	// Once the first RPC has finished, the rejected request can be executed.
+	tc.send(ctx, proto.APP_REQUEST_CANCEL, &proto.CancelDestroyRequest{ServerChannelID: sid, RequestID: 5})
+	select {
+	case <-ch.cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("provider context not cancelled")
+	}
+	waitIdle(t, srv.workers)
+	exec(6)
+	var resp proto.ChannelRPCResponse
+	tc.expect(ctx, proto.APP_CHANNEL_RPC, &resp)
+	if resp.RequestID != 6 || resp.Status.Type != pvdata.PVStatus_OK {
+		t.Errorf("RPC once the worker is free = %+v, want success for request 6", resp)
+	}
+}